@@ -0,0 +1,238 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"dito/app"
+	"dito/audit"
+	"dito/config"
+	cmid "dito/middlewares"
+	"dito/quota"
+	"dito/writer"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handler is the administrative API of a Dito instance. It is served on its own address,
+// separate from the proxy port, and protected by the bearer token configured in admin.token.
+type Handler struct {
+	dito *app.Dito
+	mux  *http.ServeMux
+}
+
+// NewHandler creates the admin API handler and registers its endpoints.
+//
+// Parameters:
+// - dito: The Dito application instance.
+//
+// Returns:
+// - *Handler: The admin API handler.
+func NewHandler(dito *app.Dito) *Handler {
+	h := &Handler{dito: dito, mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /locations", h.locations)
+	h.mux.HandleFunc("GET /transports", h.transports)
+	h.mux.HandleFunc("POST /dns/flush", h.flushDNS)
+	h.mux.HandleFunc("GET /quotas", h.getQuota)
+	h.mux.HandleFunc("DELETE /quotas", h.resetQuota)
+	h.mux.HandleFunc("GET /log/level", h.getLogLevel)
+	h.mux.HandleFunc("PUT /log/level", h.setLogLevel)
+	h.mux.Handle("/debug/pprof/", h.profiling(http.HandlerFunc(pprof.Index)))
+	h.mux.Handle("/debug/pprof/cmdline", h.profiling(http.HandlerFunc(pprof.Cmdline)))
+	h.mux.Handle("/debug/pprof/profile", h.profiling(http.HandlerFunc(pprof.Profile)))
+	h.mux.Handle("/debug/pprof/symbol", h.profiling(http.HandlerFunc(pprof.Symbol)))
+	h.mux.Handle("/debug/pprof/trace", h.profiling(http.HandlerFunc(pprof.Trace)))
+	return h
+}
+
+// ServeHTTP authenticates the request and dispatches it to the matching endpoint.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		h.dito.Logger.Warn(fmt.Sprintf("[Admin] Unauthorized request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr))
+		h.audit(r, audit.EventAuthFailure, map[string]string{"component": "admin", "method": r.Method, "path": r.URL.Path})
+		w.Header().Set("WWW-Authenticate", `Bearer realm="dito-admin"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	rw := &writer.ResponseWriter{ResponseWriter: w, Passthrough: true}
+	h.mux.ServeHTTP(rw, r)
+	h.audit(r, audit.EventAdminCall, map[string]string{"method": r.Method, "path": r.URL.Path, "status": strconv.Itoa(rw.StatusCode)})
+}
+
+// audit records an audit event for an admin API request.
+func (h *Handler) audit(r *http.Request, eventType string, details map[string]string) {
+	actor, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		actor = r.RemoteAddr
+	}
+	audit.Record(audit.Event{
+		Type:      eventType,
+		Actor:     actor,
+		RequestID: r.Header.Get(cmid.RequestIDHeader),
+		Details:   details,
+	})
+}
+
+// authorized checks the bearer token of the request against the configured admin token.
+func (h *Handler) authorized(r *http.Request) bool {
+	token := h.dito.GetCurrentConfig().Admin.Token
+	if token == "" {
+		return true
+	}
+	provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// profiling serves a pprof endpoint while profiling is enabled, so that it follows configuration reloads. The
+// endpoints are never served without an admin token.
+func (h *Handler) profiling(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminConfig := h.dito.GetCurrentConfig().Admin; !adminConfig.Profiling.Enabled || adminConfig.Token == "" {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "profiling is disabled"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// locationInfo describes a location in the admin API.
+type locationInfo struct {
+	Name        string   `json:"name"`
+	Path        string   `json:"path"`
+	TargetURL   string   `json:"target_url"`
+	Methods     []string `json:"methods,omitempty"`
+	Priority    int      `json:"priority"`
+	Middlewares []string `json:"middlewares,omitempty"`
+}
+
+// locations lists the locations of the current configuration, in configuration order, by their label.
+func (h *Handler) locations(w http.ResponseWriter, r *http.Request) {
+	locations := h.dito.GetCurrentConfig().Locations
+	infos := make([]locationInfo, len(locations))
+	for i, location := range locations {
+		infos[i] = locationInfo{
+			Name:        location.Label(),
+			Path:        location.Path,
+			TargetURL:   location.TargetURL,
+			Methods:     location.Methods,
+			Priority:    location.Priority,
+			Middlewares: location.Middlewares,
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"locations": infos})
+}
+
+// transports reports the number of cached upstream transports and their connection pool statistics.
+func (h *Handler) transports(w http.ResponseWriter, r *http.Request) {
+	stats := h.dito.TransportCache.Stats()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"cached_transports": len(stats),
+		"transports":        stats,
+	})
+}
+
+// flushDNS empties the upstream DNS cache.
+func (h *Handler) flushDNS(w http.ResponseWriter, r *http.Request) {
+	flushed := h.dito.DNSCache.Flush()
+	h.dito.Logger.Info(fmt.Sprintf("[Admin] Flushed %d DNS cache entries", flushed))
+	writeJSON(w, http.StatusOK, map[string]int{"flushed": flushed})
+}
+
+// getQuota reports the usage of a quota by an API key, given as the name and key query parameters.
+func (h *Handler) getQuota(w http.ResponseWriter, r *http.Request) {
+	quotaConfig, apiKey, ok := h.quotaRequest(w, r)
+	if !ok {
+		return
+	}
+	usage, err := quota.Get(r.Context(), h.dito.RedisClient, quotaConfig, apiKey, time.Now())
+	if err != nil {
+		h.dito.Logger.Error(fmt.Sprintf("[Admin] %v", err))
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
+
+// resetQuota clears the usage of a quota by an API key in the current window.
+func (h *Handler) resetQuota(w http.ResponseWriter, r *http.Request) {
+	quotaConfig, apiKey, ok := h.quotaRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := quota.Reset(r.Context(), h.dito.RedisClient, quotaConfig, apiKey, time.Now()); err != nil {
+		h.dito.Logger.Error(fmt.Sprintf("[Admin] %v", err))
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	h.dito.Logger.Info(fmt.Sprintf("[Admin] Reset quota %s of an API key", quotaConfig.Name))
+	writeJSON(w, http.StatusOK, map[string]string{"reset": quotaConfig.Name})
+}
+
+// getLogLevel reports the current log level.
+func (h *Handler) getLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"level": strings.ToLower(h.dito.LogLevel().String())})
+}
+
+// setLogLevel changes the log level at runtime, optionally for a limited duration, from a JSON body
+// such as {"level": "debug", "duration": "10m"}.
+func (h *Handler) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Level    string `json:"level"`
+		Duration string `json:"duration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+		return
+	}
+	var duration time.Duration
+	if body.Duration != "" {
+		var err error
+		if duration, err = time.ParseDuration(body.Duration); err != nil || duration < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid duration"})
+			return
+		}
+	}
+	if err := h.dito.SetLogLevel(body.Level, duration); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	level := strings.ToLower(h.dito.LogLevel().String())
+	if duration > 0 {
+		h.dito.Logger.Warn(fmt.Sprintf("[Admin] Log level set to %s for %s", level, duration))
+	} else {
+		h.dito.Logger.Warn(fmt.Sprintf("[Admin] Log level set to %s", level))
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"level": level})
+}
+
+// quotaRequest resolves the quota and API key of a quota request, writing the error response if they are invalid.
+func (h *Handler) quotaRequest(w http.ResponseWriter, r *http.Request) (config.Quota, string, bool) {
+	if h.dito.RedisClient == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "quotas require redis"})
+		return config.Quota{}, "", false
+	}
+	name, apiKey := r.URL.Query().Get("name"), r.URL.Query().Get("key")
+	if name == "" || apiKey == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name and key are required"})
+		return config.Quota{}, "", false
+	}
+	quotaConfig, ok := quota.Find(h.dito.GetCurrentConfig().Locations, name)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown quota"})
+		return config.Quota{}, "", false
+	}
+	return quotaConfig, apiKey, true
+}
+
+// writeJSON writes the value as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, statusCode int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(value)
+}
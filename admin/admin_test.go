@@ -0,0 +1,168 @@
+package admin_test
+
+import (
+	"context"
+	"dito/admin"
+	"dito/app"
+	"dito/config"
+	"dito/logging"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setupDito creates a Dito instance whose admin API is protected by the given token.
+func setupDito(token string) *app.Dito {
+	config.UpdateConfig(&config.ProxyConfig{
+		Logging: config.Logging{Level: "error"},
+		Admin:   config.AdminConfig{Enabled: true, Token: token},
+	})
+	return app.NewDito(nil, &config.HTTPTransportConfig{}, logging.InitializeLogger("error"))
+}
+
+// TestAuthentication tests that the admin API requires the configured bearer token.
+func TestAuthentication(t *testing.T) {
+	handler := admin.NewHandler(setupDito("secret"))
+
+	tests := []struct {
+		name          string
+		authorization string
+		expected      int
+	}{
+		{"missing token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", http.StatusUnauthorized},
+		{"wrong scheme", "Basic secret", http.StatusUnauthorized},
+		{"valid token", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/transports", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.expected, rec.Code)
+		})
+	}
+}
+
+// TestLocations tests that the locations are listed by their name, or by their path when they have none.
+func TestLocations(t *testing.T) {
+	dito := setupDito("")
+	dito.Config.Locations = []config.LocationConfig{
+		{Name: "api", Path: "^/api/", TargetURL: "http://api:8080", Priority: 5, Middlewares: []string{"auth"}},
+		{Path: "^/", TargetURL: "http://frontend:8080"},
+	}
+
+	rec := httptest.NewRecorder()
+	admin.NewHandler(dito).ServeHTTP(rec, httptest.NewRequest("GET", "/locations", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"locations": [
+		{"name": "api", "path": "^/api/", "target_url": "http://api:8080", "priority": 5, "middlewares": ["auth"]},
+		{"name": "^/", "path": "^/", "target_url": "http://frontend:8080", "priority": 0}
+	]}`, rec.Body.String())
+}
+
+// TestTransports tests that the transport cache statistics are reported.
+func TestTransports(t *testing.T) {
+	dito := setupDito("")
+	_, err := dito.TransportCache.GetTransport(&config.LocationConfig{Path: "/"}, config.HTTPTransportConfig{})
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	admin.NewHandler(dito).ServeHTTP(rec, httptest.NewRequest("GET", "/transports", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var body struct {
+		CachedTransports int `json:"cached_transports"`
+		Transports       []struct {
+			Key             string `json:"key"`
+			OpenConnections int64  `json:"open_connections"`
+		} `json:"transports"`
+	}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, 1, body.CachedTransports)
+	assert.Len(t, body.Transports, 1)
+	assert.Len(t, body.Transports[0].Key, 12)
+}
+
+// TestFlushDNS tests that the DNS cache can be flushed and that the endpoint only accepts POST.
+func TestFlushDNS(t *testing.T) {
+	handler := admin.NewHandler(setupDito(""))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/dns/flush", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"flushed":0}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/dns/flush", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+// TestQuotasRequireRedis tests that the quota endpoints report that Redis is not available.
+func TestQuotasRequireRedis(t *testing.T) {
+	handler := admin.NewHandler(setupDito(""))
+
+	for _, method := range []string{"GET", "DELETE"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, "/quotas?name=partners&key=abc", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+// TestProfiling tests that the pprof endpoints follow the profiling setting of the current configuration, and
+// require the admin token.
+func TestProfiling(t *testing.T) {
+	dito := setupDito("secret")
+	handler := admin.NewHandler(dito)
+
+	get := func(authorization string) int {
+		req := httptest.NewRequest("GET", "/debug/pprof/goroutine?debug=1", nil)
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusNotFound, get("Bearer secret"))
+
+	enabled := *dito.GetCurrentConfig()
+	enabled.Admin.Profiling.Enabled = true
+	dito.UpdateConfig(&enabled)
+	assert.Equal(t, http.StatusUnauthorized, get(""))
+	assert.Equal(t, http.StatusOK, get("Bearer secret"))
+
+	// Without a token, the profiles are never served.
+	enabled.Admin.Token = ""
+	dito.UpdateConfig(&enabled)
+	assert.Equal(t, http.StatusNotFound, get(""))
+}
+
+// TestLogLevel tests that the log level can be read and changed at runtime.
+func TestLogLevel(t *testing.T) {
+	dito := setupDito("")
+	handler := admin.NewHandler(dito)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/log/level", nil))
+	assert.JSONEq(t, `{"level": "error"}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("PUT", "/log/level", strings.NewReader(`{"level": "debug"}`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, dito.Logger.Enabled(context.Background(), slog.LevelDebug))
+
+	for _, body := range []string{`{"level": "verbose"}`, `{"level": "info", "duration": "soon"}`, `not json`} {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("PUT", "/log/level", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+}
@@ -1,22 +1,39 @@
 package app
 
 import (
+	"dito/audit"
 	credis "dito/client/redis"
 	"dito/config"
+	"dito/dnscache"
 	"dito/logging"
+	"dito/profiling"
+	"dito/ratelimit"
+	"dito/router"
+	"dito/spool"
+	"dito/transform"
 	"dito/transport"
+	"fmt"
 	"github.com/redis/go-redis/v9"
 	"log/slog"
+	"strconv"
 	"sync"
+	"time"
 )
 
 // Dito is the main application structure that holds the configuration, Redis client, logger, and transport cache.
 type Dito struct {
-	Config         *config.ProxyConfig       // Config is the current proxy configuration.
-	configMutex    sync.RWMutex              // configMutex is used to safely update the configuration.
-	RedisClient    *redis.Client             // RedisClient is the client instance for Redis operations.
-	Logger         *slog.Logger              // Logger is used for logging within the application.
-	TransportCache *transport.TransportCache // TransportCache is a cache for storing custom HTTP transports.
+	Config         *config.ProxyConfig         // Config is the current proxy configuration.
+	configMutex    sync.RWMutex                // configMutex is used to safely update the configuration.
+	RedisClient    *redis.Client               // RedisClient is the client instance for Redis operations.
+	Logger         *slog.Logger                // Logger is used for logging within the application.
+	TransportCache *transport.TransportCache   // TransportCache is a cache for storing custom HTTP transports.
+	DNSCache       *dnscache.Resolver          // DNSCache caches the DNS lookups of upstream hosts.
+	router         *router.Router              // router matches requests to the configured locations.
+	transforms     [][]transform.BodyTransform // transforms holds the response transforms of each location, by index.
+	rateLimiters   *ratelimit.Manager          // rateLimiters holds the in-memory rate limiters of the current configuration.
+	Profiler       *profiling.Profiler         // Profiler applies the runtime profiling settings and exports profiles.
+	logLevelMutex  sync.Mutex                  // logLevelMutex guards the runtime changes of the log level.
+	logLevelReset  *time.Timer                 // logLevelReset restores the configured log level after a temporary change.
 }
 
 // NewDito creates a new instance of the Dito application.
@@ -29,11 +46,23 @@ type Dito struct {
 // Returns:
 // - *Dito: A pointer to the newly created Dito application instance.
 func NewDito(redisClient *redis.Client, transportConfig *config.HTTPTransportConfig, logger *slog.Logger) *Dito {
+	proxyConfig := config.GetCurrentProxyConfig()
+	spool.SetDiskBudget(proxyConfig.Buffering.DiskBudget)
+	dnsCache := dnscache.New(proxyConfig.DNS)
+	transportCache := transport.NewTransportCache(*transportConfig)
+	transportCache.SetResolver(dnsCache)
+	profiler := profiling.NewProfiler(logger)
+	profiler.Configure(proxyConfig.Admin.Profiling)
 	return &Dito{
-		Config:         config.GetCurrentProxyConfig(),
+		Config:         proxyConfig,
 		RedisClient:    redisClient,
 		Logger:         logger,
-		TransportCache: transport.NewTransportCache(*transportConfig),
+		TransportCache: transportCache,
+		DNSCache:       dnsCache,
+		router:         router.New(proxyConfig.Locations, proxyConfig.Routing.Order),
+		transforms:     buildTransforms(proxyConfig.Locations, logger),
+		rateLimiters:   ratelimit.NewManager(),
+		Profiler:       profiler,
 	}
 }
 
@@ -44,9 +73,40 @@ func NewDito(redisClient *redis.Client, transportConfig *config.HTTPTransportCon
 func (d *Dito) UpdateConfig(newConfig *config.ProxyConfig) {
 	d.configMutex.Lock()
 	d.Config = newConfig
-	d.TransportCache.Clear()
+	d.router = router.New(newConfig.Locations, newConfig.Routing.Order)
+	d.transforms = buildTransforms(newConfig.Locations, d.Logger)
+	d.replaceRateLimiters()
+	d.DNSCache.Configure(newConfig.DNS)
+	spool.SetDiskBudget(newConfig.Buffering.DiskBudget)
+	d.Profiler.Configure(newConfig.Admin.Profiling)
+	removed := d.TransportCache.Retain(transportConfigs(newConfig))
 	d.configMutex.Unlock()
 	d.Logger.Warn("Configuration updated in Dito")
+	audit.Record(audit.Event{
+		Type:    audit.EventConfigReload,
+		Actor:   audit.ActorSystem,
+		Details: map[string]string{"locations": strconv.Itoa(len(newConfig.Locations))},
+	})
+	if removed > 0 {
+		d.Logger.Info(fmt.Sprintf("Discarded %d transports no longer matching the configuration", removed))
+	}
+}
+
+// transportConfigs returns the global transport configuration and those of all locations.
+//
+// Parameters:
+// - proxyConfig: The proxy configuration.
+//
+// Returns:
+// - []config.HTTPTransportConfig: The transport configurations in use.
+func transportConfigs(proxyConfig *config.ProxyConfig) []config.HTTPTransportConfig {
+	configs := []config.HTTPTransportConfig{proxyConfig.Transport.HTTP}
+	for _, location := range proxyConfig.Locations {
+		if location.Transport != nil {
+			configs = append(configs, location.Transport.HTTP)
+		}
+	}
+	return configs
 }
 
 // GetCurrentConfig retrieves the current proxy configuration of the Dito application.
@@ -70,7 +130,11 @@ func (d *Dito) UpdateComponents(newConfig *config.ProxyConfig) {
 
 	// Update the logger if the logging level has changed.
 	if newConfig.Logging.Level != d.Config.Logging.Level {
-		d.Logger = logging.InitializeLogger(newConfig.Logging.Level)
+		if levelVar := logging.Level(d.Logger); levelVar != nil {
+			d.applyLogLevel(levelVar, newConfig.Logging.Level, 0)
+		} else {
+			d.Logger = logging.InitializeLogger(newConfig.Logging.Level)
+		}
 	}
 
 	// Update the Redis client if the Redis configuration has changed.
@@ -85,6 +149,128 @@ func (d *Dito) UpdateComponents(newConfig *config.ProxyConfig) {
 		}
 	}
 
-	// Update the configuration.
+	// Update the configuration and rebuild the router and the response transforms.
 	d.Config = newConfig
+	d.router = router.New(newConfig.Locations, newConfig.Routing.Order)
+	d.transforms = buildTransforms(newConfig.Locations, d.Logger)
+}
+
+// buildTransforms builds the response transforms of every location, once per configuration load.
+//
+// Parameters:
+// - locations: The locations of the configuration.
+// - logger: The logger reporting the transforms that cannot be built.
+//
+// Returns:
+// - [][]transform.BodyTransform: The transforms of each location, indexed like the locations.
+func buildTransforms(locations []config.LocationConfig, logger *slog.Logger) [][]transform.BodyTransform {
+	transforms := make([][]transform.BodyTransform, len(locations))
+	for i, location := range locations {
+		built, err := transform.Build(location.ResponseTransforms)
+		if err != nil {
+			// The transforms are validated when the configuration is loaded, so this is not expected.
+			logger.Error(fmt.Sprintf("Failed to build the response transforms of %s: %v", location.Label(), err))
+			continue
+		}
+		transforms[i] = built
+	}
+	return transforms
+}
+
+// ResponseTransforms returns the response transforms of a location of the current configuration.
+//
+// Parameters:
+// - index: The index of the location in the configuration.
+//
+// Returns:
+// - []transform.BodyTransform: The transforms, in the order they must be applied.
+func (d *Dito) ResponseTransforms(index int) []transform.BodyTransform {
+	d.configMutex.RLock()
+	defer d.configMutex.RUnlock()
+	if index < 0 || index >= len(d.transforms) {
+		return nil
+	}
+	return d.transforms[index]
+}
+
+// RateLimiters returns the in-memory rate limiters of the current configuration.
+//
+// Returns:
+// - *ratelimit.Manager: The rate limiter manager.
+func (d *Dito) RateLimiters() *ratelimit.Manager {
+	d.configMutex.RLock()
+	defer d.configMutex.RUnlock()
+	return d.rateLimiters
+}
+
+// replaceRateLimiters starts from fresh rate limiters for a new configuration, and stops the previous ones.
+// It must be called with configMutex held.
+func (d *Dito) replaceRateLimiters() {
+	if d.rateLimiters != nil {
+		d.rateLimiters.Stop()
+	}
+	d.rateLimiters = ratelimit.NewManager()
+}
+
+// LogLevel returns the current level of the logger.
+//
+// Returns:
+// - slog.Level: The log level.
+func (d *Dito) LogLevel() slog.Level {
+	if levelVar := logging.Level(d.Logger); levelVar != nil {
+		return levelVar.Level()
+	}
+	return slog.LevelInfo
+}
+
+// SetLogLevel changes the level of the logger at runtime, without reloading the configuration.
+//
+// Parameters:
+// - level: The level name (debug, info, warn, or error).
+// - duration: How long the level applies before the configured level is restored (0 keeps it until the next change).
+//
+// Returns:
+// - error: An error if the level is unknown or the logger level cannot be changed.
+func (d *Dito) SetLogLevel(level string, duration time.Duration) error {
+	levelVar := logging.Level(d.Logger)
+	if levelVar == nil {
+		return fmt.Errorf("the logger level cannot be changed at runtime")
+	}
+	if _, err := logging.ParseLevel(level); err != nil {
+		return err
+	}
+	d.applyLogLevel(levelVar, level, duration)
+	return nil
+}
+
+// applyLogLevel sets the level of the logger and schedules the restoration of the configured level.
+func (d *Dito) applyLogLevel(levelVar *slog.LevelVar, level string, duration time.Duration) {
+	logLevel, err := logging.ParseLevel(level)
+	if err != nil {
+		logLevel = slog.LevelInfo
+	}
+
+	d.logLevelMutex.Lock()
+	defer d.logLevelMutex.Unlock()
+	if d.logLevelReset != nil {
+		d.logLevelReset.Stop()
+		d.logLevelReset = nil
+	}
+	levelVar.Set(logLevel)
+	if duration > 0 {
+		d.logLevelReset = time.AfterFunc(duration, func() {
+			d.applyLogLevel(levelVar, d.GetCurrentConfig().Logging.Level, 0)
+			d.Logger.Info(fmt.Sprintf("Log level restored to %s", levelVar.Level()))
+		})
+	}
+}
+
+// Router returns the router built from the current configuration.
+//
+// Returns:
+// - *router.Router: The router matching requests to locations.
+func (d *Dito) Router() *router.Router {
+	d.configMutex.RLock()
+	defer d.configMutex.RUnlock()
+	return d.router
 }
@@ -6,6 +6,7 @@ import (
 	"dito/logging"
 	"log/slog"
 	"testing"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -52,3 +53,52 @@ func TestLoggerUpdate(t *testing.T) {
 	dito.Logger.Debug("This should be visible at debug level")
 	dito.Logger.Info("This should always be visible")
 }
+
+// TestTemporaryLogLevel tests that a temporary log level change reverts to the configured level.
+func TestTemporaryLogLevel(t *testing.T) {
+	config.UpdateConfig(&config.ProxyConfig{Logging: config.Logging{Level: "warn"}})
+	dito := NewDito(nil, &config.HTTPTransportConfig{}, logging.InitializeLogger("warn"))
+
+	if err := dito.SetLogLevel("debug", 20*time.Millisecond); err != nil {
+		t.Fatalf("Expected the log level to change, got %v", err)
+	}
+	if dito.LogLevel() != slog.LevelDebug {
+		t.Errorf("Expected the debug level, got %s", dito.LogLevel())
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for dito.LogLevel() != slog.LevelWarn && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if dito.LogLevel() != slog.LevelWarn {
+		t.Errorf("Expected the configured warn level to be restored, got %s", dito.LogLevel())
+	}
+
+	if err := dito.SetLogLevel("verbose", 0); err == nil {
+		t.Error("Expected an unknown level to be rejected")
+	}
+}
+
+// TestResponseTransforms tests that the response transforms are built once per configuration, and rebuilt when
+// the configuration is updated.
+func TestResponseTransforms(t *testing.T) {
+	transforms := []config.TransformConfig{{Name: "append", Options: map[string]string{"text": "\n"}}}
+	config.UpdateConfig(&config.ProxyConfig{Locations: []config.LocationConfig{{Path: "^/", ResponseTransforms: transforms}}})
+	dito := NewDito(nil, &config.HTTPTransportConfig{}, logging.InitializeLogger("warn"))
+
+	built := dito.ResponseTransforms(0)
+	if len(built) != 1 {
+		t.Fatalf("Expected 1 response transform, got %d", len(built))
+	}
+	if again := dito.ResponseTransforms(0); &again[0] != &built[0] {
+		t.Error("Expected the response transforms to be reused across requests")
+	}
+	if dito.ResponseTransforms(1) != nil {
+		t.Error("Expected no response transforms for an unknown location")
+	}
+
+	dito.UpdateConfig(&config.ProxyConfig{Locations: []config.LocationConfig{{Path: "^/"}}})
+	if len(dito.ResponseTransforms(0)) != 0 {
+		t.Error("Expected the response transforms to be rebuilt with the new configuration")
+	}
+}
@@ -0,0 +1,290 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"dito/config"
+	"dito/metrics"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types recorded in the audit log.
+const (
+	EventConfigReload   = "config_reload"    // The configuration was reloaded.
+	EventAuthFailure    = "auth_failure"     // A request failed authentication.
+	EventRateLimitBlock = "rate_limit_block" // A request was rejected by a rate limiter.
+	EventSecurityBlock  = "security_block"   // A request was rejected by a security check.
+	EventAdminCall      = "admin_call"       // The admin API was called.
+)
+
+// ActorSystem is the actor of events triggered by the proxy itself rather than by a client.
+const ActorSystem = "system"
+
+// queueSize is the number of events waiting to be written; events recorded while the queue is full are dropped.
+const queueSize = 4096
+
+// Event is a single audit log entry.
+//
+// Entries form a hash chain: Hash covers the entry content and the Hash of the previous entry,
+// so that removing, reordering, or editing a line breaks the chain from that point on. When an
+// HMAC key is configured, the hashes cannot be recomputed without it.
+type Event struct {
+	Seq       uint64            `json:"seq"`                  // Seq is the position of the entry in the log.
+	Time      time.Time         `json:"time"`                 // Time is when the event occurred.
+	Type      string            `json:"type"`                 // Type is one of the Event* constants.
+	Actor     string            `json:"actor"`                // Actor is the client IP, or ActorSystem.
+	RequestID string            `json:"request_id,omitempty"` // RequestID identifies the request that triggered the event.
+	Details   map[string]string `json:"details,omitempty"`    // Details holds event specific information.
+	PrevHash  string            `json:"prev_hash"`            // PrevHash is the Hash of the previous entry.
+	Hash      string            `json:"hash"`                 // Hash is the chained hash of this entry.
+}
+
+// Logger appends hash-chained events to an audit file, separate from the access logs. Events recorded with
+// Enqueue are written by a background goroutine, so that requests never wait for the disk.
+type Logger struct {
+	mu       sync.Mutex
+	file     *os.File
+	key      []byte
+	seq      uint64
+	prevHash string
+
+	queueMu sync.RWMutex  // queueMu guards the queue against a send racing with Close.
+	queue   chan Event    // queue holds the events waiting to be written.
+	closed  bool          // closed is set by Close; later events are dropped.
+	written chan struct{} // written is closed once the queued events are written.
+	dropped atomic.Uint64 // dropped counts the events dropped because the queue was full.
+}
+
+// defaultLogger is the logger used by Record; nil when auditing is disabled.
+var defaultLogger atomic.Pointer[Logger]
+
+// Open opens (or creates) the audit file and resumes its hash chain.
+//
+// Parameters:
+// - auditConfig: The audit log configuration.
+//
+// Returns:
+// - *Logger: The audit logger.
+// - error: An error if the file cannot be opened or its last entry cannot be read.
+func Open(auditConfig config.AuditConfig) (*Logger, error) {
+	file, err := os.OpenFile(auditConfig.File, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+
+	l := &Logger{file: file, key: []byte(auditConfig.HMACKey), queue: make(chan Event, queueSize), written: make(chan struct{})}
+	last, err := lastEvent(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	if last != nil {
+		l.seq = last.Seq
+		l.prevHash = last.Hash
+	}
+	go l.writeQueued()
+	return l, nil
+}
+
+// SetDefault sets the logger used by Record. A nil logger disables auditing.
+//
+// Parameters:
+// - l: The audit logger.
+func SetDefault(l *Logger) {
+	defaultLogger.Store(l)
+}
+
+// Record queues an event for the default audit logger, if auditing is enabled.
+//
+// Parameters:
+// - event: The event to record. Seq, hashes, and (if zero) Time are filled in.
+func Record(event Event) {
+	if l := defaultLogger.Load(); l != nil {
+		l.Enqueue(event)
+	}
+}
+
+// Enqueue queues an event, written in the background. The event is dropped, and counted, if the queue is full.
+//
+// Parameters:
+// - event: The event to record. Seq, hashes, and (if zero) Time are filled in.
+//
+// Returns:
+// - bool: True if the event was queued.
+func (l *Logger) Enqueue(event Event) bool {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	l.queueMu.RLock()
+	defer l.queueMu.RUnlock()
+	if !l.closed {
+		select {
+		case l.queue <- event:
+			return true
+		default:
+		}
+	}
+	l.dropped.Add(1)
+	metrics.RecordAuditEventDropped()
+	return false
+}
+
+// Dropped returns the number of events dropped because the queue was full or the logger closed.
+func (l *Logger) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+// writeQueued writes the queued events until the queue is closed.
+func (l *Logger) writeQueued() {
+	defer close(l.written)
+	for event := range l.queue {
+		_ = l.Record(event)
+	}
+}
+
+// Record appends an event to the audit log, and returns once it is written.
+//
+// Parameters:
+// - event: The event to record. Seq, hashes, and (if zero) Time are filled in.
+//
+// Returns:
+// - error: An error if the entry cannot be written.
+func (l *Logger) Record(event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	event.Seq = l.seq + 1
+	event.PrevHash = l.prevHash
+	hash, err := chainHash(l.key, event)
+	if err != nil {
+		return err
+	}
+	event.Hash = hash
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.seq = event.Seq
+	l.prevHash = event.Hash
+	return nil
+}
+
+// Close writes the queued events and closes the audit file.
+func (l *Logger) Close() error {
+	l.queueMu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.queue)
+	}
+	l.queueMu.Unlock()
+	<-l.written
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Verify checks the hash chain of an audit log.
+//
+// Parameters:
+// - r: The audit log content.
+// - hmacKey: The HMAC key the log was written with (empty if none).
+//
+// Returns:
+// - int: The number of valid entries.
+// - error: An error describing the first entry breaking the chain.
+func Verify(r io.Reader, hmacKey string) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	count := 0
+	prevHash := ""
+	var prevSeq uint64
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return count, fmt.Errorf("entry %d: invalid JSON: %v", count+1, err)
+		}
+		if count == 0 && (event.Seq != 1 || event.PrevHash != "") {
+			return count, fmt.Errorf("entry %d: log does not start at the first entry", event.Seq)
+		}
+		if count > 0 && (event.PrevHash != prevHash || event.Seq != prevSeq+1) {
+			return count, fmt.Errorf("entry %d: chain broken", event.Seq)
+		}
+		expected, err := chainHash([]byte(hmacKey), event)
+		if err != nil {
+			return count, err
+		}
+		if !hmac.Equal([]byte(expected), []byte(event.Hash)) {
+			return count, fmt.Errorf("entry %d: hash mismatch", event.Seq)
+		}
+		prevHash, prevSeq = event.Hash, event.Seq
+		count++
+	}
+	return count, scanner.Err()
+}
+
+// chainHash computes the hash of an event, covering its content and the previous hash.
+func chainHash(key []byte, event Event) (string, error) {
+	event.Hash = ""
+	content, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+
+	var h hash.Hash
+	if len(key) > 0 {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lastEvent returns the last entry of the audit file, or nil if it is empty.
+func lastEvent(file *os.File) (*Event, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	var last []byte
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			last = append(last[:0], scanner.Bytes()...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, nil
+	}
+
+	var event Event
+	if err := json.Unmarshal(last, &event); err != nil {
+		return nil, errors.New("last entry is not valid JSON")
+	}
+	return &event, nil
+}
@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"bytes"
+	"dito/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeEvents records the given event types in the audit file and closes the logger.
+func writeEvents(t *testing.T, auditConfig config.AuditConfig, types ...string) {
+	l, err := Open(auditConfig)
+	assert.NoError(t, err)
+	for _, eventType := range types {
+		assert.NoError(t, l.Record(Event{Type: eventType, Actor: ActorSystem}))
+	}
+	assert.NoError(t, l.Close())
+}
+
+// TestChainResumesAcrossRestarts verifies that reopening the audit log continues the hash chain.
+func TestChainResumesAcrossRestarts(t *testing.T) {
+	auditConfig := config.AuditConfig{File: filepath.Join(t.TempDir(), "audit.log")}
+	writeEvents(t, auditConfig, EventConfigReload, EventAdminCall)
+	writeEvents(t, auditConfig, EventAuthFailure)
+
+	data, err := os.ReadFile(auditConfig.File)
+	assert.NoError(t, err)
+	count, err := Verify(bytes.NewReader(data), "")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+// TestVerifyDetectsTampering verifies that edited, removed, and truncated entries break the chain.
+func TestVerifyDetectsTampering(t *testing.T) {
+	auditConfig := config.AuditConfig{File: filepath.Join(t.TempDir(), "audit.log")}
+	writeEvents(t, auditConfig, EventConfigReload, EventRateLimitBlock, EventAdminCall)
+
+	data, err := os.ReadFile(auditConfig.File)
+	assert.NoError(t, err)
+	lines := strings.SplitAfter(string(data), "\n")
+
+	edited := strings.Replace(string(data), EventRateLimitBlock, EventSecurityBlock, 1)
+	count, err := Verify(strings.NewReader(edited), "")
+	assert.Error(t, err)
+	assert.Equal(t, 1, count)
+
+	removed := lines[0] + lines[2]
+	_, err = Verify(strings.NewReader(removed), "")
+	assert.Error(t, err)
+
+	truncated := lines[1] + lines[2]
+	_, err = Verify(strings.NewReader(truncated), "")
+	assert.Error(t, err)
+}
+
+// TestEnqueue verifies that queued events are written in order by Close, that events are dropped and counted
+// when the queue is full, and that events recorded after Close are dropped.
+func TestEnqueue(t *testing.T) {
+	auditConfig := config.AuditConfig{File: filepath.Join(t.TempDir(), "audit.log")}
+	l, err := Open(auditConfig)
+	assert.NoError(t, err)
+
+	// The writer waits for the file lock, so that the queue fills up.
+	l.mu.Lock()
+	for i := 0; i < queueSize+10; i++ {
+		l.Enqueue(Event{Type: EventAuthFailure, Actor: "192.0.2.1"})
+	}
+	l.mu.Unlock()
+	assert.GreaterOrEqual(t, l.Dropped(), uint64(9))
+
+	assert.NoError(t, l.Close())
+	assert.False(t, l.Enqueue(Event{Type: EventAdminCall, Actor: ActorSystem}))
+
+	data, err := os.ReadFile(auditConfig.File)
+	assert.NoError(t, err)
+	count, err := Verify(bytes.NewReader(data), "")
+	assert.NoError(t, err)
+	assert.Equal(t, queueSize+10, count+int(l.Dropped())-1)
+}
+
+// TestHMACKey verifies that a keyed log only verifies with the same key.
+func TestHMACKey(t *testing.T) {
+	auditConfig := config.AuditConfig{File: filepath.Join(t.TempDir(), "audit.log"), HMACKey: "secret"}
+	writeEvents(t, auditConfig, EventConfigReload, EventAdminCall)
+
+	data, err := os.ReadFile(auditConfig.File)
+	assert.NoError(t, err)
+
+	count, err := Verify(bytes.NewReader(data), "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	_, err = Verify(bytes.NewReader(data), "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,36 @@
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version and Commit identify the release. They are injected at build time:
+//
+//	go build -ldflags "-X dito/buildinfo.Version=v1.2.0 -X dito/buildinfo.Commit=$(git rev-parse --short HEAD)"
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// Info returns the version, the commit, and the Go version of the running binary. When the commit was not
+// injected, the VCS revision recorded by the Go toolchain is used instead.
+//
+// Returns:
+// - string: The version.
+// - string: The commit, or "unknown".
+// - string: The Go version the binary was built with.
+func Info() (string, string, string) {
+	commit := Commit
+	if commit == "" {
+		commit = "unknown"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" && setting.Value != "" {
+					commit = setting.Value
+				}
+			}
+		}
+	}
+	return Version, commit, runtime.Version()
+}
@@ -20,12 +20,7 @@ import (
 // Returns:
 // - *redis.Client: A pointer to the initialized Redis client, or nil if the connection fails.
 func InitRedis(logger *slog.Logger, redisConfig config.RedisConfig) (*redis.Client, error) {
-	addr := fmt.Sprintf("%s:%s", redisConfig.Host, redisConfig.Port)
-	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: redisConfig.Password,
-		DB:       0,
-	})
+	client := NewClient(redisConfig)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -39,6 +34,22 @@ func InitRedis(logger *slog.Logger, redisConfig config.RedisConfig) (*redis.Clie
 	return client, nil
 }
 
+// NewClient creates a Redis client without connecting to the server: connections are opened on first use,
+// so that the proxy can start while Redis is unavailable.
+//
+// Parameters:
+// - redisConfig: The Redis configuration containing host, port, and password.
+//
+// Returns:
+// - *redis.Client: A pointer to the Redis client.
+func NewClient(redisConfig config.RedisConfig) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%s", redisConfig.Host, redisConfig.Port),
+		Password: redisConfig.Password,
+		DB:       0,
+	})
+}
+
 // RedisHealthCheck performs a health check on the provided Redis client.
 // It pings the Redis server and logs a fatal error if the server is down.
 //
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"dito/openapi"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// importedLocation is a location generated from an imported API description.
+type importedLocation struct {
+	Path      string   `yaml:"path"`
+	Methods   []string `yaml:"methods"`
+	TargetURL string   `yaml:"target_url"`
+}
+
+// runCommand runs a subcommand given on the command line, e.g. "import openapi spec.yaml" or "replay".
+//
+// Parameters:
+// - args: The arguments following the global flags.
+//
+// Returns:
+// - error: An error if the command is unknown or fails.
+func runCommand(args []string) error {
+	if len(args) >= 2 && args[0] == "import" && args[1] == "openapi" {
+		return importOpenAPI(args[2:], os.Stdout)
+	}
+	if len(args) >= 1 && args[0] == "replay" {
+		return replay(args[1:], os.Stdout)
+	}
+	return fmt.Errorf("unknown command %q, expected: import openapi [-target-url URL] <spec>, or replay -target URL <recording>", strings.Join(args, " "))
+}
+
+// importOpenAPI prints the locations generated from an OpenAPI document, one per path, ready to be pasted
+// into the locations of the configuration.
+//
+// Parameters:
+// - args: The arguments of the command: the flags, then the path to the document.
+// - out: The writer receiving the generated YAML.
+//
+// Returns:
+// - error: An error if the arguments are invalid or the document cannot be loaded.
+func importOpenAPI(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("import openapi", flag.ContinueOnError)
+	targetURL := flags.String("target-url", "", "upstream URL of the locations, instead of the first server of the document")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: import openapi [-target-url URL] <spec>")
+	}
+
+	doc, err := openapi.Load(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %v", flags.Arg(0), err)
+	}
+	if *targetURL == "" {
+		if *targetURL, err = doc.ServerURL(); err != nil {
+			return err
+		}
+	}
+
+	var imported struct {
+		Locations []importedLocation `yaml:"locations"`
+	}
+	for _, route := range doc.Routes() {
+		imported.Locations = append(imported.Locations, importedLocation{Path: route.Pattern, Methods: route.Methods, TargetURL: *targetURL})
+	}
+	encoder := yaml.NewEncoder(out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(imported); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -2,23 +2,35 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"dito/admin"
 	"dito/app"
+	"dito/audit"
+	"dito/buildinfo"
 	credis "dito/client/redis"
 	"dito/config"
 	"dito/handlers"
+	"dito/listener"
 	"dito/logging"
 	"dito/metrics"
 	cmid "dito/middlewares"
+	"dito/startup"
+	"dito/stream"
+	"dito/systemd"
+	"dito/tlsutil"
+	"dito/transport"
+	"dito/websocket"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
-	_ "net/http/pprof"
 	"os"
 	"os/signal"
-	"runtime"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
@@ -28,12 +40,31 @@ import (
 // main is the entry point of the application.
 // It loads the configuration, initializes the logger and Redis client, and starts the HTTP server.
 func main() {
-	// Define a flag to enable the profiler
-	enableProfiler := flag.Bool("enable-profiler", false, "enable the profiler")
-
 	// Define a flag for the configuration file path
 	configFile := flag.String("f", "config.yaml", "path to the configuration file")
+
+	// Define flags overriding the startup policy of the configuration
+	failFast := flag.Bool("fail-fast", false, "stop the startup on the first failed startup check")
+	degradedStart := flag.Bool("degraded-start", false, "start with the affected features unavailable when a startup check fails")
+	showVersion := flag.Bool("version", false, "print the version and exit")
+
+	// Kept for existing deployments: the profiler is now configured with admin.profiling
+	enableProfiler := flag.Bool("enable-profiler", false, "deprecated: enable admin.profiling")
 	flag.Parse()
+	version, commit, goVersion := buildinfo.Info()
+	if *showVersion {
+		fmt.Printf("dito %s (commit %s, %s)\n", version, commit, goVersion)
+		return
+	}
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *failFast && *degradedStart {
+		log.Fatal("The -fail-fast and -degraded-start flags are mutually exclusive")
+	}
 
 	// Check if the configuration file exists
 	if _, err := os.Stat(*configFile); os.IsNotExist(err) {
@@ -41,20 +72,40 @@ func main() {
 	}
 
 	// Load and set the configuration
+	if *enableProfiler {
+		config.ForceProfiling()
+	}
 	config.LoadAndSetConfig(*configFile)
 	logger := logging.InitializeLogger(config.GetCurrentProxyConfig().Logging.Level)
+	logger.Info("Starting Dito", "version", version, "commit", commit, "go_version", goVersion)
+	if *enableProfiler {
+		logger.Warn("The -enable-profiler flag is deprecated, set admin.profiling.enabled instead: the pprof endpoints are served by the admin API, no longer on localhost:6060")
+		if !config.GetCurrentProxyConfig().Admin.Enabled {
+			logger.Warn("Profiling is enabled but the admin API is disabled: the pprof endpoints are not reachable")
+		}
+	}
 
 	// Initialize metrics system
 	metrics.InitMetrics()
 
 	var redisClient *redis.Client
 	if config.GetCurrentProxyConfig().Redis.Enabled {
-		// Initialize the Redis client
-		var err error
-		redisClient, err = credis.InitRedis(logger, config.GetCurrentProxyConfig().Redis)
-		if err != nil {
-			log.Fatal("Failed to initialize Redis client: ", err)
-		}
+		// Create the Redis client; it connects on first use, so that a degraded start can recover once Redis is up
+		redisClient = credis.NewClient(config.GetCurrentProxyConfig().Redis)
+	}
+
+	// Verify the dependencies before binding any listener
+	startupConfig := config.GetCurrentProxyConfig().Startup
+	policy := startupConfig.Policy
+	switch {
+	case *failFast:
+		policy = config.StartupPolicyFailFast
+	case *degradedStart:
+		policy = config.StartupPolicyDegraded
+	}
+	checks := startup.Checks(config.GetCurrentProxyConfig(), redisClient)
+	if err := startup.Run(checks, policy, startupConfig.Timeout, logger); err != nil {
+		log.Fatal(err)
 	}
 
 	transportConfig := &config.GetCurrentProxyConfig().Transport.HTTP
@@ -62,6 +113,30 @@ func main() {
 	// Create a new Dito instance
 	dito := app.NewDito(redisClient, transportConfig, logger)
 
+	// Export the upstream connection pool statistics at scrape time
+	metrics.SetTransportStatsSource(func() []metrics.TransportPoolStats {
+		return transportPoolStats(dito.TransportCache)
+	})
+
+	// Push the metrics to a StatsD agent if enabled
+	if statsdConfig := dito.Config.Metrics.StatsD; statsdConfig.Enabled {
+		exporter, err := metrics.StartStatsDExporter(statsdConfig, logger)
+		if err != nil {
+			log.Fatal("Failed to start the StatsD exporter: ", err)
+		}
+		defer exporter.Stop()
+	}
+
+	// Open the audit log if enabled
+	if dito.Config.Audit.Enabled {
+		auditLogger, err := audit.Open(dito.Config.Audit)
+		if err != nil {
+			log.Fatal("Failed to open audit log: ", err)
+		}
+		audit.SetDefault(auditLogger)
+		defer auditLogger.Close()
+	}
+
 	// Define a callback function to handle configuration changes
 	onChange := func(newConfig *config.ProxyConfig) {
 		// Update components with the new configuration
@@ -70,18 +145,101 @@ func main() {
 		dito.UpdateConfig(newConfig)
 	}
 
+	// Toggle debug logging on SIGUSR1
+	watchLogLevelSignal(dito)
+
 	// Watch the configuration file for changes if hot reload is enabled
 	if dito.GetCurrentConfig().HotReload {
 		go config.WatchConfig(*configFile, onChange, logger)
 	}
 
-	// Start the profiler if the flag is enabled
-	if *enableProfiler {
-		startProfiling(dito.Logger)
+	// Take the sockets passed by systemd socket activation, if any
+	activated, err := systemd.InheritedListeners()
+	if err != nil {
+		log.Fatal("Failed to inherit systemd sockets: ", err)
+	}
+
+	// Start the raw TCP/UDP stream proxies
+	streamProxies, err := stream.StartAll(dito.Config.Streams, dito.Logger)
+	if err != nil {
+		log.Fatal("Failed to start stream proxies: ", err)
+	}
+
+	// Start the admin API if enabled
+	var adminServer *http.Server
+	if dito.Config.Admin.Enabled {
+		adminServer = startAdminServer(dito, activated.Take("admin"))
 	}
 
 	// Start the HTTP server
-	StartServer(dito)
+	proxyListener := activated.Take("proxy", "unknown")
+	activated.Close()
+	StartServer(dito, proxyListener)
+
+	// Stop the stream proxies and the admin API once the HTTP server has shut down
+	for _, proxy := range streamProxies {
+		proxy.Close()
+	}
+	if adminServer != nil {
+		adminServer.Close()
+	}
+}
+
+// startAdminServer starts the admin API on its own address in the background.
+//
+// Parameters:
+// - dito: The Dito application instance.
+// - ln: A listener inherited from systemd, or nil to listen on the configured address.
+//
+// Returns:
+// - *http.Server: The admin server, to be closed on shutdown.
+func startAdminServer(dito *app.Dito, ln net.Listener) *http.Server {
+	adminConfig := dito.Config.Admin
+	if adminConfig.Token == "" {
+		dito.Logger.Warn("Admin API enabled without a token: every client able to reach it is trusted")
+	}
+
+	server := &http.Server{
+		Addr:              adminConfig.Address,
+		Handler:           cmid.RequestIDMiddleware(admin.NewHandler(dito)),
+		ReadHeaderTimeout: dito.Config.Server.ReadHeaderTimeout,
+	}
+	if ln == nil {
+		var err error
+		ln, err = net.Listen("tcp", server.Addr)
+		if err != nil {
+			log.Fatal("Failed to start admin API: ", err)
+		}
+	}
+	dito.Logger.Info(fmt.Sprintf("Admin API listening on %s", ln.Addr()))
+
+	go func() {
+		if err := server.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			dito.Logger.Error("Admin API stopped", "error", err)
+		}
+	}()
+	return server
+}
+
+// transportPoolStats converts the transport cache statistics into metrics snapshots.
+//
+// Parameters:
+// - cache: The transport cache.
+//
+// Returns:
+// - []metrics.TransportPoolStats: The connection pool statistics of the cached transports.
+func transportPoolStats(cache *transport.TransportCache) []metrics.TransportPoolStats {
+	stats := cache.Stats()
+	pools := make([]metrics.TransportPoolStats, len(stats))
+	for i, transportStats := range stats {
+		pools[i] = metrics.TransportPoolStats{
+			Transport:       transportStats.Key,
+			OpenConnections: transportStats.OpenConnections,
+			ActiveRequests:  transportStats.ActiveRequests,
+			IdleConnections: transportStats.IdleConnections,
+		}
+	}
+	return pools
 }
 
 // StartServer initializes and starts the HTTP server for the Dito application.
@@ -91,7 +249,8 @@ func main() {
 // Parameters:
 //
 //	dito (*app.Dito): The Dito application instance containing configuration and logger.
-func StartServer(dito *app.Dito) {
+//	ln (net.Listener): A listener inherited from systemd, or nil to listen on the configured port.
+func StartServer(dito *app.Dito, ln net.Listener) {
 	// Create a new HTTP request multiplexer (mux) to handle incoming requests.
 	mux := http.NewServeMux()
 
@@ -100,9 +259,24 @@ func StartServer(dito *app.Dito) {
 	}), dito))
 
 	// Create a custom HTTP server with the specified address and handler.
+	// Every request gets a request ID first, so that it can be correlated across logs.
+	// Paths are normalized before they reach the mux so that unsafe paths are rejected
+	// instead of being cleaned and redirected.
+	// Internal headers sent by clients are stripped before any middleware may set them.
+	// Attack paths probed by scanners are answered by the honeypot, with the client address resolved.
+	// Timeouts and header limits protect the server against slow-client attacks.
+	// The connection states and the failed TLS handshakes are exported as metrics.
+	serverConfig := dito.Config.Server
 	server := &http.Server{
-		Addr:    ":" + dito.Config.Port,
-		Handler: mux,
+		Addr:              ":" + dito.Config.Port,
+		Handler:           cmid.RequestIDMiddleware(cmid.NormalizationMiddleware(cmid.ForwardingMiddleware(cmid.HoneypotMiddleware(mux, dito), dito), dito)),
+		ReadHeaderTimeout: serverConfig.ReadHeaderTimeout,
+		ReadTimeout:       serverConfig.ReadTimeout,
+		WriteTimeout:      serverConfig.WriteTimeout,
+		IdleTimeout:       serverConfig.IdleTimeout,
+		MaxHeaderBytes:    serverConfig.MaxHeaderBytes,
+		ConnState:         listener.NewConnTracker().ConnState,
+		ErrorLog:          listener.NewServerErrorLog(dito.Logger),
 	}
 
 	// Channel to listen for OS interrupt signals (e.g., Ctrl+C).
@@ -116,17 +290,9 @@ func StartServer(dito *app.Dito) {
 
 		// Signal received, initiate graceful shutdown.
 		dito.Logger.Info("Shutting down server gracefully...")
+		notifySystemd(dito, systemd.StateStopping)
 
-		// Context with timeout for graceful shutdown (e.g., 30 seconds).
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		// Attempt to gracefully shut down the server.
-		if err := server.Shutdown(ctx); err != nil {
-			dito.Logger.Error("Server forced to shutdown", "error", err)
-		} else {
-			dito.Logger.Info("Server shut down gracefully.")
-		}
+		shutdownServer(dito, server, dito.GetCurrentConfig().Server.Shutdown)
 
 		close(idleConnsClosed)
 	}()
@@ -134,8 +300,47 @@ func StartServer(dito *app.Dito) {
 	// Log server start message.
 	dito.Logger.Info(fmt.Sprintf("👉 Dito it's ready on port: %s", dito.Config.Port))
 
+	// Open the listening socket unless systemd passed one, capping concurrent connections if limits are configured.
+	if ln == nil {
+		var err error
+		ln, err = net.Listen("tcp", server.Addr)
+		if err != nil {
+			dito.Logger.Error("Server failed to start", "error", err)
+			log.Fatal(err)
+		}
+	}
+	if serverConfig.MaxConnections > 0 || serverConfig.MaxConnectionsPerIP > 0 {
+		limitAction := serverConfig.ConnectionLimitAction
+		if serverConfig.TLS.Enabled {
+			// A plain-text 503 cannot be delivered before the TLS handshake.
+			limitAction = listener.ActionDrop
+		}
+		ln = listener.NewLimitListener(ln, serverConfig.MaxConnections, serverConfig.MaxConnectionsPerIP, limitAction, dito.Logger)
+	}
+
+	// Configure TLS termination if enabled.
+	if serverConfig.TLS.Enabled {
+		tlsConfig, err := tlsutil.NewServerConfig(serverConfig.TLS)
+		if err != nil {
+			dito.Logger.Error("Invalid server TLS configuration", "error", err)
+			log.Fatal(err)
+		}
+		server.TLSConfig = tlsConfig
+		if len(tlsConfig.NextProtos) > 0 && !slices.Contains(tlsConfig.NextProtos, "h2") {
+			// HTTP/2 is not among the advertised ALPN protocols: keep the server from enabling it.
+			server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+	}
+
+	// Every listener is bound: tell systemd the service is ready.
+	notifySystemd(dito, systemd.StateReady)
+
 	// Start the HTTP server.
-	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+	serve := func() error { return server.Serve(ln) }
+	if server.TLSConfig != nil {
+		serve = func() error { return server.ServeTLS(ln, "", "") }
+	}
+	if err := serve(); !errors.Is(err, http.ErrServerClosed) {
 		dito.Logger.Error("Server failed to start", "error", err)
 		log.Fatal(err)
 	}
@@ -145,19 +350,73 @@ func StartServer(dito *app.Dito) {
 	dito.Logger.Info("All connections closed, exiting.")
 }
 
-// startProfiling enables various runtime profiling options and starts the pprof server.
-func startProfiling(logger *slog.Logger) {
-	// Start the profiling server for performance analysis
+// shutdownServer gracefully shuts down the proxy server. During the drain delay the server keeps serving
+// with keep-alives disabled, so that HTTP/1.1 responses carry "Connection: close"; the shutdown then stops
+// accepting connections, sends GOAWAY to HTTP/2 clients, and waits for in-flight requests up to the timeout.
+// WebSocket sessions, which the server does not track, are given their own deadline.
+//
+// Parameters:
+// - dito: The Dito application instance containing the logger.
+// - server: The proxy server.
+// - shutdownConfig: The graceful shutdown configuration.
+func shutdownServer(dito *app.Dito, server *http.Server, shutdownConfig config.ShutdownConfig) {
+	server.SetKeepAlivesEnabled(false)
+	if shutdownConfig.DrainDelay > 0 {
+		dito.Logger.Info(fmt.Sprintf("Draining connections for %s", shutdownConfig.DrainDelay))
+		time.Sleep(shutdownConfig.DrainDelay)
+	}
+
+	websocketsClosed := make(chan int, 1)
 	go func() {
-		logger.Info("Starting pprof on :6060")
-		if err := http.ListenAndServe("localhost:6060", nil); err != nil {
-			logger.Error("pprof failed", "error", err)
-		}
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownConfig.WebSocketTimeout)
+		defer cancel()
+		websocketsClosed <- websocket.Shutdown(ctx)
 	}()
 
-	// Enable mutex profiling to help identify lock contention issues
-	runtime.SetMutexProfileFraction(1)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownConfig.Timeout)
+	defer cancel()
+
+	// Attempt to gracefully shut down the server, closing the remaining connections once the timeout expires.
+	if err := server.Shutdown(ctx); err != nil {
+		dito.Logger.Error("Server forced to shutdown", "error", err)
+		server.Close()
+	} else {
+		dito.Logger.Info("Server shut down gracefully.")
+	}
+
+	if closed := <-websocketsClosed; closed > 0 {
+		dito.Logger.Warn(fmt.Sprintf("Closed %d WebSocket sessions still open at shutdown", closed))
+	}
+}
+
+// toggleDebugLogging switches the logger to the debug level, or back to the configured level if it is
+// already logging at the debug level.
+//
+// Parameters:
+// - dito: The Dito application instance.
+func toggleDebugLogging(dito *app.Dito) {
+	level := "debug"
+	if dito.LogLevel() <= slog.LevelDebug {
+		level = dito.GetCurrentConfig().Logging.Level
+		if _, err := logging.ParseLevel(level); err != nil {
+			// Unknown configured levels fall back to info, as at startup.
+			level = "info"
+		}
+	}
+	if err := dito.SetLogLevel(level, 0); err != nil {
+		dito.Logger.Error("Failed to change the log level", "error", err)
+		return
+	}
+	dito.Logger.Warn(fmt.Sprintf("Log level set to %s", strings.ToLower(dito.LogLevel().String())))
+}
 
-	// Enable block profiling to help identify blocking operations
-	runtime.SetBlockProfileRate(1)
+// notifySystemd reports a state change to systemd, when running under a unit with Type=notify.
+//
+// Parameters:
+// - dito: The Dito application instance containing the logger.
+// - state: The state to send.
+func notifySystemd(dito *app.Dito, state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		dito.Logger.Warn("Failed to notify systemd", "state", state, "error", err)
+	}
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"dito/recording"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// headerFlags collects the repeated -header flags of the replay command.
+type headerFlags http.Header
+
+// String returns the headers, for the flag package.
+func (h headerFlags) String() string {
+	return fmt.Sprint(http.Header(h))
+}
+
+// Set adds a header given as "Name: value".
+func (h headerFlags) Set(value string) error {
+	name, headerValue, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("header %q must be given as \"Name: value\"", value)
+	}
+	http.Header(h).Add(textproto.TrimString(name), textproto.TrimString(headerValue))
+	return nil
+}
+
+// replay re-sends the exchanges recorded on a location to a target and prints the ones answered differently,
+// then a summary.
+//
+// Parameters:
+// - args: The arguments of the command: the flags, then the recording file, or the location with -redis.
+// - out: The writer receiving the report.
+//
+// Returns:
+// - error: An error if the arguments are invalid, the recording cannot be read, or an exchange did not match.
+func replay(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	target := flags.String("target", "", "base URL the recorded requests are sent to")
+	redisAddr := flags.String("redis", "", "Redis address holding the recording; the argument is then the location label")
+	compareBody := flags.Bool("body", false, "compare the response bodies besides the status codes")
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout of each replayed request")
+	header := headerFlags{}
+	flags.Var(header, "header", "header replacing the recorded one, e.g. \"Authorization: Bearer token\" (repeatable)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *target == "" || flags.NArg() != 1 {
+		return fmt.Errorf("usage: replay -target URL [-redis ADDR] [-body] [-header \"Name: value\"] <recording file or location>")
+	}
+	targetURL, err := url.Parse(*target)
+	if err != nil || targetURL.Scheme == "" || targetURL.Host == "" {
+		return fmt.Errorf("target URL %s must be absolute", *target)
+	}
+
+	var exchanges []recording.Exchange
+	if *redisAddr != "" {
+		client := redis.NewClient(&redis.Options{Addr: *redisAddr})
+		defer client.Close()
+		exchanges, err = recording.LoadRedis(context.Background(), client, flags.Arg(0))
+	} else {
+		var file *os.File
+		if file, err = os.Open(flags.Arg(0)); err != nil {
+			return err
+		}
+		defer file.Close()
+		exchanges, err = recording.Load(file)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the recording: %v", err)
+	}
+
+	results := recording.Replay(context.Background(), exchanges, recording.ReplayOptions{
+		Target:      targetURL,
+		Header:      http.Header(header),
+		CompareBody: *compareBody,
+		Client: &http.Client{
+			Timeout:       *timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	})
+	matched, mismatched, failed := 0, 0, 0
+	for _, result := range results {
+		request := result.Exchange.Request
+		switch {
+		case result.Err != nil:
+			failed++
+			fmt.Fprintf(out, "ERROR     %s %s: %v\n", request.Method, request.URL, result.Err)
+		case !result.Match:
+			mismatched++
+			fmt.Fprintf(out, "MISMATCH  %s %s: recorded %d, replayed %d\n", request.Method, request.URL, result.Exchange.Response.Status, result.Status)
+		default:
+			matched++
+		}
+	}
+	fmt.Fprintf(out, "%d exchanges replayed: %d matched, %d mismatched, %d failed\n", len(results), matched, mismatched, failed)
+	if mismatched > 0 || failed > 0 {
+		return fmt.Errorf("%d of %d exchanges were not answered as recorded", mismatched+failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "dito/app"
+
+// watchLogLevelSignal is a no-op on platforms without SIGUSR1; the log level can still be changed
+// through the admin API.
+func watchLogLevelSignal(dito *app.Dito) {}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package main
+
+import (
+	"dito/app"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchLogLevelSignal toggles debug logging every time the process receives SIGUSR1.
+//
+// Parameters:
+// - dito: The Dito application instance.
+func watchLogLevelSignal(dito *app.Dito) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			toggleDebugLogging(dito)
+		}
+	}()
+}
@@ -1,24 +1,71 @@
 package config
 
 import (
+	"dito/geoip"
+	"dito/openapi"
+	"dito/transform"
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"io"
 	"log"
 	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"reflect"
 	"regexp"
+	"slices"
+	"strings"
 	"sync/atomic"
 	"time"
 )
 
 // RedisConfig holds the configuration for connecting to a Redis server.
 type RedisConfig struct {
-	Enabled  bool   `yaml:"enabled"`  // Enables/disables Redis.
-	Host     string `yaml:"host"`     // Redis server host.
-	Port     string `yaml:"port"`     // Redis server port.
-	Password string `yaml:"password"` // Redis server password.
+	Enabled  bool   `yaml:"enabled"`                // Enables/disables Redis.
+	Host     string `yaml:"host"`                   // Redis server host.
+	Port     string `yaml:"port"`                   // Redis server port.
+	Password string `yaml:"password" secret:"true"` // Redis server password.
+}
+
+// TLSOptions holds the protocol-level TLS settings shared by the server and the upstream transports.
+//
+// Fields:
+// - MinVersion: The minimum TLS version to accept ("1.0", "1.1", "1.2", "1.3"). Defaults to "1.2".
+// - MaxVersion: The maximum TLS version to accept. Defaults to the highest version supported by Go.
+// - CipherSuites: The list of enabled TLS 1.0-1.2 cipher suites by IANA name (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256).
+// - CurvePreferences: The elliptic curves used in the key exchange, in preference order (X25519, P256, P384, P521).
+// - ALPNProtocols: The application protocols advertised through ALPN (e.g. h2, http/1.1).
+type TLSOptions struct {
+	MinVersion       string   `yaml:"min_version"`
+	MaxVersion       string   `yaml:"max_version"`
+	CipherSuites     []string `yaml:"cipher_suites"`
+	CurvePreferences []string `yaml:"curve_preferences"`
+	ALPNProtocols    []string `yaml:"alpn_protocols"`
+}
+
+// ServerTLSConfig holds the TLS configuration for client-facing connections.
+type ServerTLSConfig struct {
+	Enabled    bool   `yaml:"enabled"`   // Enables/disables TLS termination on the proxy port.
+	CertFile   string `yaml:"cert_file"` // Path to the server certificate file.
+	KeyFile    string `yaml:"key_file"`  // Path to the server key file.
+	TLSOptions `yaml:",inline"`
+}
+
+// UpstreamTLSConfig holds the TLS settings used when connecting to upstream servers.
+//
+// Fields:
+// - ServerName: Overrides the SNI server name and the name verified in the upstream certificate.
+// - InsecureSkipVerify: Disables upstream certificate verification. Only meant for lab environments.
+// - PinnedCertificates: SHA-256 fingerprints (hex or base64) of accepted upstream certificates.
+// - PinnedPublicKeys: SHA-256 fingerprints (hex or base64) of accepted upstream SubjectPublicKeyInfo.
+type UpstreamTLSConfig struct {
+	TLSOptions         `yaml:",inline"`
+	ServerName         string   `yaml:"server_name"`
+	InsecureSkipVerify bool     `yaml:"insecure_skip_verify"`
+	PinnedCertificates []string `yaml:"pinned_certificates"`
+	PinnedPublicKeys   []string `yaml:"pinned_public_keys"`
 }
 
 // HTTPTransportConfig holds the configuration settings for the HTTP transport.
@@ -29,117 +76,2440 @@ type RedisConfig struct {
 // - MaxIdleConnsPerHost: The maximum number of idle (keep-alive) connections to keep per-host.
 // - TLSHandshakeTimeout: The maximum amount of time allowed for the TLS handshake.
 // - ResponseHeaderTimeout: The maximum amount of time to wait for a server's response headers after fully writing the request.
-// - ExpectContinueTimeout: The maximum amount of time to wait for a server's first response headers after fully writing the request headers if the request has an "Expect: 100-continue" header.
+// - ExpectContinueTimeout: The maximum amount of time to wait for a server's first response headers after fully writing the request headers if the request has an "Expect: 100-continue" header. Defaults to 1s; a negative value sends the body at once.
 // - DisableCompression: Whether to disable compression (gzip) for requests.
 // - DialTimeout: The maximum amount of time to wait for a dial to complete.
 // - KeepAlive: The interval between keep-alive probes for an active network connection.
+// - TLS: The TLS protocol settings (versions, cipher suites, curves, ALPN) for upstream connections.
 type HTTPTransportConfig struct {
-	IdleConnTimeout       time.Duration `yaml:"idle_conn_timeout"`
-	MaxIdleConns          int           `yaml:"max_idle_conns"`
-	MaxIdleConnsPerHost   int           `yaml:"max_idle_conns_per_host"`
-	MaxConnsPerHost       int           `yaml:"max_conns_per_host"`
-	TLSHandshakeTimeout   time.Duration `yaml:"tls_handshake_timeout"`
-	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
-	ExpectContinueTimeout time.Duration `yaml:"expect_continue_timeout"`
-	DisableCompression    bool          `yaml:"disable_compression"`
-	ForceHTTP2            bool          `yaml:"force_http2"`
-	DialTimeout           time.Duration `yaml:"dial_timeout"`
-	KeepAlive             time.Duration `yaml:"keep_alive"`
-	CertFile              string        `yaml:"cert_file"` // Path to the certificate file.
-	KeyFile               string        `yaml:"key_file"`  // Path to the key file.
-	CaFile                string        `yaml:"ca_file"`   // Path to the CA file.
+	IdleConnTimeout       time.Duration     `yaml:"idle_conn_timeout"`
+	MaxIdleConns          int               `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost   int               `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int               `yaml:"max_conns_per_host"`
+	TLSHandshakeTimeout   time.Duration     `yaml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration     `yaml:"response_header_timeout"`
+	ExpectContinueTimeout time.Duration     `yaml:"expect_continue_timeout"`
+	DisableCompression    bool              `yaml:"disable_compression"`
+	ForceHTTP2            bool              `yaml:"force_http2"`
+	DialTimeout           time.Duration     `yaml:"dial_timeout"`
+	KeepAlive             time.Duration     `yaml:"keep_alive"`
+	CertFile              string            `yaml:"cert_file"` // Path to the certificate file.
+	KeyFile               string            `yaml:"key_file"`  // Path to the key file.
+	CaFile                string            `yaml:"ca_file"`   // Path to the CA file.
+	TLS                   UpstreamTLSConfig `yaml:"tls"`       // TLS protocol settings for upstream connections.
+}
+
+type TransportConfig struct {
+	HTTP HTTPTransportConfig `yaml:"http"`
+}
+
+// MetricsConfig holds the configuration for the metrics server.
+type MetricsConfig struct {
+	Enabled   bool            `yaml:"enabled"`    // Enables/disables the metrics server.
+	Path      string          `yaml:"path"`       // Path the metrics server will respond to.
+	PathLabel PathLabelConfig `yaml:"path_label"` // How the path label of the request metrics is derived.
+	StatsD    StatsDConfig    `yaml:"statsd"`     // Push exporter to a StatsD agent.
+}
+
+// Path label modes, deciding the normalized_path label of the request metrics.
+const (
+	PathLabelLocation   = "location"   // The name or path pattern of the matched location, "other" when none matched.
+	PathLabelNormalized = "normalized" // The request path rewritten by the normalization rules.
+)
+
+// PathLabelConfig holds how the path label of the request metrics is derived. Whatever the mode, the
+// number of distinct labels is bounded: once MaxValues labels were seen, new ones are reported as "other".
+//
+// Fields:
+// - Mode: The path label mode (location or normalized). Defaults to location.
+// - Rules: The rules applied in order in normalized mode. Defaults to replacing digit runs with ":id".
+// - MaxValues: The maximum number of distinct path labels. Defaults to 100.
+type PathLabelConfig struct {
+	Mode      string     `yaml:"mode"`
+	Rules     []PathRule `yaml:"rules"`
+	MaxValues int        `yaml:"max_values"`
+}
+
+// PathRule rewrites the parts of a request path matching a regular expression.
+type PathRule struct {
+	Pattern       string         `yaml:"pattern"`     // Regular expression matched against the path.
+	Replacement   string         `yaml:"replacement"` // Replacement of the matches; may reference groups as in regexp.Expand.
+	CompiledRegex *regexp.Regexp `yaml:"-"`           // Compiled regular expression of the pattern.
+}
+
+// StatsD flavors, deciding how metric labels are sent.
+const (
+	StatsDFlavorStatsD    = "statsd"    // Label values are appended to the metric names.
+	StatsDFlavorDogStatsD = "dogstatsd" // Labels are sent as DogStatsD tags.
+)
+
+// StatsDConfig holds the configuration of the StatsD push exporter, for environments without a Prometheus
+// scraper. The metrics are pushed over UDP at every flush interval.
+//
+// Fields:
+// - Enabled: Enables/disables the exporter.
+// - Address: The address of the StatsD agent. Defaults to 127.0.0.1:8125.
+// - Flavor: The protocol flavor (statsd or dogstatsd). Defaults to statsd.
+// - FlushInterval: How often the metrics are pushed. Defaults to 10s.
+// - Prefix: A prefix prepended to the metric names, e.g. "dito.".
+type StatsDConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Address       string        `yaml:"address"`
+	Flavor        string        `yaml:"flavor"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	Prefix        string        `yaml:"prefix"`
+}
+
+// DNSConfig holds the configuration of the in-process DNS cache used for upstream lookups.
+//
+// Fields:
+// - Enabled: Enables/disables the DNS cache. When disabled, every connection resolves the upstream host.
+// - TTL: How long successful lookups are cached. Defaults to 30s.
+// - NegativeTTL: How long "host not found" answers are cached. Defaults to 5s.
+type DNSConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	TTL         time.Duration `yaml:"ttl"`
+	NegativeTTL time.Duration `yaml:"negative_ttl"`
+}
+
+// BufferingConfig holds the global settings of the buffers used when a body must be kept whole
+// (caching, verbose logging, request spooling).
+//
+// Fields:
+// - MemoryLimit: The number of bytes of each body kept in memory before spilling to a temporary file. Defaults to 1 MB.
+// - DiskBudget: The maximum total size of the temporary files. Bodies that do not fit are not kept. Defaults to 1 GB.
+// - TempDir: The directory of the temporary files. Defaults to the system temporary directory.
+type BufferingConfig struct {
+	MemoryLimit int64  `yaml:"memory_limit"`
+	DiskBudget  int64  `yaml:"disk_budget"`
+	TempDir     string `yaml:"temp_dir"`
+}
+
+// AuditConfig holds the configuration of the audit log, which records administrative and
+// security events in hash-chained JSON lines, separately from the access logs.
+//
+// Fields:
+// - Enabled: Enables/disables the audit log.
+// - File: The path of the audit log file. Defaults to audit.log.
+// - HMACKey: Optional key used to sign the hash chain, so that it cannot be recomputed after tampering.
+type AuditConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	File    string `yaml:"file"`
+	HMACKey string `yaml:"hmac_key" secret:"true"`
+}
+
+// DefaultAuditFile is the audit log path used when none is configured.
+const DefaultAuditFile = "audit.log"
+
+// Startup policies, deciding whether a failed startup check stops the process.
+const (
+	StartupPolicyFailFast = "fail-fast"      // Any failed check stops the startup.
+	StartupPolicyDegraded = "degraded-start" // Failed checks are logged and the proxy starts with the affected features unavailable.
+)
+
+// StartupConfig holds the configuration of the checks run before the listeners are bound.
+//
+// Fields:
+// - Policy: What to do when a check fails (fail-fast or degraded-start). Defaults to fail-fast.
+// - CheckUpstreams: Also verifies that the upstream of every location accepts TCP connections.
+// - Timeout: The maximum duration of each check. Defaults to 5s.
+type StartupConfig struct {
+	Policy         string        `yaml:"policy"`
+	CheckUpstreams bool          `yaml:"check_upstreams"`
+	Timeout        time.Duration `yaml:"timeout"`
+}
+
+// AdminConfig holds the configuration for the administrative API server.
+type AdminConfig struct {
+	Enabled   bool            `yaml:"enabled"`             // Enables/disables the admin API.
+	Address   string          `yaml:"address"`             // Address the admin API listens on. Defaults to 127.0.0.1:9901.
+	Token     string          `yaml:"token" secret:"true"` // Bearer token required by every admin request. Empty disables authentication.
+	Profiling ProfilingConfig `yaml:"profiling"`           // Runtime profiling served under /debug/pprof/.
+}
+
+// ProfilingConfig holds the configuration of the runtime profiler.
+//
+// Fields:
+// - Enabled: Serves the pprof endpoints under /debug/pprof/ on the admin API.
+// - MutexProfileFraction: Reports 1 in N mutex contention events (0 disables the mutex profile).
+// - BlockProfileRate: Samples one blocking event per N nanoseconds spent blocked (0 disables the block profile).
+// - Export: Continuous export of profiles to files.
+type ProfilingConfig struct {
+	Enabled              bool                `yaml:"enabled"`
+	MutexProfileFraction int                 `yaml:"mutex_profile_fraction"`
+	BlockProfileRate     int                 `yaml:"block_profile_rate"`
+	Export               ProfileExportConfig `yaml:"export"`
+}
+
+// ProfileExportConfig holds the configuration of the continuous profile export, which periodically writes
+// a CPU and a heap profile to a directory.
+//
+// Fields:
+// - Enabled: Enables/disables the export. It also requires profiling to be enabled.
+// - Directory: The directory the profiles are written to. Defaults to profiles.
+// - Interval: The time between two exports. Defaults to 1m.
+// - CPUDuration: How long the CPU is profiled at each export. Defaults to 10s.
+// - Retain: The number of profiles of each kind kept in the directory. Defaults to 10.
+type ProfileExportConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Directory   string        `yaml:"directory"`
+	Interval    time.Duration `yaml:"interval"`
+	CPUDuration time.Duration `yaml:"cpu_duration"`
+	Retain      int           `yaml:"retain"`
+}
+
+// DefaultAdminAddress is the address the admin API listens on when none is configured.
+const DefaultAdminAddress = "127.0.0.1:9901"
+
+// Default values applied to the server configuration when a setting is omitted.
+const (
+	DefaultReadHeaderTimeout = 10 * time.Second  // Time allowed to read the request headers.
+	DefaultReadTimeout       = 60 * time.Second  // Time allowed to read the whole request, body included.
+	DefaultIdleTimeout       = 120 * time.Second // Time a keep-alive connection may stay idle.
+	DefaultMaxHeaderBytes    = 1 << 20           // Maximum size of the request headers (1 MB).
+	DefaultShutdownTimeout   = 30 * time.Second  // Time allowed to in-flight requests once the shutdown begins.
+)
+
+// ServerConfig holds the configuration for the HTTP server accepting client connections.
+//
+// Fields:
+// - ReadHeaderTimeout: The maximum amount of time allowed to read the request headers. Protects against slowloris attacks.
+// - ReadTimeout: The maximum amount of time allowed to read the entire request, including the body. Zero disables it so long uploads are not cut.
+// - WriteTimeout: The maximum amount of time allowed to write the response. Zero disables it so streaming responses are not cut.
+// - IdleTimeout: The maximum amount of time to wait for the next request when keep-alives are enabled.
+// - MaxHeaderBytes: The maximum number of bytes the server will read parsing the request headers.
+// - MaxConnections: The maximum number of concurrent client connections. Zero means unlimited.
+// - MaxConnectionsPerIP: The maximum number of concurrent connections from a single client IP. Zero means unlimited.
+// - ConnectionLimitAction: What to do with connections above the limits: "reject" (answer 503) or "drop" (close silently).
+// - TLS: The TLS configuration for client-facing connections.
+// - Shutdown: The graceful shutdown behavior.
+type ServerConfig struct {
+	ReadHeaderTimeout     time.Duration   `yaml:"read_header_timeout"`
+	ReadTimeout           time.Duration   `yaml:"read_timeout"`
+	WriteTimeout          time.Duration   `yaml:"write_timeout"`
+	IdleTimeout           time.Duration   `yaml:"idle_timeout"`
+	MaxHeaderBytes        int             `yaml:"max_header_bytes"`
+	MaxConnections        int             `yaml:"max_connections"`
+	MaxConnectionsPerIP   int             `yaml:"max_connections_per_ip"`
+	ConnectionLimitAction string          `yaml:"connection_limit_action"`
+	TLS                   ServerTLSConfig `yaml:"tls"`
+	Shutdown              ShutdownConfig  `yaml:"shutdown"`
+}
+
+// ShutdownConfig holds the graceful shutdown behavior of the proxy server.
+//
+// Fields:
+// - Timeout: The maximum amount of time in-flight requests may take to complete once the shutdown begins. Defaults to 30s.
+// - DrainDelay: How long the server keeps serving, closing every connection after its response, before the shutdown begins,
+// so that clients and load balancers move away. Zero starts the shutdown at once.
+// - WebSocketTimeout: The maximum amount of time WebSocket sessions may stay open once the shutdown begins, after which they
+// are closed with a going-away close frame. Zero closes them at once.
+type ShutdownConfig struct {
+	Timeout          time.Duration `yaml:"timeout"`
+	DrainDelay       time.Duration `yaml:"drain_delay"`
+	WebSocketTimeout time.Duration `yaml:"websocket_timeout"`
+}
+
+// ProxyConfig holds the configuration for the proxy server.
+type ProxyConfig struct {
+	Port       string                    `yaml:"port"`       // Port the proxy will listen on.
+	HotReload  bool                      `yaml:"hot_reload"` // Enables/disables hot reloading.
+	Server     ServerConfig              `yaml:"server"`     // HTTP server configuration (timeouts and limits).
+	Logging    Logging                   `yaml:"logging"`    // Logging configuration.
+	Redis      RedisConfig               `yaml:"redis"`      // Redis configuration.
+	Metrics    MetricsConfig             `yaml:"metrics"`    // Metrics configuration.
+	Admin      AdminConfig               `yaml:"admin"`      // Admin API configuration.
+	Audit      AuditConfig               `yaml:"audit"`      // Audit log configuration.
+	Startup    StartupConfig             `yaml:"startup"`    // Checks run before the listeners are bound.
+	Policies   map[string]LocationConfig `yaml:"policies"`   // Named location settings shared by the locations referencing them.
+	Locations  []LocationConfig          `yaml:"locations"`  // List of configurations for each location.
+	Transport  TransportConfig           `yaml:"transport"`  // Transport configuration.
+	DNS        DNSConfig                 `yaml:"dns"`        // Upstream DNS cache configuration.
+	Buffering  BufferingConfig           `yaml:"buffering"`  // Memory and disk limits of buffered bodies.
+	Forwarding ForwardingConfig          `yaml:"forwarding"` // Client address resolution and inbound header stripping.
+	GeoIP      GeoIPConfig               `yaml:"geoip"`      // Country and autonomous system resolution of the clients.
+	Honeypot   HoneypotConfig            `yaml:"honeypot"`   // Slow responses to the attack paths probed by scanners.
+	Fallback   Fallback                  `yaml:"fallback"`   // Target of the requests matching no location.
+	Routing    RoutingConfig             `yaml:"routing"`    // Order in which the locations are evaluated.
+	Echo       EchoConfig                `yaml:"echo"`       // Debug endpoint reflecting the received requests.
+	Streams    []StreamConfig            `yaml:"streams"`    // Raw TCP/UDP stream proxies.
+	Secrets    SecretsConfig             `yaml:"secrets"`    // Resolution of the secret references.
+
+	secretReferences int // Number of secret references resolved by the last load.
+}
+
+// ForwardingConfig holds how the client address is resolved and which client headers never reach the upstreams.
+//
+// Fields:
+// - TrustedProxies: The addresses or CIDR ranges of the proxies in front of Dito. Their X-Forwarded-For entries
+// are trusted to resolve the client address sent in X-Real-IP; the header of any other peer is ignored.
+// - InternalHeaders: The headers always stripped from inbound requests, because upstreams trust them (e.g.
+// X-User-Id). A trailing "*" strips every header with the prefix, e.g. "X-Internal-*".
+type ForwardingConfig struct {
+	TrustedProxies         []string       `yaml:"trusted_proxies"`
+	InternalHeaders        []string       `yaml:"internal_headers"`
+	CompiledTrustedProxies []netip.Prefix `yaml:"-"` // Parsed ranges of the trusted proxies.
+}
+
+// DefaultEchoPath is the path prefix of the echo endpoint when none is configured.
+const DefaultEchoPath = "/_dito/echo"
+
+// EchoConfig holds the echo endpoint, which answers the requests under its path with a description of the request
+// as received by the proxy and of the location the rest of the path matches, to debug header and routing rules.
+// It reveals the headers added by the proxy and the targets of the locations, so it is disabled by default.
+//
+// Fields:
+// - Enabled: Enables/disables the echo endpoint.
+// - Path: The path prefix of the endpoint. Defaults to /_dito/echo.
+type EchoConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+}
+
+// RoutingConfig holds the order in which the locations are evaluated. Locations with a higher priority are always
+// evaluated first.
+//
+// Fields:
+// - Order: How locations of the same priority are ordered: first_match (default) keeps the configuration order;
+// longest_prefix evaluates exact paths (e.g. "^/login$") first, then the anchored paths by decreasing length of
+// their literal prefix, then the unanchored ones, each in configuration order.
+type RoutingConfig struct {
+	Order string `yaml:"order"`
+}
+
+// Orders of the locations.
+const (
+	RoutingFirstMatch    = "first_match"
+	RoutingLongestPrefix = "longest_prefix"
+)
+
+// HoneypotConfig holds the attack paths probed by scanners, such as /.env or /wp-login.php, which are answered
+// by Dito itself, slowly, instead of being forwarded to the upstreams.
+//
+// Fields:
+// - Enabled: Enables the honeypot.
+// - Paths: Regular expressions of the attack paths, matched against the normalized path. Defaults to
+// DefaultHoneypotPaths.
+// - Response: not_found (default) answers 404 once the delay elapsed; junk sends random text for the whole delay.
+// - Delay: How long each response is held or dripped. Zero answers at once.
+// - JunkSize: The number of bytes of the junk responses. Defaults to 64 KiB.
+// - MaxTrapped: The most requests held at once; beyond it, requests get 404 at once. Defaults to 100.
+type HoneypotConfig struct {
+	Enabled       bool             `yaml:"enabled"`
+	Paths         []string         `yaml:"paths"`
+	Response      string           `yaml:"response"`
+	Delay         time.Duration    `yaml:"delay"`
+	JunkSize      int64            `yaml:"junk_size"`
+	MaxTrapped    int              `yaml:"max_trapped"`
+	CompiledPaths []*regexp.Regexp `yaml:"-"` // Compiled expressions of the attack paths.
+}
+
+// Responses of the honeypot.
+const (
+	HoneypotResponseNotFound = "not_found"
+	HoneypotResponseJunk     = "junk"
+)
+
+// DefaultHoneypotJunkSize is the size of the junk responses of the honeypot when it does not set one.
+const DefaultHoneypotJunkSize = 64 << 10
+
+// DefaultMaxTrapped is the number of requests held at once by the honeypot, or by the tarpit of a bot filter, when
+// it does not set one.
+const DefaultMaxTrapped = 100
+
+// DefaultHoneypotPaths are the attack paths of the honeypot when it does not set any: secrets and repositories
+// left in the document root, and the login and setup pages of common applications.
+var DefaultHoneypotPaths = []string{
+	`/\.env(\.|$)`,
+	`/\.git(/|$)`,
+	`/\.aws/`,
+	`/\.ssh/`,
+	`/wp-login\.php$`,
+	`/wp-admin(/|$)`,
+	`/xmlrpc\.php$`,
+	`(?i)/phpmyadmin(/|$)`,
+	`/phpinfo\.php$`,
+	`/cgi-bin/`,
+	`/(web)?config\.(php|json|yml|yaml)$`,
+}
+
+// GeoIPConfig holds the MaxMind databases resolving the country and the autonomous system of the clients, from
+// the address resolved through the trusted proxies.
+//
+// Fields:
+// - Enabled: Enables the resolution.
+// - CountryDatabase: The path of a MaxMind DB file with countries, such as GeoLite2-Country.mmdb or a City database.
+// - ASNDatabase: The path of a MaxMind DB file with autonomous systems, such as GeoLite2-ASN.mmdb.
+// - CountryHeader: The header carrying the ISO code of the country to the upstream (empty sends none).
+// - ASNHeader: The header carrying the number of the autonomous system to the upstream (empty sends none).
+// The values clients send in these headers are always replaced.
+type GeoIPConfig struct {
+	Enabled         bool      `yaml:"enabled"`
+	CountryDatabase string    `yaml:"country_database"`
+	ASNDatabase     string    `yaml:"asn_database"`
+	CountryHeader   string    `yaml:"country_header"`
+	ASNHeader       string    `yaml:"asn_header"`
+	DB              *geoip.DB `yaml:"-"` // Databases opened from the files.
+}
+
+// GeoRules restricts a location to the clients of some countries, resolved by the GeoIP databases.
+//
+// Fields:
+// - AllowCountries: The ISO codes of the only countries allowed; clients whose country is unknown are rejected too.
+// - DenyCountries: The ISO codes of the countries rejected.
+type GeoRules struct {
+	AllowCountries []string `yaml:"allow_countries"`
+	DenyCountries  []string `yaml:"deny_countries"`
+}
+
+// Allows reports whether the clients of a country are allowed by the rules.
+//
+// Parameters:
+// - country: The ISO code of the country of the client, empty when unknown.
+//
+// Returns:
+// - bool: True if the client is allowed.
+func (g GeoRules) Allows(country string) bool {
+	if len(g.AllowCountries) > 0 && !slices.Contains(g.AllowCountries, country) {
+		return false
+	}
+	return country == "" || !slices.Contains(g.DenyCountries, country)
+}
+
+// StreamConfig holds the configuration for a raw TCP or UDP stream proxy.
+//
+// Fields:
+// - Name: A name identifying the stream in logs and metrics.
+// - Protocol: The transport protocol to proxy ("tcp" or "udp").
+// - Listen: The local address to listen on (e.g. ":5432").
+// - Target: The upstream address to forward to (host:port).
+// - DialTimeout: The maximum amount of time to wait when connecting to the target.
+// - IdleTimeout: Closes a connection (TCP) or session (UDP) after this long without traffic. Zero disables it for TCP.
+// - TLS: Optional TLS termination for TCP streams.
+type StreamConfig struct {
+	Name        string          `yaml:"name"`
+	Protocol    string          `yaml:"protocol"`
+	Listen      string          `yaml:"listen"`
+	Target      string          `yaml:"target"`
+	DialTimeout time.Duration   `yaml:"dial_timeout"`
+	IdleTimeout time.Duration   `yaml:"idle_timeout"`
+	TLS         ServerTLSConfig `yaml:"tls"`
+}
+
+// RateLimiting holds the configuration for rate limiting.
+type RateLimiting struct {
+	Enabled           bool    `yaml:"enabled"`             // Enables/disables rate limiting globally.
+	RequestsPerSecond float64 `yaml:"requests_per_second"` // Number of requests allowed per second.
+	Burst             int     `yaml:"burst"`               // Maximum burst of requests.
+	Scope             string  `yaml:"scope"`               // Locations sharing the limits: "location" (default), "group", or "global".
+	Group             string  `yaml:"group"`               // Name of the group of locations sharing the limits, with the "group" scope.
+	Shared            bool    `yaml:"shared"`              // All clients share a single budget instead of each client having its own.
+}
+
+// MinSlotTimeout is the shortest slot timeout of a concurrency limit: the slots are renewed every third of it, and
+// expire in Redis with a millisecond precision.
+const MinSlotTimeout = time.Second
+
+// ConcurrencyLimit holds the configuration of the distributed concurrency limiter, which caps the number of
+// requests in flight at the same time across all Dito instances sharing a Redis server.
+type ConcurrencyLimit struct {
+	Enabled     bool          `yaml:"enabled"`       // Enables/disables the concurrency limit.
+	MaxInFlight int           `yaml:"max_in_flight"` // Maximum number of simultaneous requests.
+	PerClient   bool          `yaml:"per_client"`    // Applies the limit to each client IP instead of the whole location.
+	SlotTimeout time.Duration `yaml:"slot_timeout"`  // Time after which a slot not released (e.g. by a crashed instance) expires (default 30s, at least 1s).
+}
+
+// SpikeArrest holds the configuration of the spike arrest middleware, which smooths bursts by delaying requests
+// above the rate of the location instead of rejecting them, as long as the delay stays within a budget.
+type SpikeArrest struct {
+	Enabled           bool          `yaml:"enabled"`             // Enables/disables spike arrest.
+	RequestsPerSecond float64       `yaml:"requests_per_second"` // Rate at which requests are forwarded to the backend.
+	Burst             int           `yaml:"burst"`               // Requests forwarded without delay on top of the rate (default 1).
+	MaxDelay          time.Duration `yaml:"max_delay"`           // Longest a request may be delayed; requests needing more are rejected.
+}
+
+// BandwidthLimit holds the configuration of the bandwidth limit middleware, which throttles the response bodies
+// sent to the clients of a location with a token bucket counted in bytes.
+type BandwidthLimit struct {
+	Enabled        bool     `yaml:"enabled"`            // Enables/disables the bandwidth limit.
+	BytesPerSecond int64    `yaml:"bytes_per_second"`   // Sustained egress rate.
+	Burst          int      `yaml:"burst"`              // Bytes sent at once above the rate (default: one second of traffic).
+	KeyHeader      string   `yaml:"key_header"`         // Header carrying the API key; each accepted key gets its own budget. Empty shares the budget of the location.
+	Keys           []string `yaml:"keys" secret:"true"` // API keys owning a budget; other keys share the budget of the location.
+}
+
+// RequestValidation holds the validation of the requests of a location against an OpenAPI document or a JSON
+// schema. Invalid requests are rejected with 422 before they reach the upstream.
+//
+// Fields:
+// - Enabled: Enables/disables the validation.
+// - OpenAPI: The OpenAPI document whose operations the requests are checked against: the path, query, header,
+// and cookie parameters and the JSON bodies. Defaults to the openapi document of the location.
+// - Schema: A JSON schema the JSON request bodies are checked against, instead of an OpenAPI document.
+// - MaxBodySize: The largest body read for the validation; larger requests are rejected with 413. Defaults to 1MB.
+type RequestValidation struct {
+	Enabled     bool               `yaml:"enabled"`
+	OpenAPI     string             `yaml:"openapi"`
+	Schema      string             `yaml:"schema"`
+	MaxBodySize int64              `yaml:"max_body_size"`
+	Validator   *openapi.Validator `yaml:"-"` // Validator compiled from the document or the schema.
+}
+
+// CSRF holds the double-submit-cookie CSRF protection of a browser-facing location. A random token is set in a
+// cookie readable by scripts, and requests with an unsafe method must echo it in a header: a cross-site page can
+// make the browser send the cookie, but cannot read it.
+//
+// Fields:
+// - Enabled: Enables/disables the CSRF protection.
+// - CookieName: The name of the cookie holding the token. Defaults to csrf_token.
+// - HeaderName: The header echoing the token. Defaults to X-CSRF-Token.
+// - ExemptPaths: Regular expressions of the paths not checked, such as webhooks called by other servers.
+// - Secure: Adds the Secure attribute to the token cookie.
+// - SameSite: The SameSite attribute of the token cookie (Lax, Strict, or None). Defaults to Lax.
+type CSRF struct {
+	Enabled             bool             `yaml:"enabled"`
+	CookieName          string           `yaml:"cookie_name"`
+	HeaderName          string           `yaml:"header_name"`
+	ExemptPaths         []string         `yaml:"exempt_paths"`
+	Secure              bool             `yaml:"secure"`
+	SameSite            string           `yaml:"same_site"`
+	CompiledExemptPaths []*regexp.Regexp `yaml:"-"` // Compiled expressions of the exempt paths.
+}
+
+// BotFilter holds the rules of the bot filter middleware, which stops requests by their User-Agent or by the
+// headers that browsers always send.
+//
+// Fields:
+// - Enabled: Enables/disables the bot filter.
+// - Action: What happens to the stopped requests: block (default) answers 403 at once, tarpit answers it after
+// TarpitDelay, so that the bot wastes its time instead of moving on to the next target.
+// - TarpitDelay: How long tarpitted requests are held. Defaults to 10s.
+// - MaxTarpitted: The most requests held at once by the tarpit; beyond it, requests get 403 at once. Defaults to 100.
+// - BlockKnownBots: Stops the vulnerability scanners and attack tools of the built-in list, such as sqlmap or nikto.
+// - BlockUserAgents: Case-insensitive regular expressions of the User-Agents stopped.
+// - AllowUserAgents: Case-insensitive regular expressions of the User-Agents never stopped, e.g. a monitoring probe.
+// - RequireHeaders: Headers a request must carry with a non-empty value, such as User-Agent or Accept-Language.
+type BotFilter struct {
+	Enabled                 bool             `yaml:"enabled"`
+	Action                  string           `yaml:"action"`
+	TarpitDelay             time.Duration    `yaml:"tarpit_delay"`
+	MaxTarpitted            int              `yaml:"max_tarpitted"`
+	BlockKnownBots          bool             `yaml:"block_known_bots"`
+	BlockUserAgents         []string         `yaml:"block_user_agents"`
+	AllowUserAgents         []string         `yaml:"allow_user_agents"`
+	RequireHeaders          []string         `yaml:"require_headers"`
+	CompiledBlockUserAgents []*regexp.Regexp `yaml:"-"` // Compiled expressions of the blocked User-Agents.
+	CompiledAllowUserAgents []*regexp.Regexp `yaml:"-"` // Compiled expressions of the allowed User-Agents.
+}
+
+// Actions of the bot filter.
+const (
+	BotActionBlock  = "block"
+	BotActionTarpit = "tarpit"
+)
+
+// DefaultTarpitDelay is the time tarpitted requests are held when the bot filter does not set one.
+const DefaultTarpitDelay = 10 * time.Second
+
+// FaultInjection holds the faults injected into a share of the requests of a location by the fault injection
+// middleware, to test how clients handle slow or failing upstreams, e.g. their retries and timeouts. It is
+// meant for test environments, and does nothing unless enabled.
+//
+// Fields:
+// - Enabled: Enables/disables the fault injection.
+// - Delay: The latency added to a share of the requests before they are forwarded.
+// - Abort: The share of the requests answered without reaching the upstream.
+type FaultInjection struct {
+	Enabled bool       `yaml:"enabled"`
+	Delay   FaultDelay `yaml:"delay"`
+	Abort   FaultAbort `yaml:"abort"`
+}
+
+// FaultDelay is the latency injected into a share of the requests.
+type FaultDelay struct {
+	Percentage float64       `yaml:"percentage"` // Percentage of the requests delayed, from 0 to 100.
+	Duration   time.Duration `yaml:"duration"`   // Latency added to the delayed requests.
+}
+
+// FaultAbort is the failure injected into a share of the requests.
+type FaultAbort struct {
+	Percentage float64 `yaml:"percentage"` // Percentage of the requests aborted, from 0 to 100.
+	Status     int     `yaml:"status"`     // Status code answered, between 200 and 599; 0 closes the connection without a response.
+}
+
+// Storages of the recorded exchanges.
+const (
+	RecordingStorageFile  = "file"
+	RecordingStorageRedis = "redis"
+)
+
+// Defaults of the recording of a location.
+const (
+	DefaultRecordingDirectory   = "recordings"
+	DefaultRecordingMaxEntries  = 10000
+	DefaultRecordingMaxBodySize = 64 << 10
+)
+
+// Recording holds the record mode of a location, in which the record middleware stores the requests and the
+// responses sent to the clients, so that they can be replayed against a new version of the backend. Headers
+// and body fields are masked as in the logs.
+//
+// Fields:
+// - Enabled: Enables/disables the recording.
+// - Percentage: The percentage of the requests recorded, up to 100 (0 defaults to 100).
+// - Storage: Where the exchanges are stored: file (default), one JSON lines file per location, or redis, one
+// list per location.
+// - Directory: The directory of the files. Defaults to "recordings".
+// - MaxEntries: The number of exchanges kept per location: the length of the Redis list, or the number of exchanges
+// of a file before it is rotated. Defaults to 10000.
+// - MaxBodySize: The largest body recorded, in bytes; larger bodies are truncated. Defaults to 64KiB.
+type Recording struct {
+	Enabled     bool    `yaml:"enabled"`
+	Percentage  float64 `yaml:"percentage"`
+	Storage     string  `yaml:"storage"`
+	Directory   string  `yaml:"directory"`
+	MaxEntries  int64   `yaml:"max_entries"`
+	MaxBodySize int     `yaml:"max_body_size"`
+}
+
+// Defaults of the service level objectives of a location.
+const (
+	DefaultSLOWindow            = time.Minute
+	DefaultSLOMinRequests       = 20
+	DefaultSLOLatencyPercentile = 95
+)
+
+// SLO holds the service level objectives of a location, checked by the slo middleware over consecutive windows:
+// when a window ends with a latency or an error rate above its objective, a warning is logged and the slo_alert
+// metric of the location is raised, until a window meets the objective again. It gives lightweight alerts
+// without an external alerting pipeline.
+//
+// Fields:
+// - Enabled: Enables/disables the objectives.
+// - Window: The duration of the windows over which the requests are counted. Defaults to 1 minute.
+// - MinRequests: The fewest requests in a window for it to be evaluated. Defaults to 20.
+// - Latency: The latency objective.
+// - MaxErrorRate: The percentage of the requests allowed to fail with a 5xx status (0 disables the objective).
+type SLO struct {
+	Enabled      bool          `yaml:"enabled"`
+	Window       time.Duration `yaml:"window"`
+	MinRequests  int64         `yaml:"min_requests"`
+	Latency      SLOLatency    `yaml:"latency"`
+	MaxErrorRate float64       `yaml:"max_error_rate"`
+}
+
+// SLOLatency is a latency objective: the given percentile of the request durations must stay below the
+// threshold, i.e. at most 100 - percentile percent of the requests may be slower.
+type SLOLatency struct {
+	Threshold  time.Duration `yaml:"threshold"`  // Duration the percentile must stay below (0 disables the objective).
+	Percentile float64       `yaml:"percentile"` // Percentile of the request durations, from 0 to 100 (default 95).
+}
+
+// Default names of the CSRF token cookie and header.
+const (
+	DefaultCSRFCookieName = "csrf_token"
+	DefaultCSRFHeaderName = "X-CSRF-Token"
+)
+
+// Defaults of the sessions.
+const (
+	DefaultSessionCookieName = "dito_session"
+	DefaultSessionTTL        = 24 * time.Hour
+)
+
+// minSessionSecretSize is the shortest secret accepted to sign the session cookies.
+const minSessionSecretSize = 32
+
+// Session holds the server-side sessions of a location. Clients get a cookie carrying a random session ID signed
+// with the secret, and the data of the session is kept in Redis. Locations with the same cookie name and secret
+// share their sessions.
+//
+// Fields:
+// - Enabled: Enables/disables the sessions.
+// - Secret: The key signing the session IDs, at least 32 bytes long.
+// - CookieName: The name of the session cookie. Defaults to dito_session.
+// - TTL: How long a session is kept without requests. Defaults to 24h.
+// - Domain: The Domain attribute of the cookie, to share it with subdomains. Empty restricts it to the host.
+// - Secure: Adds the Secure attribute to the cookie.
+// - SameSite: The SameSite attribute of the cookie (Lax, Strict, or None). Defaults to Lax.
+// - IDHeader: The header forwarding the session ID to the upstream, if set.
+// - Headers: The headers forwarded to the upstream, keyed by session key (e.g. user: X-User-ID). They are
+// removed from the client requests first, so that clients cannot set them.
+// - ResponseHeaders: The upstream response headers written to the session, keyed by session key (e.g. user:
+// X-Session-User); an empty header removes the key. They are removed from the responses.
+// - DestroyHeader: The upstream response header destroying the session, e.g. on logout. It is removed from the
+// responses.
+type Session struct {
+	Enabled         bool              `yaml:"enabled"`
+	Secret          string            `yaml:"secret" secret:"true"`
+	CookieName      string            `yaml:"cookie_name"`
+	TTL             time.Duration     `yaml:"ttl"`
+	Domain          string            `yaml:"domain"`
+	Secure          bool              `yaml:"secure"`
+	SameSite        string            `yaml:"same_site"`
+	IDHeader        string            `yaml:"id_header"`
+	Headers         map[string]string `yaml:"headers"`
+	ResponseHeaders map[string]string `yaml:"response_headers"`
+	DestroyHeader   string            `yaml:"destroy_header"`
+}
+
+// GraphQL holds the GraphQL mode of a location, which parses the GraphQL requests to label the metrics and logs
+// with their operation, and rejects the operations above the limits before they reach the upstream.
+//
+// Fields:
+// - Enabled: Enables/disables the GraphQL mode.
+// - MaxDepth: The deepest nesting of fields allowed (0 means no limit).
+// - MaxComplexity: The largest number of fields an operation, or a batch of operations together, may select,
+// fragments expanded (0 means no limit).
+// - BlockIntrospection: Rejects the operations selecting __schema or __type, e.g. in production.
+// - MaxBodySize: The largest body read to parse the request; larger requests are rejected with 413. Defaults to 1MB.
+type GraphQL struct {
+	Enabled            bool  `yaml:"enabled"`
+	MaxDepth           int   `yaml:"max_depth"`
+	MaxComplexity      int   `yaml:"max_complexity"`
+	BlockIntrospection bool  `yaml:"block_introspection"`
+	MaxBodySize        int64 `yaml:"max_body_size"`
+}
+
+// AdaptiveConcurrency holds the configuration of the adaptive concurrency limiter, which lowers the number of requests
+// allowed in flight when the upstream latency rises (AIMD), and raises it back while the latency stays low.
+type AdaptiveConcurrency struct {
+	Enabled          bool    `yaml:"enabled"`           // Enables/disables the adaptive concurrency limit.
+	InitialLimit     int     `yaml:"initial_limit"`     // Limit of in-flight requests at startup (default 20).
+	MinLimit         int     `yaml:"min_limit"`         // Lowest limit (default 1).
+	MaxLimit         int     `yaml:"max_limit"`         // Highest limit (default 1000).
+	LatencyTolerance float64 `yaml:"latency_tolerance"` // Latency ratio over the baseline above which the limit is lowered (default 2).
+	BackoffRatio     float64 `yaml:"backoff_ratio"`     // Factor applied to the limit when lowering it (default 0.9).
+}
+
+// Quota holds the configuration of a long-window request quota, counted per API key in Redis. Only validated keys
+// are counted: the keys listed in Keys, or the values of a key header set by the introspection of the location.
+type Quota struct {
+	Enabled   bool     `yaml:"enabled"`            // Enables/disables the quota.
+	Name      string   `yaml:"name"`               // Name of the quota; locations with the same name share their counters.
+	Limit     int64    `yaml:"limit"`              // Number of requests allowed per API key and window.
+	Window    string   `yaml:"window"`             // Quota window, in UTC: "day" or "month".
+	KeyHeader string   `yaml:"key_header"`         // Header carrying the API key (default X-API-Key).
+	Keys      []string `yaml:"keys" secret:"true"` // API keys accepted; requests with another key are rejected.
+}
+
+// Hash algorithms of the HMAC signatures.
+const (
+	HMACAlgorithmSHA1   = "sha1"
+	HMACAlgorithmSHA256 = "sha256"
+	HMACAlgorithmSHA512 = "sha512"
+)
+
+// Encodings of the HMAC signatures.
+const (
+	HMACEncodingHex    = "hex"
+	HMACEncodingBase64 = "base64"
+)
+
+// Defaults of the HMAC signature verification of a location.
+const (
+	DefaultHMACHeader      = "X-Signature"
+	DefaultHMACMaxSkew     = 5 * time.Minute
+	DefaultHMACMaxBodySize = 1 << 20
+)
+
+// HMACVerification holds the verification of the HMAC signatures of the requests of a location, as sent by
+// webhook providers. The signature covers the body, preceded by the timestamp and the nonce when their headers
+// are set: "<timestamp>.<nonce>.<body>". Requests with a missing or invalid signature, a timestamp outside the
+// allowed skew, or an already seen nonce get 401.
+//
+// Fields:
+// - Enabled: Enables/disables the verification.
+// - Secret: The secret shared with the senders.
+// - Algorithm: The hash algorithm (sha1, sha256, or sha512). Defaults to sha256.
+// - Encoding: The encoding of the signature (hex or base64). Defaults to hex.
+// - Header: The header carrying the signature. Defaults to X-Signature.
+// - Prefix: The text preceding the signature in the header, e.g. "sha256=".
+// - TimestampHeader: The header carrying the time of the signature, in Unix seconds (empty disables the check).
+// - MaxSkew: The largest difference allowed between the timestamp and the clock of the proxy. Defaults to 5
+// minutes.
+// - NonceHeader: The header carrying a unique value per request, signed with the body.
+// - ReplayProtection: Rejects the requests whose nonce, or signature without a nonce header, was already seen
+// within the skew, remembered in Redis. Requires the timestamp header.
+// - MaxBodySize: The largest body verified, in bytes; larger requests get 413. Defaults to 1MiB.
+type HMACVerification struct {
+	Enabled          bool          `yaml:"enabled"`
+	Secret           string        `yaml:"secret" secret:"true"`
+	Algorithm        string        `yaml:"algorithm"`
+	Encoding         string        `yaml:"encoding"`
+	Header           string        `yaml:"header"`
+	Prefix           string        `yaml:"prefix"`
+	TimestampHeader  string        `yaml:"timestamp_header"`
+	MaxSkew          time.Duration `yaml:"max_skew"`
+	NonceHeader      string        `yaml:"nonce_header"`
+	ReplayProtection bool          `yaml:"replay_protection"`
+	MaxBodySize      int64         `yaml:"max_body_size"`
+}
+
+// Types of the credentials injected into the upstream requests.
+const (
+	CredentialsBearer = "bearer"
+	CredentialsBasic  = "basic"
+	CredentialsHeader = "header"
+)
+
+// UpstreamCredentials holds the credentials added by the proxy to the requests sent to the upstream of a
+// location, so that internal backends can require authentication without their credentials being handed to
+// every client. They replace any value sent by the client, and are usually given as secret references.
+//
+// Fields:
+// - Type: The credentials (bearer, basic, or header); empty disables the injection.
+// - Token: The bearer token, sent as "Authorization: Bearer <token>".
+// - Username: The user of the basic authentication.
+// - Password: The password of the basic authentication.
+// - Header: The header carrying the value, e.g. X-API-Key.
+// - Value: The value of the header.
+type UpstreamCredentials struct {
+	Type     string `yaml:"type"`
+	Token    string `yaml:"token" secret:"true"`
+	Username string `yaml:"username" secret:"true"`
+	Password string `yaml:"password" secret:"true"`
+	Header   string `yaml:"header"`
+	Value    string `yaml:"value" secret:"true"`
+}
+
+// Types of the signatures of the upstream requests.
+const (
+	SigningAWSV4 = "aws-sigv4"
+	SigningHMAC  = "hmac"
+)
+
+// DefaultSigningMaxBodySize is the largest request body read to be signed when none is set.
+const DefaultSigningMaxBodySize = 10 << 20
+
+// UpstreamSigning holds the signature added by the proxy to the requests sent to the upstream of a location,
+// so that clients do not need to hold the credentials of the backend: AWS Signature Version 4, e.g. for S3 or
+// API Gateway backends, or an HMAC signature in the format checked by the hmac middleware.
+//
+// Fields:
+// - Type: The signature (aws-sigv4 or hmac); empty disables the signing.
+// - MaxBodySize: The largest body read to be signed, in bytes; larger requests fail. Defaults to 10MiB.
+// - AWS: The credentials of the AWS signatures.
+// - HMAC: The settings of the HMAC signatures.
+type UpstreamSigning struct {
+	Type        string      `yaml:"type"`
+	MaxBodySize int64       `yaml:"max_body_size"`
+	AWS         AWSSigning  `yaml:"aws"`
+	HMAC        HMACSigning `yaml:"hmac"`
+}
+
+// AWSSigning holds the credentials and the scope of the AWS Signature Version 4.
+type AWSSigning struct {
+	AccessKeyID     string   `yaml:"access_key_id" secret:"true"`     // Access key ID of the credentials.
+	SecretAccessKey string   `yaml:"secret_access_key" secret:"true"` // Secret access key of the credentials.
+	SessionToken    string   `yaml:"session_token" secret:"true"`     // Session token of temporary credentials, if any.
+	Region          string   `yaml:"region"`                          // Region of the backend, e.g. eu-west-1.
+	Service         string   `yaml:"service"`                         // Signing name of the service, e.g. s3 or execute-api.
+	UnsignedPayload bool     `yaml:"unsigned_payload"`                // Leaves the body of S3 requests unsigned, so that it is streamed.
+	AllowedHeaders  []string `yaml:"allowed_headers"`                 // X-Amz-* headers of the clients kept and signed, e.g. X-Amz-Meta-Owner; others are removed.
+}
+
+// HMACSigning holds the HMAC signature of the upstream requests. The body is signed, preceded by the timestamp
+// and the nonce when their headers are set: "<timestamp>.<nonce>.<body>".
+type HMACSigning struct {
+	Secret          string `yaml:"secret" secret:"true"` // Secret shared with the upstream.
+	Algorithm       string `yaml:"algorithm"`            // Hash algorithm (sha1, sha256, or sha512). Defaults to sha256.
+	Encoding        string `yaml:"encoding"`             // Encoding of the signature (hex or base64). Defaults to hex.
+	Header          string `yaml:"header"`               // Header carrying the signature. Defaults to X-Signature.
+	Prefix          string `yaml:"prefix"`               // Text preceding the signature in the header, e.g. "sha256=".
+	TimestampHeader string `yaml:"timestamp_header"`     // Header carrying the time of the signature, in Unix seconds.
+	NonceHeader     string `yaml:"nonce_header"`         // Header carrying a random value per request.
+}
+
+// Defaults of the token introspection of a location.
+const (
+	DefaultIntrospectionTimeout  = 5 * time.Second
+	DefaultIntrospectionCacheTTL = time.Minute
+)
+
+// Introspection holds the validation of the opaque bearer tokens of a location against an OAuth2 introspection
+// endpoint (RFC 7662). The results are cached in Redis when it is enabled, and the claims of the active tokens
+// can be forwarded to the upstream in headers.
+//
+// Fields:
+// - Enabled: Enables/disables the token introspection.
+// - Endpoint: The URL of the introspection endpoint.
+// - ClientID: The client ID authenticating the proxy to the endpoint, with HTTP Basic authentication.
+// - ClientSecret: The client secret authenticating the proxy to the endpoint.
+// - Timeout: The maximum duration of a call to the endpoint. Defaults to 5 seconds.
+// - CacheTTL: How long a result is cached, at most; active tokens are not cached beyond their expiry. Defaults
+// to 1 minute.
+// - RequiredScopes: The scopes the token must all grant; other tokens get 403.
+// - ClaimHeaders: The headers forwarded to the upstream, keyed by claim (e.g. sub: X-User-ID). They are removed
+// from the client requests first, so that clients cannot set them.
+type Introspection struct {
+	Enabled        bool              `yaml:"enabled"`
+	Endpoint       string            `yaml:"endpoint"`
+	ClientID       string            `yaml:"client_id"`
+	ClientSecret   string            `yaml:"client_secret" secret:"true"`
+	Timeout        time.Duration     `yaml:"timeout"`
+	CacheTTL       time.Duration     `yaml:"cache_ttl"`
+	RequiredScopes []string          `yaml:"required_scopes"`
+	ClaimHeaders   map[string]string `yaml:"claim_headers"`
+}
+
+// Quota windows.
+const (
+	QuotaWindowDay   = "day"
+	QuotaWindowMonth = "month"
+)
+
+// Rate limit scopes.
+const (
+	RateLimitScopeLocation = "location" // Each location has its own limits.
+	RateLimitScopeGroup    = "group"    // The locations of a named group share their limits.
+	RateLimitScopeGlobal   = "global"   // All the locations with this scope share their limits.
+)
+
+// RequestBuffering holds the configuration for spooling request bodies before proxying them.
+// A spooled body can be replayed, e.g. when the transport retries a request on a new connection.
+//
+// Fields:
+// - Enabled: Enables/disables request body spooling.
+// - MemoryLimit: The number of bytes kept in memory before spilling to a temporary file. Defaults to 1 MB.
+// - MaxSize: The maximum accepted body size in bytes; larger requests get 413. Zero means unlimited.
+// - TempDir: The directory of the temporary files. Defaults to the system temporary directory.
+type RequestBuffering struct {
+	Enabled     bool   `yaml:"enabled"`
+	MemoryLimit int64  `yaml:"memory_limit"`
+	MaxSize     int64  `yaml:"max_size"`
+	TempDir     string `yaml:"temp_dir"`
+}
+
+// SubFilter holds the configuration of the rewriting of upstream URLs in textual response bodies, for applications
+// that embed their own base URL in their pages. The bodies are rewritten as they stream.
+//
+// Fields:
+// - Enabled: Enables/disables the rewriting.
+// - PublicURL: The replacement of the target URL in the bodies. Defaults to the public prefix of the location, as
+// an absolute path.
+// - Replacements: Additional literal replacements, applied together with the one of the target URL.
+// - ContentTypes: The media types rewritten. Defaults to HTML, CSS, JavaScript, JSON, and XML.
+// - MaxSize: The largest body rewritten, in bytes; responses announcing a larger Content-Length are left
+// untouched. Defaults to 10 MB.
+type SubFilter struct {
+	Enabled      bool          `yaml:"enabled"`
+	PublicURL    string        `yaml:"public_url"`
+	Replacements []Replacement `yaml:"replacements"`
+	ContentTypes []string      `yaml:"content_types"`
+	MaxSize      int64         `yaml:"max_size"`
+}
+
+// Replacement is a literal replacement applied to response bodies.
+type Replacement struct {
+	From string `yaml:"from"` // Text to replace.
+	To   string `yaml:"to"`   // Replacement text.
+}
+
+type Cache struct {
+	Enabled      bool     `yaml:"enabled"`        // Enables/disables caching.
+	TTL          int      `yaml:"ttl"`            // Time to live for cache entries in seconds.
+	NegativeTTL  int      `yaml:"negative_ttl"`   // Time to live in seconds of cached 404 and 5xx responses (0 disables negative caching).
+	Key          CacheKey `yaml:"key"`            // Composition of the cache key.
+	MaxEntrySize int64    `yaml:"max_entry_size"` // Largest response body cached, in bytes (0 means no limit).
+	MaxSize      int64    `yaml:"max_size"`       // Total size of the cached bodies of the location, in bytes; the oldest entries are evicted above it (0 means no limit).
+	Ranges       bool     `yaml:"ranges"`         // Answers Range requests from the cached responses with 206 Partial Content.
+}
+
+// CacheKey selects the parts of a request making up its cache key, on top of the method and path.
+// Without settings, the key is made of the method and the request URI, query string included.
+type CacheKey struct {
+	IncludeQuery []string `yaml:"include_query"` // Only these query parameters are part of the key.
+	ExcludeQuery []string `yaml:"exclude_query"` // These query parameters are left out of the key (e.g. tracking parameters).
+	Headers      []string `yaml:"headers"`       // Request headers part of the key (e.g. Accept-Language).
+	Cookies      []string `yaml:"cookies"`       // Request cookies part of the key.
+	Hash         bool     `yaml:"hash"`          // Stores the key as a SHA-256 hash to keep Redis keys short.
+}
+
+// Logging holds the configuration for logging.
+type Logging struct {
+	Enabled     bool            `yaml:"enabled"`       // Enables/disables logging.
+	Verbose     bool            `yaml:"verbose"`       // Enables/disables verbose logging.
+	Level       string          `yaml:"level"`         // Log level (e.g., debug, info, warn, error).
+	MaxBodySize int64           `yaml:"max_body_size"` // Maximum request body size logged in verbose mode (default 1024 bytes).
+	Sampling    LogSampling     `yaml:"sampling"`      // Selects the requests logged verbosely.
+	Masking     LogMasking      `yaml:"masking"`       // Sensitive data masked in the logs.
+	Queue       LogQueue        `yaml:"queue"`         // Queue and workers writing the request logs.
+	Upstream    UpstreamLogging `yaml:"upstream"`      // Debug logging of the requests sent to the upstreams.
+}
+
+// DefaultUpstreamLogBodySize is the size of the logged sample of the upstream request bodies when none is set.
+const DefaultUpstreamLogBodySize = 1024
+
+// UpstreamLogging holds the debug logging of the requests sent to the upstreams, once the path rewrites and the
+// header rules of their location applied, to diagnose what an upstream actually received. The entries are
+// written at debug level, with the sensitive headers and body fields masked.
+//
+// Fields:
+// - Enabled: Enables/disables the logging of the upstream requests.
+// - MaxBodySize: The size of the logged sample of the request bodies, in bytes. Defaults to 1024.
+type UpstreamLogging struct {
+	Enabled     bool `yaml:"enabled"`
+	MaxBodySize int  `yaml:"max_body_size"`
+}
+
+// LogQueue configures the queue between the request handlers and the workers writing the request logs.
+// It is read once at startup: changes require a restart.
+type LogQueue struct {
+	Size       int    `yaml:"size"`        // Number of entries the queue holds (default 10000).
+	Workers    int    `yaml:"workers"`     // Number of workers writing the logs (default 5).
+	Overflow   string `yaml:"overflow"`    // What to do when the queue is full: "drop" (default), "block", or "sample".
+	SampleRate int    `yaml:"sample_rate"` // With "sample", 1 entry in SampleRate is kept once the queue is half full (default 10).
+}
+
+// LogMasking lists the sensitive data replaced with a placeholder before log entries are written.
+// Leaving a list unset applies its defaults; an empty list disables that kind of masking.
+type LogMasking struct {
+	Headers           []string       `yaml:"headers"`     // Headers whose values are masked, case-insensitively.
+	BodyFields        []string       `yaml:"body_fields"` // JSON fields and XML elements whose values are masked, case-insensitively.
+	CompiledFields    *regexp.Regexp `yaml:"-"`           // Compiled expression matching the masked JSON fields.
+	CompiledXMLFields *regexp.Regexp `yaml:"-"`           // Compiled expression matching the masked XML elements, namespace prefix included.
+}
+
+// Default sensitive data masked in the logs.
+var (
+	DefaultMaskedHeaders    = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-API-Key"}
+	DefaultMaskedBodyFields = []string{"password", "token"}
+)
+
+// LogSampling selects the requests logged in verbose mode; the other requests are logged in compact form.
+// Without any setting every request is logged verbosely.
+type LogSampling struct {
+	Rate          int           `yaml:"rate"`           // Logs 1 request in Rate verbosely. 0 logs every request, unless errors or slow_threshold restrict verbose logging to those requests.
+	Errors        bool          `yaml:"errors"`         // Logs requests answered with an error status (>= 400) verbosely.
+	SlowThreshold time.Duration `yaml:"slow_threshold"` // Logs requests slower than this verbosely (0 disables).
+	MaxPerSecond  float64       `yaml:"max_per_second"` // Caps the number of verbose entries per second (0 means no cap).
+}
+
+// HeaderMatcher restricts a location to requests carrying a header.
+// When neither Value nor Regex is set, the header only needs to be present.
+type HeaderMatcher struct {
+	Name          string         `yaml:"name"`  // Name of the header to match.
+	Value         string         `yaml:"value"` // Exact value the header must have.
+	Regex         string         `yaml:"regex"` // Regular expression the header value must match.
+	CompiledRegex *regexp.Regexp `yaml:"-"`     // Compiled regular expression for the header value.
+}
+
+// Matches reports whether any of the given header values satisfies the matcher.
+//
+// Parameters:
+// - values: The values of the header in the request.
+//
+// Returns:
+// - bool: True if the header matches, false otherwise.
+func (h HeaderMatcher) Matches(values []string) bool {
+	for _, value := range values {
+		switch {
+		case h.CompiledRegex != nil:
+			if h.CompiledRegex.MatchString(value) {
+				return true
+			}
+		case h.Value != "":
+			if value == h.Value {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// CookieConfig holds the cookie rules of a location. Cookie names may end with "*" to match a prefix.
+//
+// Fields:
+// - StripRequest: The cookies removed from the requests before they reach the upstream (e.g. analytics cookies).
+// - StripResponse: The cookies whose Set-Cookie headers are removed from the upstream responses.
+// - Rename: The upstream names of cookies mapped to the names seen by the clients; request cookies are renamed back.
+// - Secure: Adds the Secure attribute to the cookies set by the upstream.
+// - HTTPOnly: Adds the HttpOnly attribute to the cookies set by the upstream.
+// - SameSite: Sets the SameSite attribute of the cookies set by the upstream (Lax, Strict, or None, which also
+// adds Secure as browsers require). Empty keeps the attribute of the upstream.
+// - RewriteDomain: Rewrites the Domain attribute naming the target host to the host requested by the client.
+// - RewritePath: Rewrites the Path attribute under the target path to the public prefix of the location.
+type CookieConfig struct {
+	StripRequest  []string          `yaml:"strip_request"`
+	StripResponse []string          `yaml:"strip_response"`
+	Rename        map[string]string `yaml:"rename"`
+	Secure        bool              `yaml:"secure"`
+	HTTPOnly      bool              `yaml:"http_only"`
+	SameSite      string            `yaml:"same_site"`
+	RewriteDomain bool              `yaml:"rewrite_domain"`
+	RewritePath   bool              `yaml:"rewrite_path"`
+}
+
+// SameSite attribute values of the cookie rules.
+const (
+	SameSiteLax    = "Lax"
+	SameSiteStrict = "Strict"
+	SameSiteNone   = "None"
+)
+
+// ContentTypeRules holds the content type rules of a location: the media types accepted in request bodies,
+// and the overrides of the Content-Type of misbehaving upstreams.
+//
+// Fields:
+// - Allowed: The media types accepted in request bodies, such as application/json or image/*. Other requests
+// with a body get 415, as do multipart file parts of another type when the body is buffered. Empty accepts any type.
+// - Sniff: Rejects with 415 the request bodies whose content contradicts their declared type, such as HTML
+// uploaded as image/png. With request buffering, the file parts of multipart bodies are checked as well.
+// - NoSniff: Adds X-Content-Type-Options: nosniff to the responses, so that browsers trust the Content-Type.
+// - Overrides: Replacements of the Content-Type of the upstream responses; the first matching one applies.
+type ContentTypeRules struct {
+	Allowed   []string              `yaml:"allowed"`
+	Sniff     bool                  `yaml:"sniff"`
+	NoSniff   bool                  `yaml:"nosniff"`
+	Overrides []ContentTypeOverride `yaml:"overrides"`
+}
+
+// ContentTypeOverride replaces the Content-Type of upstream responses, e.g. to serve scripts sent as
+// text/plain with a JavaScript type.
+type ContentTypeOverride struct {
+	Path         string         `yaml:"path"` // Regex of the request paths the override applies to. Empty matches any path.
+	From         string         `yaml:"from"` // Media type sent by the upstream, "none" for a missing Content-Type. Empty matches any type.
+	To           string         `yaml:"to"`   // Content-Type sent to the client instead.
+	CompiledPath *regexp.Regexp `yaml:"-"`    // Compiled expression of the path.
+}
+
+// ContentTypeNone matches the upstream responses without a Content-Type in a content type override.
+const ContentTypeNone = "none"
+
+// StatusRewrite replaces an upstream status code before the response is sent to the client, e.g. to map
+// 404 to 204 for a legacy client. The standard reason phrase of the new code is sent.
+type StatusRewrite struct {
+	From int `yaml:"from"` // Status code returned by the upstream.
+	To   int `yaml:"to"`   // Status code sent to the client instead.
+}
+
+// Fallback holds the target requests are forwarded to when no location matches them or, for a location, when
+// its upstream answers a fallback status: e.g. the index.html of a single-page application, whose client-side
+// router handles the path.
+//
+// Fields:
+// - TargetURL: The URL the requests are forwarded to. Empty disables the fallback.
+// - ReplacePath: Replaces the request path with the path of the target URL, e.g. /index.html, instead of
+// appending it.
+// - Statuses: The upstream status codes falling back to the target, for GET and HEAD requests (locations only).
+// Defaults to 404.
+type Fallback struct {
+	TargetURL   string `yaml:"target_url"`
+	ReplacePath bool   `yaml:"replace_path"`
+	Statuses    []int  `yaml:"statuses"`
+}
+
+// Defaults of the mirror of a location.
+const (
+	DefaultMirrorTimeout     = 10 * time.Second // Maximum time of a mirrored request.
+	DefaultMirrorMaxBodySize = 1 << 20          // Maximum size of a request body copied to the mirror (1 MiB).
+)
+
+// Mirror holds the shadow upstream receiving a copy of the requests of a location, e.g. a rewritten backend
+// validated against the production traffic. The mirrored requests are sent in the background and their
+// responses are discarded: the client always gets the response of the primary upstream.
+//
+// Fields:
+// - TargetURL: The URL of the shadow upstream, whose path is built as the one of the primary. Empty disables
+// the mirror.
+// - Percentage: The percentage of the requests mirrored, up to 100 (0 defaults to 100).
+// - Timeout: The maximum time of a mirrored request (default 10s).
+// - MaxBodySize: The maximum size of a request body copied to the mirror, in bytes; larger requests are not
+// mirrored (default 1 MiB).
+// - Compare: The comparison of the mirrored responses with the primary ones.
+type Mirror struct {
+	TargetURL   string        `yaml:"target_url"`
+	Percentage  float64       `yaml:"percentage"`
+	Timeout     time.Duration `yaml:"timeout"`
+	MaxBodySize int64         `yaml:"max_body_size"`
+	Compare     MirrorCompare `yaml:"compare"`
+}
+
+// MirrorCompare holds the comparison of the responses of the mirror with the ones of the primary upstream, as
+// they are received before any rewrite. Mismatches are counted by the metrics and logged at debug level.
+//
+// Fields:
+// - Enabled: Enables the comparison.
+// - Percentage: The percentage of the mirrored requests whose responses are compared, up to 100 (0 defaults to
+// 100).
+// - MaxBodySize: The maximum size of the compared bodies, in bytes; larger responses are compared by status
+// only (default 1 MiB).
+// - IgnoreFields: The fields of JSON bodies ignored at any depth, such as timestamps or request IDs. JSON bodies
+// are compared by value, so the order of their keys does not matter.
+type MirrorCompare struct {
+	Enabled      bool     `yaml:"enabled"`
+	Percentage   float64  `yaml:"percentage"`
+	MaxBodySize  int64    `yaml:"max_body_size"`
+	IgnoreFields []string `yaml:"ignore_fields"`
+}
+
+// Label returns the name of the location, or its path pattern when it has none. It labels the location in the
+// logs, the metrics, the admin API, and the errors.
+//
+// Returns:
+// - string: The label of the location.
+func (l *LocationConfig) Label() string {
+	if l.Name != "" {
+		return l.Name
+	}
+	return l.Path
+}
+
+// TransformConfig references a registered response body transform and its options.
+type TransformConfig = transform.Config
+
+// LocationConfig holds the configuration for a specific location.
+type LocationConfig struct {
+	Path                string              `yaml:"path"`    // Path the proxy will respond to.
+	Name                string              `yaml:"name"`    // Stable name labeling the location in logs, metrics, and errors instead of its path.
+	Policy              string              `yaml:"policy"`  // Name of the policy profile whose settings this location inherits.
+	OpenAPI             string              `yaml:"openapi"` // OpenAPI document whose paths expand this location into one location per path.
+	CompiledRegex       *regexp.Regexp      // Compiled regular expression for the path.
+	EnableWebsocket     bool                `yaml:"enable_websocket"`     // Enables/disables WebSocket for this location.
+	WebSocket           WebSocketConfig     `yaml:"websocket"`            // Settings of the proxied WebSocket sessions.
+	KeepaliveInterval   time.Duration       `yaml:"keepalive_interval"`   // Silence after which WebSocket pings or SSE heartbeats are sent to the client.
+	Methods             []string            `yaml:"methods"`              // HTTP methods this location matches. Empty matches any method.
+	AllowedMethods      []string            `yaml:"allowed_methods"`      // HTTP methods accepted once matched; others get 405. Empty allows any method.
+	MatchHeaders        []HeaderMatcher     `yaml:"match_headers"`        // Headers the request must carry to match this location.
+	MatchCountries      []string            `yaml:"match_countries"`      // ISO codes of the client countries this location matches. Empty matches any country.
+	Priority            int                 `yaml:"priority"`             // Locations with a higher priority are evaluated first (default 0).
+	TargetURL           string              `yaml:"target_url"`           // Destination URL for this location.
+	ReplacePath         bool                `yaml:"replace_path"`         // Whether to replace the path entirely.
+	PreserveHost        bool                `yaml:"preserve_host"`        // Whether to forward the client's Host header instead of the target host.
+	RewriteRedirects    bool                `yaml:"rewrite_redirects"`    // Whether to map Location and Content-Location headers from the target URL space to the public one.
+	ServerTiming        bool                `yaml:"server_timing"`        // Whether to report the upstream timings in a Server-Timing response header.
+	Deadline            DeadlineConfig      `yaml:"deadline"`             // Request deadline and its propagation to the upstream.
+	AdditionalHeaders   map[string]string   `yaml:"additional_headers"`   // Additional headers to add for this location.
+	ExcludedHeaders     []string            `yaml:"excluded_headers"`     // Headers to exclude for this location.
+	Cookies             CookieConfig        `yaml:"cookies"`              // Cookie rules applied in both directions.
+	Credentials         UpstreamCredentials `yaml:"credentials"`          // Credentials added to the requests sent to the upstream.
+	Signing             UpstreamSigning     `yaml:"signing"`              // Signature added to the requests sent to the upstream.
+	Middlewares         []string            `yaml:"middlewares"`          // List of middlewares to apply for this location.
+	RateLimiting        RateLimiting        `yaml:"rate_limiting"`        // Rate Limiting configuration.
+	ConcurrencyLimit    ConcurrencyLimit    `yaml:"concurrency_limit"`    // Distributed limit of in-flight requests.
+	SpikeArrest         SpikeArrest         `yaml:"spike_arrest"`         // Traffic shaping smoothing request bursts.
+	Quota               Quota               `yaml:"quota"`                // Long-window request quota per API key.
+	Introspection       Introspection       `yaml:"introspection"`        // Validation of opaque bearer tokens by an OAuth2 introspection endpoint.
+	HMAC                HMACVerification    `yaml:"hmac"`                 // Verification of the HMAC signatures of the requests, e.g. of webhooks.
+	AdaptiveConcurrency AdaptiveConcurrency `yaml:"adaptive_concurrency"` // Concurrency limit adapting to the upstream latency.
+	BandwidthLimit      BandwidthLimit      `yaml:"bandwidth_limit"`      // Egress bandwidth limit of the response bodies.
+	Validation          RequestValidation   `yaml:"validation"`           // Validation of the requests against an OpenAPI document or a JSON schema.
+	GraphQL             GraphQL             `yaml:"graphql"`              // GraphQL operation analysis and limits.
+	CSRF                CSRF                `yaml:"csrf"`                 // Double-submit-cookie CSRF protection.
+	Session             Session             `yaml:"session"`              // Server-side sessions stored in Redis.
+	Geo                 GeoRules            `yaml:"geo"`                  // Country allow and deny lists.
+	BotFilter           BotFilter           `yaml:"bot_filter"`           // Blocking or tarpitting of bots by User-Agent and missing headers.
+	FaultInjection      FaultInjection      `yaml:"fault_injection"`      // Latency and failures injected to test the resilience of clients.
+	Recording           Recording           `yaml:"recording"`            // Capture of the exchanges, to replay them against a backend.
+	SLO                 SLO                 `yaml:"slo"`                  // Latency and error-rate objectives raising alerts when missed.
+	EnableCompression   bool                `yaml:"enable_compression"`   // Flag to enable Gzip Compression.
+	Cache               Cache               `yaml:"cache"`                // Cache configuration.engin
+	Transport           *TransportConfig    `yaml:"transport"`            // Optional Transport configuration for this location.
+	StatusRewrites      []StatusRewrite     `yaml:"status_rewrites"`      // Rewrites of the upstream status codes.
+	Fallback            Fallback            `yaml:"fallback"`             // Target of the requests whose upstream answers a fallback status.
+	Mirror              Mirror              `yaml:"mirror"`               // Shadow upstream receiving a copy of the requests, with response comparison.
+	ContentType         ContentTypeRules    `yaml:"content_type"`         // Request content type checks and response Content-Type overrides.
+	SubFilter           SubFilter           `yaml:"sub_filter"`           // Rewriting of the upstream URLs in textual response bodies.
+	ResponseTransforms  []TransformConfig   `yaml:"response_transforms"`  // Streaming transforms applied to response bodies, in order.
+	RequestBuffering    RequestBuffering    `yaml:"request_buffering"`    // Request body spooling, so the body can be replayed.
+}
+
+// Deadline header formats, deciding how the remaining time is written.
+const (
+	DeadlineFormatMilliseconds = "milliseconds" // Whole milliseconds, e.g. X-Request-Timeout-Ms: 2500.
+	DeadlineFormatSeconds      = "seconds"      // Seconds with a millisecond fraction, e.g. X-Request-Timeout: 2.500.
+	DeadlineFormatGRPC         = "grpc"         // The gRPC timeout format, e.g. grpc-timeout: 2500m.
+)
+
+// DeadlineConfig holds the deadline of the requests of a location and how it is propagated to the upstream,
+// so that backends can stop working on requests the proxy has already abandoned.
+//
+// Fields:
+// - Timeout: The maximum time to proxy a request; the upstream request is canceled once it elapses and the
+// client gets a 504 (0 keeps the deadline of the client connection only).
+// - Headers: The headers carrying the time remaining until the deadline to the upstream. They are set only
+// when the request has a deadline, and replace the values sent by the client.
+type DeadlineConfig struct {
+	Timeout time.Duration    `yaml:"timeout"`
+	Headers []DeadlineHeader `yaml:"headers"`
+}
+
+// DeadlineHeader is a header carrying the remaining deadline to the upstream.
+type DeadlineHeader struct {
+	Name   string `yaml:"name"`   // Name of the header, e.g. X-Request-Timeout-Ms or grpc-timeout.
+	Format string `yaml:"format"` // Format of the value (milliseconds, seconds, or grpc). Defaults to milliseconds.
+}
+
+// WebSocketConfig holds the settings of the WebSocket sessions proxied by a location.
+//
+// Fields:
+// - MessagesPerSecond: The sustained rate of messages a client may send; faster clients are disconnected with
+// close code 1008 (0 means unlimited).
+// - MessageBurst: The number of client messages allowed at once above the rate. Defaults to 1.
+// - AllowedOrigins: The origins allowed to open sessions: "*", exact origins, or subdomain wildcards
+// (https://*.example.com). Empty allows only same-origin browser requests.
+// - WriteTimeout: The maximum time to forward a message to a peer; a peer not reading is disconnected.
+// Defaults to 10s.
+type WebSocketConfig struct {
+	MessagesPerSecond float64       `yaml:"messages_per_second"`
+	MessageBurst      int           `yaml:"message_burst"`
+	AllowedOrigins    []string      `yaml:"allowed_origins"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+}
+
+var currentConfig atomic.Value
+
+// profilingForced turns on the profiling of every configuration loaded, for the deprecated -enable-profiler flag.
+var profilingForced atomic.Bool
+
+// ForceProfiling turns on admin.profiling.enabled in every configuration loaded from now on, including the
+// hot reloads, which then fail if the admin API is enabled without a token. Unless configured, the mutex and block profiles record every event, as the deprecated
+// -enable-profiler flag did.
+func ForceProfiling() {
+	profilingForced.Store(true)
+}
+
+// LoadConfiguration loads the proxy configuration from a YAML file.
+//
+// Parameters:
+// - file: The path to the configuration file.
+//
+// Returns:
+// - *ProxyConfig: A pointer to the loaded ProxyConfig.
+// - error: An error if the configuration could not be loaded.
+func LoadConfiguration(file string) (*ProxyConfig, error) {
+	var config ProxyConfig
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// The read timeout is set before decoding, so that an explicit 0 disables it instead of selecting the default.
+	config.Server.ReadTimeout = DefaultReadTimeout
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	if err = applyPolicies(data, &config); err != nil {
+		return nil, err
+	}
+	if err = applyOpenAPI(&config); err != nil {
+		return nil, err
+	}
+	if err = resolveSecrets(&config); err != nil {
+		return nil, err
+	}
+
+	applyServerDefaults(&config.Server)
+	if config.Admin.Address == "" {
+		config.Admin.Address = DefaultAdminAddress
+	}
+	if profilingForced.Load() {
+		profiling := &config.Admin.Profiling
+		profiling.Enabled = true
+		if profiling.MutexProfileFraction == 0 {
+			profiling.MutexProfileFraction = 1
+		}
+		if profiling.BlockProfileRate == 0 {
+			profiling.BlockProfileRate = 1
+		}
+	}
+	// The profiles expose the command line and the memory of the process, secrets included.
+	if config.Admin.Enabled && config.Admin.Profiling.Enabled && config.Admin.Token == "" {
+		return nil, fmt.Errorf("admin.profiling requires an admin.token")
+	}
+	if config.Audit.File == "" {
+		config.Audit.File = DefaultAuditFile
+	}
+	if err = applyMaskingDefaults(&config.Logging.Masking); err != nil {
+		return nil, err
+	}
+	if config.Logging.Upstream.MaxBodySize <= 0 {
+		config.Logging.Upstream.MaxBodySize = DefaultUpstreamLogBodySize
+	}
+
+	switch config.Startup.Policy {
+	case "":
+		config.Startup.Policy = StartupPolicyFailFast
+	case StartupPolicyFailFast, StartupPolicyDegraded:
+	default:
+		return nil, fmt.Errorf("unknown startup policy %q", config.Startup.Policy)
+	}
+
+	switch config.Routing.Order {
+	case "":
+		config.Routing.Order = RoutingFirstMatch
+	case RoutingFirstMatch, RoutingLongestPrefix:
+	default:
+		return nil, fmt.Errorf("unknown routing order %q", config.Routing.Order)
+	}
+
+	switch config.Metrics.PathLabel.Mode {
+	case "":
+		config.Metrics.PathLabel.Mode = PathLabelLocation
+	case PathLabelLocation, PathLabelNormalized:
+	default:
+		return nil, fmt.Errorf("unknown metrics path label mode %q", config.Metrics.PathLabel.Mode)
+	}
+	for i, rule := range config.Metrics.PathLabel.Rules {
+		regex, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metrics path rule %q: %v", rule.Pattern, err)
+		}
+		config.Metrics.PathLabel.Rules[i].CompiledRegex = regex
+	}
+
+	switch config.Metrics.StatsD.Flavor {
+	case "":
+		config.Metrics.StatsD.Flavor = StatsDFlavorStatsD
+	case StatsDFlavorStatsD, StatsDFlavorDogStatsD:
+	default:
+		return nil, fmt.Errorf("unknown StatsD flavor %q", config.Metrics.StatsD.Flavor)
+	}
+
+	for _, proxy := range config.Forwarding.TrustedProxies {
+		prefix, err := parsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", proxy, err)
+		}
+		config.Forwarding.CompiledTrustedProxies = append(config.Forwarding.CompiledTrustedProxies, prefix)
+	}
+
+	if config.Honeypot.Enabled {
+		if err = compileHoneypot(&config.Honeypot); err != nil {
+			return nil, fmt.Errorf("honeypot: %v", err)
+		}
+	}
+
+	if err = validateFallback(&config.Fallback); err != nil {
+		return nil, fmt.Errorf("fallback: %v", err)
+	}
+
+	if config.Echo.Enabled {
+		if config.Echo.Path == "" {
+			config.Echo.Path = DefaultEchoPath
+		}
+		if !strings.HasPrefix(config.Echo.Path, "/") || config.Echo.Path == "/" {
+			return nil, fmt.Errorf("echo: path %s must start with / and not be the root", config.Echo.Path)
+		}
+		config.Echo.Path = strings.TrimSuffix(config.Echo.Path, "/")
+	}
+
+	if config.GeoIP.Enabled {
+		if config.GeoIP.CountryDatabase == "" && config.GeoIP.ASNDatabase == "" {
+			return nil, fmt.Errorf("geoip requires a country or an ASN database")
+		}
+		if config.GeoIP.DB, err = geoip.OpenDB(config.GeoIP.CountryDatabase, config.GeoIP.ASNDatabase); err != nil {
+			return nil, fmt.Errorf("error loading the GeoIP databases: %v", err)
+		}
+	}
+
+	if err = validateStreams(config.Streams); err != nil {
+		return nil, err
+	}
+	if err = validateRateLimitScopes(config.Locations); err != nil {
+		return nil, err
+	}
+	if err = validateQuotas(config.Locations); err != nil {
+		return nil, err
+	}
+
+	validators := make(map[string]*openapi.Validator)
+	names := make(map[string]bool)
+	for i, location := range config.Locations {
+		regex, err := regexp.Compile(location.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling regex for path %s: %v", location.Path, err)
+		}
+		config.Locations[i].CompiledRegex = regex
+
+		if location.Name != "" {
+			if names[location.Name] {
+				return nil, fmt.Errorf("location %s: duplicate location name", location.Name)
+			}
+			names[location.Name] = true
+		}
+
+		if location.Validation.Enabled {
+			validator, err := loadValidator(location, validators)
+			if err != nil {
+				return nil, fmt.Errorf("validation for path %s: %v", location.Label(), err)
+			}
+			config.Locations[i].Validation.Validator = validator
+		}
+
+		for j, method := range location.Methods {
+			config.Locations[i].Methods[j] = strings.ToUpper(method)
+		}
+		for j, method := range location.AllowedMethods {
+			config.Locations[i].AllowedMethods[j] = strings.ToUpper(method)
+		}
+
+		if (len(location.MatchCountries) > 0 || len(location.Geo.AllowCountries) > 0 || len(location.Geo.DenyCountries) > 0) && (!config.GeoIP.Enabled || config.GeoIP.CountryDatabase == "") {
+			return nil, fmt.Errorf("location %s: country rules require geoip with a country database", location.Label())
+		}
+		upperCountries(location.MatchCountries)
+		upperCountries(location.Geo.AllowCountries)
+		upperCountries(location.Geo.DenyCountries)
+
+		for j, matcher := range location.MatchHeaders {
+			if matcher.Regex == "" {
+				continue
+			}
+			headerRegex, err := regexp.Compile(matcher.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("error compiling header regex for %s in path %s: %v", matcher.Name, location.Label(), err)
+			}
+			config.Locations[i].MatchHeaders[j].CompiledRegex = headerRegex
+		}
+
+		if config.Locations[i].Cookies.SameSite, err = parseSameSite(location.Cookies.SameSite); err != nil {
+			return nil, fmt.Errorf("location %s: %v", location.Label(), err)
+		}
+
+		if err = validateBandwidthLimit(location); err != nil {
+			return nil, err
+		}
+		if slotTimeout := location.ConcurrencyLimit.SlotTimeout; location.ConcurrencyLimit.Enabled && slotTimeout != 0 && slotTimeout < MinSlotTimeout {
+			return nil, fmt.Errorf("location %s: concurrency_limit.slot_timeout %s must be at least %s", location.Label(), slotTimeout, MinSlotTimeout)
+		}
+
+		if location.CSRF.Enabled {
+			if err := compileCSRF(&config.Locations[i].CSRF); err != nil {
+				return nil, fmt.Errorf("csrf for path %s: %v", location.Label(), err)
+			}
+		}
+
+		// The transforms are built again by the application; building them here rejects unknown names and
+		// invalid options when the configuration is loaded rather than on each request.
+		if _, err := transform.Build(location.ResponseTransforms); err != nil {
+			return nil, fmt.Errorf("location %s: %v", location.Label(), err)
+		}
+
+		if location.Session.Enabled {
+			if err := validateSession(&config.Locations[i].Session); err != nil {
+				return nil, fmt.Errorf("location %s: session: %v", location.Label(), err)
+			}
+		}
+
+		if location.BotFilter.Enabled {
+			if err := compileBotFilter(&config.Locations[i].BotFilter); err != nil {
+				return nil, fmt.Errorf("bot filter for path %s: %v", location.Label(), err)
+			}
+		}
+
+		if location.FaultInjection.Enabled {
+			if err := validateFaultInjection(location.FaultInjection); err != nil {
+				return nil, fmt.Errorf("location %s: fault injection: %v", location.Label(), err)
+			}
+		}
+
+		if location.Recording.Enabled {
+			if err := validateRecording(&config.Locations[i].Recording); err != nil {
+				return nil, fmt.Errorf("location %s: recording: %v", location.Label(), err)
+			}
+		}
+
+		if location.Introspection.Enabled {
+			if err := validateIntrospection(&config.Locations[i].Introspection); err != nil {
+				return nil, fmt.Errorf("location %s: introspection: %v", location.Label(), err)
+			}
+		}
+
+		if location.HMAC.Enabled {
+			if err := validateHMAC(&config.Locations[i].HMAC); err != nil {
+				return nil, fmt.Errorf("location %s: hmac: %v", location.Label(), err)
+			}
+		}
+
+		if location.Credentials.Type != "" {
+			if err := validateCredentials(location.Credentials, location.Signing); err != nil {
+				return nil, fmt.Errorf("location %s: credentials: %v", location.Label(), err)
+			}
+		}
+
+		if location.Signing.Type != "" {
+			if err := validateSigning(&config.Locations[i].Signing); err != nil {
+				return nil, fmt.Errorf("location %s: signing: %v", location.Label(), err)
+			}
+		}
+
+		if location.SLO.Enabled {
+			if err := validateSLO(&config.Locations[i].SLO); err != nil {
+				return nil, fmt.Errorf("location %s: slo: %v", location.Label(), err)
+			}
+		}
+
+		for _, replacement := range location.SubFilter.Replacements {
+			if replacement.From == "" {
+				return nil, fmt.Errorf("location %s: sub filter replacement requires a text to replace", location.Label())
+			}
+		}
+
+		for _, rewrite := range location.StatusRewrites {
+			if rewrite.From < 200 || rewrite.From > 599 || rewrite.To < 200 || rewrite.To > 599 {
+				return nil, fmt.Errorf("location %s: status rewrite %d -> %d must use codes between 200 and 599", location.Label(), rewrite.From, rewrite.To)
+			}
+		}
+
+		if err := validateFallback(&config.Locations[i].Fallback); err != nil {
+			return nil, fmt.Errorf("location %s: fallback: %v", location.Label(), err)
+		}
+
+		if err := validateMirror(&config.Locations[i].Mirror); err != nil {
+			return nil, fmt.Errorf("location %s: mirror: %v", location.Label(), err)
+		}
+
+		for j, allowed := range location.ContentType.Allowed {
+			config.Locations[i].ContentType.Allowed[j] = strings.ToLower(allowed)
+		}
+		for j, override := range location.ContentType.Overrides {
+			if override.To == "" {
+				return nil, fmt.Errorf("location %s: content type override requires a type to set", location.Label())
+			}
+			if override.Path == "" {
+				continue
+			}
+			pathRegex, err := regexp.Compile(override.Path)
+			if err != nil {
+				return nil, fmt.Errorf("error compiling content type override path %s in path %s: %v", override.Path, location.Label(), err)
+			}
+			config.Locations[i].ContentType.Overrides[j].CompiledPath = pathRegex
+		}
+
+		for j, header := range location.Deadline.Headers {
+			if header.Name == "" {
+				return nil, fmt.Errorf("location %s: deadline header requires a name", location.Label())
+			}
+			switch header.Format {
+			case "":
+				config.Locations[i].Deadline.Headers[j].Format = DeadlineFormatMilliseconds
+			case DeadlineFormatMilliseconds, DeadlineFormatSeconds, DeadlineFormatGRPC:
+			default:
+				return nil, fmt.Errorf("location %s: unknown deadline header format %q", location.Label(), header.Format)
+			}
+		}
+
+		if location.Transport == nil {
+			config.Locations[i].Transport = &config.Transport
+		}
+	}
+
+	return &config, nil
+}
+
+// upperCountries uppercases country codes in place, as the databases hold them.
+func upperCountries(countries []string) {
+	for i, country := range countries {
+		countries[i] = strings.ToUpper(country)
+	}
+}
+
+// parsePrefix parses an address or a CIDR range; a single address is a range of one address.
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// parseSameSite returns the canonical form of a SameSite attribute value, matched case-insensitively.
+//
+// Parameters:
+// - value: The configured value, empty to keep the default.
+//
+// Returns:
+// - string: Lax, Strict, None, or empty.
+// - error: An error if the value is unknown.
+func parseSameSite(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "":
+		return "", nil
+	case "lax":
+		return SameSiteLax, nil
+	case "strict":
+		return SameSiteStrict, nil
+	case "none":
+		return SameSiteNone, nil
+	}
+	return "", fmt.Errorf("unknown cookie SameSite value %q", value)
+}
+
+// compileCSRF applies the defaults of a CSRF configuration and compiles its exempt paths.
+//
+// Parameters:
+// - csrf: The CSRF configuration, updated in place.
+//
+// Returns:
+// - error: An error if the SameSite value is unknown or an exempt path is not a valid regular expression.
+func compileCSRF(csrf *CSRF) error {
+	if csrf.CookieName == "" {
+		csrf.CookieName = DefaultCSRFCookieName
+	}
+	if csrf.HeaderName == "" {
+		csrf.HeaderName = DefaultCSRFHeaderName
+	}
+	sameSite, err := parseSameSite(csrf.SameSite)
+	if err != nil {
+		return err
+	}
+	if csrf.SameSite = sameSite; sameSite == "" {
+		csrf.SameSite = SameSiteLax
+	}
+	csrf.CompiledExemptPaths = make([]*regexp.Regexp, len(csrf.ExemptPaths))
+	for i, path := range csrf.ExemptPaths {
+		regex, err := regexp.Compile(path)
+		if err != nil {
+			return fmt.Errorf("error compiling exempt path %s: %v", path, err)
+		}
+		csrf.CompiledExemptPaths[i] = regex
+	}
+	return nil
+}
+
+// validateFallback checks the target of a fallback and applies its default statuses.
+//
+// Parameters:
+// - fallback: The fallback configuration, updated in place.
+//
+// Returns:
+// - error: An error if the target URL is not absolute or a status is not an error code.
+func validateFallback(fallback *Fallback) error {
+	if fallback.TargetURL == "" {
+		return nil
+	}
+	target, err := url.Parse(fallback.TargetURL)
+	if err != nil {
+		return fmt.Errorf("invalid target URL: %v", err)
+	}
+	if target.Scheme == "" || target.Host == "" {
+		return fmt.Errorf("target URL %s must be absolute", fallback.TargetURL)
+	}
+	if len(fallback.Statuses) == 0 {
+		fallback.Statuses = []int{http.StatusNotFound}
+	}
+	for _, status := range fallback.Statuses {
+		if status < 400 || status > 599 {
+			return fmt.Errorf("status %d must be between 400 and 599", status)
+		}
+	}
+	return nil
+}
+
+// validateMirror checks the target of a mirror and applies its defaults.
+//
+// Parameters:
+// - mirror: The mirror configuration, updated in place.
+//
+// Returns:
+// - error: An error if the target URL is not absolute or a percentage is out of range.
+func validateMirror(mirror *Mirror) error {
+	if mirror.TargetURL == "" {
+		return nil
+	}
+	target, err := url.Parse(mirror.TargetURL)
+	if err != nil {
+		return fmt.Errorf("invalid target URL: %v", err)
+	}
+	if target.Scheme == "" || target.Host == "" {
+		return fmt.Errorf("target URL %s must be absolute", mirror.TargetURL)
+	}
+	for _, percentage := range []*float64{&mirror.Percentage, &mirror.Compare.Percentage} {
+		if *percentage < 0 || *percentage > 100 {
+			return fmt.Errorf("percentage %g must be between 0 and 100", *percentage)
+		}
+		if *percentage == 0 {
+			*percentage = 100
+		}
+	}
+	if mirror.Timeout <= 0 {
+		mirror.Timeout = DefaultMirrorTimeout
+	}
+	if mirror.MaxBodySize <= 0 {
+		mirror.MaxBodySize = DefaultMirrorMaxBodySize
+	}
+	if mirror.Compare.MaxBodySize <= 0 {
+		mirror.Compare.MaxBodySize = DefaultMirrorMaxBodySize
+	}
+	return nil
+}
+
+// compileHoneypot applies the defaults of the honeypot and compiles its attack paths.
+//
+// Parameters:
+// - honeypot: The honeypot configuration, updated in place.
+//
+// Returns:
+// - error: An error if the response is unknown or a path is not a valid regular expression.
+func compileHoneypot(honeypot *HoneypotConfig) error {
+	switch honeypot.Response {
+	case "":
+		honeypot.Response = HoneypotResponseNotFound
+	case HoneypotResponseNotFound, HoneypotResponseJunk:
+	default:
+		return fmt.Errorf("unknown response %q (expected not_found or junk)", honeypot.Response)
+	}
+	if honeypot.Delay < 0 {
+		return fmt.Errorf("negative delay %v", honeypot.Delay)
+	}
+	if honeypot.JunkSize <= 0 {
+		honeypot.JunkSize = DefaultHoneypotJunkSize
+	}
+	if honeypot.MaxTrapped <= 0 {
+		honeypot.MaxTrapped = DefaultMaxTrapped
+	}
+	if len(honeypot.Paths) == 0 {
+		honeypot.Paths = DefaultHoneypotPaths
+	}
+	honeypot.CompiledPaths = make([]*regexp.Regexp, len(honeypot.Paths))
+	for i, path := range honeypot.Paths {
+		regex, err := regexp.Compile(path)
+		if err != nil {
+			return fmt.Errorf("error compiling path %s: %v", path, err)
+		}
+		honeypot.CompiledPaths[i] = regex
+	}
+	return nil
 }
 
-type TransportConfig struct {
-	HTTP HTTPTransportConfig `yaml:"http"`
+// validateSession checks the sessions of a location and sets their defaults.
+//
+// Parameters:
+// - session: The session configuration, updated in place.
+//
+// Returns:
+// - error: An error if the secret is too short or the SameSite value is unknown.
+func validateSession(session *Session) error {
+	if len(session.Secret) < minSessionSecretSize {
+		return fmt.Errorf("the secret must be at least %d bytes long", minSessionSecretSize)
+	}
+	if session.CookieName == "" {
+		session.CookieName = DefaultSessionCookieName
+	}
+	if session.TTL <= 0 {
+		session.TTL = DefaultSessionTTL
+	}
+	sameSite, err := parseSameSite(session.SameSite)
+	if err != nil {
+		return err
+	}
+	if session.SameSite = sameSite; sameSite == "" {
+		session.SameSite = SameSiteLax
+	}
+	for key, header := range session.ResponseHeaders {
+		if key == "" || header == "" {
+			return fmt.Errorf("response_headers: %q: the session key and the header must not be empty", key)
+		}
+	}
+	return nil
 }
 
-// MetricsConfig holds the configuration for the metrics server.
-type MetricsConfig struct {
-	Enabled bool   `yaml:"enabled"` // Enables/disables the metrics server.
-	Path    string `yaml:"path"`    // Path the metrics server will respond to.
+// compileBotFilter applies the defaults of a bot filter and compiles its User-Agent expressions, which match
+// regardless of case.
+//
+// Parameters:
+// - botFilter: The bot filter configuration, updated in place.
+//
+// Returns:
+// - error: An error if the action is unknown or an expression is not valid.
+func compileBotFilter(botFilter *BotFilter) error {
+	switch botFilter.Action {
+	case "":
+		botFilter.Action = BotActionBlock
+	case BotActionBlock, BotActionTarpit:
+	default:
+		return fmt.Errorf("unknown action %q (expected block or tarpit)", botFilter.Action)
+	}
+	if botFilter.TarpitDelay <= 0 {
+		botFilter.TarpitDelay = DefaultTarpitDelay
+	}
+	if botFilter.MaxTarpitted <= 0 {
+		botFilter.MaxTarpitted = DefaultMaxTrapped
+	}
+	compile := func(patterns []string) ([]*regexp.Regexp, error) {
+		compiled := make([]*regexp.Regexp, len(patterns))
+		for i, pattern := range patterns {
+			regex, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				return nil, fmt.Errorf("error compiling user agent pattern %s: %v", pattern, err)
+			}
+			compiled[i] = regex
+		}
+		return compiled, nil
+	}
+	var err error
+	if botFilter.CompiledBlockUserAgents, err = compile(botFilter.BlockUserAgents); err != nil {
+		return err
+	}
+	botFilter.CompiledAllowUserAgents, err = compile(botFilter.AllowUserAgents)
+	return err
 }
 
-// ProxyConfig holds the configuration for the proxy server.
-type ProxyConfig struct {
-	Port      string           `yaml:"port"`       // Port the proxy will listen on.
-	HotReload bool             `yaml:"hot_reload"` // Enables/disables hot reloading.
-	Logging   Logging          `yaml:"logging"`    // Logging configuration.
-	Redis     RedisConfig      `yaml:"redis"`      // Redis configuration.
-	Metrics   MetricsConfig    `yaml:"metrics"`    // Metrics configuration.
-	Locations []LocationConfig `yaml:"locations"`  // List of configurations for each location.
-	Transport TransportConfig  `yaml:"transport"`  // Transport configuration.
+// validateFaultInjection checks the percentages, the delay, and the status of the injected faults.
+//
+// Parameters:
+// - faults: The fault injection configuration.
+//
+// Returns:
+// - error: An error if a percentage is out of range, a delay has no duration, or the status is not valid.
+func validateFaultInjection(faults FaultInjection) error {
+	for _, percentage := range []float64{faults.Delay.Percentage, faults.Abort.Percentage} {
+		if percentage < 0 || percentage > 100 {
+			return fmt.Errorf("percentage %g must be between 0 and 100", percentage)
+		}
+	}
+	if faults.Delay.Percentage > 0 && faults.Delay.Duration <= 0 {
+		return fmt.Errorf("delay requires a positive duration")
+	}
+	if faults.Abort.Status != 0 && (faults.Abort.Status < 200 || faults.Abort.Status > 599) {
+		return fmt.Errorf("abort status %d must be between 200 and 599, or 0 to close the connection", faults.Abort.Status)
+	}
+	return nil
 }
 
-// RateLimiting holds the configuration for rate limiting.
-type RateLimiting struct {
-	Enabled           bool    `yaml:"enabled"`             // Enables/disables rate limiting globally.
-	RequestsPerSecond float64 `yaml:"requests_per_second"` // Number of requests allowed per second.
-	Burst             int     `yaml:"burst"`               // Maximum burst of requests.
+// validateRecording checks the storage of a recording and applies its defaults.
+//
+// Parameters:
+// - recording: The recording configuration, updated in place.
+//
+// Returns:
+// - error: An error if the storage is unknown or the percentage is out of range.
+func validateRecording(recording *Recording) error {
+	switch recording.Storage {
+	case "":
+		recording.Storage = RecordingStorageFile
+	case RecordingStorageFile, RecordingStorageRedis:
+	default:
+		return fmt.Errorf("unknown storage %q (expected file or redis)", recording.Storage)
+	}
+	if recording.Percentage < 0 || recording.Percentage > 100 {
+		return fmt.Errorf("percentage %g must be between 0 and 100", recording.Percentage)
+	}
+	if recording.Percentage == 0 {
+		recording.Percentage = 100
+	}
+	if recording.Directory == "" {
+		recording.Directory = DefaultRecordingDirectory
+	}
+	if recording.MaxEntries <= 0 {
+		recording.MaxEntries = DefaultRecordingMaxEntries
+	}
+	if recording.MaxBodySize <= 0 {
+		recording.MaxBodySize = DefaultRecordingMaxBodySize
+	}
+	return nil
 }
 
-type Cache struct {
-	Enabled bool `yaml:"enabled"` // Enables/disables caching.
-	TTL     int  `yaml:"ttl"`     // Time to live for cache entries in seconds.
+// validateHMAC checks the secret, the algorithm, and the encoding of an HMAC verification, and applies its
+// defaults.
+//
+// Parameters:
+// - hmac: The HMAC verification configuration, updated in place.
+//
+// Returns:
+// - error: An error if the secret is missing, the algorithm or the encoding is unknown, or replay protection is
+// enabled without a timestamp header.
+func validateHMAC(hmac *HMACVerification) error {
+	if hmac.Secret == "" {
+		return fmt.Errorf("requires a secret")
+	}
+	switch hmac.Algorithm {
+	case "":
+		hmac.Algorithm = HMACAlgorithmSHA256
+	case HMACAlgorithmSHA1, HMACAlgorithmSHA256, HMACAlgorithmSHA512:
+	default:
+		return fmt.Errorf("unknown algorithm %q (expected sha1, sha256, or sha512)", hmac.Algorithm)
+	}
+	switch hmac.Encoding {
+	case "":
+		hmac.Encoding = HMACEncodingHex
+	case HMACEncodingHex, HMACEncodingBase64:
+	default:
+		return fmt.Errorf("unknown encoding %q (expected hex or base64)", hmac.Encoding)
+	}
+	if hmac.ReplayProtection && hmac.TimestampHeader == "" {
+		return fmt.Errorf("replay protection requires a timestamp header, so that seen requests can be forgotten")
+	}
+	if hmac.Header == "" {
+		hmac.Header = DefaultHMACHeader
+	}
+	if hmac.MaxSkew <= 0 {
+		hmac.MaxSkew = DefaultHMACMaxSkew
+	}
+	if hmac.MaxBodySize <= 0 {
+		hmac.MaxBodySize = DefaultHMACMaxBodySize
+	}
+	return nil
 }
 
-// Logging holds the configuration for logging.
-type Logging struct {
-	Enabled bool   `yaml:"enabled"` // Enables/disables logging.
-	Verbose bool   `yaml:"verbose"` // Enables/disables verbose logging.
-	Level   string `yaml:"level"`   // Log level (e.g., debug, info, warn, error).
+// validateCredentials checks the upstream credentials of a location.
+//
+// Parameters:
+// - credentials: The credentials.
+// - signing: The signing configuration of the location, whose Authorization header must not be overwritten.
+//
+// Returns:
+// - error: An error if the type is unknown, its values are missing, or it conflicts with the signing.
+func validateCredentials(credentials UpstreamCredentials, signing UpstreamSigning) error {
+	switch credentials.Type {
+	case CredentialsBearer:
+		if credentials.Token == "" {
+			return fmt.Errorf("bearer requires a token")
+		}
+	case CredentialsBasic:
+		if credentials.Username == "" {
+			return fmt.Errorf("basic requires a username")
+		}
+	case CredentialsHeader:
+		if credentials.Header == "" || credentials.Value == "" {
+			return fmt.Errorf("header requires a header and a value")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown type %q (expected bearer, basic, or header)", credentials.Type)
+	}
+	if signing.Type == SigningAWSV4 {
+		return fmt.Errorf("%s credentials conflict with the Authorization header of aws-sigv4 signing", credentials.Type)
+	}
+	return nil
 }
 
-// LocationConfig holds the configuration for a specific location.
-type LocationConfig struct {
-	Path              string            `yaml:"path"` // Path the proxy will respond to.
-	CompiledRegex     *regexp.Regexp    // Compiled regular expression for the path.
-	EnableWebsocket   bool              `yaml:"enable_websocket"`   // Enables/disables WebSocket for this location.
-	TargetURL         string            `yaml:"target_url"`         // Destination URL for this location.
-	ReplacePath       bool              `yaml:"replace_path"`       // Whether to replace the path entirely.
-	AdditionalHeaders map[string]string `yaml:"additional_headers"` // Additional headers to add for this location.
-	ExcludedHeaders   []string          `yaml:"excluded_headers"`   // Headers to exclude for this location.
-	Middlewares       []string          `yaml:"middlewares"`        // List of middlewares to apply for this location.
-	RateLimiting      RateLimiting      `yaml:"rate_limiting"`      // Rate Limiting configuration.
-	EnableCompression bool              `yaml:"enable_compression"` // Flag to enable Gzip Compression.
-	Cache             Cache             `yaml:"cache"`              // Cache configuration.engin
-	Transport         *TransportConfig  `yaml:"transport"`          // Optional Transport configuration for this location.
+// validateSigning checks the credentials of the signature of the upstream requests, and applies its defaults.
+//
+// Parameters:
+// - signing: The signing configuration, updated in place.
+//
+// Returns:
+// - error: An error if the type is unknown, or its credentials or settings are missing or invalid.
+func validateSigning(signing *UpstreamSigning) error {
+	switch signing.Type {
+	case SigningAWSV4:
+		aws := signing.AWS
+		if aws.AccessKeyID == "" || aws.SecretAccessKey == "" || aws.Region == "" || aws.Service == "" {
+			return fmt.Errorf("aws-sigv4 requires an access key ID, a secret access key, a region, and a service")
+		}
+		if aws.UnsignedPayload && aws.Service != "s3" {
+			return fmt.Errorf("unsigned payloads are only accepted by s3")
+		}
+	case SigningHMAC:
+		verification := HMACVerification{Secret: signing.HMAC.Secret, Algorithm: signing.HMAC.Algorithm, Encoding: signing.HMAC.Encoding, Header: signing.HMAC.Header}
+		if err := validateHMAC(&verification); err != nil {
+			return err
+		}
+		signing.HMAC.Algorithm, signing.HMAC.Encoding, signing.HMAC.Header = verification.Algorithm, verification.Encoding, verification.Header
+	default:
+		return fmt.Errorf("unknown type %q (expected aws-sigv4 or hmac)", signing.Type)
+	}
+	if signing.MaxBodySize <= 0 {
+		signing.MaxBodySize = DefaultSigningMaxBodySize
+	}
+	return nil
 }
 
-var currentConfig atomic.Value
+// validateIntrospection checks the endpoint of a token introspection and applies its defaults.
+//
+// Parameters:
+// - introspection: The introspection configuration, updated in place.
+//
+// Returns:
+// - error: An error if the endpoint is not an absolute HTTP(S) URL, or a claim header has no name.
+func validateIntrospection(introspection *Introspection) error {
+	endpoint, err := url.Parse(introspection.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return fmt.Errorf("endpoint %q must be an absolute http or https URL", introspection.Endpoint)
+	}
+	for claim, header := range introspection.ClaimHeaders {
+		if header == "" {
+			return fmt.Errorf("claim %s requires a header name", claim)
+		}
+	}
+	if introspection.Timeout <= 0 {
+		introspection.Timeout = DefaultIntrospectionTimeout
+	}
+	if introspection.CacheTTL <= 0 {
+		introspection.CacheTTL = DefaultIntrospectionCacheTTL
+	}
+	return nil
+}
 
-// LoadConfiguration loads the proxy configuration from a YAML file.
+// validateSLO checks the objectives of a location and applies their defaults.
 //
 // Parameters:
-// - file: The path to the configuration file.
+// - slo: The objectives, updated in place.
 //
 // Returns:
-// - *ProxyConfig: A pointer to the loaded ProxyConfig.
-// - error: An error if the configuration could not be loaded.
-func LoadConfiguration(file string) (*ProxyConfig, error) {
-	var config ProxyConfig
-	data, err := os.ReadFile(file)
-	if err != nil {
-		return nil, err
+// - error: An error if no objective is set, or a percentile or a rate is out of range.
+func validateSLO(slo *SLO) error {
+	if slo.Latency.Threshold <= 0 && slo.MaxErrorRate <= 0 {
+		return fmt.Errorf("requires a latency threshold or a maximum error rate")
+	}
+	if slo.Latency.Percentile < 0 || slo.Latency.Percentile >= 100 {
+		return fmt.Errorf("latency percentile %g must be between 0 and 100", slo.Latency.Percentile)
+	}
+	if slo.Latency.Percentile == 0 {
+		slo.Latency.Percentile = DefaultSLOLatencyPercentile
+	}
+	if slo.MaxErrorRate < 0 || slo.MaxErrorRate > 100 {
+		return fmt.Errorf("maximum error rate %g must be between 0 and 100", slo.MaxErrorRate)
+	}
+	if slo.Window <= 0 {
+		slo.Window = DefaultSLOWindow
 	}
+	if slo.MinRequests <= 0 {
+		slo.MinRequests = DefaultSLOMinRequests
+	}
+	return nil
+}
 
-	if err = yaml.Unmarshal(data, &config); err != nil {
-		return nil, err
+// applyPolicies makes the locations referencing a policy profile inherit its settings. The settings of the
+// location are decoded over the ones of the policy, so a location only overrides what it sets itself; maps,
+// such as additional_headers, are merged.
+//
+// Parameters:
+// - data: The YAML configuration.
+// - config: The configuration decoded from data, whose locations are updated.
+//
+// Returns:
+// - error: An error if a location references an unknown policy, or a policy sets routing settings.
+func applyPolicies(data []byte, config *ProxyConfig) error {
+	for name, policy := range config.Policies {
+		if policy.Path != "" || policy.Name != "" || policy.TargetURL != "" || policy.Policy != "" {
+			return fmt.Errorf("policy %s: path, name, target_url, and policy cannot be set in a policy", name)
+		}
 	}
 
+	var raw struct {
+		Policies  map[string]yaml.Node `yaml:"policies"`
+		Locations []yaml.Node          `yaml:"locations"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return err
+	}
 	for i, location := range config.Locations {
-		regex, err := regexp.Compile(location.Path)
+		if location.Policy == "" {
+			continue
+		}
+		policy, ok := raw.Policies[location.Policy]
+		if !ok {
+			return fmt.Errorf("location %s: unknown policy %q", location.Label(), location.Policy)
+		}
+		var merged LocationConfig
+		if err := policy.Decode(&merged); err != nil {
+			return fmt.Errorf("policy %s: %v", location.Policy, err)
+		}
+		if err := raw.Locations[i].Decode(&merged); err != nil {
+			return fmt.Errorf("location %s: %v", location.Label(), err)
+		}
+		config.Locations[i] = merged
+	}
+	return nil
+}
+
+// applyOpenAPI expands the locations referencing an OpenAPI document into one location per path of the
+// document, matching the methods of its operations. The expanded locations keep the other settings of the
+// location, and proxy to the first server of the document unless a target URL is set.
+//
+// Parameters:
+// - config: The configuration whose locations are expanded.
+//
+// Returns:
+// - error: An error if a document cannot be loaded, or a location sets both a path and a document.
+func applyOpenAPI(config *ProxyConfig) error {
+	var locations []LocationConfig
+	for _, location := range config.Locations {
+		if location.OpenAPI == "" {
+			locations = append(locations, location)
+			continue
+		}
+		if location.Path != "" {
+			return fmt.Errorf("location %s: path and openapi are mutually exclusive", location.Path)
+		}
+		doc, err := openapi.Load(location.OpenAPI)
 		if err != nil {
-			return nil, fmt.Errorf("error compiling regex for path %s: %v", location.Path, err)
+			return fmt.Errorf("openapi %s: %v", location.OpenAPI, err)
 		}
-		config.Locations[i].CompiledRegex = regex
+		if location.TargetURL == "" {
+			if location.TargetURL, err = doc.ServerURL(); err != nil {
+				return fmt.Errorf("openapi %s: %v", location.OpenAPI, err)
+			}
+		}
+		for _, route := range doc.Routes() {
+			expanded := location
+			expanded.Path = route.Pattern
+			expanded.Methods = route.Methods
+			if location.Name != "" {
+				expanded.Name = location.Name + " " + route.Template
+			}
+			locations = append(locations, expanded)
+		}
+	}
+	config.Locations = locations
+	return nil
+}
 
-		if location.Transport == nil {
-			config.Locations[i].Transport = &config.Transport
+// loadValidator creates the request validator of a location, sharing the validators of the files already
+// loaded with the other locations.
+//
+// Parameters:
+// - location: The location configuration.
+// - validators: The validators already loaded, by file.
+//
+// Returns:
+// - *openapi.Validator: The validator of the location.
+// - error: An error if no document is set, or it cannot be loaded.
+func loadValidator(location LocationConfig, validators map[string]*openapi.Validator) (*openapi.Validator, error) {
+	validation := location.Validation
+	file := validation.Schema
+	if file == "" {
+		file = validation.OpenAPI
+		if file == "" {
+			file = location.OpenAPI
+		}
+	}
+	if file == "" {
+		return nil, fmt.Errorf("an openapi document or a schema is required")
+	}
+	if validation.Schema != "" && validation.OpenAPI != "" {
+		return nil, fmt.Errorf("openapi and schema are mutually exclusive")
+	}
+	key := "openapi:" + file
+	if validation.Schema != "" {
+		key = "schema:" + file
+	}
+	if validator, ok := validators[key]; ok {
+		return validator, nil
+	}
+
+	var validator *openapi.Validator
+	if validation.Schema != "" {
+		var err error
+		if validator, err = openapi.LoadSchemaValidator(file); err != nil {
+			return nil, err
+		}
+	} else {
+		doc, err := openapi.Load(file)
+		if err != nil {
+			return nil, err
 		}
+		validator = openapi.NewValidator(doc)
 	}
+	validators[key] = validator
+	return validator, nil
+}
 
-	return &config, nil
+// applyServerDefaults fills in sane defaults for any server setting left unset.
+//
+// Parameters:
+// - server: A pointer to the ServerConfig to update.
+func applyServerDefaults(server *ServerConfig) {
+	if server.ReadHeaderTimeout <= 0 {
+		server.ReadHeaderTimeout = DefaultReadHeaderTimeout
+	}
+	if server.ReadTimeout < 0 {
+		server.ReadTimeout = 0
+	}
+	if server.WriteTimeout < 0 {
+		server.WriteTimeout = 0
+	}
+	if server.IdleTimeout <= 0 {
+		server.IdleTimeout = DefaultIdleTimeout
+	}
+	if server.MaxHeaderBytes <= 0 {
+		server.MaxHeaderBytes = DefaultMaxHeaderBytes
+	}
+	if server.Shutdown.Timeout <= 0 {
+		server.Shutdown.Timeout = DefaultShutdownTimeout
+	}
+}
+
+// applyMaskingDefaults fills in the default masked headers and body fields, and compiles the expression
+// matching the body fields.
+//
+// Parameters:
+// - masking: A pointer to the LogMasking to update.
+//
+// Returns:
+// - error: An error if the body fields expression cannot be compiled.
+func applyMaskingDefaults(masking *LogMasking) error {
+	if masking.Headers == nil {
+		masking.Headers = DefaultMaskedHeaders
+	}
+	if masking.BodyFields == nil {
+		masking.BodyFields = DefaultMaskedBodyFields
+	}
+	if len(masking.BodyFields) == 0 {
+		return nil
+	}
+
+	fields := make([]string, len(masking.BodyFields))
+	for i, field := range masking.BodyFields {
+		fields[i] = regexp.QuoteMeta(field)
+	}
+	// A field is matched with its value, which may be a (possibly truncated) string or a scalar.
+	regex, err := regexp.Compile(`("(?i:` + strings.Join(fields, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^\s,}\]]+)`)
+	if err != nil {
+		return fmt.Errorf("error compiling masked body fields: %v", err)
+	}
+	masking.CompiledFields = regex
+
+	// An element is matched with its text, whatever its namespace prefix (e.g. <wsse:Password Type="...">).
+	xmlRegex, err := regexp.Compile(`(<(?:[\w.-]+:)?(?i:` + strings.Join(fields, "|") + `)(?:\s[^>]*)?>)[^<]*`)
+	if err != nil {
+		return fmt.Errorf("error compiling masked body fields: %v", err)
+	}
+	masking.CompiledXMLFields = xmlRegex
+	return nil
+}
+
+// validateRateLimitScopes checks the rate limit scopes, and that the locations sharing limits agree on them.
+//
+// Parameters:
+// - locations: The location configurations.
+//
+// Returns:
+// - error: An error describing the first invalid scope.
+func validateRateLimitScopes(locations []LocationConfig) error {
+	shared := make(map[string]RateLimiting)
+	for _, location := range locations {
+		rateLimiting := location.RateLimiting
+		var name string
+		switch rateLimiting.Scope {
+		case "", RateLimitScopeLocation:
+			continue
+		case RateLimitScopeGroup:
+			if rateLimiting.Group == "" {
+				return fmt.Errorf("location %s: rate limit scope group requires a group name", location.Label())
+			}
+			name = "group " + rateLimiting.Group
+		case RateLimitScopeGlobal:
+			name = "global scope"
+		default:
+			return fmt.Errorf("location %s: unsupported rate limit scope %q", location.Label(), rateLimiting.Scope)
+		}
+
+		if previous, ok := shared[name]; ok && previous != rateLimiting {
+			return fmt.Errorf("location %s: rate limits of the %s differ from those of another location", location.Label(), name)
+		}
+		shared[name] = rateLimiting
+	}
+	return nil
+}
+
+// validateQuotas checks the quotas of the locations, and that the locations sharing a quota agree on it.
+//
+// Parameters:
+// - locations: The location configurations.
+//
+// Returns:
+// - error: An error describing the first invalid quota.
+func validateQuotas(locations []LocationConfig) error {
+	quotas := make(map[string]Quota)
+	for _, location := range locations {
+		quota := location.Quota
+		if !quota.Enabled {
+			continue
+		}
+		if quota.Name == "" {
+			return fmt.Errorf("location %s: quota requires a name", location.Label())
+		}
+		if quota.Limit <= 0 {
+			return fmt.Errorf("location %s: quota %s requires a positive limit", location.Label(), quota.Name)
+		}
+		if quota.Window != QuotaWindowDay && quota.Window != QuotaWindowMonth {
+			return fmt.Errorf("location %s: unsupported quota window %q", location.Label(), quota.Window)
+		}
+		keyHeader := quota.KeyHeader
+		if keyHeader == "" {
+			keyHeader = "X-API-Key"
+		}
+		if len(quota.Keys) == 0 && !keySetByIntrospection(location, "quota", keyHeader) {
+			return fmt.Errorf("location %s: quota %s requires keys, or a key_header set by the introspection of the location", location.Label(), quota.Name)
+		}
+		if previous, ok := quotas[quota.Name]; ok && !reflect.DeepEqual(previous, quota) {
+			return fmt.Errorf("location %s: quota %s differs from the one of another location", location.Label(), quota.Name)
+		}
+		quotas[quota.Name] = quota
+	}
+	return nil
+}
+
+// validateBandwidthLimit checks that the budgets of a bandwidth limit are owned only by validated API keys, so
+// that a client cannot get fresh budgets, or fill the memory, by sending new keys.
+//
+// Parameters:
+// - location: The location configuration.
+//
+// Returns:
+// - error: An error if the key header of the bandwidth limit is not validated.
+func validateBandwidthLimit(location LocationConfig) error {
+	bandwidthLimit := location.BandwidthLimit
+	if !bandwidthLimit.Enabled || bandwidthLimit.KeyHeader == "" || len(bandwidthLimit.Keys) > 0 {
+		return nil
+	}
+	if !keySetByIntrospection(location, "bandwidth-limit", bandwidthLimit.KeyHeader) {
+		return fmt.Errorf("location %s: bandwidth_limit.key_header requires keys, or a header set by the introspection of the location", location.Label())
+	}
+	return nil
+}
+
+// keySetByIntrospection reports whether a key header read by a middleware of a location carries a claim of the
+// token validated by the introspection of the location, which runs before the middleware and removes the header
+// from the client requests, so that only validated keys are used.
+//
+// Parameters:
+// - location: The location configuration.
+// - middleware: The name of the middleware reading the key header.
+// - keyHeader: The key header.
+//
+// Returns:
+// - bool: True if the key header is a claim header of an introspection running before the middleware.
+func keySetByIntrospection(location LocationConfig, middleware, keyHeader string) bool {
+	if !location.Introspection.Enabled {
+		return false
+	}
+	introspection := slices.Index(location.Middlewares, "introspection")
+	if introspection < 0 || slices.Index(location.Middlewares, middleware) < introspection {
+		return false
+	}
+	for _, header := range location.Introspection.ClaimHeaders {
+		if strings.EqualFold(header, keyHeader) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateStreams checks that every stream proxy declares a supported protocol and its addresses.
+//
+// Parameters:
+// - streams: The stream configurations to validate.
+//
+// Returns:
+// - error: An error describing the first invalid stream.
+func validateStreams(streams []StreamConfig) error {
+	for i, stream := range streams {
+		name := stream.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		if stream.Protocol != "tcp" && stream.Protocol != "udp" {
+			return fmt.Errorf("stream %s: unsupported protocol %q (expected tcp or udp)", name, stream.Protocol)
+		}
+		if stream.Listen == "" || stream.Target == "" {
+			return fmt.Errorf("stream %s: listen and target addresses are required", name)
+		}
+		if stream.TLS.Enabled && stream.Protocol != "tcp" {
+			return fmt.Errorf("stream %s: TLS termination is only supported for tcp streams", name)
+		}
+	}
+	return nil
 }
 
 // UpdateConfig updates the current configuration with a new configuration.
@@ -188,13 +2558,15 @@ func IsConfigDifferent(config1, config2 *ProxyConfig) bool {
 }
 
 // WatchConfig watches the configuration file for changes and invokes a callback when changes are detected.
+// A configuration holding secret references is also reloaded every refresh interval, so that rotated secrets
+// are applied.
 //
 // Parameters:
 // - configFile: The path to the configuration file.
 // - onChange: A callback function to invoke when the configuration changes.
 // - logger: A logger to log messages.
 func WatchConfig(configFile string, onChange func(*ProxyConfig), logger *slog.Logger) {
-	var lastModified time.Time
+	var lastModified, lastLoaded time.Time
 	isFirstCheck := true
 
 	ticker := time.NewTicker(2 * time.Second)
@@ -209,10 +2581,14 @@ func WatchConfig(configFile string, onChange func(*ProxyConfig), logger *slog.Lo
 				continue
 			}
 
-			if fileInfo.ModTime().After(lastModified) {
-				time.Sleep(1 * time.Second)
+			modified := fileInfo.ModTime().After(lastModified)
+			if modified || secretsExpired(GetCurrentProxyConfig(), lastLoaded) {
+				if modified {
+					time.Sleep(1 * time.Second)
+				}
 
 				newConfig, err := LoadConfiguration(configFile)
+				lastLoaded = time.Now()
 				if err != nil {
 					logger.Error(fmt.Sprintf("Error loading configuration: %v", err))
 					continue
@@ -228,3 +2604,15 @@ func WatchConfig(configFile string, onChange func(*ProxyConfig), logger *slog.Lo
 		}
 	}
 }
+
+// secretsExpired reports whether the secret references of a configuration must be resolved again.
+//
+// Parameters:
+// - config: The current configuration.
+// - lastLoaded: The time of the last load.
+//
+// Returns:
+// - bool: True if the configuration holds secret references and its refresh interval has elapsed.
+func secretsExpired(config *ProxyConfig, lastLoaded time.Time) bool {
+	return config.secretReferences > 0 && config.Secrets.RefreshInterval > 0 && time.Since(lastLoaded) >= config.Secrets.RefreshInterval
+}
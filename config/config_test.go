@@ -4,7 +4,10 @@ import (
 	"dito/config"
 	"github.com/stretchr/testify/assert"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -115,3 +118,1275 @@ port: "9090"
 	time.Sleep(3 * time.Second)
 	assert.True(t, callbackInvoked)
 }
+
+// TestLoadConfigurationServerDefaults verifies that server timeouts fall back to sane defaults, and that the read
+// timeout can be disabled.
+func TestLoadConfigurationServerDefaults(t *testing.T) {
+	content := `
+port: "8080"
+server:
+  read_header_timeout: 5s
+  write_timeout: 30s
+`
+	file, err := os.CreateTemp("", "config_server_test_*.yaml")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.Write([]byte(content))
+	assert.NoError(t, err)
+
+	loadedConfig, err := config.LoadConfiguration(file.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Second, loadedConfig.Server.ReadHeaderTimeout)
+	assert.Equal(t, 30*time.Second, loadedConfig.Server.WriteTimeout)
+	assert.Equal(t, config.DefaultReadTimeout, loadedConfig.Server.ReadTimeout)
+	assert.Equal(t, config.DefaultIdleTimeout, loadedConfig.Server.IdleTimeout)
+	assert.Equal(t, config.DefaultMaxHeaderBytes, loadedConfig.Server.MaxHeaderBytes)
+
+	// An explicit 0 disables the read timeout.
+	disabled, err := os.CreateTemp("", "config_server_test_*.yaml")
+	assert.NoError(t, err)
+	defer os.Remove(disabled.Name())
+	_, err = disabled.Write([]byte("port: \"8080\"\nserver:\n  read_timeout: 0s\n"))
+	assert.NoError(t, err)
+
+	loadedConfig, err = config.LoadConfiguration(disabled.Name())
+	assert.NoError(t, err)
+	assert.Zero(t, loadedConfig.Server.ReadTimeout)
+}
+
+// TestLoadConfigurationMatchers verifies that method and header matchers are normalized and compiled.
+func TestLoadConfigurationMatchers(t *testing.T) {
+	content := `
+port: "8080"
+locations:
+  - path: "^/api$"
+    target_url: "http://backend:8000"
+    methods: ["get", "Post"]
+    match_headers:
+      - name: "X-Tenant"
+        regex: "^acme-"
+      - name: "X-Version"
+        value: "2"
+`
+	file, err := os.CreateTemp("", "config_matchers_test_*.yaml")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.Write([]byte(content))
+	assert.NoError(t, err)
+
+	loadedConfig, err := config.LoadConfiguration(file.Name())
+	assert.NoError(t, err)
+	location := loadedConfig.Locations[0]
+	assert.Equal(t, []string{"GET", "POST"}, location.Methods)
+	assert.NotNil(t, location.MatchHeaders[0].CompiledRegex)
+	assert.True(t, location.MatchHeaders[0].Matches([]string{"acme-eu"}))
+	assert.False(t, location.MatchHeaders[0].Matches([]string{"globex"}))
+	assert.True(t, location.MatchHeaders[1].Matches([]string{"1", "2"}))
+	assert.False(t, location.MatchHeaders[1].Matches(nil))
+}
+
+// TestLoadConfigurationGeoIP verifies that the country rules require the GeoIP databases, which must be valid.
+func TestLoadConfigurationGeoIP(t *testing.T) {
+	load := func(content string) error {
+		file, err := os.CreateTemp("", "config_geoip_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		_, err = config.LoadConfiguration(file.Name())
+		return err
+	}
+
+	err := load(`
+port: "8080"
+locations:
+  - path: "^/eu/"
+    target_url: "http://backend:8000"
+    match_countries: ["it", "fr"]
+`)
+	assert.ErrorContains(t, err, "country rules require geoip")
+
+	invalid, err := os.CreateTemp("", "config_geoip_test_*.mmdb")
+	assert.NoError(t, err)
+	defer os.Remove(invalid.Name())
+	invalid.Write([]byte("not a database"))
+	err = load(`
+port: "8080"
+geoip:
+  enabled: true
+  country_database: "` + invalid.Name() + `"
+`)
+	assert.ErrorContains(t, err, "error loading the GeoIP databases")
+
+	err = load(`
+port: "8080"
+geoip:
+  enabled: true
+`)
+	assert.ErrorContains(t, err, "geoip requires a country or an ASN database")
+}
+
+// TestGeoRulesAllows verifies the country allow and deny lists.
+func TestGeoRulesAllows(t *testing.T) {
+	allow := config.GeoRules{AllowCountries: []string{"IT", "FR"}}
+	assert.True(t, allow.Allows("IT"))
+	assert.False(t, allow.Allows("US"))
+	assert.False(t, allow.Allows(""))
+
+	deny := config.GeoRules{DenyCountries: []string{"KP"}}
+	assert.False(t, deny.Allows("KP"))
+	assert.True(t, deny.Allows("IT"))
+	assert.True(t, deny.Allows(""))
+	assert.True(t, config.GeoRules{}.Allows(""))
+}
+
+// TestLoadConfigurationBotFilter verifies that the bot filter gets its defaults and case-insensitive patterns,
+// and that unknown actions are rejected.
+func TestLoadConfigurationBotFilter(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_bot_filter_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	cfg, err := load(`
+port: "8080"
+locations:
+  - path: "^/"
+    target_url: "http://backend:8000"
+    bot_filter:
+      enabled: true
+      block_user_agents: ["curl/"]
+`)
+	if assert.NoError(t, err) {
+		botFilter := cfg.Locations[0].BotFilter
+		assert.Equal(t, config.BotActionBlock, botFilter.Action)
+		assert.Equal(t, config.DefaultTarpitDelay, botFilter.TarpitDelay)
+		assert.Equal(t, config.DefaultMaxTrapped, botFilter.MaxTarpitted)
+		if assert.Len(t, botFilter.CompiledBlockUserAgents, 1) {
+			assert.True(t, botFilter.CompiledBlockUserAgents[0].MatchString("CURL/8.0"))
+		}
+	}
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/"
+    target_url: "http://backend:8000"
+    bot_filter:
+      enabled: true
+      action: drop
+`)
+	assert.ErrorContains(t, err, "unknown action")
+}
+
+// TestLoadConfigurationHoneypot verifies that the honeypot gets its default paths and response, and that unknown
+// responses are rejected.
+func TestLoadConfigurationHoneypot(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_honeypot_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	cfg, err := load(`
+port: "8080"
+honeypot:
+  enabled: true
+`)
+	if assert.NoError(t, err) {
+		honeypot := cfg.Honeypot
+		assert.Equal(t, config.HoneypotResponseNotFound, honeypot.Response)
+		assert.Equal(t, int64(config.DefaultHoneypotJunkSize), honeypot.JunkSize)
+		assert.Equal(t, config.DefaultMaxTrapped, honeypot.MaxTrapped)
+		assert.Len(t, honeypot.CompiledPaths, len(config.DefaultHoneypotPaths))
+		matches := func(path string) bool {
+			for _, regex := range honeypot.CompiledPaths {
+				if regex.MatchString(path) {
+					return true
+				}
+			}
+			return false
+		}
+		for _, path := range []string{"/.env", "/api/.env.production", "/.git/config", "/wp-login.php", "/phpMyAdmin/"} {
+			assert.True(t, matches(path), path)
+		}
+		for _, path := range []string{"/", "/environment", "/blog/wp-login", "/static/app.js"} {
+			assert.False(t, matches(path), path)
+		}
+	}
+
+	_, err = load(`
+port: "8080"
+honeypot:
+  enabled: true
+  response: teapot
+`)
+	assert.ErrorContains(t, err, "unknown response")
+}
+
+// TestLoadConfigurationFallback verifies that the fallback statuses default to 404, and that relative targets
+// and statuses other than errors are rejected.
+func TestLoadConfigurationFallback(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_fallback_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	cfg, err := load(`
+port: "8080"
+fallback:
+  target_url: "http://frontend:8080"
+locations:
+  - path: "^/app/"
+    target_url: "http://backend:8000"
+    fallback:
+      target_url: "http://frontend:8080/index.html"
+      replace_path: true
+`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "http://frontend:8080", cfg.Fallback.TargetURL)
+		assert.Equal(t, []int{404}, cfg.Locations[0].Fallback.Statuses)
+	}
+
+	_, err = load(`
+port: "8080"
+fallback:
+  target_url: "/index.html"
+`)
+	assert.ErrorContains(t, err, "must be absolute")
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/app/"
+    target_url: "http://backend:8000"
+    fallback:
+      target_url: "http://frontend:8080"
+      statuses: [200]
+`)
+	assert.ErrorContains(t, err, "between 400 and 599")
+}
+
+// TestLoadConfigurationMirror verifies that the defaults of a mirror are applied, and that invalid targets and
+// percentages are rejected.
+func TestLoadConfigurationMirror(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_mirror_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	cfg, err := load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend:8000"
+    mirror:
+      target_url: "http://backend-v2:8000"
+      compare:
+        enabled: true
+        percentage: 10
+`)
+	if assert.NoError(t, err) {
+		mirror := cfg.Locations[0].Mirror
+		assert.Equal(t, 100.0, mirror.Percentage)
+		assert.Equal(t, config.DefaultMirrorTimeout, mirror.Timeout)
+		assert.Equal(t, int64(config.DefaultMirrorMaxBodySize), mirror.MaxBodySize)
+		assert.Equal(t, 10.0, mirror.Compare.Percentage)
+		assert.Equal(t, int64(config.DefaultMirrorMaxBodySize), mirror.Compare.MaxBodySize)
+	}
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend:8000"
+    mirror:
+      target_url: "backend-v2:8000"
+`)
+	assert.ErrorContains(t, err, "must be absolute")
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend:8000"
+    mirror:
+      target_url: "http://backend-v2:8000"
+      percentage: 150
+`)
+	assert.ErrorContains(t, err, "between 0 and 100")
+}
+
+// TestLoadConfigurationFaultInjection verifies that the injected faults are validated only when enabled.
+func TestLoadConfigurationFaultInjection(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_fault_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	_, err := load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend:8000"
+    fault_injection:
+      enabled: true
+      delay:
+        percentage: 10
+        duration: 2s
+      abort:
+        percentage: 5
+        status: 503
+`)
+	assert.NoError(t, err)
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend:8000"
+    fault_injection:
+      enabled: true
+      delay:
+        percentage: 10
+`)
+	assert.ErrorContains(t, err, "positive duration")
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend:8000"
+    fault_injection:
+      enabled: true
+      abort:
+        percentage: 5
+        status: 42
+`)
+	assert.ErrorContains(t, err, "between 200 and 599")
+}
+
+// TestLoadConfigurationRecording verifies that the defaults of a recording are applied, and that unknown
+// storages are rejected.
+func TestLoadConfigurationRecording(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_recording_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	cfg, err := load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend:8000"
+    recording:
+      enabled: true
+`)
+	if assert.NoError(t, err) {
+		recording := cfg.Locations[0].Recording
+		assert.Equal(t, config.RecordingStorageFile, recording.Storage)
+		assert.Equal(t, 100.0, recording.Percentage)
+		assert.Equal(t, config.DefaultRecordingDirectory, recording.Directory)
+		assert.Equal(t, int64(config.DefaultRecordingMaxEntries), recording.MaxEntries)
+		assert.Equal(t, config.DefaultRecordingMaxBodySize, recording.MaxBodySize)
+	}
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend:8000"
+    recording:
+      enabled: true
+      storage: s3
+`)
+	assert.ErrorContains(t, err, "unknown storage")
+}
+
+// TestLoadConfigurationEcho verifies that the echo endpoint gets its default path, and that the root path is
+// rejected.
+func TestLoadConfigurationEcho(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_echo_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	cfg, err := load(`
+port: "8080"
+echo:
+  enabled: true
+`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, config.DefaultEchoPath, cfg.Echo.Path)
+	}
+
+	_, err = load(`
+port: "8080"
+echo:
+  enabled: true
+  path: "/"
+`)
+	assert.ErrorContains(t, err, "not be the root")
+}
+
+// TestLoadConfigurationSigning verifies that the HMAC signing of the upstream requests gets the defaults of the
+// hmac middleware, and that AWS signatures require their credentials.
+func TestLoadConfigurationSigning(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_signing_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	cfg, err := load(`
+port: "8080"
+locations:
+  - path: "^/internal/"
+    target_url: "http://backend"
+    signing:
+      type: hmac
+      hmac:
+        secret: "key"
+`)
+	if assert.NoError(t, err) {
+		signing := cfg.Locations[0].Signing
+		assert.Equal(t, int64(config.DefaultSigningMaxBodySize), signing.MaxBodySize)
+		assert.Equal(t, config.HMACAlgorithmSHA256, signing.HMAC.Algorithm)
+		assert.Equal(t, config.DefaultHMACHeader, signing.HMAC.Header)
+	}
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/files/"
+    target_url: "https://bucket.s3.amazonaws.com"
+    signing:
+      type: aws-sigv4
+      aws:
+        access_key_id: "AKID"
+`)
+	assert.ErrorContains(t, err, "aws-sigv4 requires an access key ID")
+}
+
+// TestLoadConfigurationCredentials verifies that upstream credentials with missing values, or conflicting with
+// the AWS signature, are rejected.
+func TestLoadConfigurationCredentials(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_credentials_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	t.Setenv("DITO_BACKEND_TOKEN", "backend-token")
+	cfg, err := load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend"
+    credentials:
+      type: bearer
+      token: "env://DITO_BACKEND_TOKEN"
+`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "backend-token", cfg.Locations[0].Credentials.Token)
+	}
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend"
+    credentials:
+      type: header
+      header: "X-API-Key"
+`)
+	assert.ErrorContains(t, err, "header requires a header and a value")
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend"
+    credentials:
+      type: basic
+      username: "dito"
+      password: "s3cret"
+    signing:
+      type: aws-sigv4
+      aws: {access_key_id: "AKID", secret_access_key: "secret", region: "eu-west-1", service: "execute-api"}
+`)
+	assert.ErrorContains(t, err, "conflict with the Authorization header of aws-sigv4 signing")
+}
+
+// TestLoadConfigurationHMAC verifies that the HMAC verification of a location gets its defaults, and that replay
+// protection requires a timestamp header.
+func TestLoadConfigurationHMAC(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_hmac_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	cfg, err := load(`
+port: "8080"
+locations:
+  - path: "^/webhooks/"
+    target_url: "http://backend"
+    hmac:
+      enabled: true
+      secret: "whsec"
+`)
+	if assert.NoError(t, err) {
+		hmac := cfg.Locations[0].HMAC
+		assert.Equal(t, config.HMACAlgorithmSHA256, hmac.Algorithm)
+		assert.Equal(t, config.HMACEncodingHex, hmac.Encoding)
+		assert.Equal(t, config.DefaultHMACHeader, hmac.Header)
+		assert.Equal(t, config.DefaultHMACMaxSkew, hmac.MaxSkew)
+	}
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/webhooks/"
+    target_url: "http://backend"
+    hmac:
+      enabled: true
+      secret: "whsec"
+      replay_protection: true
+`)
+	assert.ErrorContains(t, err, "replay protection requires a timestamp header")
+}
+
+// TestLoadConfigurationIntrospection verifies that the token introspection of a location gets its defaults, and
+// that an invalid endpoint is rejected.
+func TestLoadConfigurationIntrospection(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_introspection_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	cfg, err := load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend"
+    introspection:
+      enabled: true
+      endpoint: "https://auth.example.com/introspect"
+`)
+	if assert.NoError(t, err) {
+		introspection := cfg.Locations[0].Introspection
+		assert.Equal(t, config.DefaultIntrospectionTimeout, introspection.Timeout)
+		assert.Equal(t, config.DefaultIntrospectionCacheTTL, introspection.CacheTTL)
+	}
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend"
+    introspection:
+      enabled: true
+      endpoint: "/introspect"
+`)
+	assert.ErrorContains(t, err, "must be an absolute http or https URL")
+}
+
+// TestLoadConfigurationQuota verifies that a quota counts only validated keys: its own keys, or a claim header set
+// by an introspection running before it.
+func TestLoadConfigurationQuota(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_quota_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	t.Setenv("PARTNER_API_KEY", "from-env")
+	cfg, err := load(`
+port: "8080"
+locations:
+  - path: "^/v1/"
+    target_url: "http://backend"
+    middlewares: ["quota"]
+    quota:
+      enabled: true
+      name: partners
+      limit: 100
+      window: day
+      keys: ["env://PARTNER_API_KEY", "plain"]
+`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"from-env", "plain"}, cfg.Locations[0].Quota.Keys)
+	}
+
+	introspected := func(middlewares string) string {
+		return `
+port: "8080"
+locations:
+  - path: "^/v1/"
+    target_url: "http://backend"
+    middlewares: ` + middlewares + `
+    introspection:
+      enabled: true
+      endpoint: "https://auth.example.com/introspect"
+      claim_headers:
+        client_id: X-Client-ID
+    quota:
+      enabled: true
+      name: clients
+      limit: 100
+      window: month
+      key_header: X-Client-ID
+`
+	}
+	_, err = load(introspected(`["introspection", "quota"]`))
+	assert.NoError(t, err)
+
+	_, err = load(introspected(`["quota", "introspection"]`))
+	assert.ErrorContains(t, err, "quota clients requires keys, or a key_header set by the introspection of the location")
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/v1/"
+    target_url: "http://backend"
+    middlewares: ["quota"]
+    quota:
+      enabled: true
+      name: partners
+      limit: 100
+      window: day
+`)
+	assert.ErrorContains(t, err, "quota partners requires keys")
+}
+
+// TestLoadConfigurationBandwidthLimitKeys verifies that the budgets of a bandwidth limit are owned only by
+// validated API keys.
+func TestLoadConfigurationBandwidthLimitKeys(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_bandwidth_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	location := func(bandwidthLimit string) string {
+		return `
+port: "8080"
+locations:
+  - path: "^/files"
+    target_url: "http://backend"
+    middlewares: ["introspection", "bandwidth-limit"]
+    introspection:
+      enabled: true
+      endpoint: "https://auth.example.com/introspect"
+      claim_headers:
+        client_id: X-Client-ID
+    bandwidth_limit:
+      enabled: true
+      bytes_per_second: 1048576
+` + bandwidthLimit
+	}
+	_, err := load(location("      key_header: X-Client-ID\n"))
+	assert.NoError(t, err)
+
+	_, err = load(location("      key_header: X-API-Key\n      keys: [\"tenant-a\"]\n"))
+	assert.NoError(t, err)
+
+	_, err = load(location("      key_header: X-API-Key\n"))
+	assert.ErrorContains(t, err, "bandwidth_limit.key_header requires keys")
+}
+
+// TestLoadConfigurationSlotTimeout verifies that a concurrency limit slot timeout shorter than a second is rejected.
+func TestLoadConfigurationSlotTimeout(t *testing.T) {
+	load := func(slotTimeout string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_slot_timeout_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(`
+port: "8080"
+locations:
+  - path: "^/reports"
+    target_url: "http://backend"
+    concurrency_limit:
+      enabled: true
+      max_in_flight: 5
+      slot_timeout: ` + slotTimeout + `
+`))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	cfg, err := load("1s")
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.Second, cfg.Locations[0].ConcurrencyLimit.SlotTimeout)
+	}
+
+	_, err = load("2ns")
+	assert.ErrorContains(t, err, "concurrency_limit.slot_timeout 2ns must be at least 1s")
+
+	_, err = load("500ms")
+	assert.ErrorContains(t, err, "must be at least 1s")
+}
+
+// TestLoadConfigurationSecrets verifies that the file, environment, and Vault references of the secret fields are
+// resolved, that other values are kept, and that an unresolvable reference fails the load with its field.
+func TestLoadConfigurationSecrets(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_secrets_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/dito" || r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data": {"data": {"client_secret": "from-vault"}, "metadata": {"version": 3}}}`))
+	}))
+	defer vault.Close()
+
+	secretFile := filepath.Join(t.TempDir(), "redis_password")
+	assert.NoError(t, os.WriteFile(secretFile, []byte("from-file\n"), 0600))
+	t.Setenv("DITO_ADMIN_TOKEN", "from-env")
+	t.Setenv("DITO_VAULT_TOKEN", "vault-token")
+
+	cfg, err := load(`
+port: "8080"
+redis:
+  password: "file://` + secretFile + `"
+admin:
+  token: "env://DITO_ADMIN_TOKEN"
+secrets:
+  vault:
+    address: "` + vault.URL + `"
+    token: "env://DITO_VAULT_TOKEN"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend"
+    introspection:
+      enabled: true
+      endpoint: "https://auth.example.com/introspect"
+      client_secret: "vault://secret/data/dito#client_secret"
+    hmac:
+      enabled: true
+      secret: "plain"
+`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "from-file", cfg.Redis.Password)
+		assert.Equal(t, "from-env", cfg.Admin.Token)
+		assert.Equal(t, "from-vault", cfg.Locations[0].Introspection.ClientSecret)
+		assert.Equal(t, "plain", cfg.Locations[0].HMAC.Secret)
+		assert.Equal(t, config.DefaultSecretsRefreshInterval, cfg.Secrets.RefreshInterval)
+	}
+
+	_, err = load(`
+port: "8080"
+admin:
+  token: "env://DITO_MISSING_TOKEN"
+`)
+	assert.ErrorContains(t, err, "admin.token: environment variable DITO_MISSING_TOKEN is not set")
+
+	_, err = load(`
+port: "8080"
+secrets:
+  vault:
+    address: "` + vault.URL + `"
+    token: "env://DITO_VAULT_TOKEN"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend"
+    hmac:
+      enabled: true
+      secret: "vault://secret/data/dito#missing"
+`)
+	assert.ErrorContains(t, err, `locations[0].hmac.secret: vault://secret/data/dito has no string key "missing"`)
+}
+
+// TestLoadConfigurationSession verifies that the sessions of a location get their defaults, and that a short
+// secret is rejected.
+func TestLoadConfigurationSession(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_session_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	cfg, err := load(`
+port: "8080"
+locations:
+  - path: "^/app/"
+    target_url: "http://backend"
+    session:
+      enabled: true
+      secret: "0123456789abcdef0123456789abcdef"
+`)
+	if assert.NoError(t, err) {
+		session := cfg.Locations[0].Session
+		assert.Equal(t, config.DefaultSessionCookieName, session.CookieName)
+		assert.Equal(t, config.DefaultSessionTTL, session.TTL)
+		assert.Equal(t, config.SameSiteLax, session.SameSite)
+	}
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/app/"
+    target_url: "http://backend"
+    session:
+      enabled: true
+      secret: "short"
+`)
+	assert.ErrorContains(t, err, "the secret must be at least 32 bytes long")
+}
+
+// TestLoadConfigurationResponseTransforms verifies that unknown response transforms, and transforms with invalid
+// options, are rejected when the configuration is loaded.
+func TestLoadConfigurationResponseTransforms(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_transforms_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	_, err := load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend"
+    response_transforms:
+      - name: append
+        options: {text: "\n"}
+`)
+	assert.NoError(t, err)
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend"
+    response_transforms:
+      - name: missing
+`)
+	assert.ErrorContains(t, err, `location ^/api/: unknown response transform "missing"`)
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend"
+    response_transforms:
+      - name: prepend
+`)
+	assert.ErrorContains(t, err, `response transform "prepend": missing text option`)
+}
+
+// TestLoadConfigurationSLO verifies that the objectives of a location get their defaults, and that a location
+// without any objective is rejected.
+func TestLoadConfigurationSLO(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_slo_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	cfg, err := load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend"
+    slo:
+      enabled: true
+      latency:
+        threshold: 500ms
+`)
+	if assert.NoError(t, err) {
+		slo := cfg.Locations[0].SLO
+		assert.Equal(t, config.DefaultSLOWindow, slo.Window)
+		assert.Equal(t, int64(config.DefaultSLOMinRequests), slo.MinRequests)
+		assert.Equal(t, float64(config.DefaultSLOLatencyPercentile), slo.Latency.Percentile)
+	}
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend"
+    slo:
+      enabled: true
+`)
+	assert.ErrorContains(t, err, "requires a latency threshold or a maximum error rate")
+}
+
+// TestLoadConfigurationUpstreamLogging verifies that the sample of the logged upstream request bodies has a
+// default size.
+func TestLoadConfigurationUpstreamLogging(t *testing.T) {
+	file, err := os.CreateTemp("", "config_upstream_logging_test_*.yaml")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	file.Write([]byte(`
+port: "8080"
+logging:
+  upstream:
+    enabled: true
+`))
+
+	cfg, err := config.LoadConfiguration(file.Name())
+	if assert.NoError(t, err) {
+		assert.True(t, cfg.Logging.Upstream.Enabled)
+		assert.Equal(t, config.DefaultUpstreamLogBodySize, cfg.Logging.Upstream.MaxBodySize)
+	}
+}
+
+// TestLoadConfigurationRouting verifies that the locations are evaluated in configuration order by default, and
+// that unknown orders are rejected.
+func TestLoadConfigurationRouting(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_routing_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	cfg, err := load(`
+port: "8080"
+locations:
+  - path: "^/api/"
+    target_url: "http://backend:8000"
+    priority: 10
+`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, config.RoutingFirstMatch, cfg.Routing.Order)
+		assert.Equal(t, 10, cfg.Locations[0].Priority)
+	}
+
+	_, err = load(`
+port: "8080"
+routing:
+  order: random
+`)
+	assert.ErrorContains(t, err, "unknown routing order")
+}
+
+// TestLoadConfigurationLocationNames verifies that locations are labeled by their name, or by their path when
+// they have none, and that names must be unique.
+func TestLoadConfigurationLocationNames(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_names_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	cfg, err := load(`
+port: "8080"
+locations:
+  - path: "^/api/v[0-9]+/"
+    name: api
+    target_url: "http://backend:8000"
+  - path: "^/"
+    target_url: "http://frontend:8000"
+`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "api", cfg.Locations[0].Label())
+		assert.Equal(t, "^/", cfg.Locations[1].Label())
+	}
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/a"
+    name: api
+    target_url: "http://backend:8000"
+  - path: "^/b"
+    name: api
+    target_url: "http://backend:8000"
+`)
+	assert.ErrorContains(t, err, "location api: duplicate location name")
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/a"
+    name: api
+    target_url: "http://backend:8000"
+    status_rewrites: [{from: 404, to: 100}]
+`)
+	assert.ErrorContains(t, err, "location api: status rewrite", "errors name the location")
+}
+
+// TestLoadConfigurationStreams verifies that stream proxies are loaded and validated.
+func TestLoadConfigurationStreams(t *testing.T) {
+	writeConfig := func(content string) string {
+		file, err := os.CreateTemp("", "config_streams_test_*.yaml")
+		assert.NoError(t, err)
+		_, err = file.Write([]byte(content))
+		assert.NoError(t, err)
+		file.Close()
+		return file.Name()
+	}
+
+	valid := writeConfig(`
+port: "8080"
+streams:
+  - name: postgres
+    protocol: tcp
+    listen: ":5432"
+    target: "db:5432"
+  - name: dns
+    protocol: udp
+    listen: ":53"
+    target: "resolver:53"
+    idle_timeout: 30s
+`)
+	defer os.Remove(valid)
+	loadedConfig, err := config.LoadConfiguration(valid)
+	assert.NoError(t, err)
+	assert.Len(t, loadedConfig.Streams, 2)
+	assert.Equal(t, 30*time.Second, loadedConfig.Streams[1].IdleTimeout)
+
+	invalid := writeConfig(`
+port: "8080"
+streams:
+  - name: dns
+    protocol: udp
+    listen: ":53"
+    target: "resolver:53"
+    tls:
+      enabled: true
+`)
+	defer os.Remove(invalid)
+	_, err = config.LoadConfiguration(invalid)
+	assert.Error(t, err)
+}
+
+// TestLoadConfigurationRateLimitScopes verifies the validation of rate limit scopes.
+func TestLoadConfigurationRateLimitScopes(t *testing.T) {
+	load := func(content string) error {
+		file, err := os.CreateTemp("", "config_scopes_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		_, err = file.Write([]byte(content))
+		assert.NoError(t, err)
+		file.Close()
+		_, err = config.LoadConfiguration(file.Name())
+		return err
+	}
+
+	assert.NoError(t, load(`
+port: "8080"
+locations:
+  - path: "^/orders"
+    target_url: "http://backend:8000"
+    rate_limiting: {enabled: true, requests_per_second: 10, burst: 20, scope: group, group: backend}
+  - path: "^/invoices"
+    target_url: "http://backend:8000"
+    rate_limiting: {enabled: true, requests_per_second: 10, burst: 20, scope: group, group: backend}
+`))
+
+	assert.Error(t, load(`
+port: "8080"
+locations:
+  - path: "^/orders"
+    target_url: "http://backend:8000"
+    rate_limiting: {enabled: true, requests_per_second: 10, burst: 20, scope: group}
+`))
+
+	assert.Error(t, load(`
+port: "8080"
+locations:
+  - path: "^/orders"
+    target_url: "http://backend:8000"
+    rate_limiting: {enabled: true, requests_per_second: 10, burst: 20, scope: global}
+  - path: "^/invoices"
+    target_url: "http://backend:8000"
+    rate_limiting: {enabled: true, requests_per_second: 5, burst: 20, scope: global}
+`))
+
+	assert.Error(t, load(`
+port: "8080"
+locations:
+  - path: "^/orders"
+    target_url: "http://backend:8000"
+    rate_limiting: {enabled: true, requests_per_second: 10, scope: tenant}
+`))
+}
+
+// TestLoadConfigurationPolicies verifies that locations inherit the settings of their policy and override them.
+func TestLoadConfigurationPolicies(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_policies_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		_, err = file.Write([]byte(content))
+		assert.NoError(t, err)
+		file.Close()
+		return config.LoadConfiguration(file.Name())
+	}
+
+	loadedConfig, err := load(`
+port: "8080"
+policies:
+  partner:
+    middlewares: ["auth", "rate-limiter"]
+    rate_limiting: {enabled: true, requests_per_second: 10, burst: 20}
+    additional_headers: {X-Tier: partner, X-Env: prod}
+    deadline: {timeout: 5s}
+locations:
+  - path: "^/orders"
+    target_url: "http://orders:8000"
+    policy: partner
+  - path: "^/reports"
+    target_url: "http://reports:8000"
+    policy: partner
+    rate_limiting: {enabled: true, requests_per_second: 1, burst: 2}
+    additional_headers: {X-Env: staging}
+`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	orders, reports := loadedConfig.Locations[0], loadedConfig.Locations[1]
+	assert.Equal(t, []string{"auth", "rate-limiter"}, orders.Middlewares)
+	assert.Equal(t, 10.0, orders.RateLimiting.RequestsPerSecond)
+	assert.Equal(t, 5*time.Second, orders.Deadline.Timeout)
+	assert.NotNil(t, orders.CompiledRegex)
+
+	assert.Equal(t, []string{"auth", "rate-limiter"}, reports.Middlewares)
+	assert.Equal(t, 1.0, reports.RateLimiting.RequestsPerSecond)
+	assert.Equal(t, map[string]string{"X-Tier": "partner", "X-Env": "staging"}, reports.AdditionalHeaders)
+
+	_, err = load(`
+port: "8080"
+locations:
+  - path: "^/orders"
+    target_url: "http://orders:8000"
+    policy: missing
+`)
+	assert.Error(t, err)
+}
+
+func TestLoadConfigurationOpenAPI(t *testing.T) {
+	spec, err := os.CreateTemp("", "config_openapi_spec_*.yaml")
+	assert.NoError(t, err)
+	defer os.Remove(spec.Name())
+	_, err = spec.Write([]byte(`
+openapi: 3.0.3
+servers:
+  - url: https://api.example.com/v1
+paths:
+  /pets:
+    get: {operationId: listPets}
+  /pets/{petId}:
+    get: {operationId: getPet}
+    delete: {operationId: deletePet}
+`))
+	assert.NoError(t, err)
+	spec.Close()
+
+	file, err := os.CreateTemp("", "config_openapi_test_*.yaml")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.Write([]byte(`
+port: "8080"
+locations:
+  - path: "^/health$"
+    target_url: "http://health:8000"
+  - openapi: "` + spec.Name() + `"
+    name: petstore
+    middlewares: ["auth", "request-validation"]
+    validation: {enabled: true}
+`))
+	assert.NoError(t, err)
+	file.Close()
+
+	loadedConfig, err := config.LoadConfiguration(file.Name())
+	if !assert.NoError(t, err) || !assert.Len(t, loadedConfig.Locations, 3) {
+		return
+	}
+	pets, pet := loadedConfig.Locations[1], loadedConfig.Locations[2]
+	assert.Equal(t, "^/pets$", pets.Path)
+	assert.Equal(t, []string{"GET"}, pets.Methods)
+	assert.Equal(t, "https://api.example.com/v1", pets.TargetURL)
+	assert.Equal(t, []string{"auth", "request-validation"}, pets.Middlewares)
+	assert.Equal(t, "^/pets/[^/]+$", pet.Path)
+	assert.Equal(t, []string{"GET", "DELETE"}, pet.Methods)
+	assert.Equal(t, "petstore /pets/{petId}", pet.Name, "generated locations get distinct names")
+	assert.True(t, pet.CompiledRegex.MatchString("/pets/42"))
+	assert.NotNil(t, pets.Validation.Validator)
+	assert.Same(t, pets.Validation.Validator, pet.Validation.Validator)
+}
+
+// TestLoadConfigurationProfilingToken verifies that profiling is rejected on an admin API without a token.
+func TestLoadConfigurationProfilingToken(t *testing.T) {
+	load := func(content string) (*config.ProxyConfig, error) {
+		file, err := os.CreateTemp("", "config_profiling_test_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		file.Write([]byte(content))
+		return config.LoadConfiguration(file.Name())
+	}
+
+	_, err := load(`
+port: "8080"
+admin:
+  enabled: true
+  profiling:
+    enabled: true
+`)
+	assert.ErrorContains(t, err, "admin.profiling requires an admin.token")
+
+	cfg, err := load(`
+port: "8080"
+admin:
+  enabled: true
+  token: "secret"
+  profiling:
+    enabled: true
+`)
+	if assert.NoError(t, err) {
+		assert.True(t, cfg.Admin.Profiling.Enabled)
+	}
+}
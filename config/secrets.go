@@ -0,0 +1,362 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schemes of the secret references.
+const (
+	SecretSchemeFile       = "file://"
+	SecretSchemeEnv        = "env://"
+	SecretSchemeVault      = "vault://"
+	SecretSchemeKubernetes = "k8s://"
+)
+
+// Defaults of the secret resolution.
+const (
+	DefaultSecretsRefreshInterval = time.Minute
+	DefaultVaultTimeout           = 5 * time.Second
+)
+
+// kubernetesServiceAccountDir holds the token and the CA certificate of the service account of the pod.
+const kubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// maxSecretResponseSize is the largest response read from Vault or the Kubernetes API.
+const maxSecretResponseSize = 1 << 20
+
+// SecretsConfig holds how the secret references of the configuration are resolved.
+//
+// Fields:
+// - RefreshInterval: How often the configuration is reloaded, when it holds secret references and hot reload is
+// enabled, so that rotated secrets are applied. Defaults to 1m; a negative value disables the refresh.
+// - Vault: The Vault server resolving the vault:// references.
+type SecretsConfig struct {
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	Vault           VaultConfig   `yaml:"vault"`
+}
+
+// VaultConfig holds the connection to a Vault server.
+//
+// Fields:
+// - Address: The address of the server (e.g. https://vault:8200). Defaults to the VAULT_ADDR environment variable.
+// - Token: The token authenticating the requests, which may be a file:// or env:// reference. Defaults to the
+// VAULT_TOKEN environment variable.
+// - Timeout: The maximum duration of a request. Defaults to 5s.
+type VaultConfig struct {
+	Address string        `yaml:"address"`
+	Token   string        `yaml:"token" secret:"true"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// secretResolver resolves the secret references of a configuration. The documents read from Vault and the
+// Kubernetes API are cached, so that the keys of a secret are fetched once per load.
+type secretResolver struct {
+	vault      VaultConfig
+	documents  map[string]map[string]any
+	references int
+}
+
+// resolveSecrets replaces the secret references of the fields tagged `secret:"true"` with their values:
+// file://<path> with the trimmed content of the file, env://<name> with an environment variable,
+// vault://<path>#<key> with a key of a Vault secret, and k8s://<namespace>/<name>#<key> with a key of a
+// Kubernetes secret. Other values are kept as they are.
+//
+// Parameters:
+// - config: The configuration, updated in place.
+//
+// Returns:
+// - error: An error if a reference cannot be resolved.
+func resolveSecrets(config *ProxyConfig) error {
+	resolver := &secretResolver{documents: map[string]map[string]any{}}
+	// The Vault token is resolved first, since the vault:// references need it.
+	if err := resolver.walk(reflect.ValueOf(&config.Secrets).Elem(), "secrets"); err != nil {
+		return err
+	}
+	if config.Secrets.RefreshInterval == 0 {
+		config.Secrets.RefreshInterval = DefaultSecretsRefreshInterval
+	}
+	if config.Secrets.Vault.Address == "" {
+		config.Secrets.Vault.Address = os.Getenv("VAULT_ADDR")
+	}
+	if config.Secrets.Vault.Token == "" {
+		config.Secrets.Vault.Token = os.Getenv("VAULT_TOKEN")
+	}
+	if config.Secrets.Vault.Timeout <= 0 {
+		config.Secrets.Vault.Timeout = DefaultVaultTimeout
+	}
+	resolver.vault = config.Secrets.Vault
+
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Name == "Secrets" || !field.IsExported() {
+			continue
+		}
+		if err := resolver.walk(value.Field(i), yamlName(field)); err != nil {
+			return err
+		}
+	}
+	config.secretReferences = resolver.references
+	return nil
+}
+
+// walk resolves the secret fields of a value of the configuration, recursing into its structs, pointers, and
+// slices. Maps are skipped: the policies they hold are already applied to the locations.
+//
+// Parameters:
+// - value: The value, which must be settable.
+// - path: The path of the value in the configuration, used in the errors.
+//
+// Returns:
+// - error: An error if a reference cannot be resolved.
+func (r *secretResolver) walk(value reflect.Value, path string) error {
+	switch value.Kind() {
+	case reflect.Pointer:
+		if value.IsNil() || value.Elem().Type().PkgPath() != configPkgPath {
+			return nil
+		}
+		return r.walk(value.Elem(), path)
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			if err := r.walk(value.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		if value.Type().PkgPath() != configPkgPath {
+			return nil
+		}
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			fieldPath := path + "." + yamlName(field)
+			if field.Tag.Get("secret") == "true" && field.Type.Kind() == reflect.String {
+				resolved, err := r.resolve(value.Field(i).String())
+				if err != nil {
+					return fmt.Errorf("%s: %v", fieldPath, err)
+				}
+				value.Field(i).SetString(resolved)
+				continue
+			}
+			if field.Tag.Get("secret") == "true" && field.Type == reflect.TypeOf([]string(nil)) {
+				for j := 0; j < value.Field(i).Len(); j++ {
+					item := value.Field(i).Index(j)
+					resolved, err := r.resolve(item.String())
+					if err != nil {
+						return fmt.Errorf("%s[%d]: %v", fieldPath, j, err)
+					}
+					item.SetString(resolved)
+				}
+				continue
+			}
+			if err := r.walk(value.Field(i), fieldPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// configPkgPath is the import path of this package; the walk does not leave its types.
+var configPkgPath = reflect.TypeOf(ProxyConfig{}).PkgPath()
+
+// yamlName returns the YAML name of a struct field.
+func yamlName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// resolve returns the value of a secret reference, or the value itself if it is not a reference.
+//
+// Parameters:
+// - value: The value of the secret field.
+//
+// Returns:
+// - string: The secret.
+// - error: An error if the reference cannot be resolved.
+func (r *secretResolver) resolve(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, SecretSchemeFile):
+		r.references++
+		data, err := os.ReadFile(strings.TrimPrefix(value, SecretSchemeFile))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	case strings.HasPrefix(value, SecretSchemeEnv):
+		r.references++
+		name := strings.TrimPrefix(value, SecretSchemeEnv)
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, SecretSchemeVault):
+		r.references++
+		return r.lookup(value, SecretSchemeVault, r.readVault)
+	case strings.HasPrefix(value, SecretSchemeKubernetes):
+		r.references++
+		return r.lookup(value, SecretSchemeKubernetes, r.readKubernetes)
+	}
+	return value, nil
+}
+
+// lookup returns a key of a secret document, reading the document on first use.
+//
+// Parameters:
+// - value: The reference, <scheme><path>#<key>.
+// - scheme: The scheme of the reference.
+// - read: The function reading the document at a path.
+//
+// Returns:
+// - string: The value of the key.
+// - error: An error if the reference is malformed, the document cannot be read, or it lacks the key.
+func (r *secretResolver) lookup(value, scheme string, read func(path string) (map[string]any, error)) (string, error) {
+	path, key, ok := strings.Cut(strings.TrimPrefix(value, scheme), "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("invalid secret reference %q: expected %s<path>#<key>", value, scheme)
+	}
+	document, ok := r.documents[scheme+path]
+	if !ok {
+		var err error
+		if document, err = read(path); err != nil {
+			return "", fmt.Errorf("failed to read %s%s: %v", scheme, path, err)
+		}
+		r.documents[scheme+path] = document
+	}
+	secret, ok := document[key].(string)
+	if !ok {
+		return "", fmt.Errorf("%s%s has no string key %q", scheme, path, key)
+	}
+	return secret, nil
+}
+
+// readVault reads a secret from Vault. The data of KV version 2 secrets is unwrapped.
+//
+// Parameters:
+// - path: The path of the secret, including its mount (e.g. secret/data/dito).
+//
+// Returns:
+// - map[string]any: The keys of the secret.
+// - error: An error if Vault cannot be reached or does not answer the secret.
+func (r *secretResolver) readVault(path string) (map[string]any, error) {
+	if r.vault.Address == "" {
+		return nil, fmt.Errorf("no Vault address configured")
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(r.vault.Address, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", r.vault.Token)
+
+	var response struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := getJSON(&http.Client{Timeout: r.vault.Timeout}, req, &response); err != nil {
+		return nil, err
+	}
+	if data, ok := response.Data["data"].(map[string]any); ok {
+		return data, nil
+	}
+	return response.Data, nil
+}
+
+// readKubernetes reads a secret from the Kubernetes API, with the service account of the pod.
+//
+// Parameters:
+// - path: The namespace and the name of the secret, <namespace>/<name>.
+//
+// Returns:
+// - map[string]any: The keys of the secret, decoded.
+// - error: An error if the API cannot be reached or does not answer the secret.
+func (r *secretResolver) readKubernetes(path string) (map[string]any, error) {
+	namespace, name, ok := strings.Cut(path, "/")
+	if !ok || namespace == "" || name == "" {
+		return nil, fmt.Errorf("expected <namespace>/<name>")
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster")
+	}
+	token, err := os.ReadFile(kubernetesServiceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(kubernetesServiceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid service account CA certificate")
+	}
+
+	endpoint := fmt.Sprintf("https://%s/api/v1/namespaces/%s/secrets/%s", net.JoinHostPort(host, port), url.PathEscape(namespace), url.PathEscape(name))
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	client := &http.Client{
+		Timeout:   DefaultVaultTimeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+	}
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := getJSON(client, req, &secret); err != nil {
+		return nil, err
+	}
+	data := make(map[string]any, len(secret.Data))
+	for key, encoded := range secret.Data {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %v", key, err)
+		}
+		data[key] = string(decoded)
+	}
+	return data, nil
+}
+
+// getJSON sends a request and decodes its JSON response.
+//
+// Parameters:
+// - client: The HTTP client sending the request.
+// - req: The request.
+// - v: The value the response is decoded into.
+//
+// Returns:
+// - error: An error if the request fails, the response is not 200, or its body cannot be decoded.
+func getJSON(client *http.Client, req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %d", req.URL.Host, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxSecretResponseSize)).Decode(v); err != nil {
+		return fmt.Errorf("invalid response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package dnscache
+
+import (
+	"context"
+	"dito/config"
+	"dito/metrics"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTTL is how long successful lookups are cached when no TTL is configured.
+	DefaultTTL = 30 * time.Second
+	// DefaultNegativeTTL is how long "host not found" answers are cached when no negative TTL is configured.
+	DefaultNegativeTTL = 5 * time.Second
+
+	// lookupTimeout bounds a lookup shared by concurrent callers, independently of their own contexts.
+	lookupTimeout = 10 * time.Second
+)
+
+// Resolver is an in-process DNS cache for upstream host names.
+//
+// Successful answers are cached for the configured TTL and "host not found" answers for the
+// negative TTL. Concurrent lookups of the same host share a single query. Other failures
+// (timeouts, unreachable resolver) are never cached. When disabled, every lookup goes to the
+// system resolver.
+type Resolver struct {
+	mu          sync.Mutex
+	entries     map[string]*entry
+	enabled     bool
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	// lookupHost resolves a host name, net.DefaultResolver.LookupHost by default.
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+}
+
+// entry is a cached answer, or a lookup in progress until ready is closed.
+type entry struct {
+	ready   chan struct{}
+	addrs   []string
+	err     error
+	expires time.Time
+}
+
+// New creates a resolver with the given configuration.
+//
+// Parameters:
+// - dnsConfig: The DNS cache configuration.
+//
+// Returns:
+// - *Resolver: The resolver.
+func New(dnsConfig config.DNSConfig) *Resolver {
+	r := &Resolver{
+		entries:    make(map[string]*entry),
+		lookupHost: net.DefaultResolver.LookupHost,
+	}
+	r.Configure(dnsConfig)
+	return r
+}
+
+// Configure applies a new configuration. Cached answers are kept, but expire according to the TTL
+// they were stored with; disabling the cache flushes it.
+//
+// Parameters:
+// - dnsConfig: The DNS cache configuration.
+func (r *Resolver) Configure(dnsConfig config.DNSConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enabled = dnsConfig.Enabled
+	r.ttl = dnsConfig.TTL
+	if r.ttl <= 0 {
+		r.ttl = DefaultTTL
+	}
+	r.negativeTTL = dnsConfig.NegativeTTL
+	if r.negativeTTL <= 0 {
+		r.negativeTTL = DefaultNegativeTTL
+	}
+	if !r.enabled {
+		r.flushLocked()
+	}
+}
+
+// Flush removes every cached answer.
+//
+// Returns:
+// - int: The number of entries removed.
+func (r *Resolver) Flush() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.flushLocked()
+}
+
+// flushLocked removes every completed entry. Lookups in progress are left to their waiters.
+func (r *Resolver) flushLocked() int {
+	flushed := 0
+	for host, e := range r.entries {
+		select {
+		case <-e.ready:
+			delete(r.entries, host)
+			flushed++
+		default:
+		}
+	}
+	return flushed
+}
+
+// LookupHost returns the addresses of the host, from the cache when possible.
+//
+// Parameters:
+// - ctx: The context of the caller.
+// - host: The host name to resolve.
+//
+// Returns:
+// - []string: The addresses of the host.
+// - error: An error if the host could not be resolved.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	if !r.enabled {
+		r.mu.Unlock()
+		return r.lookupHost(ctx, host)
+	}
+
+	e, ok := r.entries[host]
+	if ok {
+		select {
+		case <-e.ready:
+			if time.Now().After(e.expires) {
+				ok = false
+			}
+		default:
+		}
+	}
+	if ok {
+		r.mu.Unlock()
+		return r.wait(ctx, e)
+	}
+
+	e = &entry{ready: make(chan struct{})}
+	r.entries[host] = e
+	r.mu.Unlock()
+	metrics.RecordDNSLookup("miss")
+
+	go r.resolve(ctx, host, e)
+	select {
+	case <-e.ready:
+		return e.addrs, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// wait returns the answer of a cached entry, waiting for it if the lookup is still in progress.
+func (r *Resolver) wait(ctx context.Context, e *entry) ([]string, error) {
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	switch {
+	case e.err == nil:
+		metrics.RecordDNSLookup("hit")
+	case isNotFound(e.err):
+		metrics.RecordDNSLookup("negative_hit")
+	}
+	return e.addrs, e.err
+}
+
+// resolve performs the lookup for an entry and decides whether its answer may be cached.
+func (r *Resolver) resolve(ctx context.Context, host string, e *entry) {
+	// The lookup is shared with other callers: do not let the first caller's cancellation fail it.
+	lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lookupTimeout)
+	defer cancel()
+	addrs, err := r.lookupHost(lookupCtx, host)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.addrs, e.err = addrs, err
+	switch {
+	case err == nil:
+		e.expires = time.Now().Add(r.ttl)
+	case isNotFound(err):
+		e.expires = time.Now().Add(r.negativeTTL)
+	default:
+		metrics.RecordDNSLookup("error")
+		if r.entries[host] == e {
+			delete(r.entries, host)
+		}
+	}
+	close(e.ready)
+}
+
+// DialContext returns a dial function that resolves host names through the cache and tries
+// each address in turn with the given dialer.
+//
+// Parameters:
+// - dialer: The dialer used to open the connections.
+//
+// Returns:
+// - func(ctx context.Context, network, addr string) (net.Conn, error): The dial function.
+func (r *Resolver) DialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		addrs, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		var dialErr error
+		for _, ip := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			dialErr = err
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		if dialErr == nil {
+			dialErr = &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+		}
+		return nil, dialErr
+	}
+}
+
+// isNotFound reports whether the error is an authoritative "host not found" answer.
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
@@ -0,0 +1,132 @@
+package dnscache
+
+import (
+	"context"
+	"dito/config"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestResolver creates an enabled resolver whose lookups are answered by the given function.
+func newTestResolver(ttl, negativeTTL time.Duration, lookup func(host string) ([]string, error)) (*Resolver, *atomic.Int32) {
+	calls := &atomic.Int32{}
+	r := New(config.DNSConfig{Enabled: true, TTL: ttl, NegativeTTL: negativeTTL})
+	r.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		calls.Add(1)
+		return lookup(host)
+	}
+	return r, calls
+}
+
+// TestLookupHostCachesAnswers tests that answers are served from the cache until they expire.
+func TestLookupHostCachesAnswers(t *testing.T) {
+	r, calls := newTestResolver(50*time.Millisecond, time.Second, func(host string) ([]string, error) {
+		return []string{"10.0.0.1"}, nil
+	})
+
+	for i := 0; i < 3; i++ {
+		addrs, err := r.LookupHost(context.Background(), "upstream.local")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"10.0.0.1"}, addrs)
+	}
+	assert.Equal(t, int32(1), calls.Load())
+
+	time.Sleep(60 * time.Millisecond)
+	_, err := r.LookupHost(context.Background(), "upstream.local")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+// TestLookupHostNegativeCaching tests that only "not found" answers are cached as failures.
+func TestLookupHostNegativeCaching(t *testing.T) {
+	notFound := &net.DNSError{Err: "no such host", Name: "missing.local", IsNotFound: true}
+	r, calls := newTestResolver(time.Minute, time.Minute, func(host string) ([]string, error) {
+		if host == "missing.local" {
+			return nil, notFound
+		}
+		return nil, errors.New("resolver unreachable")
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := r.LookupHost(context.Background(), "missing.local")
+		assert.ErrorIs(t, err, notFound)
+	}
+	assert.Equal(t, int32(1), calls.Load())
+
+	for i := 0; i < 2; i++ {
+		_, err := r.LookupHost(context.Background(), "flaky.local")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+// TestLookupHostSharesConcurrentLookups tests that concurrent callers share a single query.
+func TestLookupHostSharesConcurrentLookups(t *testing.T) {
+	release := make(chan struct{})
+	r, calls := newTestResolver(time.Minute, time.Minute, func(host string) ([]string, error) {
+		<-release
+		return []string{"10.0.0.1"}, nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addrs, err := r.LookupHost(context.Background(), "upstream.local")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"10.0.0.1"}, addrs)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+// TestFlushAndDisable tests that flushing and disabling the cache force new lookups.
+func TestFlushAndDisable(t *testing.T) {
+	r, calls := newTestResolver(time.Minute, time.Minute, func(host string) ([]string, error) {
+		return []string{"10.0.0.1"}, nil
+	})
+
+	r.LookupHost(context.Background(), "upstream.local")
+	assert.Equal(t, 1, r.Flush())
+	r.LookupHost(context.Background(), "upstream.local")
+	assert.Equal(t, int32(2), calls.Load())
+
+	r.Configure(config.DNSConfig{Enabled: false})
+	r.LookupHost(context.Background(), "upstream.local")
+	r.LookupHost(context.Background(), "upstream.local")
+	assert.Equal(t, int32(4), calls.Load())
+}
+
+// TestDialContext tests that host names are dialed through the cached addresses.
+func TestDialContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	r, _ := newTestResolver(time.Minute, time.Minute, func(host string) ([]string, error) {
+		// The first address refuses connections, the second one is the listener.
+		return []string{"127.0.0.2", "127.0.0.1"}, nil
+	})
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	conn, err := r.DialContext(&net.Dialer{Timeout: time.Second})(context.Background(), "tcp", net.JoinHostPort("upstream.local", port))
+	assert.NoError(t, err)
+	if conn != nil {
+		conn.Close()
+	}
+}
@@ -0,0 +1,129 @@
+package geoip
+
+import (
+	"context"
+	"net/netip"
+)
+
+// Location is the geographic information resolved for a client address.
+type Location struct {
+	Country      string // Country is the ISO 3166-1 alpha-2 code of the country, e.g. "IT" (empty when unknown).
+	ASN          uint   // ASN is the number of the autonomous system (0 when unknown).
+	Organization string // Organization is the name of the organization of the autonomous system.
+}
+
+// DB resolves client addresses with a country database and an autonomous system database, either optional.
+type DB struct {
+	country *Reader
+	asn     *Reader
+}
+
+// OpenDB opens the MaxMind DB files resolving the countries and the autonomous systems.
+//
+// Parameters:
+// - countryPath: The path of a database with countries, such as GeoLite2-Country or GeoLite2-City (empty skips it).
+// - asnPath: The path of a database with autonomous systems, such as GeoLite2-ASN (empty skips it).
+//
+// Returns:
+// - *DB: The databases.
+// - error: An error if a file cannot be read or is not a MaxMind DB.
+func OpenDB(countryPath, asnPath string) (*DB, error) {
+	db := &DB{}
+	var err error
+	if countryPath != "" {
+		if db.country, err = Open(countryPath); err != nil {
+			return nil, err
+		}
+	}
+	if asnPath != "" {
+		if db.asn, err = Open(asnPath); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// NewDB builds the databases from readers, either of which may be nil.
+//
+// Parameters:
+// - country: The reader of the country database.
+// - asn: The reader of the autonomous system database.
+//
+// Returns:
+// - *DB: The databases.
+func NewDB(country, asn *Reader) *DB {
+	return &DB{country: country, asn: asn}
+}
+
+// Lookup resolves the country and the autonomous system of an address. Addresses the databases do not hold,
+// such as private ones, resolve to an empty location.
+//
+// Parameters:
+// - addr: The client address.
+//
+// Returns:
+// - Location: The resolved location.
+func (db *DB) Lookup(addr netip.Addr) Location {
+	var location Location
+	if record, ok := lookupMap(db.country, addr); ok {
+		location.Country = isoCode(record, "country")
+		if location.Country == "" {
+			// Anonymous proxies and satellite providers only have the country of their registration.
+			location.Country = isoCode(record, "registered_country")
+		}
+	}
+	if record, ok := lookupMap(db.asn, addr); ok {
+		location.ASN = uintValue(record["autonomous_system_number"])
+		location.Organization, _ = record["autonomous_system_organization"].(string)
+	}
+	return location
+}
+
+// lookupMap looks up the record of an address in a database that may be nil. Corrupted records are ignored.
+func lookupMap(reader *Reader, addr netip.Addr) (map[string]any, bool) {
+	if reader == nil {
+		return nil, false
+	}
+	record, ok, err := reader.Lookup(addr)
+	if err != nil || !ok {
+		return nil, false
+	}
+	values, ok := record.(map[string]any)
+	return values, ok
+}
+
+// isoCode returns the ISO code of a country entry of a record.
+func isoCode(record map[string]any, entry string) string {
+	country, _ := record[entry].(map[string]any)
+	code, _ := country["iso_code"].(string)
+	return code
+}
+
+// locationKey is the request context key of the resolved location.
+type locationKey struct{}
+
+// WithLocation attaches the location of the client to a context.
+//
+// Parameters:
+// - ctx: The request context.
+// - location: The location of the client.
+//
+// Returns:
+// - context.Context: The context carrying the location.
+func WithLocation(ctx context.Context, location Location) context.Context {
+	return context.WithValue(ctx, locationKey{}, location)
+}
+
+// FromContext returns the location of the client attached to a context, so that middlewares and transforms
+// can use it.
+//
+// Parameters:
+// - ctx: The request context.
+//
+// Returns:
+// - Location: The location of the client.
+// - bool: False if no location was resolved, e.g. without GeoIP databases.
+func FromContext(ctx context.Context) (Location, bool) {
+	location, ok := ctx.Value(locationKey{}).(Location)
+	return location, ok
+}
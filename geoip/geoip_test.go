@@ -0,0 +1,308 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPointer is a value encoded as a pointer to an offset of the data section.
+type testPointer uint
+
+// testNode is a node of the search tree written by buildDB.
+type testNode struct {
+	children [2]*testNode
+	data     int // data is the offset of the record of a leaf, -1 for inner nodes.
+}
+
+// buildDB writes a MaxMind DB holding the given records, keyed by network.
+func buildDB(t *testing.T, ipVersion, recordSize uint, networks map[string]any) []byte {
+	t.Helper()
+	var data bytes.Buffer
+	root := &testNode{data: -1}
+	for network, record := range networks {
+		prefix := netip.MustParsePrefix(network)
+		offset := data.Len()
+		data.Write(encodeValue(record))
+
+		addr := prefix.Addr()
+		bits := prefix.Bits()
+		var ip []byte
+		if addr.Is4() && ipVersion == 6 {
+			v6 := netip.AddrFrom16([16]byte{12: addr.As4()[0], 13: addr.As4()[1], 14: addr.As4()[2], 15: addr.As4()[3]}).As16()
+			ip, bits = v6[:], bits+96
+		} else {
+			ip = addr.AsSlice()
+		}
+		node := root
+		for i := 0; i < bits; i++ {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if i == bits-1 {
+				node.children[bit] = &testNode{data: offset}
+				break
+			}
+			if node.children[bit] == nil {
+				node.children[bit] = &testNode{data: -1}
+			}
+			node = node.children[bit]
+		}
+	}
+
+	var nodes []*testNode
+	index := map[*testNode]uint{}
+	var number func(n *testNode)
+	number = func(n *testNode) {
+		index[n] = uint(len(nodes))
+		nodes = append(nodes, n)
+		for _, child := range n.children {
+			if child != nil && child.data < 0 {
+				number(child)
+			}
+		}
+	}
+	number(root)
+	nodeCount := uint(len(nodes))
+	value := func(child *testNode) uint {
+		switch {
+		case child == nil:
+			return nodeCount
+		case child.data < 0:
+			return index[child]
+		}
+		return nodeCount + dataSectionSeparator + uint(child.data)
+	}
+
+	var file bytes.Buffer
+	for _, n := range nodes {
+		left, right := value(n.children[0]), value(n.children[1])
+		switch recordSize {
+		case 24:
+			file.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			file.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(left>>24)<<4 | byte(right>>24), byte(right >> 16), byte(right >> 8), byte(right)})
+		case 32:
+			file.Write(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, uint32(left)), uint32(right)))
+		}
+	}
+	file.Write(make([]byte, dataSectionSeparator))
+	file.Write(data.Bytes())
+	file.Write(metadataMarker)
+	file.Write(encodeValue(map[string]any{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+		"ip_version":                  uint16(ipVersion),
+		"database_type":               "Test-Country",
+		"binary_format_major_version": uint16(2),
+	}))
+	return file.Bytes()
+}
+
+// encodeValue encodes a value in the MaxMind DB data format.
+func encodeValue(value any) []byte {
+	control := func(kind uint, size int) []byte {
+		var b []byte
+		switch {
+		case size < 29:
+			b = []byte{byte(size)}
+		case size < 285:
+			b = []byte{29, byte(size - 29)}
+		default:
+			b = []byte{30, byte((size - 285) >> 8), byte(size - 285)}
+		}
+		if kind > 7 {
+			return append([]byte{b[0], byte(kind - 7)}, b[1:]...)
+		}
+		b[0] |= byte(kind << 5)
+		return b
+	}
+	trimmed := func(v uint64, size int) []byte {
+		b := binary.BigEndian.AppendUint64(nil, v)[8-size:]
+		return bytes.TrimLeft(b, "\x00")
+	}
+
+	switch v := value.(type) {
+	case testPointer:
+		return []byte{typePointer<<5 | byte(v>>8&0x7), byte(v)}
+	case string:
+		return append(control(typeString, len(v)), v...)
+	case uint16:
+		b := trimmed(uint64(v), 2)
+		return append(control(typeUint16, len(b)), b...)
+	case uint32:
+		b := trimmed(uint64(v), 4)
+		return append(control(typeUint32, len(b)), b...)
+	case uint64:
+		b := trimmed(v, 8)
+		return append(control(typeUint64, len(b)), b...)
+	case int32:
+		b := binary.BigEndian.AppendUint32(nil, uint32(v))
+		return append(control(typeInt32, len(b)), b...)
+	case float64:
+		return append(control(typeDouble, 8), binary.BigEndian.AppendUint64(nil, math.Float64bits(v))...)
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		return control(typeBool, size)
+	case []any:
+		b := control(typeArray, len(v))
+		for _, item := range v {
+			b = append(b, encodeValue(item)...)
+		}
+		return b
+	case map[string]any:
+		b := control(typeMap, len(v))
+		for key, item := range v {
+			b = append(b, encodeValue(key)...)
+			b = append(b, encodeValue(item)...)
+		}
+		return b
+	}
+	panic("unsupported test value")
+}
+
+// countryRecord is a record of a country database.
+func countryRecord(code string) map[string]any {
+	return map[string]any{"country": map[string]any{"iso_code": code, "names": map[string]any{"en": code}}}
+}
+
+// TestReaderLookup verifies the lookups of IPv4 and IPv6 addresses with every record size.
+func TestReaderLookup(t *testing.T) {
+	for _, recordSize := range []uint{24, 28, 32} {
+		db, err := NewReader(buildDB(t, 6, recordSize, map[string]any{
+			"192.0.2.0/24":    countryRecord("IT"),
+			"198.51.100.0/25": countryRecord("FR"),
+			"2001:db8::/32":   countryRecord("DE"),
+		}))
+		require.NoError(t, err, "record size %d", recordSize)
+		assert.Equal(t, "Test-Country", db.DatabaseType)
+
+		for addr, expected := range map[string]string{
+			"192.0.2.77":       "IT",
+			"::ffff:192.0.2.1": "IT",
+			"198.51.100.127":   "FR",
+			"2001:db8:1234::1": "DE",
+			"198.51.100.128":   "",
+			"203.0.113.1":      "",
+			"2001:db9::1":      "",
+		} {
+			record, ok, err := db.Lookup(netip.MustParseAddr(addr))
+			assert.NoError(t, err, addr)
+			if expected == "" {
+				assert.False(t, ok, addr)
+				continue
+			}
+			if assert.True(t, ok, addr) {
+				assert.Equal(t, expected, isoCode(record.(map[string]any), "country"), addr)
+			}
+		}
+	}
+}
+
+// TestReaderIPv4Database verifies the lookups in an IPv4-only database, which holds no IPv6 address.
+func TestReaderIPv4Database(t *testing.T) {
+	db, err := NewReader(buildDB(t, 4, 24, map[string]any{"10.0.0.0/8": countryRecord("US")}))
+	require.NoError(t, err)
+
+	record, ok, err := db.Lookup(netip.MustParseAddr("10.1.2.3"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "US", isoCode(record.(map[string]any), "country"))
+
+	_, ok, err = db.Lookup(netip.MustParseAddr("2001:db8::1"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+// TestDecode verifies the decoding of every data type, of pointers, and of sizes encoded on extra bytes.
+func TestDecode(t *testing.T) {
+	long := string(bytes.Repeat([]byte("x"), 300))
+	var data []byte
+	data = append(data, encodeValue("shared")...)
+	data = append(data, encodeValue(map[string]any{
+		"pointer": testPointer(0),
+		"long":    long,
+		"uint16":  uint16(443),
+		"uint32":  uint32(4200000000),
+		"uint64":  uint64(1) << 40,
+		"int32":   int32(-7),
+		"double":  2.5,
+		"bool":    true,
+		"array":   []any{"a", uint32(1)},
+	})...)
+
+	value, next, err := decoder(data).decode(uint(len(encodeValue("shared"))), 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint(len(data)), next)
+	assert.Equal(t, map[string]any{
+		"pointer": "shared",
+		"long":    long,
+		"uint16":  uint64(443),
+		"uint32":  uint64(4200000000),
+		"uint64":  uint64(1) << 40,
+		"int32":   int32(-7),
+		"double":  2.5,
+		"bool":    true,
+		"array":   []any{"a", uint64(1)},
+	}, value)
+
+	_, _, err = decoder(encodeValue(long)[:10]).decode(0, 0)
+	assert.ErrorIs(t, err, errTruncated)
+	_, _, err = decoder{typePointer << 5, 0}.decode(0, 0)
+	assert.Error(t, err, "a pointer to itself must not recurse forever")
+}
+
+// TestOpen verifies that database files are read, and that other files are rejected.
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "country.mmdb")
+	require.NoError(t, os.WriteFile(path, buildDB(t, 6, 28, map[string]any{"192.0.2.0/24": countryRecord("IT")}), 0o644))
+	_, err := Open(path)
+	assert.NoError(t, err)
+
+	invalid := filepath.Join(dir, "invalid.mmdb")
+	require.NoError(t, os.WriteFile(invalid, []byte("not a database"), 0o644))
+	_, err = Open(invalid)
+	assert.ErrorContains(t, err, "metadata not found")
+
+	_, err = Open(filepath.Join(dir, "missing.mmdb"))
+	assert.Error(t, err)
+}
+
+// TestDBLookup verifies that the country and the autonomous system are resolved from their databases.
+func TestDBLookup(t *testing.T) {
+	country, err := NewReader(buildDB(t, 6, 24, map[string]any{
+		"192.0.2.0/24":    countryRecord("IT"),
+		"198.51.100.0/24": map[string]any{"registered_country": map[string]any{"iso_code": "NL"}},
+	}))
+	require.NoError(t, err)
+	asn, err := NewReader(buildDB(t, 6, 24, map[string]any{
+		"192.0.2.0/24": map[string]any{"autonomous_system_number": uint32(64500), "autonomous_system_organization": "Example Net"},
+	}))
+	require.NoError(t, err)
+
+	db := NewDB(country, asn)
+	assert.Equal(t, Location{Country: "IT", ASN: 64500, Organization: "Example Net"}, db.Lookup(netip.MustParseAddr("192.0.2.1")))
+	assert.Equal(t, Location{Country: "NL"}, db.Lookup(netip.MustParseAddr("198.51.100.1")))
+	assert.Equal(t, Location{}, db.Lookup(netip.MustParseAddr("10.0.0.1")))
+	assert.Equal(t, Location{Country: "IT"}, NewDB(country, nil).Lookup(netip.MustParseAddr("192.0.2.1")))
+}
+
+// TestContext verifies that the location of the client travels in the request context.
+func TestContext(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+
+	location, ok := FromContext(WithLocation(context.Background(), Location{Country: "IT"}))
+	assert.True(t, ok)
+	assert.Equal(t, "IT", location.Country)
+}
@@ -0,0 +1,367 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"os"
+)
+
+// metadataMarker precedes the metadata section at the end of a MaxMind DB file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSeparator is the number of zero bytes between the search tree and the data section.
+const dataSectionSeparator = 16
+
+// maxDecodeDepth bounds the nesting of decoded values, so that a corrupted file cannot recurse forever.
+const maxDecodeDepth = 64
+
+// errTruncated reports a value running past the end of its section.
+var errTruncated = errors.New("truncated MaxMind DB data")
+
+// Data types of the MaxMind DB format. Types above 7 are stored as extended types.
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEndMarker = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+// Reader looks up the records of a MaxMind DB file (.mmdb), such as the GeoLite2 databases. The whole file is
+// kept in memory.
+type Reader struct {
+	DatabaseType string // DatabaseType is the type declared in the metadata, e.g. GeoLite2-Country.
+	tree         []byte
+	data         decoder
+	nodeCount    uint
+	recordSize   uint
+	nodeSize     uint
+	ipVersion    uint
+	ipv4Start    uint
+}
+
+// Open reads a MaxMind DB file.
+//
+// Parameters:
+// - path: The path of the file.
+//
+// Returns:
+// - *Reader: The reader of the database.
+// - error: An error if the file cannot be read or is not a MaxMind DB.
+func Open(path string) (*Reader, error) {
+	buffer, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := NewReader(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return reader, nil
+}
+
+// NewReader parses a MaxMind DB held in memory.
+//
+// Parameters:
+// - buffer: The content of the database file.
+//
+// Returns:
+// - *Reader: The reader of the database.
+// - error: An error if the content is not a valid MaxMind DB.
+func NewReader(buffer []byte) (*Reader, error) {
+	start := bytes.LastIndex(buffer, metadataMarker)
+	if start < 0 {
+		return nil, errors.New("not a MaxMind DB: metadata not found")
+	}
+	value, _, err := decoder(buffer[start+len(metadataMarker):]).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %v", err)
+	}
+	metadata, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.New("invalid metadata: not a map")
+	}
+
+	r := &Reader{
+		nodeCount:  uintValue(metadata["node_count"]),
+		recordSize: uintValue(metadata["record_size"]),
+		ipVersion:  uintValue(metadata["ip_version"]),
+	}
+	r.DatabaseType, _ = metadata["database_type"].(string)
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", r.ipVersion)
+	}
+	r.nodeSize = r.recordSize / 4
+	treeSize := r.nodeCount * r.nodeSize
+	if treeSize+dataSectionSeparator > uint(start) {
+		return nil, errors.New("search tree larger than the file")
+	}
+	r.tree = buffer[:treeSize]
+	r.data = decoder(buffer[treeSize+dataSectionSeparator : start])
+
+	// IPv4 addresses are looked up in an IPv6 tree as ::a.b.c.d, under the first 96 zero bits.
+	if r.ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// Lookup returns the record of the network holding an address.
+//
+// Parameters:
+// - addr: The IP address.
+//
+// Returns:
+// - any: The decoded record, usually a map[string]any.
+// - bool: False if the database holds no network with the address.
+// - error: An error if the database is corrupted.
+func (r *Reader) Lookup(addr netip.Addr) (any, bool, error) {
+	addr = addr.Unmap()
+	var ip []byte
+	node := uint(0)
+	switch {
+	case addr.Is4():
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+		v4 := addr.As4()
+		ip = v4[:]
+	case addr.Is6() && r.ipVersion == 6:
+		v6 := addr.As16()
+		ip = v6[:]
+	default:
+		return nil, false, nil
+	}
+
+	for i := 0; i < len(ip)*8 && node < r.nodeCount; i++ {
+		node = r.record(node, uint(ip[i/8]>>(7-i%8))&1)
+	}
+	switch {
+	case node == r.nodeCount:
+		return nil, false, nil
+	case node < r.nodeCount:
+		return nil, false, errors.New("invalid search tree: no record at the end of the address")
+	}
+	offset := node - r.nodeCount - dataSectionSeparator
+	if offset >= uint(len(r.data)) {
+		return nil, false, errors.New("invalid search tree: record outside of the data section")
+	}
+	value, _, err := r.data.decode(offset, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node of the search tree.
+func (r *Reader) record(node, bit uint) uint {
+	b := r.tree[node*r.nodeSize : (node+1)*r.nodeSize]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		// The middle byte holds the high nibbles of both records.
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// decoder decodes the values of a data section, whose pointers are offsets from its start.
+type decoder []byte
+
+// decode decodes the value at an offset.
+//
+// Parameters:
+// - offset: The offset of the value.
+// - depth: The nesting depth of the value.
+//
+// Returns:
+// - any: The value: string, float64, []byte, uint64, *big.Int, int32, bool, float32, map[string]any, or []any.
+// - uint: The offset following the value (following the pointer, for values reached through one).
+// - error: An error if the data is corrupted.
+func (d decoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, errors.New("MaxMind DB data nested too deeply")
+	}
+	kind, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if kind == typePointer {
+		target, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(target, depth+1)
+		return value, next, err
+	}
+
+	switch kind {
+	case typeMap:
+		values := make(map[string]any, size)
+		for range size {
+			var key, value any
+			if key, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("MaxMind DB map key is not a string")
+			}
+			if value, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			values[name] = value
+		}
+		return values, offset, nil
+	case typeArray:
+		values := make([]any, 0, size)
+		for range size {
+			var value any
+			if value, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			values = append(values, value)
+		}
+		return values, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d)) {
+		return nil, 0, errTruncated
+	}
+	b, next := d[offset:offset+size], offset+size
+	switch kind {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return bytes.Clone(b), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size %d", size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("invalid unsigned integer size %d", size)
+		}
+		var value uint64
+		for _, c := range b {
+			value = value<<8 | uint64(c)
+		}
+		return value, next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("invalid int32 size %d", size)
+		}
+		var value uint32
+		for _, c := range b {
+			value = value<<8 | uint32(c)
+		}
+		return int32(value), next, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("invalid uint128 size %d", size)
+		}
+		return new(big.Int).SetBytes(b), next, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported MaxMind DB data type %d", kind)
+}
+
+// control parses the control byte of a value: its type and its size, or the size bits of a pointer.
+func (d decoder) control(offset uint) (kind, size, next uint, err error) {
+	if offset >= uint(len(d)) {
+		return 0, 0, 0, errTruncated
+	}
+	ctrl := d[offset]
+	offset++
+	kind = uint(ctrl >> 5)
+	if kind == typePointer {
+		return kind, uint(ctrl & 0x1f), offset, nil
+	}
+	if kind == typeExtended {
+		if offset >= uint(len(d)) {
+			return 0, 0, 0, errTruncated
+		}
+		kind = 7 + uint(d[offset])
+		offset++
+	}
+
+	size = uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d)) {
+			return 0, 0, 0, errTruncated
+		}
+		var extra uint
+		for _, c := range d[offset : offset+n] {
+			extra = extra<<8 | uint(c)
+		}
+		offset += n
+		size = [...]uint{29, 285, 65821}[n-1] + extra
+	}
+	return kind, size, offset, nil
+}
+
+// pointer parses a pointer whose control byte had the given size bits.
+func (d decoder) pointer(bits, offset uint) (uint, uint, error) {
+	n := (bits>>3)&0x3 + 1
+	if offset+n > uint(len(d)) {
+		return 0, 0, errTruncated
+	}
+	var value uint
+	for _, c := range d[offset : offset+n] {
+		value = value<<8 | uint(c)
+	}
+	switch n {
+	case 1:
+		value |= (bits & 0x7) << 8
+	case 2:
+		value = (bits&0x7)<<16 | value + 2048
+	case 3:
+		value = (bits&0x7)<<24 | value + 526336
+	}
+	return value, offset + n, nil
+}
+
+// uintValue returns an unsigned integer of a decoded record, or 0 if it is missing or has another type.
+func uintValue(value any) uint {
+	if v, ok := value.(uint64); ok {
+		return uint(v)
+	}
+	return 0
+}
@@ -0,0 +1,184 @@
+package graphql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Operation describes the operation of a GraphQL request.
+type Operation struct {
+	Name          string // Name is the name of the operation, empty when it is anonymous.
+	Type          string // Type is query, mutation, or subscription.
+	Depth         int    // Depth is the deepest nesting of fields, fragments expanded.
+	Complexity    int    // Complexity is the number of fields selected, fragments expanded.
+	Introspection bool   // Introspection reports whether the operation selects __schema or __type.
+}
+
+// Request is a GraphQL request, as sent in a JSON body or in the query string.
+type Request struct {
+	Query         string `json:"query"`
+	OperationName string `json:"operationName"`
+}
+
+// ReadRequests extracts the GraphQL requests of an HTTP request: the query parameters of a GET, a JSON body
+// holding a request or a batch of requests, or an application/graphql body.
+//
+// Parameters:
+// - r: The HTTP request.
+// - body: The request body, already read.
+//
+// Returns:
+// - []Request: The GraphQL requests.
+// - error: An error if the request carries no GraphQL request.
+func ReadRequests(r *http.Request, body []byte) ([]Request, error) {
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		return []Request{{Query: query.Get("query"), OperationName: query.Get("operationName")}}, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/graphql":
+		return []Request{{Query: string(body), OperationName: r.URL.Query().Get("operationName")}}, nil
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		trimmed := strings.TrimSpace(string(body))
+		if strings.HasPrefix(trimmed, "[") {
+			var batch []Request
+			if err := json.Unmarshal(body, &batch); err != nil {
+				return nil, fmt.Errorf("invalid JSON body: %v", err)
+			}
+			if len(batch) == 0 {
+				return nil, errors.New("empty batch")
+			}
+			return batch, nil
+		}
+		var request Request
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, fmt.Errorf("invalid JSON body: %v", err)
+		}
+		return []Request{request}, nil
+	}
+	return nil, fmt.Errorf("unsupported content type %q", mediaType)
+}
+
+// Analyze parses a GraphQL document and measures the operation it executes.
+//
+// Parameters:
+// - query: The GraphQL document.
+// - operationName: The name of the operation to execute, required when the document holds several.
+//
+// Returns:
+// - Operation: The executed operation.
+// - error: An error if the document is invalid or the operation cannot be determined.
+func Analyze(query, operationName string) (Operation, error) {
+	if strings.TrimSpace(query) == "" {
+		return Operation{}, errors.New("missing query")
+	}
+	doc, err := parse(query)
+	if err != nil {
+		return Operation{}, fmt.Errorf("invalid query: %v", err)
+	}
+
+	var selected *operation
+	for i, op := range doc.operations {
+		if operationName == "" || op.name == operationName {
+			if selected != nil {
+				return Operation{}, errors.New("the operation name is required with several operations")
+			}
+			selected = &doc.operations[i]
+		}
+	}
+	if selected == nil {
+		if operationName != "" {
+			return Operation{}, fmt.Errorf("unknown operation %q", operationName)
+		}
+		return Operation{}, errors.New("the document holds no operation")
+	}
+
+	m := &measurer{fragments: doc.fragments, measured: make(map[string]measure), visiting: make(map[string]bool)}
+	result, err := m.measure(selected.selections)
+	if err != nil {
+		return Operation{}, err
+	}
+	return Operation{
+		Name:          selected.name,
+		Type:          selected.kind,
+		Depth:         result.depth,
+		Complexity:    result.complexity,
+		Introspection: result.introspection,
+	}, nil
+}
+
+// measure is the size of a selection set.
+type measure struct {
+	depth         int
+	complexity    int
+	introspection bool
+}
+
+// measurer measures selection sets, measuring each fragment once so that fragments spread many times cannot
+// make the analysis explode.
+type measurer struct {
+	fragments map[string][]selection
+	measured  map[string]measure
+	visiting  map[string]bool
+}
+
+// measure returns the size of a selection set, fragments expanded.
+func (m *measurer) measure(selections []selection) (measure, error) {
+	var total measure
+	for _, sel := range selections {
+		var child measure
+		var err error
+		switch {
+		case sel.spread != "":
+			child, err = m.fragment(sel.spread)
+		default:
+			child, err = m.measure(sel.children)
+		}
+		if err != nil {
+			return measure{}, err
+		}
+		if sel.field != "" {
+			child.depth++
+			child.complexity = saturatedAdd(child.complexity, 1)
+			child.introspection = child.introspection || sel.field == "__schema" || sel.field == "__type"
+		}
+		total.depth = max(total.depth, child.depth)
+		total.complexity = saturatedAdd(total.complexity, child.complexity)
+		total.introspection = total.introspection || child.introspection
+	}
+	return total, nil
+}
+
+// fragment returns the size of a named fragment.
+func (m *measurer) fragment(name string) (measure, error) {
+	if result, ok := m.measured[name]; ok {
+		return result, nil
+	}
+	selections, ok := m.fragments[name]
+	if !ok {
+		return measure{}, fmt.Errorf("unknown fragment %q", name)
+	}
+	if m.visiting[name] {
+		return measure{}, fmt.Errorf("fragment %q spreads itself", name)
+	}
+	m.visiting[name] = true
+	result, err := m.measure(selections)
+	delete(m.visiting, name)
+	if err != nil {
+		return measure{}, err
+	}
+	m.measured[name] = result
+	return result, nil
+}
+
+// saturatedAdd adds two non-negative counts, stopping at the largest int32.
+func saturatedAdd(a, b int) int {
+	return int(min(int64(a)+int64(b), math.MaxInt32))
+}
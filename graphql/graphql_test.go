@@ -0,0 +1,114 @@
+package graphql
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAnalyze verifies the operation name, type, depth, complexity, and introspection measured for documents
+// with aliases, arguments, directives, and fragments.
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		operationName string
+		expected      Operation
+	}{
+		{
+			name:     "anonymous shorthand",
+			query:    `{ user { id name } }`,
+			expected: Operation{Type: "query", Depth: 2, Complexity: 3},
+		},
+		{
+			name: "named mutation with arguments and directives",
+			query: `mutation AddItem($name: String = "a, b") @trace {
+				item: addItem(input: {name: $name, tags: ["x"]}) @include(if: true) { id }
+			}`,
+			expected: Operation{Name: "AddItem", Type: "mutation", Depth: 2, Complexity: 2},
+		},
+		{
+			name: "fragments expanded",
+			query: `query Feed { posts { ...PostFields ... on Post { author { name } } } }
+				fragment PostFields on Post { id comments { id } }`,
+			expected: Operation{Name: "Feed", Type: "query", Depth: 3, Complexity: 6},
+		},
+		{
+			name:          "selected operation",
+			query:         `query A { a } query B { b { c } }`,
+			operationName: "B",
+			expected:      Operation{Name: "B", Type: "query", Depth: 2, Complexity: 2},
+		},
+		{
+			name:     "introspection",
+			query:    "# schema\nquery IntrospectionQuery { __schema { types { name } } }",
+			expected: Operation{Name: "IntrospectionQuery", Type: "query", Depth: 3, Complexity: 3, Introspection: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operation, err := Analyze(tt.query, tt.operationName)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expected, operation)
+			}
+		})
+	}
+}
+
+// TestAnalyzeErrors verifies that invalid documents and undeterminable operations are rejected.
+func TestAnalyzeErrors(t *testing.T) {
+	tests := map[string]struct {
+		query         string
+		operationName string
+	}{
+		"missing query":       {query: "  "},
+		"unbalanced braces":   {query: `{ user { id }`},
+		"unterminated string": {query: `{ user(name: "x) { id } }`},
+		"ambiguous operation": {query: `query A { a } query B { b }`},
+		"unknown operation":   {query: `query A { a }`, operationName: "B"},
+		"unknown fragment":    {query: `{ ...Missing }`},
+		"fragment cycle":      {query: `{ ...A } fragment A on Q { ...B } fragment B on Q { ...A }`},
+		"deep nesting":        {query: strings.Repeat("{ a ", maxNesting+1) + strings.Repeat("}", maxNesting+1)},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Analyze(tt.query, tt.operationName)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestReadRequests verifies that GraphQL requests are read from the query string, JSON bodies, JSON batches,
+// and application/graphql bodies, and that other bodies are rejected.
+func TestReadRequests(t *testing.T) {
+	r := httptest.NewRequest("GET", "/graphql?query=%7Ba%7D&operationName=Op", nil)
+	requests, err := ReadRequests(r, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []Request{{Query: "{a}", OperationName: "Op"}}, requests)
+
+	r = httptest.NewRequest("POST", "/graphql", nil)
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	requests, err = ReadRequests(r, []byte(`{"query":"{a}","variables":{"x":1}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []Request{{Query: "{a}"}}, requests)
+
+	requests, err = ReadRequests(r, []byte(` [{"query":"{a}"},{"query":"query B {b}","operationName":"B"}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, []Request{{Query: "{a}"}, {Query: "query B {b}", OperationName: "B"}}, requests)
+
+	_, err = ReadRequests(r, []byte(`[]`))
+	assert.Error(t, err)
+
+	r.Header.Set("Content-Type", "application/graphql")
+	requests, err = ReadRequests(r, []byte(`{a}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []Request{{Query: "{a}"}}, requests)
+
+	r.Header.Set("Content-Type", "text/plain")
+	_, err = ReadRequests(r, []byte(`{a}`))
+	assert.Error(t, err)
+}
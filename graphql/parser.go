@@ -0,0 +1,376 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokenKind is the kind of a lexical token of a GraphQL document.
+type tokenKind int
+
+const (
+	tokenEOF   tokenKind = iota // tokenEOF ends the document.
+	tokenPunct                  // tokenPunct is a punctuator, such as { or ...
+	tokenName                   // tokenName is a name, such as a field or a keyword.
+	tokenValue                  // tokenValue is a number or a string.
+)
+
+// token is a lexical token of a GraphQL document.
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+// lexer splits a GraphQL document into tokens, skipping whitespace, commas, and comments.
+type lexer struct {
+	src string
+	pos int
+}
+
+// next returns the next token of the document.
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			l.pos++
+		} else if c == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+		} else if strings.HasPrefix(l.src[l.pos:], "\uFEFF") {
+			l.pos += len("\uFEFF")
+		} else {
+			break
+		}
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF}, nil
+	}
+
+	start := l.pos
+	c := l.src[l.pos]
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return token{kind: tokenPunct, value: "..."}, nil
+	case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+		l.pos++
+		return token{kind: tokenPunct, value: string(c)}, nil
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokenName, value: l.src[start:l.pos]}, nil
+	case c == '-' || isDigit(c):
+		l.pos++
+		for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || strings.IndexByte(".eE+-", l.src[l.pos]) >= 0) {
+			l.pos++
+		}
+		return token{kind: tokenValue, value: l.src[start:l.pos]}, nil
+	case strings.HasPrefix(l.src[l.pos:], `"""`):
+		for i := l.pos + 3; i+3 <= len(l.src); i++ {
+			if l.src[i] == '\\' && strings.HasPrefix(l.src[i+1:], `"""`) {
+				i += 3
+				continue
+			}
+			if strings.HasPrefix(l.src[i:], `"""`) {
+				l.pos = i + 3
+				return token{kind: tokenValue, value: l.src[start:l.pos]}, nil
+			}
+		}
+		return token{}, fmt.Errorf("unterminated block string at offset %d", start)
+	case c == '"':
+		for i := l.pos + 1; i < len(l.src); i++ {
+			switch l.src[i] {
+			case '\\':
+				i++
+			case '\n', '\r':
+				return token{}, fmt.Errorf("unterminated string at offset %d", start)
+			case '"':
+				l.pos = i + 1
+				return token{kind: tokenValue, value: l.src[start:l.pos]}, nil
+			}
+		}
+		return token{}, fmt.Errorf("unterminated string at offset %d", start)
+	}
+	return token{}, fmt.Errorf("unexpected character %q at offset %d", c, start)
+}
+
+// isLetter reports whether c is an ASCII letter.
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isDigit reports whether c is an ASCII digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// document is the structure of a GraphQL document relevant to the analysis of its operations.
+type document struct {
+	operations []operation
+	fragments  map[string][]selection
+}
+
+// operation is an operation definition of a document.
+type operation struct {
+	name       string
+	kind       string
+	selections []selection
+}
+
+// selection is a field, a fragment spread, or an inline fragment of a selection set.
+type selection struct {
+	field    string      // field is the name of a selected field, empty for fragments.
+	spread   string      // spread is the name of a spread fragment.
+	children []selection // children is the selection set of a field or an inline fragment.
+}
+
+// maxNesting bounds the nesting of the selection sets, so that a hostile document cannot exhaust the stack.
+const maxNesting = 256
+
+// parser parses a GraphQL document with one token of lookahead.
+type parser struct {
+	lex     lexer
+	tok     token
+	nesting int // nesting is the number of selection sets being parsed.
+}
+
+// parse parses a GraphQL document into its operations and fragments. Arguments, variables, and directives
+// are checked for balance but otherwise skipped.
+func parse(src string) (*document, error) {
+	p := &parser{lex: lexer{src: src}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	doc := &document{fragments: make(map[string][]selection)}
+	for p.tok.kind != tokenEOF {
+		if p.is(tokenPunct, "{") {
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, operation{kind: "query", selections: selections})
+			continue
+		}
+		if p.tok.kind != tokenName {
+			return nil, p.unexpected()
+		}
+
+		switch keyword := p.tok.value; keyword {
+		case "query", "mutation", "subscription":
+			op := operation{kind: keyword}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if p.tok.kind == tokenName {
+				op.name = p.tok.value
+				if err := p.advance(); err != nil {
+					return nil, err
+				}
+			}
+			if p.is(tokenPunct, "(") {
+				if err := p.skipBalanced("(", ")"); err != nil {
+					return nil, err
+				}
+			}
+			if err := p.skipDirectives(); err != nil {
+				return nil, err
+			}
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			op.selections = selections
+			doc.operations = append(doc.operations, op)
+		case "fragment":
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if !p.is(tokenName, "on") {
+				return nil, p.unexpected()
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if _, err := p.name(); err != nil {
+				return nil, err
+			}
+			if err := p.skipDirectives(); err != nil {
+				return nil, err
+			}
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.fragments[name] = selections
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	return doc, nil
+}
+
+// selectionSet parses a selection set, braces included.
+func (p *parser) selectionSet() ([]selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	if p.nesting++; p.nesting > maxNesting {
+		return nil, fmt.Errorf("selection sets nested deeper than %d", maxNesting)
+	}
+	defer func() { p.nesting-- }()
+	var selections []selection
+	for !p.is(tokenPunct, "}") {
+		if p.tok.kind == tokenEOF {
+			return nil, p.unexpected()
+		}
+
+		if p.is(tokenPunct, "...") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if p.tok.kind == tokenName && p.tok.value != "on" {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.skipDirectives(); err != nil {
+					return nil, err
+				}
+				selections = append(selections, selection{spread: name})
+				continue
+			}
+			if p.is(tokenName, "on") {
+				if err := p.advance(); err != nil {
+					return nil, err
+				}
+				if _, err := p.name(); err != nil {
+					return nil, err
+				}
+			}
+			if err := p.skipDirectives(); err != nil {
+				return nil, err
+			}
+			children, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			selections = append(selections, selection{children: children})
+			continue
+		}
+
+		field, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if p.is(tokenPunct, ":") {
+			// The first name was an alias.
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if field, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if p.is(tokenPunct, "(") {
+			if err := p.skipBalanced("(", ")"); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.skipDirectives(); err != nil {
+			return nil, err
+		}
+		sel := selection{field: field}
+		if p.is(tokenPunct, "{") {
+			if sel.children, err = p.selectionSet(); err != nil {
+				return nil, err
+			}
+		}
+		selections = append(selections, sel)
+	}
+	return selections, p.advance()
+}
+
+// skipDirectives skips the directives at the current position, with their arguments.
+func (p *parser) skipDirectives() error {
+	for p.is(tokenPunct, "@") {
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if _, err := p.name(); err != nil {
+			return err
+		}
+		if p.is(tokenPunct, "(") {
+			if err := p.skipBalanced("(", ")"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// skipBalanced skips a group opened by the current token up to its matching closing punctuator.
+func (p *parser) skipBalanced(open, close string) error {
+	depth := 0
+	for {
+		switch {
+		case p.tok.kind == tokenEOF:
+			return p.unexpected()
+		case p.is(tokenPunct, open):
+			depth++
+		case p.is(tokenPunct, close):
+			depth--
+		}
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// name consumes a name token and returns it.
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+// expect consumes the given punctuator.
+func (p *parser) expect(punct string) error {
+	if !p.is(tokenPunct, punct) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+// is reports whether the current token has the given kind and value.
+func (p *parser) is(kind tokenKind, value string) bool {
+	return p.tok.kind == kind && p.tok.value == value
+}
+
+// advance reads the next token.
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// unexpected returns the error reporting the current token.
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of document")
+	}
+	return fmt.Errorf("unexpected %q at offset %d", p.tok.value, p.lex.pos-len(p.tok.value))
+}
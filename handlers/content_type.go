@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"bytes"
+	"dito/config"
+	"dito/writer"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// sniffLength is the number of bytes http.DetectContentType considers.
+const sniffLength = 512
+
+// errUnreadableBody reports a request body that cannot be read, or a multipart body that cannot be parsed.
+var errUnreadableBody = errors.New("unreadable request body")
+
+// signatureTypes are the media types that http.DetectContentType recognizes by their signature: a body
+// declared with one of them must carry it.
+var signatureTypes = map[string]bool{
+	"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true, "image/bmp": true,
+	"image/x-icon": true, "application/pdf": true, "application/zip": true, "application/x-gzip": true,
+	"application/wasm": true, "application/ogg": true, "audio/mpeg": true, "audio/wave": true,
+	"video/mp4": true, "video/webm": true, "font/woff": true, "font/woff2": true, "font/ttf": true,
+}
+
+// checkRequestContentType applies the content type rules of a location to a request body: its declared type
+// must be allowed and, when sniffing, match its content. The file parts of multipart bodies are checked as
+// well when the body is replayable, e.g. spooled by the request buffering.
+//
+// Parameters:
+// - r: The HTTP request; its body is replaced by one replaying the sniffed bytes.
+// - rules: The content type rules of the location.
+//
+// Returns:
+// - error: errUnreadableBody if the body cannot be read or parsed, or an error describing the rejected type.
+func checkRequestContentType(r *http.Request, rules config.ContentTypeRules) error {
+	if (len(rules.Allowed) == 0 && !rules.Sniff) || r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return nil
+	}
+	contentType := r.Header.Get("Content-Type")
+	if !contentTypeAllowed(rules.Allowed, contentType) {
+		return fmt.Errorf("content type %q is not allowed", contentType)
+	}
+
+	head, err := peekBody(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnreadableBody, err)
+	}
+	if rules.Sniff && sniffMismatch(contentType, head) {
+		return fmt.Errorf("content does not match the declared type %q", contentType)
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	if mediaType != "multipart/form-data" || r.GetBody == nil {
+		return nil
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnreadableBody, err)
+	}
+	defer body.Close()
+	parts := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%w: %v", errUnreadableBody, err)
+		}
+		partType := part.Header.Get("Content-Type")
+		if part.FileName() == "" && partType == "" {
+			continue
+		}
+		if !contentTypeAllowed(rules.Allowed, partType) {
+			return fmt.Errorf("part %q: content type %q is not allowed", part.FormName(), partType)
+		}
+		if rules.Sniff {
+			partHead, err := io.ReadAll(io.LimitReader(part, sniffLength))
+			if err != nil {
+				return fmt.Errorf("%w: %v", errUnreadableBody, err)
+			}
+			if sniffMismatch(partType, partHead) {
+				return fmt.Errorf("part %q: content does not match the declared type %q", part.FormName(), partType)
+			}
+		}
+	}
+}
+
+// contentTypeAllowed reports whether a Content-Type matches an allow list of media types, where type/* matches
+// any subtype. An empty list allows any type.
+func contentTypeAllowed(allowed []string, contentType string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType := writer.MediaType(contentType)
+	if mediaType == "" {
+		return false
+	}
+	for _, candidate := range allowed {
+		if candidate == mediaType || candidate == "*/*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(candidate, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffMismatch reports whether the head of a body contradicts its declared Content-Type: HTML declared as
+// anything else, or a type recognizable by its signature that the body does not carry.
+func sniffMismatch(contentType string, head []byte) bool {
+	if len(head) == 0 {
+		return false
+	}
+	declared := writer.MediaType(contentType)
+	sniffed := writer.MediaType(http.DetectContentType(head))
+	if sniffed == "text/html" {
+		return declared != "text/html"
+	}
+	return signatureTypes[declared] && sniffed != declared
+}
+
+// peekBody reads the first bytes of a request body, and replaces the body by one returning them first.
+//
+// Parameters:
+// - r: The HTTP request.
+//
+// Returns:
+// - []byte: Up to sniffLength bytes of the body.
+// - error: The error reading the body.
+func peekBody(r *http.Request) ([]byte, error) {
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(r.Body, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	r.Body = &peekedBody{Reader: io.MultiReader(bytes.NewReader(head), r.Body), Closer: r.Body}
+	return head, nil
+}
+
+// peekedBody reads the peeked bytes, then the rest of the original body, which it closes.
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// overrideContentType applies the first Content-Type override matching an upstream response, and marks the
+// response as not to be sniffed when configured.
+//
+// Parameters:
+// - resp: The upstream response.
+// - rules: The content type rules of the location.
+// - path: The path requested by the client.
+func overrideContentType(resp *http.Response, rules config.ContentTypeRules, path string) {
+	mediaType := writer.MediaType(resp.Header.Get("Content-Type"))
+	for _, override := range rules.Overrides {
+		if override.CompiledPath != nil && !override.CompiledPath.MatchString(path) {
+			continue
+		}
+		if override.From == config.ContentTypeNone && mediaType != "" {
+			continue
+		}
+		if override.From != "" && override.From != config.ContentTypeNone && !strings.EqualFold(override.From, mediaType) {
+			continue
+		}
+		resp.Header.Set("Content-Type", override.To)
+		break
+	}
+	if rules.NoSniff {
+		resp.Header.Set("X-Content-Type-Options", "nosniff")
+	}
+}
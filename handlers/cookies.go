@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"dito/config"
+	"net/http"
+	"strings"
+)
+
+// rewriteRequestCookies applies the cookie rules of a location to the Cookie header of an outgoing request:
+// stripped cookies are removed and renamed cookies get back their upstream names.
+//
+// Parameters:
+// - header: The headers of the outgoing request.
+// - cookies: The cookie rules of the location.
+func rewriteRequestCookies(header http.Header, cookies config.CookieConfig) {
+	if len(cookies.StripRequest) == 0 && len(cookies.Rename) == 0 {
+		return
+	}
+	values := header.Values("Cookie")
+	if len(values) == 0 {
+		return
+	}
+	upstreamNames := make(map[string]string, len(cookies.Rename))
+	for upstreamName, clientName := range cookies.Rename {
+		upstreamNames[clientName] = upstreamName
+	}
+
+	var kept []string
+	for _, value := range values {
+		for _, pair := range strings.Split(value, ";") {
+			pair = strings.TrimSpace(pair)
+			if pair == "" {
+				continue
+			}
+			name, rest, _ := strings.Cut(pair, "=")
+			if matchCookie(cookies.StripRequest, name) {
+				continue
+			}
+			if upstreamName, ok := upstreamNames[name]; ok {
+				pair = upstreamName + "=" + rest
+			}
+			kept = append(kept, pair)
+		}
+	}
+	if len(kept) == 0 {
+		header.Del("Cookie")
+		return
+	}
+	header.Set("Cookie", strings.Join(kept, "; "))
+}
+
+// rewriteResponseCookies applies the cookie rules of a location to the Set-Cookie headers of an upstream
+// response: stripped cookies are removed, renamed cookies get their public names, the Domain and Path scoped
+// to the upstream are mapped to the public URL space, and the configured attributes are added or replaced.
+//
+// Parameters:
+// - header: The headers of the upstream response.
+// - cookies: The cookie rules of the location.
+// - mapping: The mapping of the upstream URLs to the public ones.
+func rewriteResponseCookies(header http.Header, cookies config.CookieConfig, mapping urlMapping) {
+	values := header["Set-Cookie"]
+	if len(values) == 0 || !rewritesResponseCookies(cookies) {
+		return
+	}
+
+	rewritten := make([]string, 0, len(values))
+	for _, value := range values {
+		parts := strings.Split(value, ";")
+		name, rest, _ := strings.Cut(strings.TrimSpace(parts[0]), "=")
+		if matchCookie(cookies.StripResponse, name) {
+			continue
+		}
+		if clientName, ok := cookies.Rename[name]; ok {
+			name = clientName
+		}
+
+		attributes := parts[1:]
+		if domain, ok := cookieAttribute(attributes, "Domain"); ok && cookies.RewriteDomain {
+			if publicHost, ok := mapping.domain(domain); ok {
+				attributes = setCookieAttribute(attributes, "Domain", publicHost)
+			}
+		}
+		if path, ok := cookieAttribute(attributes, "Path"); ok && cookies.RewritePath {
+			if publicPath, ok := mapping.path(path); ok {
+				attributes = setCookieAttribute(attributes, "Path", publicPath)
+			}
+		}
+		if cookies.Secure || cookies.SameSite == config.SameSiteNone {
+			attributes = setCookieAttribute(attributes, "Secure", "")
+		}
+		if cookies.HTTPOnly {
+			attributes = setCookieAttribute(attributes, "HttpOnly", "")
+		}
+		if cookies.SameSite != "" {
+			attributes = setCookieAttribute(attributes, "SameSite", cookies.SameSite)
+		}
+
+		cookie := name + "=" + rest
+		for _, attribute := range attributes {
+			if attribute = strings.TrimSpace(attribute); attribute != "" {
+				cookie += "; " + attribute
+			}
+		}
+		rewritten = append(rewritten, cookie)
+	}
+	if len(rewritten) == 0 {
+		header.Del("Set-Cookie")
+		return
+	}
+	header["Set-Cookie"] = rewritten
+}
+
+// rewritesResponseCookies reports whether the cookie rules modify the Set-Cookie headers.
+func rewritesResponseCookies(cookies config.CookieConfig) bool {
+	return len(cookies.StripResponse) > 0 || len(cookies.Rename) > 0 || cookies.Secure || cookies.HTTPOnly || cookies.SameSite != "" ||
+		cookies.RewriteDomain || cookies.RewritePath
+}
+
+// cookieAttribute returns the value of the attribute of a Set-Cookie header with the given name.
+func cookieAttribute(attributes []string, name string) (string, bool) {
+	for _, attribute := range attributes {
+		attributeName, value, _ := strings.Cut(strings.TrimSpace(attribute), "=")
+		if strings.EqualFold(strings.TrimSpace(attributeName), name) {
+			return strings.TrimSpace(value), true
+		}
+	}
+	return "", false
+}
+
+// setCookieAttribute replaces the attribute of a Set-Cookie header with the given name, or appends it.
+//
+// Parameters:
+// - attributes: The attributes following the name and value of the cookie.
+// - name: The name of the attribute.
+// - value: The value of the attribute, empty for flags such as Secure.
+//
+// Returns:
+// - []string: The updated attributes.
+func setCookieAttribute(attributes []string, name, value string) []string {
+	attribute := name
+	if value != "" {
+		attribute += "=" + value
+	}
+	for i, existing := range attributes {
+		existingName, _, _ := strings.Cut(strings.TrimSpace(existing), "=")
+		if strings.EqualFold(strings.TrimSpace(existingName), name) {
+			attributes[i] = attribute
+			return attributes
+		}
+	}
+	return append(attributes, attribute)
+}
+
+// matchCookie reports whether a cookie name matches one of the patterns: exact names, or prefixes ending with "*".
+func matchCookie(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if pattern == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"dito/config"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxGRPCTimeoutValue is the largest value of the grpc-timeout header, which allows at most 8 digits.
+const maxGRPCTimeoutValue = 99999999
+
+// setDeadlineHeaders sets the headers carrying the time remaining until the deadline of the request
+// context. Nothing is set when the request has no deadline.
+//
+// Parameters:
+// - req: The outgoing request.
+// - headers: The deadline headers of the location.
+func setDeadlineHeaders(req *http.Request, headers []config.DeadlineHeader) {
+	deadline, ok := req.Context().Deadline()
+	if !ok || len(headers) == 0 {
+		return
+	}
+	// The remaining time is truncated, so the upstream gives up no later than the proxy.
+	milliseconds := max(time.Until(deadline).Milliseconds(), 1)
+	for _, header := range headers {
+		req.Header.Set(header.Name, formatDeadline(milliseconds, header.Format))
+	}
+}
+
+// formatDeadline formats a remaining time in milliseconds as a deadline header value.
+//
+// Parameters:
+// - milliseconds: The time remaining until the deadline, in milliseconds.
+// - format: The deadline header format.
+//
+// Returns:
+// - string: The header value.
+func formatDeadline(milliseconds int64, format string) string {
+	switch format {
+	case config.DeadlineFormatSeconds:
+		return fmt.Sprintf("%d.%03d", milliseconds/1000, milliseconds%1000)
+	case config.DeadlineFormatGRPC:
+		if milliseconds <= maxGRPCTimeoutValue {
+			return strconv.FormatInt(milliseconds, 10) + "m"
+		}
+		return strconv.FormatInt(min(milliseconds/1000, maxGRPCTimeoutValue), 10) + "S"
+	default:
+		return strconv.FormatInt(milliseconds, 10)
+	}
+}
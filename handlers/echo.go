@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"dito/app"
+	"dito/config"
+	"dito/geoip"
+	cmid "dito/middlewares"
+	"dito/transport"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// echoResponse describes a request as received by the proxy, and the location the rest of its path matches.
+type echoResponse struct {
+	Method     string        `json:"method"`
+	URL        string        `json:"url"`
+	Host       string        `json:"host"`
+	Proto      string        `json:"proto"`
+	RemoteAddr string        `json:"remote_addr"`
+	ClientIP   string        `json:"client_ip"`         // ClientIP is the client address resolved by the forwarding rules.
+	Country    string        `json:"country,omitempty"` // Country is the country of the client resolved by GeoIP.
+	ASN        uint          `json:"asn,omitempty"`     // ASN is the autonomous system of the client resolved by GeoIP.
+	Header     http.Header   `json:"header"`            // Header holds the headers after the inbound processing.
+	Location   *echoLocation `json:"location"`          // Location is the matched location, null if none matches.
+}
+
+// echoLocation describes the location matching an echoed request, and the upstream request it would send.
+type echoLocation struct {
+	Name           string      `json:"name"`
+	Path           string      `json:"path"`
+	TargetURL      string      `json:"target_url"`
+	Middlewares    []string    `json:"middlewares"`
+	UpstreamURL    string      `json:"upstream_url"`
+	UpstreamHost   string      `json:"upstream_host"`   // UpstreamHost is the Host header sent to the upstream.
+	UpstreamHeader http.Header `json:"upstream_header"` // UpstreamHeader holds the headers after the rules of the location.
+}
+
+// isEchoRequest checks if a request targets the echo endpoint.
+//
+// Parameters:
+// - requestPath: The path of the request.
+// - echo: The echo endpoint configuration.
+//
+// Returns:
+// - bool: True if the endpoint is enabled and the path is under its prefix.
+func isEchoRequest(requestPath string, echo config.EchoConfig) bool {
+	if !echo.Enabled {
+		return false
+	}
+	return requestPath == echo.Path || strings.HasPrefix(requestPath, echo.Path+"/")
+}
+
+// serveEcho answers a request with its description as received by the proxy, after the normalization, the
+// client address resolution, and the stripping of the internal headers. The rest of the path, after the prefix
+// of the endpoint, is matched against the locations, e.g. /_dito/echo/api/users describes the location matching
+// /api/users and the upstream request it would send. The request is never forwarded.
+//
+// Parameters:
+// - dito: The Dito application instance containing the configuration and router.
+// - w: The HTTP response writer.
+// - r: The HTTP request.
+func serveEcho(dito *app.Dito, w http.ResponseWriter, r *http.Request) {
+	routed := r.Clone(r.Context())
+	routed.URL.Path = strings.TrimPrefix(r.URL.Path, dito.Config.Echo.Path)
+	if routed.URL.Path == "" {
+		routed.URL.Path = "/"
+	}
+	routed.URL.RawPath = ""
+
+	echo := echoResponse{
+		Method:     r.Method,
+		URL:        r.URL.RequestURI(),
+		Host:       r.Host,
+		Proto:      r.Proto,
+		RemoteAddr: r.RemoteAddr,
+		ClientIP:   r.Header.Get(cmid.RealIPHeader),
+		Header:     r.Header,
+	}
+	if client, ok := geoip.FromContext(r.Context()); ok {
+		echo.Country = client.Country
+		echo.ASN = client.ASN
+	}
+	if i, ok := dito.Router().Match(routed); ok {
+		echo.Location = describeUpstream(dito.Config.Locations[i], routed)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(echo)
+}
+
+// describeUpstream describes the upstream request a location would send for a request, with the headers set by
+// its rules.
+//
+// Parameters:
+// - location: The matched location.
+// - r: The request, with the path the location matched.
+//
+// Returns:
+// - *echoLocation: The description of the location and of the upstream request.
+func describeUpstream(location config.LocationConfig, r *http.Request) *echoLocation {
+	described := &echoLocation{
+		Name:        location.Label(),
+		Path:        location.Path,
+		TargetURL:   location.TargetURL,
+		Middlewares: location.Middlewares,
+	}
+	targetURL, err := url.Parse(location.TargetURL)
+	if err != nil {
+		return described
+	}
+
+	upstream := r.Clone(r.Context())
+	upstream.URL.Scheme = targetURL.Scheme
+	upstream.URL.Host = targetURL.Host
+	upstream.URL.Path = upstreamPath(location, targetURL, r.URL.Path)
+	if !location.PreserveHost {
+		upstream.Host = targetURL.Host
+	}
+	for _, header := range hopHeaders {
+		upstream.Header.Del(header)
+	}
+	rewriteRequestCookies(upstream.Header, location.Cookies)
+	(&transport.Caronte{Location: &location}).AddHeaders(upstream)
+
+	described.UpstreamURL = upstream.URL.String()
+	described.UpstreamHost = upstream.Host
+	described.UpstreamHeader = upstream.Header
+	return described
+}
@@ -1,25 +1,38 @@
 package handlers
 
 import (
+	"context"
 	"dito/app"
 	"dito/config"
+	"dito/geoip"
+	"dito/logging"
 	"dito/metrics"
 	cmid "dito/middlewares"
+	"dito/spool"
+	"dito/transform"
 	"dito/transport"
 	"dito/websocket"
 	"dito/writer"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
+	"time"
 )
 
 const (
 	InternalServerErrorMessage = "Internal Server Error"
 )
 
+// errFallback is returned by the response modifier of a location whose upstream answered a fallback status, so
+// that the error handler of the proxy forwards the request to the fallback instead.
+var errFallback = errors.New("upstream answered a fallback status")
+
 // DynamicProxyHandler handles dynamic proxying of requests based on the configuration.
 // It reads the request body, matches the request path with configured locations, and applies middlewares.
 //
@@ -36,33 +49,72 @@ func DynamicProxyHandler(dito *app.Dito, w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	for i, location := range dito.Config.Locations {
-		if location.CompiledRegex.MatchString(r.URL.Path) {
-			if location.EnableWebsocket && websocket.IsWebSocketRequest(r) {
-				dito.Logger.Info("Upgrading to WebSocket for", "path", location.Path)
-				websocket.HandleWebSocketProxy(w, r, location.TargetURL, dito.Logger)
-				return
+	if isEchoRequest(r.URL.Path, dito.Config.Echo) {
+		serveEcho(dito, w, r)
+		return
+	}
 
-			}
-			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				ServeProxy(dito, i, w, r)
+	if i, ok := dito.Router().Match(r); ok {
+		location := dito.Config.Locations[i]
+		if info := logging.RequestInfoFrom(r.Context()); info != nil {
+			info.Location = location.Label()
+		}
+		if client, ok := geoip.FromContext(r.Context()); ok && dito.Config.Metrics.Enabled {
+			metrics.RecordGeoRequest(location.Label(), client.Country)
+		}
+		if !methodAllowed(location.AllowedMethods, r.Method) {
+			dito.Logger.Debug(fmt.Sprintf("Method %s not allowed on %s", r.Method, location.Label()))
+			w.Header().Set("Allow", allowHeader(location.AllowedMethods))
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ServeProxy(dito, i, w, r)
+		})
+		if location.EnableWebsocket && websocket.IsWebSocketRequest(r) {
+			// The middlewares (auth, rate limits) run before the connection is upgraded.
+			handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				serveWebSocket(dito, location, w, r)
 			})
+		}
 
-			lrw := &writer.ResponseWriter{ResponseWriter: w}
-			if len(location.Middlewares) > 0 {
-				handlerWithMiddlewares := applyMiddlewares(dito, handler, location)
-				handlerWithMiddlewares.ServeHTTP(lrw, r)
-			} else {
-				handler.ServeHTTP(lrw, r)
-			}
-			return
+		if len(location.Middlewares) > 0 {
+			handler = applyMiddlewares(dito, handler, location)
 		}
+		handler.ServeHTTP(w, r)
+		return
 	}
 
+	if dito.Config.Fallback.TargetURL != "" {
+		dito.Logger.Debug(fmt.Sprintf("No location matches %s, forwarding to the fallback", r.URL.Path))
+		serveFallback(dito, dito.Config.Fallback, w, r)
+		return
+	}
 	http.NotFound(w, r)
 
 }
 
+// serveWebSocket upgrades a request to a WebSocket session proxied to the target of its location.
+//
+// Parameters:
+// - dito: The Dito application instance containing the logger.
+// - location: The location configuration.
+// - w: The HTTP response writer.
+// - r: The HTTP request.
+func serveWebSocket(dito *app.Dito, location config.LocationConfig, w http.ResponseWriter, r *http.Request) {
+	dito.Logger.Info("Upgrading to WebSocket for", "location", location.Label())
+	if location.WebSocket.MessagesPerSecond > 0 {
+		r = websocket.WithMessageHook(r, websocket.MessageRateLimit(location.WebSocket.MessagesPerSecond, location.WebSocket.MessageBurst))
+	}
+	upstreamTransport, err := dito.TransportCache.GetTransport(&location, dito.Config.Transport.HTTP)
+	if err != nil {
+		dito.Logger.Error(fmt.Sprintf("Failed to get the transport for %s: %v", location.Label(), err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	websocket.HandleWebSocketProxy(w, r, location, websocket.NewDialer(upstreamTransport), dito.Logger)
+}
+
 // ServeProxy handles the proxying of requests to the target URL specified in the location configuration.
 //
 // Parameters:
@@ -71,11 +123,50 @@ func DynamicProxyHandler(dito *app.Dito, w http.ResponseWriter, r *http.Request)
 // - lrw: The HTTP response writer.
 // - r: The HTTP request.
 func ServeProxy(dito *app.Dito, locationIndex int, lrw http.ResponseWriter, r *http.Request) {
-	location := dito.Config.Locations[locationIndex]
+	serveLocation(dito, dito.Config.Locations[locationIndex], dito.ResponseTransforms(locationIndex), lrw, r)
+}
+
+// serveFallback forwards a request to the target of a fallback, as a location without settings of its own.
+//
+// Parameters:
+// - dito: The Dito application instance containing the configuration and logger.
+// - fallback: The fallback configuration.
+// - w: The HTTP response writer.
+// - r: The HTTP request.
+func serveFallback(dito *app.Dito, fallback config.Fallback, w http.ResponseWriter, r *http.Request) {
+	serveLocation(dito, config.LocationConfig{Name: "fallback", TargetURL: fallback.TargetURL, ReplacePath: fallback.ReplacePath}, nil, w, r)
+}
+
+// fallsBack reports whether the upstream response of a location is replaced by the one of its fallback: only
+// the responses to GET and HEAD requests are, since the body of other requests has been consumed.
+//
+// Parameters:
+// - fallback: The fallback configuration of the location.
+// - r: The client request.
+// - status: The status code of the upstream response.
+//
+// Returns:
+// - bool: True if the request must be forwarded to the fallback.
+func fallsBack(fallback config.Fallback, r *http.Request, status int) bool {
+	if fallback.TargetURL == "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+	return slices.Contains(fallback.Statuses, status)
+}
 
+// serveLocation proxies a request to the target URL of a location.
+//
+// Parameters:
+// - dito: The Dito application instance containing the configuration and logger.
+// - location: The location configuration.
+// - bodyTransforms: The response transforms of the location, built when the configuration was loaded.
+// - lrw: The HTTP response writer.
+// - r: The HTTP request.
+func serveLocation(dito *app.Dito, location config.LocationConfig, bodyTransforms []transform.BodyTransform, lrw http.ResponseWriter, r *http.Request) {
 	caronteTransport := &transport.Caronte{
 		Location:       &location,
 		TransportCache: dito.TransportCache,
+		OnRequest:      upstreamRequestLogger(dito, location.Label()),
 	}
 
 	targetURL, err := url.Parse(location.TargetURL)
@@ -85,34 +176,220 @@ func ServeProxy(dito *app.Dito, locationIndex int, lrw http.ResponseWriter, r *h
 		return
 	}
 
+	if location.RequestBuffering.Enabled {
+		body, err := spoolRequestBody(r, location.RequestBuffering)
+		if err != nil {
+			dito.Logger.Warn(fmt.Sprintf("Error buffering the request body: %v", err))
+			if errors.Is(err, spool.ErrTooLarge) {
+				http.Error(lrw, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(lrw, "Bad Request", http.StatusBadRequest)
+			}
+			return
+		}
+		if body != nil {
+			defer body.Close()
+		}
+	}
+
+	if err := checkRequestContentType(r, location.ContentType); err != nil {
+		dito.Logger.Debug(fmt.Sprintf("Rejected request body on %s: %v", location.Label(), err))
+		if errors.Is(err, errUnreadableBody) {
+			http.Error(lrw, "Bad Request", http.StatusBadRequest)
+		} else {
+			http.Error(lrw, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+		}
+		return
+	}
+
+	var comparison *mirrorComparison
+	if location.Mirror.TargetURL != "" {
+		comparison = mirror(dito, location, r)
+		defer comparison.finish()
+	}
+
+	if dito.Config.Metrics.Enabled {
+		if upload := trackUpload(r, location.Label()); upload != nil {
+			defer upload.finish()
+		}
+	}
+
+	timing := &transport.Timing{}
+	fellBack := false
 	proxy := &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
 			req.URL.Scheme = targetURL.Scheme
 			req.URL.Host = targetURL.Host
 
-			if location.ReplacePath {
-				req.URL.Path = targetURL.Path
-			} else {
-				additionalPath := strings.TrimPrefix(r.URL.Path, location.Path)
-				req.URL.Path = normalizePath(targetURL.Path, additionalPath)
+			req.URL.Path = upstreamPath(location, targetURL, r.URL.Path)
+			req.URL.RawQuery = r.URL.RawQuery
+
+			// Keep the client's Host header for name-based virtual hosting on the upstream.
+			if !location.PreserveHost {
+				req.Host = targetURL.Host
 			}
 
-			req.URL.RawQuery = r.URL.RawQuery
+			// The proxy clones the trailers of the client before they are read along with the body; the
+			// upstream request shares them instead, so that it sends their values. Trailers can only follow
+			// a chunked body, so the request is not sent with a Content-Length, e.g. set by the buffering.
+			if len(r.Trailer) > 0 {
+				req.Trailer = r.Trailer
+				req.ContentLength = -1
+			}
 
-			req.Host = targetURL.Host
+			setDeadlineHeaders(req, location.Deadline.Headers)
+			rewriteRequestCookies(req.Header, location.Cookies)
+			if location.SubFilter.Enabled || len(bodyTransforms) > 0 {
+				// Only the codings the transforms can decompress are negotiated; without any left, the
+				// transport negotiates and decodes the compression itself.
+				if accepted := transform.DecodableAcceptEncoding(req.Header.Get("Accept-Encoding")); accepted != "" {
+					req.Header.Set("Accept-Encoding", accepted)
+				} else {
+					req.Header.Del("Accept-Encoding")
+				}
+			}
 		},
-		Transport: caronteTransport,
+		Transport:      caronteTransport,
+		ModifyResponse: createResponseModifier(location, newURLMapping(location, targetURL, r), r, timing, bodyTransforms, comparison),
 		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			if errors.Is(err, errFallback) {
+				fellBack = true
+				dito.Logger.Debug(fmt.Sprintf("Upstream of %s answered a fallback status, forwarding to the fallback", location.Label()))
+				serveFallback(dito, location.Fallback, w, r)
+				return
+			}
 			dito.Logger.Error(fmt.Sprintf("Error proxying request: %v", err))
+			if info := logging.RequestInfoFrom(r.Context()); info != nil {
+				info.UpstreamAddr = req.URL.Host
+			}
 
-			if os.IsTimeout(err) {
+			if os.IsTimeout(err) || errors.Is(err, context.DeadlineExceeded) {
 				http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
 			} else {
 				http.Error(w, "Bad Gateway", http.StatusBadGateway)
 			}
 		},
 	}
+	if location.Deadline.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), location.Deadline.Timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	r = r.WithContext(transport.WithTiming(r.Context(), timing))
 	proxy.ServeHTTP(lrw, r)
+
+	// The response body has been closed, so the phases are complete. After a fallback, the request info
+	// describes the upstream of the fallback.
+	phases := timing.Phases()
+	if phases.Header == 0 || fellBack {
+		return
+	}
+	if info := logging.RequestInfoFrom(r.Context()); info != nil {
+		info.UpstreamAddr = phases.Addr
+		info.UpstreamConnectTime = phases.Connect + phases.TLS
+		info.UpstreamHeaderTime = phases.Header
+		info.UpstreamResponseTime = phases.Total
+	}
+	if dito.Config.Metrics.Enabled {
+		metrics.RecordUpstreamResponseTime(location.Label(), phases.Total.Seconds())
+	}
+}
+
+// createResponseModifier creates the function modifying the upstream responses of a location: it records the
+// upstream in the request info, rewrites the status code, the cookies, and the redirects, adds the Server-Timing
+// header, and wraps the body into the sub filter, the response transforms, and SSE heartbeats. The response is
+// captured for the mirror comparison before it is rewritten.
+//
+// Parameters:
+// - location: The location configuration.
+// - mapping: The mapping of the upstream URLs to the public ones.
+// - r: The client request.
+// - timing: The timing of the upstream request.
+// - bodyTransforms: The response transforms of the location.
+// - comparison: The comparison with the mirror the response is handed to, nil if there is none.
+//
+// Returns:
+// - func(*http.Response) error: The response modifier of the reverse proxy.
+func createResponseModifier(location config.LocationConfig, mapping urlMapping, r *http.Request, timing *transport.Timing, bodyTransforms []transform.BodyTransform, comparison *mirrorComparison) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if info := logging.RequestInfoFrom(r.Context()); info != nil {
+			info.UpstreamAddr = resp.Request.URL.Host
+			info.UpstreamStatus = resp.StatusCode
+		}
+		if fallsBack(location.Fallback, r, resp.StatusCode) {
+			return errFallback
+		}
+		if comparison != nil {
+			comparison.capture(resp)
+		}
+		if location.Session.Enabled {
+			storeSessionHeaders(r, resp.Header, location.Session)
+		}
+		rewriteStatus(resp, location.StatusRewrites)
+		overrideContentType(resp, location.ContentType, r.URL.Path)
+		rewriteResponseCookies(resp.Header, location.Cookies, mapping)
+		if location.RewriteRedirects {
+			rewriteLocationHeaders(resp.Header, mapping)
+		}
+		if location.ServerTiming {
+			resp.Header.Add("Server-Timing", serverTiming(timing.Phases()))
+		}
+		if location.SubFilter.Enabled {
+			if err := applySubFilter(resp, location.SubFilter, mapping); err != nil {
+				return err
+			}
+		}
+		if err := transform.Apply(resp, bodyTransforms); err != nil {
+			return err
+		}
+		if location.KeepaliveInterval > 0 {
+			injectHeartbeats(resp, location.KeepaliveInterval)
+		}
+		return nil
+	}
+}
+
+// serverTiming formats the upstream phases as a Server-Timing header value, in milliseconds.
+//
+// Parameters:
+// - phases: The phases measured until the response headers were received.
+//
+// Returns:
+// - string: The header value.
+func serverTiming(phases transport.Phases) string {
+	milliseconds := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	return fmt.Sprintf("upstream-dns;dur=%.3f, upstream-connect;dur=%.3f, upstream-tls;dur=%.3f, upstream-ttfb;dur=%.3f",
+		milliseconds(phases.DNS), milliseconds(phases.Connect), milliseconds(phases.TLS), milliseconds(phases.Header))
+}
+
+// spoolRequestBody reads the whole request body into a spool buffer and makes the request replayable:
+// the body is replaced by a reader over the buffer and GetBody returns a fresh one on every call.
+//
+// Parameters:
+// - r: The HTTP request.
+// - buffering: The request buffering configuration of the location.
+//
+// Returns:
+// - *spool.Buffer: The buffer holding the body, to be closed once the request is done (nil if there is no body).
+// - error: spool.ErrTooLarge if the body exceeds the maximum size, or the error reading the body.
+func spoolRequestBody(r *http.Request, buffering config.RequestBuffering) (*spool.Buffer, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body := spool.NewBuffer(buffering.MemoryLimit, buffering.MaxSize, buffering.TempDir)
+	if _, err := body.ReadFrom(r.Body); err != nil {
+		body.Close()
+		return nil, err
+	}
+	r.Body.Close()
+
+	r.Body = body.NewReader()
+	r.GetBody = func() (io.ReadCloser, error) { return body.NewReader(), nil }
+	r.ContentLength = body.Size()
+	r.Header.Del("Transfer-Encoding")
+	r.TransferEncoding = nil
+	return body, nil
 }
 
 // applyMiddlewares applies the configured middlewares to the given handler.
@@ -131,26 +408,121 @@ func applyMiddlewares(dito *app.Dito, handler http.Handler, location config.Loca
 		case "auth":
 			dito.Logger.Debug("Applying Auth Middleware")
 			handler = cmid.AuthMiddleware(handler, dito.Logger)
+		case "introspection":
+			if location.Introspection.Enabled {
+				dito.Logger.Debug("Applying Introspection Middleware")
+				handler = cmid.IntrospectionMiddleware(handler, dito, location.Label(), location.Introspection)
+			}
+		case "hmac":
+			if location.HMAC.Enabled {
+				dito.Logger.Debug("Applying HMAC Middleware")
+				handler = cmid.HMACMiddleware(handler, dito, location.Label(), location.HMAC)
+			}
+		case "session":
+			if location.Session.Enabled {
+				dito.Logger.Debug("Applying Session Middleware")
+				handler = cmid.SessionMiddleware(handler, dito, location.Label(), location.Session)
+			}
 		case "rate-limiter":
 			if location.RateLimiting.Enabled {
 				dito.Logger.Debug("Applying Rate Limiter Middleware")
-				handler = cmid.RateLimiterMiddleware(handler, location.RateLimiting, dito.Logger)
+				handler = cmid.RateLimiterMiddleware(handler, dito.RateLimiters(), location.Path, location.RateLimiting, dito.Logger)
 			}
 		case "rate-limiter-redis":
 			if location.RateLimiting.Enabled && dito.RedisClient != nil && dito.Config.Redis.Enabled {
 				dito.Logger.Debug("Applying Rate Limiter Middleware")
-				handler = cmid.RateLimiterMiddlewareWithRedis(handler, location.RateLimiting, dito.RedisClient, dito.Logger)
+				handler = cmid.RateLimiterMiddlewareWithRedis(handler, location.Path, location.RateLimiting, dito.RedisClient, dito.Logger)
+			}
+		case "spike-arrest":
+			if location.SpikeArrest.Enabled {
+				dito.Logger.Debug("Applying Spike Arrest Middleware")
+				handler = cmid.SpikeArrestMiddleware(handler, dito.RateLimiters(), location.Path, location.SpikeArrest, dito.Logger)
+			}
+		case "quota":
+			if location.Quota.Enabled && dito.RedisClient != nil && dito.Config.Redis.Enabled {
+				dito.Logger.Debug(fmt.Sprintf("Applying Quota Middleware for quota %s", location.Quota.Name))
+				handler = cmid.QuotaMiddlewareWithRedis(handler, location.Quota, dito.RedisClient, dito.Logger)
+			}
+		case "adaptive-concurrency":
+			if location.AdaptiveConcurrency.Enabled {
+				dito.Logger.Debug("Applying Adaptive Concurrency Middleware")
+				handler = cmid.AdaptiveConcurrencyMiddleware(handler, dito.RateLimiters(), location.Path, location.AdaptiveConcurrency, dito.Logger)
+			}
+		case "bandwidth-limit":
+			if location.BandwidthLimit.Enabled {
+				dito.Logger.Debug("Applying Bandwidth Limit Middleware")
+				handler = cmid.BandwidthLimitMiddleware(handler, dito.RateLimiters(), location.Path, location.BandwidthLimit, dito.Logger)
+			}
+		case "request-validation":
+			if location.Validation.Enabled {
+				dito.Logger.Debug("Applying Request Validation Middleware")
+				handler = cmid.RequestValidationMiddleware(handler, location.Validation, dito.Logger)
+			}
+		case "csrf":
+			if location.CSRF.Enabled {
+				dito.Logger.Debug("Applying CSRF Middleware")
+				handler = cmid.CSRFMiddleware(handler, dito, location.CSRF)
+			}
+		case "geo":
+			dito.Logger.Debug("Applying Geo Middleware")
+			handler = cmid.GeoMiddleware(handler, dito, location.Geo)
+		case "bot-filter":
+			if location.BotFilter.Enabled {
+				dito.Logger.Debug("Applying Bot Filter Middleware")
+				handler = cmid.BotFilterMiddleware(handler, dito, location.Label(), location.BotFilter)
+			}
+		case "record":
+			if location.Recording.Enabled {
+				dito.Logger.Debug("Applying Record Middleware")
+				handler = cmid.RecordMiddleware(handler, dito, location.Label(), location.Recording, writer.PoolFor(location.Path))
+			}
+		case "fault-injection":
+			if location.FaultInjection.Enabled {
+				dito.Logger.Debug("Applying Fault Injection Middleware")
+				handler = cmid.FaultInjectionMiddleware(handler, dito, location.Label(), location.FaultInjection)
+			}
+		case "slo":
+			if location.SLO.Enabled {
+				dito.Logger.Debug("Applying SLO Middleware")
+				handler = cmid.SLOMiddleware(handler, dito, location.Label(), location.SLO)
+			}
+		case "graphql":
+			if location.GraphQL.Enabled {
+				dito.Logger.Debug("Applying GraphQL Middleware")
+				handler = cmid.GraphQLMiddleware(handler, dito, location.Label(), location.GraphQL)
+			}
+		case "concurrency-limiter-redis":
+			if location.ConcurrencyLimit.Enabled && dito.RedisClient != nil && dito.Config.Redis.Enabled {
+				dito.Logger.Debug("Applying Concurrency Limiter Middleware")
+				handler = cmid.ConcurrencyLimiterMiddlewareWithRedis(handler, location.Path, location.ConcurrencyLimit, dito.RedisClient, dito.Logger)
 			}
 		case "cache":
 			if dito.RedisClient != nil && dito.Config.Redis.Enabled && location.Cache.Enabled {
 				dito.Logger.Debug(fmt.Sprintf("Applying Cache Middleware with TTL: %d seconds", location.Cache.TTL))
-				handler = cmid.CacheMiddleware(handler, dito, location.Cache)
+				handler = cmid.CacheMiddleware(handler, dito, location.Label(), location.Cache, writer.PoolFor(location.Path))
 			}
 		}
 	}
 	return handler
 }
 
+// upstreamPath builds the path of an upstream request: the location prefix of the client path is replaced by
+// the path of the target URL, or the whole path when ReplacePath is set.
+//
+// Parameters:
+// - location: The location configuration.
+// - targetURL: The target URL of the upstream.
+// - path: The path of the client request.
+//
+// Returns:
+// - string: The path of the upstream request.
+func upstreamPath(location config.LocationConfig, targetURL *url.URL, path string) string {
+	if location.ReplacePath {
+		return targetURL.Path
+	}
+	return normalizePath(targetURL.Path, strings.TrimPrefix(path, location.Path))
+}
+
 // normalizePath normalizes the base path and additional path by ensuring there is exactly one slash between them.
 //
 // Parameters:
@@ -163,6 +535,37 @@ func normalizePath(basePath, additionalPath string) string {
 	return strings.TrimSuffix(basePath, "/") + "/" + strings.TrimPrefix(additionalPath, "/")
 }
 
+// methodAllowed checks if a method is accepted by the allowed methods of a location. HEAD is accepted
+// wherever GET is, as it is a GET without a body.
+//
+// Parameters:
+// - allowed: The allowed methods of the location, empty to allow any method.
+// - method: The method of the request.
+//
+// Returns:
+// - bool: True if the method is accepted, false otherwise.
+func methodAllowed(allowed []string, method string) bool {
+	if len(allowed) == 0 || slices.Contains(allowed, method) {
+		return true
+	}
+	return method == http.MethodHead && slices.Contains(allowed, http.MethodGet)
+}
+
+// allowHeader builds the value of the Allow header listing the allowed methods of a location.
+//
+// Parameters:
+// - allowed: The allowed methods of the location.
+//
+// Returns:
+// - string: The comma-separated methods, HEAD included when GET is allowed.
+func allowHeader(allowed []string) string {
+	methods := slices.Clone(allowed)
+	if slices.Contains(methods, http.MethodGet) && !slices.Contains(methods, http.MethodHead) {
+		methods = append(methods, http.MethodHead)
+	}
+	return strings.Join(methods, ", ")
+}
+
 // isMetricsEndpoint checks if the request path matches the configured metrics path.
 //
 // Parameters:
@@ -1,18 +1,28 @@
 package handlers_test
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"dito/app"
 	"dito/config"
 	"dito/handlers"
 	"dito/logging"
+	cmid "dito/middlewares"
+	"encoding/json"
+	"fmt"
 	"io"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 )
@@ -106,3 +116,820 @@ func TestDynamicProxyHandler(t *testing.T) {
 	// Check that the status code is what you expect.
 	assert.Equal(t, http.StatusOK, rr.Code)
 }
+
+// TestServeProxyPreserveHost verifies that the client Host header is forwarded only when preserve_host is enabled.
+func TestServeProxyPreserveHost(t *testing.T) {
+	var receivedHost string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedHost = r.Host
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	for _, preserveHost := range []bool{false, true} {
+		cfg := setupTestConfig()
+		cfg.Locations[0].TargetURL = upstream.URL
+		cfg.Locations[0].PreserveHost = preserveHost
+		config.UpdateConfig(cfg)
+		dito := setupDito()
+
+		req := httptest.NewRequest("GET", "http://public.example.org/test", nil)
+		rr := httptest.NewRecorder()
+		handlers.DynamicProxyHandler(dito, rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		if preserveHost {
+			assert.Equal(t, "public.example.org", receivedHost)
+		} else {
+			assert.Equal(t, strings.TrimPrefix(upstream.URL, "http://"), receivedHost)
+		}
+	}
+}
+
+// TestDynamicProxyHandlerMethodAndHeaderMatchers verifies that locations sharing a path are selected by method and headers.
+func TestDynamicProxyHandlerMethodAndHeaderMatchers(t *testing.T) {
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+	canary, writer, reader := newUpstream("canary"), newUpstream("writer"), newUpstream("reader")
+	defer canary.Close()
+	defer writer.Close()
+	defer reader.Close()
+
+	cfg := setupTestConfig()
+	cfg.Locations = []config.LocationConfig{
+		{Path: "^/api$", TargetURL: canary.URL, ReplacePath: true, MatchHeaders: []config.HeaderMatcher{
+			{Name: "X-Canary", CompiledRegex: regexp.MustCompile("^(yes|true)$")},
+		}},
+		{Path: "^/api$", TargetURL: writer.URL, ReplacePath: true, Methods: []string{"POST", "PUT"}},
+		{Path: "^/api$", TargetURL: reader.URL, ReplacePath: true, Methods: []string{"GET"}},
+	}
+	for i := range cfg.Locations {
+		cfg.Locations[i].CompiledRegex = regexp.MustCompile(cfg.Locations[i].Path)
+	}
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+
+	serve := func(method string, headers map[string]string) (int, string) {
+		req := httptest.NewRequest(method, "/api", nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		handlers.DynamicProxyHandler(dito, rr, req)
+		return rr.Code, rr.Body.String()
+	}
+
+	_, body := serve("GET", nil)
+	assert.Equal(t, "reader", body)
+	_, body = serve("POST", nil)
+	assert.Equal(t, "writer", body)
+	_, body = serve("POST", map[string]string{"X-Canary": "true"})
+	assert.Equal(t, "canary", body)
+	_, body = serve("GET", map[string]string{"X-Canary": "no"})
+	assert.Equal(t, "reader", body)
+	code, _ := serve("DELETE", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+// TestDynamicProxyHandlerAllowedMethods verifies that methods outside the allowed methods of a location are
+// rejected with 405 and an Allow header, and that HEAD is accepted where GET is.
+func TestDynamicProxyHandlerAllowedMethods(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := setupTestConfig()
+	cfg.Locations = []config.LocationConfig{
+		{Path: "^/docs$", TargetURL: upstream.URL, ReplacePath: true, AllowedMethods: []string{"GET", "OPTIONS"}},
+	}
+	cfg.Locations[0].CompiledRegex = regexp.MustCompile(cfg.Locations[0].Path)
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+
+	for method, expected := range map[string]int{
+		"GET":     http.StatusOK,
+		"HEAD":    http.StatusOK,
+		"OPTIONS": http.StatusOK,
+		"DELETE":  http.StatusMethodNotAllowed,
+		"TRACE":   http.StatusMethodNotAllowed,
+	} {
+		rr := httptest.NewRecorder()
+		handlers.DynamicProxyHandler(dito, rr, httptest.NewRequest(method, "/docs", nil))
+		assert.Equal(t, expected, rr.Code, method)
+		if expected == http.StatusMethodNotAllowed {
+			assert.Equal(t, "GET, OPTIONS, HEAD", rr.Header().Get("Allow"))
+		}
+	}
+}
+
+// TestDynamicProxyHandlerFallback verifies that requests matching no location go to the global fallback, and
+// that the GET requests of a location whose upstream answers 404 go to the fallback of the location.
+func TestDynamicProxyHandlerFallback(t *testing.T) {
+	spa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "spa %s", r.URL.Path)
+	}))
+	defer spa.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "api %s", r.URL.Path)
+	}))
+	defer api.Close()
+
+	cfg := setupTestConfig()
+	cfg.Locations = []config.LocationConfig{{
+		Path:        "^/api/",
+		TargetURL:   api.URL,
+		ReplacePath: true,
+		Fallback:    config.Fallback{TargetURL: spa.URL + "/index.html", ReplacePath: true, Statuses: []int{http.StatusNotFound}},
+	}}
+	cfg.Locations[0].CompiledRegex = regexp.MustCompile(cfg.Locations[0].Path)
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handlers.DynamicProxyHandler(dito, rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	assert.Equal(t, http.StatusNotFound, serve("GET", "/dashboard").Code, "no fallback is configured")
+
+	cfg.Fallback = config.Fallback{TargetURL: spa.URL}
+	rr := serve("GET", "/dashboard")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "spa /dashboard", rr.Body.String())
+
+	cfg.Locations[0].TargetURL = api.URL + "/missing"
+	rr = serve("GET", "/api/users")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "spa /index.html", rr.Body.String())
+	assert.Equal(t, http.StatusNotFound, serve("POST", "/api/users").Code, "the body of other requests is consumed")
+
+	cfg.Locations[0].TargetURL = api.URL + "/users"
+	assert.Equal(t, "api /users", serve("GET", "/api/users").Body.String())
+}
+
+// TestServeProxyMirror verifies that a copy of the requests, body included, reaches the mirror while the client
+// gets the response of the primary upstream.
+func TestServeProxyMirror(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		fmt.Fprint(w, "primary")
+	}))
+	defer upstream.Close()
+	mirrored := make(chan string, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mirrored <- fmt.Sprintf("%s %s?%s %s", r.Method, r.URL.Path, r.URL.RawQuery, body)
+		fmt.Fprint(w, "shadow")
+	}))
+	defer shadow.Close()
+
+	cfg := setupTestConfig()
+	cfg.Locations[0].TargetURL = upstream.URL
+	cfg.Locations[0].Mirror = config.Mirror{
+		TargetURL:   shadow.URL + "/v2",
+		Percentage:  100,
+		Timeout:     5 * time.Second,
+		MaxBodySize: 1024,
+		Compare:     config.MirrorCompare{Enabled: true, Percentage: 100, MaxBodySize: 1024},
+	}
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+
+	rr := httptest.NewRecorder()
+	handlers.ServeProxy(dito, 0, rr, httptest.NewRequest("POST", "/test/orders?page=2", strings.NewReader("order")))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "primary", rr.Body.String())
+
+	select {
+	case request := <-mirrored:
+		assert.Equal(t, "POST /v2/orders?page=2 order", request)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request was not mirrored")
+	}
+}
+
+// TestDynamicProxyHandlerEcho verifies that the echo endpoint describes the request and the location the rest of
+// its path matches, with the upstream request it would send, without forwarding it.
+func TestDynamicProxyHandlerEcho(t *testing.T) {
+	cfg := setupTestConfig()
+	cfg.Locations = []config.LocationConfig{{
+		Name:              "api",
+		Path:              "^/api/",
+		TargetURL:         "http://backend:8000/v1",
+		AdditionalHeaders: map[string]string{"X-Tenant": "acme"},
+		ExcludedHeaders:   []string{"X-Debug"},
+	}}
+	cfg.Locations[0].CompiledRegex = regexp.MustCompile(cfg.Locations[0].Path)
+	cfg.Echo = config.EchoConfig{Enabled: true, Path: config.DefaultEchoPath}
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+
+	serve := func(path string) map[string]any {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Debug", "1")
+		rr := httptest.NewRecorder()
+		handlers.DynamicProxyHandler(dito, rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		var echo map[string]any
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &echo))
+		return echo
+	}
+
+	echo := serve("/_dito/echo/api/users?page=2")
+	assert.Equal(t, "/_dito/echo/api/users?page=2", echo["url"])
+	location, ok := echo["location"].(map[string]any)
+	if assert.True(t, ok, "the rest of the path matches the location") {
+		assert.Equal(t, "api", location["name"])
+		assert.Equal(t, "http://backend:8000/v1/api/users?page=2", location["upstream_url"])
+		assert.Equal(t, "backend:8000", location["upstream_host"])
+		header := location["upstream_header"].(map[string]any)
+		assert.Equal(t, []any{"acme"}, header["X-Tenant"])
+		assert.Nil(t, header["X-Debug"])
+	}
+
+	assert.Nil(t, serve("/_dito/echo/other")["location"])
+
+	cfg.Echo.Enabled = false
+	rr := httptest.NewRecorder()
+	handlers.DynamicProxyHandler(dito, rr, httptest.NewRequest("GET", "/_dito/echo/api/users", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "the endpoint is opt-in")
+}
+
+// TestServeProxyExpectContinue verifies that the body of a request sent with Expect: 100-continue is requested
+// from the client only once the upstream accepts it, that interim responses are forwarded, and that a request
+// refused by the upstream is answered without its body being sent.
+func TestServeProxyExpectContinue(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/reject") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Link", "</app.css>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	cfg := setupTestConfig()
+	cfg.Locations[0].TargetURL = upstream.URL
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.ServeProxy(dito, 0, w, r)
+	}))
+	defer proxy.Close()
+
+	send := func(path string) (*bufio.Reader, net.Conn) {
+		conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: proxy\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n", path)
+		return bufio.NewReader(conn), conn
+	}
+
+	reader, conn := send("/test/accept")
+	defer conn.Close()
+	resp, err := http.ReadResponse(reader, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusEarlyHints, resp.StatusCode)
+		assert.Equal(t, "</app.css>; rel=preload", resp.Header.Get("Link"))
+	}
+	resp, err = http.ReadResponse(reader, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusContinue, resp.StatusCode)
+	}
+	conn.Write([]byte("hello"))
+	resp, err = http.ReadResponse(reader, nil)
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello", string(body))
+	}
+
+	reader, conn = send("/test/reject")
+	defer conn.Close()
+	resp, err = http.ReadResponse(reader, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+}
+
+// TestServeProxyRequestBuffering verifies that spooled request bodies are forwarded with a known length
+// and that bodies above the maximum size are refused.
+func TestServeProxyRequestBuffering(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%d:%s", r.ContentLength, body)
+	}))
+	defer upstream.Close()
+
+	cfg := setupTestConfig()
+	cfg.Locations[0].TargetURL = upstream.URL
+	cfg.Locations[0].RequestBuffering = config.RequestBuffering{Enabled: true, MemoryLimit: 4, MaxSize: 32, TempDir: t.TempDir()}
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+
+	serve := func(body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/test", body)
+		req.ContentLength = -1 // Simulate a chunked upload.
+		rr := httptest.NewRecorder()
+		handlers.ServeProxy(dito, 0, rr, req)
+		return rr
+	}
+
+	rr := serve(strings.NewReader("spooled body"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "12:spooled body", rr.Body.String())
+
+	rr = serve(strings.NewReader(strings.Repeat("x", 33)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+}
+
+// TestServeProxyTrailers verifies that the trailers of the client requests reach the upstream, with or without
+// request buffering, and that the trailers of the upstream responses reach the client.
+func TestServeProxyTrailers(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write(body)
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"X-Checksum", r.Trailer.Get("X-Checksum"))
+	}))
+	defer upstream.Close()
+
+	for _, buffering := range []bool{false, true} {
+		cfg := setupTestConfig()
+		cfg.Locations[0].TargetURL = upstream.URL
+		cfg.Locations[0].RequestBuffering = config.RequestBuffering{Enabled: buffering, MemoryLimit: 4, MaxSize: 1024, TempDir: t.TempDir()}
+		config.UpdateConfig(cfg)
+		dito := setupDito()
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers.ServeProxy(dito, 0, w, r)
+		}))
+
+		// Hiding the length of the body makes the client send it chunked, followed by the trailers.
+		req, _ := http.NewRequest("POST", proxy.URL+"/test", io.MultiReader(strings.NewReader("payload")))
+		req.Trailer = http.Header{"X-Checksum": {"abc"}}
+		resp, err := http.DefaultClient.Do(req)
+		if assert.NoError(t, err, "buffering: %v", buffering) {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, "payload", string(body))
+			assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"), "buffering: %v", buffering)
+			assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"), "buffering: %v", buffering)
+		}
+		proxy.Close()
+	}
+}
+
+// TestServeProxyRanges verifies that Range requests and their partial responses pass through untouched, even on
+// a location rewriting its bodies, while the rewritten full responses no longer advertise ranges.
+func TestServeProxyRanges(t *testing.T) {
+	var upstream *httptest.Server
+	upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("<a href=\""+upstream.URL+"/page\">"))
+	}))
+	defer upstream.Close()
+
+	cfg := setupTestConfig()
+	cfg.Locations[0].TargetURL = upstream.URL
+	cfg.Locations[0].SubFilter = config.SubFilter{Enabled: true, PublicURL: "https://www.example.com"}
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/test", nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rr := httptest.NewRecorder()
+		handlers.ServeProxy(dito, 0, rr, req)
+		return rr
+	}
+
+	rr := serve(http.Header{"Range": {"bytes=0-7"}})
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, fmt.Sprintf("bytes 0-7/%d", len(upstream.URL)+16), rr.Header().Get("Content-Range"))
+	assert.Equal(t, "<a href=", rr.Body.String())
+
+	rr = serve(http.Header{"Range": {"bytes=0-7"}, "If-Range": {`"v0"`}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `<a href="https://www.example.com/page">`, rr.Body.String())
+	assert.Empty(t, rr.Header().Get("Accept-Ranges"))
+}
+
+// TestServeProxySSEHeartbeats verifies that heartbeats fill the silences of event streams between events.
+func TestServeProxySSEHeartbeats(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(w, "data: second\n\n")
+	}))
+	defer upstream.Close()
+
+	cfg := setupTestConfig()
+	cfg.Locations[0].TargetURL = upstream.URL
+	cfg.Locations[0].KeepaliveInterval = 30 * time.Millisecond
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+
+	rr := httptest.NewRecorder()
+	handlers.ServeProxy(dito, 0, rr, httptest.NewRequest("GET", "/test", nil))
+
+	body := rr.Body.String()
+	assert.True(t, strings.HasPrefix(body, "data: first\n\n: keepalive\n"), "unexpected body: %q", body)
+	assert.True(t, strings.HasSuffix(body, ": keepalive\ndata: second\n\n"), "unexpected body: %q", body)
+}
+
+// TestServeProxyServerTiming verifies that the upstream timings are appended to the Server-Timing header.
+func TestServeProxyServerTiming(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server-Timing", "db;dur=5")
+	}))
+	defer upstream.Close()
+
+	cfg := setupTestConfig()
+	cfg.Locations[0].TargetURL = upstream.URL
+	cfg.Locations[0].ServerTiming = true
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+
+	rr := httptest.NewRecorder()
+	handlers.ServeProxy(dito, 0, rr, httptest.NewRequest("GET", "/test", nil))
+
+	values := rr.Header().Values("Server-Timing")
+	if assert.Len(t, values, 2) {
+		assert.Equal(t, "db;dur=5", values[0])
+		assert.Regexp(t, `^upstream-dns;dur=[0-9.]+, upstream-connect;dur=[0-9.]+, upstream-tls;dur=[0-9.]+, upstream-ttfb;dur=[0-9.]+$`, values[1])
+	}
+}
+
+// TestServeProxyDeadline verifies that the remaining deadline is propagated and that the upstream request is
+// abandoned once it elapses.
+func TestServeProxyDeadline(t *testing.T) {
+	received := make(chan http.Header, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	cfg := setupTestConfig()
+	cfg.Locations[0].TargetURL = upstream.URL
+	cfg.Locations[0].Deadline = config.DeadlineConfig{
+		Timeout: 200 * time.Millisecond,
+		Headers: []config.DeadlineHeader{
+			{Name: "X-Request-Timeout-Ms", Format: config.DeadlineFormatMilliseconds},
+			{Name: "grpc-timeout", Format: config.DeadlineFormatGRPC},
+		},
+	}
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-Request-Timeout-Ms", "999999")
+	rr := httptest.NewRecorder()
+	handlers.ServeProxy(dito, 0, rr, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+	headers := <-received
+	assert.Regexp(t, `^(1\d\d|200)$`, headers.Get("X-Request-Timeout-Ms"))
+	assert.Regexp(t, `^(1\d\d|200)m$`, headers.Get("grpc-timeout"))
+}
+
+// TestServeProxyStatusRewrites verifies that upstream status codes are rewritten, and that bodies are dropped
+// for statuses that cannot carry one.
+func TestServeProxyStatusRewrites(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("case") {
+		case "missing":
+			http.NotFound(w, r)
+		case "denied":
+			http.Error(w, "denied", http.StatusUnauthorized)
+		default:
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	defer upstream.Close()
+
+	cfg := setupTestConfig()
+	cfg.Locations[0].TargetURL = upstream.URL
+	cfg.Locations[0].StatusRewrites = []config.StatusRewrite{
+		{From: http.StatusNotFound, To: http.StatusNoContent},
+		{From: http.StatusUnauthorized, To: http.StatusForbidden},
+	}
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handlers.ServeProxy(dito, 0, rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	rr := serve("/test?case=missing")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Empty(t, rr.Body.String())
+	assert.Empty(t, rr.Header().Get("Content-Type"))
+
+	rr = serve("/test?case=denied")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Equal(t, "denied\n", rr.Body.String())
+
+	rr = serve("/test")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "ok", rr.Body.String())
+}
+
+// TestServeProxyCookies verifies that the cookie rules are applied to requests and responses.
+func TestServeProxyCookies(t *testing.T) {
+	var received string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("Cookie")
+		w.Header().Add("Set-Cookie", "sid=abc; Path=/; SameSite=Lax")
+		w.Header().Add("Set-Cookie", "_ga=1; Path=/")
+		w.Header().Add("Set-Cookie", "theme=dark")
+	}))
+	defer upstream.Close()
+
+	cfg := setupTestConfig()
+	cfg.Locations[0].TargetURL = upstream.URL
+	cfg.Locations[0].Cookies = config.CookieConfig{
+		StripRequest:  []string{"_ga*"},
+		StripResponse: []string{"_ga"},
+		Rename:        map[string]string{"sid": "app_sid"},
+		HTTPOnly:      true,
+		SameSite:      config.SameSiteNone,
+	}
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Cookie", "app_sid=abc; _ga=1; _gat=2; theme=dark")
+	rr := httptest.NewRecorder()
+	handlers.ServeProxy(dito, 0, rr, req)
+
+	assert.Equal(t, "sid=abc; theme=dark", received)
+	assert.Equal(t, []string{
+		"app_sid=abc; Path=/; SameSite=None; Secure; HttpOnly",
+		"theme=dark; Secure; HttpOnly; SameSite=None",
+	}, rr.Header().Values("Set-Cookie"))
+}
+
+// TestServeProxyCookieScope verifies that the Domain and Path of upstream cookies are mapped to the public URL space.
+func TestServeProxyCookieScope(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "sid=abc; Domain=127.0.0.1; Path=/internal/app")
+		w.Header().Add("Set-Cookie", "lang=en; Path=/")
+		w.Header().Add("Set-Cookie", "other=1; Domain=example.org; Path=/elsewhere")
+	}))
+	defer upstream.Close()
+
+	cfg := setupTestConfig()
+	cfg.Locations[0].TargetURL = upstream.URL + "/internal/"
+	cfg.Locations[0].ReplacePath = false
+	cfg.Locations[0].Cookies = config.CookieConfig{RewriteDomain: true, RewritePath: true}
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+
+	req := httptest.NewRequest("GET", "http://public.example.com:8443/test/page", nil)
+	rr := httptest.NewRecorder()
+	handlers.ServeProxy(dito, 0, rr, req)
+
+	assert.Equal(t, []string{
+		"sid=abc; Domain=public.example.com; Path=/test/app",
+		"lang=en; Path=/",
+		"other=1; Domain=example.org; Path=/elsewhere",
+	}, rr.Header().Values("Set-Cookie"))
+}
+
+// TestServeProxyRewriteRedirects verifies that redirects to the target are mapped to the public URL space.
+func TestServeProxyRewriteRedirects(t *testing.T) {
+	var upstream *httptest.Server
+	upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("case") {
+		case "absolute":
+			w.Header().Set("Content-Location", upstream.URL+"/internal/items/1")
+			http.Redirect(w, r, upstream.URL+"/internal/login?next=%2F#top", http.StatusFound)
+		case "path":
+			http.Redirect(w, r, "/internal/home", http.StatusMovedPermanently)
+		default:
+			http.Redirect(w, r, "https://sso.example.com/auth", http.StatusFound)
+		}
+	}))
+	defer upstream.Close()
+
+	cfg := setupTestConfig()
+	cfg.Locations[0].TargetURL = upstream.URL + "/internal"
+	cfg.Locations[0].ReplacePath = false
+	cfg.Locations[0].RewriteRedirects = true
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+
+	serve := func(query string) http.Header {
+		rr := httptest.NewRecorder()
+		handlers.ServeProxy(dito, 0, rr, httptest.NewRequest("GET", "/test/page?case="+query, nil))
+		return rr.Header()
+	}
+
+	headers := serve("absolute")
+	assert.Equal(t, "/test/login?next=%2F#top", headers.Get("Location"))
+	assert.Equal(t, "/test/items/1", headers.Get("Content-Location"))
+	assert.Equal(t, "/test/home", serve("path").Get("Location"))
+	assert.Equal(t, "https://sso.example.com/auth", serve("external").Get("Location"))
+}
+
+// TestServeProxySubFilter verifies that the upstream URLs are rewritten in textual bodies only, including
+// bodies the upstream compressed.
+func TestServeProxySubFilter(t *testing.T) {
+	var upstream *httptest.Server
+	upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := upstream.URL + "/internal"
+		if r.URL.Query().Get("type") == "gzip" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			fmt.Fprintf(gz, "%s (%s)", base, r.Header.Get("Accept-Encoding"))
+			gz.Close()
+			return
+		}
+		if r.URL.Query().Get("type") == "binary" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			fmt.Fprint(w, base)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<a href="%s/page">page</a><script>var api = "%s";</script>`, base, strings.ReplaceAll(base, "/", `\/`))
+	}))
+	defer upstream.Close()
+
+	cfg := setupTestConfig()
+	cfg.Locations[0].TargetURL = upstream.URL + "/internal/"
+	cfg.Locations[0].ReplacePath = false
+	cfg.Locations[0].SubFilter = config.SubFilter{Enabled: true, PublicURL: "https://www.example.com/test"}
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+
+	serve := func(path string) string {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "compress, gzip;q=0.8")
+		handlers.ServeProxy(dito, 0, rr, req)
+		if rr.Header().Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(rr.Body)
+			if err != nil {
+				return err.Error()
+			}
+			body, _ := io.ReadAll(gz)
+			return string(body)
+		}
+		return rr.Body.String()
+	}
+
+	assert.Equal(t, `<a href="https://www.example.com/test/page">page</a><script>var api = "https:\/\/www.example.com\/test";</script>`, serve("/test"))
+	assert.Equal(t, upstream.URL+"/internal", serve("/test?type=binary"))
+	assert.Equal(t, "https://www.example.com/test (gzip;q=0.8)", serve("/test?type=gzip"))
+}
+
+// TestServeProxyContentTypes verifies that request bodies of types not allowed or contradicting their declared
+// type are rejected with 415, including multipart file parts of buffered requests, and that the Content-Type of
+// upstream responses is overridden.
+func TestServeProxyContentTypes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	cfg := setupTestConfig()
+	cfg.Locations[0].TargetURL = upstream.URL
+	cfg.Locations[0].RequestBuffering = config.RequestBuffering{Enabled: true}
+	cfg.Locations[0].ContentType = config.ContentTypeRules{
+		Allowed: []string{"application/json", "image/*", "multipart/form-data"},
+		Sniff:   true,
+		NoSniff: true,
+		Overrides: []config.ContentTypeOverride{
+			{CompiledPath: regexp.MustCompile(`\.js$`), From: "text/plain", To: "application/javascript"},
+		},
+	}
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	multipartBody := func(partType, content string) (string, string) {
+		var body bytes.Buffer
+		parts := multipart.NewWriter(&body)
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="file"; filename="avatar.png"`)
+		header.Set("Content-Type", partType)
+		part, _ := parts.CreatePart(header)
+		part.Write([]byte(content))
+		parts.Close()
+		return body.String(), parts.FormDataContentType()
+	}
+	serve := func(path, contentType, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		handlers.ServeProxy(dito, 0, rr, req)
+		return rr
+	}
+
+	rr := serve("/test", "application/json", `{"a":1}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `{"a":1}`, rr.Body.String())
+	assert.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "text/plain", rr.Header().Get("Content-Type"))
+
+	assert.Equal(t, http.StatusOK, serve("/test", "image/png", png).Code)
+	assert.Equal(t, http.StatusUnsupportedMediaType, serve("/test", "text/csv", "a,b").Code)
+	assert.Equal(t, http.StatusUnsupportedMediaType, serve("/test", "image/png", "<html><script>alert(1)</script>").Code)
+
+	body, contentType := multipartBody("image/png", png)
+	assert.Equal(t, http.StatusOK, serve("/test", contentType, body).Code)
+	body, contentType = multipartBody("image/png", "<!DOCTYPE html><p>hi</p>")
+	assert.Equal(t, http.StatusUnsupportedMediaType, serve("/test", contentType, body).Code)
+	body, contentType = multipartBody("application/x-sh", "#!/bin/sh")
+	assert.Equal(t, http.StatusUnsupportedMediaType, serve("/test", contentType, body).Code)
+
+	assert.Equal(t, "application/javascript", serve("/test/app.js", "application/json", `{}`).Header().Get("Content-Type"))
+}
+
+// TestServeProxySessionHeaders verifies that the upstream writes and destroys the sessions through its response
+// headers, which never reach the client, and that the values written are forwarded with the next requests.
+func TestServeProxySessionHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/login"):
+			w.Header().Set("X-Session-User", "alice")
+		case strings.HasSuffix(r.URL.Path, "/logout"):
+			w.Header().Set("X-Session-Destroy", "1")
+		}
+		fmt.Fprint(w, r.Header.Get("X-User-ID"))
+	}))
+	defer upstream.Close()
+
+	server := miniredis.RunT(t)
+	cfg := setupTestConfig()
+	cfg.Redis.Enabled = true
+	cfg.Locations[0].TargetURL = upstream.URL
+	cfg.Locations[0].Session = config.Session{
+		Enabled:         true,
+		Secret:          strings.Repeat("s", 32),
+		CookieName:      "sid",
+		TTL:             time.Hour,
+		Headers:         map[string]string{"user": "X-User-ID"},
+		ResponseHeaders: map[string]string{"user": "X-Session-User"},
+		DestroyHeader:   "X-Session-Destroy",
+	}
+	config.UpdateConfig(cfg)
+	dito := setupDito()
+	dito.RedisClient = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer dito.RedisClient.Close()
+	handler := cmid.SessionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.ServeProxy(dito, 0, w, r)
+	}), dito, "/test", cfg.Locations[0].Session)
+
+	var cookie *http.Cookie
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if cookies := rr.Result().Cookies(); len(cookies) > 0 {
+			cookie = cookies[0]
+		}
+		return rr
+	}
+
+	rr := serve("/test/login")
+	assert.Empty(t, rr.Header().Get("X-Session-User"))
+	loggedIn := cookie.Value
+	assert.Equal(t, "alice", serve("/test/profile").Body.String())
+	assert.Equal(t, loggedIn, cookie.Value)
+
+	rr = serve("/test/logout")
+	assert.Empty(t, rr.Header().Get("X-Session-Destroy"))
+	assert.Empty(t, server.Keys())
+
+	// The destroyed session is not reused.
+	assert.Empty(t, serve("/test/profile").Body.String())
+	assert.NotEqual(t, loggedIn, cookie.Value)
+}
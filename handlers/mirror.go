@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"dito/app"
+	"dito/config"
+	"dito/metrics"
+	"dito/transport"
+	"dito/writer"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Results of the comparison of a mirrored response with the primary one.
+const (
+	mirrorMatch          = "match"
+	mirrorStatusMismatch = "status_mismatch"
+	mirrorBodyMismatch   = "body_mismatch"
+	mirrorIncomplete     = "incomplete"
+)
+
+// hopHeaders are the headers describing the client connection, which are not copied to the upstream requests
+// built by the proxy itself.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade", "Expect"}
+
+// capturedResponse is the status and the beginning of the body of an upstream response, kept for comparison.
+type capturedResponse struct {
+	status    int
+	header    http.Header
+	body      []byte
+	complete  bool // complete is true once the whole body was read, or its first bytes beyond the maximum size.
+	truncated bool // truncated is true if the body exceeds the maximum size, so only the status is compared.
+}
+
+// mirrorComparison hands the response of the primary upstream to the comparison running alongside the mirrored
+// request.
+type mirrorComparison struct {
+	primary     chan capturedResponse
+	maxBodySize int64
+	once        sync.Once
+}
+
+// mirror sends a copy of a request to the mirror of its location in the background, for the configured
+// percentage of the requests. The body of the request is read first, so that both upstreams receive it, unless
+// it exceeds the maximum size, in which case the request is not mirrored.
+//
+// Parameters:
+// - dito: The Dito application instance containing the configuration and logger.
+// - location: The location configuration.
+// - r: The client request, whose body is replaced by a replayable copy.
+//
+// Returns:
+// - *mirrorComparison: The comparison to which the primary response is handed, to be finished once the request
+// is done; nil if the request is not mirrored or its responses not compared.
+func mirror(dito *app.Dito, location config.LocationConfig, r *http.Request) *mirrorComparison {
+	settings := location.Mirror
+	if rand.Float64()*100 >= settings.Percentage {
+		return nil
+	}
+	body, ok := copyRequestBody(r, settings.MaxBodySize)
+	if !ok {
+		dito.Logger.Debug(fmt.Sprintf("Request body of %s exceeds the maximum size of the mirror, not mirrored", location.Label()))
+		return nil
+	}
+
+	var comparison *mirrorComparison
+	if settings.Compare.Enabled && rand.Float64()*100 < settings.Compare.Percentage {
+		comparison = &mirrorComparison{primary: make(chan capturedResponse, 1), maxBodySize: settings.Compare.MaxBodySize}
+	}
+	req, cancel, err := newMirrorRequest(location, r, body)
+	if err != nil {
+		dito.Logger.Error(fmt.Sprintf("Error building the mirrored request of %s: %v", location.Label(), err))
+		comparison.finish()
+		return nil
+	}
+
+	go func() {
+		defer cancel()
+		mirrorLocation := location
+		mirrorLocation.TargetURL = settings.TargetURL
+		caronte := &transport.Caronte{Location: &mirrorLocation, TransportCache: dito.TransportCache}
+
+		var shadow capturedResponse
+		resp, err := caronte.RoundTrip(req)
+		if err == nil {
+			shadow = captureResponse(resp, settings.Compare.MaxBodySize, comparison != nil)
+			if !shadow.complete {
+				err = fmt.Errorf("reading the response body failed")
+			}
+		}
+		if err != nil {
+			dito.Logger.Debug(fmt.Sprintf("Mirror of %s failed: %v", location.Label(), err))
+		}
+		if dito.Config.Metrics.Enabled {
+			metrics.RecordMirrorRequest(location.Label(), err != nil)
+		}
+		if comparison == nil {
+			return
+		}
+
+		primary := <-comparison.primary
+		result := compareResponses(primary, shadow, settings.Compare.IgnoreFields)
+		if result == mirrorStatusMismatch || result == mirrorBodyMismatch {
+			dito.Logger.Debug(fmt.Sprintf("Mirror of %s answered %s %s differently (%s): status %d instead of %d", location.Label(), req.Method, r.URL.Path, result, shadow.status, primary.status))
+		}
+		if dito.Config.Metrics.Enabled {
+			metrics.RecordMirrorComparison(location.Label(), result)
+		}
+	}()
+	return comparison
+}
+
+// copyRequestBody reads the body of a request, up to a maximum size, and replaces it by a copy, so that it can
+// be sent to the mirror too. Bodies spooled by the request buffering are read from the spool.
+//
+// Parameters:
+// - r: The client request.
+// - maxSize: The maximum size of the body, in bytes.
+//
+// Returns:
+// - []byte: The body (nil without a body).
+// - bool: False if the body exceeds the maximum size; the request is then left readable as it was.
+func copyRequestBody(r *http.Request, maxSize int64) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	if r.ContentLength > maxSize {
+		return nil, false
+	}
+	if r.GetBody != nil {
+		spooled, err := r.GetBody()
+		if err != nil {
+			return nil, false
+		}
+		defer spooled.Close()
+		body, err := io.ReadAll(io.LimitReader(spooled, maxSize+1))
+		return body, err == nil && int64(len(body)) <= maxSize
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSize+1))
+	if err != nil || int64(len(body)) > maxSize {
+		// The bytes read are put back in front of the rest of the body.
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, false
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true
+}
+
+// newMirrorRequest builds the copy of a client request sent to the mirror of its location. Its path is built
+// as the one of the primary request, and it is not canceled along with the client request.
+//
+// Parameters:
+// - location: The location configuration.
+// - r: The client request.
+// - body: The body of the client request.
+//
+// Returns:
+// - *http.Request: The mirrored request.
+// - context.CancelFunc: The function releasing the timeout of the mirrored request.
+// - error: An error if the target URL of the mirror is invalid.
+func newMirrorRequest(location config.LocationConfig, r *http.Request, body []byte) (*http.Request, context.CancelFunc, error) {
+	targetURL, err := url.Parse(location.Mirror.TargetURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	mirrorURL := *targetURL
+	mirrorURL.Path = upstreamPath(location, targetURL, r.URL.Path)
+	mirrorURL.RawPath = ""
+	mirrorURL.RawQuery = r.URL.RawQuery
+
+	ctx, cancel := context.WithTimeout(context.Background(), location.Mirror.Timeout)
+	req, err := http.NewRequestWithContext(ctx, r.Method, mirrorURL.String(), bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	req.Header = r.Header.Clone()
+	for _, header := range hopHeaders {
+		req.Header.Del(header)
+	}
+	if body == nil {
+		req.Body = http.NoBody
+	}
+	req.ContentLength = int64(len(body))
+	req.RemoteAddr = r.RemoteAddr
+	if location.PreserveHost {
+		req.Host = r.Host
+	}
+	rewriteRequestCookies(req.Header, location.Cookies)
+	return req, cancel, nil
+}
+
+// captureResponse reads the body of a mirrored response and closes it.
+//
+// Parameters:
+// - resp: The mirrored response.
+// - maxBodySize: The maximum size of the body kept for comparison.
+// - keep: Whether the body is kept for comparison, or only drained.
+//
+// Returns:
+// - capturedResponse: The captured response.
+func captureResponse(resp *http.Response, maxBodySize int64, keep bool) capturedResponse {
+	defer resp.Body.Close()
+	captured := capturedResponse{status: resp.StatusCode, header: resp.Header}
+	if !keep {
+		_, err := io.Copy(io.Discard, resp.Body)
+		captured.complete = err == nil
+		return captured
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	captured.complete = err == nil
+	if int64(len(body)) > maxBodySize {
+		captured.truncated = true
+		body = nil
+	}
+	captured.body = body
+	return captured
+}
+
+// capture hands the status and the body of the primary response to the comparison once the body is closed,
+// i.e. once it was sent to the client.
+//
+// Parameters:
+// - resp: The response of the primary upstream.
+func (c *mirrorComparison) capture(resp *http.Response) {
+	resp.Body = &capturingBody{
+		ReadCloser: resp.Body,
+		comparison: c,
+		captured:   capturedResponse{status: resp.StatusCode, header: resp.Header.Clone()},
+	}
+}
+
+// finish releases the comparison when the primary response was not captured, e.g. after an upstream error or
+// a fallback, so that the comparison reports it as incomplete. It does nothing on a nil comparison.
+func (c *mirrorComparison) finish() {
+	if c == nil {
+		return
+	}
+	c.once.Do(func() { c.primary <- capturedResponse{} })
+}
+
+// capturingBody is a response body keeping the bytes read, up to the maximum size of the comparison.
+type capturingBody struct {
+	io.ReadCloser
+	comparison *mirrorComparison
+	captured   capturedResponse
+}
+
+// Read reads the body and keeps the bytes read.
+func (b *capturingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.captured.truncated {
+		b.captured.body = append(b.captured.body, p[:n]...)
+		if int64(len(b.captured.body)) > b.comparison.maxBodySize {
+			b.captured.truncated = true
+			b.captured.complete = true
+			b.captured.body = nil
+		}
+	}
+	if err == io.EOF {
+		b.captured.complete = true
+	}
+	return n, err
+}
+
+// Close closes the body and hands the captured response to the comparison.
+func (b *capturingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.comparison.once.Do(func() { b.comparison.primary <- b.captured })
+	return err
+}
+
+// compareResponses compares a mirrored response with the primary one: their status codes, then their bodies,
+// decompressed if gzipped. JSON bodies are compared by value, without the ignored fields.
+//
+// Parameters:
+// - primary: The response of the primary upstream.
+// - shadow: The response of the mirror.
+// - ignoreFields: The fields of JSON bodies ignored at any depth.
+//
+// Returns:
+// - string: The result (match, status_mismatch, body_mismatch, or incomplete).
+func compareResponses(primary, shadow capturedResponse, ignoreFields []string) string {
+	if !primary.complete || !shadow.complete {
+		return mirrorIncomplete
+	}
+	if primary.status != shadow.status {
+		return mirrorStatusMismatch
+	}
+	if primary.truncated || shadow.truncated {
+		return mirrorMatch
+	}
+	primaryBody, primaryErr := decodedBody(primary)
+	shadowBody, shadowErr := decodedBody(shadow)
+	if primaryErr != nil || shadowErr != nil {
+		return mirrorIncomplete
+	}
+	if bytes.Equal(primaryBody, shadowBody) {
+		return mirrorMatch
+	}
+	if writer.IsJSON(primary.header.Get("Content-Type")) && writer.IsJSON(shadow.header.Get("Content-Type")) {
+		var primaryValue, shadowValue any
+		if json.Unmarshal(primaryBody, &primaryValue) == nil && json.Unmarshal(shadowBody, &shadowValue) == nil &&
+			reflect.DeepEqual(withoutFields(primaryValue, ignoreFields), withoutFields(shadowValue, ignoreFields)) {
+			return mirrorMatch
+		}
+	}
+	return mirrorBodyMismatch
+}
+
+// decodedBody returns the body of a captured response, decompressed if it is gzipped.
+func decodedBody(captured capturedResponse) ([]byte, error) {
+	if !strings.EqualFold(captured.header.Get("Content-Encoding"), "gzip") || len(captured.body) == 0 {
+		return captured.body, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(captured.body))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+// withoutFields removes the given fields from the objects of a decoded JSON value, at any depth.
+func withoutFields(value any, fields []string) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for _, field := range fields {
+			delete(v, field)
+		}
+		for key, item := range v {
+			v[key] = withoutFields(item, fields)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = withoutFields(item, fields)
+		}
+	}
+	return value
+}
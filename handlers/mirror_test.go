@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCompareResponses verifies that responses are compared by status, then by body, with JSON bodies compared
+// by value without the ignored fields and gzipped bodies decompressed.
+func TestCompareResponses(t *testing.T) {
+	jsonHeader := http.Header{"Content-Type": {"application/json"}}
+	response := func(status int, header http.Header, body string) capturedResponse {
+		return capturedResponse{status: status, header: header, body: []byte(body), complete: true}
+	}
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write([]byte("same"))
+	zw.Close()
+
+	tests := []struct {
+		name            string
+		primary, shadow capturedResponse
+		expected        string
+	}{
+		{"identical", response(200, http.Header{}, "same"), response(200, http.Header{}, "same"), mirrorMatch},
+		{"status", response(200, http.Header{}, "same"), response(500, http.Header{}, "same"), mirrorStatusMismatch},
+		{"body", response(200, http.Header{}, "same"), response(200, http.Header{}, "other"), mirrorBodyMismatch},
+		{"json order and ignored fields", response(200, jsonHeader, `{"a":1,"b":[{"id":"x","v":2}]}`), response(200, jsonHeader, `{"b":[{"v":2,"id":"y"}],"a":1}`), mirrorMatch},
+		{"json value", response(200, jsonHeader, `{"a":1}`), response(200, jsonHeader, `{"a":2}`), mirrorBodyMismatch},
+		{"gzip", response(200, http.Header{"Content-Encoding": {"gzip"}}, gzipped.String()), response(200, http.Header{}, "same"), mirrorMatch},
+		{"truncated", response(200, http.Header{}, "same"), capturedResponse{status: 200, header: http.Header{}, complete: true, truncated: true}, mirrorMatch},
+		{"incomplete", capturedResponse{}, response(200, http.Header{}, "same"), mirrorIncomplete},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, compareResponses(tt.primary, tt.shadow, []string{"id"}), tt.name)
+	}
+}
+
+// TestCopyRequestBody verifies that bodies within the maximum size are copied and still readable, and that
+// larger bodies are left readable as they were.
+func TestCopyRequestBody(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader("small body"))
+	r.ContentLength = -1
+	body, ok := copyRequestBody(r, 16)
+	assert.True(t, ok)
+	assert.Equal(t, "small body", string(body))
+	forwarded, _ := io.ReadAll(r.Body)
+	assert.Equal(t, "small body", string(forwarded))
+
+	r = httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("x", 32)))
+	r.ContentLength = -1
+	_, ok = copyRequestBody(r, 16)
+	assert.False(t, ok)
+	forwarded, _ = io.ReadAll(r.Body)
+	assert.Equal(t, strings.Repeat("x", 32), string(forwarded))
+
+	_, ok = copyRequestBody(httptest.NewRequest("GET", "/", nil), 16)
+	assert.True(t, ok)
+}
+
+// TestMirrorComparisonCapture verifies that the primary response is handed to the comparison once its body is
+// closed, and that a comparison without a captured response is reported as incomplete.
+func TestMirrorComparisonCapture(t *testing.T) {
+	comparison := &mirrorComparison{primary: make(chan capturedResponse, 1), maxBodySize: 1024}
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("primary"))}
+	comparison.capture(resp)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	comparison.finish()
+
+	captured := <-comparison.primary
+	assert.True(t, captured.complete)
+	assert.Equal(t, "primary", string(captured.body))
+
+	comparison = &mirrorComparison{primary: make(chan capturedResponse, 1)}
+	comparison.finish()
+	assert.Equal(t, mirrorIncomplete, compareResponses(<-comparison.primary, capturedResponse{complete: true}, nil))
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"dito/config"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// urlMapping maps the URL space of the upstream of a location back to the public URL space of the proxy,
+// as seen by the client of a request.
+type urlMapping struct {
+	upstreamAuthorities []string // upstreamAuthorities are the hosts (with port) of the absolute upstream URLs.
+	upstreamBase        string   // upstreamBase is the target URL, without trailing slash.
+	upstreamHost        string   // upstreamHost is the host name of the target URL, without port.
+	upstreamPath        string   // upstreamPath is the path of the target URL, without trailing slash.
+	publicHost          string   // publicHost is the host name requested by the client, without port.
+	publicPath          string   // publicPath is the public prefix of the upstream path, without trailing slash.
+}
+
+// newURLMapping creates the mapping of the upstream URLs of a request, mirroring how the proxy builds the
+// upstream path: the location prefix is replaced by the target path, or the whole path when ReplacePath is set.
+//
+// Parameters:
+// - location: The location configuration.
+// - targetURL: The target URL of the location.
+// - r: The client request.
+//
+// Returns:
+// - urlMapping: The URL mapping of the request.
+func newURLMapping(location config.LocationConfig, targetURL *url.URL, r *http.Request) urlMapping {
+	mapping := urlMapping{
+		upstreamAuthorities: []string{targetURL.Host},
+		upstreamBase:        strings.TrimSuffix(targetURL.Scheme+"://"+targetURL.Host+targetURL.Path, "/"),
+		upstreamHost:        targetURL.Hostname(),
+		upstreamPath:        strings.TrimSuffix(targetURL.Path, "/"),
+		publicHost:          r.Host,
+	}
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		mapping.publicHost = host
+	}
+	if location.PreserveHost {
+		// The upstream sees the client's Host header, and builds its URLs with it.
+		mapping.upstreamAuthorities = append(mapping.upstreamAuthorities, r.Host)
+	}
+	switch {
+	case location.ReplacePath:
+		mapping.publicPath = strings.TrimSuffix(r.URL.Path, "/")
+	case strings.HasPrefix(r.URL.Path, location.Path):
+		mapping.publicPath = strings.TrimSuffix(location.Path, "/")
+	}
+	return mapping
+}
+
+// path maps an upstream path to the public path.
+//
+// Parameters:
+// - upstreamPath: The path in the URL space of the upstream.
+//
+// Returns:
+// - string: The public path.
+// - bool: False if the path is outside the target path, and cannot be mapped.
+func (m urlMapping) path(upstreamPath string) (string, bool) {
+	if !strings.HasPrefix(upstreamPath, "/") {
+		return "", false
+	}
+	rest, ok := strings.CutPrefix(upstreamPath, m.upstreamPath)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return "", false
+	}
+	if publicPath := m.publicPath + rest; publicPath != "" {
+		return publicPath, true
+	}
+	return "/", true
+}
+
+// domain maps the Domain attribute of an upstream cookie to the public host.
+//
+// Parameters:
+// - domain: The Domain attribute of the cookie.
+//
+// Returns:
+// - string: The public host.
+// - bool: False if the domain is not the one of the upstream.
+func (m urlMapping) domain(domain string) (string, bool) {
+	if !strings.EqualFold(strings.TrimPrefix(domain, "."), m.upstreamHost) || m.publicHost == "" {
+		return "", false
+	}
+	return m.publicHost, true
+}
+
+// url maps an upstream URL, such as the Location of a redirect, to a public one. Absolute URLs of the upstream
+// and absolute paths are mapped to an absolute path, which keeps the scheme and host used by the client.
+//
+// Parameters:
+// - upstreamURL: The URL in the URL space of the upstream.
+//
+// Returns:
+// - string: The public URL.
+// - bool: False if the URL points elsewhere, or is relative to the current path, and is kept as is.
+func (m urlMapping) url(upstreamURL string) (string, bool) {
+	u, err := url.Parse(upstreamURL)
+	if err != nil || u.Opaque != "" || (u.Scheme != "" && u.Host == "") {
+		return "", false
+	}
+	if u.Host != "" && !slices.ContainsFunc(m.upstreamAuthorities, func(authority string) bool {
+		return strings.EqualFold(authority, u.Host)
+	}) {
+		return "", false
+	}
+	public, ok := m.path(u.EscapedPath())
+	if !ok {
+		return "", false
+	}
+	if u.RawQuery != "" {
+		public += "?" + u.RawQuery
+	}
+	if u.Fragment != "" {
+		public += "#" + u.EscapedFragment()
+	}
+	return public, true
+}
+
+// rewriteLocationHeaders maps the Location and Content-Location headers of an upstream response to the public
+// URL space, so that redirects do not leak the target host and stay within the location.
+//
+// Parameters:
+// - header: The headers of the upstream response.
+// - mapping: The mapping of the upstream URLs to the public ones.
+func rewriteLocationHeaders(header http.Header, mapping urlMapping) {
+	for _, name := range []string{"Location", "Content-Location"} {
+		if value := header.Get(name); value != "" {
+			if public, ok := mapping.url(value); ok {
+				header.Set(name, public)
+			}
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"dito/config"
+	"dito/session"
+	"net/http"
+)
+
+// storeSessionHeaders applies the session headers of an upstream response to the session of the request, so that
+// a backend keeping no state can log a client in or out: the destroy header removes every value of the session,
+// then each response header sets its session key, or removes it when empty. The headers are always removed from
+// the response, so that they never reach the client.
+//
+// Parameters:
+// - r: The HTTP request, carrying the session in its context.
+// - header: The headers of the upstream response.
+// - sessionConfig: The session configuration of the location.
+func storeSessionHeaders(r *http.Request, header http.Header, sessionConfig config.Session) {
+	current, ok := session.FromContext(r.Context())
+
+	if sessionConfig.DestroyHeader != "" {
+		if _, destroy := header[http.CanonicalHeaderKey(sessionConfig.DestroyHeader)]; destroy && ok {
+			current.Destroy()
+		}
+		header.Del(sessionConfig.DestroyHeader)
+	}
+	for key, name := range sessionConfig.ResponseHeaders {
+		values, set := header[http.CanonicalHeaderKey(name)]
+		header.Del(name)
+		if !set || !ok {
+			continue
+		}
+		if len(values) == 0 || values[0] == "" {
+			current.Delete(key)
+		} else {
+			current.Set(key, values[0])
+		}
+	}
+}
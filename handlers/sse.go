@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// heartbeat is the SSE comment line sent while the upstream is silent; clients ignore comment lines.
+var heartbeat = []byte(": keepalive\n")
+
+// injectHeartbeats makes an event stream response carry a heartbeat whenever the upstream stays silent for
+// the interval, so that idle streams are not dropped by NATs and proxies along the way. Other responses,
+// and encoded streams that cannot be modified, are left untouched.
+//
+// Parameters:
+// - resp: The upstream response.
+// - interval: The maximum silence before a heartbeat is sent.
+func injectHeartbeats(resp *http.Response, interval time.Duration) {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/event-stream" || resp.Body == nil || resp.Body == http.NoBody {
+		return
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return
+	}
+
+	resp.Body = newHeartbeatReader(resp.Body, interval)
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+}
+
+// chunk is a piece of the upstream body, or the error that ended it.
+type chunk struct {
+	data []byte
+	err  error
+}
+
+// heartbeatReader reads an event stream, producing a heartbeat whenever the upstream is silent for the
+// interval. Heartbeats are only inserted at line starts, so that they never split a field of an event.
+type heartbeatReader struct {
+	body        io.ReadCloser
+	interval    time.Duration
+	chunks      chan chunk    // chunks receives the body read by the background reader.
+	done        chan struct{} // done is closed on Close to stop the background reader.
+	closeOnce   sync.Once
+	pending     []byte // pending is the part of the last chunk not returned yet.
+	err         error  // err is the error that ended the body.
+	atLineStart bool   // atLineStart reports whether the output so far ends with a complete line.
+}
+
+// newHeartbeatReader starts reading the body in the background.
+func newHeartbeatReader(body io.ReadCloser, interval time.Duration) *heartbeatReader {
+	r := &heartbeatReader{
+		body:        body,
+		interval:    interval,
+		chunks:      make(chan chunk),
+		done:        make(chan struct{}),
+		atLineStart: true,
+	}
+	go r.readBody()
+	return r
+}
+
+// readBody forwards the body to the chunks channel, one read at a time, until it ends or the reader is closed.
+func (r *heartbeatReader) readBody() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.body.Read(buf)
+		c := chunk{data: append([]byte(nil), buf[:n]...), err: err}
+		select {
+		case r.chunks <- c:
+		case <-r.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read returns the next part of the body, or a heartbeat if the upstream stays silent for the interval.
+func (r *heartbeatReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(r.pending) == 0 && r.err == nil {
+		timer := time.NewTimer(r.interval)
+		defer timer.Stop()
+		for len(r.pending) == 0 && r.err == nil {
+			select {
+			case c := <-r.chunks:
+				r.pending, r.err = c.data, c.err
+			case <-timer.C:
+				if r.atLineStart {
+					r.pending = heartbeat
+				} else {
+					timer.Reset(r.interval)
+				}
+			}
+		}
+	}
+
+	if len(r.pending) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	r.atLineStart = p[n-1] == '\n'
+	return n, nil
+}
+
+// Close stops the background reader and closes the upstream body.
+func (r *heartbeatReader) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	return r.body.Close()
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"dito/config"
+	"fmt"
+	"net/http"
+)
+
+// rewriteStatus applies the first status rewrite matching the status code of an upstream response. When the
+// new status does not allow a body (204 and 304), the upstream body is discarded.
+//
+// Parameters:
+// - resp: The upstream response.
+// - rewrites: The status rewrites of the location.
+func rewriteStatus(resp *http.Response, rewrites []config.StatusRewrite) {
+	for _, rewrite := range rewrites {
+		if rewrite.From != resp.StatusCode {
+			continue
+		}
+		resp.StatusCode = rewrite.To
+		resp.Status = fmt.Sprintf("%d %s", rewrite.To, http.StatusText(rewrite.To))
+		if rewrite.To == http.StatusNoContent || rewrite.To == http.StatusNotModified {
+			if resp.Body != nil {
+				resp.Body.Close()
+			}
+			resp.Body = http.NoBody
+			resp.ContentLength = 0
+			resp.Header.Del("Content-Length")
+			resp.Header.Del("Content-Type")
+		}
+		return
+	}
+}
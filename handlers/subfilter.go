@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bytes"
+	"dito/config"
+	"dito/transform"
+	"dito/writer"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// defaultSubFilterTypes are the media types rewritten by default, on top of the JSON and XML ones.
+var defaultSubFilterTypes = []string{"text/html", "text/css", "text/javascript", "application/javascript"}
+
+// defaultSubFilterMaxSize is the default largest body rewritten by the sub filter.
+const defaultSubFilterMaxSize = 10 << 20
+
+// subFilterChunkSize is the size of the reads from the upstream body.
+const subFilterChunkSize = 32 << 10
+
+// applySubFilter rewrites the upstream URLs in a textual response body, as the body streams to the client.
+// Responses of other types, larger than the size limit, or with a coding transform.Apply cannot decompress
+// are left untouched.
+//
+// Parameters:
+// - resp: The upstream response.
+// - subFilter: The sub filter configuration of the location.
+// - mapping: The mapping of the upstream URLs to the public ones.
+//
+// Returns:
+// - error: An error if the body cannot be wrapped.
+func applySubFilter(resp *http.Response, subFilter config.SubFilter, mapping urlMapping) error {
+	if !subFilterType(subFilter.ContentTypes, resp.Header.Get("Content-Type")) {
+		return nil
+	}
+	maxSize := subFilter.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultSubFilterMaxSize
+	}
+	if resp.ContentLength > maxSize {
+		return nil
+	}
+
+	replacements := subFilterReplacements(subFilter, mapping)
+	return transform.Apply(resp, []transform.BodyTransform{transform.Func(func(_ *http.Response, body io.Reader) (io.Reader, error) {
+		return newReplaceReader(body, replacements), nil
+	})})
+}
+
+// subFilterType reports whether a response of the given Content-Type is rewritten: one of the configured types,
+// or by default a textual web type, a JSON type, or an XML type such as application/soap+xml.
+func subFilterType(contentTypes []string, contentType string) bool {
+	mediaType := writer.MediaType(contentType)
+	if len(contentTypes) == 0 {
+		return slices.Contains(defaultSubFilterTypes, mediaType) || writer.IsJSON(contentType) || writer.IsXML(contentType)
+	}
+	return slices.ContainsFunc(contentTypes, func(t string) bool { return strings.EqualFold(t, mediaType) })
+}
+
+// subFilterReplacements returns the replacements of a sub filter: the target URL by the public URL, the
+// configured ones, and their JSON-escaped forms ("\/" for "/").
+func subFilterReplacements(subFilter config.SubFilter, mapping urlMapping) []config.Replacement {
+	publicURL := subFilter.PublicURL
+	if publicURL == "" {
+		publicURL = mapping.publicPath
+	}
+	replacements := append([]config.Replacement{{From: mapping.upstreamBase, To: strings.TrimSuffix(publicURL, "/")}}, subFilter.Replacements...)
+	for _, replacement := range replacements {
+		if strings.Contains(replacement.From, "/") {
+			replacements = append(replacements, config.Replacement{
+				From: strings.ReplaceAll(replacement.From, "/", `\/`),
+				To:   strings.ReplaceAll(replacement.To, "/", `\/`),
+			})
+		}
+	}
+	return replacements
+}
+
+// replaceReader replaces literal texts in a stream. It holds back the bytes that may start a match until
+// the next read, so that matches spanning reads are found while the memory used stays bounded.
+type replaceReader struct {
+	src          io.Reader
+	replacements []config.Replacement
+	keep         int    // keep is the number of bytes held back: the longest text to replace, minus one.
+	chunk        []byte // chunk is the buffer of the reads from the source.
+	in           []byte // in holds the bytes read but not yet processed.
+	out          []byte // out holds the processed bytes not yet returned.
+	err          error  // err is the error that ended the source.
+}
+
+// newReplaceReader creates a reader replacing the texts of the replacements in src.
+//
+// Parameters:
+// - src: The source stream.
+// - replacements: The replacements, the earliest match winning.
+//
+// Returns:
+// - io.Reader: The reader of the rewritten stream.
+func newReplaceReader(src io.Reader, replacements []config.Replacement) io.Reader {
+	keep := 0
+	for _, replacement := range replacements {
+		keep = max(keep, len(replacement.From)-1)
+	}
+	return &replaceReader{src: src, replacements: replacements, keep: keep}
+}
+
+// Read returns the rewritten stream.
+func (rr *replaceReader) Read(p []byte) (int, error) {
+	for len(rr.out) == 0 {
+		if rr.err != nil {
+			return 0, rr.err
+		}
+		if rr.chunk == nil {
+			rr.chunk = make([]byte, subFilterChunkSize)
+		}
+		n, err := rr.src.Read(rr.chunk)
+		rr.in = append(rr.in, rr.chunk[:n]...)
+		rr.err = err
+		rr.process(err != nil)
+	}
+	n := copy(p, rr.out)
+	rr.out = rr.out[n:]
+	return n, nil
+}
+
+// process moves the pending input to the output, replacing the matches, and holds back the bytes that may
+// start a match unless the input is final. A match is only replaced once every longer text that could start
+// at the same place or before it has been read, so the earliest and longest match wins.
+func (rr *replaceReader) process(final bool) {
+	for {
+		index, match := -1, -1
+		for i, replacement := range rr.replacements {
+			j := bytes.Index(rr.in, []byte(replacement.From))
+			if j >= 0 && (index < 0 || j < index || (j == index && len(replacement.From) > len(rr.replacements[match].From))) {
+				index, match = j, i
+			}
+		}
+		if index < 0 || (!final && index >= len(rr.in)-rr.keep) {
+			break
+		}
+		rr.out = append(rr.out, rr.in[:index]...)
+		rr.out = append(rr.out, rr.replacements[match].To...)
+		rr.in = rr.in[index+len(rr.replacements[match].From):]
+	}
+
+	safe := len(rr.in) - rr.keep
+	if final {
+		safe = len(rr.in)
+	}
+	if safe > 0 {
+		rr.out = append(rr.out, rr.in[:safe]...)
+		rr.in = append(rr.in[:0:0], rr.in[safe:]...)
+	}
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"dito/config"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReplaceReader verifies that matches spanning reads are replaced.
+func TestReplaceReader(t *testing.T) {
+	replacements := []config.Replacement{{From: "http://backend:8080", To: "/app"}, {From: "backend", To: "proxy"}}
+	src := iotest.OneByteReader(strings.NewReader("go to http://backend:8080/x or backend, not http://backend:80"))
+
+	rewritten, err := io.ReadAll(newReplaceReader(src, replacements))
+	assert.NoError(t, err)
+	assert.Equal(t, "go to /app/x or proxy, not http://proxy:80", string(rewritten))
+}
+
+// TestSubFilterType verifies that XML types such as SOAP envelopes are rewritten by default, and that configured
+// types replace the defaults.
+func TestSubFilterType(t *testing.T) {
+	for _, contentType := range []string{"text/html; charset=utf-8", "application/json", "application/problem+json", "text/xml", "application/soap+xml; charset=utf-8"} {
+		assert.True(t, subFilterType(nil, contentType), contentType)
+	}
+	assert.False(t, subFilterType(nil, "image/png"))
+	assert.True(t, subFilterType([]string{"Text/Plain"}, "text/plain; charset=utf-8"))
+	assert.False(t, subFilterType([]string{"text/plain"}, "text/xml"))
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"dito/metrics"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// uploadReader streams a request body to the upstream, reporting its progress in the upload metrics as the
+// bytes are read, so that large uploads are visible while they are in flight.
+type uploadReader struct {
+	io.ReadCloser
+	location string
+	size     atomic.Int64
+	once     sync.Once
+}
+
+// trackUpload replaces the body of a request by one reporting the upload metrics of its location. Requests
+// without a body are left untouched.
+//
+// Parameters:
+// - r: The HTTP request.
+// - location: The label of the location (its name or path), used to label the metrics.
+//
+// Returns:
+// - *uploadReader: The tracked body, to be finished once the request is done; nil without a body.
+func trackUpload(r *http.Request, location string) *uploadReader {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return nil
+	}
+	upload := &uploadReader{ReadCloser: r.Body, location: location}
+	metrics.UpdateUploads(location, true)
+	r.Body = upload
+	return upload
+}
+
+// Read reads the body and records the bytes read.
+func (u *uploadReader) Read(p []byte) (int, error) {
+	n, err := u.ReadCloser.Read(p)
+	if n > 0 {
+		u.size.Add(int64(n))
+		metrics.RecordUploadBytes(u.location, n)
+	}
+	if err == io.EOF {
+		u.finish()
+	}
+	return n, err
+}
+
+// Close closes the body and ends the upload.
+func (u *uploadReader) Close() error {
+	u.finish()
+	return u.ReadCloser.Close()
+}
+
+// finish ends the upload, recording its size. It may be called several times.
+func (u *uploadReader) finish() {
+	u.once.Do(func() {
+		metrics.UpdateUploads(u.location, false)
+		metrics.RecordUploadSize(u.location, u.size.Load())
+	})
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTrackUpload verifies that a tracked body streams unchanged while its size is counted, and that requests
+// without a body are not tracked.
+func TestTrackUpload(t *testing.T) {
+	r := httptest.NewRequest("POST", "/upload", strings.NewReader(strings.Repeat("x", 100000)))
+	upload := trackUpload(r, "/upload")
+	if !assert.NotNil(t, upload) {
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	assert.NoError(t, err)
+	assert.Len(t, body, 100000)
+	assert.Equal(t, int64(100000), upload.size.Load())
+	assert.NoError(t, r.Body.Close())
+	upload.finish()
+
+	assert.Nil(t, trackUpload(httptest.NewRequest("GET", "/upload", nil), "/upload"))
+	assert.Nil(t, trackUpload(&http.Request{Body: http.NoBody}, "/upload"))
+}
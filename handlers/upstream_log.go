@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"dito/app"
+	"dito/logging"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// upstreamRequestLogger returns the hook logging the requests sent to the upstream of a location, as they leave
+// the proxy: with their final URL and headers, and the beginning of their body. Bodies are logged once they were
+// sent, so that they are not read ahead of the upstream.
+//
+// Parameters:
+// - dito: The Dito application instance containing the configuration and logger.
+// - location: The label of the location (its name or path).
+//
+// Returns:
+// - func(*http.Request): The hook, nil if the upstream logging is disabled or the debug level is not logged.
+func upstreamRequestLogger(dito *app.Dito, location string) func(*http.Request) {
+	upstreamLogging := dito.Config.Logging.Upstream
+	if !upstreamLogging.Enabled || !dito.Logger.Enabled(context.Background(), slog.LevelDebug) {
+		return nil
+	}
+	masking := dito.Config.Logging.Masking
+
+	return func(req *http.Request) {
+		log := func(body []byte, truncated bool) {
+			dito.Logger.Debug(fmt.Sprintf("Upstream request of %s: %s %s", location, req.Method, req.URL.String()),
+				"host", req.Host,
+				"headers", logging.MaskHeaders(req.Header, masking),
+				"body", string(logging.MaskBody(body, req.Header.Get("Content-Type"), masking)),
+				"body_truncated", truncated,
+			)
+		}
+		if req.Body == nil || req.Body == http.NoBody {
+			log(nil, false)
+			return
+		}
+		req.Body = &sampledBody{ReadCloser: req.Body, maxSize: upstreamLogging.MaxBodySize, done: log}
+	}
+}
+
+// sampledBody is a request body keeping its first bytes, handed to a function once the body is closed.
+type sampledBody struct {
+	io.ReadCloser
+	maxSize   int
+	sample    []byte
+	truncated bool // truncated is true once bytes beyond the maximum size were read.
+	done      func(sample []byte, truncated bool)
+	once      sync.Once
+}
+
+// Read reads the body and keeps its first bytes.
+func (b *sampledBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	kept := min(n, b.maxSize-len(b.sample))
+	b.sample = append(b.sample, p[:kept]...)
+	if kept < n {
+		b.truncated = true
+	}
+	return n, err
+}
+
+// Close closes the body and hands its sample over.
+func (b *sampledBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.sample, b.truncated) })
+	return err
+}
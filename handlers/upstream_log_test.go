@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"bytes"
+	"dito/app"
+	"dito/config"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestUpstreamRequestLogger verifies that the upstream requests are logged with their final URL, their masked
+// headers and the beginning of their body once it was sent, and only at debug level.
+func TestUpstreamRequestLogger(t *testing.T) {
+	var logs bytes.Buffer
+	dito := &app.Dito{
+		Config: &config.ProxyConfig{Logging: config.Logging{
+			Masking:  config.LogMasking{Headers: []string{"Authorization"}},
+			Upstream: config.UpstreamLogging{Enabled: true, MaxBodySize: 5},
+		}},
+		Logger: slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+	logRequest := upstreamRequestLogger(dito, "api")
+	if !assert.NotNil(t, logRequest) {
+		return
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "http://backend:8080/v1/users?page=2", strings.NewReader("hello world"))
+	req.Header.Set("Authorization", "Bearer secret")
+	logRequest(req)
+	assert.Empty(t, logs.String(), "the request must be logged once its body was sent")
+
+	body, err := io.ReadAll(req.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	req.Body.Close()
+	assert.Contains(t, logs.String(), "Upstream request of api: POST http://backend:8080/v1/users?page=2")
+	assert.Contains(t, logs.String(), "body=hello body_truncated=true")
+	assert.Contains(t, logs.String(), "***")
+	assert.NotContains(t, logs.String(), "secret")
+
+	logs.Reset()
+	req, _ = http.NewRequest(http.MethodGet, "http://backend:8080/v1/users", nil)
+	logRequest(req)
+	assert.Contains(t, logs.String(), "Upstream request of api: GET http://backend:8080/v1/users")
+
+	dito.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	assert.Nil(t, upstreamRequestLogger(dito, "api"))
+}
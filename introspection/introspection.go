@@ -0,0 +1,207 @@
+package introspection
+
+import (
+	"context"
+	"crypto/sha256"
+	"dito/config"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// keyPrefix prefixes the Redis keys caching the introspection results.
+const keyPrefix = "introspection:"
+
+// maxResponseSize is the largest response read from the introspection endpoint.
+const maxResponseSize = 1 << 20
+
+// Result is the response of the introspection endpoint for a token: the active member and, for active tokens,
+// their claims (scope, sub, exp, ...).
+type Result map[string]any
+
+// Active reports whether the token is active.
+func (r Result) Active() bool {
+	active, _ := r["active"].(bool)
+	return active
+}
+
+// Scopes returns the scopes granted by the token.
+func (r Result) Scopes() []string {
+	scope, _ := r["scope"].(string)
+	return strings.Fields(scope)
+}
+
+// Expiry returns the expiry of the token, if the endpoint reported one.
+//
+// Returns:
+// - time.Time: The expiry.
+// - bool: False if the result has no exp claim.
+func (r Result) Expiry() (time.Time, bool) {
+	exp, ok := r["exp"].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(exp), 0), true
+}
+
+// Claim returns a claim of the token formatted as a header value: strings as they are, numbers and booleans
+// in their JSON form, lists of strings joined by commas, and other values as JSON.
+//
+// Parameters:
+// - name: The name of the claim.
+//
+// Returns:
+// - string: The value.
+// - bool: False if the result has no such claim.
+func (r Result) Claim(name string) (string, bool) {
+	value, ok := r[name]
+	if !ok || value == nil {
+		return "", false
+	}
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				encoded, _ := json.Marshal(v)
+				return string(encoded), true
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), true
+	}
+	encoded, err := json.Marshal(value)
+	return string(encoded), err == nil
+}
+
+// Introspect asks the introspection endpoint whether a token is active.
+//
+// Parameters:
+// - ctx: The context of the call.
+// - client: The HTTP client calling the endpoint.
+// - introspectionConfig: The introspection configuration.
+// - token: The bearer token.
+//
+// Returns:
+// - Result: The result of the endpoint.
+// - error: An error if the endpoint cannot be reached or does not answer a valid result.
+func Introspect(ctx context.Context, client *http.Client, introspectionConfig config.Introspection, token string) (Result, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, introspectionConfig.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if introspectionConfig.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(introspectionConfig.ClientID), url.QueryEscape(introspectionConfig.ClientSecret))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("introspection request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint answered %d", resp.StatusCode)
+	}
+	var result Result
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid introspection response: %v", err)
+	}
+	if _, ok := result["active"].(bool); !ok {
+		return nil, fmt.Errorf("invalid introspection response: missing active member")
+	}
+	return result, nil
+}
+
+// CacheTTL returns how long a result is cached: the maximum TTL, shortened to the remaining lifetime of an
+// active token.
+//
+// Parameters:
+// - result: The introspection result.
+// - maxTTL: The maximum TTL.
+// - now: The current time.
+//
+// Returns:
+// - time.Duration: The TTL; 0 or less if the result must not be cached.
+func CacheTTL(result Result, maxTTL time.Duration, now time.Time) time.Duration {
+	if expiry, ok := result.Expiry(); ok && result.Active() {
+		return min(maxTTL, expiry.Sub(now))
+	}
+	return maxTTL
+}
+
+// cacheKey returns the Redis key caching the result of a token. The results are scoped to the endpoint and the
+// client ID that produced them, so that a token accepted by one endpoint is never accepted from the cache on a
+// location checking its tokens against another one. The token is hashed, so that it is not stored.
+func cacheKey(introspectionConfig config.Introspection, token string) string {
+	scope := sha256.Sum256([]byte(introspectionConfig.Endpoint + "|" + introspectionConfig.ClientID))
+	sum := sha256.Sum256([]byte(token))
+	return keyPrefix + hex.EncodeToString(scope[:]) + ":" + hex.EncodeToString(sum[:])
+}
+
+// Load returns the cached result of a token.
+//
+// Parameters:
+// - ctx: The context of the Redis call.
+// - client: The Redis client.
+// - introspectionConfig: The introspection configuration the result was obtained with.
+// - token: The bearer token.
+//
+// Returns:
+// - Result: The cached result.
+// - bool: False if no result is cached.
+// - error: An error if the cache cannot be read or decoded.
+func Load(ctx context.Context, client *redis.Client, introspectionConfig config.Introspection, token string) (Result, bool, error) {
+	data, err := client.Get(ctx, cacheKey(introspectionConfig, token)).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read the cached introspection result: %v", err)
+	}
+	var result Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, false, fmt.Errorf("failed to decode the cached introspection result: %v", err)
+	}
+	return result, true, nil
+}
+
+// Store caches the result of a token.
+//
+// Parameters:
+// - ctx: The context of the Redis call.
+// - client: The Redis client.
+// - introspectionConfig: The introspection configuration the result was obtained with.
+// - token: The bearer token.
+// - result: The introspection result.
+// - ttl: How long the result is cached.
+//
+// Returns:
+// - error: An error if the result cannot be cached.
+func Store(ctx context.Context, client *redis.Client, introspectionConfig config.Introspection, token string, result Result, ttl time.Duration) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	if err := client.Set(ctx, cacheKey(introspectionConfig, token), data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to cache the introspection result: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package introspection
+
+import (
+	"context"
+	"dito/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIntrospect verifies that the token is posted to the endpoint with the client credentials, and that invalid
+// answers are reported as errors.
+func TestIntrospect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		if clientID != "dito" || clientSecret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.PostFormValue("token") {
+		case "good":
+			w.Write([]byte(`{"active": true, "scope": "read write", "sub": "alice"}`))
+		case "broken":
+			w.Write([]byte(`{"sub": "alice"}`))
+		default:
+			w.Write([]byte(`{"active": false}`))
+		}
+	}))
+	defer server.Close()
+	introspectionConfig := config.Introspection{Endpoint: server.URL, ClientID: "dito", ClientSecret: "s3cret"}
+
+	result, err := Introspect(context.Background(), server.Client(), introspectionConfig, "good")
+	if assert.NoError(t, err) {
+		assert.True(t, result.Active())
+		assert.Equal(t, []string{"read", "write"}, result.Scopes())
+	}
+
+	result, err = Introspect(context.Background(), server.Client(), introspectionConfig, "revoked")
+	if assert.NoError(t, err) {
+		assert.False(t, result.Active())
+	}
+
+	_, err = Introspect(context.Background(), server.Client(), introspectionConfig, "broken")
+	assert.ErrorContains(t, err, "missing active member")
+
+	introspectionConfig.ClientSecret = "wrong"
+	_, err = Introspect(context.Background(), server.Client(), introspectionConfig, "good")
+	assert.ErrorContains(t, err, "answered 401")
+}
+
+// TestResultClaim verifies how the claims are formatted as header values.
+func TestResultClaim(t *testing.T) {
+	result := Result{"sub": "alice", "exp": float64(1700000000), "admin": true, "groups": []any{"a", "b"}, "address": map[string]any{"city": "Rome"}}
+
+	for claim, expected := range map[string]string{"sub": "alice", "exp": "1700000000", "admin": "true", "groups": "a,b", "address": `{"city":"Rome"}`} {
+		value, ok := result.Claim(claim)
+		assert.True(t, ok, claim)
+		assert.Equal(t, expected, value, claim)
+	}
+	_, ok := result.Claim("missing")
+	assert.False(t, ok)
+}
+
+// TestCacheTTL verifies that active tokens are not cached beyond their expiry.
+func TestCacheTTL(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	assert.Equal(t, time.Minute, CacheTTL(Result{"active": true}, time.Minute, now))
+	assert.Equal(t, 10*time.Second, CacheTTL(Result{"active": true, "exp": float64(1700000010)}, time.Minute, now))
+	assert.LessOrEqual(t, CacheTTL(Result{"active": true, "exp": float64(1699999990)}, time.Minute, now), time.Duration(0))
+	assert.Equal(t, time.Minute, CacheTTL(Result{"active": false}, time.Minute, now))
+}
@@ -0,0 +1,110 @@
+package listener
+
+import (
+	"bytes"
+	"crypto/tls"
+	"dito/metrics"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ConnTracker follows the client connections of an HTTP server through http.Server.ConnState, and exports
+// their number, their states, and their protocols as metrics.
+type ConnTracker struct {
+	mu     sync.Mutex
+	states map[net.Conn]http.ConnState
+}
+
+// NewConnTracker creates a connection tracker. Its ConnState method must be set as http.Server.ConnState.
+//
+// Returns:
+// - *ConnTracker: The tracker.
+func NewConnTracker() *ConnTracker {
+	return &ConnTracker{states: make(map[net.Conn]http.ConnState)}
+}
+
+// ConnState records a connection changing state.
+//
+// Parameters:
+// - conn: The client connection.
+// - state: The new state of the connection.
+func (t *ConnTracker) ConnState(conn net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	previous, known := t.states[conn]
+	if state == http.StateHijacked || state == http.StateClosed {
+		delete(t.states, conn)
+	} else {
+		t.states[conn] = state
+	}
+	t.mu.Unlock()
+
+	if known {
+		metrics.UpdateConnectionState(previous.String(), false)
+	}
+	switch state {
+	case http.StateNew:
+		metrics.UpdateActiveConnections(true)
+		metrics.UpdateConnectionState(state.String(), true)
+	case http.StateActive, http.StateIdle:
+		if previous == http.StateNew {
+			protocol, tlsVersion := connectionProtocol(conn)
+			metrics.RecordConnectionProtocol(protocol, tlsVersion)
+		}
+		metrics.UpdateConnectionState(state.String(), true)
+	case http.StateHijacked, http.StateClosed:
+		if known {
+			metrics.UpdateActiveConnections(false)
+		}
+		if state == http.StateHijacked {
+			metrics.RecordConnectionHijacked()
+		}
+	}
+}
+
+// connectionProtocol returns the application protocol and the TLS version of a connection whose first
+// request has been read, so that the TLS handshake is complete.
+func connectionProtocol(conn net.Conn) (string, string) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "http/1.1", "none"
+	}
+	state := tlsConn.ConnectionState()
+	protocol := state.NegotiatedProtocol
+	if protocol == "" {
+		protocol = "http/1.1"
+	}
+	return protocol, strings.TrimPrefix(tls.VersionName(state.Version), "TLS ")
+}
+
+// serverErrorWriter receives the error log of an HTTP server, counting the failed TLS handshakes.
+type serverErrorWriter struct {
+	logger *slog.Logger
+}
+
+// Write logs a line of the server error log.
+func (w *serverErrorWriter) Write(p []byte) (int, error) {
+	message := string(bytes.TrimSpace(p))
+	if strings.HasPrefix(message, "http: TLS handshake error") {
+		metrics.RecordTLSHandshakeError()
+		w.logger.Debug(message)
+	} else {
+		w.logger.Warn(message)
+	}
+	return len(p), nil
+}
+
+// NewServerErrorLog returns a logger to set as http.Server.ErrorLog. Failed TLS handshakes, which scanners
+// and clients with mismatched settings cause all the time, are counted and logged at debug level.
+//
+// Parameters:
+// - logger: The logger receiving the server errors.
+//
+// Returns:
+// - *log.Logger: The server error log.
+func NewServerErrorLog(logger *slog.Logger) *log.Logger {
+	return log.New(&serverErrorWriter{logger: logger}, "", 0)
+}
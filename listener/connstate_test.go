@@ -0,0 +1,55 @@
+package listener
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConnTracker verifies that connections are followed from their first request until they are closed.
+func TestConnTracker(t *testing.T) {
+	tracker := NewConnTracker()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ConnState = tracker.ConnState
+	server.StartTLS()
+	defer server.Close()
+
+	stateOf := func() []http.ConnState {
+		tracker.mu.Lock()
+		defer tracker.mu.Unlock()
+		var states []http.ConnState
+		for conn, state := range tracker.states {
+			protocol, version := connectionProtocol(conn)
+			assert.Equal(t, "http/1.1", protocol)
+			assert.Equal(t, "1.3", version)
+			states = append(states, state)
+		}
+		return states
+	}
+
+	client := server.Client()
+	resp, err := client.Get(server.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	assert.Eventually(t, func() bool {
+		states := stateOf()
+		return len(states) == 1 && states[0] == http.StateIdle
+	}, time.Second, 10*time.Millisecond)
+
+	client.CloseIdleConnections()
+	assert.Eventually(t, func() bool { return len(stateOf()) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+// TestConnectionProtocolPlain verifies the protocol reported for plain-text connections.
+func TestConnectionProtocolPlain(t *testing.T) {
+	protocol, version := connectionProtocol(nil)
+	assert.Equal(t, "http/1.1", protocol)
+	assert.Equal(t, "none", version)
+}
@@ -0,0 +1,161 @@
+package listener
+
+import (
+	"dito/metrics"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// ActionReject answers excess connections with a minimal 503 response before closing them.
+	ActionReject = "reject"
+	// ActionDrop closes excess connections without writing anything.
+	ActionDrop = "drop"
+
+	// rejectWriteTimeout bounds the time spent writing the 503 response to a rejected client.
+	rejectWriteTimeout = time.Second
+)
+
+// serviceUnavailableResponse is written to connections rejected with ActionReject.
+var serviceUnavailableResponse = []byte("HTTP/1.1 503 Service Unavailable\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Connection: close\r\n" +
+	"Content-Length: 20\r\n\r\n" +
+	"Service Unavailable\n")
+
+// LimitListener is a net.Listener that caps the number of concurrent connections,
+// both globally and per client IP. Connections above the limits are rejected or dropped
+// as soon as they are accepted, so they never reach the HTTP server.
+type LimitListener struct {
+	net.Listener
+	maxConns      int          // maxConns is the global cap on concurrent connections (0 means unlimited).
+	maxConnsPerIP int          // maxConnsPerIP is the cap on concurrent connections per client IP (0 means unlimited).
+	action        string       // action is ActionReject or ActionDrop.
+	logger        *slog.Logger // logger is used to report rejected connections.
+
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+// NewLimitListener wraps the given listener with global and per-IP connection limits.
+//
+// Parameters:
+// - inner: The listener accepting the raw connections.
+// - maxConns: The maximum number of concurrent connections (0 means unlimited).
+// - maxConnsPerIP: The maximum number of concurrent connections per client IP (0 means unlimited).
+// - action: What to do with excess connections (ActionReject or ActionDrop).
+// - logger: The logger used to report rejected connections.
+//
+// Returns:
+// - *LimitListener: The wrapping listener.
+func NewLimitListener(inner net.Listener, maxConns, maxConnsPerIP int, action string, logger *slog.Logger) *LimitListener {
+	if action != ActionDrop {
+		action = ActionReject
+	}
+	return &LimitListener{
+		Listener:      inner,
+		maxConns:      maxConns,
+		maxConnsPerIP: maxConnsPerIP,
+		action:        action,
+		logger:        logger,
+		perIP:         make(map[string]int),
+	}
+}
+
+// Accept waits for and returns the next connection that fits within the configured limits.
+// Connections exceeding a limit are rejected or dropped and never returned to the caller.
+func (l *LimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := remoteIP(conn)
+		if reason, ok := l.acquire(ip); !ok {
+			l.logger.Warn(fmt.Sprintf("Connection limit reached (%s), refusing connection from %s", reason, ip))
+			metrics.RecordConnectionRejected(reason)
+			go l.refuse(conn)
+			continue
+		}
+
+		return &limitedConn{Conn: conn, release: func() { l.release(ip) }}, nil
+	}
+}
+
+// ActiveConnections returns the number of connections currently held by the listener.
+func (l *LimitListener) ActiveConnections() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.total
+}
+
+// acquire reserves a connection slot for the given IP.
+// It returns the name of the exhausted limit and false when no slot is available.
+func (l *LimitListener) acquire(ip string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxConns > 0 && l.total >= l.maxConns {
+		return "global", false
+	}
+	if l.maxConnsPerIP > 0 && l.perIP[ip] >= l.maxConnsPerIP {
+		return "per_ip", false
+	}
+	l.total++
+	l.perIP[ip]++
+	return "", true
+}
+
+// release frees the connection slot held by the given IP.
+func (l *LimitListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if l.perIP[ip] <= 1 {
+		delete(l.perIP, ip)
+	} else {
+		l.perIP[ip]--
+	}
+}
+
+// refuse closes a connection that exceeded a limit, writing a 503 response first when configured to.
+func (l *LimitListener) refuse(conn net.Conn) {
+	if l.action == ActionReject {
+		_ = conn.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+		_, _ = conn.Write(serviceUnavailableResponse)
+	}
+	_ = conn.Close()
+}
+
+// limitedConn is a net.Conn that releases its slot in the LimitListener when closed.
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+// Close closes the connection and releases its slot exactly once.
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// remoteIP extracts the IP address of the remote end of the connection.
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
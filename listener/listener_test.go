@@ -0,0 +1,96 @@
+package listener
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestListener starts a LimitListener on a random local port.
+func newTestListener(t *testing.T, maxConns, maxConnsPerIP int, action string) *LimitListener {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ln := NewLimitListener(inner, maxConns, maxConnsPerIP, action, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+// acceptInBackground accepts connections and sends them on the returned channel.
+func acceptInBackground(ln net.Listener) <-chan net.Conn {
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	return accepted
+}
+
+// TestLimitListenerRejectsExcessConnections verifies that connections above the limit receive a 503.
+func TestLimitListenerRejectsExcessConnections(t *testing.T) {
+	ln := newTestListener(t, 1, 0, ActionReject)
+	accepted := acceptInBackground(ln)
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	assert.NoError(t, err)
+	defer first.Close()
+	serverSide := <-accepted
+
+	second, err := net.Dial("tcp", ln.Addr().String())
+	assert.NoError(t, err)
+	defer second.Close()
+
+	_ = second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	statusLine, err := bufio.NewReader(second).ReadString('\n')
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(statusLine, "HTTP/1.1 503"))
+	assert.Equal(t, 1, ln.ActiveConnections())
+
+	// Closing the accepted connection frees the slot for a new client.
+	serverSide.Close()
+	assert.Equal(t, 0, ln.ActiveConnections())
+
+	third, err := net.Dial("tcp", ln.Addr().String())
+	assert.NoError(t, err)
+	defer third.Close()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the connection to be accepted after a slot was released")
+	}
+}
+
+// TestLimitListenerPerIPDrop verifies that the per-IP limit drops connections without a response.
+func TestLimitListenerPerIPDrop(t *testing.T) {
+	ln := newTestListener(t, 0, 1, ActionDrop)
+	accepted := acceptInBackground(ln)
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	assert.NoError(t, err)
+	defer first.Close()
+	serverSide := <-accepted
+	defer serverSide.Close()
+
+	second, err := net.Dial("tcp", ln.Addr().String())
+	assert.NoError(t, err)
+	defer second.Close()
+
+	_ = second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := second.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.ErrorIs(t, err, io.EOF)
+}
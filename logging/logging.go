@@ -1,12 +1,15 @@
 package logging
 
 import (
+	"bytes"
 	"dito/writer"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fatih/color"
@@ -28,28 +31,72 @@ var (
 )
 
 // InitializeLogger initializes a new logger with the specified log level.
+// The level can be changed at runtime through the slog.LevelVar returned by Level.
 func InitializeLogger(level string) *slog.Logger {
 	levelVar := new(slog.LevelVar)
 
-	// Set the log level based on the provided string
-	var logLevel slog.Level
-	switch level {
+	// Set the log level based on the provided string, defaulting to info if unrecognized
+	logLevel, err := ParseLevel(level)
+	if err != nil {
+		logLevel = slog.LevelInfo
+	}
+
+	levelVar.Set(logLevel)
+
+	handler := tint.NewHandler(os.Stdout, &tint.Options{Level: levelVar})
+	return slog.New(&leveledHandler{Handler: handler, level: levelVar})
+}
+
+// ParseLevel parses a log level name (debug, info, warn, or error).
+//
+// Parameters:
+// - level: The level name, case-insensitive.
+//
+// Returns:
+// - slog.Level: The log level.
+// - error: An error if the name is not a known level.
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
 	case "debug":
-		logLevel = slog.LevelDebug
+		return slog.LevelDebug, nil
 	case "info":
-		logLevel = slog.LevelInfo
+		return slog.LevelInfo, nil
 	case "warn":
-		logLevel = slog.LevelWarn
+		return slog.LevelWarn, nil
 	case "error":
-		logLevel = slog.LevelError
-	default:
-		logLevel = slog.LevelInfo // Default to info if unrecognized
+		return slog.LevelError, nil
 	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", level)
+}
 
-	levelVar.Set(logLevel)
+// Level returns the variable holding the level of a logger created by InitializeLogger.
+//
+// Parameters:
+// - logger: The logger.
+//
+// Returns:
+// - *slog.LevelVar: The level of the logger, or nil if it was not created by InitializeLogger.
+func Level(logger *slog.Logger) *slog.LevelVar {
+	if handler, ok := logger.Handler().(*leveledHandler); ok {
+		return handler.level
+	}
+	return nil
+}
 
-	handler := tint.NewHandler(os.Stdout, &tint.Options{Level: levelVar})
-	return slog.New(handler)
+// leveledHandler keeps the level variable of a handler reachable from its logger.
+type leveledHandler struct {
+	slog.Handler
+	level *slog.LevelVar
+}
+
+// WithAttrs returns a handler with the given attributes, keeping the level variable.
+func (h *leveledHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &leveledHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+// WithGroup returns a handler with the given group, keeping the level variable.
+func (h *leveledHandler) WithGroup(name string) slog.Handler {
+	return &leveledHandler{Handler: h.Handler.WithGroup(name), level: h.level}
 }
 
 // GetLogger returns the global logger instance.
@@ -63,6 +110,15 @@ func GetLogger() *slog.Logger {
 
 // LogRequestVerbose logs detailed information about the HTTP request and response for debugging purposes.
 func LogRequestVerbose(req *http.Request, body []byte, headers http.Header, statusCode int, duration time.Duration) {
+	LogRequestVerboseStream(req, bytes.NewReader(body), headers, statusCode, duration)
+}
+
+// verboseOutputMutex keeps the verbose entries written by concurrent loggers from interleaving.
+var verboseOutputMutex sync.Mutex
+
+// LogRequestVerboseStream logs detailed information about the HTTP request and response, copying the
+// request body from a reader so that large bodies are streamed to the output instead of being loaded in memory.
+func LogRequestVerboseStream(req *http.Request, body io.Reader, headers http.Header, statusCode int, duration time.Duration) {
 	var sb strings.Builder
 
 	// Start building the log message
@@ -81,22 +137,31 @@ func LogRequestVerbose(req *http.Request, body []byte, headers http.Header, stat
 	}
 
 	sb.WriteString("\n")
-	sb.WriteString(fmt.Sprintf("%s\n\t%s\n\n", urlStyle("Request Body:"), string(body)))
+	sb.WriteString(fmt.Sprintf("%s\n\t", urlStyle("Request Body:")))
+
+	var tail strings.Builder
+	tail.WriteString("\n\n")
 
 	// Response details
-	sb.WriteString(detailStyle("----------- Response Details -----------"))
-	sb.WriteString("\n\n")
-	sb.WriteString(fmt.Sprintf("%s: %d\n\n", statusStyle("Status Code:"), statusCode))
-	sb.WriteString(fmt.Sprintf("%s: %.6f seconds\n\n", boldWhiteStyle("Response Time:"), duration.Seconds()))
+	tail.WriteString(detailStyle("----------- Response Details -----------"))
+	tail.WriteString("\n\n")
+	tail.WriteString(fmt.Sprintf("%s: %d\n\n", statusStyle("Status Code:"), statusCode))
+	tail.WriteString(fmt.Sprintf("%s: %.6f seconds\n\n", boldWhiteStyle("Response Time:"), duration.Seconds()))
 
-	sb.WriteString(detailStyle("---------------------------------------"))
+	tail.WriteString(detailStyle("---------------------------------------"))
+	tail.WriteString("\n")
 
 	// Print the final log message
-	fmt.Println(sb.String())
+	verboseOutputMutex.Lock()
+	defer verboseOutputMutex.Unlock()
+	io.WriteString(os.Stdout, sb.String())
+	io.Copy(os.Stdout, body)
+	io.WriteString(os.Stdout, tail.String())
 }
 
-// LogRequestCompact logs the HTTP request and response in a compact format.
-func LogRequestCompact(r *http.Request, body []byte, headers http.Header, statusCode int, duration time.Duration) {
+// LogRequestCompact logs the HTTP request and response in a compact format. The request information, when
+// given, is added as attributes so that log analytics do not need the verbose format.
+func LogRequestCompact(r *http.Request, body []byte, headers http.Header, statusCode int, duration time.Duration, info *RequestInfo) {
 	logger := GetLogger()
 	clientIP := r.RemoteAddr
 	method := r.Method
@@ -105,6 +170,35 @@ func LogRequestCompact(r *http.Request, body []byte, headers http.Header, status
 	userAgent := r.Header.Get("User-Agent")
 	referer := r.Header.Get("Referer")
 
+	var attrs []any
+	if info != nil {
+		cacheStatus := info.CacheStatus
+		if cacheStatus == "" {
+			cacheStatus = "-"
+		}
+		attrs = []any{
+			"request_id", info.RequestID,
+			"bytes_in", info.BytesIn.Load(),
+			"bytes_out", info.BytesOut,
+			"upstream_addr", info.UpstreamAddr,
+			"upstream_status", info.UpstreamStatus,
+			"upstream_connect_time", info.UpstreamConnectTime,
+			"upstream_header_time", info.UpstreamHeaderTime,
+			"upstream_response_time", info.UpstreamResponseTime,
+			"cache_status", cacheStatus,
+			"location", info.Location,
+		}
+		if info.GraphQLOperation != "" {
+			attrs = append(attrs, "graphql_operation", info.GraphQLOperation)
+		}
+		if info.Country != "" {
+			attrs = append(attrs, "country", info.Country)
+		}
+		if info.ASN != 0 {
+			attrs = append(attrs, "asn", info.ASN)
+		}
+	}
+
 	logger.Info(fmt.Sprintf("%s - \"%s %s %s\" %d \"%s\" \"%s\" %.6f seconds",
 		clientIP,
 		method,
@@ -114,7 +208,7 @@ func LogRequestCompact(r *http.Request, body []byte, headers http.Header, status
 		referer,
 		userAgent,
 		duration.Seconds(),
-	))
+	), attrs...)
 }
 
 // LogWebSocketMessage logs the details of a WebSocket message.
@@ -2,10 +2,13 @@ package logging
 
 import (
 	"bytes"
+	"dito/config"
 	"log/slog"
 	"os"
+	"path/filepath"
 
 	"github.com/lmittmann/tint"
+	"github.com/stretchr/testify/assert"
 
 	"net/http"
 	"testing"
@@ -37,7 +40,80 @@ func TestLogRequestCompact(t *testing.T) {
 	duration := 2 * time.Second
 
 	body := []byte("request body")
-	LogRequestCompact(req, body, headers, statusCode, duration)
+	LogRequestCompact(req, body, headers, statusCode, duration, nil)
+}
+
+// TestLogRequestCompactInfo verifies that the request information is logged as attributes.
+func TestLogRequestCompactInfo(t *testing.T) {
+	var output bytes.Buffer
+	previous := logger
+	logger = slog.New(slog.NewTextHandler(&output, nil))
+	defer func() { logger = previous }()
+
+	req, _ := http.NewRequest("POST", "http://example.com/api", nil)
+	info := &RequestInfo{
+		RequestID:      "abc",
+		Location:       "^/api",
+		UpstreamAddr:   "10.0.0.1:8080",
+		UpstreamStatus: 503,
+		BytesOut:       34,
+	}
+	info.BytesIn.Store(12)
+	LogRequestCompact(req, nil, req.Header, http.StatusServiceUnavailable, time.Second, info)
+
+	for _, attr := range []string{"request_id=abc", "bytes_in=12", "bytes_out=34", "upstream_addr=10.0.0.1:8080",
+		"upstream_status=503", "cache_status=-", "location=^/api"} {
+		assert.Contains(t, output.String(), attr)
+	}
+}
+
+// loadMasking loads the default masking configuration through the configuration loader.
+func loadMasking(t *testing.T) config.LogMasking {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte("port: \"8080\"\n"), 0o600))
+	proxyConfig, err := config.LoadConfiguration(file)
+	assert.NoError(t, err)
+	return proxyConfig.Logging.Masking
+}
+
+// TestMaskHeaders verifies that sensitive headers are masked in a copy of the headers.
+func TestMaskHeaders(t *testing.T) {
+	headers := http.Header{
+		"Authorization": {"Bearer secret"},
+		"X-Api-Key":     {"key"},
+		"Content-Type":  {"application/json"},
+	}
+	masked := MaskHeaders(headers, loadMasking(t))
+
+	assert.Equal(t, []string{MaskedValue}, masked["Authorization"])
+	assert.Equal(t, []string{MaskedValue}, masked["X-Api-Key"])
+	assert.Equal(t, []string{"application/json"}, masked["Content-Type"])
+	assert.Equal(t, []string{"Bearer secret"}, headers["Authorization"])
+}
+
+// TestMaskBody verifies that sensitive JSON fields and XML elements are masked, including in truncated bodies.
+func TestMaskBody(t *testing.T) {
+	masking := loadMasking(t)
+	tests := map[string]string{
+		`{"user":"bob","password":"s3cr\"et"}`:         `{"user":"bob","password":"***"}`,
+		`{"Token": 12345, "nested": {"token" : null}}`: `{"Token": "***", "nested": {"token" : "***"}}`,
+		`{"user":"bob","password":"trunc`:              `{"user":"bob","password":"***"`,
+		`user=bob&password=secret`:                     `user=bob&password=secret`,
+	}
+	for body, expected := range tests {
+		assert.Equal(t, expected, string(MaskBody([]byte(body), "application/json", masking)), body)
+	}
+	assert.Equal(t, `{"password":"x"}`, string(MaskBody([]byte(`{"password":"x"}`), "application/json", config.LogMasking{})))
+
+	xmlTests := map[string]string{
+		`<login><user>bob</user><password>secret</password></login>`: `<login><user>bob</user><password>***</password></login>`,
+		`<wsse:Password Type="PasswordText">secret</wsse:Password>`:  `<wsse:Password Type="PasswordText">***</wsse:Password>`,
+		`<Token>abc</Token><passwordHint>pet</passwordHint>`:         `<Token>***</Token><passwordHint>pet</passwordHint>`,
+		`<user>bob</user><password>trunc`:                            `<user>bob</user><password>***`,
+	}
+	for body, expected := range xmlTests {
+		assert.Equal(t, expected, string(MaskBody([]byte(body), "application/soap+xml; charset=utf-8", masking)), body)
+	}
 }
 
 // InitializeLogger initializes a new logger with the specified log level.
@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"dito/config"
+	"dito/writer"
+	"net/http"
+	"strings"
+)
+
+// MaskedValue replaces the sensitive values in the logs.
+const MaskedValue = "***"
+
+// MaskHeaders returns a copy of the headers in which the values of the masked headers are replaced.
+//
+// Parameters:
+// - headers: The headers to mask.
+// - masking: The masking configuration.
+//
+// Returns:
+// - http.Header: The masked headers; the original ones are returned when nothing needs masking.
+func MaskHeaders(headers http.Header, masking config.LogMasking) http.Header {
+	var masked http.Header
+	for _, name := range masking.Headers {
+		for key, values := range headers {
+			if !strings.EqualFold(key, name) {
+				continue
+			}
+			if masked == nil {
+				masked = headers.Clone()
+			}
+			replaced := make([]string, len(values))
+			for i := range replaced {
+				replaced[i] = MaskedValue
+			}
+			masked[key] = replaced
+		}
+	}
+	if masked == nil {
+		return headers
+	}
+	return masked
+}
+
+// MaskBody replaces the values of the masked fields in a request body: the text of the masked elements of XML
+// bodies, such as SOAP envelopes, and the values of the masked JSON fields of the other bodies. The body may be
+// truncated.
+//
+// Parameters:
+// - body: The body to mask.
+// - contentType: The Content-Type of the body.
+// - masking: The masking configuration.
+//
+// Returns:
+// - []byte: The masked body.
+func MaskBody(body []byte, contentType string, masking config.LogMasking) []byte {
+	if len(body) == 0 {
+		return body
+	}
+	if writer.IsXML(contentType) {
+		if masking.CompiledXMLFields == nil {
+			return body
+		}
+		return masking.CompiledXMLFields.ReplaceAll(body, []byte("${1}"+MaskedValue))
+	}
+	if masking.CompiledFields == nil {
+		return body
+	}
+	return masking.CompiledFields.ReplaceAll(body, []byte(`${1}"`+MaskedValue+`"`))
+}
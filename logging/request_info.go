@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// RequestInfo collects what the proxy learns while serving a request, for the access log. The logging
+// middleware attaches it to the request context; the handlers and middlewares serving the request fill it in.
+type RequestInfo struct {
+	RequestID            string        // RequestID is the ID of the request.
+	Location             string        // Location is the label (name or path) of the matched location.
+	UpstreamAddr         string        // UpstreamAddr is the address of the upstream that served the request.
+	UpstreamStatus       int           // UpstreamStatus is the status code returned by the upstream (0 if it was not reached).
+	UpstreamConnectTime  time.Duration // UpstreamConnectTime is the time spent connecting, TLS included (0 when reused).
+	UpstreamHeaderTime   time.Duration // UpstreamHeaderTime is the time until the first byte of the upstream response.
+	UpstreamResponseTime time.Duration // UpstreamResponseTime is the time until the upstream response was fully read.
+	CacheStatus          string        // CacheStatus is HIT or MISS when the response cache was consulted.
+	GraphQLOperation     string        // GraphQLOperation is the name of the GraphQL operation, in GraphQL mode.
+	Country              string        // Country is the ISO code of the country of the client, with GeoIP enabled.
+	ASN                  uint          // ASN is the autonomous system of the client, with GeoIP enabled.
+	BytesIn              atomic.Int64  // BytesIn is the number of request body bytes read, possibly by another goroutine than the handler.
+	BytesOut             int           // BytesOut is the number of response body bytes written.
+}
+
+// requestInfoKey is the request context key of the request information.
+type requestInfoKey struct{}
+
+// WithRequestInfo attaches a new RequestInfo to a request.
+//
+// Parameters:
+// - r: The HTTP request.
+//
+// Returns:
+// - *http.Request: The request carrying the information.
+// - *RequestInfo: The information to fill in.
+func WithRequestInfo(r *http.Request) (*http.Request, *RequestInfo) {
+	info := &RequestInfo{}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)), info
+}
+
+// RequestInfoFrom returns the RequestInfo attached to a request context.
+//
+// Parameters:
+// - ctx: The request context.
+//
+// Returns:
+// - *RequestInfo: The information, or nil if the request is not logged.
+func RequestInfoFrom(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info
+}
@@ -1,10 +1,13 @@
 package metrics
 
 import (
+	"dito/buildinfo"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"net/http"
 	"regexp"
+	"strconv"
 )
 
 // Define Prometheus metrics
@@ -37,16 +40,386 @@ var (
 	activeConnections = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "active_connections",
-			Help: "Number of active connections currently being handled by the proxy.",
+			Help: "Number of client connections currently open on the proxy, hijacked connections excluded.",
 		},
 	)
+
+	connectionStates = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "http_connections",
+			Help: "Number of client connections, partitioned by state (new, active, or idle).",
+		},
+		[]string{"state"},
+	)
+
+	connectionsHijacked = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "http_connections_hijacked_total",
+			Help: "Total number of client connections taken over by a handler, such as WebSocket upgrades.",
+		},
+	)
+
+	connectionProtocols = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_connections_protocol_total",
+			Help: "Total number of client connections, partitioned by application protocol and TLS version.",
+		},
+		[]string{"protocol", "tls_version"},
+	)
+
+	tlsHandshakeErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tls_handshake_errors_total",
+			Help: "Total number of client TLS handshakes that failed.",
+		},
+	)
+
+	securityBlocks = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "security_blocks_total",
+			Help: "Total number of requests blocked by security checks, partitioned by reason.",
+		},
+		[]string{"reason"},
+	)
+
+	connectionsRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "connections_rejected_total",
+			Help: "Total number of client connections refused because a connection limit was reached, partitioned by limit (global or per_ip).",
+		},
+		[]string{"limit"},
+	)
+
+	streamConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "stream_active_connections",
+			Help: "Number of active TCP connections or UDP sessions handled by stream proxies, partitioned by stream.",
+		},
+		[]string{"stream"},
+	)
+
+	dnsLookups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_cache_lookups_total",
+			Help: "Total number of upstream DNS lookups, partitioned by result (hit, negative_hit, miss, or error).",
+		},
+		[]string{"result"},
+	)
+
+	spoolDiskUsage = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "spool_disk_bytes",
+			Help: "Total size of the temporary files used to buffer bodies larger than the in-memory limit.",
+		},
+	)
+
+	cacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_requests_total",
+			Help: "Total number of cache lookups, partitioned by location and result (hit or miss).",
+		},
+		[]string{"location", "result"},
+	)
+
+	cacheSkipped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_skipped_total",
+			Help: "Total number of responses not cached, partitioned by location and reason.",
+		},
+		[]string{"location", "reason"},
+	)
+
+	cacheEvictions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_evictions_total",
+			Help: "Total number of cache entries evicted to respect the size limit of a location.",
+		},
+		[]string{"location"},
+	)
+
+	cacheSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cache_size_bytes",
+			Help: "Total size of the cached bodies of a location with a size limit.",
+		},
+		[]string{"location"},
+	)
+
+	upstreamResponseTime = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "upstream_response_time_seconds",
+			Help:    "Time until the upstream response was fully read in seconds, partitioned by location.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"location"},
+	)
+
+	uploadBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "upload_bytes_total",
+			Help: "Total number of request body bytes streamed to the upstream, partitioned by location.",
+		},
+		[]string{"location"},
+	)
+
+	uploadsActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "uploads_active",
+			Help: "Number of request bodies currently being streamed to the upstream, partitioned by location.",
+		},
+		[]string{"location"},
+	)
+
+	uploadSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "upload_size_bytes",
+			Help:    "Size of the request bodies streamed to the upstream in bytes, partitioned by location.",
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 11), // 1 KB to 1 GB.
+		},
+		[]string{"location"},
+	)
+
+	geoRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "geoip_requests_total",
+			Help: "Total number of requests resolved by GeoIP, partitioned by location and client country.",
+		},
+		[]string{"location", "country"},
+	)
+
+	honeypotRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "honeypot_requests_total",
+			Help: "Total number of requests to attack paths answered by the honeypot, partitioned by matched path pattern.",
+		},
+		[]string{"pattern"},
+	)
+
+	botBlocks = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "bot_blocks_total",
+			Help: "Total number of requests stopped by the bot filter, partitioned by location, reason, and action (block or tarpit).",
+		},
+		[]string{"location", "reason", "action"},
+	)
+
+	faultInjections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fault_injections_total",
+			Help: "Total number of faults injected into requests, partitioned by location and fault (delay, status, or abort).",
+		},
+		[]string{"location", "fault"},
+	)
+
+	tokenIntrospections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "token_introspections_total",
+			Help: "Total number of bearer tokens checked by the introspection middleware, partitioned by location, result (active, inactive, or error), and source (cache or endpoint).",
+		},
+		[]string{"location", "result", "source"},
+	)
+
+	hmacVerifications = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hmac_verifications_total",
+			Help: "Total number of request signatures checked by the hmac middleware, partitioned by location and result (valid, missing, invalid, expired, or replayed).",
+		},
+		[]string{"location", "result"},
+	)
+
+	sessionEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "session_events_total",
+			Help: "Total number of session events of the session middleware, partitioned by location and event (issued, saved, or destroyed).",
+		},
+		[]string{"location", "event"},
+	)
+
+	sloAlerts = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "slo_alert",
+			Help: "Whether the last evaluated window of a location missed an objective (1) or met it (0), partitioned by location and objective (latency or error_rate).",
+		},
+		[]string{"location", "objective"},
+	)
+
+	sloBreaches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "slo_breaches_total",
+			Help: "Total number of windows in which a location missed an objective, partitioned by location and objective (latency or error_rate).",
+		},
+		[]string{"location", "objective"},
+	)
+
+	recordedExchanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "recorded_exchanges_total",
+			Help: "Total number of request/response pairs captured by the record middleware, partitioned by location and result (ok or error).",
+		},
+		[]string{"location", "result"},
+	)
+
+	mirrorRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mirror_requests_total",
+			Help: "Total number of requests copied to the mirror of a location, partitioned by location and result (ok or error).",
+		},
+		[]string{"location", "result"},
+	)
+
+	mirrorComparisons = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mirror_comparisons_total",
+			Help: "Total number of mirrored responses compared with the primary ones, partitioned by location and result (match, status_mismatch, body_mismatch, or incomplete).",
+		},
+		[]string{"location", "result"},
+	)
+
+	websocketConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "websocket_active_connections",
+			Help: "Number of WebSocket sessions currently being proxied, partitioned by location.",
+		},
+		[]string{"location"},
+	)
+
+	websocketDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "websocket_connection_duration_seconds",
+			Help:    "Duration of proxied WebSocket sessions in seconds, partitioned by location.",
+			Buckets: []float64{1, 5, 15, 60, 300, 900, 1800, 3600, 14400},
+		},
+		[]string{"location"},
+	)
+
+	websocketMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "websocket_messages_total",
+			Help: "Total number of WebSocket data messages forwarded, partitioned by location and direction (client_to_upstream or upstream_to_client).",
+		},
+		[]string{"location", "direction"},
+	)
+
+	websocketBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "websocket_bytes_total",
+			Help: "Total size of the WebSocket data messages forwarded in bytes, partitioned by location and direction.",
+		},
+		[]string{"location", "direction"},
+	)
+
+	websocketCloses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "websocket_close_codes_total",
+			Help: "Total number of WebSocket close codes received or sent by hooks, partitioned by location, direction, and code.",
+		},
+		[]string{"location", "direction", "code"},
+	)
+
+	buildInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dito_build_info",
+			Help: "Always 1, labeled with the version, the commit, and the Go version of the running binary.",
+		},
+		[]string{"version", "commit", "go_version"},
+	)
+
+	logEntriesDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "log_entries_dropped_total",
+			Help: "Total number of request log entries discarded, partitioned by reason (queue_full or sampled).",
+		},
+		[]string{"reason"},
+	)
+
+	auditEventsDropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "audit_events_dropped_total",
+			Help: "Total number of audit events discarded because the audit queue was full.",
+		},
+	)
+
+	graphQLOperations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "graphql_operations_total",
+			Help: "Total number of GraphQL operations, partitioned by location, operation name, and type.",
+		},
+		[]string{"location", "operation", "type"},
+	)
+
+	graphQLRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "graphql_rejected_total",
+			Help: "Total number of GraphQL requests rejected, partitioned by location and reason.",
+		},
+		[]string{"location", "reason"},
+	)
+
+	streamBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stream_bytes_total",
+			Help: "Total number of bytes proxied by stream proxies, partitioned by stream and direction (inbound or outbound).",
+		},
+		[]string{"stream", "direction"},
+	)
 )
 
 func InitMetrics() {
+	// The default registry exports the process metrics and the basic Go metrics: the latter are replaced by
+	// a collector that also exports the garbage collector, memory, and scheduler metrics of runtime/metrics.
+	prometheus.Unregister(collectors.NewGoCollector())
+	prometheus.MustRegister(collectors.NewGoCollector(
+		collectors.WithGoCollectorRuntimeMetrics(collectors.MetricsGC, collectors.MetricsMemory, collectors.MetricsScheduler),
+	))
+
+	version, commit, goVersion := buildinfo.Info()
+	buildInfo.WithLabelValues(version, commit, goVersion).Set(1)
+	prometheus.MustRegister(buildInfo)
+
 	prometheus.MustRegister(httpRequestsTotal)
 	prometheus.MustRegister(httpRequestDuration)
 	prometheus.MustRegister(dataTransferred)
 	prometheus.MustRegister(activeConnections)
+	prometheus.MustRegister(connectionStates)
+	prometheus.MustRegister(connectionsHijacked)
+	prometheus.MustRegister(connectionProtocols)
+	prometheus.MustRegister(tlsHandshakeErrors)
+	prometheus.MustRegister(securityBlocks)
+	prometheus.MustRegister(connectionsRejected)
+	prometheus.MustRegister(streamConnections)
+	prometheus.MustRegister(streamBytes)
+	prometheus.MustRegister(transportStats)
+	prometheus.MustRegister(dnsLookups)
+	prometheus.MustRegister(spoolDiskUsage)
+	prometheus.MustRegister(logEntriesDropped)
+	prometheus.MustRegister(auditEventsDropped)
+	prometheus.MustRegister(graphQLOperations)
+	prometheus.MustRegister(graphQLRejected)
+	prometheus.MustRegister(cacheRequests)
+	prometheus.MustRegister(cacheSkipped)
+	prometheus.MustRegister(cacheEvictions)
+	prometheus.MustRegister(cacheSize)
+	prometheus.MustRegister(upstreamResponseTime)
+	prometheus.MustRegister(uploadBytes)
+	prometheus.MustRegister(uploadsActive)
+	prometheus.MustRegister(uploadSize)
+	prometheus.MustRegister(geoRequests)
+	prometheus.MustRegister(botBlocks)
+	prometheus.MustRegister(honeypotRequests)
+	prometheus.MustRegister(faultInjections)
+	prometheus.MustRegister(tokenIntrospections)
+	prometheus.MustRegister(hmacVerifications)
+	prometheus.MustRegister(sessionEvents)
+	prometheus.MustRegister(sloAlerts)
+	prometheus.MustRegister(sloBreaches)
+	prometheus.MustRegister(recordedExchanges)
+	prometheus.MustRegister(mirrorRequests)
+	prometheus.MustRegister(mirrorComparisons)
+	prometheus.MustRegister(websocketConnections)
+	prometheus.MustRegister(websocketDuration)
+	prometheus.MustRegister(websocketMessages)
+	prometheus.MustRegister(websocketBytes)
+	prometheus.MustRegister(websocketCloses)
 }
 
 // NormalizePath normalizes dynamic paths (e.g., "/users/123" -> "/users/:id")
@@ -57,13 +430,12 @@ func NormalizePath(path string) string {
 	return normalizedPath
 }
 
-// RecordRequest records metrics for each request
-func RecordRequest(method, path string, statusCode int, duration float64) {
-	normalizedPath := NormalizePath(path)
+// RecordRequest records metrics for each request, under a path label returned by PathLabel
+func RecordRequest(method, pathLabel string, statusCode int, duration float64) {
 	statusCodeStr := http.StatusText(statusCode)
 
-	httpRequestsTotal.WithLabelValues(method, normalizedPath, statusCodeStr).Inc()
-	httpRequestDuration.WithLabelValues(method, normalizedPath, statusCodeStr).Observe(duration)
+	httpRequestsTotal.WithLabelValues(method, pathLabel, statusCodeStr).Inc()
+	httpRequestDuration.WithLabelValues(method, pathLabel, statusCodeStr).Observe(duration)
 }
 
 // RecordDataTransferred records the number of bytes transferred, partitioned by direction (inbound or outbound)
@@ -80,6 +452,237 @@ func UpdateActiveConnections(increment bool) {
 	}
 }
 
+// UpdateConnectionState increments or decrements the number of client connections in the given state
+func UpdateConnectionState(state string, increment bool) {
+	if increment {
+		connectionStates.WithLabelValues(state).Inc()
+	} else {
+		connectionStates.WithLabelValues(state).Dec()
+	}
+}
+
+// RecordConnectionHijacked records a client connection taken over by a handler
+func RecordConnectionHijacked() {
+	connectionsHijacked.Inc()
+}
+
+// RecordConnectionProtocol records a client connection with its application protocol and TLS version
+func RecordConnectionProtocol(protocol, tlsVersion string) {
+	connectionProtocols.WithLabelValues(protocol, tlsVersion).Inc()
+}
+
+// RecordTLSHandshakeError records a failed client TLS handshake
+func RecordTLSHandshakeError() {
+	tlsHandshakeErrors.Inc()
+}
+
+// RecordSecurityBlock records a request blocked by a security check for the given reason
+func RecordSecurityBlock(reason string) {
+	securityBlocks.WithLabelValues(reason).Inc()
+}
+
+// RecordConnectionRejected records a client connection refused because the given limit was reached
+func RecordConnectionRejected(limit string) {
+	connectionsRejected.WithLabelValues(limit).Inc()
+}
+
+// UpdateStreamConnections increments or decrements the number of active connections of a stream proxy
+func UpdateStreamConnections(stream string, increment bool) {
+	if increment {
+		streamConnections.WithLabelValues(stream).Inc()
+	} else {
+		streamConnections.WithLabelValues(stream).Dec()
+	}
+}
+
+// RecordStreamBytes records the number of bytes proxied by a stream, partitioned by direction (inbound or outbound)
+func RecordStreamBytes(stream, direction string, numBytes int64) {
+	streamBytes.WithLabelValues(stream, direction).Add(float64(numBytes))
+}
+
+// RecordDNSLookup records an upstream DNS lookup with the given result (hit, negative_hit, miss, or error)
+func RecordDNSLookup(result string) {
+	dnsLookups.WithLabelValues(result).Inc()
+}
+
+// UpdateSpoolDiskUsage adjusts the total size of the temporary files used to buffer bodies by the given delta
+func UpdateSpoolDiskUsage(delta int64) {
+	spoolDiskUsage.Add(float64(delta))
+}
+
+// RecordLogEntryDropped records a request log entry discarded for the given reason (queue_full or sampled)
+func RecordLogEntryDropped(reason string) {
+	logEntriesDropped.WithLabelValues(reason).Inc()
+}
+
+// RecordAuditEventDropped records an audit event discarded because the audit queue was full
+func RecordAuditEventDropped() {
+	auditEventsDropped.Inc()
+}
+
+// RecordCacheLookup records a cache lookup of a location with the given result (hit or miss)
+func RecordCacheLookup(location, result string) {
+	cacheRequests.WithLabelValues(location, result).Inc()
+}
+
+// RecordCacheSkipped records a response of a location not cached for the given reason (e.g. too_large)
+func RecordCacheSkipped(location, reason string) {
+	cacheSkipped.WithLabelValues(location, reason).Inc()
+}
+
+// RecordCacheStore records the total size of the cache of a location after a store, and the entries it evicted
+func RecordCacheStore(location string, size int64, evictions int64) {
+	cacheSize.WithLabelValues(location).Set(float64(size))
+	cacheEvictions.WithLabelValues(location).Add(float64(evictions))
+}
+
+// anonymousOperation is the operation label of the anonymous GraphQL operations.
+const anonymousOperation = "anonymous"
+
+// maxGraphQLOperations is the maximum number of distinct GraphQL operation labels.
+const maxGraphQLOperations = 100
+
+// graphQLOperationNames holds the GraphQL operation labels reported so far, bounding their number.
+var graphQLOperationNames = &labelSet{seen: make(map[string]struct{})}
+
+// RecordGraphQLOperation records a GraphQL operation of a location. Anonymous operations are labeled "anonymous",
+// and the names beyond the first 100 are labeled "other", so that clients cannot grow the label cardinality.
+func RecordGraphQLOperation(location, name, operationType string) {
+	if name == "" {
+		name = anonymousOperation
+	}
+	graphQLOperations.WithLabelValues(location, graphQLOperationNames.bound(name, maxGraphQLOperations), operationType).Inc()
+}
+
+// RecordGraphQLRejected records a GraphQL request of a location rejected for the given reason (e.g. depth or introspection)
+func RecordGraphQLRejected(location, reason string) {
+	graphQLRejected.WithLabelValues(location, reason).Inc()
+}
+
+// RecordUpstreamResponseTime records the time in seconds until an upstream response of a location was fully read
+func RecordUpstreamResponseTime(location string, duration float64) {
+	upstreamResponseTime.WithLabelValues(location).Observe(duration)
+}
+
+// RecordUploadBytes records request body bytes of a location streamed to the upstream, as they are read
+func RecordUploadBytes(location string, numBytes int) {
+	uploadBytes.WithLabelValues(location).Add(float64(numBytes))
+}
+
+// UpdateUploads increments or decrements the number of request bodies of a location being streamed
+func UpdateUploads(location string, increment bool) {
+	if increment {
+		uploadsActive.WithLabelValues(location).Inc()
+	} else {
+		uploadsActive.WithLabelValues(location).Dec()
+	}
+}
+
+// RecordUploadSize records the size in bytes of a request body of a location once it has been streamed
+func RecordUploadSize(location string, size int64) {
+	uploadSize.WithLabelValues(location).Observe(float64(size))
+}
+
+// RecordGeoRequest records a request of a location from a client of the given country ("unknown" when unresolved)
+func RecordGeoRequest(location, country string) {
+	if country == "" {
+		country = "unknown"
+	}
+	geoRequests.WithLabelValues(location, country).Inc()
+}
+
+// RecordHoneypotRequest records a request to an attack path answered by the honeypot, by the pattern it matched
+func RecordHoneypotRequest(pattern string) {
+	honeypotRequests.WithLabelValues(pattern).Inc()
+}
+
+// RecordBotBlock records a request of a location stopped by the bot filter for the given reason (e.g. user_agent)
+func RecordBotBlock(location, reason, action string) {
+	botBlocks.WithLabelValues(location, reason, action).Inc()
+}
+
+// RecordFaultInjection records a fault (delay, status, or abort) injected into a request of a location
+func RecordFaultInjection(location, fault string) {
+	faultInjections.WithLabelValues(location, fault).Inc()
+}
+
+// RecordTokenIntrospection records a bearer token checked on a location, its result, and whether it was cached
+func RecordTokenIntrospection(location, result string, cached bool) {
+	source := "endpoint"
+	if cached {
+		source = "cache"
+	}
+	tokenIntrospections.WithLabelValues(location, result, source).Inc()
+}
+
+// RecordHMACVerification records the result of the verification of a request signature on a location
+func RecordHMACVerification(location, result string) {
+	hmacVerifications.WithLabelValues(location, result).Inc()
+}
+
+// RecordSessionEvent records a session issued, saved, or destroyed on a location
+func RecordSessionEvent(location, event string) {
+	sessionEvents.WithLabelValues(location, event).Inc()
+}
+
+// RecordSLOWindow records whether a window of a location met an objective, raising or clearing its alert
+func RecordSLOWindow(location, objective string, breached bool) {
+	if breached {
+		sloAlerts.WithLabelValues(location, objective).Set(1)
+		sloBreaches.WithLabelValues(location, objective).Inc()
+	} else {
+		sloAlerts.WithLabelValues(location, objective).Set(0)
+	}
+}
+
+// RecordExchange records an exchange captured on a location, and whether it could be stored
+func RecordExchange(location string, failed bool) {
+	result := "ok"
+	if failed {
+		result = "error"
+	}
+	recordedExchanges.WithLabelValues(location, result).Inc()
+}
+
+// RecordMirrorRequest records a request copied to the mirror of a location, and whether the mirror answered it
+func RecordMirrorRequest(location string, failed bool) {
+	result := "ok"
+	if failed {
+		result = "error"
+	}
+	mirrorRequests.WithLabelValues(location, result).Inc()
+}
+
+// RecordMirrorComparison records the result of the comparison of a mirrored response with the primary one
+func RecordMirrorComparison(location, result string) {
+	mirrorComparisons.WithLabelValues(location, result).Inc()
+}
+
+// UpdateWebSocketConnections increments or decrements the number of active WebSocket sessions of a location
+func UpdateWebSocketConnections(location string, increment bool) {
+	if increment {
+		websocketConnections.WithLabelValues(location).Inc()
+	} else {
+		websocketConnections.WithLabelValues(location).Dec()
+	}
+}
+
+// RecordWebSocketSession records the duration in seconds of an ended WebSocket session of a location
+func RecordWebSocketSession(location string, duration float64) {
+	websocketDuration.WithLabelValues(location).Observe(duration)
+}
+
+// RecordWebSocketMessage records a WebSocket data message forwarded in the given direction and its size
+func RecordWebSocketMessage(location, direction string, numBytes int) {
+	websocketMessages.WithLabelValues(location, direction).Inc()
+	websocketBytes.WithLabelValues(location, direction).Add(float64(numBytes))
+}
+
+// RecordWebSocketClose records a WebSocket close code seen in the given direction
+func RecordWebSocketClose(location, direction string, code int) {
+	websocketCloses.WithLabelValues(location, direction, strconv.Itoa(code)).Inc()
+}
+
 // ExposeMetricsHandler returns a handler that serves the metrics for Prometheus
 func ExposeMetricsHandler() http.Handler {
 	return promhttp.Handler()
@@ -1,10 +1,14 @@
 package metrics
 
 import (
+	"dito/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -23,7 +27,7 @@ func TestNormalizePath(t *testing.T) {
 
 // TestRecordRequest tests the RecordRequest function for recording HTTP requests.
 func TestRecordRequest(t *testing.T) {
-	RecordRequest("GET", "/users/123", http.StatusOK, 0.123)
+	RecordRequest("GET", NormalizePath("/users/123"), http.StatusOK, 0.123)
 	metric := &io_prometheus_client.Metric{}
 	if err := httpRequestsTotal.WithLabelValues("GET", "/users/:id", "OK").Write(metric); err != nil {
 		t.Fatalf("failed to write metric: %v", err)
@@ -31,6 +35,25 @@ func TestRecordRequest(t *testing.T) {
 	assert.Equal(t, 1, int(metric.GetCounter().GetValue()))
 }
 
+// TestPathLabel verifies the path label modes and the bound on the number of labels.
+func TestPathLabel(t *testing.T) {
+	defer func() { pathLabels = &labelSet{seen: make(map[string]struct{})} }()
+
+	location := config.PathLabelConfig{Mode: config.PathLabelLocation, MaxValues: 2}
+	assert.Equal(t, "^/api/", PathLabel(location, "^/api/", "/api/users/123"))
+	assert.Equal(t, OtherPath, PathLabel(location, "", "/unmatched"))
+
+	normalized := config.PathLabelConfig{
+		Mode:      config.PathLabelNormalized,
+		Rules:     []config.PathRule{{CompiledRegex: regexp.MustCompile(`^/files/.*`), Replacement: "/files/*"}},
+		MaxValues: 2,
+	}
+	assert.Equal(t, "/files/*", PathLabel(normalized, "^/files/", "/files/a/b.txt"))
+	// The limit is reached: new labels are reported as other, known ones are kept.
+	assert.Equal(t, OtherPath, PathLabel(normalized, "^/docs/", "/docs/intro"))
+	assert.Equal(t, "^/api/", PathLabel(location, "^/api/", "/api/orders/1"))
+}
+
 // TestRecordDataTransferred tests the RecordDataTransferred function for recording data transfer.
 func TestRecordDataTransferred(t *testing.T) {
 	RecordDataTransferred("inbound", 1024)
@@ -67,3 +90,156 @@ func TestExposeMetricsHandler(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rr.Code)
 	assert.Contains(t, rr.Body.String(), "http_requests_total")
 }
+
+// TestTransportStatsCollector tests that the transport statistics are exported at scrape time.
+func TestTransportStatsCollector(t *testing.T) {
+	SetTransportStatsSource(func() []TransportPoolStats {
+		return []TransportPoolStats{{Transport: "abc", OpenConnections: 3, ActiveRequests: 1, IdleConnections: 2}}
+	})
+	defer SetTransportStatsSource(nil)
+
+	assert.Equal(t, 4, testutil.CollectAndCount(transportStats))
+	expected := `
+# HELP transport_idle_connections Estimated number of idle upstream connections, partitioned by transport.
+# TYPE transport_idle_connections gauge
+transport_idle_connections{transport="abc"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(transportStats, strings.NewReader(expected), "transport_idle_connections"))
+}
+
+// TestRecordWebSocketSession tests the WebSocket session metrics.
+func TestRecordWebSocketSession(t *testing.T) {
+	UpdateWebSocketConnections("/ws", true)
+	assert.Equal(t, 1.0, testutil.ToFloat64(websocketConnections.WithLabelValues("/ws")))
+	RecordWebSocketMessage("/ws", "client_to_upstream", 5)
+	RecordWebSocketMessage("/ws", "client_to_upstream", 7)
+	RecordWebSocketClose("/ws", "client_to_upstream", 1000)
+	UpdateWebSocketConnections("/ws", false)
+	RecordWebSocketSession("/ws", 2.5)
+
+	assert.Equal(t, 0.0, testutil.ToFloat64(websocketConnections.WithLabelValues("/ws")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(websocketMessages.WithLabelValues("/ws", "client_to_upstream")))
+	assert.Equal(t, 12.0, testutil.ToFloat64(websocketBytes.WithLabelValues("/ws", "client_to_upstream")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(websocketCloses.WithLabelValues("/ws", "client_to_upstream", "1000")))
+	assert.Equal(t, 1, testutil.CollectAndCount(websocketDuration))
+}
+
+// TestRecordUploads tests the upload progress metrics.
+func TestRecordUploads(t *testing.T) {
+	UpdateUploads("/upload", true)
+	assert.Equal(t, 1.0, testutil.ToFloat64(uploadsActive.WithLabelValues("/upload")))
+	RecordUploadBytes("/upload", 1024)
+	RecordUploadBytes("/upload", 512)
+	UpdateUploads("/upload", false)
+	RecordUploadSize("/upload", 1536)
+
+	assert.Equal(t, 0.0, testutil.ToFloat64(uploadsActive.WithLabelValues("/upload")))
+	assert.Equal(t, 1536.0, testutil.ToFloat64(uploadBytes.WithLabelValues("/upload")))
+	assert.Equal(t, 1, testutil.CollectAndCount(uploadSize))
+}
+
+// TestRecordGeoRequest tests that the requests are counted per country, unresolved ones as unknown.
+func TestRecordGeoRequest(t *testing.T) {
+	RecordGeoRequest("/geo", "IT")
+	RecordGeoRequest("/geo", "")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(geoRequests.WithLabelValues("/geo", "IT")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(geoRequests.WithLabelValues("/geo", "unknown")))
+}
+
+// TestRecordBotBlock tests that the requests stopped by the bot filter are counted per reason and action.
+func TestRecordBotBlock(t *testing.T) {
+	RecordBotBlock("/bots", "known_bot", "block")
+	RecordBotBlock("/bots", "missing_header", "tarpit")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(botBlocks.WithLabelValues("/bots", "known_bot", "block")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(botBlocks.WithLabelValues("/bots", "missing_header", "tarpit")))
+}
+
+// TestRecordHoneypotRequest tests that the honeypot requests are counted per matched pattern.
+func TestRecordHoneypotRequest(t *testing.T) {
+	RecordHoneypotRequest(`/\.env$`)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(honeypotRequests.WithLabelValues(`/\.env$`)))
+}
+
+// TestRecordFaultInjection tests that the injected faults are counted per location and fault.
+func TestRecordFaultInjection(t *testing.T) {
+	RecordFaultInjection("/chaos", "delay")
+	RecordFaultInjection("/chaos", "abort")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(faultInjections.WithLabelValues("/chaos", "delay")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(faultInjections.WithLabelValues("/chaos", "abort")))
+}
+
+// TestRecordTokenIntrospection tests that the checked tokens are counted per location, result, and source.
+func TestRecordTokenIntrospection(t *testing.T) {
+	RecordTokenIntrospection("/introspected", "active", false)
+	RecordTokenIntrospection("/introspected", "active", true)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(tokenIntrospections.WithLabelValues("/introspected", "active", "endpoint")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(tokenIntrospections.WithLabelValues("/introspected", "active", "cache")))
+}
+
+// TestRecordHMACVerification tests that the signature verifications are counted per location and result.
+func TestRecordHMACVerification(t *testing.T) {
+	RecordHMACVerification("/webhooks", "valid")
+	RecordHMACVerification("/webhooks", "replayed")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(hmacVerifications.WithLabelValues("/webhooks", "valid")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(hmacVerifications.WithLabelValues("/webhooks", "replayed")))
+}
+
+// TestRecordSessionEvent tests that the session events are counted per location and event.
+func TestRecordSessionEvent(t *testing.T) {
+	RecordSessionEvent("/app", "issued")
+	RecordSessionEvent("/app", "saved")
+	RecordSessionEvent("/app", "saved")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(sessionEvents.WithLabelValues("/app", "issued")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(sessionEvents.WithLabelValues("/app", "saved")))
+}
+
+// TestRecordSLOWindow tests that a breached window raises the alert of its objective and is counted, and that a
+// window meeting the objective clears the alert.
+func TestRecordSLOWindow(t *testing.T) {
+	RecordSLOWindow("/slo", "latency", true)
+	assert.Equal(t, 1.0, testutil.ToFloat64(sloAlerts.WithLabelValues("/slo", "latency")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(sloBreaches.WithLabelValues("/slo", "latency")))
+
+	RecordSLOWindow("/slo", "latency", false)
+	assert.Equal(t, 0.0, testutil.ToFloat64(sloAlerts.WithLabelValues("/slo", "latency")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(sloBreaches.WithLabelValues("/slo", "latency")))
+}
+
+// TestRecordExchange tests that the recorded exchanges are counted per location and result.
+func TestRecordExchange(t *testing.T) {
+	RecordExchange("/recorded", false)
+	RecordExchange("/recorded", true)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(recordedExchanges.WithLabelValues("/recorded", "ok")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(recordedExchanges.WithLabelValues("/recorded", "error")))
+}
+
+// TestRecordMirror tests that the mirrored requests and the comparisons of their responses are counted per result.
+func TestRecordMirror(t *testing.T) {
+	RecordMirrorRequest("/shadow", false)
+	RecordMirrorRequest("/shadow", true)
+	RecordMirrorComparison("/shadow", "body_mismatch")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(mirrorRequests.WithLabelValues("/shadow", "ok")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(mirrorRequests.WithLabelValues("/shadow", "error")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(mirrorComparisons.WithLabelValues("/shadow", "body_mismatch")))
+}
+
+// TestRuntimeAndBuildInfoMetrics tests that the runtime, process, and build metrics are exposed.
+func TestRuntimeAndBuildInfoMetrics(t *testing.T) {
+	rr := httptest.NewRecorder()
+	ExposeMetricsHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rr.Body.String()
+	assert.Contains(t, body, `dito_build_info{commit=`)
+	assert.Contains(t, body, "go_sched_goroutines_goroutines")
+	assert.Contains(t, body, "go_gc_cycles_total_gc_cycles_total")
+	assert.Contains(t, body, "process_resident_memory_bytes")
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"dito/config"
+	"sync"
+)
+
+// OtherPath is the path label of the requests matching no location, and of the paths beyond the label limit.
+const OtherPath = "other"
+
+// defaultMaxPathLabels is the default maximum number of distinct path labels.
+const defaultMaxPathLabels = 100
+
+// pathLabels holds the path labels reported so far, bounding their number.
+var pathLabels = &labelSet{seen: make(map[string]struct{})}
+
+// labelSet is the set of the values of a label reported so far.
+type labelSet struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+// bound returns the label if it was already reported or the limit is not reached, OtherPath otherwise.
+func (s *labelSet) bound(label string, limit int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.seen[label]; ok {
+		return label
+	}
+	if len(s.seen) >= limit {
+		return OtherPath
+	}
+	s.seen[label] = struct{}{}
+	return label
+}
+
+// PathLabel returns the path label of a request for the request metrics. In location mode it is the label
+// of the matched location; in normalized mode it is the request path rewritten by the rules.
+// Labels beyond the configured limit are reported as OtherPath, so that the label cardinality stays bounded.
+//
+// Parameters:
+// - labelConfig: The path label configuration.
+// - location: The label (name or path pattern) of the matched location, empty if none matched.
+// - path: The request path.
+//
+// Returns:
+// - string: The path label.
+func PathLabel(labelConfig config.PathLabelConfig, location, path string) string {
+	label := location
+	if labelConfig.Mode == config.PathLabelNormalized {
+		label = normalizePath(labelConfig.Rules, path)
+	}
+	if label == "" {
+		return OtherPath
+	}
+
+	limit := labelConfig.MaxValues
+	if limit <= 0 {
+		limit = defaultMaxPathLabels
+	}
+	return pathLabels.bound(label, limit)
+}
+
+// normalizePath applies the rules to a path in order, or NormalizePath when no rule is configured.
+func normalizePath(rules []config.PathRule, path string) string {
+	if len(rules) == 0 {
+		return NormalizePath(path)
+	}
+	for _, rule := range rules {
+		if rule.CompiledRegex != nil {
+			path = rule.CompiledRegex.ReplaceAllString(path, rule.Replacement)
+		}
+	}
+	return path
+}
@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"dito/config"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Defaults of the StatsD exporter.
+const (
+	defaultStatsDAddress       = "127.0.0.1:8125"
+	defaultStatsDFlushInterval = 10 * time.Second
+)
+
+// maxStatsDPacketSize keeps the datagrams below the usual network MTU.
+const maxStatsDPacketSize = 1432
+
+// StatsDExporter pushes the registered metrics to a StatsD agent at every flush interval. Counters are sent
+// as the increase since the previous flush, gauges as their current value, and histograms and summaries as
+// the increase of their count and sum.
+type StatsDExporter struct {
+	config   config.StatsDConfig
+	gatherer prometheus.Gatherer
+	conn     net.Conn
+	logger   *slog.Logger
+	previous map[string]float64 // previous holds the counter values sent at the previous flush, by series.
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// StartStatsDExporter starts pushing the metrics of the default registry to a StatsD agent.
+//
+// Parameters:
+// - statsdConfig: The exporter configuration.
+// - logger: The logger used to report failed flushes.
+//
+// Returns:
+// - *StatsDExporter: The running exporter, to be stopped on shutdown.
+// - error: An error if the agent address cannot be resolved.
+func StartStatsDExporter(statsdConfig config.StatsDConfig, logger *slog.Logger) (*StatsDExporter, error) {
+	exporter, err := newStatsDExporter(statsdConfig, prometheus.DefaultGatherer, logger)
+	if err != nil {
+		return nil, err
+	}
+	go exporter.run()
+	return exporter, nil
+}
+
+// newStatsDExporter creates an exporter of the metrics of a gatherer, without starting it.
+func newStatsDExporter(statsdConfig config.StatsDConfig, gatherer prometheus.Gatherer, logger *slog.Logger) (*StatsDExporter, error) {
+	if statsdConfig.Address == "" {
+		statsdConfig.Address = defaultStatsDAddress
+	}
+	if statsdConfig.FlushInterval <= 0 {
+		statsdConfig.FlushInterval = defaultStatsDFlushInterval
+	}
+	conn, err := net.Dial("udp", statsdConfig.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the StatsD agent: %v", err)
+	}
+	return &StatsDExporter{
+		config:   statsdConfig,
+		gatherer: gatherer,
+		conn:     conn,
+		logger:   logger,
+		previous: make(map[string]float64),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Stop pushes the metrics a last time and stops the exporter.
+func (e *StatsDExporter) Stop() {
+	e.stopOnce.Do(func() {
+		close(e.stop)
+		<-e.done
+		e.conn.Close()
+	})
+}
+
+// run flushes the metrics at every interval until the exporter is stopped.
+func (e *StatsDExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.stop:
+			e.flush()
+			return
+		}
+		e.flush()
+	}
+}
+
+// flush gathers the metrics and sends them.
+func (e *StatsDExporter) flush() {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		e.logger.Warn(fmt.Sprintf("[StatsD] Failed to gather metrics: %v", err))
+	}
+	if err := e.send(e.lines(families)); err != nil {
+		e.logger.Warn(fmt.Sprintf("[StatsD] Failed to push metrics: %v", err))
+	}
+}
+
+// send writes the lines to the agent, packing as many as fit in each datagram.
+func (e *StatsDExporter) send(lines []string) error {
+	var packet strings.Builder
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxStatsDPacketSize {
+			if _, err := e.conn.Write([]byte(packet.String())); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		_, err := e.conn.Write([]byte(packet.String()))
+		return err
+	}
+	return nil
+}
+
+// lines converts the metric families to StatsD lines.
+func (e *StatsDExporter) lines(families []*dto.MetricFamily) []string {
+	var lines []string
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			name, tags := e.series(family.GetName(), metric.GetLabel())
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				lines = e.appendCounter(lines, name, tags, metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				lines = appendGauge(lines, name, tags, metric.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				lines = appendGauge(lines, name, tags, metric.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				lines = e.appendCounter(lines, name+"_count", tags, float64(metric.GetHistogram().GetSampleCount()))
+				lines = e.appendCounter(lines, name+"_sum", tags, metric.GetHistogram().GetSampleSum())
+			case dto.MetricType_SUMMARY:
+				lines = e.appendCounter(lines, name+"_count", tags, float64(metric.GetSummary().GetSampleCount()))
+				lines = e.appendCounter(lines, name+"_sum", tags, metric.GetSummary().GetSampleSum())
+			}
+		}
+	}
+	return lines
+}
+
+// series returns the StatsD name and the tags of a metric. With the statsd flavor the label values are
+// appended to the name, with the dogstatsd flavor the labels become tags.
+func (e *StatsDExporter) series(name string, labels []*dto.LabelPair) (string, string) {
+	name = e.config.Prefix + sanitizeStatsD(name)
+	sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+	if e.config.Flavor == config.StatsDFlavorDogStatsD {
+		tags := make([]string, len(labels))
+		for i, label := range labels {
+			tags[i] = sanitizeStatsD(label.GetName()) + ":" + sanitizeStatsD(label.GetValue())
+		}
+		if len(tags) == 0 {
+			return name, ""
+		}
+		return name, "|#" + strings.Join(tags, ",")
+	}
+	for _, label := range labels {
+		// Slashes, common in paths, are not valid in hierarchical (Graphite) names.
+		name += "." + strings.ReplaceAll(sanitizeStatsD(label.GetValue()), "/", "_")
+	}
+	return name, ""
+}
+
+// appendCounter appends the increase of a counter since the previous flush; a counter that went down was
+// reset, so its whole value is the increase.
+func (e *StatsDExporter) appendCounter(lines []string, name, tags string, value float64) []string {
+	key := name + tags
+	delta := value - e.previous[key]
+	if delta < 0 {
+		delta = value
+	}
+	e.previous[key] = value
+	if delta <= 0 || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return lines
+	}
+	return append(lines, name+":"+formatStatsD(delta)+"|c"+tags)
+}
+
+// appendGauge appends the value of a gauge. A signed value is a relative change in StatsD, so a negative
+// gauge is first reset to zero.
+func appendGauge(lines []string, name, tags string, value float64) []string {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return lines
+	}
+	if value < 0 {
+		lines = append(lines, name+":0|g"+tags)
+	}
+	return append(lines, name+":"+formatStatsD(value)+"|g"+tags)
+}
+
+// formatStatsD formats a value without exponent.
+func formatStatsD(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// sanitizeStatsD replaces the characters with a meaning in the StatsD protocol, and the dots that would
+// split label values into hierarchy levels.
+func sanitizeStatsD(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '.', '\n', ' ':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"dito/config"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStatsDExporter tests the conversion of the metrics to StatsD lines in both flavors.
+func TestStatsDExporter(t *testing.T) {
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer agent.Close()
+	receive := func() []string {
+		buf := make([]byte, maxStatsDPacketSize)
+		agent.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := agent.ReadFrom(buf)
+		assert.NoError(t, err)
+		return strings.Split(string(buf[:n]), "\n")
+	}
+
+	registry := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total"}, []string{"location", "result"})
+	temperature := prometheus.NewGauge(prometheus.GaugeOpts{Name: "temperature"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency_seconds"})
+	registry.MustRegister(requests, temperature, latency)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	requests.WithLabelValues("/api", "hit").Add(3)
+	temperature.Set(-2.5)
+	latency.Observe(0.5)
+
+	exporter, err := newStatsDExporter(config.StatsDConfig{Address: agent.LocalAddr().String(), Prefix: "dito."}, registry, logger)
+	if !assert.NoError(t, err) {
+		return
+	}
+	exporter.flush()
+	assert.Equal(t, []string{
+		"dito.latency_seconds_count:1|c",
+		"dito.latency_seconds_sum:0.5|c",
+		"dito.requests_total._api.hit:3|c",
+		"dito.temperature:0|g",
+		"dito.temperature:-2.5|g",
+	}, receive())
+
+	// Counters are sent as their increase since the previous flush.
+	requests.WithLabelValues("/api", "hit").Add(2)
+	temperature.Set(4)
+	exporter.flush()
+	assert.Equal(t, []string{"dito.requests_total._api.hit:2|c", "dito.temperature:4|g"}, receive())
+
+	exporter, err = newStatsDExporter(config.StatsDConfig{Address: agent.LocalAddr().String(), Flavor: config.StatsDFlavorDogStatsD}, registry, logger)
+	if !assert.NoError(t, err) {
+		return
+	}
+	exporter.flush()
+	assert.Contains(t, receive(), "requests_total:5|c|#location:/api,result:hit")
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TransportPoolStats is a snapshot of the connection pool of a cached upstream transport.
+type TransportPoolStats struct {
+	Transport       string // Transport identifies the transport configuration.
+	OpenConnections int64  // OpenConnections is the number of open upstream connections.
+	ActiveRequests  int64  // ActiveRequests is the number of in-flight requests.
+	IdleConnections int64  // IdleConnections is the estimated number of idle connections.
+}
+
+// transportCollector exports the transport cache statistics, read from a source at scrape time.
+type transportCollector struct {
+	mu     sync.RWMutex
+	source func() []TransportPoolStats
+
+	cachedTransports *prometheus.Desc
+	openConnections  *prometheus.Desc
+	activeRequests   *prometheus.Desc
+	idleConnections  *prometheus.Desc
+}
+
+var transportStats = &transportCollector{
+	cachedTransports: prometheus.NewDesc(
+		"transport_cache_entries",
+		"Number of upstream transports currently cached.",
+		nil, nil,
+	),
+	openConnections: prometheus.NewDesc(
+		"transport_open_connections",
+		"Number of open upstream connections, partitioned by transport.",
+		[]string{"transport"}, nil,
+	),
+	activeRequests: prometheus.NewDesc(
+		"transport_active_requests",
+		"Number of in-flight upstream requests, partitioned by transport.",
+		[]string{"transport"}, nil,
+	),
+	idleConnections: prometheus.NewDesc(
+		"transport_idle_connections",
+		"Estimated number of idle upstream connections, partitioned by transport.",
+		[]string{"transport"}, nil,
+	),
+}
+
+// SetTransportStatsSource sets the function queried at scrape time for the transport cache statistics.
+func SetTransportStatsSource(source func() []TransportPoolStats) {
+	transportStats.mu.Lock()
+	defer transportStats.mu.Unlock()
+	transportStats.source = source
+}
+
+// Describe sends the descriptors of the transport metrics.
+func (c *transportCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.cachedTransports
+	ch <- c.openConnections
+	ch <- c.activeRequests
+	ch <- c.idleConnections
+}
+
+// Collect queries the source and sends the current transport metrics.
+func (c *transportCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	source := c.source
+	c.mu.RUnlock()
+	if source == nil {
+		return
+	}
+
+	pools := source()
+	ch <- prometheus.MustNewConstMetric(c.cachedTransports, prometheus.GaugeValue, float64(len(pools)))
+	for _, pool := range pools {
+		ch <- prometheus.MustNewConstMetric(c.openConnections, prometheus.GaugeValue, float64(pool.OpenConnections), pool.Transport)
+		ch <- prometheus.MustNewConstMetric(c.activeRequests, prometheus.GaugeValue, float64(pool.ActiveRequests), pool.Transport)
+		ch <- prometheus.MustNewConstMetric(c.idleConnections, prometheus.GaugeValue, float64(pool.IdleConnections), pool.Transport)
+	}
+}
@@ -0,0 +1,67 @@
+package middlewares
+
+import (
+	"dito/audit"
+	"dito/config"
+	"dito/ratelimit"
+	"dito/websocket"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// AdaptiveConcurrencyMiddleware limits the requests in flight to the upstream of a location with a limit that
+// adapts to the upstream latency: the limit shrinks when the latency rises or the upstream reports overload,
+// and grows back while responses stay fast. Requests above the limit are answered with 503.
+//
+// Parameters:
+// - next: The next http.Handler to be called if the limit allows the request.
+// - limiters: The rate limiter manager holding the adaptive limiter of the location.
+// - location: The key of the location.
+// - adaptiveConfig: The adaptive concurrency configuration.
+// - logger: The logger used to log messages.
+//
+// Returns:
+// - http.Handler: A handler that applies the adaptive concurrency limit.
+func AdaptiveConcurrencyMiddleware(next http.Handler, limiters *ratelimit.Manager, location string, adaptiveConfig config.AdaptiveConcurrency, logger *slog.Logger) http.Handler {
+	middlewareType := "AdaptiveConcurrencyMiddleware"
+	if !adaptiveConfig.Enabled {
+		logger.Debug(fmt.Sprintf("[%s] Adaptive concurrency is disabled", middlewareType))
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The duration of a WebSocket session says nothing about the upstream latency.
+		if websocket.IsWebSocketRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limiter := limiters.Adaptive(location, adaptiveConfig)
+		if !limiter.Acquire() {
+			logger.Debug(fmt.Sprintf("[%s] Concurrency limit of %d reached for %s", middlewareType, limiter.Limit(), location))
+			auditRequest(r, audit.EventRateLimitBlock, map[string]string{"limiter": "adaptive_concurrency", "path": r.URL.Path})
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		lrw := passthroughWriters.Get(w, true)
+		defer passthroughWriters.Put(lrw)
+
+		// The slot is released even if the handler panics (e.g. http.ErrAbortHandler when the client goes away).
+		start := time.Now()
+		defer func() { limiter.Release(time.Since(start), upstreamOverloaded(lrw.StatusCode)) }()
+		next.ServeHTTP(lrw, r)
+	})
+}
+
+// upstreamOverloaded reports whether a response status signals an overloaded upstream.
+func upstreamOverloaded(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"dito/config"
+	"dito/ratelimit"
+	"dito/websocket"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// sharedBandwidthKey is the budget of the requests without an accepted API key.
+const sharedBandwidthKey = "*"
+
+// BandwidthLimitMiddleware throttles the response bodies of a location to the configured rate, so that clients
+// downloading large bodies cannot starve the others. The budget is shared by the location, or owned by each accepted
+// API key when a key header is configured: one of the configured keys, or any key when the header is set by the
+// introspection of the location. Other keys share the budget of the location, so that a client cannot get fresh
+// budgets by sending new keys. WebSocket sessions are not throttled.
+//
+// Parameters:
+// - next: The next http.Handler producing the response.
+// - limiters: The rate limiter manager holding the byte buckets.
+// - location: The key of the location.
+// - bandwidthConfig: The configuration for the bandwidth limit.
+// - logger: The logger used to log messages.
+//
+// Returns:
+// - http.Handler: A handler that throttles the response bodies.
+func BandwidthLimitMiddleware(next http.Handler, limiters *ratelimit.Manager, location string, bandwidthConfig config.BandwidthLimit, logger *slog.Logger) http.Handler {
+	middlewareType := "BandwidthLimitMiddleware"
+	if !bandwidthConfig.Enabled || bandwidthConfig.BytesPerSecond <= 0 {
+		logger.Debug(fmt.Sprintf("[%s] Bandwidth limit is disabled", middlewareType))
+		return next
+	}
+
+	burst := bandwidthConfig.Burst
+	if burst <= 0 {
+		burst = int(min(bandwidthConfig.BytesPerSecond, int64(1<<30)))
+	}
+	rateLimiting := config.RateLimiting{Enabled: true, RequestsPerSecond: float64(bandwidthConfig.BytesPerSecond), Burst: burst}
+	namespace := "bandwidth:" + location
+	accepted := make(map[[sha256.Size]byte]struct{}, len(bandwidthConfig.Keys))
+	for _, key := range bandwidthConfig.Keys {
+		accepted[sha256.Sum256([]byte(key))] = struct{}{}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := sharedBandwidthKey
+		if bandwidthConfig.KeyHeader != "" {
+			if apiKey := r.Header.Get(bandwidthConfig.KeyHeader); apiKey != "" {
+				hash := sha256.Sum256([]byte(apiKey))
+				if _, ok := accepted[hash]; ok || len(accepted) == 0 {
+					key = string(hash[:])
+				}
+			}
+		}
+		next.ServeHTTP(&throttledWriter{
+			ResponseWriter: w,
+			ctx:            r.Context(),
+			burst:          burst,
+			wait: func(ctx context.Context, n int) error {
+				return limiters.WaitN(ctx, namespace, key, rateLimiting, n)
+			},
+		}, r)
+	})
+}
+
+// throttledWriter is a response writer waiting for bandwidth tokens before writing each piece of the body.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx   context.Context
+	burst int                                    // burst is the largest piece written at once.
+	wait  func(ctx context.Context, n int) error // wait blocks until n bytes may be written.
+}
+
+// Write writes the body in pieces no larger than the burst, each once the bucket allows it.
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		piece := p[:min(len(p), tw.burst)]
+		if err := tw.wait(tw.ctx, len(piece)); err != nil {
+			return written, err
+		}
+		n, err := tw.ResponseWriter.Write(piece)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(piece):]
+	}
+	return written, nil
+}
+
+// Flush sends any buffered data to the client, so that streamed responses keep flowing.
+func (tw *throttledWriter) Flush() {
+	http.NewResponseController(tw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying response writer, for http.ResponseController.
+func (tw *throttledWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
@@ -0,0 +1,58 @@
+package middlewares
+
+import (
+	"bytes"
+	"dito/config"
+	"dito/ratelimit"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBandwidthLimitMiddleware verifies that response bodies are throttled per accepted API key, and that other
+// keys share the budget of the location.
+func TestBandwidthLimitMiddleware(t *testing.T) {
+	limiters := ratelimit.NewManager()
+	defer limiters.Stop()
+
+	body := bytes.Repeat([]byte("x"), 3000)
+	bandwidthLimit := config.BandwidthLimit{Enabled: true, BytesPerSecond: 10000, Burst: 1000, KeyHeader: "X-API-Key",
+		Keys: []string{"tenant-a", "tenant-b", "tenant-c"}}
+	handler := BandwidthLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}), limiters, "^/files", bandwidthLimit, slog.Default())
+
+	serve := func(apiKey string) time.Duration {
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/files", nil)
+		req.Header.Set("X-API-Key", apiKey)
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, body, rec.Body.Bytes())
+		return time.Since(start)
+	}
+
+	// The burst is sent at once, the remaining 2000 bytes take about 200ms.
+	assert.GreaterOrEqual(t, serve("tenant-a"), 150*time.Millisecond)
+
+	// Each key has its own budget: concurrent downloads of two keys are not slowed down by each other.
+	elapsed := make(chan time.Duration, 2)
+	for _, apiKey := range []string{"tenant-b", "tenant-c"} {
+		go func() { elapsed <- serve(apiKey) }()
+	}
+	for i := 0; i < 2; i++ {
+		assert.Less(t, <-elapsed, 350*time.Millisecond)
+	}
+
+	// Unknown keys share one budget: the second of two concurrent downloads waits for the first.
+	for _, apiKey := range []string{"unknown-1", "unknown-2"} {
+		go func() { elapsed <- serve(apiKey) }()
+	}
+	slowest := max(<-elapsed, <-elapsed)
+	assert.GreaterOrEqual(t, slowest, 350*time.Millisecond)
+	assert.Equal(t, 4, limiters.Clients("bandwidth:^/files"))
+}
@@ -0,0 +1,95 @@
+package middlewares
+
+import (
+	"dito/app"
+	"dito/audit"
+	"dito/config"
+	"dito/metrics"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync/atomic"
+)
+
+// knownBots matches the User-Agents of common vulnerability scanners and attack tools, which legitimate clients
+// never send.
+var knownBots = regexp.MustCompile(`(?i)(sqlmap|nikto|nmap|masscan|zgrab|nuclei|wpscan|dirbuster|gobuster|feroxbuster|ffuf|wfuzz|acunetix|netsparker|havij|w3af|commix|jorgee|zmeu|morfeus|fimap|openvas|whatweb)`)
+
+// Reasons for which the bot filter stops a request.
+const (
+	botReasonMissingHeader = "missing_header"
+	botReasonKnownBot      = "known_bot"
+	botReasonUserAgent     = "user_agent"
+)
+
+// BotFilterMiddleware stops the requests of bots: clients missing a required header, sending the User-Agent of
+// a known attack tool, or matching a blocked User-Agent pattern, unless their User-Agent is explicitly allowed.
+// Stopped requests get 403, at once or after the tarpit delay; beyond the maximum of requests held at once by the
+// tarpit, they get it at once.
+//
+// Parameters:
+// - next: The next http.Handler to be called if the request is allowed.
+// - dito: The Dito application instance containing the configuration and logger.
+// - location: The label of the location (its name or path), used to label the metrics.
+// - botFilter: The bot filter configuration of the location.
+//
+// Returns:
+// - http.Handler: A handler that enforces the bot filter.
+func BotFilterMiddleware(next http.Handler, dito *app.Dito, location string, botFilter config.BotFilter) http.Handler {
+	middlewareType := "BotFilterMiddleware"
+	var tarpitted atomic.Int64
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason := botFilterReason(r, botFilter)
+		if reason == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		dito.Logger.Warn(fmt.Sprintf("[%s] Stopped %s %s from %s (%s): %s", middlewareType, r.Method, r.URL.Path, r.RemoteAddr, botFilter.Action, reason))
+		if dito.Config.Metrics.Enabled {
+			metrics.RecordBotBlock(location, reason, botFilter.Action)
+		}
+		auditRequest(r, audit.EventSecurityBlock, map[string]string{"reason": "bot_" + reason, "user_agent": r.UserAgent(), "path": r.URL.Path})
+
+		if botFilter.Action == config.BotActionTarpit && holdRequest(&tarpitted, botFilter.MaxTarpitted) {
+			held := sleep(r.Context(), botFilter.TarpitDelay)
+			tarpitted.Add(-1)
+			if !held {
+				return
+			}
+		}
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
+
+// botFilterReason returns the reason for which the bot filter stops a request.
+//
+// Parameters:
+// - r: The HTTP request.
+// - botFilter: The bot filter configuration.
+//
+// Returns:
+// - string: The reason (missing_header, known_bot, or user_agent), empty if the request is allowed.
+func botFilterReason(r *http.Request, botFilter config.BotFilter) string {
+	userAgent := r.UserAgent()
+	for _, allowed := range botFilter.CompiledAllowUserAgents {
+		if allowed.MatchString(userAgent) {
+			return ""
+		}
+	}
+	for _, header := range botFilter.RequireHeaders {
+		if r.Header.Get(header) == "" {
+			return botReasonMissingHeader
+		}
+	}
+	if botFilter.BlockKnownBots && knownBots.MatchString(userAgent) {
+		return botReasonKnownBot
+	}
+	for _, blocked := range botFilter.CompiledBlockUserAgents {
+		if blocked.MatchString(userAgent) {
+			return botReasonUserAgent
+		}
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package middlewares
+
+import (
+	"context"
+	"dito/app"
+	"dito/config"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBotFilterReason verifies the order of the bot filter rules: allowed User-Agents first, then the required
+// headers, the known bots, and the blocked User-Agents.
+func TestBotFilterReason(t *testing.T) {
+	botFilter := config.BotFilter{
+		BlockKnownBots:          true,
+		RequireHeaders:          []string{"User-Agent", "Accept"},
+		CompiledBlockUserAgents: []*regexp.Regexp{regexp.MustCompile("(?i)python-requests")},
+		CompiledAllowUserAgents: []*regexp.Regexp{regexp.MustCompile("(?i)^probe/")},
+	}
+	tests := []struct {
+		name      string
+		userAgent string
+		accept    string
+		expected  string
+	}{
+		{"browser", "Mozilla/5.0 (X11; Linux x86_64)", "text/html", ""},
+		{"missing user agent", "", "text/html", botReasonMissingHeader},
+		{"missing accept", "Mozilla/5.0", "", botReasonMissingHeader},
+		{"known bot", "sqlmap/1.7.2#stable (https://sqlmap.org)", "*/*", botReasonKnownBot},
+		{"blocked pattern", "Python-Requests/2.31", "*/*", botReasonUserAgent},
+		{"allowed without headers", "probe/1.0", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			assert.Equal(t, tt.expected, botFilterReason(req, botFilter))
+		})
+	}
+}
+
+// TestBotFilterMiddleware verifies that stopped requests get 403, after the delay when tarpitted unless the
+// tarpit is full, and that a tarpitted client going away is not answered.
+func TestBotFilterMiddleware(t *testing.T) {
+	dito := &app.Dito{Config: &config.ProxyConfig{}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	botFilter := config.BotFilter{Enabled: true, Action: config.BotActionBlock, BlockKnownBots: true, TarpitDelay: 50 * time.Millisecond, MaxTarpitted: 1}
+
+	serve := func(handler http.Handler, req *http.Request) (*httptest.ResponseRecorder, time.Duration) {
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		handler.ServeHTTP(rec, req)
+		return rec, time.Since(start)
+	}
+	scan := func() *http.Request {
+		req := httptest.NewRequest("GET", "/wp-login.php", nil)
+		req.Header.Set("User-Agent", "Nikto/2.5.0")
+		return req
+	}
+
+	handler := BotFilterMiddleware(next, dito, "/", botFilter)
+	rec, _ := serve(handler, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec, elapsed := serve(handler, scan())
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Less(t, elapsed, botFilter.TarpitDelay)
+
+	botFilter.Action = config.BotActionTarpit
+	handler = BotFilterMiddleware(next, dito, "/", botFilter)
+	rec, elapsed = serve(handler, scan())
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.GreaterOrEqual(t, elapsed, botFilter.TarpitDelay)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec, _ = serve(handler, scan().WithContext(ctx))
+	assert.Empty(t, rec.Body.String(), "a client gone during the tarpit is not answered")
+
+	// While a request is held, the next one is answered at once.
+	held := make(chan time.Duration)
+	go func() {
+		_, elapsed := serve(handler, scan())
+		held <- elapsed
+	}()
+	time.Sleep(10 * time.Millisecond)
+	rec, elapsed = serve(handler, scan())
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Less(t, elapsed, botFilter.TarpitDelay)
+	assert.GreaterOrEqual(t, <-held, botFilter.TarpitDelay)
+}
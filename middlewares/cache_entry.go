@@ -0,0 +1,277 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// cachedHeaders are the response headers stored with cached bodies.
+var cachedHeaders = []string{"Content-Type", "Content-Encoding", "Content-Language", "ETag", "Last-Modified", "Vary"}
+
+// cacheEntry is a cached response. It is stored in Redis as a JSON line holding the status and
+// headers, followed by the raw body.
+type cacheEntry struct {
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"-"`
+}
+
+// encodeCacheEntry serializes a cache entry.
+//
+// Parameters:
+// - entry: The cache entry.
+//
+// Returns:
+// - []byte: The serialized entry.
+// - error: An error if the headers cannot be serialized.
+func encodeCacheEntry(entry cacheEntry) ([]byte, error) {
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, len(meta)+1+len(entry.Body))
+	data = append(data, meta...)
+	data = append(data, '\n')
+	return append(data, entry.Body...), nil
+}
+
+// decodeCacheEntry parses a serialized cache entry.
+//
+// Parameters:
+// - data: The serialized entry.
+//
+// Returns:
+// - cacheEntry: The cache entry.
+// - error: An error if the data is not a cache entry (e.g. written by an older version).
+func decodeCacheEntry(data []byte) (cacheEntry, error) {
+	meta, body, ok := bytes.Cut(data, []byte{'\n'})
+	if !ok {
+		return cacheEntry{}, errors.New("malformed cache entry")
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(meta, &entry); err != nil || entry.StatusCode == 0 {
+		return cacheEntry{}, errors.New("malformed cache entry")
+	}
+	entry.Body = body
+	return entry, nil
+}
+
+// hasTrailers reports whether a response declares trailers, or set them after its headers were sent.
+//
+// Parameters:
+// - header: The response headers.
+//
+// Returns:
+// - bool: True if the response has trailers.
+func hasTrailers(header http.Header) bool {
+	if header.Get("Trailer") != "" {
+		return true
+	}
+	for name := range header {
+		if strings.HasPrefix(name, http.TrailerPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// captureCachedHeaders copies the headers stored with a cached body.
+//
+// Parameters:
+// - header: The response headers.
+//
+// Returns:
+// - http.Header: The headers to store.
+func captureCachedHeaders(header http.Header) http.Header {
+	captured := make(http.Header)
+	for _, name := range cachedHeaders {
+		if values := header.Values(name); len(values) > 0 {
+			captured[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	return captured
+}
+
+// generatedETag computes a strong entity tag from a cached body, for upstream responses lacking one.
+//
+// Parameters:
+// - body: The response body.
+//
+// Returns:
+// - string: The quoted entity tag.
+func generatedETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified evaluates the conditional headers of a request against a cached response, as defined by
+// RFC 9110: If-None-Match takes precedence, and If-Modified-Since only applies to GET and HEAD requests.
+//
+// Parameters:
+// - r: The HTTP request.
+// - entry: The cached response.
+//
+// Returns:
+// - bool: True if the client copy is current and a 304 Not Modified response can be sent.
+func notModified(r *http.Request, entry cacheEntry) bool {
+	// Cached error responses are served as they are.
+	if entry.StatusCode != http.StatusOK || r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := entry.Header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			// If-None-Match uses the weak comparison.
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+		lastModified, err := http.ParseTime(entry.Header.Get("Last-Modified"))
+		if err != nil {
+			return false
+		}
+		return !lastModified.After(since)
+	}
+	return false
+}
+
+// writeNotModified answers a conditional request with 304 Not Modified and the validators of the cached response.
+//
+// Parameters:
+// - w: The HTTP response writer.
+// - entry: The cached response.
+func writeNotModified(w http.ResponseWriter, entry cacheEntry) {
+	for _, name := range []string{"ETag", "Last-Modified", "Vary", "Content-Location"} {
+		if values := entry.Header.Values(name); len(values) > 0 {
+			w.Header()[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	addVary(w.Header(), "Accept-Encoding")
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// writeCachedEntry answers a request with a cached response. With ranges, a successful response answers
+// Range requests with the requested bytes, as long as If-Range still matches it.
+//
+// Parameters:
+// - w: The HTTP response writer.
+// - r: The HTTP request.
+// - entry: The cached response.
+// - ranges: Whether Range requests are answered with 206 Partial Content.
+//
+// Returns:
+// - error: The error writing the body.
+func writeCachedEntry(w http.ResponseWriter, r *http.Request, entry cacheEntry, ranges bool) error {
+	for name, values := range entry.Header {
+		w.Header()[name] = values
+	}
+	addVary(w.Header(), "Accept-Encoding")
+	if ranges && entry.StatusCode == http.StatusOK {
+		lastModified, _ := http.ParseTime(entry.Header.Get("Last-Modified"))
+		http.ServeContent(w, r, "", lastModified, bytes.NewReader(entry.Body))
+		return nil
+	}
+	w.WriteHeader(entry.StatusCode)
+	_, err := w.Write(entry.Body)
+	return err
+}
+
+// addVary adds a header name to the Vary header, unless it is already listed.
+//
+// Parameters:
+// - header: The response headers.
+// - name: The header name the response varies on.
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, listed := range strings.Split(value, ",") {
+			if listed = strings.TrimSpace(listed); listed == "*" || strings.EqualFold(listed, name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
+
+// encodingVariant returns the content coding a client prefers among those cached separately (br, gzip,
+// identity), so that each negotiated representation is cached under its own key.
+//
+// Parameters:
+// - acceptEncoding: The Accept-Encoding header of the request.
+//
+// Returns:
+// - string: The preferred content coding.
+func encodingVariant(acceptEncoding string) string {
+	variant, best := "identity", 0.0
+	for _, coding := range []string{"br", "gzip"} {
+		if q := encodingQuality(acceptEncoding, coding); q > best {
+			variant, best = coding, q
+		}
+	}
+	return variant
+}
+
+// acceptsEncoding reports whether a client accepts a content coding ("" or identity for unencoded bodies).
+//
+// Parameters:
+// - acceptEncoding: The Accept-Encoding header of the request.
+// - coding: The content coding.
+//
+// Returns:
+// - bool: True if the coding is acceptable.
+func acceptsEncoding(acceptEncoding, coding string) bool {
+	if coding == "" {
+		coding = "identity"
+	}
+	return encodingQuality(acceptEncoding, coding) > 0
+}
+
+// encodingQuality returns the quality value a client gives to a content coding, as defined by RFC 9110.
+func encodingQuality(acceptEncoding, coding string) float64 {
+	quality, wildcard := -1.0, -1.0
+	for _, member := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(member), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch {
+		case strings.EqualFold(name, coding):
+			quality = q
+		case name == "*":
+			wildcard = q
+		}
+	}
+
+	switch {
+	case quality >= 0:
+		return quality
+	case wildcard >= 0:
+		return wildcard
+	case strings.EqualFold(coding, "identity"):
+		// Identity is acceptable unless explicitly excluded.
+		return 0.001
+	default:
+		return 0
+	}
+}
@@ -1,82 +1,475 @@
 package middlewares
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"dito/app"
 	"dito/config"
+	"dito/logging"
+	"dito/metrics"
+	"dito/spool"
+	"dito/websocket"
 	"dito/writer"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
+
+	"github.com/redis/go-redis/v9"
 )
 
+// maxCacheKeyBodySize is the largest XML request body hashed into a cache key; larger requests are not cached.
+const maxCacheKeyBodySize = 1 << 20
+
 // CacheMiddleware is an HTTP middleware that caches responses in Redis.
 // It checks if caching is enabled and if the request allows caching.
 // If a cached response is found, it serves the response from the cache.
-// Otherwise, it processes the request and caches the response.
+// Otherwise, it processes the request and caches the response, unless its body exceeds the
+// per-entry size limit. The total size of the location cache is kept under its limit by
+// evicting the entries closest to expiry.
 //
 // Parameters:
 // - next: The next http.Handler to be called if the request is not cached.
 // - dito: The Dito application instance containing the Redis client and logger.
+// - location: The label of the location (its name or path), used to label the cache metrics and account its size.
 // - locationConfig: The configuration for caching.
+// - writers: The pool providing the buffering writers used to capture responses.
 //
 // Returns:
 // - http.Handler: A handler that applies caching based on the provided configuration.
-func CacheMiddleware(next http.Handler, dito *app.Dito, locationConfig config.Cache) http.Handler {
+func CacheMiddleware(next http.Handler, dito *app.Dito, location string, locationConfig config.Cache, writers *writer.Pool) http.Handler {
 	middlewareType := "CacheMiddlewareRedis"
 	dito.Logger.Debug(fmt.Sprintf("[%s] Executing", middlewareType))
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !locationConfig.Enabled || locationConfig.TTL <= 0 || r.Header.Get("Cache-Control") == "no-cache" {
+		// WebSocket upgrades are never cached: the hijacked connection bypasses the response writer.
+		if websocket.IsWebSocketRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !locationConfig.Enabled || (locationConfig.TTL <= 0 && locationConfig.NegativeTTL <= 0) || r.Header.Get("Cache-Control") == "no-cache" {
 			dito.Logger.Debug(fmt.Sprintf("[%s] Cache is not enabled or request has 'Cache-Control: no-cache'. Proceeding without cache.", middlewareType))
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		cacheKey := generateCacheKey(r)
+		// An XML request, such as a SOAP call, names its operation in its body, so its body is part of the key.
+		var bodyKey string
+		if r.Body != nil && r.Body != http.NoBody && writer.IsXML(r.Header.Get("Content-Type")) {
+			var ok bool
+			if bodyKey, ok = xmlBodyKey(r); !ok {
+				dito.Logger.Debug(fmt.Sprintf("[%s] XML request body too large to be part of the cache key. Proceeding without cache.", middlewareType))
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
 
-		cachedContentType, err1 := dito.RedisClient.Get(context.Background(), cacheKey+":content-type").Result()
-		cachedResponse, err2 := dito.RedisClient.Get(context.Background(), cacheKey).Result()
+		// Each negotiated representation (br, gzip, identity) is cached under its own key, so that a client
+		// never receives an encoding it does not accept.
+		acceptEncoding := r.Header.Get("Accept-Encoding")
+		cacheKey := generateCacheKey(r, locationConfig.Key) + bodyKey + ":" + encodingVariant(acceptEncoding)
+		if locationConfig.MaxSize > 0 {
+			// The entries of a bounded cache share a hash tag with its index, which is updated by the same scripts.
+			cacheKey = cacheHashTag(location) + cacheKey
+		}
 
-		if err1 == nil && err2 == nil {
+		if entry, ok := loadCacheEntry(dito, cacheKey); ok && acceptsEncoding(acceptEncoding, entry.Header.Get("Content-Encoding")) {
 			dito.Logger.Debug(fmt.Sprintf("[%s] Cache hit for key: %s", middlewareType, cacheKey))
+			if dito.Config.Metrics.Enabled {
+				metrics.RecordCacheLookup(location, "hit")
+			}
+			setCacheStatus(r, "HIT")
 
-			w.Header().Set("Content-Type", cachedContentType)
-			w.WriteHeader(http.StatusOK)
-			_, writeErr := w.Write([]byte(cachedResponse))
-			if writeErr != nil {
-				dito.Logger.Error(fmt.Sprintf("[%s] Failed to write cached response: %v", middlewareType, writeErr))
+			// The client copy is still current: answer without the body and without touching the upstream.
+			if notModified(r, entry) {
+				writeNotModified(w, entry)
+				return
+			}
+
+			if err := writeCachedEntry(w, r, entry, locationConfig.Ranges); err != nil {
+				dito.Logger.Error(fmt.Sprintf("[%s] Failed to write cached response: %v", middlewareType, err))
 			}
 			return
 		} else {
 			dito.Logger.Debug(fmt.Sprintf("[%s] Cache miss for key: %s", middlewareType, cacheKey))
+			if dito.Config.Metrics.Enabled {
+				metrics.RecordCacheLookup(location, "miss")
+			}
+			setCacheStatus(r, "MISS")
 		}
 
-		lrw := &writer.ResponseWriter{ResponseWriter: w}
+		// Large responses spill to disk instead of growing the heap; a response above the entry
+		// size limit or that does not fit in the disk budget is served normally but not cached,
+		// and its capture stops as soon as it is known to be too large.
+		buffering := dito.Config.Buffering
+		body := spool.NewBuffer(buffering.MemoryLimit, locationConfig.MaxEntrySize, buffering.TempDir)
+		defer body.Close()
+
+		lrw := writers.Get(w, false)
+		defer writers.Put(lrw)
+		lrw.Spool = body
 		next.ServeHTTP(lrw, r)
 
-		if lrw.StatusCode == http.StatusOK && lrw.Body.Len() > 0 {
-			err := dito.RedisClient.Set(context.Background(), cacheKey, lrw.Body.String(), time.Duration(locationConfig.TTL)*time.Second).Err()
-			if err != nil {
-				dito.Logger.Error(fmt.Sprintf("[%s] Failed to cache response: %v", middlewareType, err))
+		if lrw.Truncated {
+			dito.Logger.Warn(fmt.Sprintf("[%s] Response for key %s exceeds the entry size limit or the buffering disk budget, not caching it", middlewareType, cacheKey))
+			if dito.Config.Metrics.Enabled {
+				metrics.RecordCacheSkipped(location, "too_large")
 			}
+			return
+		}
 
-			contentType := lrw.Header().Get("Content-Type")
-			err = dito.RedisClient.Set(context.Background(), cacheKey+":content-type", contentType, time.Duration(locationConfig.TTL)*time.Second).Err()
+		// Cached entries are served without trailers, which carry e.g. the status of gRPC calls.
+		if hasTrailers(lrw.Header()) {
+			dito.Logger.Debug(fmt.Sprintf("[%s] Response for key %s has trailers, not caching it", middlewareType, cacheKey))
+			if dito.Config.Metrics.Enabled {
+				metrics.RecordCacheSkipped(location, "trailers")
+			}
+			return
+		}
+
+		ttl := cacheTTL(lrw.StatusCode, locationConfig)
+		// An empty 200 is most likely an upstream glitch; error responses are cached whatever their body.
+		if ttl > 0 && (lrw.StatusCode != http.StatusOK || body.Size() > 0) {
+			data, err := io.ReadAll(body.NewReader())
 			if err != nil {
-				dito.Logger.Error(fmt.Sprintf("[%s] Failed to cache content-type: %v", middlewareType, err))
+				dito.Logger.Error(fmt.Sprintf("[%s] Failed to read buffered response: %v", middlewareType, err))
+				return
+			}
+			entry := cacheEntry{StatusCode: lrw.StatusCode, Header: captureCachedHeaders(lrw.Header()), Body: data}
+			if entry.StatusCode == http.StatusOK && entry.Header.Get("ETag") == "" {
+				// Responses served from the cache can then be revalidated with If-None-Match.
+				entry.Header.Set("ETag", generatedETag(data))
+			}
+			if locationConfig.MaxSize <= 0 {
+				storeCacheEntry(dito, cacheKey, entry, ttl)
+				return
+			}
+			size, evicted, err := storeBoundedCacheEntry(dito, location, cacheKey, entry, ttl, locationConfig.MaxSize)
+			if err != nil {
+				dito.Logger.Error(fmt.Sprintf("[%s] Failed to cache response: %v", middlewareType, err))
+				return
+			}
+			if evicted > 0 {
+				dito.Logger.Debug(fmt.Sprintf("[%s] Evicted %d entries to keep the cache of %s under %d bytes", middlewareType, evicted, location, locationConfig.MaxSize))
+			}
+			if dito.Config.Metrics.Enabled {
+				metrics.RecordCacheStore(location, size, evicted)
 			}
 		}
 	})
 }
 
-// generateCacheKey generates a cache key based on the request method and URI.
+// cacheTTL returns how long a response is cached: successful responses use the TTL of the location, while
+// 404 and 5xx responses use the negative TTL, so that a failing upstream is shielded from repeated requests
+// for a short time.
+//
+// Parameters:
+// - statusCode: The status code of the response.
+// - cacheConfig: The cache configuration of the location.
+//
+// Returns:
+// - time.Duration: The time to live of the entry, or 0 if the response is not cached.
+func cacheTTL(statusCode int, cacheConfig config.Cache) time.Duration {
+	switch {
+	case statusCode == http.StatusOK:
+		return time.Duration(max(cacheConfig.TTL, 0)) * time.Second
+	case statusCode == http.StatusNotFound || statusCode >= 500 && statusCode <= 599:
+		return time.Duration(max(cacheConfig.NegativeTTL, 0)) * time.Second
+	}
+	return 0
+}
+
+// loadCacheEntry reads a cached response from Redis.
+//
+// Parameters:
+// - dito: The Dito application instance containing the Redis client and logger.
+// - cacheKey: The cache key.
+//
+// Returns:
+// - cacheEntry: The cached response.
+// - bool: True if a valid entry was found.
+func loadCacheEntry(dito *app.Dito, cacheKey string) (cacheEntry, bool) {
+	data, err := dito.RedisClient.Get(context.Background(), cacheKey).Bytes()
+	if err != nil {
+		return cacheEntry{}, false
+	}
+	entry, err := decodeCacheEntry(data)
+	if err != nil {
+		dito.Logger.Debug(fmt.Sprintf("[CacheMiddlewareRedis] Ignoring cache entry %s: %v", cacheKey, err))
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// storeCacheEntry writes a response to the cache.
+//
+// Parameters:
+// - dito: The Dito application instance containing the Redis client and logger.
+// - cacheKey: The cache key.
+// - entry: The response to cache.
+// - ttl: The time to live of the entry.
+func storeCacheEntry(dito *app.Dito, cacheKey string, entry cacheEntry, ttl time.Duration) {
+	data, err := encodeCacheEntry(entry)
+	if err != nil {
+		dito.Logger.Error(fmt.Sprintf("[CacheMiddlewareRedis] Failed to encode response: %v", err))
+		return
+	}
+	if err := dito.RedisClient.Set(context.Background(), cacheKey, data, ttl).Err(); err != nil {
+		dito.Logger.Error(fmt.Sprintf("[CacheMiddlewareRedis] Failed to cache response: %v", err))
+	}
+}
+
+// storeBoundedCacheScript stores a cache entry and accounts its size in the index of its location: a sorted
+// set of the entry keys by expiry time (KEYS[2]) and a hash of their sizes holding the total under "__total"
+// (KEYS[3]). Expired entries are dropped from the index. It returns the total size and, if it exceeds the limit,
+// the keys and expiry times of the entries closest to expiry that must be evicted to fit it. The script does not
+// delete them itself: keys a script touches must be declared in KEYS, so they are evicted by evictCacheScript.
+var storeBoundedCacheScript = redis.NewScript(`
+local ttl = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local limit = tonumber(ARGV[4])
+
+local function forget(key)
+	local size = tonumber(redis.call('HGET', KEYS[3], key) or 0)
+	redis.call('HDEL', KEYS[3], key)
+	redis.call('ZREM', KEYS[2], key)
+	return redis.call('HINCRBY', KEYS[3], '__total', -size)
+end
+
+for _, key in ipairs(redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now)) do
+	forget(key)
+end
+
+forget(KEYS[1])
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ttl)
+redis.call('ZADD', KEYS[2], now + ttl, KEYS[1])
+redis.call('HSET', KEYS[3], KEYS[1], string.len(ARGV[1]))
+local total = redis.call('HINCRBY', KEYS[3], '__total', string.len(ARGV[1]))
+
+local victims = {}
+local excess = total - limit
+local rank = 0
+while excess > 0 do
+	local oldest = redis.call('ZRANGE', KEYS[2], rank, rank, 'WITHSCORES')
+	if not oldest[1] or oldest[1] == KEYS[1] then
+		break
+	end
+	table.insert(victims, oldest[1])
+	table.insert(victims, oldest[2])
+	excess = excess - tonumber(redis.call('HGET', KEYS[3], oldest[1]) or 0)
+	rank = rank + 1
+end
+
+-- The index lives as long as its longest-lived entry.
+if redis.call('PTTL', KEYS[2]) < ttl then
+	redis.call('PEXPIRE', KEYS[2], ttl)
+	redis.call('PEXPIRE', KEYS[3], ttl)
+end
+return {total, victims}
+`)
+
+// evictCacheScript evicts the entries (KEYS[3:]) selected by storeBoundedCacheScript from the cache of a location
+// and from its index (KEYS[1] and KEYS[2]). An entry is only evicted if its expiry time is still the one it was
+// selected with (ARGV), so that an entry stored again in the meantime is kept. It returns the total size and the
+// number of entries evicted.
+var evictCacheScript = redis.NewScript(`
+local total = tonumber(redis.call('HGET', KEYS[2], '__total') or 0)
+local evicted = 0
+for i = 3, #KEYS do
+	local score = redis.call('ZSCORE', KEYS[1], KEYS[i])
+	if score and tonumber(score) == tonumber(ARGV[i - 2]) then
+		local size = tonumber(redis.call('HGET', KEYS[2], KEYS[i]) or 0)
+		redis.call('HDEL', KEYS[2], KEYS[i])
+		redis.call('ZREM', KEYS[1], KEYS[i])
+		total = redis.call('HINCRBY', KEYS[2], '__total', -size)
+		evicted = evicted + redis.call('DEL', KEYS[i])
+	end
+end
+return {total, evicted}
+`)
+
+// cacheHashTag returns the Redis Cluster hash tag shared by the keys of the cache of a location with a size
+// limit, so that its entries and its index are stored in the same slot and can be used by the same script.
+//
+// Parameters:
+// - location: The label of the location.
+//
+// Returns:
+// - string: The hash tag, derived from a hash of the label so that braces in it cannot alter the tag.
+func cacheHashTag(location string) string {
+	sum := sha256.Sum256([]byte(location))
+	return "{" + hex.EncodeToString(sum[:8]) + "}"
+}
+
+// storeBoundedCacheEntry writes a response to the cache of a location with a size limit, evicting the
+// entries of the location closest to expiry to keep its total size under the limit. The cache key, like the
+// keys of the index, must carry the hash tag of the location.
+//
+// Parameters:
+// - dito: The Dito application instance containing the Redis client.
+// - location: The label of the location.
+// - cacheKey: The cache key.
+// - entry: The response to cache.
+// - ttl: The time to live of the entry.
+// - maxSize: The total size limit of the location cache, in bytes.
+//
+// Returns:
+// - int64: The total size of the location cache after the store.
+// - int64: The number of entries evicted.
+// - error: An error if the entry cannot be stored.
+func storeBoundedCacheEntry(dito *app.Dito, location, cacheKey string, entry cacheEntry, ttl time.Duration, maxSize int64) (int64, int64, error) {
+	data, err := encodeCacheEntry(entry)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to encode response: %v", err)
+	}
+	ctx := context.Background()
+	tag := cacheHashTag(location)
+	index := []string{"cache_index:" + tag, "cache_sizes:" + tag}
+	result, err := storeBoundedCacheScript.Run(ctx, dito.RedisClient, append([]string{cacheKey}, index...),
+		data, ttl.Milliseconds(), time.Now().UnixMilli(), maxSize).Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(result) != 2 {
+		return 0, 0, fmt.Errorf("unexpected cache store result: %v", result)
+	}
+	total, ok := result[0].(int64)
+	victims, isList := result[1].([]interface{})
+	if !ok || !isList || len(victims)%2 != 0 {
+		return 0, 0, fmt.Errorf("unexpected cache store result: %v", result)
+	}
+	if len(victims) == 0 {
+		return total, 0, nil
+	}
+
+	keys := index
+	args := make([]interface{}, 0, len(victims)/2)
+	for i := 0; i < len(victims); i += 2 {
+		key, ok := victims[i].(string)
+		if !ok {
+			return 0, 0, fmt.Errorf("unexpected cache store result: %v", result)
+		}
+		keys = append(keys, key)
+		args = append(args, victims[i+1])
+	}
+	evictions, err := evictCacheScript.Run(ctx, dito.RedisClient, keys, args...).Int64Slice()
+	if err != nil {
+		return total, 0, fmt.Errorf("failed to evict cache entries: %v", err)
+	}
+	if len(evictions) != 2 {
+		return 0, 0, fmt.Errorf("unexpected cache eviction result: %v", evictions)
+	}
+	return evictions[0], evictions[1], nil
+}
+
+// generateCacheKey generates a cache key from the request method, path, and the query parameters, headers,
+// and cookies selected by the key configuration.
 //
 // Parameters:
 // - r: The HTTP request.
+// - keyConfig: The composition of the cache key.
 //
 // Returns:
 // - string: The generated cache key.
-func generateCacheKey(r *http.Request) string {
-	return fmt.Sprintf("cache:%s:%s", r.Method, r.URL.RequestURI())
+func generateCacheKey(r *http.Request, keyConfig config.CacheKey) string {
+	var key strings.Builder
+	key.WriteString(r.Method)
+	key.WriteString(":")
+	if len(keyConfig.IncludeQuery) == 0 && len(keyConfig.ExcludeQuery) == 0 {
+		key.WriteString(r.URL.RequestURI())
+	} else {
+		key.WriteString(r.URL.EscapedPath())
+		if query := filterQuery(r.URL.Query(), keyConfig); len(query) > 0 {
+			key.WriteString("?")
+			// Encode sorts the parameters, so that their order does not split the cache.
+			key.WriteString(query.Encode())
+		}
+	}
+
+	for _, name := range keyConfig.Headers {
+		key.WriteString("|h:")
+		key.WriteString(strings.ToLower(name))
+		key.WriteString("=")
+		key.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	for _, name := range keyConfig.Cookies {
+		key.WriteString("|c:")
+		key.WriteString(name)
+		key.WriteString("=")
+		if cookie, err := r.Cookie(name); err == nil {
+			key.WriteString(cookie.Value)
+		}
+	}
+
+	if keyConfig.Hash {
+		sum := sha256.Sum256([]byte(key.String()))
+		return "cache:" + hex.EncodeToString(sum[:])
+	}
+	return "cache:" + key.String()
+}
+
+// xmlBodyKey reads the body of an XML request and returns the part of the cache key identifying it: a hash of the
+// SOAP action and of the body. The body is replayed to the next handlers.
+//
+// Parameters:
+// - r: The HTTP request, whose body is replaced by one replaying the bytes read.
+//
+// Returns:
+// - string: The body part of the cache key.
+// - bool: False if the body is larger than maxCacheKeyBodySize, and the request must not be cached.
+func xmlBodyKey(r *http.Request) (string, bool) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxCacheKeyBodySize+1))
+	r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(data), r.Body), Closer: r.Body}
+	if err != nil || len(data) > maxCacheKeyBodySize {
+		return "", false
+	}
+
+	// SOAP 1.1 carries the action in a header, SOAP 1.2 in a parameter of the content type.
+	_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	hash := sha256.New()
+	hash.Write([]byte(r.Header.Get("SOAPAction") + "\n" + params["action"] + "\n"))
+	hash.Write(data)
+	return "|body:" + hex.EncodeToString(hash.Sum(nil)), true
+}
+
+// readCloser combines a reader with the closer of the body it replaces.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// filterQuery keeps the query parameters selected by the key configuration.
+//
+// Parameters:
+// - query: The query parameters of the request.
+// - keyConfig: The composition of the cache key.
+//
+// Returns:
+// - url.Values: The parameters part of the cache key.
+func filterQuery(query url.Values, keyConfig config.CacheKey) url.Values {
+	if len(keyConfig.IncludeQuery) > 0 {
+		included := make(url.Values, len(keyConfig.IncludeQuery))
+		for _, name := range keyConfig.IncludeQuery {
+			if values, ok := query[name]; ok {
+				included[name] = values
+			}
+		}
+		query = included
+	}
+	for _, name := range keyConfig.ExcludeQuery {
+		query.Del(name)
+	}
+	return query
+}
+
+// setCacheStatus records the outcome of the cache lookup in the access log information of the request.
+func setCacheStatus(r *http.Request, status string) {
+	if info := logging.RequestInfoFrom(r.Context()); info != nil {
+		info.CacheStatus = status
+	}
 }
@@ -0,0 +1,314 @@
+package middlewares
+
+import (
+	"dito/app"
+	"dito/config"
+	"dito/writer"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGenerateCacheKey verifies the composition of cache keys from the key configuration.
+func TestGenerateCacheKey(t *testing.T) {
+	request := func(target string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("Accept-Language", "it")
+		r.AddCookie(&http.Cookie{Name: "tenant", Value: "acme"})
+		return r
+	}
+
+	assert.Equal(t, "cache:GET:/items?b=2&a=1", generateCacheKey(request("/items?b=2&a=1"), config.CacheKey{}))
+
+	excluded := config.CacheKey{ExcludeQuery: []string{"utm_source"}}
+	assert.Equal(t, "cache:GET:/items?a=1&b=2", generateCacheKey(request("/items?b=2&utm_source=x&a=1"), excluded))
+
+	included := config.CacheKey{IncludeQuery: []string{"page"}}
+	assert.Equal(t, "cache:GET:/items?page=3", generateCacheKey(request("/items?page=3&session=x"), included))
+	assert.Equal(t, "cache:GET:/items", generateCacheKey(request("/items?session=x"), included))
+
+	varied := config.CacheKey{Headers: []string{"Accept-Language"}, Cookies: []string{"tenant"}}
+	assert.Equal(t, "cache:GET:/items|h:accept-language=it|c:tenant=acme", generateCacheKey(request("/items"), varied))
+
+	hashed := generateCacheKey(request("/items"), config.CacheKey{Hash: true})
+	assert.True(t, strings.HasPrefix(hashed, "cache:"))
+	assert.Len(t, hashed, len("cache:")+64)
+}
+
+// TestXMLBodyKey verifies that SOAP requests are keyed by their action and envelope, and that their body is
+// replayed to the upstream.
+func TestXMLBodyKey(t *testing.T) {
+	request := func(action, envelope string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/soap", strings.NewReader(envelope))
+		r.Header.Set("Content-Type", "text/xml; charset=utf-8")
+		r.Header.Set("SOAPAction", action)
+		return r
+	}
+
+	r := request("urn:GetQuote", "<Envelope><Body><GetQuote>ACME</GetQuote></Body></Envelope>")
+	key, ok := xmlBodyKey(r)
+	assert.True(t, ok)
+	body, _ := io.ReadAll(r.Body)
+	assert.Equal(t, "<Envelope><Body><GetQuote>ACME</GetQuote></Body></Envelope>", string(body))
+
+	same, _ := xmlBodyKey(request("urn:GetQuote", "<Envelope><Body><GetQuote>ACME</GetQuote></Body></Envelope>"))
+	otherEnvelope, _ := xmlBodyKey(request("urn:GetQuote", "<Envelope><Body><GetQuote>INIT</GetQuote></Body></Envelope>"))
+	otherAction, _ := xmlBodyKey(request("urn:GetHistory", "<Envelope><Body><GetQuote>ACME</GetQuote></Body></Envelope>"))
+	assert.Equal(t, key, same)
+	assert.NotEqual(t, key, otherEnvelope)
+	assert.NotEqual(t, key, otherAction)
+
+	large := request("urn:Upload", strings.Repeat("x", maxCacheKeyBodySize+1))
+	_, ok = xmlBodyKey(large)
+	assert.False(t, ok)
+	body, _ = io.ReadAll(large.Body)
+	assert.Len(t, body, maxCacheKeyBodySize+1)
+}
+
+// TestCacheEntryEncoding verifies that cache entries survive a round trip and that foreign data is rejected.
+func TestCacheEntryEncoding(t *testing.T) {
+	entry := cacheEntry{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"gzip"}},
+		Body:       []byte("line one\nline two"),
+	}
+	data, err := encodeCacheEntry(entry)
+	assert.NoError(t, err)
+
+	decoded, err := decodeCacheEntry(data)
+	assert.NoError(t, err)
+	assert.Equal(t, entry, decoded)
+
+	_, err = decodeCacheEntry([]byte("plain body cached by an older version"))
+	assert.Error(t, err)
+}
+
+// TestHasTrailers verifies the detection of the responses with trailers, which are not cached.
+func TestHasTrailers(t *testing.T) {
+	assert.False(t, hasTrailers(http.Header{"Content-Type": {"application/grpc"}}))
+	assert.True(t, hasTrailers(http.Header{"Trailer": {"Grpc-Status"}}))
+	assert.True(t, hasTrailers(http.Header{http.TrailerPrefix + "X-Checksum": {"abc"}}))
+}
+
+// TestEncodingNegotiation verifies the choice of the cached variant and the acceptability of cached encodings.
+func TestEncodingNegotiation(t *testing.T) {
+	assert.Equal(t, "br", encodingVariant("gzip, deflate, br"))
+	assert.Equal(t, "gzip", encodingVariant("gzip;q=1.0, br;q=0.5"))
+	assert.Equal(t, "br", encodingVariant("*"))
+	assert.Equal(t, "identity", encodingVariant(""))
+	assert.Equal(t, "identity", encodingVariant("br;q=0, gzip;q=0"))
+
+	assert.True(t, acceptsEncoding("", ""))
+	assert.False(t, acceptsEncoding("", "gzip"))
+	assert.True(t, acceptsEncoding("gzip", "gzip"))
+	assert.False(t, acceptsEncoding("gzip, identity;q=0", ""))
+	assert.False(t, acceptsEncoding("*;q=0", "identity"))
+
+	header := http.Header{"Vary": {"Accept-Language, accept-encoding"}}
+	addVary(header, "Accept-Encoding")
+	assert.Equal(t, []string{"Accept-Language, accept-encoding"}, header.Values("Vary"))
+}
+
+// TestNotModified verifies the evaluation of conditional requests against cached responses.
+func TestNotModified(t *testing.T) {
+	lastModified := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+	entry := cacheEntry{StatusCode: http.StatusOK, Header: http.Header{
+		"Etag":          {generatedETag([]byte("body"))},
+		"Last-Modified": {lastModified.Format(http.TimeFormat)},
+	}}
+	etag := entry.Header.Get("ETag")
+
+	conditional := func(method, name, value string) *http.Request {
+		r := httptest.NewRequest(method, "/items", nil)
+		r.Header.Set(name, value)
+		return r
+	}
+
+	assert.True(t, notModified(conditional(http.MethodGet, "If-None-Match", etag), entry))
+	assert.True(t, notModified(conditional(http.MethodGet, "If-None-Match", `"other", W/`+etag), entry))
+	assert.True(t, notModified(conditional(http.MethodHead, "If-None-Match", "*"), entry))
+	assert.False(t, notModified(conditional(http.MethodGet, "If-None-Match", `"other"`), entry))
+	assert.False(t, notModified(conditional(http.MethodPost, "If-None-Match", etag), entry))
+
+	assert.True(t, notModified(conditional(http.MethodGet, "If-Modified-Since", lastModified.Format(http.TimeFormat)), entry))
+	assert.False(t, notModified(conditional(http.MethodGet, "If-Modified-Since", lastModified.Add(-time.Hour).Format(http.TimeFormat)), entry))
+
+	// If-None-Match takes precedence over If-Modified-Since.
+	r := conditional(http.MethodGet, "If-None-Match", `"other"`)
+	r.Header.Set("If-Modified-Since", lastModified.Format(http.TimeFormat))
+	assert.False(t, notModified(r, entry))
+
+	rec := httptest.NewRecorder()
+	writeNotModified(rec, entry)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Equal(t, etag, rec.Header().Get("ETag"))
+	assert.Empty(t, rec.Body.String())
+}
+
+// TestWriteCachedEntry verifies that cached responses answer Range requests only when ranges are enabled, and
+// that a stale If-Range gets the whole response.
+func TestWriteCachedEntry(t *testing.T) {
+	entry := cacheEntry{StatusCode: http.StatusOK, Header: http.Header{
+		"Content-Type": {"video/mp4"},
+		"Etag":         {`"v1"`},
+	}, Body: []byte("0123456789")}
+
+	serve := func(ranges bool, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/video.mp4", nil)
+		r.Header = header
+		rec := httptest.NewRecorder()
+		assert.NoError(t, writeCachedEntry(rec, r, entry, ranges))
+		return rec
+	}
+
+	rec := serve(true, http.Header{"Range": {"bytes=2-5"}})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "bytes 2-5/10", rec.Header().Get("Content-Range"))
+	assert.Equal(t, "2345", rec.Body.String())
+
+	rec = serve(true, http.Header{"Range": {"bytes=2-5"}, "If-Range": {`"v0"`}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "0123456789", rec.Body.String())
+
+	rec = serve(true, http.Header{"Range": {"bytes=20-"}})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rec.Code)
+
+	rec = serve(false, http.Header{"Range": {"bytes=2-5"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "0123456789", rec.Body.String())
+	assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+}
+
+// TestCacheTTL verifies the time to live of successful and error responses.
+func TestCacheTTL(t *testing.T) {
+	cacheConfig := config.Cache{TTL: 60, NegativeTTL: 5}
+	assert.Equal(t, time.Minute, cacheTTL(http.StatusOK, cacheConfig))
+	assert.Equal(t, 5*time.Second, cacheTTL(http.StatusNotFound, cacheConfig))
+	assert.Equal(t, 5*time.Second, cacheTTL(http.StatusBadGateway, cacheConfig))
+	assert.Zero(t, cacheTTL(http.StatusForbidden, cacheConfig))
+	assert.Zero(t, cacheTTL(http.StatusCreated, cacheConfig))
+
+	// Negative caching is disabled by default.
+	assert.Zero(t, cacheTTL(http.StatusServiceUnavailable, config.Cache{TTL: 60}))
+
+	// Cached error responses are never revalidated.
+	r := httptest.NewRequest(http.MethodGet, "/items", nil)
+	r.Header.Set("If-None-Match", "*")
+	assert.False(t, notModified(r, cacheEntry{StatusCode: http.StatusNotFound, Header: http.Header{"Etag": {`"x"`}}}))
+}
+
+// newTestCacheDito returns a Dito instance caching in an in-memory Redis server.
+func newTestCacheDito(t *testing.T) (*miniredis.Miniredis, *app.Dito) {
+	server, client := newTestRedis(t)
+	dito := &app.Dito{Config: &config.ProxyConfig{}, RedisClient: client, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	return server, dito
+}
+
+// encodedSize returns the size accounted for a cache entry with the given body.
+func encodedSize(t *testing.T, body string) int64 {
+	data, err := encodeCacheEntry(cacheEntry{StatusCode: http.StatusOK, Body: []byte(body)})
+	assert.NoError(t, err)
+	return int64(len(data))
+}
+
+// storeTestEntry stores an entry with the given body in the bounded cache of the "/items" location.
+func storeTestEntry(t *testing.T, dito *app.Dito, key, body string, ttl time.Duration, maxSize int64) (int64, int64) {
+	entry := cacheEntry{StatusCode: http.StatusOK, Body: []byte(body)}
+	total, evicted, err := storeBoundedCacheEntry(dito, "/items", cacheHashTag("/items")+key, entry, ttl, maxSize)
+	assert.NoError(t, err)
+	return total, evicted
+}
+
+// TestStoreBoundedCacheEntrySize verifies that the total size of a bounded cache accounts each entry, and that
+// replacing an entry accounts only its new size.
+func TestStoreBoundedCacheEntrySize(t *testing.T) {
+	server, dito := newTestCacheDito(t)
+	tag := cacheHashTag("/items")
+
+	total, evicted := storeTestEntry(t, dito, "cache:a", "aaaa", time.Minute, 1<<20)
+	assert.Equal(t, encodedSize(t, "aaaa"), total)
+	assert.Zero(t, evicted)
+
+	total, _ = storeTestEntry(t, dito, "cache:b", "bb", time.Minute, 1<<20)
+	assert.Equal(t, encodedSize(t, "aaaa")+encodedSize(t, "bb"), total)
+
+	total, evicted = storeTestEntry(t, dito, "cache:a", "a", time.Minute, 1<<20)
+	assert.Equal(t, encodedSize(t, "a")+encodedSize(t, "bb"), total)
+	assert.Zero(t, evicted)
+	members, _ := server.ZMembers("cache_index:" + tag)
+	assert.ElementsMatch(t, []string{tag + "cache:a", tag + "cache:b"}, members)
+	entry, ok := loadCacheEntry(dito, tag+"cache:a")
+	assert.True(t, ok)
+	assert.Equal(t, "a", string(entry.Body))
+}
+
+// TestStoreBoundedCacheEntryEviction verifies that the entries closest to expiry are evicted first, and only
+// as many as needed to fit the limit.
+func TestStoreBoundedCacheEntryEviction(t *testing.T) {
+	server, dito := newTestCacheDito(t)
+	tag := cacheHashTag("/items")
+	limit := 3 * encodedSize(t, "xxxx")
+
+	storeTestEntry(t, dito, "cache:long", "xxxx", 3*time.Minute, limit)
+	storeTestEntry(t, dito, "cache:short", "xxxx", time.Minute, limit)
+	storeTestEntry(t, dito, "cache:medium", "xxxx", 2*time.Minute, limit)
+
+	total, evicted := storeTestEntry(t, dito, "cache:new", "xxxx", 4*time.Minute, limit)
+	assert.Equal(t, int64(1), evicted)
+	assert.Equal(t, limit, total)
+	assert.False(t, server.Exists(tag+"cache:short"))
+	assert.True(t, server.Exists(tag+"cache:medium"))
+
+	total, evicted = storeTestEntry(t, dito, "cache:large", strings.Repeat("x", 12), 5*time.Minute, limit)
+	assert.Equal(t, int64(2), evicted)
+	assert.LessOrEqual(t, total, limit)
+	assert.False(t, server.Exists(tag+"cache:medium"))
+	assert.False(t, server.Exists(tag+"cache:long"))
+	assert.True(t, server.Exists(tag+"cache:new"))
+	members, _ := server.ZMembers("cache_index:" + tag)
+	assert.ElementsMatch(t, []string{tag + "cache:new", tag + "cache:large"}, members)
+}
+
+// TestStoreBoundedCacheEntryTooLarge verifies that an entry larger than the whole limit is stored without
+// evicting entries closer to expiry, since it is the one evicted first.
+func TestStoreBoundedCacheEntryTooLarge(t *testing.T) {
+	server, dito := newTestCacheDito(t)
+	tag := cacheHashTag("/items")
+	limit := encodedSize(t, "xxxx")
+
+	storeTestEntry(t, dito, "cache:old", "xxxx", time.Minute, limit)
+	_, evicted := storeTestEntry(t, dito, "cache:big", strings.Repeat("x", 64), 30*time.Second, limit)
+	assert.Zero(t, evicted)
+	assert.True(t, server.Exists(tag+"cache:old"))
+}
+
+// TestCacheMiddlewareMaxEntrySize verifies that responses above the per-entry size limit are served but not
+// cached, and that the entries of a bounded cache carry the hash tag of their location.
+func TestCacheMiddlewareMaxEntrySize(t *testing.T) {
+	server, dito := newTestCacheDito(t)
+	tag := cacheHashTag("/items")
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", len(r.URL.Query().Get("size"))))
+	})
+	cacheConfig := config.Cache{Enabled: true, TTL: 60, MaxEntrySize: 8, MaxSize: 1 << 20}
+	handler := CacheMiddleware(next, dito, "/items", cacheConfig, writer.NewPool())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/items?size=0123456789", nil))
+	assert.Equal(t, strings.Repeat("x", 10), rec.Body.String())
+	assert.Empty(t, server.Keys())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/items?size=0123", nil))
+	assert.Equal(t, "xxxx", rec.Body.String())
+	members, _ := server.ZMembers("cache_index:" + tag)
+	assert.Equal(t, []string{tag + "cache:GET:/items?size=0123:identity"}, members)
+	assert.True(t, server.Exists(members[0]))
+}
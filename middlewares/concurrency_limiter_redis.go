@@ -0,0 +1,156 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/rand"
+	"dito/audit"
+	"dito/config"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	concurrencyLimiterKeyPrefix = "concurrency_limiter:"
+	// defaultSlotTimeout is how long an unreleased slot is held when no slot timeout is configured.
+	defaultSlotTimeout = 30 * time.Second
+)
+
+// acquireSlotScript atomically evicts the expired slots of a key and takes a new slot if one is free.
+// Slots are the members of a sorted set scored by their expiry time, taken from the Redis clock so
+// that the instances do not need synchronized clocks.
+//
+// KEYS[1]: the slots key. ARGV[1]: the maximum number of slots. ARGV[2]: the slot ID. ARGV[3]: the slot timeout in ms.
+var acquireSlotScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], now + tonumber(ARGV[3]), ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return 1
+`)
+
+// renewSlotScript pushes back the expiry of a slot still in flight, from the Redis clock. A slot already
+// evicted is not taken again.
+//
+// KEYS[1]: the slots key. ARGV[1]: the slot ID. ARGV[2]: the slot timeout in ms.
+var renewSlotScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+if redis.call('ZADD', KEYS[1], 'XX', 'CH', now + tonumber(ARGV[2]), ARGV[1]) == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// ConcurrencyLimiterMiddlewareWithRedis is an HTTP middleware that caps the number of requests in flight at the
+// same time, across all the instances sharing the Redis server. Each request holds a slot until it completes,
+// renewed every third of the slot timeout while the request runs, so that long requests keep counting; slots
+// left behind by an instance that stopped abruptly expire after the slot timeout.
+//
+// Parameters:
+// - next: The next http.Handler to be called if a slot is available.
+// - location: The key of the location, used to namespace the Redis keys.
+// - limitConfig: The configuration for the concurrency limit.
+// - redisClient: The Redis client used to store the slots.
+// - logger: The logger used to log messages.
+//
+// Returns:
+// - http.Handler: A handler that applies the concurrency limit.
+func ConcurrencyLimiterMiddlewareWithRedis(next http.Handler, location string, limitConfig config.ConcurrencyLimit, redisClient *redis.Client, logger *slog.Logger) http.Handler {
+	middlewareType := "ConcurrencyLimiterMiddlewareWithRedis"
+	if !limitConfig.Enabled || limitConfig.MaxInFlight <= 0 {
+		logger.Debug(fmt.Sprintf("[%s] Concurrency limiting is disabled", middlewareType))
+		return next
+	}
+
+	slotTimeout := limitConfig.SlotTimeout
+	if slotTimeout <= 0 {
+		slotTimeout = defaultSlotTimeout
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := concurrencyLimiterKeyPrefix + location
+		ip := getClientIP(r, logger, middlewareType)
+		if limitConfig.PerClient {
+			key += ":" + ip
+		}
+
+		slot := newSlotID()
+		acquired, err := acquireSlotScript.Run(r.Context(), redisClient, []string{key}, limitConfig.MaxInFlight, slot, slotTimeout.Milliseconds()).Int()
+		if err != nil {
+			logger.Error(fmt.Sprintf("[%s] Error acquiring a concurrency slot for %s: %v", middlewareType, key, err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if acquired == 0 {
+			logger.Debug(fmt.Sprintf("[%s] Concurrency limit of %d reached for %s", middlewareType, limitConfig.MaxInFlight, key))
+			auditRequest(r, audit.EventRateLimitBlock, map[string]string{"limiter": "concurrency", "client_ip": ip, "path": r.URL.Path})
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+
+		stop := renewSlot(key, slot, slotTimeout, redisClient, logger)
+		defer func() {
+			stop()
+			// Release the slot even if the client went away and canceled the request context, or the handler
+			// panicked.
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := redisClient.ZRem(ctx, key, slot).Err(); err != nil {
+				logger.Warn(fmt.Sprintf("[%s] Failed to release concurrency slot for %s, it expires in %v: %v", middlewareType, key, slotTimeout, err))
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// renewSlot renews a slot every third of the slot timeout until it is stopped.
+//
+// Parameters:
+// - key: The slots key.
+// - slot: The slot ID.
+// - slotTimeout: The slot timeout.
+// - redisClient: The Redis client used to store the slots.
+// - logger: The logger used to log messages.
+//
+// Returns:
+// - func(): A function stopping the renewal. A renewal racing with the release does not take the slot back.
+func renewSlot(key, slot string, slotTimeout time.Duration, redisClient *redis.Client, logger *slog.Logger) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(slotTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				err := renewSlotScript.Run(ctx, redisClient, []string{key}, slot, slotTimeout.Milliseconds()).Err()
+				cancel()
+				if err != nil {
+					logger.Warn(fmt.Sprintf("[ConcurrencyLimiterMiddlewareWithRedis] Failed to renew concurrency slot for %s: %v", key, err))
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// newSlotID generates a random identifier for a concurrency slot.
+func newSlotID() string {
+	var id [12]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
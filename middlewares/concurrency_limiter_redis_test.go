@@ -0,0 +1,119 @@
+package middlewares
+
+import (
+	"dito/config"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestRedis starts an in-memory Redis server, closed at the end of the test.
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+// TestConcurrencyLimiterWithRedis verifies that a request takes a slot for its duration, that requests above
+// the limit are rejected meanwhile, and that the slot is released once the request completes.
+func TestConcurrencyLimiterWithRedis(t *testing.T) {
+	server, client := newTestRedis(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release })
+	handler := ConcurrencyLimiterMiddlewareWithRedis(next, "/slow", config.ConcurrencyLimit{Enabled: true, MaxInFlight: 1}, client, logger)
+	key := concurrencyLimiterKeyPrefix + "/slow"
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/slow", nil))
+		done <- rec.Code
+	}()
+	assert.Eventually(t, func() bool { n, _ := server.ZMembers(key); return len(n) == 1 }, time.Second, 5*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/slow", nil))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+	members, _ := server.ZMembers(key)
+	assert.Empty(t, members)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/slow", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// TestConcurrencyLimiterWithRedisReleaseOnPanic verifies that the slot of a request whose handler panics is
+// released.
+func TestConcurrencyLimiterWithRedisReleaseOnPanic(t *testing.T) {
+	server, client := newTestRedis(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) })
+	handler := ConcurrencyLimiterMiddlewareWithRedis(next, "/panic", config.ConcurrencyLimit{Enabled: true, MaxInFlight: 1}, client, logger)
+
+	assert.Panics(t, func() { handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil)) })
+	members, _ := server.ZMembers(concurrencyLimiterKeyPrefix + "/panic")
+	assert.Empty(t, members)
+}
+
+// TestConcurrencyLimiterWithRedisExpiry verifies that a slot left behind, e.g. by a crashed instance, stops
+// counting once the slot timeout elapsed.
+func TestConcurrencyLimiterWithRedisExpiry(t *testing.T) {
+	server, client := newTestRedis(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	now := time.Now()
+	server.SetTime(now)
+	limitConfig := config.ConcurrencyLimit{Enabled: true, MaxInFlight: 1, SlotTimeout: 10 * time.Second}
+	handler := ConcurrencyLimiterMiddlewareWithRedis(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "/crashed", limitConfig, client, logger)
+	key := concurrencyLimiterKeyPrefix + "/crashed"
+
+	_, err := server.ZAdd(key, float64(now.Add(limitConfig.SlotTimeout).UnixMilli()), "abandoned")
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/crashed", nil))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	server.SetTime(now.Add(limitConfig.SlotTimeout + time.Second))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/crashed", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// TestConcurrencyLimiterWithRedisRenewal verifies that a request running longer than the slot timeout keeps its
+// slot, so that the limit holds.
+func TestConcurrencyLimiterWithRedisRenewal(t *testing.T) {
+	_, client := newTestRedis(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release })
+	limitConfig := config.ConcurrencyLimit{Enabled: true, MaxInFlight: 1, SlotTimeout: 150 * time.Millisecond}
+	handler := ConcurrencyLimiterMiddlewareWithRedis(next, "/long", limitConfig, client, logger)
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/long", nil))
+		close(done)
+	}()
+
+	// The first request outlives several slot timeouts.
+	time.Sleep(500 * time.Millisecond)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/long", nil))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	close(release)
+	<-done
+}
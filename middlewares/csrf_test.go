@@ -0,0 +1,63 @@
+package middlewares
+
+import (
+	"dito/app"
+	"dito/config"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCSRFMiddleware verifies that a token cookie is issued to new clients, that unsafe requests must echo it in
+// the token header, and that exempt paths are not checked.
+func TestCSRFMiddleware(t *testing.T) {
+	dito := &app.Dito{Config: &config.ProxyConfig{}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	csrf := config.CSRF{
+		Enabled:             true,
+		CookieName:          config.DefaultCSRFCookieName,
+		HeaderName:          config.DefaultCSRFHeaderName,
+		SameSite:            config.SameSiteLax,
+		CompiledExemptPaths: []*regexp.Regexp{regexp.MustCompile("^/hooks/")},
+	}
+	handler := CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), dito, csrf)
+
+	serve := func(method, path string, cookie *http.Cookie, header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if header != "" {
+			req.Header.Set(config.DefaultCSRFHeaderName, header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("GET", "/app", nil, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	cookies := rec.Result().Cookies()
+	if !assert.Len(t, cookies, 1) {
+		return
+	}
+	token := cookies[0]
+	assert.Equal(t, config.DefaultCSRFCookieName, token.Name)
+	assert.False(t, token.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, token.SameSite)
+
+	rec = serve("GET", "/app", token, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Result().Cookies(), "a valid token is kept")
+
+	assert.Equal(t, http.StatusOK, serve("POST", "/app", token, token.Value).Code)
+	assert.Equal(t, http.StatusForbidden, serve("POST", "/app", token, "").Code)
+	assert.Equal(t, http.StatusForbidden, serve("DELETE", "/app", token, token.Value+"x").Code)
+	assert.Equal(t, http.StatusForbidden, serve("POST", "/app", nil, token.Value).Code)
+	assert.Equal(t, http.StatusForbidden, serve("POST", "/app", &http.Cookie{Name: token.Name, Value: "weak"}, "weak").Code)
+	assert.Equal(t, http.StatusOK, serve("POST", "/hooks/github", nil, "").Code)
+}
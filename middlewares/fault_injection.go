@@ -0,0 +1,80 @@
+package middlewares
+
+import (
+	"dito/app"
+	"dito/config"
+	"dito/metrics"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+)
+
+// Faults injected by the fault injection middleware.
+const (
+	faultDelay  = "delay"
+	faultStatus = "status"
+	faultAbort  = "abort"
+)
+
+// FaultInjectionMiddleware injects faults into a share of the requests, to test how clients handle slow or
+// failing upstreams: a delay before the request is forwarded, then, for the aborted requests, a status code
+// answered without reaching the upstream, or a connection closed without a response.
+//
+// Parameters:
+// - next: The next http.Handler to be called if no failure is injected.
+// - dito: The Dito application instance containing the configuration and logger.
+// - location: The label of the location (its name or path), used to label the metrics.
+// - faults: The fault injection configuration of the location.
+//
+// Returns:
+// - http.Handler: A handler that injects the faults.
+func FaultInjectionMiddleware(next http.Handler, dito *app.Dito, location string, faults config.FaultInjection) http.Handler {
+	middlewareType := "FaultInjectionMiddleware"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if injectFault(faults.Delay.Percentage) {
+			dito.Logger.Debug(fmt.Sprintf("[%s] Delaying %s %s by %s", middlewareType, r.Method, r.URL.Path, faults.Delay.Duration))
+			recordFault(dito, location, faultDelay)
+			if !sleep(r.Context(), faults.Delay.Duration) {
+				return
+			}
+		}
+
+		if !injectFault(faults.Abort.Percentage) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if faults.Abort.Status == 0 {
+			dito.Logger.Debug(fmt.Sprintf("[%s] Closing the connection of %s %s", middlewareType, r.Method, r.URL.Path))
+			recordFault(dito, location, faultAbort)
+			// The server closes the connection without a response, and does not log the panic.
+			panic(http.ErrAbortHandler)
+		}
+		dito.Logger.Debug(fmt.Sprintf("[%s] Answering %s %s with %d", middlewareType, r.Method, r.URL.Path, faults.Abort.Status))
+		recordFault(dito, location, faultStatus)
+		http.Error(w, http.StatusText(faults.Abort.Status), faults.Abort.Status)
+	})
+}
+
+// injectFault draws whether a fault is injected into a request.
+//
+// Parameters:
+// - percentage: The percentage of the requests receiving the fault.
+//
+// Returns:
+// - bool: True if the fault is injected.
+func injectFault(percentage float64) bool {
+	return percentage > 0 && rand.Float64()*100 < percentage
+}
+
+// recordFault records an injected fault in the metrics, when they are enabled.
+//
+// Parameters:
+// - dito: The Dito application instance containing the configuration.
+// - location: The label of the location.
+// - fault: The injected fault (delay, status, or abort).
+func recordFault(dito *app.Dito, location, fault string) {
+	if dito.Config.Metrics.Enabled {
+		metrics.RecordFaultInjection(location, fault)
+	}
+}
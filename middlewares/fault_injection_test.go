@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"dito/app"
+	"dito/config"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFaultInjectionMiddleware verifies that requests are delayed, answered with the abort status, or have their
+// connection closed according to the configured faults, and that requests without faults reach the upstream.
+func TestFaultInjectionMiddleware(t *testing.T) {
+	dito := &app.Dito{Config: &config.ProxyConfig{}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	serve := func(faults config.FaultInjection) (*httptest.ResponseRecorder, time.Duration) {
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		FaultInjectionMiddleware(next, dito, "/chaos", faults).ServeHTTP(rec, httptest.NewRequest("GET", "/chaos", nil))
+		return rec, time.Since(start)
+	}
+
+	rec, elapsed := serve(config.FaultInjection{Enabled: true})
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Less(t, elapsed, 50*time.Millisecond)
+
+	rec, elapsed = serve(config.FaultInjection{Enabled: true, Delay: config.FaultDelay{Percentage: 100, Duration: 50 * time.Millisecond}})
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.GreaterOrEqual(t, elapsed, 50*time.Millisecond)
+
+	rec, _ = serve(config.FaultInjection{Enabled: true, Abort: config.FaultAbort{Percentage: 100, Status: http.StatusServiceUnavailable}})
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		serve(config.FaultInjection{Enabled: true, Abort: config.FaultAbort{Percentage: 100}})
+	})
+}
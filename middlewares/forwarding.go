@@ -0,0 +1,126 @@
+package middlewares
+
+import (
+	"dito/app"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// RealIPHeader is the header carrying the resolved client address to the upstream.
+const RealIPHeader = "X-Real-IP"
+
+// ForwardingMiddleware strips the configured internal headers from inbound requests, so that clients cannot
+// spoof headers the upstreams trust, and sets X-Real-IP to the client address resolved through the trusted proxies.
+// With GeoIP enabled, the location of the client is resolved from that address as well. It runs before the
+// location middlewares, which may set internal headers themselves.
+//
+// Parameters:
+// - next: The next HTTP handler in the chain.
+// - dito: The Dito application instance.
+//
+// Returns:
+// - http.Handler: The HTTP handler with the forwarding headers applied.
+func ForwardingMiddleware(next http.Handler, dito *app.Dito) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarding := dito.Config.Forwarding
+		stripHeaders(r.Header, forwarding.InternalHeaders)
+		ip := ClientIP(r, forwarding.CompiledTrustedProxies)
+		if ip != "" {
+			r.Header.Set(RealIPHeader, ip)
+		} else {
+			r.Header.Del(RealIPHeader)
+		}
+		if geoIP := dito.Config.GeoIP; geoIP.DB != nil {
+			r = resolveGeoLocation(r, geoIP, ip)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// stripHeaders removes the headers matching the patterns: exact names, or prefixes ending with "*".
+func stripHeaders(header http.Header, patterns []string) {
+	for _, pattern := range patterns {
+		prefix, isPrefix := strings.CutSuffix(pattern, "*")
+		if !isPrefix {
+			header.Del(pattern)
+			continue
+		}
+		prefix = http.CanonicalHeaderKey(prefix)
+		for name := range header {
+			if strings.HasPrefix(name, prefix) {
+				delete(header, name)
+			}
+		}
+	}
+}
+
+// ClientIP resolves the address of the client. When the peer is a trusted proxy, the X-Forwarded-For entries are
+// walked from the right, skipping the trusted proxies, and the first other address is the client.
+//
+// Parameters:
+// - r: The HTTP request.
+// - trusted: The ranges of the trusted proxies.
+//
+// Returns:
+// - string: The client address, or an empty string if the peer address cannot be parsed.
+func ClientIP(r *http.Request, trusted []netip.Prefix) string {
+	client, ok := parseIP(r.RemoteAddr)
+	if !ok {
+		return ""
+	}
+	if !isTrusted(client, trusted) {
+		return client.String()
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, ok := parseIP(forwarded[i])
+		if !ok {
+			// A malformed entry cannot be trusted further: the last valid hop is the client.
+			break
+		}
+		client = addr
+		if !isTrusted(client, trusted) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// parseIP parses a client address as found in RemoteAddr or in X-Forwarded-For, with or without a port, brackets,
+// or an IPv6 zone (e.g. 192.0.2.1:8080, [2001:db8::1]:443, fe80::1%eth0). IPv4-mapped addresses are unmapped and
+// zones dropped, so that every form of an address identifies the same client.
+//
+// Parameters:
+// - value: The address.
+//
+// Returns:
+// - netip.Addr: The client address.
+// - bool: False if the value is not an IP address.
+func parseIP(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr().Unmap().WithZone(""), true
+	}
+	if inner, bracketed := strings.CutPrefix(value, "["); bracketed {
+		if value, bracketed = strings.CutSuffix(inner, "]"); !bracketed {
+			return netip.Addr{}, false
+		}
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// isTrusted reports whether an address belongs to a trusted proxy.
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestClientIP verifies that X-Forwarded-For is only trusted through the trusted proxies.
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	request := func(remoteAddr string, forwarded ...string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		for _, value := range forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		return r
+	}
+
+	// A direct client cannot spoof its address.
+	assert.Equal(t, "203.0.113.7", ClientIP(request("203.0.113.7:4321", "1.2.3.4"), trusted))
+	// Through trusted proxies, the rightmost untrusted entry is the client.
+	assert.Equal(t, "198.51.100.2", ClientIP(request("10.0.0.1:4321", "1.2.3.4, 198.51.100.2", "10.0.0.2"), trusted))
+	// When every hop is trusted, the leftmost one is the client.
+	assert.Equal(t, "10.0.0.3", ClientIP(request("10.0.0.1:4321", "10.0.0.3"), trusted))
+	assert.Equal(t, "10.0.0.1", ClientIP(request("10.0.0.1:4321", "garbage"), trusted))
+	assert.Equal(t, "2001:db8::1", ClientIP(request("[2001:db8::1]:4321"), trusted))
+	assert.Equal(t, "2001:db8::1", ClientIP(request("10.0.0.1:4321", "[2001:db8::1]:443"), trusted))
+	assert.Equal(t, "fe80::1", ClientIP(request("[fe80::1%eth0]:4321"), trusted))
+	assert.Equal(t, "198.51.100.2", ClientIP(request("[::ffff:10.0.0.1]:4321", "198.51.100.2"), trusted))
+}
+
+// TestParseIP verifies the parsing of client addresses with ports, brackets, zones, and IPv4-mapped forms.
+func TestParseIP(t *testing.T) {
+	for value, expected := range map[string]string{
+		"192.0.2.1":                "192.0.2.1",
+		"192.0.2.1:8080":           "192.0.2.1",
+		" 2001:db8::1 ":            "2001:db8::1",
+		"[2001:db8::1]":            "2001:db8::1",
+		"[2001:DB8:0::1]:443":      "2001:db8::1",
+		"fe80::1%eth0":             "fe80::1",
+		"[fe80::1%eth0]:443":       "fe80::1",
+		"::ffff:192.0.2.1":         "192.0.2.1",
+		"[::ffff:192.0.2.1]:51260": "192.0.2.1",
+	} {
+		addr, ok := parseIP(value)
+		if assert.True(t, ok, value) {
+			assert.Equal(t, expected, addr.String(), value)
+		}
+	}
+	for _, value := range []string{"", "unknown", "2001:db8::1:443:x", "[2001:db8::1"} {
+		_, ok := parseIP(value)
+		assert.False(t, ok, value)
+	}
+}
+
+// TestStripHeaders verifies that internal headers are stripped by name and prefix.
+func TestStripHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-User-Id", "admin")
+	header.Set("X-Internal-Role", "root")
+	header.Set("X-Internal-Tenant", "acme")
+	header.Set("X-Request-ID", "abc")
+
+	stripHeaders(header, []string{"x-user-id", "X-Internal-*"})
+	assert.Equal(t, http.Header{"X-Request-Id": {"abc"}}, header)
+}
@@ -0,0 +1,81 @@
+package middlewares
+
+import (
+	"dito/app"
+	"dito/audit"
+	"dito/config"
+	"dito/geoip"
+	"dito/metrics"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+)
+
+// GeoMiddleware rejects with 403 the clients whose country the rules of the location do not allow. The country
+// is the one resolved by the GeoIP databases from the client address.
+//
+// Parameters:
+// - next: The next http.Handler to be called if the client is allowed.
+// - dito: The Dito application instance containing the configuration and logger.
+// - geo: The country rules of the location.
+//
+// Returns:
+// - http.Handler: A handler that enforces the country rules.
+func GeoMiddleware(next http.Handler, dito *app.Dito, geo config.GeoRules) http.Handler {
+	middlewareType := "GeoMiddleware"
+	if len(geo.AllowCountries) == 0 && len(geo.DenyCountries) == 0 {
+		dito.Logger.Debug(fmt.Sprintf("[%s] No country rules", middlewareType))
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		location, _ := geoip.FromContext(r.Context())
+		if !geo.Allows(location.Country) {
+			country := location.Country
+			if country == "" {
+				country = "unknown"
+			}
+			dito.Logger.Warn(fmt.Sprintf("[%s] Blocked %s %s from %s: country %s", middlewareType, r.Method, r.URL.Path, r.RemoteAddr, country))
+			if dito.Config.Metrics.Enabled {
+				metrics.RecordSecurityBlock("geo_blocked")
+			}
+			auditRequest(r, audit.EventSecurityBlock, map[string]string{"reason": "geo_blocked", "country": country, "path": r.URL.Path})
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// resolveGeoLocation attaches the location of the client to a request, and sets the configured GeoIP headers,
+// replacing the values sent by the client.
+//
+// Parameters:
+// - r: The HTTP request.
+// - geoIP: The GeoIP configuration, with its databases opened.
+// - ip: The client address, empty if it could not be resolved.
+//
+// Returns:
+// - *http.Request: The request carrying the location.
+func resolveGeoLocation(r *http.Request, geoIP config.GeoIPConfig, ip string) *http.Request {
+	if geoIP.CountryHeader != "" {
+		r.Header.Del(geoIP.CountryHeader)
+	}
+	if geoIP.ASNHeader != "" {
+		r.Header.Del(geoIP.ASNHeader)
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return r
+	}
+
+	location := geoIP.DB.Lookup(addr)
+	if geoIP.CountryHeader != "" && location.Country != "" {
+		r.Header.Set(geoIP.CountryHeader, location.Country)
+	}
+	if geoIP.ASNHeader != "" && location.ASN != 0 {
+		r.Header.Set(geoIP.ASNHeader, strconv.FormatUint(uint64(location.ASN), 10))
+	}
+	return r.WithContext(geoip.WithLocation(r.Context(), location))
+}
@@ -0,0 +1,58 @@
+package middlewares
+
+import (
+	"dito/app"
+	"dito/config"
+	"dito/geoip"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGeoMiddleware verifies that the clients of the countries the rules do not allow are rejected.
+func TestGeoMiddleware(t *testing.T) {
+	dito := &app.Dito{Config: &config.ProxyConfig{}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := GeoMiddleware(next, dito, config.GeoRules{AllowCountries: []string{"IT", "FR"}, DenyCountries: []string{"FR"}})
+
+	serve := func(country string, resolved bool) int {
+		r := httptest.NewRequest(http.MethodGet, "/shop", nil)
+		if resolved {
+			r = r.WithContext(geoip.WithLocation(r.Context(), geoip.Location{Country: country}))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusNoContent, serve("IT", true))
+	assert.Equal(t, http.StatusForbidden, serve("FR", true))
+	assert.Equal(t, http.StatusForbidden, serve("US", true))
+	assert.Equal(t, http.StatusForbidden, serve("", true))
+	assert.Equal(t, http.StatusForbidden, serve("", false))
+}
+
+// TestResolveGeoLocation verifies that the GeoIP headers sent by clients are replaced, and that the location of
+// the client travels in the request context.
+func TestResolveGeoLocation(t *testing.T) {
+	geoIP := config.GeoIPConfig{CountryHeader: "X-Country", ASNHeader: "X-ASN", DB: geoip.NewDB(nil, nil)}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Country", "IT")
+	r.Header.Set("X-ASN", "64500")
+
+	r = resolveGeoLocation(r, geoIP, "203.0.113.7")
+	assert.Empty(t, r.Header.Get("X-Country"))
+	assert.Empty(t, r.Header.Get("X-ASN"))
+	location, ok := geoip.FromContext(r.Context())
+	assert.True(t, ok)
+	assert.Equal(t, geoip.Location{}, location)
+
+	_, ok = geoip.FromContext(resolveGeoLocation(httptest.NewRequest(http.MethodGet, "/", nil), geoIP, "").Context())
+	assert.False(t, ok)
+}
@@ -0,0 +1,126 @@
+package middlewares
+
+import (
+	"dito/app"
+	"dito/config"
+	"dito/graphql"
+	"dito/logging"
+	"dito/metrics"
+	"dito/websocket"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// defaultGraphQLMaxBodySize is the largest GraphQL request body read by default.
+const defaultGraphQLMaxBodySize = 1 << 20
+
+// GraphQLMiddleware parses the GraphQL requests of a location: it labels the access log and the metrics with
+// their operation names, and rejects with 400 the invalid operations, the ones above the depth or complexity
+// limits, the batches above the complexity limit together, and introspection when it is blocked. WebSocket sessions, such as subscriptions, are not parsed.
+//
+// Parameters:
+// - next: The next http.Handler to be called if the operations are allowed.
+// - dito: The Dito application instance containing the configuration and logger.
+// - location: The label of the location (its name or path), used to label the metrics.
+// - graphqlConfig: The GraphQL configuration of the location.
+//
+// Returns:
+// - http.Handler: A handler that analyzes the GraphQL operations.
+func GraphQLMiddleware(next http.Handler, dito *app.Dito, location string, graphqlConfig config.GraphQL) http.Handler {
+	middlewareType := "GraphQLMiddleware"
+	if !graphqlConfig.Enabled {
+		dito.Logger.Debug(fmt.Sprintf("[%s] GraphQL mode is disabled", middlewareType))
+		return next
+	}
+	maxBodySize := graphqlConfig.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultGraphQLMaxBodySize
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests carrying no operation, such as the page of a GraphQL IDE or CORS preflights, pass through.
+		if websocket.IsWebSocketRequest(r) || (r.Method != http.MethodPost && (r.Method != http.MethodGet || !r.URL.Query().Has("query"))) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reject := func(status int, reason, message string) {
+			dito.Logger.Debug(fmt.Sprintf("[%s] Rejected GraphQL request on %s: %s", middlewareType, location, message))
+			if dito.Config.Metrics.Enabled {
+				metrics.RecordGraphQLRejected(location, reason)
+			}
+			writeGraphQLError(w, status, message)
+		}
+
+		body, err := bufferBody(r, maxBodySize)
+		if errors.Is(err, errBodyTooLarge) {
+			reject(http.StatusRequestEntityTooLarge, "too_large", "request body too large")
+			return
+		} else if err != nil {
+			reject(http.StatusBadRequest, "invalid", fmt.Sprintf("error reading the request body: %v", err))
+			return
+		}
+		requests, err := graphql.ReadRequests(r, body)
+		if err != nil {
+			reject(http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+
+		operations := make([]graphql.Operation, 0, len(requests))
+		complexity := 0
+		for _, request := range requests {
+			operation, err := graphql.Analyze(request.Query, request.OperationName)
+			switch {
+			case err != nil:
+				reject(http.StatusBadRequest, "invalid", err.Error())
+				return
+			case graphqlConfig.BlockIntrospection && operation.Introspection:
+				reject(http.StatusBadRequest, "introspection", "introspection is disabled")
+				return
+			case graphqlConfig.MaxDepth > 0 && operation.Depth > graphqlConfig.MaxDepth:
+				reject(http.StatusBadRequest, "depth", fmt.Sprintf("query depth %d exceeds the maximum of %d", operation.Depth, graphqlConfig.MaxDepth))
+				return
+			case graphqlConfig.MaxComplexity > 0 && operation.Complexity > graphqlConfig.MaxComplexity:
+				reject(http.StatusBadRequest, "complexity", fmt.Sprintf("query complexity %d exceeds the maximum of %d", operation.Complexity, graphqlConfig.MaxComplexity))
+				return
+			}
+			operations = append(operations, operation)
+			complexity += operation.Complexity
+		}
+		// A batch costs the upstream as much as its operations together.
+		if graphqlConfig.MaxComplexity > 0 && complexity > graphqlConfig.MaxComplexity {
+			reject(http.StatusBadRequest, "complexity", fmt.Sprintf("batch complexity %d exceeds the maximum of %d", complexity, graphqlConfig.MaxComplexity))
+			return
+		}
+
+		names := make([]string, len(operations))
+		for i, operation := range operations {
+			names[i] = operation.Name
+			if names[i] == "" {
+				names[i] = "anonymous"
+			}
+			if dito.Config.Metrics.Enabled {
+				metrics.RecordGraphQLOperation(location, operation.Name, operation.Type)
+			}
+		}
+		if info := logging.RequestInfoFrom(r.Context()); info != nil {
+			info.GraphQLOperation = strings.Join(names, ",")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeGraphQLError answers a GraphQL request with an error in the GraphQL response format.
+//
+// Parameters:
+// - w: The HTTP response writer.
+// - status: The HTTP status code.
+// - message: The error message.
+func writeGraphQLError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"errors": []map[string]string{{"message": message}}})
+}
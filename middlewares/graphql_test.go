@@ -0,0 +1,70 @@
+package middlewares
+
+import (
+	"dito/app"
+	"dito/config"
+	"dito/logging"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGraphQLMiddleware verifies that operations above the limits and blocked introspection are rejected with a
+// GraphQL error, and that allowed operations reach the next handler with their names recorded in the request info.
+func TestGraphQLMiddleware(t *testing.T) {
+	dito := &app.Dito{Config: &config.ProxyConfig{}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	graphqlConfig := config.GraphQL{Enabled: true, MaxDepth: 3, MaxComplexity: 5, BlockIntrospection: true, MaxBodySize: 256}
+
+	var received string
+	handler := GraphQLMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+	}), dito, "/graphql", graphqlConfig)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedError  string
+		expectedInfo   string
+	}{
+		{name: "allowed", body: `{"query":"query Me { me { id } }"}`, expectedStatus: http.StatusOK, expectedInfo: "Me"},
+		{name: "batch", body: `[{"query":"query A { a }"},{"query":"{ b }"}]`, expectedStatus: http.StatusOK, expectedInfo: "A,anonymous"},
+		{name: "too deep", body: `{"query":"{ a { b { c { d } } } }"}`, expectedStatus: http.StatusBadRequest, expectedError: "query depth 4 exceeds the maximum of 3"},
+		{name: "batch too complex", body: `[{"query":"{ a b c }"},{"query":"{ d e f }"}]`, expectedStatus: http.StatusBadRequest, expectedError: "batch complexity 6 exceeds the maximum of 5"},
+		{name: "too complex", body: `{"query":"{ a b c d e f }"}`, expectedStatus: http.StatusBadRequest, expectedError: "query complexity 6 exceeds the maximum of 5"},
+		{name: "introspection", body: `{"query":"{ __schema { types { name } } }"}`, expectedStatus: http.StatusBadRequest, expectedError: "introspection is disabled"},
+		{name: "invalid", body: `{"query":"{ a "}`, expectedStatus: http.StatusBadRequest, expectedError: "invalid query"},
+		{name: "too large", body: `{"query":"{ ` + strings.Repeat("a ", 200) + `}"}`, expectedStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received = ""
+			req := httptest.NewRequest("POST", "/graphql", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req, info := logging.WithRequestInfo(req)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			if tt.expectedStatus == http.StatusOK {
+				assert.Equal(t, tt.body, received)
+				assert.Equal(t, tt.expectedInfo, info.GraphQLOperation)
+			} else {
+				assert.Empty(t, received)
+				assert.Contains(t, rec.Body.String(), tt.expectedError)
+			}
+		})
+	}
+
+	// Requests carrying no operation pass through.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/graphql", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
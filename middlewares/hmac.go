@@ -0,0 +1,195 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"dito/app"
+	"dito/audit"
+	"dito/config"
+	"dito/metrics"
+	"dito/signing"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Results of the HMAC signature verification, labeling the metrics.
+const (
+	hmacValid    = "valid"
+	hmacMissing  = "missing"
+	hmacInvalid  = "invalid"
+	hmacExpired  = "expired"
+	hmacReplayed = "replayed"
+)
+
+// hmacNoncePrefix prefixes the Redis keys remembering the nonces already seen.
+const hmacNoncePrefix = "hmac:nonce:"
+
+// HMACMiddleware verifies the HMAC signatures of the requests of a location, as sent by webhook providers: the
+// signature header must hold the HMAC of the body, preceded by the timestamp and the nonce when their headers
+// are configured, the timestamp must be within the allowed skew, and, with replay protection, the nonce (or
+// the signature itself) must not have been seen before. Other requests get 401, and bodies above the maximum
+// size get 413.
+//
+// Parameters:
+// - next: The next http.Handler to be called if the signature is valid.
+// - dito: The Dito application instance containing the configuration, the Redis client, and the logger.
+// - location: The label of the location (its name or path), used to label the metrics and the nonces.
+// - hmacConfig: The HMAC verification configuration of the location.
+//
+// Returns:
+// - http.Handler: A handler that verifies the signatures.
+func HMACMiddleware(next http.Handler, dito *app.Dito, location string, hmacConfig config.HMACVerification) http.Handler {
+	middlewareType := "HMACMiddleware"
+	var redisClient *redis.Client
+	if dito.Config.Redis.Enabled {
+		redisClient = dito.RedisClient
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reject := func(result string) {
+			dito.Logger.Debug(fmt.Sprintf("[%s] Rejected %s %s from %s: %s signature", middlewareType, r.Method, r.URL.Path, r.RemoteAddr, result))
+			recordHMACVerification(dito, location, result)
+			auditRequest(r, audit.EventAuthFailure, map[string]string{"path": r.URL.Path, "reason": "hmac_" + result})
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
+
+		header := r.Header.Get(hmacConfig.Header)
+		if header == "" {
+			reject(hmacMissing)
+			return
+		}
+		signature, ok := decodeSignature(header, hmacConfig)
+		if !ok {
+			reject(hmacInvalid)
+			return
+		}
+
+		if r.ContentLength > hmacConfig.MaxBodySize {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, hmacConfig.MaxBodySize+1))
+			if err != nil {
+				http.Error(w, "Bad Request", http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > hmacConfig.MaxBodySize {
+				http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		timestamp := headerValue(r, hmacConfig.TimestampHeader)
+		nonce := headerValue(r, hmacConfig.NonceHeader)
+		if !hmac.Equal(signature, hmacSignature(hmacConfig, timestamp, nonce, body)) {
+			reject(hmacInvalid)
+			return
+		}
+		if hmacConfig.TimestampHeader != "" {
+			seconds, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				reject(hmacInvalid)
+				return
+			}
+			if skew := time.Since(time.Unix(seconds, 0)).Abs(); skew > hmacConfig.MaxSkew {
+				reject(hmacExpired)
+				return
+			}
+		}
+
+		if hmacConfig.ReplayProtection {
+			if redisClient == nil {
+				dito.Logger.Error(fmt.Sprintf("[%s] Replay protection of %s requires Redis", middlewareType, location))
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if nonce == "" {
+				nonce = hex.EncodeToString(signature)
+			}
+			sum := sha256.Sum256([]byte(nonce))
+			key := hmacNoncePrefix + location + ":" + hex.EncodeToString(sum[:])
+			// A nonce is remembered as long as its timestamp is accepted.
+			first, err := redisClient.SetNX(r.Context(), key, 1, 2*hmacConfig.MaxSkew).Result()
+			if err != nil {
+				dito.Logger.Error(fmt.Sprintf("[%s] Failed to record the nonce: %v", middlewareType, err))
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !first {
+				reject(hmacReplayed)
+				return
+			}
+		}
+
+		recordHMACVerification(dito, location, hmacValid)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeSignature decodes the signature of a request, after its prefix.
+//
+// Parameters:
+// - header: The value of the signature header.
+// - hmacConfig: The HMAC verification configuration.
+//
+// Returns:
+// - []byte: The signature.
+// - bool: False if the header lacks the prefix or the signature cannot be decoded.
+func decodeSignature(header string, hmacConfig config.HMACVerification) ([]byte, bool) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(header), hmacConfig.Prefix)
+	if !ok {
+		return nil, false
+	}
+	signature, err := signing.Decode(encoded, hmacConfig.Encoding)
+	return signature, err == nil
+}
+
+// hmacSignature computes the signature of a request: the HMAC of its body, preceded by the timestamp and the
+// nonce when their headers are configured, each followed by a dot.
+//
+// Parameters:
+// - hmacConfig: The HMAC verification configuration.
+// - timestamp: The value of the timestamp header.
+// - nonce: The value of the nonce header.
+// - body: The body of the request.
+//
+// Returns:
+// - []byte: The signature.
+func hmacSignature(hmacConfig config.HMACVerification, timestamp, nonce string, body []byte) []byte {
+	var fields []string
+	if hmacConfig.TimestampHeader != "" {
+		fields = append(fields, timestamp)
+	}
+	if hmacConfig.NonceHeader != "" {
+		fields = append(fields, nonce)
+	}
+	return signing.HMAC(hmacConfig.Algorithm, hmacConfig.Secret, body, fields...)
+}
+
+// headerValue returns the value of a header of a request, or an empty string if the header is not configured.
+func headerValue(r *http.Request, name string) string {
+	if name == "" {
+		return ""
+	}
+	return r.Header.Get(name)
+}
+
+// recordHMACVerification records the result of a signature verification if the metrics are enabled.
+func recordHMACVerification(dito *app.Dito, location, result string) {
+	if dito.Config.Metrics.Enabled {
+		metrics.RecordHMACVerification(location, result)
+	}
+}
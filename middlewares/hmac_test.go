@@ -0,0 +1,89 @@
+package middlewares
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"dito/app"
+	"dito/config"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHMACMiddleware verifies that requests with a valid signature of their timestamp and body reach the
+// upstream with their body intact, and that missing, invalid, and expired signatures are rejected.
+func TestHMACMiddleware(t *testing.T) {
+	dito := &app.Dito{Config: &config.ProxyConfig{}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	hmacConfig := config.HMACVerification{
+		Enabled:         true,
+		Secret:          "whsec",
+		Algorithm:       config.HMACAlgorithmSHA256,
+		Encoding:        config.HMACEncodingHex,
+		Header:          "X-Hub-Signature-256",
+		Prefix:          "sha256=",
+		TimestampHeader: "X-Timestamp",
+		MaxSkew:         time.Minute,
+		MaxBodySize:     1024,
+	}
+	var received string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+	})
+	handler := HMACMiddleware(next, dito, "/webhooks", hmacConfig)
+	sign := func(timestamp, body string) string {
+		mac := hmac.New(sha256.New, []byte("whsec"))
+		mac.Write([]byte(timestamp + "." + body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	serve := func(signature, timestamp, body string) int {
+		req := httptest.NewRequest("POST", "/webhooks", strings.NewReader(body))
+		req.Header.Set("X-Hub-Signature-256", signature)
+		req.Header.Set("X-Timestamp", timestamp)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	assert.Equal(t, http.StatusOK, serve(sign(now, `{"event":"push"}`), now, `{"event":"push"}`))
+	assert.Equal(t, `{"event":"push"}`, received)
+
+	assert.Equal(t, http.StatusUnauthorized, serve("", now, `{"event":"push"}`))
+	assert.Equal(t, http.StatusUnauthorized, serve(sign(now, `{"event":"push"}`), now, `{"event":"delete"}`))
+	assert.Equal(t, http.StatusUnauthorized, serve(strings.TrimPrefix(sign(now, "{}"), "sha256="), now, "{}"))
+
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	assert.Equal(t, http.StatusUnauthorized, serve(sign(old, "{}"), old, "{}"))
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve(sign(now, "{}"), now, strings.Repeat("a", 2048)))
+
+	// Replay protection cannot work without Redis.
+	hmacConfig.ReplayProtection = true
+	handler = HMACMiddleware(next, dito, "/webhooks", hmacConfig)
+	assert.Equal(t, http.StatusInternalServerError, serve(sign(now, "{}"), now, "{}"))
+}
+
+// TestHMACSignature verifies the signed payload with a nonce, and the base64 encoding of the signatures.
+func TestHMACSignature(t *testing.T) {
+	hmacConfig := config.HMACVerification{Secret: "key", Algorithm: config.HMACAlgorithmSHA256, Encoding: config.HMACEncodingBase64, NonceHeader: "X-Nonce"}
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("n1.body"))
+	expected := mac.Sum(nil)
+
+	assert.Equal(t, expected, hmacSignature(hmacConfig, "", "n1", []byte("body")))
+	signature, ok := decodeSignature(base64.StdEncoding.EncodeToString(expected), hmacConfig)
+	assert.True(t, ok)
+	assert.Equal(t, expected, signature)
+	_, ok = decodeSignature("not base64!", hmacConfig)
+	assert.False(t, ok)
+}
@@ -0,0 +1,154 @@
+package middlewares
+
+import (
+	"context"
+	"dito/app"
+	"dito/audit"
+	"dito/config"
+	"dito/metrics"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// honeypotDripInterval is the time between the chunks of a junk response.
+const honeypotDripInterval = time.Second
+
+// trappedRequests is the number of requests held by the honeypot.
+var trappedRequests atomic.Int64
+
+// junkAlphabet holds the characters of the junk responses.
+const junkAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789=\n"
+
+// HoneypotMiddleware answers the requests to the attack paths probed by scanners, such as /.env or
+// /wp-login.php, instead of forwarding them to the upstreams: they get a 404 once the delay elapsed, or junk
+// dripped over the delay, so that scanners waste their time. Beyond the maximum of requests held at once, they get
+// a 404 at once, so that a flood of scans cannot pile up goroutines. Each request is logged with its client address.
+// It runs after ForwardingMiddleware, which resolves that address.
+//
+// Parameters:
+// - next: The next HTTP handler in the chain.
+// - dito: The Dito application instance.
+//
+// Returns:
+// - http.Handler: The HTTP handler with the honeypot applied.
+func HoneypotMiddleware(next http.Handler, dito *app.Dito) http.Handler {
+	middlewareType := "HoneypotMiddleware"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		honeypot := dito.Config.Honeypot
+		if !honeypot.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		pattern := ""
+		for _, path := range honeypot.CompiledPaths {
+			if path.MatchString(r.URL.Path) {
+				pattern = path.String()
+				break
+			}
+		}
+		if pattern == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		dito.Logger.Warn(fmt.Sprintf("[%s] Trapped %s %s from %s (user agent %q)", middlewareType, r.Method, r.URL.Path, r.Header.Get(RealIPHeader), r.UserAgent()))
+		if dito.Config.Metrics.Enabled {
+			metrics.RecordHoneypotRequest(pattern)
+		}
+		auditRequest(r, audit.EventSecurityBlock, map[string]string{"reason": "honeypot", "path": r.URL.Path, "user_agent": r.UserAgent()})
+
+		if !holdRequest(&trappedRequests, honeypot.MaxTrapped) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		defer trappedRequests.Add(-1)
+		if honeypot.Response == config.HoneypotResponseJunk {
+			dripJunk(w, r, honeypot)
+			return
+		}
+		if sleep(r.Context(), honeypot.Delay) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+		}
+	})
+}
+
+// dripJunk sends random text in chunks spread over the delay of the honeypot. It stops when the client goes
+// away.
+//
+// Parameters:
+// - w: The HTTP response writer.
+// - r: The HTTP request.
+// - honeypot: The honeypot configuration.
+func dripJunk(w http.ResponseWriter, r *http.Request, honeypot config.HoneypotConfig) {
+	chunks := min(max(int64(honeypot.Delay/honeypotDripInterval), 1), honeypot.JunkSize)
+	interval := honeypot.Delay / time.Duration(chunks)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.FormatInt(honeypot.JunkSize, 10))
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+	_ = controller.Flush()
+
+	chunkSize := honeypot.JunkSize / chunks
+	for i := int64(0); i < chunks; i++ {
+		if !sleep(r.Context(), interval) {
+			return
+		}
+		size := chunkSize
+		if i == chunks-1 {
+			size = honeypot.JunkSize - chunkSize*(chunks-1)
+		}
+		junk := make([]byte, size)
+		for j := range junk {
+			junk[j] = junkAlphabet[rand.IntN(len(junkAlphabet))]
+		}
+		if _, err := w.Write(junk); err != nil {
+			return
+		}
+		_ = controller.Flush()
+	}
+}
+
+// holdRequest reserves one of the requests that may be held at once; the caller releases it by decrementing the
+// counter.
+//
+// Parameters:
+// - held: The number of requests held.
+// - maxHeld: The most requests held at once.
+//
+// Returns:
+// - bool: False if the maximum is reached, and the request must be answered at once.
+func holdRequest(held *atomic.Int64, maxHeld int) bool {
+	if held.Add(1) > int64(maxHeld) {
+		held.Add(-1)
+		return false
+	}
+	return true
+}
+
+// sleep waits for a duration, unless the context is done first.
+//
+// Parameters:
+// - ctx: The context of the request.
+// - d: The duration.
+//
+// Returns:
+// - bool: False if the context was done before the end of the duration.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,82 @@
+package middlewares
+
+import (
+	"context"
+	"dito/app"
+	"dito/config"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHoneypotMiddleware verifies that attack paths are answered with a delayed 404 or with junk, that other
+// paths reach the next handler, that requests beyond the maximum held at once get a 404 at once, and that
+// nothing is trapped while the honeypot is disabled.
+func TestHoneypotMiddleware(t *testing.T) {
+	honeypot := config.HoneypotConfig{
+		Enabled:       true,
+		Response:      config.HoneypotResponseNotFound,
+		Delay:         50 * time.Millisecond,
+		JunkSize:      100,
+		MaxTrapped:    1,
+		CompiledPaths: []*regexp.Regexp{regexp.MustCompile(`/\.env$`), regexp.MustCompile(`/wp-login\.php$`)},
+	}
+	dito := &app.Dito{Config: &config.ProxyConfig{Honeypot: honeypot}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	handler := HoneypotMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), dito)
+
+	serve := func(path string) (*httptest.ResponseRecorder, time.Duration) {
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec, time.Since(start)
+	}
+
+	rec, _ := serve("/index.html")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec, elapsed := serve("/app/.env")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.GreaterOrEqual(t, elapsed, honeypot.Delay)
+
+	dito.Config.Honeypot.Response = config.HoneypotResponseJunk
+	rec, elapsed = serve("/wp-login.php")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "100", rec.Header().Get("Content-Length"))
+	assert.Len(t, rec.Body.Bytes(), 100)
+	assert.GreaterOrEqual(t, elapsed, honeypot.Delay)
+
+	trappedRequests.Add(1)
+	rec, elapsed = serve("/.env")
+	trappedRequests.Add(-1)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Less(t, elapsed, honeypot.Delay)
+
+	dito.Config.Honeypot.Enabled = false
+	rec, _ = serve("/.env")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
+
+// TestDripJunk verifies that junk is split in chunks over the delay, and that the drip stops when the client
+// goes away.
+func TestDripJunk(t *testing.T) {
+	honeypot := config.HoneypotConfig{Delay: 3 * honeypotDripInterval, JunkSize: 10}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	dripJunk(rec, httptest.NewRequest("GET", "/.env", nil).WithContext(ctx), honeypot)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Body.String())
+
+	honeypot.Delay = 0
+	rec = httptest.NewRecorder()
+	dripJunk(rec, httptest.NewRequest("GET", "/.env", nil), honeypot)
+	assert.Len(t, rec.Body.Bytes(), 10)
+}
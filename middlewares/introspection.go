@@ -0,0 +1,160 @@
+package middlewares
+
+import (
+	"context"
+	"dito/app"
+	"dito/audit"
+	"dito/config"
+	"dito/introspection"
+	"dito/metrics"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Results of the token introspection, labeling the metrics.
+const (
+	introspectionActive   = "active"
+	introspectionInactive = "inactive"
+	introspectionError    = "error"
+)
+
+// IntrospectionMiddleware validates the opaque bearer tokens of the requests against the OAuth2 introspection
+// endpoint of the location (RFC 7662). Requests without a token or with an inactive one get 401, tokens missing
+// a required scope get 403, and the claims of the active tokens are forwarded to the upstream in the configured
+// headers. The results are cached in Redis when it is enabled; if the endpoint cannot be reached, the request
+// gets 503.
+//
+// Parameters:
+// - next: The next http.Handler to be called if the token is active.
+// - dito: The Dito application instance containing the configuration, the Redis client, and the logger.
+// - location: The label of the location (its name or path), used to label the metrics.
+// - introspectionConfig: The introspection configuration of the location.
+//
+// Returns:
+// - http.Handler: A handler that validates the bearer tokens.
+func IntrospectionMiddleware(next http.Handler, dito *app.Dito, location string, introspectionConfig config.Introspection) http.Handler {
+	middlewareType := "IntrospectionMiddleware"
+	client := &http.Client{Timeout: introspectionConfig.Timeout}
+	var redisClient *redis.Client
+	if dito.Config.Redis.Enabled {
+		redisClient = dito.RedisClient
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The claim headers are only trusted when set by the proxy.
+		for _, header := range introspectionConfig.ClaimHeaders {
+			r.Header.Del(header)
+		}
+
+		token, ok := bearerToken(r)
+		if !ok {
+			auditRequest(r, audit.EventAuthFailure, map[string]string{"path": r.URL.Path, "reason": "missing_token"})
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		result, cached, err := introspectToken(r.Context(), dito, client, redisClient, introspectionConfig, token)
+		if err != nil {
+			dito.Logger.Error(fmt.Sprintf("[%s] Token introspection failed for %s: %v", middlewareType, location, err))
+			recordIntrospection(dito, location, introspectionError, false)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		if !result.Active() {
+			recordIntrospection(dito, location, introspectionInactive, cached)
+			auditRequest(r, audit.EventAuthFailure, map[string]string{"path": r.URL.Path, "reason": "inactive_token"})
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		recordIntrospection(dito, location, introspectionActive, cached)
+
+		scopes := result.Scopes()
+		for _, scope := range introspectionConfig.RequiredScopes {
+			if !slices.Contains(scopes, scope) {
+				dito.Logger.Debug(fmt.Sprintf("[%s] Token of %s lacks the scope %s", middlewareType, r.RemoteAddr, scope))
+				auditRequest(r, audit.EventAuthFailure, map[string]string{"path": r.URL.Path, "reason": "insufficient_scope", "scope": scope})
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(introspectionConfig.RequiredScopes, " ")))
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+
+		for claim, header := range introspectionConfig.ClaimHeaders {
+			if value, ok := result.Claim(claim); ok {
+				r.Header.Set(header, value)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken returns the bearer token of the Authorization header of a request.
+//
+// Parameters:
+// - r: The HTTP request.
+//
+// Returns:
+// - string: The token.
+// - bool: False if the request carries no bearer token.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// introspectToken returns the introspection result of a token, from the Redis cache if possible. Cache errors
+// are logged, and the endpoint is called instead.
+//
+// Parameters:
+// - ctx: The context of the request.
+// - dito: The Dito application instance containing the logger.
+// - client: The HTTP client calling the endpoint.
+// - redisClient: The Redis client caching the results, or nil.
+// - introspectionConfig: The introspection configuration.
+// - token: The bearer token.
+//
+// Returns:
+// - introspection.Result: The result.
+// - bool: True if the result was cached.
+// - error: An error if the endpoint cannot be reached or does not answer a valid result.
+func introspectToken(ctx context.Context, dito *app.Dito, client *http.Client, redisClient *redis.Client, introspectionConfig config.Introspection, token string) (introspection.Result, bool, error) {
+	if redisClient != nil {
+		result, ok, err := introspection.Load(ctx, redisClient, introspectionConfig, token)
+		if err != nil {
+			dito.Logger.Warn(fmt.Sprintf("[IntrospectionMiddleware] %v", err))
+		} else if ok {
+			return result, true, nil
+		}
+	}
+
+	result, err := introspection.Introspect(ctx, client, introspectionConfig, token)
+	if err != nil {
+		return nil, false, err
+	}
+	if redisClient != nil {
+		if ttl := introspection.CacheTTL(result, introspectionConfig.CacheTTL, time.Now()); ttl > 0 {
+			if err := introspection.Store(ctx, redisClient, introspectionConfig, token, result, ttl); err != nil {
+				dito.Logger.Warn(fmt.Sprintf("[IntrospectionMiddleware] %v", err))
+			}
+		}
+	}
+	return result, false, nil
+}
+
+// recordIntrospection records the result of a token introspection if the metrics are enabled.
+func recordIntrospection(dito *app.Dito, location, result string, cached bool) {
+	if dito.Config.Metrics.Enabled {
+		metrics.RecordTokenIntrospection(location, result, cached)
+	}
+}
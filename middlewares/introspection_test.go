@@ -0,0 +1,107 @@
+package middlewares
+
+import (
+	"dito/app"
+	"dito/config"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIntrospectionMiddleware verifies that requests without an active token are rejected, that the required
+// scopes are enforced, and that the claims of active tokens replace the client's claim headers.
+func TestIntrospectionMiddleware(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.PostFormValue("token") {
+		case "reader":
+			w.Write([]byte(`{"active": true, "scope": "read", "sub": "alice"}`))
+		case "writer":
+			w.Write([]byte(`{"active": true, "scope": "read write", "sub": "bob"}`))
+		default:
+			w.Write([]byte(`{"active": false}`))
+		}
+	}))
+	defer endpoint.Close()
+
+	dito := &app.Dito{Config: &config.ProxyConfig{}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	introspectionConfig := config.Introspection{
+		Enabled:        true,
+		Endpoint:       endpoint.URL,
+		Timeout:        time.Second,
+		RequiredScopes: []string{"write"},
+		ClaimHeaders:   map[string]string{"sub": "X-User-ID"},
+	}
+	var user string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { user = r.Header.Get("X-User-ID") })
+	handler := IntrospectionMiddleware(next, dito, "/api", introspectionConfig)
+	serve := func(authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api", nil)
+		req.Header.Set("X-User-ID", "admin")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+
+	rec = serve("Bearer revoked")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Bearer error="invalid_token"`, rec.Header().Get("WWW-Authenticate"))
+
+	rec = serve("Bearer reader")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`)
+
+	rec = serve("bearer writer")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "bob", user)
+
+	endpoint.Close()
+	rec = serve("Bearer writer")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+// TestIntrospectionMiddlewareCacheScope verifies that a token cached as active for a location is still checked
+// against the endpoint of another location.
+func TestIntrospectionMiddlewareCacheScope(t *testing.T) {
+	newEndpoint := func(answer string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(answer)) }))
+	}
+	accepting := newEndpoint(`{"active": true, "sub": "alice"}`)
+	defer accepting.Close()
+	rejecting := newEndpoint(`{"active": false}`)
+	defer rejecting.Close()
+
+	_, client := newTestRedis(t)
+	dito := &app.Dito{Config: &config.ProxyConfig{}, RedisClient: client, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	dito.Config.Redis.Enabled = true
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	serve := func(handler http.Handler) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	public := IntrospectionMiddleware(next, dito, "/public", config.Introspection{Enabled: true, Endpoint: accepting.URL, Timeout: time.Second, CacheTTL: time.Minute})
+	internal := IntrospectionMiddleware(next, dito, "/internal", config.Introspection{Enabled: true, Endpoint: rejecting.URL, Timeout: time.Second, CacheTTL: time.Minute})
+	assert.Equal(t, http.StatusOK, serve(public))
+	assert.Equal(t, http.StatusUnauthorized, serve(internal))
+
+	// A result is cached for the same endpoint and client ID only.
+	accepting.Close()
+	assert.Equal(t, http.StatusOK, serve(public))
+	sameEndpoint := IntrospectionMiddleware(next, dito, "/partner", config.Introspection{Enabled: true, Endpoint: accepting.URL, ClientID: "partner", Timeout: time.Second, CacheTTL: time.Minute})
+	assert.Equal(t, http.StatusServiceUnavailable, serve(sameEndpoint))
+}
@@ -0,0 +1,123 @@
+package middlewares
+
+import (
+	"dito/config"
+	"sync"
+	"sync/atomic"
+)
+
+// Overflow policies of the log queue.
+const (
+	// OverflowDrop discards new entries while the queue is full.
+	OverflowDrop = "drop"
+	// OverflowBlock makes requests wait for room in the queue, so that no entry is lost.
+	OverflowBlock = "block"
+	// OverflowSample keeps only a sample of the entries once the queue is half full, and drops them when it is full.
+	OverflowSample = "sample"
+)
+
+// Defaults of the log queue.
+const (
+	defaultLogQueueSize  = 10000
+	defaultLogWorkers    = 5
+	defaultLogSampleRate = 10
+)
+
+// Reasons reported for discarded log entries.
+const (
+	dropQueueFull = "queue_full"
+	dropSampled   = "sampled"
+)
+
+// logQueue hands the log entries over to the workers writing them.
+type logQueue struct {
+	entries    chan logEntry
+	overflow   string
+	sampleRate uint64
+	count      atomic.Uint64
+}
+
+var (
+	// requestLogs is the queue shared by all the logging middlewares, created on first use.
+	requestLogs     *logQueue
+	requestLogsOnce sync.Once
+)
+
+// startLogQueue creates the shared log queue and starts its workers, the first time it is called.
+//
+// Parameters:
+// - queueConfig: The log queue configuration.
+//
+// Returns:
+// - *logQueue: The shared log queue.
+func startLogQueue(queueConfig config.LogQueue) *logQueue {
+	requestLogsOnce.Do(func() {
+		requestLogs = newLogQueue(queueConfig)
+		workers := queueConfig.Workers
+		if workers <= 0 {
+			workers = defaultLogWorkers
+		}
+		for i := 0; i < workers; i++ {
+			go func() {
+				for entry := range requestLogs.entries {
+					processLogEntry(entry)
+				}
+			}()
+		}
+	})
+	return requestLogs
+}
+
+// newLogQueue creates a log queue, applying the defaults for unset values.
+//
+// Parameters:
+// - queueConfig: The log queue configuration.
+//
+// Returns:
+// - *logQueue: The log queue, without workers.
+func newLogQueue(queueConfig config.LogQueue) *logQueue {
+	size := queueConfig.Size
+	if size <= 0 {
+		size = defaultLogQueueSize
+	}
+	overflow := queueConfig.Overflow
+	if overflow != OverflowBlock && overflow != OverflowSample {
+		overflow = OverflowDrop
+	}
+	sampleRate := queueConfig.SampleRate
+	if sampleRate <= 0 {
+		sampleRate = defaultLogSampleRate
+	}
+	return &logQueue{
+		entries:    make(chan logEntry, size),
+		overflow:   overflow,
+		sampleRate: uint64(sampleRate),
+	}
+}
+
+// push hands an entry over to the workers according to the overflow policy.
+//
+// Parameters:
+// - entry: The log entry.
+//
+// Returns:
+// - string: The reason the entry was discarded (dropQueueFull or dropSampled).
+// - bool: True if the entry was queued.
+func (q *logQueue) push(entry logEntry) (string, bool) {
+	switch q.overflow {
+	case OverflowBlock:
+		q.entries <- entry
+		return "", true
+	case OverflowSample:
+		if len(q.entries) >= cap(q.entries)/2 && q.count.Add(1)%q.sampleRate != 0 {
+			return dropSampled, false
+		}
+	}
+
+	select {
+	case q.entries <- entry:
+		return "", true
+	default:
+		return dropQueueFull, false
+	}
+}
@@ -0,0 +1,83 @@
+package middlewares
+
+import (
+	"dito/config"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// logSampler decides which requests are logged verbosely.
+//
+// The decision is taken in two steps: before the request is served, candidate tells whether the
+// request body must be captured; once the response is known, verbose takes the final decision.
+type logSampler struct {
+	count atomic.Uint64
+
+	mu           sync.Mutex
+	maxPerSecond float64
+	limiter      *rate.Limiter
+}
+
+// verboseSampler is the sampler shared by all the logging middlewares.
+var verboseSampler logSampler
+
+// candidate reports whether a request may be logged verbosely, and whether it was picked by the 1 in N sampling.
+//
+// Parameters:
+// - sampling: The sampling configuration.
+//
+// Returns:
+// - bool: True if the request was picked by the sampling rate.
+// - bool: True if the request may be logged verbosely, so its body must be captured.
+func (s *logSampler) candidate(sampling config.LogSampling) (bool, bool) {
+	var sampled bool
+	switch {
+	case sampling.Rate > 1:
+		sampled = s.count.Add(1)%uint64(sampling.Rate) == 1
+	case sampling.Rate == 1:
+		sampled = true
+	default:
+		sampled = !sampling.Errors && sampling.SlowThreshold <= 0
+	}
+	return sampled, sampled || sampling.Errors || sampling.SlowThreshold > 0
+}
+
+// verbose takes the final decision for a candidate request, once its status and duration are known.
+//
+// Parameters:
+// - sampling: The sampling configuration.
+// - sampled: Whether the request was picked by the sampling rate.
+// - statusCode: The status code of the response.
+// - duration: The time taken to serve the request.
+//
+// Returns:
+// - bool: True if the request must be logged verbosely.
+func (s *logSampler) verbose(sampling config.LogSampling, sampled bool, statusCode int, duration time.Duration) bool {
+	selected := sampled ||
+		(sampling.Errors && statusCode >= http.StatusBadRequest) ||
+		(sampling.SlowThreshold > 0 && duration >= sampling.SlowThreshold)
+	if !selected || sampling.MaxPerSecond <= 0 {
+		return selected
+	}
+	return s.allow(sampling.MaxPerSecond)
+}
+
+// allow applies the cap on verbose entries per second, recreating the limiter when the cap changes.
+func (s *logSampler) allow(maxPerSecond float64) bool {
+	s.mu.Lock()
+	if s.limiter == nil || s.maxPerSecond != maxPerSecond {
+		burst := int(maxPerSecond)
+		if burst < 1 {
+			burst = 1
+		}
+		s.limiter = rate.NewLimiter(rate.Limit(maxPerSecond), burst)
+		s.maxPerSecond = maxPerSecond
+	}
+	limiter := s.limiter
+	s.mu.Unlock()
+	return limiter.Allow()
+}
@@ -3,50 +3,84 @@ package middlewares
 import (
 	"bytes"
 	"dito/app"
+	"dito/config"
+	"dito/geoip"
 	"dito/logging"
 	"dito/metrics"
+	"dito/spool"
 	"dito/writer"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // logEntry represents a log entry with details about the HTTP request and response.
 type logEntry struct {
-	Dito         *app.Dito     // Reference to the Dito application instance.
-	Request      *http.Request // The HTTP request.
-	BodyBytes    []byte        // The body of the HTTP request.
-	Headers      http.Header   // The headers of the HTTP request.
-	StatusCode   int           // The status code of the HTTP response.
-	Duration     time.Duration // The duration of the HTTP request processing.
-	BytesWritten int           // The number of bytes written in the HTTP response.
+	Dito         *app.Dito            // Reference to the Dito application instance.
+	Request      *http.Request        // The HTTP request.
+	BodyBytes    []byte               // The body of the HTTP request.
+	Headers      http.Header          // The headers of the HTTP request.
+	StatusCode   int                  // The status code of the HTTP response.
+	Duration     time.Duration        // The duration of the HTTP request processing.
+	BytesWritten int                  // The number of bytes written in the HTTP response.
+	BodySpool    *spool.Buffer        // The request body captured for verbose logging, when it may exceed BodyBytes.
+	Verbose      bool                 // Whether the entry is logged verbosely, as selected by the sampling configuration.
+	Info         *logging.RequestInfo // What the handlers learned while serving the request.
 }
 
-// Global log channel
-var logChannel = make(chan logEntry, 10000)
+// passthroughWriters recycles the non-buffering writers used to record status codes and sizes.
+var passthroughWriters = writer.NewPool()
 
-// Number of worker goroutines for logging
-const numLogWorkers = 5
+// maxMaskedBodySize is the largest captured body masked in memory before being logged. Larger
+// bodies are left out of the log when body fields must be masked, so that they never leak.
+const maxMaskedBodySize = 1 << 20
 
-// init initializes the logging workers.
-func init() {
-	// Start multiple goroutines for logging
-	for i := 0; i < numLogWorkers; i++ {
-		go func() {
-			for entry := range logChannel {
-				processLogEntry(entry)
+// processLogEntry processes a log entry and logs it based on the configuration.
+// Sensitive headers and body fields are masked before the entry is written.
+func processLogEntry(entry logEntry) {
+	if entry.BodySpool != nil {
+		defer entry.BodySpool.Close()
+	}
+
+	masking := entry.Dito.Config.Logging.Masking
+	headers := logging.MaskHeaders(entry.Headers, masking)
+
+	if entry.Verbose {
+		if entry.BodySpool != nil {
+			if masking.CompiledFields == nil && masking.CompiledXMLFields == nil {
+				logging.LogRequestVerboseStream(entry.Request, entry.BodySpool.NewReader(), headers, entry.StatusCode, entry.Duration)
+				return
 			}
-		}()
+			logging.LogRequestVerbose(entry.Request, maskedSpool(entry.BodySpool, entry.Request.Header.Get("Content-Type"), masking), headers, entry.StatusCode, entry.Duration)
+			return
+		}
+		logging.LogRequestVerbose(entry.Request, logging.MaskBody(entry.BodyBytes, entry.Request.Header.Get("Content-Type"), masking), headers, entry.StatusCode, entry.Duration)
+	} else {
+		logging.LogRequestCompact(entry.Request, entry.BodyBytes, headers, entry.StatusCode, entry.Duration, entry.Info)
 	}
 }
 
-// processLogEntry processes a log entry and logs it based on the configuration.
-func processLogEntry(entry logEntry) {
-	if entry.Dito.Config.Logging.Enabled && entry.Dito.Config.Logging.Verbose {
-		logging.LogRequestVerbose(entry.Request, entry.BodyBytes, entry.Headers, entry.StatusCode, entry.Duration)
-	} else {
-		logging.LogRequestCompact(entry.Request, entry.BodyBytes, entry.Headers, entry.StatusCode, entry.Duration)
+// maskedSpool reads a captured body and masks its sensitive fields.
+//
+// Parameters:
+// - body: The captured body.
+// - contentType: The Content-Type of the body.
+// - masking: The masking configuration.
+//
+// Returns:
+// - []byte: The masked body, or a placeholder if it is too large to be masked or cannot be read.
+func maskedSpool(body *spool.Buffer, contentType string, masking config.LogMasking) []byte {
+	if body.Size() > maxMaskedBodySize {
+		return []byte(fmt.Sprintf("[body of %d bytes not logged: too large to be masked]", body.Size()))
+	}
+	data, err := io.ReadAll(body.NewReader())
+	if err != nil {
+		return []byte(fmt.Sprintf("[body not logged: %v]", err))
 	}
+	return logging.MaskBody(data, contentType, masking)
 }
 
 // LoggingMiddleware is an HTTP middleware that logs the details of each request and response.
@@ -58,37 +92,67 @@ func processLogEntry(entry logEntry) {
 // Returns:
 // - http.Handler: The HTTP handler with logging functionality.
 func LoggingMiddleware(next http.Handler, dito *app.Dito) http.Handler {
+	queue := startLogQueue(dito.Config.Logging.Queue)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		if dito.Config.Metrics.Enabled {
-			metrics.UpdateActiveConnections(true)
-			defer metrics.UpdateActiveConnections(false)
+		// Only the requests that may be logged verbosely need their body captured.
+		loggingConfig := dito.Config.Logging
+		verbose := loggingConfig.Enabled && loggingConfig.Verbose
+		var sampled bool
+		if verbose {
+			sampled, verbose = verboseSampler.candidate(loggingConfig.Sampling)
 		}
 
 		var bodyBytes []byte
-		if r.Body != nil {
-			const MaxBodySize = 1024
+		var bodySpool *spool.Buffer
+		const MaxBodySize = 1024
+		// Reading the body of a request sent with Expect: 100-continue would ask the client for it before the
+		// upstream accepted the request, so it is captured as the upstream reads it, like large bodies.
+		expectsContinue := strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+		if r.Body != nil && verbose && (loggingConfig.MaxBodySize > MaxBodySize || expectsContinue) {
+			// Large bodies are captured while the upstream reads them, spilling to disk if needed,
+			// and streamed to the log afterwards.
+			buffering := dito.Config.Buffering
+			bodySpool = spool.NewBuffer(buffering.MemoryLimit, 0, buffering.TempDir)
+			r.Body = &captureReader{ReadCloser: r.Body, capture: bodySpool, limit: max(loggingConfig.MaxBodySize, MaxBodySize)}
+		} else if r.Body != nil && verbose {
 			limitedReader := io.LimitReader(r.Body, MaxBodySize)
 			bodyBytes, _ = io.ReadAll(limitedReader)
 			r.Body = io.NopCloser(io.MultiReader(bytes.NewBuffer(bodyBytes), r.Body))
 		}
 
-		lrw := &writer.ResponseWriter{ResponseWriter: w}
+		r, info := logging.WithRequestInfo(r)
+		info.RequestID = r.Header.Get(RequestIDHeader)
+		if location, ok := geoip.FromContext(r.Context()); ok {
+			info.Country = location.Country
+			info.ASN = location.ASN
+		}
+		if r.Body != nil {
+			r.Body = &countingReader{ReadCloser: r.Body, count: &info.BytesIn}
+		}
+
+		// The response body is never logged, so the writer only tracks status and size.
+		lrw := passthroughWriters.Get(w, true)
+		defer passthroughWriters.Put(lrw)
 
 		next.ServeHTTP(lrw, r)
 
 		duration := time.Since(start)
+		if verbose {
+			verbose = verboseSampler.verbose(loggingConfig.Sampling, sampled, lrw.StatusCode, duration)
+		}
 
 		if dito.Config.Metrics.Enabled {
-			metrics.RecordRequest(r.Method, r.URL.Path, lrw.StatusCode, float64(duration.Seconds()))
-			metrics.RecordDataTransferred("inbound", int(r.ContentLength))
+			pathLabel := metrics.PathLabel(dito.Config.Metrics.PathLabel, info.Location, r.URL.Path)
+			metrics.RecordRequest(r.Method, pathLabel, lrw.StatusCode, float64(duration.Seconds()))
+			metrics.RecordDataTransferred("inbound", int(info.BytesIn.Load()))
 			metrics.RecordDataTransferred("outbound", lrw.BytesWritten)
 		}
 
-		select {
-		case logChannel <- logEntry{
+		info.BytesOut = lrw.BytesWritten
+		entry := logEntry{
 			Dito:         dito,
 			Request:      r,
 			BodyBytes:    bodyBytes,
@@ -96,9 +160,60 @@ func LoggingMiddleware(next http.Handler, dito *app.Dito) http.Handler {
 			StatusCode:   lrw.StatusCode,
 			Duration:     duration,
 			BytesWritten: lrw.BytesWritten,
-		}:
-		default:
-			dito.Logger.Warn("Log channel is full, discarding log entry")
+			BodySpool:    bodySpool,
+			Verbose:      verbose,
+			Info:         info,
+		}
+		if reason, ok := queue.push(entry); !ok {
+			if reason == dropQueueFull {
+				dito.Logger.Warn("Log queue is full, discarding log entry")
+			}
+			if dito.Config.Metrics.Enabled {
+				metrics.RecordLogEntryDropped(reason)
+			}
+			if bodySpool != nil {
+				bodySpool.Close()
+			}
 		}
 	})
 }
+
+// countingReader counts the bytes read from a request body. The body may be read by the transport goroutine
+// sending it upstream while the access log reads the count, hence the atomic counter.
+type countingReader struct {
+	io.ReadCloser
+	count *atomic.Int64
+}
+
+// Read reads from the body and counts the bytes read.
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.count.Add(int64(n))
+	return n, err
+}
+
+// captureReader copies up to limit bytes read from a request body into a spool buffer.
+// The capture stops silently once the limit is reached or the buffer refuses data (disk budget),
+// without affecting the request itself.
+type captureReader struct {
+	io.ReadCloser
+	capture *spool.Buffer
+	limit   int64
+	stopped bool
+}
+
+// Read reads from the body and captures the data read.
+func (c *captureReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 && !c.stopped {
+		captured := p[:n]
+		if remaining := c.limit - c.capture.Size(); int64(len(captured)) > remaining {
+			captured = captured[:remaining]
+			c.stopped = true
+		}
+		if _, captureErr := c.capture.Write(captured); captureErr != nil {
+			c.stopped = true
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,138 @@
+package middlewares
+
+import (
+	"dito/app"
+	"dito/config"
+	"dito/spool"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCaptureReader verifies that the request body is forwarded whole while at most limit bytes are captured.
+func TestCaptureReader(t *testing.T) {
+	capture := spool.NewBuffer(4, 0, t.TempDir())
+	defer capture.Close()
+
+	reader := &captureReader{ReadCloser: io.NopCloser(strings.NewReader("0123456789")), capture: capture, limit: 6}
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+
+	captured, err := io.ReadAll(capture.NewReader())
+	assert.NoError(t, err)
+	assert.Equal(t, "012345", string(captured))
+	assert.True(t, capture.OnDisk())
+}
+
+// TestLogSampler verifies the verbose logging decisions for the sampling settings.
+func TestLogSampler(t *testing.T) {
+	t.Run("rate", func(t *testing.T) {
+		var s logSampler
+		sampling := config.LogSampling{Rate: 3}
+		picked := 0
+		for i := 0; i < 9; i++ {
+			sampled, candidate := s.candidate(sampling)
+			assert.Equal(t, sampled, candidate)
+			if s.verbose(sampling, sampled, http.StatusOK, time.Millisecond) {
+				picked++
+			}
+		}
+		assert.Equal(t, 3, picked)
+	})
+
+	t.Run("errors and slow requests", func(t *testing.T) {
+		var s logSampler
+		sampling := config.LogSampling{Errors: true, SlowThreshold: time.Second}
+		sampled, candidate := s.candidate(sampling)
+		assert.False(t, sampled)
+		assert.True(t, candidate)
+
+		assert.False(t, s.verbose(sampling, sampled, http.StatusOK, time.Millisecond))
+		assert.True(t, s.verbose(sampling, sampled, http.StatusBadGateway, time.Millisecond))
+		assert.True(t, s.verbose(sampling, sampled, http.StatusOK, 2*time.Second))
+	})
+
+	t.Run("no sampling", func(t *testing.T) {
+		var s logSampler
+		sampled, candidate := s.candidate(config.LogSampling{})
+		assert.True(t, sampled)
+		assert.True(t, candidate)
+	})
+
+	t.Run("max per second", func(t *testing.T) {
+		var s logSampler
+		sampling := config.LogSampling{MaxPerSecond: 2}
+		assert.True(t, s.verbose(sampling, true, http.StatusOK, 0))
+		assert.True(t, s.verbose(sampling, true, http.StatusOK, 0))
+		assert.False(t, s.verbose(sampling, true, http.StatusOK, 0))
+	})
+}
+
+// TestLogQueueOverflow verifies the drop and sample overflow policies.
+func TestLogQueueOverflow(t *testing.T) {
+	queue := newLogQueue(config.LogQueue{Size: 2})
+	for i := 0; i < 2; i++ {
+		_, ok := queue.push(logEntry{})
+		assert.True(t, ok)
+	}
+	reason, ok := queue.push(logEntry{})
+	assert.False(t, ok)
+	assert.Equal(t, dropQueueFull, reason)
+
+	queue = newLogQueue(config.LogQueue{Size: 10, Overflow: OverflowSample, SampleRate: 2})
+	queued := 0
+	for i := 0; i < 10; i++ {
+		if _, ok := queue.push(logEntry{}); ok {
+			queued++
+		}
+	}
+	// The first 5 entries fill half the queue, then 1 in 2 is kept.
+	assert.Equal(t, 7, queued)
+	_, ok = queue.push(logEntry{})
+	assert.True(t, ok)
+	reason, ok = queue.push(logEntry{})
+	assert.False(t, ok)
+	assert.Equal(t, dropSampled, reason)
+}
+
+// readTracker records whether a request body was read.
+type readTracker struct {
+	io.Reader
+	read bool
+}
+
+// Read reads from the body and records the read.
+func (rt *readTracker) Read(p []byte) (int, error) {
+	rt.read = true
+	return rt.Reader.Read(p)
+}
+
+// TestLoggingMiddlewareExpectContinue verifies that in verbose mode the body of a request sent with
+// Expect: 100-continue is not read before the next handler, and is still captured for the log.
+func TestLoggingMiddlewareExpectContinue(t *testing.T) {
+	dito := &app.Dito{
+		Config: &config.ProxyConfig{Logging: config.Logging{Enabled: true, Verbose: true, MaxBodySize: 100}},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	body := &readTracker{Reader: strings.NewReader("hello")}
+	var readBefore bool
+	var received string
+	handler := LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readBefore = body.read
+		data, _ := io.ReadAll(r.Body)
+		received = string(data)
+	}), dito)
+
+	req := httptest.NewRequest("POST", "/upload", io.NopCloser(body))
+	req.Header.Set("Expect", "100-continue")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.False(t, readBefore)
+	assert.Equal(t, "hello", received)
+}
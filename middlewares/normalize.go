@@ -0,0 +1,155 @@
+package middlewares
+
+import (
+	"dito/app"
+	"dito/audit"
+	"dito/metrics"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// Reasons reported when a request path is rejected by the normalization stage.
+const (
+	ReasonInvalidEncoding  = "invalid_encoding"
+	ReasonOverlongEncoding = "overlong_encoding"
+	ReasonDoubleEncoding   = "double_encoding"
+	ReasonEncodedSeparator = "encoded_separator"
+	ReasonNullByte         = "null_byte"
+	ReasonDuplicateSlash   = "duplicate_slash"
+	ReasonPathTraversal    = "path_traversal"
+	ReasonInvalidPath      = "invalid_path"
+)
+
+// PathRejectionError is returned by NormalizeRequestPath when a path is unsafe.
+type PathRejectionError struct {
+	Reason string // Reason is one of the Reason* constants.
+	Path   string // Path is the raw path that was rejected.
+}
+
+// Error implements the error interface.
+func (e *PathRejectionError) Error() string {
+	return fmt.Sprintf("rejected request path %q: %s", e.Path, e.Reason)
+}
+
+// NormalizationMiddleware decodes and canonicalizes the request path before location matching.
+// Requests containing traversal sequences, null bytes, duplicate slashes, or invalid/overlong
+// encodings are rejected with 400 Bad Request and recorded in the security blocks metric.
+//
+// Parameters:
+// - next: The next HTTP handler in the chain.
+// - dito: The Dito application instance.
+//
+// Returns:
+// - http.Handler: The HTTP handler with path normalization applied.
+func NormalizationMiddleware(next http.Handler, dito *app.Dito) http.Handler {
+	middlewareType := "NormalizationMiddleware"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// CONNECT and server-wide OPTIONS requests do not carry a path.
+		if r.Method == http.MethodConnect || r.URL.Path == "*" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		normalized, err := NormalizeRequestPath(r.URL.EscapedPath())
+		if err != nil {
+			var rejection *PathRejectionError
+			reason := ReasonInvalidEncoding
+			if errors.As(err, &rejection) {
+				reason = rejection.Reason
+			}
+			dito.Logger.Warn(fmt.Sprintf("[%s] Blocked request from %s: %v", middlewareType, r.RemoteAddr, err))
+			if dito.Config.Metrics.Enabled {
+				metrics.RecordSecurityBlock(reason)
+			}
+			auditRequest(r, audit.EventSecurityBlock, map[string]string{"reason": reason, "path": r.URL.EscapedPath()})
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		if normalized != r.URL.Path {
+			dito.Logger.Debug(fmt.Sprintf("[%s] Normalized path %q to %q", middlewareType, r.URL.Path, normalized))
+		}
+		r.URL.Path = normalized
+		r.URL.RawPath = ""
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NormalizeRequestPath decodes an escaped request path and returns its canonical form.
+// It rejects paths that contain null bytes, `..` segments, duplicate slashes, encoded
+// separators, double encoding, or byte sequences that are not valid UTF-8 (such as overlong encodings).
+//
+// Parameters:
+// - escapedPath: The path as it was received on the wire (percent-encoded).
+//
+// Returns:
+// - string: The decoded, canonical path.
+// - error: A *PathRejectionError if the path is unsafe.
+func NormalizeRequestPath(escapedPath string) (string, error) {
+	reject := func(reason string) (string, error) {
+		return "", &PathRejectionError{Reason: reason, Path: escapedPath}
+	}
+
+	if escapedPath == "" {
+		return "/", nil
+	}
+	if !strings.HasPrefix(escapedPath, "/") {
+		return reject(ReasonInvalidPath)
+	}
+
+	lowerEscaped := strings.ToLower(escapedPath)
+	if strings.Contains(lowerEscaped, "%2f") || strings.Contains(lowerEscaped, "%5c") {
+		return reject(ReasonEncodedSeparator)
+	}
+
+	decoded, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		return reject(ReasonInvalidEncoding)
+	}
+	if strings.ContainsRune(decoded, 0) {
+		return reject(ReasonNullByte)
+	}
+	if !utf8.ValidString(decoded) {
+		return reject(ReasonOverlongEncoding)
+	}
+	if hasPercentEncoding(decoded) {
+		return reject(ReasonDoubleEncoding)
+	}
+	if strings.Contains(decoded, "//") {
+		return reject(ReasonDuplicateSlash)
+	}
+
+	for _, segment := range strings.FieldsFunc(decoded, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return reject(ReasonPathTraversal)
+		}
+	}
+
+	canonical := path.Clean(decoded)
+	if strings.HasSuffix(decoded, "/") && canonical != "/" {
+		canonical += "/"
+	}
+	return canonical, nil
+}
+
+// hasPercentEncoding reports whether s still contains a valid percent-encoded byte after decoding.
+func hasPercentEncoding(s string) bool {
+	for i := 0; i+2 < len(s); i++ {
+		if s[i] == '%' && isHex(s[i+1]) && isHex(s[i+2]) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHex reports whether c is a hexadecimal digit.
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
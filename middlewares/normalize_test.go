@@ -0,0 +1,48 @@
+package middlewares
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNormalizeRequestPath verifies that safe paths are canonicalized and unsafe ones rejected.
+func TestNormalizeRequestPath(t *testing.T) {
+	valid := map[string]string{
+		"":              "/",
+		"/":             "/",
+		"/api/users":    "/api/users",
+		"/api/users/":   "/api/users/",
+		"/api/./users":  "/api/users",
+		"/caf%C3%A9":    "/café",
+		"/a%20b":        "/a b",
+		"/files/v1.2.3": "/files/v1.2.3",
+	}
+	for input, expected := range valid {
+		normalized, err := NormalizeRequestPath(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, normalized, input)
+	}
+
+	rejected := map[string]string{
+		"api":                ReasonInvalidPath,
+		"/../etc/passwd":     ReasonPathTraversal,
+		"/api/%2e%2e/secret": ReasonPathTraversal,
+		"/api/..\\secret":    ReasonPathTraversal,
+		"/api%2F..%2Fsecret": ReasonEncodedSeparator,
+		"/api%5csecret":      ReasonEncodedSeparator,
+		"/api%00.json":       ReasonNullByte,
+		"/api//users":        ReasonDuplicateSlash,
+		"/%c0%ae%c0%ae/etc":  ReasonOverlongEncoding,
+		"/%252e%252e/etc":    ReasonDoubleEncoding,
+		"/api/%zz":           ReasonInvalidEncoding,
+	}
+	for input, reason := range rejected {
+		_, err := NormalizeRequestPath(input)
+		var rejection *PathRejectionError
+		if assert.True(t, errors.As(err, &rejection), input) {
+			assert.Equal(t, reason, rejection.Reason, input)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package middlewares
+
+import (
+	"dito/audit"
+	"dito/config"
+	"dito/quota"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Headers reporting the quota of the API key to the client.
+const (
+	QuotaLimitHeader     = "X-Quota-Limit"
+	QuotaRemainingHeader = "X-Quota-Remaining"
+	QuotaResetHeader     = "X-Quota-Reset"
+)
+
+// QuotaMiddlewareWithRedis is an HTTP middleware that enforces a daily or monthly request quota per API key,
+// counted in Redis. Requests without an accepted API key get 401, so that a client cannot escape its quota by
+// sending new keys. The remaining quota is reported in the response headers.
+//
+// Parameters:
+// - next: The next http.Handler to be called if the request is within quota.
+// - quotaConfig: The configuration for the quota.
+// - redisClient: The Redis client used to store the counters.
+// - logger: The logger used to log messages.
+//
+// Returns:
+// - http.Handler: A handler that enforces the quota.
+func QuotaMiddlewareWithRedis(next http.Handler, quotaConfig config.Quota, redisClient *redis.Client, logger *slog.Logger) http.Handler {
+	middlewareType := "QuotaMiddlewareWithRedis"
+	if !quotaConfig.Enabled {
+		logger.Debug(fmt.Sprintf("[%s] Quota is disabled", middlewareType))
+		return next
+	}
+	keyHeader := quota.KeyHeader(quotaConfig)
+	accepted := quota.Validator(quotaConfig)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey := r.Header.Get(keyHeader)
+		if apiKey == "" {
+			auditRequest(r, audit.EventAuthFailure, map[string]string{"path": r.URL.Path, "reason": "missing_api_key", "quota": quotaConfig.Name})
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !accepted(apiKey) {
+			auditRequest(r, audit.EventAuthFailure, map[string]string{"path": r.URL.Path, "reason": "invalid_api_key", "quota": quotaConfig.Name})
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		usage, err := quota.Consume(r.Context(), redisClient, quotaConfig, apiKey, time.Now())
+		if err != nil {
+			logger.Error(fmt.Sprintf("[%s] %v", middlewareType, err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(QuotaLimitHeader, strconv.FormatInt(usage.Limit, 10))
+		w.Header().Set(QuotaRemainingHeader, strconv.FormatInt(usage.Remaining, 10))
+		w.Header().Set(QuotaResetHeader, strconv.FormatInt(usage.Reset.Unix(), 10))
+
+		if usage.Exceeded() {
+			logger.Debug(fmt.Sprintf("[%s] Quota %s exceeded for the API key of %s", middlewareType, quotaConfig.Name, r.RemoteAddr))
+			auditRequest(r, audit.EventRateLimitBlock, map[string]string{"limiter": "quota", "quota": quotaConfig.Name, "path": r.URL.Path})
+			retryAfter := int64(time.Until(usage.Reset).Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
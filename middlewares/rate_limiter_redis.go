@@ -2,7 +2,9 @@ package middlewares
 
 import (
 	"context"
+	"dito/audit"
 	"dito/config"
+	"dito/ratelimit"
 	"fmt"
 	"github.com/redis/go-redis/v9"
 	"log/slog"
@@ -17,13 +19,14 @@ const rateLimiterKeyPrefix = "rate_limiter:"
 //
 // Parameters:
 // - next: The next http.Handler to be called if the request is allowed.
+// - location: The key of the location, used to namespace the Redis keys according to the rate limit scope.
 // - rateLimitingConfig: The configuration for rate limiting.
 // - redisClient: The Redis client used to store and retrieve rate limiting data.
 // - logger: The logger used to log messages.
 //
 // Returns:
 // - http.Handler: A handler that applies rate limiting based on the provided configuration.
-func RateLimiterMiddlewareWithRedis(next http.Handler, rateLimitingConfig config.RateLimiting, redisClient *redis.Client, logger *slog.Logger) http.Handler {
+func RateLimiterMiddlewareWithRedis(next http.Handler, location string, rateLimitingConfig config.RateLimiting, redisClient *redis.Client, logger *slog.Logger) http.Handler {
 	middlewareType := "RateLimiterMiddlewareWithRedis"
 	logger.Debug(fmt.Sprintf("[%s] Rate limiting is enabled with %v requests per second", middlewareType, rateLimitingConfig.RequestsPerSecond))
 	if !rateLimitingConfig.Enabled {
@@ -38,7 +41,8 @@ func RateLimiterMiddlewareWithRedis(next http.Handler, rateLimitingConfig config
 		logger.Debug(fmt.Sprintf("[%s] Handling request from IP: %s, Path: %s", middlewareType, ip, r.URL.Path))
 
 		// Check if the request is allowed
-		allowed, err := allowRequest(redisClient, ip, rateLimitingConfig, logger, middlewareType)
+		namespace := ratelimit.Namespace(rateLimitingConfig, location)
+		allowed, err := allowRequest(redisClient, namespace, ip, rateLimitingConfig, logger, middlewareType)
 		if err != nil {
 			logger.Error(fmt.Sprintf("[%s] Error checking rate limit for IP %s: %v", middlewareType, ip, err))
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -48,6 +52,7 @@ func RateLimiterMiddlewareWithRedis(next http.Handler, rateLimitingConfig config
 		// If the request exceeds the rate limit, return 429 (Too Many Requests)
 		if !allowed {
 			logger.Debug(fmt.Sprintf("[%s] Rate limit exceeded for IP: %s", middlewareType, ip))
+			auditRequest(r, audit.EventRateLimitBlock, map[string]string{"limiter": "redis", "scope": namespace, "client_ip": ip, "path": r.URL.Path})
 			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
 			return
 		}
@@ -63,6 +68,7 @@ func RateLimiterMiddlewareWithRedis(next http.Handler, rateLimitingConfig config
 //
 // Parameters:
 // - redisClient: The Redis client used to store and retrieve rate limiting data.
+// - namespace: The namespace of the limits (see ratelimit.Namespace).
 // - ip: The IP address of the client making the request.
 // - rateLimitingConfig: The configuration for rate limiting.
 // - logger: The logger used to log messages.
@@ -71,9 +77,9 @@ func RateLimiterMiddlewareWithRedis(next http.Handler, rateLimitingConfig config
 // Returns:
 // - bool: True if the request is allowed, false otherwise.
 // - error: An error if there was an issue checking the rate limit.
-func allowRequest(redisClient *redis.Client, ip string, rateLimitingConfig config.RateLimiting, logger *slog.Logger, middlewareType string) (bool, error) {
+func allowRequest(redisClient *redis.Client, namespace string, ip string, rateLimitingConfig config.RateLimiting, logger *slog.Logger, middlewareType string) (bool, error) {
 	ctx := context.Background()
-	key := rateLimiterKeyPrefix + ip
+	key := rateLimiterKeyPrefix + namespace + ":" + ratelimit.ClientKey(rateLimitingConfig, ip)
 
 	limit := rateLimitingConfig.RequestsPerSecond
 	expiry := time.Second
@@ -0,0 +1,50 @@
+package middlewares
+
+import (
+	"dito/app"
+	"dito/config"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGetClientIP verifies that IPv6 clients get their own rate limiting key instead of sharing the one of their
+// first address group, whatever the form of their address, and that the limiters key on the address resolved by
+// ForwardingMiddleware rather than on the X-Forwarded-For entries chosen by the client.
+func TestGetClientIP(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dito := &app.Dito{Config: &config.ProxyConfig{}, Logger: logger}
+	dito.Config.Forwarding.CompiledTrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	clientIP := func(remoteAddr, forwarded string) string {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		var ip string
+		ForwardingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip = getClientIP(r, logger, "test")
+		}), dito).ServeHTTP(httptest.NewRecorder(), r)
+		return ip
+	}
+
+	assert.Equal(t, "192.0.2.1", clientIP("192.0.2.1:8080", ""))
+	assert.Equal(t, "2001:db8::1", clientIP("[2001:db8::1]:51260", ""))
+	assert.Equal(t, "2001:db8::2", clientIP("[2001:db8::2]:51260", ""))
+	assert.Equal(t, "fe80::1", clientIP("[fe80::1%eth0]:51260", ""))
+	assert.Equal(t, "192.0.2.1", clientIP("192.0.2.1:8080", "2001:db8::1"))
+	assert.Equal(t, "2001:db8::1", clientIP("10.0.0.1:8080", "203.0.113.9, 2001:DB8::1, 10.0.0.2"))
+	assert.Equal(t, "2001:db8::1", clientIP("10.0.0.1:8080", "[2001:db8::1]:443"))
+	assert.Equal(t, "10.0.0.1", clientIP("10.0.0.1:8080", "unknown"))
+
+	// Without ForwardingMiddleware, the peer address identifies the client.
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "[2001:db8::3]:51260"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	assert.Equal(t, "2001:db8::3", getClientIP(r, logger, "test"))
+}
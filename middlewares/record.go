@@ -0,0 +1,189 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"dito/app"
+	"dito/config"
+	"dito/logging"
+	"dito/metrics"
+	"dito/recording"
+	"dito/transform"
+	"dito/writer"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// recordingTimeout is the maximum time taken to store an exchange in Redis.
+const recordingTimeout = 5 * time.Second
+
+// RecordMiddleware captures the requests of a location and the responses sent to the clients, and stores them,
+// with their sensitive headers and body fields masked as in the logs, so that they can be replayed against a new
+// version of the backend. Compressed bodies are decompressed to be masked, and bodies that cannot be masked are
+// dropped. The exchanges are stored in the background, once the response was sent.
+//
+// Parameters:
+// - next: The next http.Handler in the chain.
+// - dito: The Dito application instance containing the configuration, Redis client, and logger.
+// - location: The label of the location (its name or path), used to name the recording and label the metrics.
+// - recordingConfig: The recording configuration of the location.
+// - writers: The pool providing the writers capturing the responses.
+//
+// Returns:
+// - http.Handler: A handler that records the exchanges.
+func RecordMiddleware(next http.Handler, dito *app.Dito, location string, recordingConfig config.Recording, writers *writer.Pool) http.Handler {
+	middlewareType := "RecordMiddleware"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rand.Float64()*100 >= recordingConfig.Percentage {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		requestHeader := r.Header.Clone()
+		var body *recordedBody
+		if r.Body != nil && r.Body != http.NoBody {
+			body = &recordedBody{ReadCloser: r.Body, maxSize: recordingConfig.MaxBodySize}
+			r.Body = body
+		}
+		lrw := writers.Get(w, false)
+		defer writers.Put(lrw)
+		lrw.MaxBodySize = recordingConfig.MaxBodySize
+
+		next.ServeHTTP(lrw, r)
+		if lrw.StatusCode == http.StatusSwitchingProtocols {
+			return
+		}
+
+		masking := dito.Config.Logging.Masking
+		exchange := recording.Exchange{
+			Time:     start,
+			Location: location,
+			Duration: float64(time.Since(start).Microseconds()) / 1000,
+			Request: recording.Request{
+				Method: r.Method,
+				URL:    r.URL.RequestURI(),
+				Host:   r.Host,
+				Header: logging.MaskHeaders(requestHeader, masking),
+			},
+			Response: recording.Response{
+				Status: lrw.StatusCode,
+				Header: logging.MaskHeaders(lrw.Header().Clone(), masking),
+			},
+		}
+		response := &exchange.Response
+		response.Body, response.BodyTruncated, response.BodyDropped = maskedBody(bytes.Clone(lrw.Body.Bytes()), response.Header, lrw.Truncated, recordingConfig.MaxBodySize, masking)
+		if body != nil {
+			request := &exchange.Request
+			request.Body, request.BodyTruncated, request.BodyDropped = maskedBody(body.data.Bytes(), request.Header, body.truncated, recordingConfig.MaxBodySize, masking)
+		}
+
+		go func() {
+			if err := storeExchange(dito, recordingConfig, exchange); err != nil {
+				dito.Logger.Warn(fmt.Sprintf("[%s] Failed to record %s %s: %v", middlewareType, r.Method, r.URL.Path, err))
+			}
+		}()
+	})
+}
+
+// storeExchange stores an exchange in the storage of the recording, and records it in the metrics.
+//
+// Parameters:
+// - dito: The Dito application instance containing the configuration and Redis client.
+// - recordingConfig: The recording configuration of the location.
+// - exchange: The exchange.
+//
+// Returns:
+// - error: An error if the exchange could not be stored.
+func storeExchange(dito *app.Dito, recordingConfig config.Recording, exchange recording.Exchange) error {
+	var err error
+	switch recordingConfig.Storage {
+	case config.RecordingStorageRedis:
+		if dito.RedisClient == nil {
+			err = fmt.Errorf("redis is not enabled")
+			break
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), recordingTimeout)
+		defer cancel()
+		err = recording.SaveRedis(ctx, dito.RedisClient, exchange, recordingConfig.MaxEntries)
+	default:
+		var file *recording.File
+		if file, err = recording.FileFor(recordingConfig.Directory, exchange.Location); err == nil {
+			err = file.Save(exchange, recordingConfig.MaxEntries)
+		}
+	}
+	if dito.Config.Metrics.Enabled {
+		metrics.RecordExchange(exchange.Location, err != nil)
+	}
+	return err
+}
+
+// maskedBody returns a captured body as it is recorded. A compressed body is decompressed so that it can be
+// masked, and its Content-Encoding removed from the recorded headers; a body that cannot be decompressed, such as
+// a truncated one, is dropped. A truncated JSON or XML body is cut after its last complete field, so that a field
+// cut in its name cannot escape the masking.
+//
+// Parameters:
+// - body: The captured body.
+// - header: The recorded headers of the body, updated when the body is decompressed.
+// - truncated: Whether the body was truncated at the maximum size.
+// - maxSize: The largest body recorded.
+// - masking: The masking configuration.
+//
+// Returns:
+// - []byte: The masked body, nil if it is dropped.
+// - bool: True if the body is truncated.
+// - bool: True if the body is dropped.
+func maskedBody(body []byte, header http.Header, truncated bool, maxSize int, masking config.LogMasking) ([]byte, bool, bool) {
+	if len(body) == 0 {
+		return body, truncated, false
+	}
+	if encoding := header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		if truncated {
+			return nil, true, true
+		}
+		decoded, decodedTruncated, err := transform.DecodeBody(body, header, maxSize)
+		if err != nil {
+			return nil, false, true
+		}
+		body, truncated = decoded, decodedTruncated
+		header.Del("Content-Encoding")
+		header.Del("Content-Length")
+	}
+
+	contentType := header.Get("Content-Type")
+	if truncated {
+		switch {
+		case writer.IsXML(contentType):
+			body = body[:bytes.LastIndexByte(body, '>')+1]
+		case writer.IsJSON(contentType):
+			body = body[:bytes.LastIndexAny(body, ",[{")+1]
+		}
+	}
+	return logging.MaskBody(body, contentType, masking), truncated, false
+}
+
+// recordedBody is a request body keeping a copy of the bytes read, up to a maximum size.
+type recordedBody struct {
+	io.ReadCloser
+	data      bytes.Buffer
+	maxSize   int
+	truncated bool
+}
+
+// Read reads the body and keeps a copy of the bytes read.
+func (b *recordedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if remaining := b.maxSize - b.data.Len(); n > remaining {
+		b.data.Write(p[:remaining])
+		b.truncated = true
+	} else {
+		b.data.Write(p[:n])
+	}
+	return n, err
+}
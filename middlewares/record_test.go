@@ -0,0 +1,125 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/gzip"
+	"dito/app"
+	"dito/config"
+	"dito/recording"
+	"dito/writer"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRecordMiddleware verifies that the requests and the responses are recorded with their sensitive headers
+// masked, and their bodies truncated at the maximum size.
+func TestRecordMiddleware(t *testing.T) {
+	dito := &app.Dito{
+		Config: &config.ProxyConfig{Logging: config.Logging{Masking: config.LogMasking{Headers: []string{"Authorization"}}}},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "created "+string(body))
+	})
+	recordingConfig := config.Recording{Enabled: true, Percentage: 100, Storage: config.RecordingStorageFile, Directory: t.TempDir(), MaxBodySize: 12}
+
+	req := httptest.NewRequest("POST", "/orders?draft=true", strings.NewReader("order"))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	RecordMiddleware(next, dito, "orders", recordingConfig, writer.NewPool()).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "created order", rec.Body.String())
+
+	var exchanges []recording.Exchange
+	assert.Eventually(t, func() bool {
+		file, err := os.Open(recording.FilePath(recordingConfig.Directory, "orders"))
+		if err != nil {
+			return false
+		}
+		defer file.Close()
+		exchanges, err = recording.Load(file)
+		return err == nil && len(exchanges) == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Len(t, exchanges, 1)
+
+	exchange := exchanges[0]
+	assert.Equal(t, "orders", exchange.Location)
+	assert.Equal(t, "/orders?draft=true", exchange.Request.URL)
+	assert.Equal(t, "***", exchange.Request.Header.Get("Authorization"))
+	assert.Equal(t, "order", string(exchange.Request.Body))
+	assert.Equal(t, http.StatusCreated, exchange.Response.Status)
+	assert.Equal(t, "created orde", string(exchange.Response.Body))
+	assert.True(t, exchange.Response.BodyTruncated)
+}
+
+// TestRecordMiddlewareMasking verifies that compressed bodies are decompressed to be masked, that bodies that
+// cannot be decompressed are dropped, and that truncated JSON bodies are cut after their last complete field.
+func TestRecordMiddlewareMasking(t *testing.T) {
+	dito := &app.Dito{
+		Config: &config.ProxyConfig{Logging: config.Logging{Masking: config.LogMasking{
+			CompiledFields: regexp.MustCompile(`("(?i:password)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^\s,}\]]+)`),
+		}}},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	record := func(maxBodySize int, encoding string, body []byte) recording.Exchange {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if encoding != "" {
+				w.Header().Set("Content-Encoding", encoding)
+			}
+			w.Write(body)
+		})
+		recordingConfig := config.Recording{Enabled: true, Percentage: 100, Storage: config.RecordingStorageFile, Directory: t.TempDir(), MaxEntries: 10, MaxBodySize: maxBodySize}
+		RecordMiddleware(next, dito, "login", recordingConfig, writer.NewPool()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/login", nil))
+
+		var exchanges []recording.Exchange
+		require.Eventually(t, func() bool {
+			file, err := os.Open(recording.FilePath(recordingConfig.Directory, "login"))
+			if err != nil {
+				return false
+			}
+			defer file.Close()
+			exchanges, err = recording.Load(file)
+			return err == nil && len(exchanges) == 1
+		}, time.Second, 10*time.Millisecond)
+		return exchanges[0]
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(`{"user":"alice","password":"secret"}`))
+	gz.Close()
+
+	exchange := record(1024, "gzip", compressed.Bytes())
+	assert.Equal(t, `{"user":"alice","password":"***"}`, string(exchange.Response.Body))
+	assert.Empty(t, exchange.Response.Header.Get("Content-Encoding"))
+	assert.False(t, exchange.Response.BodyDropped)
+
+	// A truncated compressed body cannot be decompressed.
+	exchange = record(10, "gzip", compressed.Bytes())
+	assert.Nil(t, exchange.Response.Body)
+	assert.True(t, exchange.Response.BodyDropped)
+
+	exchange = record(1024, "compress", []byte("opaque"))
+	assert.Nil(t, exchange.Response.Body)
+	assert.True(t, exchange.Response.BodyDropped)
+
+	// The body is cut in the name of the masked field.
+	exchange = record(22, "", []byte(`{"user":"alice","password":"secret"}`))
+	assert.Equal(t, `{"user":"alice",`, string(exchange.Response.Body))
+	assert.True(t, exchange.Response.BodyTruncated)
+}
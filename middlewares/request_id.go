@@ -0,0 +1,81 @@
+package middlewares
+
+import (
+	"crypto/rand"
+	"dito/audit"
+	"encoding/hex"
+	"net"
+	"net/http"
+)
+
+// RequestIDHeader is the header carrying the request ID, both to the upstream and back to the client.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request ID accepted from a client.
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware makes sure every request carries a request ID, so that access logs, audit
+// events, and upstream logs can be correlated. A valid ID sent by the client is kept, otherwise a
+// random one is generated. The ID is also returned in the response headers.
+//
+// Parameters:
+// - next: The next HTTP handler in the chain.
+//
+// Returns:
+// - http.Handler: The HTTP handler setting the request ID.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+			r.Header.Set(RequestIDHeader, requestID)
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newRequestID generates a random 128-bit request ID.
+func newRequestID() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// validRequestID reports whether a client supplied request ID is safe to propagate.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// auditRequest records an audit event triggered by a request, identifying the client and the request.
+//
+// Parameters:
+// - r: The HTTP request.
+// - eventType: The type of the audit event.
+// - details: Event specific information.
+func auditRequest(r *http.Request, eventType string, details map[string]string) {
+	audit.Record(audit.Event{
+		Type:      eventType,
+		Actor:     remoteHost(r.RemoteAddr),
+		RequestID: r.Header.Get(RequestIDHeader),
+		Details:   details,
+	})
+}
+
+// remoteHost returns the host part of a remote address.
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
@@ -0,0 +1,45 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRequestIDMiddleware verifies that valid client IDs are kept and others replaced.
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get(RequestIDHeader)
+	}))
+
+	tests := []struct {
+		clientID string
+		kept     bool
+	}{
+		{"abc-123", true},
+		{"", false},
+		{"has space", false},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.clientID != "" {
+			req.Header.Set(RequestIDHeader, tt.clientID)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.NotEmpty(t, seen)
+		assert.Equal(t, seen, rec.Header().Get(RequestIDHeader))
+		if tt.kept {
+			assert.Equal(t, tt.clientID, seen)
+		} else {
+			assert.NotEqual(t, tt.clientID, seen)
+			assert.Len(t, seen, 32)
+		}
+	}
+}
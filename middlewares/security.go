@@ -1,6 +1,7 @@
 package middlewares
 
 import (
+	"dito/audit"
 	"log/slog"
 	"net/http"
 )
@@ -18,6 +19,7 @@ func AuthMiddleware(next http.Handler, logger *slog.Logger) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Here you could verify an authentication token, for example
 		if r.Header.Get("Authorization") == "" {
+			auditRequest(r, audit.EventAuthFailure, map[string]string{"path": r.URL.Path, "reason": "missing_authorization"})
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -0,0 +1,151 @@
+package middlewares
+
+import (
+	"context"
+	"dito/app"
+	"dito/config"
+	"dito/metrics"
+	"dito/session"
+	"fmt"
+	"net/http"
+)
+
+// Events of the sessions, labeling the metrics.
+const (
+	sessionIssued    = "issued"
+	sessionSaved     = "saved"
+	sessionDestroyed = "destroyed"
+)
+
+// SessionMiddleware gives the clients of a location a server-side session. Clients without a valid session
+// cookie, or whose session is not stored, get a new signed session ID, so that an ID chosen before the login
+// cannot be carried into it; the data of the session is loaded from Redis and attached to the request context,
+// where the upstream writes it through the session response headers, and the configured values are forwarded to
+// the upstream in headers. Changes are saved once the request is handled, and each request extends the lifetime
+// of the session. If Redis is disabled or fails, the request gets 500.
+//
+// Parameters:
+// - next: The next http.Handler in the chain.
+// - dito: The Dito application instance containing the configuration, the Redis client, and the logger.
+// - location: The label of the location (its name or path), used to label the metrics.
+// - sessionConfig: The session configuration of the location.
+//
+// Returns:
+// - http.Handler: A handler that attaches the sessions.
+func SessionMiddleware(next http.Handler, dito *app.Dito, location string, sessionConfig config.Session) http.Handler {
+	middlewareType := "SessionMiddleware"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The session headers are only trusted when set by the proxy.
+		if sessionConfig.IDHeader != "" {
+			r.Header.Del(sessionConfig.IDHeader)
+		}
+		for _, header := range sessionConfig.Headers {
+			r.Header.Del(header)
+		}
+
+		if !dito.Config.Redis.Enabled || dito.RedisClient == nil {
+			dito.Logger.Error(fmt.Sprintf("[%s] Sessions of %s require Redis", middlewareType, location))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		redisClient := dito.RedisClient
+
+		var id string
+		var values map[string]string
+		stored := false
+		if cookie, err := r.Cookie(sessionConfig.CookieName); err == nil {
+			if verified, ok := session.VerifyCookie(cookie.Value, sessionConfig.Secret); ok {
+				if values, stored, err = session.Load(r.Context(), redisClient, verified); err != nil {
+					dito.Logger.Error(fmt.Sprintf("[%s] %v", middlewareType, err))
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+				// A signed ID without stored data, e.g. an expired or planted one, is not reused.
+				if stored {
+					id = verified
+				}
+			}
+		}
+		if id == "" {
+			issued, err := session.NewID()
+			if err != nil {
+				dito.Logger.Error(fmt.Sprintf("[%s] Error generating a session ID: %v", middlewareType, err))
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			id = issued
+			http.SetCookie(w, sessionCookie(sessionConfig, id))
+			recordSessionEvent(dito, location, sessionIssued)
+		}
+
+		current := session.New(id, values)
+		if sessionConfig.IDHeader != "" {
+			r.Header.Set(sessionConfig.IDHeader, id)
+		}
+		for key, header := range sessionConfig.Headers {
+			if value, ok := current.Get(key); ok {
+				r.Header.Set(header, value)
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(session.WithSession(r.Context(), current)))
+
+		// The session is saved even if the client went away before the end of the response.
+		ctx := context.WithoutCancel(r.Context())
+		changes, changed := current.Changes()
+		var err error
+		switch {
+		case changed && len(changes) == 0:
+			if stored {
+				err = session.Delete(ctx, redisClient, id)
+				recordSessionEvent(dito, location, sessionDestroyed)
+			}
+		case changed:
+			err = session.Save(ctx, redisClient, id, changes, sessionConfig.TTL)
+			recordSessionEvent(dito, location, sessionSaved)
+		case stored:
+			err = session.Touch(ctx, redisClient, id, sessionConfig.TTL)
+		}
+		if err != nil {
+			dito.Logger.Error(fmt.Sprintf("[%s] %v", middlewareType, err))
+		}
+	})
+}
+
+// sessionCookie builds the cookie carrying a signed session ID. It is HttpOnly and has no expiry: the session
+// ends with the browser, or when its data expires in Redis.
+//
+// Parameters:
+// - sessionConfig: The session configuration of the location.
+// - id: The session ID.
+//
+// Returns:
+// - *http.Cookie: The session cookie.
+func sessionCookie(sessionConfig config.Session, id string) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     sessionConfig.CookieName,
+		Value:    session.SignID(id, sessionConfig.Secret),
+		Path:     "/",
+		Domain:   sessionConfig.Domain,
+		Secure:   sessionConfig.Secure,
+		HttpOnly: true,
+	}
+	switch sessionConfig.SameSite {
+	case config.SameSiteStrict:
+		cookie.SameSite = http.SameSiteStrictMode
+	case config.SameSiteNone:
+		cookie.SameSite = http.SameSiteNoneMode
+		cookie.Secure = true
+	default:
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	return cookie
+}
+
+// recordSessionEvent records a session event if the metrics are enabled.
+func recordSessionEvent(dito *app.Dito, location, event string) {
+	if dito.Config.Metrics.Enabled {
+		metrics.RecordSessionEvent(location, event)
+	}
+}
@@ -0,0 +1,82 @@
+package middlewares
+
+import (
+	"dito/app"
+	"dito/config"
+	"dito/session"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSessionMiddlewareWithoutRedis verifies that the requests of a location with sessions fail without Redis,
+// rather than reaching the upstream without their session.
+func TestSessionMiddlewareWithoutRedis(t *testing.T) {
+	dito := &app.Dito{Config: &config.ProxyConfig{}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })
+	handler := SessionMiddleware(next, dito, "/app", config.Session{Enabled: true, Secret: "secret", CookieName: "dito_session"})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/app", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.False(t, called)
+}
+
+// TestSessionCookie verifies the attributes of the session cookie, and that its value carries the signed ID.
+func TestSessionCookie(t *testing.T) {
+	sessionConfig := config.Session{Secret: "secret", CookieName: "sid", Domain: "example.com", SameSite: config.SameSiteNone}
+	cookie := sessionCookie(sessionConfig, "id")
+	assert.Equal(t, "sid", cookie.Name)
+	assert.Equal(t, session.SignID("id", "secret"), cookie.Value)
+	assert.Equal(t, "/", cookie.Path)
+	assert.Equal(t, "example.com", cookie.Domain)
+	assert.True(t, cookie.HttpOnly)
+	assert.True(t, cookie.Secure)
+	assert.Equal(t, http.SameSiteNoneMode, cookie.SameSite)
+
+	sessionConfig.SameSite = config.SameSiteLax
+	assert.Equal(t, http.SameSiteLaxMode, sessionCookie(sessionConfig, "id").SameSite)
+}
+
+// TestSessionMiddlewareFixation verifies that the values written to a session are stored and forwarded with the
+// next requests, and that a validly signed ID without a stored session is replaced by a new one.
+func TestSessionMiddlewareFixation(t *testing.T) {
+	_, client := newTestRedis(t)
+	dito := &app.Dito{Config: &config.ProxyConfig{}, RedisClient: client, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	dito.Config.Redis.Enabled = true
+	sessionConfig := config.Session{Enabled: true, Secret: "secret", CookieName: "sid", TTL: time.Hour, Headers: map[string]string{"user": "X-User-ID"}}
+	var user string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = r.Header.Get("X-User-ID")
+		if current, ok := session.FromContext(r.Context()); ok && r.URL.Path == "/login" {
+			current.Set("user", "alice")
+		}
+	})
+	handler := SessionMiddleware(next, dito, "/app", sessionConfig)
+	serve := func(path string, cookie *http.Cookie) *http.Cookie {
+		req := httptest.NewRequest("GET", path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if cookies := rec.Result().Cookies(); len(cookies) > 0 {
+			return cookies[0]
+		}
+		return nil
+	}
+
+	planted := &http.Cookie{Name: "sid", Value: session.SignID("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", "secret")}
+	issued := serve("/login", planted)
+	if assert.NotNil(t, issued) {
+		assert.NotEqual(t, planted.Value, issued.Value)
+		assert.Nil(t, serve("/profile", issued))
+		assert.Equal(t, "alice", user)
+	}
+}
@@ -0,0 +1,142 @@
+package middlewares
+
+import (
+	"dito/app"
+	"dito/config"
+	"dito/metrics"
+	"dito/websocket"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Objectives checked by the slo middleware.
+const (
+	sloLatency   = "latency"
+	sloErrorRate = "error_rate"
+)
+
+// sloWindows holds the current window of each location, keyed by label, so that the counts and the alerts
+// survive configuration reloads.
+var sloWindows sync.Map
+
+// sloWindow counts the requests of a location during a window, and remembers the objectives missed by the
+// previous one.
+type sloWindow struct {
+	mu       sync.Mutex
+	start    time.Time
+	requests int64
+	slow     int64
+	errors   int64
+	alerts   map[string]bool
+}
+
+// SLOMiddleware checks the latency and the error rate of the requests of a location against its objectives.
+// The requests are counted over consecutive windows; a window is evaluated by the first request after its end,
+// and each objective it misses is logged as a warning and raises the slo_alert metric of the location, until a
+// window meets the objective again. Windows without traffic are not evaluated, so an alert is kept while the
+// location gets no requests. It measures the time spent in the handlers after it, so it comes first in the
+// middlewares of the location.
+//
+// Parameters:
+// - next: The next http.Handler in the chain.
+// - dito: The Dito application instance containing the configuration and logger.
+// - location: The label of the location (its name or path), used to label the logs and the metrics.
+// - slo: The objectives of the location.
+//
+// Returns:
+// - http.Handler: A handler that checks the objectives.
+func SLOMiddleware(next http.Handler, dito *app.Dito, location string, slo config.SLO) http.Handler {
+	value, _ := sloWindows.LoadOrStore(location, &sloWindow{start: time.Now(), alerts: map[string]bool{}})
+	window := value.(*sloWindow)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The duration of a WebSocket session says nothing about the latency of the location.
+		if websocket.IsWebSocketRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		lrw := passthroughWriters.Get(w, true)
+		defer passthroughWriters.Put(lrw)
+
+		start := time.Now()
+		next.ServeHTTP(lrw, r)
+		now := time.Now()
+		window.observe(dito, location, slo, now.Sub(start), lrw.StatusCode, now)
+	})
+}
+
+// observe counts a request in the current window, evaluating and resetting the window first if it has ended.
+//
+// Parameters:
+// - dito: The Dito application instance containing the configuration and logger.
+// - location: The label of the location.
+// - slo: The objectives of the location.
+// - duration: The time taken to answer the request.
+// - statusCode: The status code of the response (0 if none was written).
+// - now: The time at which the request ended.
+func (w *sloWindow) observe(dito *app.Dito, location string, slo config.SLO, duration time.Duration, statusCode int, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if now.Sub(w.start) >= slo.Window {
+		w.evaluate(dito, location, slo)
+		w.start = now
+		w.requests, w.slow, w.errors = 0, 0, 0
+	}
+	w.requests++
+	if slo.Latency.Threshold > 0 && duration > slo.Latency.Threshold {
+		w.slow++
+	}
+	if statusCode >= 500 {
+		w.errors++
+	}
+}
+
+// evaluate checks the ended window against the objectives, unless it has too few requests.
+//
+// Parameters:
+// - dito: The Dito application instance containing the configuration and logger.
+// - location: The label of the location.
+// - slo: The objectives of the location.
+func (w *sloWindow) evaluate(dito *app.Dito, location string, slo config.SLO) {
+	if w.requests < slo.MinRequests {
+		return
+	}
+	if slo.Latency.Threshold > 0 {
+		slowRate := float64(w.slow) / float64(w.requests) * 100
+		w.report(dito, location, slo, sloLatency, slowRate, 100-slo.Latency.Percentile,
+			"threshold", slo.Latency.Threshold.String(), "percentile", slo.Latency.Percentile)
+	}
+	if slo.MaxErrorRate > 0 {
+		errorRate := float64(w.errors) / float64(w.requests) * 100
+		w.report(dito, location, slo, sloErrorRate, errorRate, slo.MaxErrorRate)
+	}
+}
+
+// report logs an objective missed by the window, or met again after an alert, and records it.
+//
+// Parameters:
+// - dito: The Dito application instance containing the configuration and logger.
+// - location: The label of the location.
+// - slo: The objectives of the location.
+// - objective: The objective (latency or error_rate).
+// - rate: The percentage of the requests of the window that were slow or failed.
+// - maxRate: The percentage allowed by the objective.
+// - attrs: The settings of the objective, added to the log entries.
+func (w *sloWindow) report(dito *app.Dito, location string, slo config.SLO, objective string, rate, maxRate float64, attrs ...any) {
+	breached := rate > maxRate
+	attrs = append([]any{"location", location, "objective", objective, "window", slo.Window.String(), "requests", w.requests,
+		"rate", fmt.Sprintf("%.2f%%", rate), "max_rate", fmt.Sprintf("%.2f%%", maxRate)}, attrs...)
+	if breached {
+		dito.Logger.Warn(fmt.Sprintf("[SLOMiddleware] %s missed its %s objective", location, objective), attrs...)
+	} else if w.alerts[objective] {
+		dito.Logger.Info(fmt.Sprintf("[SLOMiddleware] %s meets its %s objective again", location, objective), attrs...)
+	}
+	w.alerts[objective] = breached
+	if dito.Config.Metrics.Enabled {
+		metrics.RecordSLOWindow(location, objective, breached)
+	}
+}
@@ -0,0 +1,74 @@
+package middlewares
+
+import (
+	"bytes"
+	"dito/app"
+	"dito/config"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSLOWindow verifies that an ended window missing an objective logs a warning, that a window meeting it
+// again clears the alert, and that windows with too few requests are not evaluated.
+func TestSLOWindow(t *testing.T) {
+	var logs bytes.Buffer
+	dito := &app.Dito{Config: &config.ProxyConfig{}, Logger: slog.New(slog.NewTextHandler(&logs, nil))}
+	slo := config.SLO{
+		Enabled:      true,
+		Window:       time.Minute,
+		MinRequests:  4,
+		Latency:      config.SLOLatency{Threshold: 100 * time.Millisecond, Percentile: 75},
+		MaxErrorRate: 10,
+	}
+	start := time.Now()
+	window := &sloWindow{start: start, alerts: map[string]bool{}}
+
+	// Half of the requests are slow, none fail.
+	for i := 0; i < 4; i++ {
+		window.observe(dito, "/slo", slo, time.Duration(i%2)*time.Second, http.StatusOK, start)
+	}
+	window.observe(dito, "/slo", slo, 0, http.StatusOK, start.Add(time.Minute))
+	assert.Contains(t, logs.String(), "/slo missed its latency objective")
+	assert.Contains(t, logs.String(), "rate=50.00%")
+	assert.NotContains(t, logs.String(), "error_rate")
+	assert.True(t, window.alerts[sloLatency])
+
+	// The window ending now has a single request, so it is not evaluated.
+	logs.Reset()
+	window.observe(dito, "/slo", slo, 0, http.StatusOK, start.Add(2*time.Minute))
+	assert.Empty(t, logs.String())
+	assert.True(t, window.alerts[sloLatency])
+
+	for i := 0; i < 4; i++ {
+		window.observe(dito, "/slo", slo, 0, http.StatusBadGateway, start.Add(2*time.Minute))
+	}
+	window.observe(dito, "/slo", slo, 0, http.StatusOK, start.Add(3*time.Minute))
+	assert.Contains(t, logs.String(), "/slo meets its latency objective again")
+	assert.Contains(t, logs.String(), "/slo missed its error_rate objective")
+	assert.False(t, window.alerts[sloLatency])
+	assert.True(t, window.alerts[sloErrorRate])
+}
+
+// TestSLOMiddleware verifies that the requests go through and are counted in the window of their location.
+func TestSLOMiddleware(t *testing.T) {
+	dito := &app.Dito{Config: &config.ProxyConfig{}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusServiceUnavailable) })
+	slo := config.SLO{Enabled: true, Window: time.Minute, MinRequests: 1, MaxErrorRate: 1}
+
+	rec := httptest.NewRecorder()
+	SLOMiddleware(next, dito, "/slo-middleware", slo).ServeHTTP(rec, httptest.NewRequest("GET", "/slo-middleware", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	value, ok := sloWindows.Load("/slo-middleware")
+	if assert.True(t, ok) {
+		window := value.(*sloWindow)
+		assert.Equal(t, int64(1), window.requests)
+		assert.Equal(t, int64(1), window.errors)
+	}
+}
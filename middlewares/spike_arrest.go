@@ -0,0 +1,67 @@
+package middlewares
+
+import (
+	"dito/audit"
+	"dito/config"
+	"dito/ratelimit"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// SpikeArrestMiddleware smooths request bursts: requests above the configured rate are held until their turn
+// comes instead of being rejected, as long as their delay stays within the configured budget. The rate applies
+// to the location as a whole, so the backend sees a steady flow whatever the number of clients.
+//
+// Parameters:
+// - next: The next http.Handler to be called once the request may proceed.
+// - limiters: The rate limiter manager holding the location limiter.
+// - location: The key of the location.
+// - spikeArrestConfig: The configuration for spike arrest.
+// - logger: The logger used to log messages.
+//
+// Returns:
+// - http.Handler: A handler that shapes the traffic of the location.
+func SpikeArrestMiddleware(next http.Handler, limiters *ratelimit.Manager, location string, spikeArrestConfig config.SpikeArrest, logger *slog.Logger) http.Handler {
+	middlewareType := "SpikeArrestMiddleware"
+	if !spikeArrestConfig.Enabled || spikeArrestConfig.RequestsPerSecond <= 0 {
+		logger.Debug(fmt.Sprintf("[%s] Spike arrest is disabled", middlewareType))
+		return next
+	}
+
+	burst := spikeArrestConfig.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	rateLimiting := config.RateLimiting{Enabled: true, RequestsPerSecond: spikeArrestConfig.RequestsPerSecond, Burst: burst}
+	namespace := "spike_arrest:" + location
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reservation := limiters.Reserve(namespace, "*", rateLimiting)
+		delay := reservation.Delay()
+		if !reservation.OK() || delay > spikeArrestConfig.MaxDelay {
+			reservation.Cancel()
+			logger.Debug(fmt.Sprintf("[%s] Delay %v exceeds the budget of %v for %s", middlewareType, delay, spikeArrestConfig.MaxDelay, r.URL.Path))
+			auditRequest(r, audit.EventRateLimitBlock, map[string]string{"limiter": "spike_arrest", "path": r.URL.Path})
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+
+		if delay > 0 {
+			logger.Debug(fmt.Sprintf("[%s] Delaying request to %s by %v", middlewareType, r.URL.Path, delay))
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				// The client went away: give the slot back to the requests still waiting.
+				timer.Stop()
+				reservation.Cancel()
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,50 @@
+package middlewares
+
+import (
+	"dito/config"
+	"dito/ratelimit"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSpikeArrestMiddleware verifies that requests above the rate are delayed within the budget and rejected beyond it.
+func TestSpikeArrestMiddleware(t *testing.T) {
+	limiters := ratelimit.NewManager()
+	defer limiters.Stop()
+
+	spikeArrest := config.SpikeArrest{Enabled: true, RequestsPerSecond: 10, Burst: 1, MaxDelay: 150 * time.Millisecond}
+	handler := SpikeArrestMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), limiters, "^/billing", spikeArrest, slog.Default())
+
+	serve := func() (int, time.Duration) {
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/billing", nil))
+		return rec.Code, time.Since(start)
+	}
+
+	status, elapsed := serve()
+	assert.Equal(t, http.StatusOK, status)
+	assert.Less(t, elapsed, 50*time.Millisecond)
+
+	// The second request waits for its turn, about 100ms later.
+	delayed := make(chan time.Duration)
+	go func() {
+		status, elapsed := serve()
+		assert.Equal(t, http.StatusOK, status)
+		delayed <- elapsed
+	}()
+
+	// A third one would have to wait about 200ms, beyond the budget.
+	time.Sleep(10 * time.Millisecond)
+	status, _ = serve()
+	assert.Equal(t, http.StatusTooManyRequests, status)
+
+	assert.GreaterOrEqual(t, <-delayed, 50*time.Millisecond)
+}
@@ -0,0 +1,88 @@
+package middlewares
+
+import (
+	"bytes"
+	"dito/config"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// defaultValidationMaxBodySize is the largest body read for the validation by default.
+const defaultValidationMaxBodySize = 1 << 20
+
+// RequestValidationMiddleware checks the requests of a location against its OpenAPI document or JSON schema,
+// and rejects the invalid ones with 422 before they reach the upstream. The body is read for the validation
+// and replayed to the next handler.
+//
+// Parameters:
+// - next: The next http.Handler to be called if the request is valid.
+// - validation: The request validation configuration.
+// - logger: The logger used to log messages.
+//
+// Returns:
+// - http.Handler: A handler that validates the requests.
+func RequestValidationMiddleware(next http.Handler, validation config.RequestValidation, logger *slog.Logger) http.Handler {
+	middlewareType := "RequestValidationMiddleware"
+	if !validation.Enabled || validation.Validator == nil {
+		logger.Debug(fmt.Sprintf("[%s] Request validation is disabled", middlewareType))
+		return next
+	}
+	maxBodySize := validation.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultValidationMaxBodySize
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := bufferBody(r, maxBodySize)
+		if errors.Is(err, errBodyTooLarge) {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			logger.Debug(fmt.Sprintf("[%s] Error reading the request body: %v", middlewareType, err))
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		if err := validation.Validator.Validate(r, body); err != nil {
+			logger.Debug(fmt.Sprintf("[%s] Invalid request %s %s: %v", middlewareType, r.Method, r.URL.Path, err))
+			http.Error(w, "Unprocessable Entity: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// errBodyTooLarge reports a request body larger than the limit of bufferBody.
+var errBodyTooLarge = errors.New("request body too large")
+
+// bufferBody reads a whole request body in memory, up to a limit, and replaces it with a replayable copy.
+//
+// Parameters:
+// - r: The HTTP request.
+// - limit: The largest body read.
+//
+// Returns:
+// - []byte: The body, nil if the request has none.
+// - error: errBodyTooLarge if the body exceeds the limit, or the error reading the body.
+func bufferBody(r *http.Request, limit int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errBodyTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	r.ContentLength = int64(len(body))
+	r.Header.Del("Transfer-Encoding")
+	r.TransferEncoding = nil
+	return body, nil
+}
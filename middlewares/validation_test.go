@@ -0,0 +1,64 @@
+package middlewares
+
+import (
+	"dito/config"
+	"dito/openapi"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRequestValidationMiddleware verifies that invalid requests are rejected with 422, oversized bodies with 413,
+// and that valid requests reach the next handler with their body intact.
+func TestRequestValidationMiddleware(t *testing.T) {
+	doc, err := openapi.Parse([]byte(`
+openapi: 3.0.3
+paths:
+  /items:
+    post:
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [name]
+              properties:
+                name: {type: string}
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	validation := config.RequestValidation{Enabled: true, MaxBodySize: 64, Validator: openapi.NewValidator(doc)}
+
+	var received string
+	handler := RequestValidationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		w.WriteHeader(http.StatusOK)
+	}), validation, slog.Default())
+
+	serve := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(`{"name": "pen"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `{"name": "pen"}`, received)
+
+	rec = serve(`{"name": 3}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "body.name: expected string")
+
+	rec = serve(`{"name": "` + strings.Repeat("x", 64) + `"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
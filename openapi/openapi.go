@@ -0,0 +1,187 @@
+package openapi
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Document is the part of an OpenAPI 3 document describing the routes of an API. JSON documents are read
+// as well, JSON being a subset of YAML.
+type Document struct {
+	OpenAPI string              `yaml:"openapi"` // Version of the OpenAPI specification.
+	Servers []Server            `yaml:"servers"` // Servers hosting the API; the first one is the upstream.
+	Paths   map[string]PathItem `yaml:"paths"`   // Path templates of the API, e.g. /pets/{petId}.
+	raw     map[string]any      // raw is the whole document, holding the schemas and the targets of the references.
+}
+
+// Server is a server hosting the API.
+type Server struct {
+	URL       string                    `yaml:"url"`       // URL of the server, possibly with {variables}.
+	Variables map[string]ServerVariable `yaml:"variables"` // Variables substituted in the URL.
+}
+
+// ServerVariable is a variable of a server URL.
+type ServerVariable struct {
+	Default string `yaml:"default"` // Value used for the variable.
+}
+
+// PathItem holds the operations of a path template.
+type PathItem struct {
+	Get     *Operation `yaml:"get"`
+	Put     *Operation `yaml:"put"`
+	Post    *Operation `yaml:"post"`
+	Delete  *Operation `yaml:"delete"`
+	Options *Operation `yaml:"options"`
+	Head    *Operation `yaml:"head"`
+	Patch   *Operation `yaml:"patch"`
+	Trace   *Operation `yaml:"trace"`
+}
+
+// Operation is an operation of a path.
+type Operation struct {
+	OperationID string `yaml:"operationId"` // Unique name of the operation.
+}
+
+// Route is a path of the API with the methods it accepts.
+type Route struct {
+	Template string   // Template is the OpenAPI path template, e.g. /pets/{petId}.
+	Pattern  string   // Pattern is the anchored regular expression matching the template.
+	Methods  []string // Methods are the HTTP methods of the operations of the path.
+}
+
+// templateParam matches the parameters of a path template.
+var templateParam = regexp.MustCompile(`\{[^{}/]+\}`)
+
+// Load reads an OpenAPI document from a YAML or JSON file.
+//
+// Parameters:
+// - file: The path to the document.
+//
+// Returns:
+// - *Document: The parsed document.
+// - error: An error if the file cannot be read or is not an OpenAPI 3 document.
+func Load(file string) (*Document, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse parses an OpenAPI document.
+//
+// Parameters:
+// - data: The YAML or JSON document.
+//
+// Returns:
+// - *Document: The parsed document.
+// - error: An error if the data is not an OpenAPI 3 document.
+func Parse(data []byte) (*Document, error) {
+	var doc Document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("unsupported OpenAPI version %q, expected 3.x", doc.OpenAPI)
+	}
+	if err := yaml.Unmarshal(data, &doc.raw); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %v", err)
+	}
+	return &doc, nil
+}
+
+// ServerURL returns the URL of the first server of the document, with its variables set to their defaults.
+//
+// Returns:
+// - string: The absolute URL of the server.
+// - error: An error if the document has no server or its URL is not absolute.
+func (d *Document) ServerURL() (string, error) {
+	if len(d.Servers) == 0 {
+		return "", fmt.Errorf("the OpenAPI document declares no server")
+	}
+	server := d.Servers[0]
+	serverURL := templateParam.ReplaceAllStringFunc(server.URL, func(param string) string {
+		if variable, ok := server.Variables[strings.Trim(param, "{}")]; ok {
+			return variable.Default
+		}
+		return param
+	})
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("the OpenAPI server URL %q is not absolute", serverURL)
+	}
+	return serverURL, nil
+}
+
+// Routes returns the routes of the document, skipping the paths without operations. Paths with fewer parameters
+// come first, so that a literal path such as /pets/mine wins over the template /pets/{petId} when the routes are
+// matched in order.
+//
+// Returns:
+// - []Route: The routes, in matching order.
+func (d *Document) Routes() []Route {
+	routes := make([]Route, 0, len(d.Paths))
+	for template, item := range d.Paths {
+		if methods := item.Methods(); len(methods) > 0 {
+			routes = append(routes, Route{Template: template, Pattern: PathPattern(template), Methods: methods})
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		pi, pj := len(templateParam.FindAllString(routes[i].Template, -1)), len(templateParam.FindAllString(routes[j].Template, -1))
+		if pi != pj {
+			return pi < pj
+		}
+		return routes[i].Template < routes[j].Template
+	})
+	return routes
+}
+
+// Methods returns the HTTP methods of the operations of the path item.
+func (p PathItem) Methods() []string {
+	var methods []string
+	for _, operation := range []struct {
+		method    string
+		operation *Operation
+	}{
+		{"GET", p.Get}, {"PUT", p.Put}, {"POST", p.Post}, {"DELETE", p.Delete},
+		{"OPTIONS", p.Options}, {"HEAD", p.Head}, {"PATCH", p.Patch}, {"TRACE", p.Trace},
+	} {
+		if operation.operation != nil {
+			methods = append(methods, operation.method)
+		}
+	}
+	return methods
+}
+
+// PathPattern converts an OpenAPI path template to an anchored regular expression, each parameter matching
+// a single path segment.
+//
+// Parameters:
+// - template: The path template, e.g. /pets/{petId}.
+//
+// Returns:
+// - string: The regular expression, e.g. ^/pets/[^/]+$.
+func PathPattern(template string) string {
+	return pathPattern(template, "[^/]+")
+}
+
+// pathPattern converts a path template to an anchored regular expression, the parameters matching param.
+func pathPattern(template, param string) string {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	last := 0
+	for _, match := range templateParam.FindAllStringIndex(template, -1) {
+		pattern.WriteString(regexp.QuoteMeta(template[last:match[0]]))
+		pattern.WriteString(param)
+		last = match[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(template[last:]))
+	pattern.WriteString("$")
+	return pattern.String()
+}
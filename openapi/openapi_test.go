@@ -0,0 +1,64 @@
+package openapi
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const petstore = `
+openapi: 3.0.3
+servers:
+  - url: https://{env}.example.com/v1
+    variables:
+      env: {default: api}
+paths:
+  /pets:
+    get: {operationId: listPets}
+    post: {operationId: createPet}
+  /pets/{petId}:
+    get: {operationId: getPet}
+    delete: {operationId: deletePet}
+  /pets/mine:
+    get: {operationId: listMyPets}
+  /health: {}
+`
+
+// TestRoutes verifies that the paths with operations become routes, literal paths first, and that server variables take their defaults.
+func TestRoutes(t *testing.T) {
+	doc, err := Parse([]byte(petstore))
+	if !assert.NoError(t, err) {
+		return
+	}
+	serverURL, err := doc.ServerURL()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://api.example.com/v1", serverURL)
+
+	routes := doc.Routes()
+	assert.Equal(t, []Route{
+		{Template: "/pets", Pattern: `^/pets$`, Methods: []string{"GET", "POST"}},
+		{Template: "/pets/mine", Pattern: `^/pets/mine$`, Methods: []string{"GET"}},
+		{Template: "/pets/{petId}", Pattern: `^/pets/[^/]+$`, Methods: []string{"GET", "DELETE"}},
+	}, routes)
+}
+
+// TestPathPattern verifies that path parameters match a single segment and literal parts are escaped.
+func TestPathPattern(t *testing.T) {
+	pattern := regexp.MustCompile(PathPattern("/v1.0/users/{id}/files/{name}.json"))
+	assert.True(t, pattern.MatchString("/v1.0/users/42/files/report.json"))
+	assert.False(t, pattern.MatchString("/v1x0/users/42/files/report.json"))
+	assert.False(t, pattern.MatchString("/v1.0/users/42/7/files/report.json"))
+	assert.False(t, pattern.MatchString("/v1.0/users/42/files/report.json/extra"))
+}
+
+// TestParseRejectsOtherVersions verifies that only OpenAPI 3 documents are accepted, and that a server is required for the target URL.
+func TestParseRejectsOtherVersions(t *testing.T) {
+	_, err := Parse([]byte(`swagger: "2.0"`))
+	assert.Error(t, err)
+
+	doc, err := Parse([]byte(`{"openapi": "3.1.0", "paths": {}}`))
+	assert.NoError(t, err)
+	_, err = doc.ServerURL()
+	assert.Error(t, err)
+}
@@ -0,0 +1,523 @@
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// patterns caches the compiled pattern keywords of the schemas.
+var patterns sync.Map
+
+// maxRefDepth bounds the chains of references, so that a reference cycle cannot loop forever.
+const maxRefDepth = 32
+
+// Validator validates requests against the operations of an OpenAPI document, or their bodies against a JSON
+// schema. Schemas support the usual keywords (type, enum, properties, required, items, bounds, pattern,
+// allOf/anyOf/oneOf); references are resolved within the document only.
+type Validator struct {
+	root       any              // root is the document the references are resolved in.
+	operations []operationRoute // operations are the paths of the document, in matching order.
+	bodySchema any              // bodySchema is the schema of the bodies, when validating against a JSON schema only.
+}
+
+// operationRoute is a path of the document with its operations.
+type operationRoute struct {
+	pattern *regexp.Regexp // pattern matches the path, capturing its parameters.
+	params  []string       // params are the names of the path parameters, in order.
+	item    map[string]any // item is the path item, holding the operations and their parameters.
+}
+
+// NewValidator creates a validator checking the requests against the operations of an OpenAPI document.
+//
+// Parameters:
+// - doc: The OpenAPI document.
+//
+// Returns:
+// - *Validator: The validator.
+func NewValidator(doc *Document) *Validator {
+	v := &Validator{root: doc.raw}
+	paths, _ := doc.raw["paths"].(map[string]any)
+	for _, route := range doc.Routes() {
+		item, _ := v.resolve(paths[route.Template]).(map[string]any)
+		var params []string
+		for _, param := range templateParam.FindAllString(route.Template, -1) {
+			params = append(params, strings.Trim(param, "{}"))
+		}
+		v.operations = append(v.operations, operationRoute{
+			pattern: regexp.MustCompile(pathPattern(route.Template, "([^/]+)")),
+			params:  params,
+			item:    item,
+		})
+	}
+	return v
+}
+
+// LoadSchemaValidator creates a validator checking the JSON request bodies against a JSON schema.
+//
+// Parameters:
+// - file: The path to the schema, in JSON or YAML.
+//
+// Returns:
+// - *Validator: The validator.
+// - error: An error if the file cannot be read or parsed.
+func LoadSchemaValidator(file string) (*Validator, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var schema map[string]any
+	if err := yaml.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON schema: %v", err)
+	}
+	return &Validator{root: schema, bodySchema: schema}, nil
+}
+
+// Validate checks a request: its path, query, header, and cookie parameters and its body against the matching
+// operation, or its body against the JSON schema.
+//
+// Parameters:
+// - r: The HTTP request.
+// - body: The request body, already read.
+//
+// Returns:
+// - error: An error describing the first violation, or nil if the request is valid.
+func (v *Validator) Validate(r *http.Request, body []byte) error {
+	if v.bodySchema != nil {
+		if len(body) == 0 {
+			return nil
+		}
+		return v.validateJSON(body, v.bodySchema)
+	}
+
+	for _, route := range v.operations {
+		match := route.pattern.FindStringSubmatch(r.URL.Path)
+		if match == nil {
+			continue
+		}
+		operation, ok := v.resolve(route.item[strings.ToLower(r.Method)]).(map[string]any)
+		if !ok {
+			continue
+		}
+		pathValues := make(map[string]string, len(route.params))
+		for i, name := range route.params {
+			value, err := url.PathUnescape(match[i+1])
+			if err != nil {
+				value = match[i+1]
+			}
+			pathValues[name] = value
+		}
+		if err := v.validateParameters(r, pathValues, route.item["parameters"], operation["parameters"]); err != nil {
+			return err
+		}
+		return v.validateBody(r, body, operation["requestBody"])
+	}
+	return fmt.Errorf("no operation matches %s %s", r.Method, r.URL.Path)
+}
+
+// validateParameters checks the parameters of a request, the ones of the operation overriding the ones of the path.
+func (v *Validator) validateParameters(r *http.Request, pathValues map[string]string, pathParams, operationParams any) error {
+	params := make(map[string]map[string]any)
+	var order []string
+	for _, list := range []any{pathParams, operationParams} {
+		items, _ := list.([]any)
+		for _, item := range items {
+			param, ok := v.resolve(item).(map[string]any)
+			if !ok {
+				continue
+			}
+			name, _ := param["name"].(string)
+			in, _ := param["in"].(string)
+			key := in + ":" + name
+			if _, ok := params[key]; !ok {
+				order = append(order, key)
+			}
+			params[key] = param
+		}
+	}
+
+	for _, key := range order {
+		param := params[key]
+		name, _ := param["name"].(string)
+		in, _ := param["in"].(string)
+		var values []string
+		switch in {
+		case "path":
+			if value, ok := pathValues[name]; ok {
+				values = []string{value}
+			}
+		case "query":
+			values = r.URL.Query()[name]
+		case "header":
+			values = r.Header.Values(name)
+		case "cookie":
+			if cookie, err := r.Cookie(name); err == nil {
+				values = []string{cookie.Value}
+			}
+		}
+		if len(values) == 0 {
+			if param["required"] == true || in == "path" {
+				return fmt.Errorf("%s parameter %q is required", in, name)
+			}
+			continue
+		}
+		schema := v.resolve(param["schema"])
+		if err := v.validateValue(schema, v.parameterValue(schema, values), fmt.Sprintf("%s parameter %q", in, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parameterValue converts the raw values of a parameter to the type of its schema: arrays are made of the
+// repeated values or the comma-separated ones, and numbers and booleans are parsed. A value that cannot be
+// parsed is kept as a string, for the schema to reject it.
+func (v *Validator) parameterValue(schema any, values []string) any {
+	s, _ := schema.(map[string]any)
+	if slices.Contains(schemaTypes(s), "array") {
+		if len(values) == 1 {
+			values = strings.Split(values[0], ",")
+		}
+		items := v.resolve(s["items"])
+		array := make([]any, len(values))
+		for i, value := range values {
+			array[i] = v.parameterValue(items, []string{value})
+		}
+		return array
+	}
+
+	value := values[0]
+	types := schemaTypes(s)
+	if slices.Contains(types, "integer") || slices.Contains(types, "number") {
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			return number
+		}
+	}
+	if slices.Contains(types, "boolean") {
+		if boolean, err := strconv.ParseBool(value); err == nil {
+			return boolean
+		}
+	}
+	return value
+}
+
+// validateBody checks the body of a request against the request body of its operation. Only the JSON bodies
+// are checked against their schema; the other media types are only checked to be declared.
+func (v *Validator) validateBody(r *http.Request, body []byte, requestBody any) error {
+	rb, ok := v.resolve(requestBody).(map[string]any)
+	if !ok {
+		return nil
+	}
+	if len(body) == 0 {
+		if rb["required"] == true {
+			return errors.New("request body is required")
+		}
+		return nil
+	}
+	content, _ := rb["content"].(map[string]any)
+	if len(content) == 0 {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	media, ok := matchMediaType(content, mediaType)
+	if !ok {
+		return fmt.Errorf("unsupported content type %q", mediaType)
+	}
+	schema, ok := media["schema"]
+	if !ok || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return nil
+	}
+	return v.validateJSON(body, schema)
+}
+
+// matchMediaType returns the media type object of the content matching a media type, exactly or by a wildcard.
+func matchMediaType(content map[string]any, mediaType string) (map[string]any, bool) {
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, key := range []string{mediaType, major + "/*", "*/*"} {
+		for name, media := range content {
+			if strings.EqualFold(name, key) {
+				m, _ := media.(map[string]any)
+				return m, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// validateJSON checks a JSON body against a schema.
+func (v *Validator) validateJSON(body []byte, schema any) error {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("body: invalid JSON: %v", err)
+	}
+	return v.validateValue(schema, value, "body")
+}
+
+// validateValue checks a value against a schema.
+//
+// Parameters:
+// - schema: The schema, possibly a reference.
+// - value: The value, as decoded from JSON.
+// - at: The location of the value, used in the error messages.
+//
+// Returns:
+// - error: An error describing the first violation, or nil if the value is valid.
+func (v *Validator) validateValue(schema any, value any, at string) error {
+	s, ok := v.resolve(schema).(map[string]any)
+	if !ok {
+		return nil
+	}
+	if value == nil && s["nullable"] == true {
+		return nil
+	}
+	if types := schemaTypes(s); len(types) > 0 && !slices.ContainsFunc(types, func(t string) bool { return hasType(value, t) }) {
+		return fmt.Errorf("%s: expected %s", at, strings.Join(types, " or "))
+	}
+	if enum, ok := s["enum"].([]any); ok && !slices.ContainsFunc(enum, func(e any) bool { return equalValues(e, value) }) {
+		return fmt.Errorf("%s: must be one of %v", at, enum)
+	}
+	if constant, ok := s["const"]; ok && !equalValues(constant, value) {
+		return fmt.Errorf("%s: must be %v", at, constant)
+	}
+
+	switch value := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(value)
+		if minLength, ok := number(s["minLength"]); ok && float64(length) < minLength {
+			return fmt.Errorf("%s: must be at least %v characters long", at, minLength)
+		}
+		if maxLength, ok := number(s["maxLength"]); ok && float64(length) > maxLength {
+			return fmt.Errorf("%s: must be at most %v characters long", at, maxLength)
+		}
+		if pattern, ok := s["pattern"].(string); ok {
+			if regex := compilePattern(pattern); regex != nil && !regex.MatchString(value) {
+				return fmt.Errorf("%s: must match %q", at, pattern)
+			}
+		}
+	case float64:
+		if err := checkBounds(s, value, at); err != nil {
+			return err
+		}
+	case []any:
+		if minItems, ok := number(s["minItems"]); ok && float64(len(value)) < minItems {
+			return fmt.Errorf("%s: must have at least %v items", at, minItems)
+		}
+		if maxItems, ok := number(s["maxItems"]); ok && float64(len(value)) > maxItems {
+			return fmt.Errorf("%s: must have at most %v items", at, maxItems)
+		}
+		if items, ok := s["items"]; ok {
+			for i, item := range value {
+				if err := v.validateValue(items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]any:
+		required, _ := s["required"].([]any)
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, ok := value[name]; !ok {
+					return fmt.Errorf("%s.%s: is required", at, name)
+				}
+			}
+		}
+		properties, _ := s["properties"].(map[string]any)
+		for name, property := range value {
+			propertySchema, ok := properties[name]
+			if !ok {
+				switch additional := s["additionalProperties"].(type) {
+				case bool:
+					if !additional {
+						return fmt.Errorf("%s.%s: is not allowed", at, name)
+					}
+					continue
+				case nil:
+					continue
+				default:
+					propertySchema = additional
+				}
+			}
+			if err := v.validateValue(propertySchema, property, at+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return v.validateComposition(s, value, at)
+}
+
+// validateComposition checks a value against the allOf, anyOf, and oneOf keywords of a schema.
+func (v *Validator) validateComposition(s map[string]any, value any, at string) error {
+	if allOf, ok := s["allOf"].([]any); ok {
+		for _, schema := range allOf {
+			if err := v.validateValue(schema, value, at); err != nil {
+				return err
+			}
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]any); ok && !slices.ContainsFunc(anyOf, func(schema any) bool {
+		return v.validateValue(schema, value, at) == nil
+	}) {
+		return fmt.Errorf("%s: matches none of the allowed schemas", at)
+	}
+	if oneOf, ok := s["oneOf"].([]any); ok {
+		matches := 0
+		for _, schema := range oneOf {
+			if v.validateValue(schema, value, at) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: must match exactly one schema, matches %d", at, matches)
+		}
+	}
+	return nil
+}
+
+// checkBounds checks a number against the minimum, maximum, and exclusive bounds of a schema. The exclusive
+// bounds are flags in OpenAPI 3.0, and numbers in OpenAPI 3.1 and JSON Schema.
+func checkBounds(s map[string]any, value float64, at string) error {
+	if minimum, ok := number(s["minimum"]); ok {
+		if s["exclusiveMinimum"] == true && value <= minimum {
+			return fmt.Errorf("%s: must be greater than %v", at, minimum)
+		}
+		if value < minimum {
+			return fmt.Errorf("%s: must be at least %v", at, minimum)
+		}
+	}
+	if maximum, ok := number(s["maximum"]); ok {
+		if s["exclusiveMaximum"] == true && value >= maximum {
+			return fmt.Errorf("%s: must be less than %v", at, maximum)
+		}
+		if value > maximum {
+			return fmt.Errorf("%s: must be at most %v", at, maximum)
+		}
+	}
+	if minimum, ok := number(s["exclusiveMinimum"]); ok && value <= minimum {
+		return fmt.Errorf("%s: must be greater than %v", at, minimum)
+	}
+	if maximum, ok := number(s["exclusiveMaximum"]); ok && value >= maximum {
+		return fmt.Errorf("%s: must be less than %v", at, maximum)
+	}
+	return nil
+}
+
+// resolve follows the references of a node to their targets in the document. A reference that cannot be
+// resolved yields nil, which no keyword constrains.
+func (v *Validator) resolve(node any) any {
+	for range maxRefDepth {
+		m, ok := node.(map[string]any)
+		if !ok {
+			return node
+		}
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return node
+		}
+		pointer, ok := strings.CutPrefix(ref, "#")
+		if !ok {
+			return nil
+		}
+		node = v.root
+		for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+			if token == "" {
+				continue
+			}
+			token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+			parent, ok := node.(map[string]any)
+			if !ok {
+				return nil
+			}
+			node = parent[token]
+		}
+	}
+	return nil
+}
+
+// compilePattern returns the compiled pattern keyword, or nil if it is not a valid regular expression.
+func compilePattern(pattern string) *regexp.Regexp {
+	if regex, ok := patterns.Load(pattern); ok {
+		return regex.(*regexp.Regexp)
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil
+	}
+	patterns.Store(pattern, regex)
+	return regex
+}
+
+// schemaTypes returns the types allowed by a schema: a single type, or a list of types in OpenAPI 3.1.
+func schemaTypes(s map[string]any) []string {
+	switch t := s["type"].(type) {
+	case string:
+		return []string{t}
+	case []any:
+		var types []string
+		for _, item := range t {
+			if item, ok := item.(string); ok {
+				types = append(types, item)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// hasType reports whether a value decoded from JSON has a schema type.
+func hasType(value any, t string) bool {
+	switch value := value.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case float64:
+		return t == "number" || (t == "integer" && value == math.Trunc(value))
+	case string:
+		return t == "string"
+	case []any:
+		return t == "array"
+	case map[string]any:
+		return t == "object"
+	}
+	return false
+}
+
+// number converts a number of the document, decoded as an integer or a float, to a float.
+func number(value any) (float64, bool) {
+	switch value := value.(type) {
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+	return 0, false
+}
+
+// equalValues compares a value of the document with a value decoded from JSON, numbers by their value.
+func equalValues(expected, value any) bool {
+	if n, ok := number(expected); ok {
+		m, ok := value.(float64)
+		return ok && n == m
+	}
+	return reflect.DeepEqual(expected, value)
+}
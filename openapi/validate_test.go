@@ -0,0 +1,133 @@
+package openapi
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const ordersAPI = `
+openapi: 3.0.3
+paths:
+  /orders:
+    get:
+      parameters:
+        - name: limit
+          in: query
+          schema: {type: integer, minimum: 1, maximum: 100}
+        - name: status
+          in: query
+          schema: {type: array, items: {type: string, enum: [open, closed]}}
+    post:
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema: {$ref: "#/components/schemas/Order"}
+  /orders/{orderId}:
+    parameters:
+      - name: orderId
+        in: path
+        required: true
+        schema: {type: string, pattern: "^[0-9]+$"}
+    get:
+      parameters:
+        - name: X-Tenant
+          in: header
+          required: true
+          schema: {type: string}
+components:
+  schemas:
+    Order:
+      type: object
+      required: [item, quantity]
+      additionalProperties: false
+      properties:
+        item: {type: string, minLength: 1}
+        quantity: {type: integer, minimum: 1}
+        note: {type: string, nullable: true}
+        tags: {type: array, maxItems: 2, items: {type: string}}
+`
+
+// TestValidatorOperations verifies the validation of the parameters and bodies of requests against the operations of a document.
+func TestValidatorOperations(t *testing.T) {
+	doc, err := Parse([]byte(ordersAPI))
+	if !assert.NoError(t, err) {
+		return
+	}
+	validator := NewValidator(doc)
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		headers map[string]string
+		body    string
+		errText string
+	}{
+		{name: "valid query", method: "GET", target: "/orders?limit=10&status=open&status=closed"},
+		{name: "query out of range", method: "GET", target: "/orders?limit=0", errText: `query parameter "limit": must be at least 1`},
+		{name: "query not an integer", method: "GET", target: "/orders?limit=ten", errText: `query parameter "limit": expected integer`},
+		{name: "query not in enum", method: "GET", target: "/orders?status=lost", errText: `query parameter "status"[0]: must be one of`},
+		{name: "valid path and header", method: "GET", target: "/orders/42", headers: map[string]string{"X-Tenant": "acme"}},
+		{name: "invalid path parameter", method: "GET", target: "/orders/abc", headers: map[string]string{"X-Tenant": "acme"}, errText: `path parameter "orderId": must match`},
+		{name: "missing header", method: "GET", target: "/orders/42", errText: `header parameter "X-Tenant" is required`},
+		{name: "valid body", method: "POST", target: "/orders", body: `{"item": "book", "quantity": 2, "note": null, "tags": ["gift"]}`},
+		{name: "missing body", method: "POST", target: "/orders", errText: "request body is required"},
+		{name: "missing property", method: "POST", target: "/orders", body: `{"item": "book"}`, errText: "body.quantity: is required"},
+		{name: "wrong type", method: "POST", target: "/orders", body: `{"item": "book", "quantity": 1.5}`, errText: "body.quantity: expected integer"},
+		{name: "unknown property", method: "POST", target: "/orders", body: `{"item": "book", "quantity": 1, "price": 3}`, errText: "body.price: is not allowed"},
+		{name: "too many items", method: "POST", target: "/orders", body: `{"item": "book", "quantity": 1, "tags": ["a", "b", "c"]}`, errText: "body.tags: must have at most 2 items"},
+		{name: "invalid JSON", method: "POST", target: "/orders", body: `{"item":`, errText: "body: invalid JSON"},
+		{name: "undeclared content type", method: "POST", target: "/orders", headers: map[string]string{"Content-Type": "text/plain"}, body: "book", errText: `unsupported content type "text/plain"`},
+		{name: "unknown operation", method: "DELETE", target: "/orders", errText: "no operation matches DELETE /orders"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			err := validator.Validate(req, []byte(tt.body))
+			if tt.errText == "" {
+				assert.NoError(t, err)
+			} else if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.errText)
+			}
+		})
+	}
+}
+
+// TestSchemaValidator verifies the validation of request bodies against a standalone JSON schema.
+func TestSchemaValidator(t *testing.T) {
+	file, err := os.CreateTemp("", "validate_schema_test_*.json")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(`{
+  "type": "object",
+  "required": ["id"],
+  "properties": {
+    "id": {"$ref": "#/$defs/id"},
+    "kind": {"oneOf": [{"const": "a"}, {"const": "b"}]}
+  },
+  "$defs": {"id": {"type": "string", "maxLength": 4}}
+}`)
+	assert.NoError(t, err)
+	file.Close()
+
+	validator, err := LoadSchemaValidator(file.Name())
+	if !assert.NoError(t, err) {
+		return
+	}
+	req := httptest.NewRequest("POST", "/anything", nil)
+	assert.NoError(t, validator.Validate(req, []byte(`{"id": "a1", "kind": "b"}`)))
+	assert.NoError(t, validator.Validate(req, nil))
+	assert.ErrorContains(t, validator.Validate(req, []byte(`{"id": "too long"}`)), "body.id: must be at most 4 characters long")
+	assert.ErrorContains(t, validator.Validate(req, []byte(`{"id": "a1", "kind": "c"}`)), "body.kind: must match exactly one schema")
+}
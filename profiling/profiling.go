@@ -0,0 +1,178 @@
+package profiling
+
+import (
+	"bytes"
+	"dito/config"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Defaults of the continuous profile export.
+const (
+	defaultDirectory   = "profiles"
+	defaultInterval    = time.Minute
+	defaultCPUDuration = 10 * time.Second
+	defaultRetain      = 10
+)
+
+// Profiler applies the runtime profiling settings and runs the continuous profile export.
+type Profiler struct {
+	mu     sync.Mutex
+	logger *slog.Logger
+	export config.ProfileExportConfig // export is the configuration of the running export.
+	stop   chan struct{}              // stop is closed to stop the running export; nil when none runs.
+	done   chan struct{}              // done is closed once the running export has stopped.
+}
+
+// NewProfiler creates a profiler. Configure must be called to apply a configuration.
+//
+// Parameters:
+// - logger: The logger used to report export failures.
+//
+// Returns:
+// - *Profiler: The profiler.
+func NewProfiler(logger *slog.Logger) *Profiler {
+	return &Profiler{logger: logger}
+}
+
+// Configure applies a profiling configuration: it sets the mutex and block profile rates, and starts,
+// restarts, or stops the continuous export when its settings changed.
+//
+// Parameters:
+// - profilingConfig: The profiling configuration.
+func (p *Profiler) Configure(profilingConfig config.ProfilingConfig) {
+	if profilingConfig.Enabled {
+		runtime.SetMutexProfileFraction(profilingConfig.MutexProfileFraction)
+		runtime.SetBlockProfileRate(profilingConfig.BlockProfileRate)
+	} else {
+		runtime.SetMutexProfileFraction(0)
+		runtime.SetBlockProfileRate(0)
+	}
+
+	export := exportDefaults(profilingConfig.Export)
+	export.Enabled = export.Enabled && profilingConfig.Enabled
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil && export == p.export {
+		return
+	}
+	p.stopExport()
+	p.export = export
+	if !export.Enabled {
+		return
+	}
+	if err := os.MkdirAll(export.Directory, 0o755); err != nil {
+		p.logger.Error(fmt.Sprintf("[Profiling] Failed to create the profile directory: %v", err))
+		return
+	}
+	p.stop, p.done = make(chan struct{}), make(chan struct{})
+	go p.run(export, p.stop, p.done)
+}
+
+// Stop stops the continuous export.
+func (p *Profiler) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopExport()
+}
+
+// stopExport stops the running export and waits for it. It must be called with mu held.
+func (p *Profiler) stopExport() {
+	if p.stop == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+	p.stop, p.done = nil, nil
+}
+
+// run exports the profiles at every interval until stop is closed.
+func (p *Profiler) run(export config.ProfileExportConfig, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(export.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if err := exportProfiles(export, now, stop); err != nil {
+				p.logger.Warn(fmt.Sprintf("[Profiling] %v", err))
+			}
+		}
+	}
+}
+
+// exportProfiles writes a CPU and a heap profile to the export directory and removes the oldest profiles.
+func exportProfiles(export config.ProfileExportConfig, now time.Time, stop <-chan struct{}) error {
+	stamp := now.UTC().Format("20060102T150405Z")
+
+	var cpu bytes.Buffer
+	if err := pprof.StartCPUProfile(&cpu); err != nil {
+		// The CPU is already being profiled, e.g. through the admin API.
+		return fmt.Errorf("skipped CPU profile: %v", err)
+	}
+	timer := time.NewTimer(export.CPUDuration)
+	select {
+	case <-timer.C:
+	case <-stop:
+		timer.Stop()
+	}
+	pprof.StopCPUProfile()
+	if err := writeProfile(export, "cpu", stamp, cpu.Bytes()); err != nil {
+		return err
+	}
+
+	var heap bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&heap, 0); err != nil {
+		return fmt.Errorf("failed to capture heap profile: %v", err)
+	}
+	return writeProfile(export, "heap", stamp, heap.Bytes())
+}
+
+// writeProfile writes a profile to the export directory, keeping the most recent profiles of its kind.
+func writeProfile(export config.ProfileExportConfig, kind, stamp string, data []byte) error {
+	path := filepath.Join(export.Directory, kind+"-"+stamp+".pprof")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s profile: %v", kind, err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(export.Directory, kind+"-*.pprof"))
+	if err != nil {
+		return err
+	}
+	// The timestamps sort chronologically.
+	slices.Sort(files)
+	for len(files) > export.Retain {
+		os.Remove(files[0])
+		files = files[1:]
+	}
+	return nil
+}
+
+// exportDefaults fills in the defaults of the unset export settings.
+func exportDefaults(export config.ProfileExportConfig) config.ProfileExportConfig {
+	if export.Directory == "" {
+		export.Directory = defaultDirectory
+	}
+	if export.Interval <= 0 {
+		export.Interval = defaultInterval
+	}
+	if export.CPUDuration <= 0 {
+		export.CPUDuration = defaultCPUDuration
+	}
+	// The CPU profile must end before the next export starts.
+	export.CPUDuration = min(export.CPUDuration, export.Interval)
+	if export.Retain <= 0 {
+		export.Retain = defaultRetain
+	}
+	return export
+}
@@ -0,0 +1,44 @@
+package profiling
+
+import (
+	"dito/config"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestContinuousExport verifies that profiles are exported periodically and that only the most recent are kept.
+func TestContinuousExport(t *testing.T) {
+	directory := t.TempDir()
+	profiler := NewProfiler(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	profilingConfig := config.ProfilingConfig{
+		Enabled: true,
+		Export:  config.ProfileExportConfig{Enabled: true, Directory: directory, Interval: 20 * time.Millisecond, CPUDuration: 5 * time.Millisecond, Retain: 2},
+	}
+	profiler.Configure(profilingConfig)
+
+	count := func(kind string) int {
+		files, _ := filepath.Glob(filepath.Join(directory, kind+"-*.pprof"))
+		return len(files)
+	}
+	assert.Eventually(t, func() bool { return count("heap") == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	// Disabling profiling stops the export, even if the export settings are unchanged.
+	profilingConfig.Enabled = false
+	profiler.Configure(profilingConfig)
+	assert.Nil(t, profiler.stop)
+	assert.LessOrEqual(t, count("cpu"), 2)
+	assert.LessOrEqual(t, count("heap"), 2)
+}
+
+// TestExportDefaults verifies the defaults of the export settings.
+func TestExportDefaults(t *testing.T) {
+	export := exportDefaults(config.ProfileExportConfig{Interval: 5 * time.Second})
+	assert.Equal(t, "profiles", export.Directory)
+	assert.Equal(t, 5*time.Second, export.CPUDuration)
+	assert.Equal(t, 10, export.Retain)
+}
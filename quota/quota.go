@@ -0,0 +1,205 @@
+package quota
+
+import (
+	"context"
+	"crypto/sha256"
+	"dito/config"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	keyPrefix = "quota:"
+	// DefaultKeyHeader is the header carrying the API key when none is configured.
+	DefaultKeyHeader = "X-API-Key"
+	// expirySlack keeps a counter around a little after its window ends, so that it can still be inspected.
+	expirySlack = time.Hour
+)
+
+// Usage describes the consumption of a quota by an API key in the current window.
+type Usage struct {
+	Name      string    `json:"name"`      // Name is the name of the quota.
+	KeyHash   string    `json:"key_hash"`  // KeyHash is the SHA-256 of the API key, which is never stored.
+	Window    string    `json:"window"`    // Window is the quota window ("day" or "month").
+	Limit     int64     `json:"limit"`     // Limit is the number of requests allowed in the window.
+	Used      int64     `json:"used"`      // Used is the number of requests counted in the window.
+	Remaining int64     `json:"remaining"` // Remaining is the number of requests still allowed in the window.
+	Reset     time.Time `json:"reset"`     // Reset is when the current window ends.
+}
+
+// Exceeded reports whether the quota has been exhausted.
+func (u Usage) Exceeded() bool {
+	return u.Used > u.Limit
+}
+
+// KeyHeader returns the header carrying the API key of a quota.
+//
+// Parameters:
+// - quotaConfig: The quota configuration.
+//
+// Returns:
+// - string: The header name.
+func KeyHeader(quotaConfig config.Quota) string {
+	if quotaConfig.KeyHeader == "" {
+		return DefaultKeyHeader
+	}
+	return quotaConfig.KeyHeader
+}
+
+// Find returns the quota with the given name among the locations.
+//
+// Parameters:
+// - locations: The location configurations.
+// - name: The quota name.
+//
+// Returns:
+// - config.Quota: The quota configuration.
+// - bool: True if an enabled quota with the name exists.
+func Find(locations []config.LocationConfig, name string) (config.Quota, bool) {
+	for _, location := range locations {
+		if location.Quota.Enabled && location.Quota.Name == name {
+			return location.Quota, true
+		}
+	}
+	return config.Quota{}, false
+}
+
+// Consume counts a request of an API key against a quota.
+//
+// Parameters:
+// - ctx: The context of the Redis calls.
+// - client: The Redis client.
+// - quotaConfig: The quota configuration.
+// - apiKey: The API key.
+// - now: The current time.
+//
+// Returns:
+// - Usage: The usage including the request; the request is over quota if Exceeded is true.
+// - error: An error if the counter cannot be updated.
+func Consume(ctx context.Context, client *redis.Client, quotaConfig config.Quota, apiKey string, now time.Time) (Usage, error) {
+	key, reset := counterKey(quotaConfig, apiKey, now)
+
+	pipe := client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.ExpireAt(ctx, key, reset.Add(expirySlack))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Usage{}, fmt.Errorf("failed to count quota %s: %v", quotaConfig.Name, err)
+	}
+	return newUsage(quotaConfig, apiKey, incr.Val(), reset), nil
+}
+
+// Get returns the usage of a quota by an API key, without counting a request.
+//
+// Parameters:
+// - ctx: The context of the Redis calls.
+// - client: The Redis client.
+// - quotaConfig: The quota configuration.
+// - apiKey: The API key.
+// - now: The current time.
+//
+// Returns:
+// - Usage: The usage in the current window.
+// - error: An error if the counter cannot be read.
+func Get(ctx context.Context, client *redis.Client, quotaConfig config.Quota, apiKey string, now time.Time) (Usage, error) {
+	key, reset := counterKey(quotaConfig, apiKey, now)
+	used, err := client.Get(ctx, key).Int64()
+	if err != nil && err != redis.Nil {
+		return Usage{}, fmt.Errorf("failed to read quota %s: %v", quotaConfig.Name, err)
+	}
+	return newUsage(quotaConfig, apiKey, used, reset), nil
+}
+
+// Reset clears the usage of a quota by an API key in the current window.
+//
+// Parameters:
+// - ctx: The context of the Redis calls.
+// - client: The Redis client.
+// - quotaConfig: The quota configuration.
+// - apiKey: The API key.
+// - now: The current time.
+//
+// Returns:
+// - error: An error if the counter cannot be deleted.
+func Reset(ctx context.Context, client *redis.Client, quotaConfig config.Quota, apiKey string, now time.Time) error {
+	key, _ := counterKey(quotaConfig, apiKey, now)
+	if err := client.Del(ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to reset quota %s: %v", quotaConfig.Name, err)
+	}
+	return nil
+}
+
+// Window returns the identifier and the end of the quota window containing a time, in UTC.
+//
+// Parameters:
+// - window: The quota window ("day" or "month").
+// - now: The time.
+//
+// Returns:
+// - string: The window identifier (e.g. 2024-05-31 or 2024-05).
+// - time.Time: The end of the window.
+func Window(window string, now time.Time) (string, time.Time) {
+	now = now.UTC()
+	if window == config.QuotaWindowMonth {
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start.Format("2006-01"), start.AddDate(0, 1, 0)
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return start.Format("2006-01-02"), start.AddDate(0, 0, 1)
+}
+
+// KeyHash returns the hex-encoded SHA-256 of an API key, which identifies it in Redis and in the admin API.
+//
+// Parameters:
+// - apiKey: The API key.
+//
+// Returns:
+// - string: The hash.
+func KeyHash(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// Validator returns a function reporting whether an API key is accepted by a quota: one of its keys, or any key
+// when the quota has none, its key header being set by the introspection of the location.
+//
+// Parameters:
+// - quotaConfig: The quota configuration.
+//
+// Returns:
+// - func(string) bool: The validator.
+func Validator(quotaConfig config.Quota) func(apiKey string) bool {
+	if len(quotaConfig.Keys) == 0 {
+		return func(string) bool { return true }
+	}
+	accepted := make(map[[sha256.Size]byte]struct{}, len(quotaConfig.Keys))
+	for _, key := range quotaConfig.Keys {
+		accepted[sha256.Sum256([]byte(key))] = struct{}{}
+	}
+	return func(apiKey string) bool {
+		_, ok := accepted[sha256.Sum256([]byte(apiKey))]
+		return ok
+	}
+}
+
+// counterKey returns the Redis key counting the requests of an API key in the current window, and the window end.
+// The key is hashed, so that it is not stored.
+func counterKey(quotaConfig config.Quota, apiKey string, now time.Time) (string, time.Time) {
+	period, reset := Window(quotaConfig.Window, now)
+	return keyPrefix + quotaConfig.Name + ":" + period + ":" + KeyHash(apiKey), reset
+}
+
+// newUsage builds the usage of a quota from its counter.
+func newUsage(quotaConfig config.Quota, apiKey string, used int64, reset time.Time) Usage {
+	return Usage{
+		Name:      quotaConfig.Name,
+		KeyHash:   KeyHash(apiKey),
+		Window:    quotaConfig.Window,
+		Limit:     quotaConfig.Limit,
+		Used:      used,
+		Remaining: max(quotaConfig.Limit-used, 0),
+		Reset:     reset,
+	}
+}
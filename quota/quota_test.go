@@ -0,0 +1,68 @@
+package quota
+
+import (
+	"dito/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWindow verifies the identifiers and ends of the daily and monthly windows.
+func TestWindow(t *testing.T) {
+	now := time.Date(2024, time.December, 31, 23, 30, 0, 0, time.FixedZone("CET", 3600))
+
+	period, reset := Window(config.QuotaWindowDay, now)
+	assert.Equal(t, "2024-12-31", period)
+	assert.Equal(t, time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC), reset)
+
+	period, reset = Window(config.QuotaWindowMonth, now)
+	assert.Equal(t, "2024-12", period)
+	assert.Equal(t, time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC), reset)
+}
+
+// TestUsage verifies the remaining quota and the exceeded state.
+func TestUsage(t *testing.T) {
+	quotaConfig := config.Quota{Name: "partners", Limit: 2, Window: config.QuotaWindowDay}
+
+	usage := newUsage(quotaConfig, "key", 2, time.Time{})
+	assert.Equal(t, int64(0), usage.Remaining)
+	assert.False(t, usage.Exceeded())
+
+	usage = newUsage(quotaConfig, "key", 3, time.Time{})
+	assert.Equal(t, int64(0), usage.Remaining)
+	assert.True(t, usage.Exceeded())
+	assert.Equal(t, KeyHash("key"), usage.KeyHash)
+}
+
+// TestValidator verifies that only the configured keys are accepted, and that the counters are keyed on their
+// hash rather than on the keys.
+func TestValidator(t *testing.T) {
+	accepted := Validator(config.Quota{Keys: []string{"alpha", "beta"}})
+	assert.True(t, accepted("alpha"))
+	assert.True(t, accepted("beta"))
+	assert.False(t, accepted("gamma"))
+	assert.False(t, accepted(""))
+
+	// Without keys, the key header is set by the introspection of the location, which validated it.
+	assert.True(t, Validator(config.Quota{})("gamma"))
+
+	key, _ := counterKey(config.Quota{Name: "partners", Window: config.QuotaWindowDay}, "alpha", time.Date(2024, time.May, 31, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, "quota:partners:2024-05-31:"+KeyHash("alpha"), key)
+	assert.NotContains(t, key, "alpha")
+}
+
+// TestFind verifies that quotas are looked up by name among the enabled ones.
+func TestFind(t *testing.T) {
+	locations := []config.LocationConfig{
+		{Path: "^/a", Quota: config.Quota{Name: "disabled"}},
+		{Path: "^/b", Quota: config.Quota{Enabled: true, Name: "partners", Limit: 10}},
+	}
+	quotaConfig, ok := Find(locations, "partners")
+	assert.True(t, ok)
+	assert.Equal(t, int64(10), quotaConfig.Limit)
+
+	_, ok = Find(locations, "disabled")
+	assert.False(t, ok)
+	assert.Equal(t, DefaultKeyHeader, KeyHeader(quotaConfig))
+}
@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"dito/config"
+	"sync"
+	"time"
+)
+
+// Defaults of the adaptive concurrency limiter.
+const (
+	defaultInitialLimit     = 20
+	defaultMinLimit         = 1
+	defaultMaxLimit         = 1000
+	defaultLatencyTolerance = 2.0
+	defaultBackoffRatio     = 0.9
+	// baselineWindow is how long the lowest latency observed is kept as the baseline, so that the
+	// baseline follows lasting changes of the upstream (e.g. a slower deployment).
+	baselineWindow = 30 * time.Second
+)
+
+// AdaptiveLimiter limits the requests in flight to an upstream with a limit adjusted by AIMD: the limit
+// grows by one for every fast response while it is nearly used up, and is multiplied by the backoff
+// ratio, at most once per round trip, when the latency rises above the tolerated ratio of the baseline
+// or the upstream reports overload.
+type AdaptiveLimiter struct {
+	mu           sync.Mutex
+	config       config.AdaptiveConcurrency
+	limit        float64
+	inFlight     int
+	baseline     time.Duration // baseline is the lowest latency of the previous window.
+	windowMin    time.Duration // windowMin is the lowest latency of the current window.
+	windowStart  time.Time
+	lastDecrease time.Time
+}
+
+// NewAdaptiveLimiter creates an adaptive limiter, applying the defaults for unset values.
+//
+// Parameters:
+// - adaptiveConfig: The adaptive concurrency configuration.
+//
+// Returns:
+// - *AdaptiveLimiter: The limiter.
+func NewAdaptiveLimiter(adaptiveConfig config.AdaptiveConcurrency) *AdaptiveLimiter {
+	if adaptiveConfig.MinLimit <= 0 {
+		adaptiveConfig.MinLimit = defaultMinLimit
+	}
+	if adaptiveConfig.MaxLimit <= 0 {
+		adaptiveConfig.MaxLimit = defaultMaxLimit
+	}
+	if adaptiveConfig.MaxLimit < adaptiveConfig.MinLimit {
+		adaptiveConfig.MaxLimit = adaptiveConfig.MinLimit
+	}
+	if adaptiveConfig.InitialLimit <= 0 {
+		adaptiveConfig.InitialLimit = defaultInitialLimit
+	}
+	adaptiveConfig.InitialLimit = min(max(adaptiveConfig.InitialLimit, adaptiveConfig.MinLimit), adaptiveConfig.MaxLimit)
+	if adaptiveConfig.LatencyTolerance <= 1 {
+		adaptiveConfig.LatencyTolerance = defaultLatencyTolerance
+	}
+	if adaptiveConfig.BackoffRatio <= 0 || adaptiveConfig.BackoffRatio >= 1 {
+		adaptiveConfig.BackoffRatio = defaultBackoffRatio
+	}
+	return &AdaptiveLimiter{config: adaptiveConfig, limit: float64(adaptiveConfig.InitialLimit)}
+}
+
+// Acquire takes an in-flight slot if the current limit allows it.
+//
+// Returns:
+// - bool: True if the request may proceed; Release must then be called once it completes.
+func (l *AdaptiveLimiter) Acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight >= int(l.limit) {
+		return false
+	}
+	l.inFlight++
+	return true
+}
+
+// Release gives back the slot of a completed request and adjusts the limit with its outcome.
+//
+// Parameters:
+// - latency: The time the upstream took to serve the request.
+// - overloaded: Whether the upstream reported overload (e.g. 503) or timed out.
+func (l *AdaptiveLimiter) Release(latency time.Duration, overloaded bool) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	saturated := l.inFlight*2 >= int(l.limit)
+	l.inFlight--
+
+	if !overloaded {
+		l.observe(latency, now)
+	}
+	slow := l.baseline > 0 && float64(latency) > float64(l.baseline)*l.config.LatencyTolerance
+
+	switch {
+	case overloaded || slow:
+		// Back off at most once per round trip, so that the responses of a single slow period
+		// do not collapse the limit.
+		if now.Sub(l.lastDecrease) >= latency {
+			l.limit = max(l.limit*l.config.BackoffRatio, float64(l.config.MinLimit))
+			l.lastDecrease = now
+		}
+	case saturated:
+		l.limit = min(l.limit+1, float64(l.config.MaxLimit))
+	}
+}
+
+// Limit returns the current limit of in-flight requests.
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// InFlight returns the number of requests in flight.
+func (l *AdaptiveLimiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// observe folds a latency sample into the baseline.
+func (l *AdaptiveLimiter) observe(latency time.Duration, now time.Time) {
+	if l.windowStart.IsZero() || now.Sub(l.windowStart) >= baselineWindow {
+		if l.windowMin > 0 {
+			l.baseline = l.windowMin
+		}
+		l.windowMin = 0
+		l.windowStart = now
+	}
+	if l.windowMin == 0 || latency < l.windowMin {
+		l.windowMin = latency
+	}
+	if l.baseline == 0 || latency < l.baseline {
+		l.baseline = latency
+	}
+}
@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"dito/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAdaptiveLimiterAcquire verifies that no more requests than the limit are admitted.
+func TestAdaptiveLimiterAcquire(t *testing.T) {
+	l := NewAdaptiveLimiter(config.AdaptiveConcurrency{InitialLimit: 2})
+	assert.True(t, l.Acquire())
+	assert.True(t, l.Acquire())
+	assert.False(t, l.Acquire())
+
+	l.Release(time.Millisecond, false)
+	assert.True(t, l.Acquire())
+	assert.Equal(t, 2, l.InFlight())
+}
+
+// TestAdaptiveLimiterAIMD verifies that the limit grows with fast responses and shrinks with slow ones.
+func TestAdaptiveLimiterAIMD(t *testing.T) {
+	l := NewAdaptiveLimiter(config.AdaptiveConcurrency{InitialLimit: 10, MinLimit: 2, MaxLimit: 12})
+
+	// Fast responses while the limit is nearly used up raise it, up to the maximum.
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 8; j++ {
+			l.Acquire()
+		}
+		for j := 0; j < 8; j++ {
+			l.Release(10*time.Millisecond, false)
+		}
+	}
+	assert.Equal(t, 12, l.Limit())
+
+	// A response well above the baseline latency lowers it.
+	l.Acquire()
+	l.Release(100*time.Millisecond, false)
+	assert.Equal(t, 10, l.Limit())
+
+	// Overload signals keep lowering it, down to the minimum.
+	for i := 0; i < 50; i++ {
+		l.Acquire()
+		l.Release(0, true)
+	}
+	assert.Equal(t, 2, l.Limit())
+}
@@ -0,0 +1,222 @@
+package ratelimit
+
+import (
+	"context"
+	"dito/config"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// cleanupInterval is how often the janitor looks for idle clients.
+	cleanupInterval = time.Minute
+	// clientIdleTimeout is how long a client limiter is kept after the last request of the client.
+	clientIdleTimeout = 3 * time.Minute
+)
+
+// clientLimiter is the token bucket of a single client.
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen atomic.Int64 // lastSeen is the Unix time of the last request of the client.
+}
+
+// bucketSet holds the client limiters of a single namespace.
+type bucketSet struct {
+	config  config.RateLimiting
+	mu      sync.RWMutex
+	clients map[string]*clientLimiter
+}
+
+// Manager owns the in-memory rate limiters of a configuration. Each namespace (a location, a group of
+// locations, or the global scope) has its own set of client limiters, so that a client hitting one
+// location does not consume the budget of another.
+// A single janitor goroutine evicts idle clients until Stop is called.
+type Manager struct {
+	mu         sync.RWMutex
+	namespaces map[string]*bucketSet
+	adaptive   map[string]*AdaptiveLimiter
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+// NewManager creates a rate limiter manager and starts its janitor.
+//
+// Returns:
+// - *Manager: The rate limiter manager, to be stopped when the configuration is replaced.
+func NewManager() *Manager {
+	m := &Manager{
+		namespaces: make(map[string]*bucketSet),
+		adaptive:   make(map[string]*AdaptiveLimiter),
+		stop:       make(chan struct{}),
+	}
+	go m.janitor()
+	return m
+}
+
+// Allow reports whether a request of the client is allowed by the limiters of a namespace.
+// The limiters of a namespace are created with the configuration of its first request.
+//
+// Parameters:
+// - namespace: The namespace of the limiters (see Namespace).
+// - client: The key of the client (see ClientKey).
+// - rateLimitingConfig: The rate limiting configuration of the location.
+//
+// Returns:
+// - bool: True if the request is allowed.
+func (m *Manager) Allow(namespace, client string, rateLimitingConfig config.RateLimiting) bool {
+	return m.bucketSet(namespace, rateLimitingConfig).client(client).limiter.Allow()
+}
+
+// Reserve reserves a token for a request of the client from the limiters of a namespace. Unlike Allow,
+// the reservation succeeds when no token is available yet, and tells how long the request must wait.
+//
+// Parameters:
+// - namespace: The namespace of the limiters (see Namespace).
+// - client: The key of the client (see ClientKey).
+// - rateLimitingConfig: The rate limiting configuration of the location.
+//
+// Returns:
+// - *rate.Reservation: The reservation, to be canceled if the request does not wait for it.
+func (m *Manager) Reserve(namespace, client string, rateLimitingConfig config.RateLimiting) *rate.Reservation {
+	return m.bucketSet(namespace, rateLimitingConfig).client(client).limiter.Reserve()
+}
+
+// WaitN blocks until n tokens of the client are available in the limiters of a namespace, or the context ends.
+// It is used to shape byte flows, each byte consuming a token; n must not exceed the burst.
+//
+// Parameters:
+// - ctx: The context bounding the wait.
+// - namespace: The namespace of the limiters.
+// - client: The key of the client.
+// - rateLimitingConfig: The rate limiting configuration, whose rate and burst are counted in tokens.
+// - n: The number of tokens to consume.
+//
+// Returns:
+// - error: An error if the context ends first, or if n exceeds the burst.
+func (m *Manager) WaitN(ctx context.Context, namespace, client string, rateLimitingConfig config.RateLimiting, n int) error {
+	return m.bucketSet(namespace, rateLimitingConfig).client(client).limiter.WaitN(ctx, n)
+}
+
+// Adaptive returns the adaptive concurrency limiter of a location, creating it with the configuration of its first request.
+//
+// Parameters:
+// - location: The key of the location.
+// - adaptiveConfig: The adaptive concurrency configuration of the location.
+//
+// Returns:
+// - *AdaptiveLimiter: The adaptive limiter of the location.
+func (m *Manager) Adaptive(location string, adaptiveConfig config.AdaptiveConcurrency) *AdaptiveLimiter {
+	m.mu.RLock()
+	limiter, ok := m.adaptive[location]
+	m.mu.RUnlock()
+	if ok {
+		return limiter
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if limiter, ok = m.adaptive[location]; !ok {
+		limiter = NewAdaptiveLimiter(adaptiveConfig)
+		m.adaptive[location] = limiter
+	}
+	return limiter
+}
+
+// Stop stops the janitor. The manager can still be used, but idle clients are no longer evicted.
+func (m *Manager) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+// Clients returns the number of clients tracked in a namespace.
+//
+// Parameters:
+// - namespace: The namespace of the limiters.
+//
+// Returns:
+// - int: The number of tracked clients.
+func (m *Manager) Clients(namespace string) int {
+	m.mu.RLock()
+	set, ok := m.namespaces[namespace]
+	m.mu.RUnlock()
+	if !ok {
+		return 0
+	}
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	return len(set.clients)
+}
+
+// bucketSet returns the limiters of a namespace, creating them if needed.
+func (m *Manager) bucketSet(namespace string, rateLimitingConfig config.RateLimiting) *bucketSet {
+	m.mu.RLock()
+	set, ok := m.namespaces[namespace]
+	m.mu.RUnlock()
+	if ok {
+		return set
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if set, ok = m.namespaces[namespace]; !ok {
+		set = &bucketSet{config: rateLimitingConfig, clients: make(map[string]*clientLimiter)}
+		m.namespaces[namespace] = set
+	}
+	return set
+}
+
+// client returns the limiter of a client, creating it if needed, and marks the client as seen.
+func (s *bucketSet) client(key string) *clientLimiter {
+	s.mu.RLock()
+	limiter, ok := s.clients[key]
+	s.mu.RUnlock()
+
+	if !ok {
+		s.mu.Lock()
+		// Double check if the limiter was created during the RUnlock -> Lock phase
+		if limiter, ok = s.clients[key]; !ok {
+			limiter = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(s.config.RequestsPerSecond), s.config.Burst)}
+			s.clients[key] = limiter
+		}
+		s.mu.Unlock()
+	}
+
+	limiter.lastSeen.Store(time.Now().Unix())
+	return limiter
+}
+
+// janitor periodically evicts idle clients until the manager is stopped.
+func (m *Manager) janitor() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-ticker.C:
+			m.evictIdle(now.Add(-clientIdleTimeout))
+		}
+	}
+}
+
+// evictIdle removes the clients not seen since the given time.
+func (m *Manager) evictIdle(before time.Time) {
+	m.mu.RLock()
+	sets := make([]*bucketSet, 0, len(m.namespaces))
+	for _, set := range m.namespaces {
+		sets = append(sets, set)
+	}
+	m.mu.RUnlock()
+
+	for _, set := range sets {
+		set.mu.Lock()
+		for key, limiter := range set.clients {
+			if limiter.lastSeen.Load() < before.Unix() {
+				delete(set.clients, key)
+			}
+		}
+		set.mu.Unlock()
+	}
+}
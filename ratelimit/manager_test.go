@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"dito/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestManagerIsolatesLocations verifies that each location has its own client limiters.
+func TestManagerIsolatesLocations(t *testing.T) {
+	m := NewManager()
+	defer m.Stop()
+
+	strict := config.RateLimiting{Enabled: true, RequestsPerSecond: 1, Burst: 1}
+	loose := config.RateLimiting{Enabled: true, RequestsPerSecond: 1, Burst: 3}
+
+	assert.True(t, m.Allow("/strict", "10.0.0.1", strict))
+	assert.False(t, m.Allow("/strict", "10.0.0.1", strict))
+	assert.True(t, m.Allow("/strict", "10.0.0.2", strict))
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, m.Allow("/loose", "10.0.0.1", loose))
+	}
+	assert.False(t, m.Allow("/loose", "10.0.0.1", loose))
+}
+
+// TestManagerEvictsIdleClients verifies that the janitor pass removes the clients not seen recently.
+func TestManagerEvictsIdleClients(t *testing.T) {
+	m := NewManager()
+	defer m.Stop()
+
+	rateLimiting := config.RateLimiting{Enabled: true, RequestsPerSecond: 1, Burst: 1}
+	m.Allow("/api", "10.0.0.1", rateLimiting)
+	m.Allow("/api", "10.0.0.2", rateLimiting)
+	assert.Equal(t, 2, m.Clients("/api"))
+
+	m.evictIdle(time.Now().Add(-clientIdleTimeout))
+	assert.Equal(t, 2, m.Clients("/api"))
+
+	m.evictIdle(time.Now().Add(time.Minute))
+	assert.Equal(t, 0, m.Clients("/api"))
+}
+
+// TestManagerStop verifies that stopping the manager twice is safe.
+func TestManagerStop(t *testing.T) {
+	m := NewManager()
+	m.Stop()
+	m.Stop()
+}
+
+// TestScopes verifies that locations sharing a scope share their limiters.
+func TestScopes(t *testing.T) {
+	m := NewManager()
+	defer m.Stop()
+
+	group := config.RateLimiting{Enabled: true, RequestsPerSecond: 1, Burst: 1, Scope: config.RateLimitScopeGroup, Group: "backend"}
+	assert.Equal(t, Namespace(group, "^/orders"), Namespace(group, "^/invoices"))
+	assert.True(t, m.Allow(Namespace(group, "^/orders"), "10.0.0.1", group))
+	assert.False(t, m.Allow(Namespace(group, "^/invoices"), "10.0.0.1", group))
+
+	global := config.RateLimiting{Scope: config.RateLimitScopeGlobal}
+	assert.Equal(t, "global", Namespace(global, "^/orders"))
+	assert.NotEqual(t, Namespace(config.RateLimiting{}, "^/orders"), Namespace(config.RateLimiting{}, "^/invoices"))
+
+	shared := config.RateLimiting{Enabled: true, RequestsPerSecond: 1, Burst: 1, Shared: true}
+	namespace := Namespace(shared, "^/search")
+	assert.True(t, m.Allow(namespace, ClientKey(shared, "10.0.0.1"), shared))
+	assert.False(t, m.Allow(namespace, ClientKey(shared, "10.0.0.2"), shared))
+}
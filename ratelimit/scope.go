@@ -0,0 +1,41 @@
+package ratelimit
+
+import "dito/config"
+
+// sharedClientKey is the client key used when all clients share a single budget.
+const sharedClientKey = "*"
+
+// Namespace returns the key namespace of the limits applying to a location, so that the locations
+// sharing their limits (same group, or global scope) use the same limiters and Redis keys.
+//
+// Parameters:
+// - rateLimitingConfig: The rate limiting configuration of the location.
+// - location: The key of the location (typically its path pattern).
+//
+// Returns:
+// - string: The namespace of the limiters.
+func Namespace(rateLimitingConfig config.RateLimiting, location string) string {
+	switch rateLimitingConfig.Scope {
+	case config.RateLimitScopeGroup:
+		return "group:" + rateLimitingConfig.Group
+	case config.RateLimitScopeGlobal:
+		return "global"
+	default:
+		return "location:" + location
+	}
+}
+
+// ClientKey returns the key of the budget consumed by a client within a namespace.
+//
+// Parameters:
+// - rateLimitingConfig: The rate limiting configuration of the location.
+// - client: The key of the client (typically its IP address).
+//
+// Returns:
+// - string: The client key, shared by every client when the budget is shared.
+func ClientKey(rateLimitingConfig config.RateLimiting, client string) string {
+	if rateLimitingConfig.Shared {
+		return sharedClientKey
+	}
+	return client
+}
@@ -0,0 +1,255 @@
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// keyPrefix prefixes the Redis lists holding the exchanges of the locations.
+const keyPrefix = "recording:"
+
+// Exchange is a request and the response sent to the client, as captured on a location.
+type Exchange struct {
+	Time     time.Time `json:"time"`        // Time is when the request was received.
+	Location string    `json:"location"`    // Location is the label of the location (its name or path).
+	Duration float64   `json:"duration_ms"` // Duration is the time taken to answer, in milliseconds.
+	Request  Request   `json:"request"`
+	Response Response  `json:"response"`
+}
+
+// Request is a captured request. Sensitive headers and body fields are masked.
+type Request struct {
+	Method        string      `json:"method"`
+	URL           string      `json:"url"` // URL is the path and query of the request.
+	Host          string      `json:"host"`
+	Header        http.Header `json:"header"`
+	Body          []byte      `json:"body,omitempty"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"` // BodyTruncated reports a body cut at the maximum size.
+	BodyDropped   bool        `json:"body_dropped,omitempty"`   // BodyDropped reports a body not recorded, since it could not be masked.
+}
+
+// Response is a captured response. Sensitive headers and body fields are masked.
+type Response struct {
+	Status        int         `json:"status"`
+	Header        http.Header `json:"header"`
+	Body          []byte      `json:"body,omitempty"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"` // BodyTruncated reports a body cut at the maximum size.
+	BodyDropped   bool        `json:"body_dropped,omitempty"`   // BodyDropped reports a body not recorded, since it could not be masked.
+}
+
+// File appends exchanges to a JSON lines file. Once the file holds the maximum number of exchanges, it is
+// renamed with a .1 suffix, replacing the previous one, and a new file is started.
+type File struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	entries int64 // entries is the number of exchanges in the file.
+}
+
+// files holds the files opened with FileFor, keyed by path.
+var files sync.Map
+
+// unsafeFileChars matches the characters of a location label replaced in its file name.
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// FilePath returns the path of the file holding the exchanges of a location.
+//
+// Parameters:
+// - directory: The directory of the recordings.
+// - location: The label of the location.
+//
+// Returns:
+// - string: The path of the JSON lines file, named after the location.
+func FilePath(directory, location string) string {
+	return filepath.Join(directory, unsafeFileChars.ReplaceAllString(location, "_")+".jsonl")
+}
+
+// FileFor returns the shared file holding the exchanges of a location, opening it on first use. The file is
+// kept open, and appended to across configuration reloads.
+//
+// Parameters:
+// - directory: The directory of the recordings, created if needed.
+// - location: The label of the location.
+//
+// Returns:
+// - *File: The file.
+// - error: An error if the directory or the file cannot be created.
+func FileFor(directory, location string) (*File, error) {
+	path := FilePath(directory, location)
+	if value, ok := files.Load(path); ok {
+		return value.(*File), nil
+	}
+	if err := os.MkdirAll(directory, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create the recording directory: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the recording file: %v", err)
+	}
+	entries, err := countLines(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read the recording file: %v", err)
+	}
+	actual, loaded := files.LoadOrStore(path, &File{path: path, file: file, entries: entries})
+	if loaded {
+		file.Close()
+	}
+	return actual.(*File), nil
+}
+
+// Save appends an exchange to the file, rotating it first if it holds the maximum number of exchanges, so that
+// at most twice that number are kept per location.
+//
+// Parameters:
+// - exchange: The exchange.
+// - maxEntries: The number of exchanges kept in the file before it is rotated.
+//
+// Returns:
+// - error: An error if the exchange cannot be encoded or written, or the file cannot be rotated.
+func (f *File) Save(exchange Exchange, maxEntries int64) error {
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.entries >= maxEntries {
+		if err = f.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err = f.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	f.entries++
+	return nil
+}
+
+// rotate renames the file with a .1 suffix, replacing the previous one, and starts a new file.
+//
+// Returns:
+// - error: An error if the file cannot be renamed or created.
+func (f *File) rotate() error {
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate the recording file: %v", err)
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_RDWR|os.O_APPEND|os.O_TRUNC, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open the recording file: %v", err)
+	}
+	f.file.Close()
+	f.file = file
+	f.entries = 0
+	return nil
+}
+
+// countLines returns the number of lines of a file, read from its start.
+//
+// Parameters:
+// - file: The file.
+//
+// Returns:
+// - int64: The number of lines.
+// - error: An error if the file cannot be read.
+func countLines(file *os.File) (int64, error) {
+	var lines int64
+	buf := make([]byte, 32<<10)
+	for offset := int64(0); ; {
+		n, err := file.ReadAt(buf, offset)
+		lines += int64(bytes.Count(buf[:n], []byte{'\n'}))
+		offset += int64(n)
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// SaveRedis appends an exchange to the Redis list of its location, keeping the most recent ones only.
+//
+// Parameters:
+// - ctx: The context of the Redis commands.
+// - client: The Redis client.
+// - exchange: The exchange.
+// - maxEntries: The number of exchanges kept per location.
+//
+// Returns:
+// - error: An error if the exchange cannot be encoded or stored.
+func SaveRedis(ctx context.Context, client *redis.Client, exchange Exchange, maxEntries int64) error {
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		return err
+	}
+	key := keyPrefix + exchange.Location
+	pipe := client.TxPipeline()
+	pipe.RPush(ctx, key, line)
+	pipe.LTrim(ctx, key, -maxEntries, -1)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// LoadRedis returns the exchanges of a location stored in Redis, oldest first.
+//
+// Parameters:
+// - ctx: The context of the Redis command.
+// - client: The Redis client.
+// - location: The label of the location.
+//
+// Returns:
+// - []Exchange: The exchanges.
+// - error: An error if they cannot be read or decoded.
+func LoadRedis(ctx context.Context, client *redis.Client, location string) ([]Exchange, error) {
+	lines, err := client.LRange(ctx, keyPrefix+location, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	exchanges := make([]Exchange, 0, len(lines))
+	for i, line := range lines {
+		var exchange Exchange
+		if err := json.Unmarshal([]byte(line), &exchange); err != nil {
+			return nil, fmt.Errorf("exchange %d: %v", i+1, err)
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	return exchanges, nil
+}
+
+// Load reads the exchanges of a JSON lines recording.
+//
+// Parameters:
+// - r: The reader of the recording.
+//
+// Returns:
+// - []Exchange: The exchanges, in recording order.
+// - error: An error if a line is not a valid exchange.
+func Load(r io.Reader) ([]Exchange, error) {
+	var exchanges []Exchange
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var exchange Exchange
+		if err := json.Unmarshal(scanner.Bytes(), &exchange); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	return exchanges, scanner.Err()
+}
@@ -0,0 +1,104 @@
+package recording
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFileSaveAndLoad verifies that the exchanges appended to the file of a location are read back in order.
+func TestFileSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	file, err := FileFor(dir, "^/api/(.*)$")
+	require.NoError(t, err)
+	same, err := FileFor(dir, "^/api/(.*)$")
+	require.NoError(t, err)
+	assert.Same(t, file, same, "the file of a location is shared")
+
+	for _, path := range []string{"/api/a", "/api/b"} {
+		require.NoError(t, file.Save(Exchange{Location: "^/api/(.*)$", Request: Request{Method: "GET", URL: path}, Response: Response{Status: 200, Body: []byte("ok")}}, 10))
+	}
+
+	assert.Equal(t, dir+"/_api_._.jsonl", FilePath(dir, "^/api/(.*)$"))
+	recorded, err := os.Open(FilePath(dir, "^/api/(.*)$"))
+	require.NoError(t, err)
+	defer recorded.Close()
+	exchanges, err := Load(recorded)
+	require.NoError(t, err)
+	if assert.Len(t, exchanges, 2) {
+		assert.Equal(t, "/api/a", exchanges[0].Request.URL)
+		assert.Equal(t, "/api/b", exchanges[1].Request.URL)
+		assert.Equal(t, []byte("ok"), exchanges[1].Response.Body)
+	}
+}
+
+// TestFileRotation verifies that a file holding the maximum number of exchanges is rotated, including a file
+// left by a previous run, so that at most twice that number are kept.
+func TestFileRotation(t *testing.T) {
+	dir := t.TempDir()
+	load := func(path string) []Exchange {
+		recorded, err := os.Open(path)
+		require.NoError(t, err)
+		defer recorded.Close()
+		exchanges, err := Load(recorded)
+		require.NoError(t, err)
+		return exchanges
+	}
+
+	path := FilePath(dir, "rotated")
+	require.NoError(t, os.WriteFile(path, []byte(`{"request":{"url":"/0"}}`+"\n"), 0o640))
+	file, err := FileFor(dir, "rotated")
+	require.NoError(t, err)
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, file.Save(Exchange{Location: "rotated", Request: Request{URL: fmt.Sprintf("/%d", i)}}, 2))
+	}
+
+	previous := load(path + ".1")
+	if assert.Len(t, previous, 2) {
+		assert.Equal(t, "/2", previous[0].Request.URL)
+	}
+	current := load(path)
+	if assert.Len(t, current, 2) {
+		assert.Equal(t, "/4", current[0].Request.URL)
+	}
+}
+
+// TestReplay verifies that the recorded requests are re-sent to the target, with the replaced headers, and that
+// the responses are compared with the recorded ones.
+func TestReplay(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/v2/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprintf(w, "%s %s?%s %s %s", r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization"), body)
+	}))
+	defer target.Close()
+	targetURL, _ := url.Parse(target.URL + "/v2")
+
+	exchanges := []Exchange{
+		{Request: Request{Method: "POST", URL: "/orders?page=1", Header: http.Header{"Authorization": {"***"}}, Body: []byte("order")}, Response: Response{Status: 200, Body: []byte("POST /v2/orders?page=1 Bearer test order")}},
+		{Request: Request{Method: "GET", URL: "/broken"}, Response: Response{Status: 200}},
+		{Request: Request{Method: "GET", URL: "/other"}, Response: Response{Status: 200, Body: []byte("different")}},
+		{Request: Request{Method: "POST", URL: "/upload", BodyTruncated: true}, Response: Response{Status: 200}},
+	}
+	results := Replay(context.Background(), exchanges, ReplayOptions{Target: targetURL, Header: http.Header{"Authorization": {"Bearer test"}}, CompareBody: true})
+	require.Len(t, results, 4)
+	assert.True(t, results[0].Match)
+	assert.False(t, results[1].Match)
+	assert.Equal(t, http.StatusInternalServerError, results[1].Status)
+	assert.False(t, results[2].Match, "the bodies differ")
+	assert.Error(t, results[3].Err, "truncated requests are skipped")
+
+	results = Replay(context.Background(), exchanges[2:3], ReplayOptions{Target: targetURL})
+	assert.True(t, results[0].Match, "only the status is compared")
+}
@@ -0,0 +1,114 @@
+package recording
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// hopHeaders are the recorded headers describing the client connection, which are not replayed.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade", "Expect", "Content-Length"}
+
+// Result is the outcome of the replay of an exchange.
+type Result struct {
+	Exchange Exchange // Exchange is the replayed exchange.
+	Status   int      // Status is the status code answered by the target (0 if the request failed).
+	Err      error    // Err is the error of a failed or skipped request.
+	Match    bool     // Match reports whether the target answered as recorded.
+}
+
+// ReplayOptions holds the settings of a replay.
+type ReplayOptions struct {
+	Target      *url.URL     // Target is the base URL the requests are sent to; their path is appended to its path.
+	Header      http.Header  // Header holds headers replacing the recorded ones, e.g. credentials that were masked.
+	CompareBody bool         // CompareBody also compares the bodies, besides the status codes.
+	Client      *http.Client // Client sends the requests.
+}
+
+// Replay re-sends recorded requests to a target, in order, and compares the responses with the recorded ones.
+// Requests whose body was truncated or dropped are skipped, since they cannot be replayed faithfully.
+//
+// Parameters:
+// - ctx: The context of the replay.
+// - exchanges: The recorded exchanges.
+// - options: The replay settings.
+//
+// Returns:
+// - []Result: The outcome of every exchange.
+func Replay(ctx context.Context, exchanges []Exchange, options ReplayOptions) []Result {
+	results := make([]Result, 0, len(exchanges))
+	for _, exchange := range exchanges {
+		result := Result{Exchange: exchange}
+		if exchange.Request.BodyTruncated || exchange.Request.BodyDropped {
+			result.Err = fmt.Errorf("skipped: the recorded request body is incomplete")
+			results = append(results, result)
+			continue
+		}
+		resp, err := send(ctx, exchange.Request, options)
+		if err != nil {
+			result.Err = err
+			results = append(results, result)
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			result.Err = err
+			results = append(results, result)
+			continue
+		}
+		result.Status = resp.StatusCode
+		result.Match = resp.StatusCode == exchange.Response.Status
+		if options.CompareBody && !exchange.Response.BodyTruncated && !exchange.Response.BodyDropped {
+			result.Match = result.Match && bytes.Equal(body, exchange.Response.Body)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// send sends a recorded request to the target of a replay.
+//
+// Parameters:
+// - ctx: The context of the request.
+// - recorded: The recorded request.
+// - options: The replay settings.
+//
+// Returns:
+// - *http.Response: The response of the target.
+// - error: An error if the request cannot be built or sent.
+func send(ctx context.Context, recorded Request, options ReplayOptions) (*http.Response, error) {
+	path, err := url.Parse(recorded.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recorded URL: %v", err)
+	}
+	target := *options.Target
+	target.Path = strings.TrimSuffix(target.Path, "/") + path.Path
+	target.RawPath = ""
+	target.RawQuery = path.RawQuery
+
+	req, err := http.NewRequestWithContext(ctx, recorded.Method, target.String(), bytes.NewReader(recorded.Body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range recorded.Header {
+		req.Header[name] = values
+	}
+	for _, name := range hopHeaders {
+		req.Header.Del(name)
+	}
+	// The bodies are recorded decompressed: the client asks for the codings it decompresses itself.
+	req.Header.Del("Accept-Encoding")
+	for name, values := range options.Header {
+		req.Header[name] = values
+	}
+	client := options.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
@@ -0,0 +1,217 @@
+package router
+
+import (
+	"cmp"
+	"dito/config"
+	"dito/geoip"
+	"net/http"
+	"regexp/syntax"
+	"slices"
+	"strings"
+)
+
+// Router finds the location matching a request without evaluating every location regex.
+//
+// Location paths anchored at the start (e.g. "^/api/v1/") are indexed in a trie by their
+// literal prefix, so only the locations whose prefix matches the request path are evaluated.
+// Unanchored or case-insensitive patterns cannot be indexed and are always evaluated.
+// Candidates are checked in the order of their rank, so the first matching location wins exactly
+// as with a linear scan of the ranked locations.
+type Router struct {
+	locations []config.LocationConfig
+	root      *node
+	fallback  []int // fallback holds the indexes of the locations that could not be indexed.
+	rank      []int // rank holds the position of each location in the evaluation order.
+}
+
+// node is a trie node keyed by path bytes.
+type node struct {
+	children  map[byte]*node
+	locations []int // locations holds the indexes of the locations whose literal prefix ends at this node.
+}
+
+// New builds a router for the given locations. The locations must have their regexes compiled.
+//
+// Parameters:
+// - locations: The locations, in configuration order.
+// - order: The order of the locations of the same priority: config.RoutingFirstMatch or config.RoutingLongestPrefix.
+//
+// Returns:
+// - *Router: The router.
+func New(locations []config.LocationConfig, order string) *Router {
+	r := &Router{locations: locations, root: &node{}, rank: make([]int, len(locations))}
+	keys := make([]rankKey, len(locations))
+	for i, location := range locations {
+		keys[i] = rankKey{index: i, priority: location.Priority, prefix: -1}
+		prefix, ok := anchoredPrefix(location.Path)
+		if !ok {
+			r.fallback = append(r.fallback, i)
+			continue
+		}
+		r.insert(prefix, i)
+		if order == config.RoutingLongestPrefix {
+			keys[i].exact, keys[i].prefix = exactPath(location.Path), len(prefix)
+		}
+	}
+
+	slices.SortFunc(keys, compareRankKeys)
+	for position, key := range keys {
+		r.rank[key.index] = position
+	}
+	return r
+}
+
+// rankKey holds what orders a location among the others.
+type rankKey struct {
+	index    int  // index is the position of the location in the configuration.
+	priority int  // priority is the priority of the location.
+	exact    bool // exact is true for the paths matching a single literal path, when ordering by longest prefix.
+	prefix   int  // prefix is the length of the literal prefix when ordering by longest prefix, -1 otherwise.
+}
+
+// compareRankKeys orders locations by decreasing priority, exact paths first, by decreasing prefix length, and
+// by configuration order.
+func compareRankKeys(a, b rankKey) int {
+	if c := cmp.Compare(b.priority, a.priority); c != 0 {
+		return c
+	}
+	if a.exact != b.exact {
+		if a.exact {
+			return -1
+		}
+		return 1
+	}
+	if c := cmp.Compare(b.prefix, a.prefix); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.index, b.index)
+}
+
+// Match returns the first location, in evaluation order, matching the request.
+//
+// Parameters:
+// - req: The HTTP request.
+//
+// Returns:
+// - int: The index of the matching location in the configuration.
+// - bool: False if no location matches.
+func (r *Router) Match(req *http.Request) (int, bool) {
+	var buf [16]int
+	candidates := append(buf[:0], r.fallback...)
+
+	path := req.URL.Path
+	current := r.root
+	candidates = append(candidates, current.locations...)
+	for i := 0; i < len(path) && current.children != nil; i++ {
+		next, ok := current.children[path[i]]
+		if !ok {
+			break
+		}
+		current = next
+		candidates = append(candidates, current.locations...)
+	}
+
+	// Candidates are collected per trie level, restore the evaluation order.
+	slices.SortFunc(candidates, func(a, b int) int { return cmp.Compare(r.rank[a], r.rank[b]) })
+	for _, index := range candidates {
+		if Matches(&r.locations[index], req) {
+			return index, true
+		}
+	}
+	return -1, false
+}
+
+// Matches checks if the request matches the location's path pattern, methods, header matchers, and countries.
+//
+// Parameters:
+// - location: The location configuration to match against.
+// - r: The HTTP request.
+//
+// Returns:
+// - bool: True if the request matches the location, false otherwise.
+func Matches(location *config.LocationConfig, r *http.Request) bool {
+	if !location.CompiledRegex.MatchString(r.URL.Path) {
+		return false
+	}
+
+	if len(location.Methods) > 0 && !slices.Contains(location.Methods, r.Method) {
+		return false
+	}
+
+	for _, matcher := range location.MatchHeaders {
+		if !matcher.Matches(r.Header.Values(matcher.Name)) {
+			return false
+		}
+	}
+
+	if len(location.MatchCountries) > 0 {
+		client, _ := geoip.FromContext(r.Context())
+		if !slices.Contains(location.MatchCountries, client.Country) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// insert adds a location index under the given literal prefix.
+func (r *Router) insert(prefix string, index int) {
+	current := r.root
+	for i := 0; i < len(prefix); i++ {
+		if current.children == nil {
+			current.children = make(map[byte]*node)
+		}
+		next, ok := current.children[prefix[i]]
+		if !ok {
+			next = &node{}
+			current.children[prefix[i]] = next
+		}
+		current = next
+	}
+	current.locations = append(current.locations, index)
+}
+
+// exactPath reports whether the pattern matches a single literal path, e.g. "^/login$".
+func exactPath(pattern string) bool {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return false
+	}
+	re = re.Simplify()
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 || re.Sub[0].Op != syntax.OpBeginText || re.Sub[len(re.Sub)-1].Op != syntax.OpEndText {
+		return false
+	}
+	for _, sub := range re.Sub[1 : len(re.Sub)-1] {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// anchoredPrefix returns the literal text every path matched by the pattern must start with.
+// It returns false if the pattern is not anchored at the start of the text, in which case
+// a match may begin anywhere in the path.
+func anchoredPrefix(pattern string) (string, bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	re = re.Simplify()
+
+	if re.Op == syntax.OpBeginText {
+		return "", true
+	}
+	if re.Op != syntax.OpConcat || len(re.Sub) == 0 || re.Sub[0].Op != syntax.OpBeginText {
+		return "", false
+	}
+
+	var prefix strings.Builder
+	for _, sub := range re.Sub[1:] {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			break
+		}
+		prefix.WriteString(string(sub.Rune))
+	}
+	return prefix.String(), true
+}
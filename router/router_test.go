@@ -0,0 +1,179 @@
+package router
+
+import (
+	"dito/config"
+	"dito/geoip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newLocations builds location configurations with compiled regexes for the given paths.
+func newLocations(paths ...string) []config.LocationConfig {
+	locations := make([]config.LocationConfig, len(paths))
+	for i, path := range paths {
+		locations[i] = config.LocationConfig{Path: path, CompiledRegex: regexp.MustCompile(path)}
+	}
+	return locations
+}
+
+// TestAnchoredPrefix tests the extraction of literal prefixes from location patterns.
+func TestAnchoredPrefix(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		prefix   string
+		anchored bool
+	}{
+		{"^/api/v1/", "/api/v1/", true},
+		{"^/api/v[0-9]+/users", "/api/v", true},
+		{"^/static/.*\\.css$", "/static/", true},
+		{"^", "", true},
+		{"^(?i)/admin", "", true},
+		{"/api", "", false},
+		{"^/a|^/b", "", false},
+		{".*", "", false},
+	}
+
+	for _, tt := range tests {
+		prefix, anchored := anchoredPrefix(tt.pattern)
+		assert.Equal(t, tt.anchored, anchored, tt.pattern)
+		assert.Equal(t, tt.prefix, prefix, tt.pattern)
+	}
+}
+
+// TestMatchKeepsConfigurationOrder tests that the first configured matching location wins.
+func TestMatchKeepsConfigurationOrder(t *testing.T) {
+	r := New(newLocations("^/api/v1/users", "users", "^/api/", "^/api/v1/"), config.RoutingFirstMatch)
+
+	tests := []struct {
+		path  string
+		index int
+		found bool
+	}{
+		{"/api/v1/users/42", 0, true},
+		{"/api/v1/orders", 2, true},
+		{"/api/v2/users", 1, true},
+		{"/other/users", 1, true},
+		{"/other", -1, false},
+	}
+
+	for _, tt := range tests {
+		index, found := r.Match(httptest.NewRequest("GET", tt.path, nil))
+		assert.Equal(t, tt.found, found, tt.path)
+		assert.Equal(t, tt.index, index, tt.path)
+	}
+}
+
+// TestMatchHonorsPriority tests that locations with a higher priority win over earlier ones.
+func TestMatchHonorsPriority(t *testing.T) {
+	locations := newLocations("^/", "^/api/", "items$")
+	locations[2].Priority = 10
+	locations[1].Priority = 5
+	r := New(locations, config.RoutingFirstMatch)
+
+	for path, expected := range map[string]int{"/api/items": 2, "/items": 2, "/api/users": 1, "/other": 0} {
+		index, found := r.Match(httptest.NewRequest("GET", path, nil))
+		assert.True(t, found, path)
+		assert.Equal(t, expected, index, path)
+	}
+}
+
+// TestMatchLongestPrefix tests that, ordered by longest prefix, exact paths win first, then the longest literal
+// prefixes, then the unanchored patterns, with the priority still coming first.
+func TestMatchLongestPrefix(t *testing.T) {
+	locations := newLocations("^/", "users", "^/api/", "^/api/v1/", "^/api/v1/login$", "^/api/v1/[a-z]+$")
+	r := New(locations, config.RoutingLongestPrefix)
+
+	for path, expected := range map[string]int{
+		"/api/v1/login":  4,
+		"/api/v1/logout": 3,
+		"/api/v2/users":  2,
+		"/users":         0,
+		"/other/users":   0,
+	} {
+		index, found := r.Match(httptest.NewRequest("GET", path, nil))
+		assert.True(t, found, path)
+		assert.Equal(t, expected, index, path)
+	}
+
+	locations[1].Priority = 1
+	r = New(locations, config.RoutingLongestPrefix)
+	index, _ := r.Match(httptest.NewRequest("GET", "/api/v1/users", nil))
+	assert.Equal(t, 1, index)
+}
+
+// TestExactPath tests the detection of the patterns matching a single literal path.
+func TestExactPath(t *testing.T) {
+	for pattern, expected := range map[string]bool{
+		"^/login$":   true,
+		"^/a/b\\.c$": true,
+		"^/login":    false,
+		"/login$":    false,
+		"^/log.n$":   false,
+		"^(?i)/a$":   false,
+	} {
+		assert.Equal(t, expected, exactPath(pattern), pattern)
+	}
+}
+
+// TestMatchAppliesMethodAndHeaderMatchers tests that non-path matchers are honored.
+func TestMatchAppliesMethodAndHeaderMatchers(t *testing.T) {
+	locations := newLocations("^/api/", "^/api/")
+	locations[0].Methods = []string{"POST"}
+	locations[0].MatchHeaders = []config.HeaderMatcher{{Name: "X-Version", Value: "2"}}
+	r := New(locations, config.RoutingFirstMatch)
+
+	req := httptest.NewRequest("POST", "/api/items", nil)
+	req.Header.Set("X-Version", "2")
+	index, found := r.Match(req)
+	assert.True(t, found)
+	assert.Equal(t, 0, index)
+
+	index, found = r.Match(httptest.NewRequest("POST", "/api/items", nil))
+	assert.True(t, found)
+	assert.Equal(t, 1, index)
+}
+
+// TestMatchAppliesCountryMatchers tests that country matchers route on the country resolved by GeoIP.
+func TestMatchAppliesCountryMatchers(t *testing.T) {
+	locations := newLocations("^/shop", "^/shop")
+	locations[0].MatchCountries = []string{"IT", "SM"}
+	r := New(locations, config.RoutingFirstMatch)
+
+	request := func(country string) *http.Request {
+		req := httptest.NewRequest("GET", "/shop", nil)
+		if country != "" {
+			req = req.WithContext(geoip.WithLocation(req.Context(), geoip.Location{Country: country}))
+		}
+		return req
+	}
+
+	index, _ := r.Match(request("SM"))
+	assert.Equal(t, 0, index)
+	index, _ = r.Match(request("DE"))
+	assert.Equal(t, 1, index)
+	index, _ = r.Match(request(""))
+	assert.Equal(t, 1, index)
+}
+
+// BenchmarkMatch measures matching against hundreds of anchored locations.
+func BenchmarkMatch(b *testing.B) {
+	paths := make([]string, 500)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("^/service-%d/api/", i)
+	}
+	r := New(newLocations(paths...), config.RoutingFirstMatch)
+	req := httptest.NewRequest("GET", "/service-499/api/items", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := r.Match(req); !ok {
+			b.Fatal("expected a match")
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package session
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// keyPrefix prefixes the Redis keys holding the session data.
+const keyPrefix = "session:"
+
+// idSize is the number of random bytes of a session ID.
+const idSize = 32
+
+// Session is the server-side session of a client. It is safe for concurrent use; the changes are saved by the
+// session middleware once the request is handled.
+type Session struct {
+	mu      sync.Mutex
+	id      string
+	values  map[string]string
+	changed bool
+}
+
+// New returns a session.
+//
+// Parameters:
+// - id: The session ID.
+// - values: The stored data of the session, or nil for a new session.
+//
+// Returns:
+// - *Session: The session.
+func New(id string, values map[string]string) *Session {
+	if values == nil {
+		values = map[string]string{}
+	}
+	return &Session{id: id, values: values}
+}
+
+// ID returns the session ID.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Get returns a value of the session.
+//
+// Parameters:
+// - key: The key of the value.
+//
+// Returns:
+// - string: The value.
+// - bool: False if the session has no such key.
+func (s *Session) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// Set stores a value in the session.
+//
+// Parameters:
+// - key: The key of the value.
+// - value: The value.
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.changed = true
+}
+
+// Delete removes a value from the session.
+//
+// Parameters:
+// - key: The key of the value.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.changed = true
+	}
+}
+
+// Values returns a copy of the data of the session.
+func (s *Session) Values() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.values)
+}
+
+// Destroy removes every value of the session, e.g. on logout. Its data is deleted from Redis.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.values)
+	s.changed = true
+}
+
+// Changes returns the data to save once the request is handled.
+//
+// Returns:
+// - map[string]string: A copy of the data of the session.
+// - bool: True if the session was changed.
+func (s *Session) Changes() (map[string]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.values), s.changed
+}
+
+// sessionKey is the request context key of the session.
+type sessionKey struct{}
+
+// WithSession attaches a session to a context.
+//
+// Parameters:
+// - ctx: The request context.
+// - session: The session of the client.
+//
+// Returns:
+// - context.Context: The context carrying the session.
+func WithSession(ctx context.Context, session *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// FromContext returns the session attached to a context, so that middlewares and transforms can read and
+// write it.
+//
+// Parameters:
+// - ctx: The request context.
+//
+// Returns:
+// - *Session: The session of the client.
+// - bool: False if the location has no sessions.
+func FromContext(ctx context.Context) (*Session, bool) {
+	session, ok := ctx.Value(sessionKey{}).(*Session)
+	return session, ok
+}
+
+// NewID returns a random session ID, encoded in unpadded URL-safe base64.
+//
+// Returns:
+// - string: The ID.
+// - error: An error if the random source fails.
+func NewID() (string, error) {
+	b := make([]byte, idSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// SignID returns the value of the session cookie of an ID: the ID and its HMAC-SHA256, separated by a dot.
+//
+// Parameters:
+// - id: The session ID.
+// - secret: The key signing the ID.
+//
+// Returns:
+// - string: The cookie value.
+func SignID(id, secret string) string {
+	return id + "." + base64.RawURLEncoding.EncodeToString(idSignature(id, secret))
+}
+
+// VerifyCookie returns the session ID of a cookie value, if its signature is valid.
+//
+// Parameters:
+// - value: The cookie value.
+// - secret: The key signing the IDs.
+//
+// Returns:
+// - string: The session ID.
+// - bool: False if the value is malformed or its signature is invalid.
+func VerifyCookie(value, secret string) (string, bool) {
+	id, encoded, ok := strings.Cut(value, ".")
+	if !ok {
+		return "", false
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(id); err != nil || len(b) != idSize {
+		return "", false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || !hmac.Equal(signature, idSignature(id, secret)) {
+		return "", false
+	}
+	return id, true
+}
+
+// idSignature returns the HMAC-SHA256 of a session ID.
+func idSignature(id, secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id))
+	return mac.Sum(nil)
+}
+
+// Load returns the stored data of a session.
+//
+// Parameters:
+// - ctx: The context of the Redis call.
+// - client: The Redis client.
+// - id: The session ID.
+//
+// Returns:
+// - map[string]string: The data.
+// - bool: False if the session has no data, or it expired.
+// - error: An error if the data cannot be read or decoded.
+func Load(ctx context.Context, client *redis.Client, id string) (map[string]string, bool, error) {
+	data, err := client.Get(ctx, keyPrefix+id).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read the session: %v", err)
+	}
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, false, fmt.Errorf("failed to decode the session: %v", err)
+	}
+	return values, true, nil
+}
+
+// Save stores the data of a session, or deletes it if the session is empty.
+//
+// Parameters:
+// - ctx: The context of the Redis call.
+// - client: The Redis client.
+// - id: The session ID.
+// - values: The data.
+// - ttl: How long the session is kept without requests.
+//
+// Returns:
+// - error: An error if the data cannot be stored.
+func Save(ctx context.Context, client *redis.Client, id string, values map[string]string, ttl time.Duration) error {
+	if len(values) == 0 {
+		return Delete(ctx, client, id)
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	if err := client.Set(ctx, keyPrefix+id, data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save the session: %v", err)
+	}
+	return nil
+}
+
+// Touch extends the lifetime of a stored session.
+//
+// Parameters:
+// - ctx: The context of the Redis call.
+// - client: The Redis client.
+// - id: The session ID.
+// - ttl: How long the session is kept without requests.
+//
+// Returns:
+// - error: An error if the lifetime cannot be extended.
+func Touch(ctx context.Context, client *redis.Client, id string, ttl time.Duration) error {
+	if err := client.Expire(ctx, keyPrefix+id, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to extend the session: %v", err)
+	}
+	return nil
+}
+
+// Delete deletes the data of a session.
+//
+// Parameters:
+// - ctx: The context of the Redis call.
+// - client: The Redis client.
+// - id: The session ID.
+//
+// Returns:
+// - error: An error if the data cannot be deleted.
+func Delete(ctx context.Context, client *redis.Client, id string) error {
+	if err := client.Del(ctx, keyPrefix+id).Err(); err != nil {
+		return fmt.Errorf("failed to delete the session: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package session
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestVerifyCookie verifies that signed session IDs are accepted, and that IDs signed with another secret,
+// altered, or malformed are rejected.
+func TestVerifyCookie(t *testing.T) {
+	id, err := NewID()
+	assert.NoError(t, err)
+	value := SignID(id, "secret")
+
+	verified, ok := VerifyCookie(value, "secret")
+	assert.True(t, ok)
+	assert.Equal(t, id, verified)
+
+	_, ok = VerifyCookie(value, "other")
+	assert.False(t, ok)
+
+	other, _ := NewID()
+	_, ok = VerifyCookie(other+value[strings.Index(value, "."):], "secret")
+	assert.False(t, ok)
+
+	for _, malformed := range []string{"", id, "short." + strings.Split(SignID("short", "secret"), ".")[1]} {
+		_, ok = VerifyCookie(malformed, "secret")
+		assert.False(t, ok, malformed)
+	}
+}
+
+// TestSession verifies that the changes of a session are tracked, and that a destroyed session is empty.
+func TestSession(t *testing.T) {
+	s := New("id", map[string]string{"user": "alice"})
+	_, changed := s.Changes()
+	assert.False(t, changed)
+
+	value, ok := s.Get("user")
+	assert.True(t, ok)
+	assert.Equal(t, "alice", value)
+
+	s.Delete("missing")
+	_, changed = s.Changes()
+	assert.False(t, changed)
+
+	s.Set("role", "admin")
+	values, changed := s.Changes()
+	assert.True(t, changed)
+	assert.Equal(t, map[string]string{"user": "alice", "role": "admin"}, values)
+
+	s.Destroy()
+	values, _ = s.Changes()
+	assert.Empty(t, values)
+	assert.Empty(t, s.Values())
+}
+
+// TestFromContext verifies that the session attached to a context is returned.
+func TestFromContext(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+
+	s := New("id", nil)
+	attached, ok := FromContext(WithSession(context.Background(), s))
+	assert.True(t, ok)
+	assert.Same(t, s, attached)
+}
@@ -0,0 +1,172 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"dito/config"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Constants of the AWS Signature Version 4.
+const (
+	awsAlgorithm   = "AWS4-HMAC-SHA256"
+	awsDateFormat  = "20060102T150405Z"
+	awsDayFormat   = "20060102"
+	awsScopeSuffix = "aws4_request"
+	// UnsignedPayload replaces the hash of the body of the S3 requests whose body is not signed.
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// PayloadHash returns the SHA-256 of a body, hex encoded, as signed by the AWS Signature Version 4.
+//
+// Parameters:
+// - body: The body of the request.
+//
+// Returns:
+// - string: The hash.
+func PayloadHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// SignAWSV4 signs a request with the AWS Signature Version 4: it sets the X-Amz-Date header, the session token
+// and, for S3, the payload hash headers, then the Authorization header. The host, the Content-Type, and the
+// X-Amz-* headers are signed. The X-Amz-* headers of the client are removed first, except the allowed ones, so
+// that a client cannot have the proxy sign headers of its own, such as X-Amz-Security-Token or X-Amz-Acl.
+//
+// Parameters:
+// - req: The request, whose headers are updated.
+// - credentials: The AWS credentials, region, and service.
+// - payloadHash: The hash of the body (see PayloadHash), or UnsignedPayload.
+// - now: The time of the signature.
+func SignAWSV4(req *http.Request, credentials config.AWSSigning, payloadHash string, now time.Time) {
+	now = now.UTC()
+	req.Header.Del("Authorization")
+	for name := range req.Header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-") && !slices.ContainsFunc(credentials.AllowedHeaders, func(allowed string) bool {
+			return strings.EqualFold(allowed, name)
+		}) {
+			req.Header.Del(name)
+		}
+	}
+	req.Header.Set("X-Amz-Date", now.Format(awsDateFormat))
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+	if credentials.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			trimmed := make([]string, len(values))
+			for i, value := range values {
+				trimmed[i] = strings.Join(strings.Fields(value), " ")
+			}
+			headers[name] = strings.Join(trimmed, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath(req.URL, credentials.Service),
+		canonicalQuery(req.URL),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{now.Format(awsDayFormat), credentials.Region, credentials.Service, awsScopeSuffix}, "/")
+	stringToSign := strings.Join([]string{awsAlgorithm, now.Format(awsDateFormat), scope, PayloadHash([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), now.Format(awsDayFormat))
+	for _, part := range []string{credentials.Region, credentials.Service, awsScopeSuffix} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", awsAlgorithm, credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalPath returns the path of a request as signed: each segment URI-encoded, twice for the services
+// other than S3.
+func canonicalPath(u *url.URL, service string) string {
+	path := u.Path
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+		if service != "s3" {
+			segments[i] = awsEscape(segments[i])
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery returns the query of a request as signed: the parameters URI-encoded and sorted by name, then
+// by value.
+func canonicalQuery(u *url.URL) string {
+	type parameter struct{ name, value string }
+	var parameters []parameter
+	for name, values := range u.Query() {
+		for _, value := range values {
+			parameters = append(parameters, parameter{awsEscape(name), awsEscape(value)})
+		}
+	}
+	sort.Slice(parameters, func(i, j int) bool {
+		if parameters[i].name != parameters[j].name {
+			return parameters[i].name < parameters[j].name
+		}
+		return parameters[i].value < parameters[j].value
+	})
+	encoded := make([]string, len(parameters))
+	for i, p := range parameters {
+		encoded[i] = p.name + "=" + p.value
+	}
+	return strings.Join(encoded, "&")
+}
+
+// awsEscape URI-encodes a string as the AWS Signature Version 4 requires: every byte but the unreserved
+// characters (A-Z, a-z, 0-9, '-', '.', '_', and '~') is percent-encoded.
+func awsEscape(s string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with a key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
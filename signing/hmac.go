@@ -0,0 +1,72 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"dito/config"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+)
+
+// HMAC computes the HMAC signature of a request: the HMAC of its body, preceded by the given fields (the
+// timestamp and the nonce, when used), each followed by a dot, e.g. "<timestamp>.<nonce>.<body>".
+//
+// Parameters:
+// - algorithm: The hash algorithm (sha1, sha256, or sha512).
+// - secret: The shared secret.
+// - body: The body of the request.
+// - fields: The fields signed before the body.
+//
+// Returns:
+// - []byte: The signature.
+func HMAC(algorithm, secret string, body []byte, fields ...string) []byte {
+	var newHash func() hash.Hash
+	switch algorithm {
+	case config.HMACAlgorithmSHA1:
+		newHash = sha1.New
+	case config.HMACAlgorithmSHA512:
+		newHash = sha512.New
+	default:
+		newHash = sha256.New
+	}
+	mac := hmac.New(newHash, []byte(secret))
+	for _, field := range fields {
+		mac.Write([]byte(field + "."))
+	}
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// Encode encodes a signature for a header.
+//
+// Parameters:
+// - signature: The signature.
+// - encoding: The encoding (hex or base64).
+//
+// Returns:
+// - string: The encoded signature.
+func Encode(signature []byte, encoding string) string {
+	if encoding == config.HMACEncodingBase64 {
+		return base64.StdEncoding.EncodeToString(signature)
+	}
+	return hex.EncodeToString(signature)
+}
+
+// Decode decodes a signature read from a header.
+//
+// Parameters:
+// - encoded: The encoded signature.
+// - encoding: The encoding (hex or base64).
+//
+// Returns:
+// - []byte: The signature.
+// - error: An error if the signature is not validly encoded.
+func Decode(encoded, encoding string) ([]byte, error) {
+	if encoding == config.HMACEncodingBase64 {
+		return base64.StdEncoding.DecodeString(encoded)
+	}
+	return hex.DecodeString(encoded)
+}
@@ -0,0 +1,65 @@
+package signing
+
+import (
+	"dito/config"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSignAWSV4 verifies the signature of the example request of the AWS Signature Version 4 documentation.
+func TestSignAWSV4(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	credentials := config.AWSSigning{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", Region: "us-east-1", Service: "iam"}
+
+	SignAWSV4(req, credentials, PayloadHash(nil), time.Date(2015, time.August, 30, 12, 36, 0, 0, time.UTC))
+	assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, "+
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7", req.Header.Get("Authorization"))
+}
+
+// TestSignAWSV4S3 verifies that S3 requests carry their payload hash and session token, signed with them.
+func TestSignAWSV4S3(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPut, "https://bucket.s3.amazonaws.com/a%20b.txt", strings.NewReader("data"))
+	credentials := config.AWSSigning{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token", Region: "eu-west-1", Service: "s3"}
+
+	SignAWSV4(req, credentials, UnsignedPayload, time.Now())
+	assert.Equal(t, UnsignedPayload, req.Header.Get("X-Amz-Content-Sha256"))
+	assert.Equal(t, "token", req.Header.Get("X-Amz-Security-Token"))
+	assert.Contains(t, req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token,")
+	assert.Equal(t, "/a%20b.txt", canonicalPath(req.URL, "s3"))
+	assert.Equal(t, "/a%2520b.txt", canonicalPath(req.URL, "execute-api"))
+}
+
+// TestSignAWSV4ClientHeaders verifies that the X-Amz-* headers of the client are removed before the request is
+// signed, except the allowed ones.
+func TestSignAWSV4ClientHeaders(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPut, "https://bucket.s3.amazonaws.com/report.pdf", nil)
+	req.Header.Set("X-Amz-Security-Token", "forged")
+	req.Header.Set("X-Amz-Acl", "public-read")
+	req.Header.Set("X-Amz-Meta-Owner", "alice")
+	credentials := config.AWSSigning{AccessKeyID: "AKID", SecretAccessKey: "secret", Region: "eu-west-1", Service: "s3", AllowedHeaders: []string{"x-amz-meta-owner"}}
+
+	SignAWSV4(req, credentials, UnsignedPayload, time.Now())
+	assert.Empty(t, req.Header.Get("X-Amz-Security-Token"))
+	assert.Empty(t, req.Header.Get("X-Amz-Acl"))
+	assert.Equal(t, "alice", req.Header.Get("X-Amz-Meta-Owner"))
+	assert.Contains(t, req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-meta-owner,")
+}
+
+// TestHMAC verifies the signed payload and the encodings of the HMAC signatures.
+func TestHMAC(t *testing.T) {
+	signature := HMAC(config.HMACAlgorithmSHA256, "key", []byte("body"), "1700000000")
+	assert.Equal(t, HMAC(config.HMACAlgorithmSHA256, "key", []byte("1700000000.body")), signature)
+	assert.NotEqual(t, HMAC(config.HMACAlgorithmSHA512, "key", []byte("1700000000.body")), signature)
+
+	for _, encoding := range []string{config.HMACEncodingHex, config.HMACEncodingBase64} {
+		decoded, err := Decode(Encode(signature, encoding), encoding)
+		assert.NoError(t, err)
+		assert.Equal(t, signature, decoded)
+	}
+}
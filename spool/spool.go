@@ -0,0 +1,212 @@
+package spool
+
+import (
+	"bytes"
+	"dito/metrics"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+const (
+	// DefaultMemoryLimit is the number of bytes kept in memory when no limit is configured.
+	DefaultMemoryLimit = 1 << 20
+	// DefaultDiskBudget is the total size of the temporary files when no budget is configured.
+	DefaultDiskBudget = 1 << 30
+)
+
+var (
+	// ErrTooLarge is returned when the data exceeds the maximum size of the buffer.
+	ErrTooLarge = errors.New("spool: data exceeds the maximum size")
+	// ErrDiskBudgetExceeded is returned when spilling would exceed the global disk budget.
+	ErrDiskBudgetExceeded = errors.New("spool: disk budget exceeded")
+)
+
+var (
+	diskBudget atomic.Int64 // diskBudget is the maximum total size of the temporary files of all buffers.
+	diskUsed   atomic.Int64 // diskUsed is the current total size of the temporary files of all buffers.
+)
+
+func init() {
+	diskBudget.Store(DefaultDiskBudget)
+}
+
+// SetDiskBudget sets the maximum total size of the temporary files shared by all buffers.
+// Buffers already on disk keep their data even if the new budget is lower.
+//
+// Parameters:
+// - budget: The budget in bytes (DefaultDiskBudget if <= 0).
+func SetDiskBudget(budget int64) {
+	if budget <= 0 {
+		budget = DefaultDiskBudget
+	}
+	diskBudget.Store(budget)
+}
+
+// DiskUsage returns the current total size of the temporary files of all buffers.
+func DiskUsage() int64 {
+	return diskUsed.Load()
+}
+
+// reserveDisk reserves space in the global disk budget.
+func reserveDisk(n int64) bool {
+	for {
+		used := diskUsed.Load()
+		if used+n > diskBudget.Load() {
+			return false
+		}
+		if diskUsed.CompareAndSwap(used, used+n) {
+			metrics.UpdateSpoolDiskUsage(n)
+			return true
+		}
+	}
+}
+
+// releaseDisk returns space to the global disk budget.
+func releaseDisk(n int64) {
+	diskUsed.Add(-n)
+	metrics.UpdateSpoolDiskUsage(-n)
+}
+
+// Buffer accumulates data in memory up to a limit and spills the rest to a temporary file,
+// so that bodies of any size can be read back several times (e.g. to retry or mirror a request).
+// A Buffer must be closed to remove its temporary file.
+type Buffer struct {
+	memoryLimit int64  // memoryLimit is the number of bytes kept in memory before spilling to disk.
+	maxSize     int64  // maxSize is the maximum number of bytes accepted (0 means unlimited).
+	tempDir     string // tempDir is the directory of the temporary file (empty for the system default).
+
+	mem  bytes.Buffer
+	file *os.File
+	size int64
+}
+
+// Every Buffer spilling to disk reserves its file size in the global disk budget,
+// and releases it on Close.
+
+// NewBuffer creates an empty buffer.
+//
+// Parameters:
+// - memoryLimit: The number of bytes kept in memory before spilling to disk (DefaultMemoryLimit if <= 0).
+// - maxSize: The maximum number of bytes accepted (0 means unlimited).
+// - tempDir: The directory of the temporary file (empty for the system default).
+//
+// Returns:
+// - *Buffer: The buffer.
+func NewBuffer(memoryLimit, maxSize int64, tempDir string) *Buffer {
+	if memoryLimit <= 0 {
+		memoryLimit = DefaultMemoryLimit
+	}
+	return &Buffer{memoryLimit: memoryLimit, maxSize: maxSize, tempDir: tempDir}
+}
+
+// Write appends data to the buffer, spilling to disk once the memory limit is reached.
+//
+// Parameters:
+// - p: The data to append.
+//
+// Returns:
+// - int: The number of bytes written.
+// - error: ErrTooLarge if the maximum size is exceeded, or an error writing the temporary file.
+func (b *Buffer) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if b.maxSize > 0 && b.size+int64(len(p)) > b.maxSize {
+		return 0, ErrTooLarge
+	}
+
+	if b.file == nil && b.size+int64(len(p)) <= b.memoryLimit {
+		n, _ := b.mem.Write(p)
+		b.size += int64(n)
+		return n, nil
+	}
+
+	if !reserveDisk(int64(len(p))) {
+		return 0, ErrDiskBudgetExceeded
+	}
+	if b.file == nil {
+		if err := b.spill(); err != nil {
+			releaseDisk(int64(len(p)))
+			return 0, err
+		}
+	}
+	n, err := b.file.Write(p)
+	b.size += int64(n)
+	if n < len(p) {
+		releaseDisk(int64(len(p) - n))
+	}
+	return n, err
+}
+
+// spill moves the in-memory data to a new temporary file.
+func (b *Buffer) spill() error {
+	if !reserveDisk(int64(b.mem.Len())) {
+		return ErrDiskBudgetExceeded
+	}
+	file, err := os.CreateTemp(b.tempDir, "dito-spool-*")
+	if err != nil {
+		releaseDisk(int64(b.mem.Len()))
+		return err
+	}
+	if _, err := file.Write(b.mem.Bytes()); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		releaseDisk(int64(b.mem.Len()))
+		return err
+	}
+	b.file = file
+	b.mem = bytes.Buffer{}
+	return nil
+}
+
+// ReadFrom fills the buffer from a reader until EOF.
+//
+// Parameters:
+// - r: The reader to consume.
+//
+// Returns:
+// - int64: The number of bytes read.
+// - error: ErrTooLarge if the maximum size is exceeded, or the error returned by the reader.
+func (b *Buffer) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{b}, r)
+}
+
+// Size returns the number of bytes in the buffer.
+func (b *Buffer) Size() int64 {
+	return b.size
+}
+
+// OnDisk reports whether the buffer spilled to a temporary file.
+func (b *Buffer) OnDisk() bool {
+	return b.file != nil
+}
+
+// NewReader returns an independent reader over the whole content of the buffer. Readers may be
+// used concurrently with each other, but not with Write.
+//
+// Returns:
+// - io.ReadCloser: The reader. Closing it does not release the buffer.
+func (b *Buffer) NewReader() io.ReadCloser {
+	if b.file != nil {
+		return io.NopCloser(io.NewSectionReader(b.file, 0, b.size))
+	}
+	return io.NopCloser(bytes.NewReader(b.mem.Bytes()))
+}
+
+// Close releases the buffer, removing its temporary file if any.
+//
+// Returns:
+// - error: An error if the temporary file cannot be removed.
+func (b *Buffer) Close() error {
+	b.mem = bytes.Buffer{}
+	if b.file == nil {
+		return nil
+	}
+	name := b.file.Name()
+	b.file.Close()
+	b.file = nil
+	releaseDisk(b.size)
+	return os.Remove(name)
+}
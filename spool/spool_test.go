@@ -0,0 +1,89 @@
+package spool
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBufferInMemory tests that small bodies stay in memory and can be read several times.
+func TestBufferInMemory(t *testing.T) {
+	b := NewBuffer(64, 0, t.TempDir())
+	defer b.Close()
+
+	n, err := b.ReadFrom(strings.NewReader("hello world"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), n)
+	assert.False(t, b.OnDisk())
+
+	for i := 0; i < 2; i++ {
+		data, err := io.ReadAll(b.NewReader())
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(data))
+	}
+}
+
+// TestBufferSpillsToDisk tests that bodies above the memory limit are moved to a temporary file
+// which is removed on Close.
+func TestBufferSpillsToDisk(t *testing.T) {
+	dir := t.TempDir()
+	b := NewBuffer(4, 0, dir)
+
+	_, err := b.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.False(t, b.OnDisk())
+	_, err = b.Write([]byte("defgh"))
+	assert.NoError(t, err)
+	assert.True(t, b.OnDisk())
+	assert.Equal(t, int64(8), b.Size())
+
+	first, second := b.NewReader(), b.NewReader()
+	data, err := io.ReadAll(first)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdefgh", string(data))
+	data, err = io.ReadAll(second)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdefgh", string(data))
+
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+	assert.NoError(t, b.Close())
+	entries, _ = os.ReadDir(dir)
+	assert.Len(t, entries, 0)
+}
+
+// TestBufferMaxSize tests that data beyond the maximum size is refused.
+func TestBufferMaxSize(t *testing.T) {
+	b := NewBuffer(4, 10, t.TempDir())
+	defer b.Close()
+
+	_, err := b.ReadFrom(strings.NewReader(strings.Repeat("x", 11)))
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+// TestDiskBudget tests that spilling is refused once the global disk budget is used up,
+// and that closing a buffer gives its space back.
+func TestDiskBudget(t *testing.T) {
+	SetDiskBudget(10)
+	defer SetDiskBudget(0)
+
+	first := NewBuffer(2, 0, t.TempDir())
+	_, err := first.Write([]byte("12345678"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), DiskUsage())
+
+	second := NewBuffer(2, 0, t.TempDir())
+	_, err = second.Write([]byte("12345"))
+	assert.ErrorIs(t, err, ErrDiskBudgetExceeded)
+	assert.Equal(t, int64(0), second.Size())
+
+	assert.NoError(t, first.Close())
+	assert.Equal(t, int64(0), DiskUsage())
+
+	_, err = second.Write([]byte("12345"))
+	assert.NoError(t, err)
+	assert.NoError(t, second.Close())
+}
@@ -0,0 +1,222 @@
+package startup
+
+import (
+	"context"
+	"crypto/tls"
+	"dito/config"
+	"dito/tlsutil"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultTimeout is the maximum duration of a check when none is configured.
+const DefaultTimeout = 5 * time.Second
+
+// Check is a verification run before the listeners are bound.
+type Check struct {
+	Name      string                          // Name identifies the check in logs.
+	Essential bool                            // Essential checks stop the startup whatever the policy, since the proxy cannot serve without them.
+	Run       func(ctx context.Context) error // Run performs the check.
+}
+
+// Checks returns the startup checks of a configuration, in the order they should run: TLS material first,
+// then Redis, then the upstreams if enabled.
+//
+// Parameters:
+// - proxyConfig: The proxy configuration.
+// - redisClient: The Redis client, or nil if Redis is disabled.
+//
+// Returns:
+// - []Check: The checks to run.
+func Checks(proxyConfig *config.ProxyConfig, redisClient *redis.Client) []Check {
+	checks := []Check{
+		{Name: "server TLS", Essential: true, Run: func(context.Context) error { return checkServerTLS(proxyConfig) }},
+		{Name: "upstream TLS", Run: func(context.Context) error { return checkUpstreamTLS(proxyConfig) }},
+		{Name: "redis", Run: func(ctx context.Context) error { return checkRedis(ctx, proxyConfig, redisClient) }},
+	}
+	if proxyConfig.Startup.CheckUpstreams {
+		checks = append(checks, Check{Name: "upstreams", Run: func(ctx context.Context) error { return checkUpstreams(ctx, proxyConfig.Locations) }})
+	}
+	return checks
+}
+
+// Run executes the startup checks in order. With the fail-fast policy the first failure stops the startup;
+// with the degraded-start policy failures are logged and only essential checks stop the startup.
+//
+// Parameters:
+// - checks: The checks to run.
+// - policy: The startup policy (fail-fast or degraded-start).
+// - timeout: The maximum duration of each check (0 uses DefaultTimeout).
+// - logger: The logger used to log the results.
+//
+// Returns:
+// - error: An error if the startup must stop.
+func Run(checks []Check, policy string, timeout time.Duration, logger *slog.Logger) error {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	failed := 0
+	for _, check := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := check.Run(ctx)
+		cancel()
+		if err == nil {
+			logger.Debug(fmt.Sprintf("Startup check %s passed", check.Name))
+			continue
+		}
+
+		if check.Essential || policy != config.StartupPolicyDegraded {
+			return fmt.Errorf("startup check %s failed: %v", check.Name, err)
+		}
+		failed++
+		logger.Warn(fmt.Sprintf("Startup check %s failed, starting in degraded mode: %v", check.Name, err))
+	}
+
+	if failed > 0 {
+		logger.Warn(fmt.Sprintf("%d of %d startup checks failed", failed, len(checks)))
+	} else {
+		logger.Info("All startup checks passed")
+	}
+	return nil
+}
+
+// checkServerTLS loads the certificates of the TLS listeners.
+func checkServerTLS(proxyConfig *config.ProxyConfig) error {
+	if proxyConfig.Server.TLS.Enabled {
+		if _, err := tlsutil.NewServerConfig(proxyConfig.Server.TLS); err != nil {
+			return err
+		}
+	}
+	for _, stream := range proxyConfig.Streams {
+		if stream.TLS.Enabled {
+			if _, err := tlsutil.NewServerConfig(stream.TLS); err != nil {
+				return fmt.Errorf("stream %s: %v", stream.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkUpstreamTLS loads the client certificates and CA files used to connect to the upstreams.
+func checkUpstreamTLS(proxyConfig *config.ProxyConfig) error {
+	if err := checkTransportFiles(proxyConfig.Transport.HTTP); err != nil {
+		return err
+	}
+	for _, location := range proxyConfig.Locations {
+		// Locations without their own transport share the global one, already checked.
+		if location.Transport == nil || location.Transport == &proxyConfig.Transport {
+			continue
+		}
+		if err := checkTransportFiles(location.Transport.HTTP); err != nil {
+			return fmt.Errorf("location %s: %v", location.Label(), err)
+		}
+	}
+	return nil
+}
+
+// checkTransportFiles loads the certificate files of a transport configuration.
+func checkTransportFiles(transportConfig config.HTTPTransportConfig) error {
+	if transportConfig.CertFile != "" || transportConfig.KeyFile != "" {
+		if _, err := tls.LoadX509KeyPair(transportConfig.CertFile, transportConfig.KeyFile); err != nil {
+			return fmt.Errorf("failed to load client key pair: %v", err)
+		}
+	}
+	if transportConfig.CaFile != "" {
+		if _, err := os.ReadFile(transportConfig.CaFile); err != nil {
+			return fmt.Errorf("failed to read CA file: %v", err)
+		}
+	}
+	return nil
+}
+
+// checkRedis verifies that Redis is enabled when a location needs it, and that it answers.
+func checkRedis(ctx context.Context, proxyConfig *config.ProxyConfig, redisClient *redis.Client) error {
+	if !proxyConfig.Redis.Enabled || redisClient == nil {
+		for _, location := range proxyConfig.Locations {
+			if middleware, ok := redisMiddleware(location); ok {
+				return fmt.Errorf("location %s uses the %s middleware but Redis is disabled", location.Label(), middleware)
+			}
+		}
+		return nil
+	}
+	if err := redisClient.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to connect to Redis: %v", err)
+	}
+	return nil
+}
+
+// redisMiddleware returns the first enabled middleware of a location that relies on Redis.
+func redisMiddleware(location config.LocationConfig) (string, bool) {
+	for _, middleware := range location.Middlewares {
+		var enabled bool
+		switch middleware {
+		case "rate-limiter-redis":
+			enabled = location.RateLimiting.Enabled
+		case "quota":
+			enabled = location.Quota.Enabled
+		case "concurrency-limiter-redis":
+			enabled = location.ConcurrencyLimit.Enabled
+		case "cache":
+			enabled = location.Cache.Enabled
+		case "hmac":
+			enabled = location.HMAC.Enabled && location.HMAC.ReplayProtection
+		case "session":
+			enabled = location.Session.Enabled
+		}
+		if enabled {
+			return middleware, true
+		}
+	}
+	return "", false
+}
+
+// checkUpstreams verifies that the upstream of every location accepts TCP connections.
+func checkUpstreams(ctx context.Context, locations []config.LocationConfig) error {
+	checked := make(map[string]bool)
+	var dialer net.Dialer
+	for _, location := range locations {
+		address, err := upstreamAddress(location.TargetURL)
+		if err != nil {
+			return fmt.Errorf("location %s: %v", location.Label(), err)
+		}
+		if checked[address] {
+			continue
+		}
+		checked[address] = true
+
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return fmt.Errorf("location %s: upstream %s is unreachable: %v", location.Label(), address, err)
+		}
+		conn.Close()
+	}
+	return nil
+}
+
+// upstreamAddress returns the host:port of a target URL, using the default port of its scheme.
+func upstreamAddress(targetURL string) (string, error) {
+	target, err := url.Parse(targetURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid target URL %s: %v", targetURL, err)
+	}
+	if target.Hostname() == "" {
+		return "", fmt.Errorf("invalid target URL %s: missing host", targetURL)
+	}
+	port := target.Port()
+	if port == "" {
+		switch target.Scheme {
+		case "https", "wss":
+			port = "443"
+		default:
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(target.Hostname(), port), nil
+}
@@ -0,0 +1,81 @@
+package startup
+
+import (
+	"context"
+	"dito/config"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRunPolicies verifies which failed checks stop the startup under each policy.
+func TestRunPolicies(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var ran []string
+	check := func(name string, essential bool, err error) Check {
+		return Check{Name: name, Essential: essential, Run: func(context.Context) error {
+			ran = append(ran, name)
+			return err
+		}}
+	}
+	failing := []Check{check("optional", false, errors.New("down")), check("next", false, nil)}
+
+	err := Run(failing, config.StartupPolicyFailFast, 0, logger)
+	assert.ErrorContains(t, err, "startup check optional failed: down")
+	assert.Equal(t, []string{"optional"}, ran)
+
+	ran = nil
+	assert.NoError(t, Run(failing, config.StartupPolicyDegraded, 0, logger))
+	assert.Equal(t, []string{"optional", "next"}, ran)
+
+	ran = nil
+	essential := []Check{check("tls", true, errors.New("missing certificate"))}
+	assert.Error(t, Run(essential, config.StartupPolicyDegraded, 0, logger))
+}
+
+// TestCheckRedis verifies that locations relying on Redis require it.
+func TestCheckRedis(t *testing.T) {
+	proxyConfig := &config.ProxyConfig{Locations: []config.LocationConfig{
+		{Path: "^/free$", Middlewares: []string{"rate-limiter"}, RateLimiting: config.RateLimiting{Enabled: true}},
+		{Path: "^/cached$", Middlewares: []string{"cache"}},
+	}}
+	assert.NoError(t, checkRedis(context.Background(), proxyConfig, nil))
+
+	proxyConfig.Locations[1].Cache.Enabled = true
+	assert.ErrorContains(t, checkRedis(context.Background(), proxyConfig, nil), "location ^/cached$ uses the cache middleware but Redis is disabled")
+
+	proxyConfig.Locations = []config.LocationConfig{{Path: "^/webhooks/", Middlewares: []string{"hmac"}, HMAC: config.HMACVerification{Enabled: true}}}
+	assert.NoError(t, checkRedis(context.Background(), proxyConfig, nil))
+	proxyConfig.Locations[0].HMAC.ReplayProtection = true
+	assert.ErrorContains(t, checkRedis(context.Background(), proxyConfig, nil), "uses the hmac middleware but Redis is disabled")
+
+	proxyConfig.Locations = []config.LocationConfig{{Path: "^/app/", Middlewares: []string{"session"}, Session: config.Session{Enabled: true}}}
+	assert.ErrorContains(t, checkRedis(context.Background(), proxyConfig, nil), "uses the session middleware but Redis is disabled")
+}
+
+// TestCheckUpstreams verifies the reachability check of the upstreams.
+func TestCheckUpstreams(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	reachable := []config.LocationConfig{{Path: "^/up$", TargetURL: "http://" + ln.Addr().String() + "/api"}}
+	assert.NoError(t, checkUpstreams(context.Background(), reachable))
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	closedAddress := closed.Addr().String()
+	closed.Close()
+	unreachable := append(reachable, config.LocationConfig{Path: "^/down$", TargetURL: "http://" + closedAddress})
+	assert.ErrorContains(t, checkUpstreams(context.Background(), unreachable), "location ^/down$: upstream "+closedAddress+" is unreachable")
+
+	address, err := upstreamAddress("wss://echo.example.com/socket")
+	assert.NoError(t, err)
+	assert.Equal(t, "echo.example.com:443", address)
+	_, err = upstreamAddress("/relative")
+	assert.Error(t, err)
+}
@@ -0,0 +1,419 @@
+package stream
+
+import (
+	"crypto/tls"
+	"dito/config"
+	"dito/metrics"
+	"dito/tlsutil"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultDialTimeout is used when a stream does not configure a dial timeout.
+	DefaultDialTimeout = 5 * time.Second
+	// DefaultUDPIdleTimeout is used to expire UDP sessions when no idle timeout is configured.
+	DefaultUDPIdleTimeout = 60 * time.Second
+
+	// maxDatagramSize is the largest UDP payload that can be proxied.
+	maxDatagramSize = 65535
+)
+
+// Proxy forwards raw TCP connections or UDP datagrams from a local address to a target address.
+type Proxy struct {
+	config   config.StreamConfig
+	logger   *slog.Logger
+	listener net.Listener   // listener accepts TCP connections (nil for UDP).
+	packet   net.PacketConn // packet receives UDP datagrams (nil for TCP).
+
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}  // conns tracks open TCP connections so they can be closed on shutdown.
+	sessions map[string]*udpSession // sessions maps UDP client addresses to their upstream session.
+	closed   bool
+	done     chan struct{} // done is closed when the proxy shuts down.
+	wg       sync.WaitGroup
+}
+
+// udpSession is the upstream socket associated with a single UDP client.
+type udpSession struct {
+	upstream   *net.UDPConn
+	clientAddr net.Addr
+	lastActive time.Time
+}
+
+// New creates a stream proxy for the given configuration. Call Start to begin listening.
+//
+// Parameters:
+// - streamConfig: The stream configuration.
+// - logger: The logger used to report connection events.
+//
+// Returns:
+// - *Proxy: The stream proxy.
+func New(streamConfig config.StreamConfig, logger *slog.Logger) *Proxy {
+	if streamConfig.Name == "" {
+		streamConfig.Name = streamConfig.Protocol + "/" + streamConfig.Listen
+	}
+	if streamConfig.DialTimeout <= 0 {
+		streamConfig.DialTimeout = DefaultDialTimeout
+	}
+	if streamConfig.Protocol == "udp" && streamConfig.IdleTimeout <= 0 {
+		streamConfig.IdleTimeout = DefaultUDPIdleTimeout
+	}
+	return &Proxy{
+		config:   streamConfig,
+		logger:   logger,
+		conns:    make(map[net.Conn]struct{}),
+		sessions: make(map[string]*udpSession),
+		done:     make(chan struct{}),
+	}
+}
+
+// StartAll creates and starts a proxy for each stream configuration.
+// If any stream fails to start, the ones already started are closed.
+//
+// Parameters:
+// - streams: The stream configurations.
+// - logger: The logger used by the proxies.
+//
+// Returns:
+// - []*Proxy: The started proxies.
+// - error: An error if a stream could not be started.
+func StartAll(streams []config.StreamConfig, logger *slog.Logger) ([]*Proxy, error) {
+	proxies := make([]*Proxy, 0, len(streams))
+	for _, streamConfig := range streams {
+		proxy := New(streamConfig, logger)
+		if err := proxy.Start(); err != nil {
+			for _, started := range proxies {
+				started.Close()
+			}
+			return nil, err
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
+
+// Start binds the listening address and starts forwarding in the background.
+//
+// Returns:
+// - error: An error if the address cannot be bound or the TLS configuration is invalid.
+func (p *Proxy) Start() error {
+	switch p.config.Protocol {
+	case "tcp":
+		ln, err := net.Listen("tcp", p.config.Listen)
+		if err != nil {
+			return fmt.Errorf("stream %s: %v", p.config.Name, err)
+		}
+		if p.config.TLS.Enabled {
+			tlsConfig, err := tlsutil.NewServerConfig(p.config.TLS)
+			if err != nil {
+				ln.Close()
+				return fmt.Errorf("stream %s: %v", p.config.Name, err)
+			}
+			ln = tls.NewListener(ln, tlsConfig)
+		}
+		p.listener = ln
+		p.wg.Add(1)
+		go p.serveTCP()
+	case "udp":
+		packet, err := net.ListenPacket("udp", p.config.Listen)
+		if err != nil {
+			return fmt.Errorf("stream %s: %v", p.config.Name, err)
+		}
+		p.packet = packet
+		p.wg.Add(2)
+		go p.serveUDP()
+		go p.expireUDPSessions()
+	default:
+		return fmt.Errorf("stream %s: unsupported protocol %q", p.config.Name, p.config.Protocol)
+	}
+
+	p.logger.Info(fmt.Sprintf("Stream %s forwarding %s %s -> %s", p.config.Name, p.config.Protocol, p.Addr(), p.config.Target))
+	return nil
+}
+
+// Addr returns the local address the proxy is listening on.
+func (p *Proxy) Addr() net.Addr {
+	if p.listener != nil {
+		return p.listener.Addr()
+	}
+	if p.packet != nil {
+		return p.packet.LocalAddr()
+	}
+	return nil
+}
+
+// Close stops accepting traffic, closes all open connections and sessions, and waits for the workers to exit.
+//
+// Returns:
+// - error: An error if the listening socket could not be closed.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+
+	var err error
+	if p.listener != nil {
+		err = p.listener.Close()
+	}
+	if p.packet != nil {
+		err = p.packet.Close()
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	for key, session := range p.sessions {
+		session.upstream.Close()
+		delete(p.sessions, key)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	return err
+}
+
+// serveTCP accepts client connections until the listener is closed.
+func (p *Proxy) serveTCP() {
+	defer p.wg.Done()
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				p.logger.Error(fmt.Sprintf("Stream %s: accept failed: %v", p.config.Name, err))
+			}
+			return
+		}
+		if !p.track(client) {
+			client.Close()
+			return
+		}
+		p.wg.Add(1)
+		go p.handleTCP(client)
+	}
+}
+
+// handleTCP connects a client to the target and copies data in both directions until both sides are done.
+func (p *Proxy) handleTCP(client net.Conn) {
+	defer p.wg.Done()
+	defer p.untrack(client)
+
+	upstream, err := net.DialTimeout("tcp", p.config.Target, p.config.DialTimeout)
+	if err != nil {
+		p.logger.Error(fmt.Sprintf("Stream %s: failed to connect to %s: %v", p.config.Name, p.config.Target, err))
+		return
+	}
+	if !p.track(upstream) {
+		upstream.Close()
+		return
+	}
+	defer p.untrack(upstream)
+
+	metrics.UpdateStreamConnections(p.config.Name, true)
+	defer metrics.UpdateStreamConnections(p.config.Name, false)
+
+	// Both directions share the activity timestamp, so a long one-way transfer
+	// does not trip the idle timeout on the silent direction.
+	activity := &atomic.Int64{}
+	activity.Store(time.Now().UnixNano())
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		n := p.pipe(upstream, &idleReader{conn: client, timeout: p.config.IdleTimeout, activity: activity})
+		metrics.RecordStreamBytes(p.config.Name, "inbound", n)
+	}()
+	go func() {
+		defer wg.Done()
+		n := p.pipe(client, &idleReader{conn: upstream, timeout: p.config.IdleTimeout, activity: activity})
+		metrics.RecordStreamBytes(p.config.Name, "outbound", n)
+	}()
+	wg.Wait()
+}
+
+// pipe copies from src to dst, then half-closes dst so the peer sees EOF while the other direction keeps flowing.
+func (p *Proxy) pipe(dst net.Conn, src *idleReader) int64 {
+	n, err := io.Copy(dst, src)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		p.logger.Debug(fmt.Sprintf("Stream %s: copy ended: %v", p.config.Name, err))
+	}
+
+	if closeWriter, ok := dst.(interface{ CloseWrite() error }); ok && err == nil {
+		closeWriter.CloseWrite()
+	} else {
+		// The peer cannot be half-closed or the copy failed: tear down both sides.
+		dst.Close()
+		src.conn.Close()
+	}
+	return n
+}
+
+// track registers an open connection, returning false if the proxy is closing.
+func (p *Proxy) track(conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.conns[conn] = struct{}{}
+	return true
+}
+
+// untrack closes and forgets a connection.
+func (p *Proxy) untrack(conn net.Conn) {
+	conn.Close()
+	p.mu.Lock()
+	delete(p.conns, conn)
+	p.mu.Unlock()
+}
+
+// serveUDP reads datagrams from clients and forwards them through per-client upstream sessions.
+func (p *Proxy) serveUDP() {
+	defer p.wg.Done()
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, clientAddr, err := p.packet.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				p.logger.Error(fmt.Sprintf("Stream %s: read failed: %v", p.config.Name, err))
+			}
+			return
+		}
+
+		session, err := p.udpSession(clientAddr)
+		if err != nil {
+			p.logger.Error(fmt.Sprintf("Stream %s: failed to connect to %s: %v", p.config.Name, p.config.Target, err))
+			continue
+		}
+		if _, err := session.upstream.Write(buf[:n]); err != nil {
+			p.logger.Debug(fmt.Sprintf("Stream %s: write to upstream failed: %v", p.config.Name, err))
+			continue
+		}
+		metrics.RecordStreamBytes(p.config.Name, "inbound", int64(n))
+	}
+}
+
+// udpSession returns the session for a client address, creating it if needed.
+func (p *Proxy) udpSession(clientAddr net.Addr) (*udpSession, error) {
+	key := clientAddr.String()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, net.ErrClosed
+	}
+	if session, ok := p.sessions[key]; ok {
+		session.lastActive = time.Now()
+		return session, nil
+	}
+
+	conn, err := net.DialTimeout("udp", p.config.Target, p.config.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	session := &udpSession{upstream: conn.(*net.UDPConn), clientAddr: clientAddr, lastActive: time.Now()}
+	p.sessions[key] = session
+	metrics.UpdateStreamConnections(p.config.Name, true)
+
+	p.wg.Add(1)
+	go p.relayUDPReplies(key, session)
+	return session, nil
+}
+
+// relayUDPReplies forwards datagrams from the upstream back to the client until the session is closed.
+func (p *Proxy) relayUDPReplies(key string, session *udpSession) {
+	defer p.wg.Done()
+	defer metrics.UpdateStreamConnections(p.config.Name, false)
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := session.upstream.Read(buf)
+		if err != nil {
+			p.mu.Lock()
+			if p.sessions[key] == session {
+				delete(p.sessions, key)
+			}
+			p.mu.Unlock()
+			session.upstream.Close()
+			return
+		}
+
+		p.mu.Lock()
+		session.lastActive = time.Now()
+		p.mu.Unlock()
+
+		if _, err := p.packet.WriteTo(buf[:n], session.clientAddr); err != nil {
+			p.logger.Debug(fmt.Sprintf("Stream %s: write to client failed: %v", p.config.Name, err))
+			continue
+		}
+		metrics.RecordStreamBytes(p.config.Name, "outbound", int64(n))
+	}
+}
+
+// expireUDPSessions periodically closes UDP sessions that have been idle longer than the idle timeout.
+func (p *Proxy) expireUDPSessions() {
+	defer p.wg.Done()
+	interval := p.config.IdleTimeout / 2
+	if interval > 10*time.Second {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		for key, session := range p.sessions {
+			if time.Since(session.lastActive) > p.config.IdleTimeout {
+				session.upstream.Close()
+				delete(p.sessions, key)
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+// idleReader reads from a connection with a read deadline, failing only once neither
+// direction of the stream has seen traffic for the configured idle timeout.
+type idleReader struct {
+	conn     net.Conn
+	timeout  time.Duration
+	activity *atomic.Int64 // activity holds the UnixNano time of the last read in either direction.
+}
+
+// Read reads from the underlying connection, extending its read deadline while the stream is active.
+func (r *idleReader) Read(b []byte) (int, error) {
+	if r.timeout <= 0 {
+		return r.conn.Read(b)
+	}
+	for {
+		r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+		n, err := r.conn.Read(b)
+		if n > 0 {
+			r.activity.Store(time.Now().UnixNano())
+		}
+
+		var netErr net.Error
+		if err != nil && errors.As(err, &netErr) && netErr.Timeout() &&
+			time.Since(time.Unix(0, r.activity.Load())) < r.timeout {
+			// The other direction is still active: keep waiting.
+			continue
+		}
+		return n, err
+	}
+}
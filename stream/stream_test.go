@@ -0,0 +1,111 @@
+package stream
+
+import (
+	"bufio"
+	"dito/config"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testLogger discards log output during tests.
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// startTCPEchoServer starts a TCP server that echoes each line back with a prefix.
+func startTCPEchoServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					conn.Write([]byte("echo: " + scanner.Text() + "\n"))
+				}
+			}(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+// TestTCPStreamProxy verifies that TCP traffic is forwarded in both directions and half-closes propagate.
+func TestTCPStreamProxy(t *testing.T) {
+	upstream := startTCPEchoServer(t)
+
+	proxy := New(config.StreamConfig{Name: "echo", Protocol: "tcp", Listen: "127.0.0.1:0", Target: upstream.Addr().String()}, testLogger)
+	assert.NoError(t, proxy.Start())
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	_, err = conn.Write([]byte("hello\n"))
+	assert.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "echo: hello\n", line)
+
+	// Half-closing the client side makes the upstream finish and close the stream.
+	conn.(*net.TCPConn).CloseWrite()
+	_, err = reader.ReadString('\n')
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+// TestUDPStreamProxy verifies that datagrams are forwarded to the target and replies reach the client.
+func TestUDPStreamProxy(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer upstream.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			upstream.WriteTo(append([]byte("pong: "), buf[:n]...), addr)
+		}
+	}()
+
+	proxy := New(config.StreamConfig{Protocol: "udp", Listen: "127.0.0.1:0", Target: upstream.LocalAddr().String()}, testLogger)
+	assert.NoError(t, proxy.Start())
+	defer proxy.Close()
+
+	conn, err := net.Dial("udp", proxy.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	for _, message := range []string{"one", "two"} {
+		_, err = conn.Write([]byte(message))
+		assert.NoError(t, err)
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, "pong: "+message, string(buf[:n]))
+	}
+}
+
+// TestStartAllFailsOnInvalidStream verifies that a failing stream stops the ones already started.
+func TestStartAllFailsOnInvalidStream(t *testing.T) {
+	_, err := StartAll([]config.StreamConfig{
+		{Protocol: "tcp", Listen: "127.0.0.1:0", Target: "127.0.0.1:1"},
+		{Protocol: "sctp", Listen: "127.0.0.1:0", Target: "127.0.0.1:1"},
+	}, testLogger)
+	assert.Error(t, err)
+}
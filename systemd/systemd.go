@@ -0,0 +1,127 @@
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd (SD_LISTEN_FDS_START).
+const listenFDsStart = 3
+
+// Readiness states sent to systemd.
+const (
+	StateReady    = "READY=1"    // The service finished starting up.
+	StateStopping = "STOPPING=1" // The service is shutting down.
+)
+
+// Listeners holds the sockets passed by systemd socket activation, keyed by their FileDescriptorName
+// ("unknown" when the socket unit does not set one).
+type Listeners map[string][]net.Listener
+
+// InheritedListeners returns the listening sockets passed by systemd through LISTEN_FDS, as done by
+// sd_listen_fds(3). The environment variables are unset so that child processes do not inherit them.
+//
+// Returns:
+// - Listeners: The inherited sockets, empty if the process was not socket activated.
+// - error: An error if a passed file descriptor is not a listening socket.
+func InheritedListeners() (Listeners, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return Listeners{}, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return Listeners{}, nil
+	}
+	var names []string
+	if fdNames := os.Getenv("LISTEN_FDNAMES"); fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+	return inherit(listenFDsStart, count, names)
+}
+
+// inherit wraps the file descriptors from start to start+count-1 into listeners.
+func inherit(start, count int, names []string) (Listeners, error) {
+	listeners := make(Listeners)
+	for i := 0; i < count; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		file := os.NewFile(uintptr(start+i), name)
+		// FileListener duplicates the descriptor, so the original is closed either way.
+		ln, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			listeners.Close()
+			return nil, fmt.Errorf("inherited socket %d (%s) is not a listener: %v", start+i, name, err)
+		}
+		listeners[name] = append(listeners[name], ln)
+	}
+	return listeners, nil
+}
+
+// Take removes and returns the first inherited listener with one of the given names, tried in order.
+//
+// Parameters:
+// - names: The FileDescriptorNames accepted for the listener.
+//
+// Returns:
+// - net.Listener: The listener, or nil if none was passed under these names.
+func (l Listeners) Take(names ...string) net.Listener {
+	for _, name := range names {
+		if lns := l[name]; len(lns) > 0 {
+			l[name] = lns[1:]
+			return lns[0]
+		}
+	}
+	return nil
+}
+
+// Close closes the inherited listeners that were not taken.
+func (l Listeners) Close() {
+	for _, lns := range l {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	}
+}
+
+// Notify sends a state to the systemd notification socket, as done by sd_notify(3).
+//
+// Parameters:
+// - state: The state to send (e.g. StateReady).
+//
+// Returns:
+// - bool: True if the state was sent, false if the process is not supervised by systemd (NOTIFY_SOCKET unset).
+// - error: An error if the notification socket cannot be reached.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		// Abstract namespace socket.
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to the notification socket: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("failed to notify systemd: %v", err)
+	}
+	return true, nil
+}
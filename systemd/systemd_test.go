@@ -0,0 +1,69 @@
+//go:build unix
+
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestInherit verifies that passed file descriptors are wrapped into named listeners.
+func TestInherit(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	file, err := ln.(*net.TCPListener).File()
+	assert.NoError(t, err)
+	// The descriptor is closed by inherit, as systemd descriptors are.
+	fd, err := syscall.Dup(int(file.Fd()))
+	assert.NoError(t, err)
+	file.Close()
+
+	listeners, err := inherit(fd, 1, []string{"proxy"})
+	assert.NoError(t, err)
+	assert.Nil(t, listeners.Take("admin"))
+	inherited := listeners.Take("admin", "proxy")
+	if assert.NotNil(t, inherited) {
+		assert.Equal(t, ln.Addr().String(), inherited.Addr().String())
+		inherited.Close()
+	}
+	assert.Nil(t, listeners.Take("proxy"))
+}
+
+// TestInheritedListenersOtherProcess verifies that sockets passed to another process are ignored.
+func TestInheritedListenersOtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := InheritedListeners()
+	assert.NoError(t, err)
+	assert.Empty(t, listeners)
+	assert.Empty(t, os.Getenv("LISTEN_FDS"))
+}
+
+// TestNotify verifies that states are sent to the notification socket.
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	sent, err := Notify(StateReady)
+	assert.NoError(t, err)
+	assert.False(t, sent)
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	sent, err = Notify(StateReady)
+	assert.NoError(t, err)
+	assert.True(t, sent)
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, StateReady, string(buf[:n]))
+}
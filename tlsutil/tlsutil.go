@@ -0,0 +1,298 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"dito/config"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultMinVersion is the minimum TLS version used when none is configured.
+const DefaultMinVersion = tls.VersionTLS12
+
+// tlsVersions maps the accepted configuration names to TLS protocol versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// curves maps the accepted configuration names to elliptic curve identifiers.
+var curves = map[string]tls.CurveID{
+	"x25519": tls.X25519,
+	"p256":   tls.CurveP256,
+	"p384":   tls.CurveP384,
+	"p521":   tls.CurveP521,
+}
+
+// ApplyOptions applies the configured TLS protocol options to a tls.Config.
+// When no minimum version is configured, TLS 1.2 is enforced.
+//
+// Parameters:
+// - tlsConfig: The tls.Config to update.
+// - options: The TLS options from the configuration.
+//
+// Returns:
+// - error: An error if any of the options is invalid.
+func ApplyOptions(tlsConfig *tls.Config, options config.TLSOptions) error {
+	tlsConfig.MinVersion = DefaultMinVersion
+	if options.MinVersion != "" {
+		version, err := ParseVersion(options.MinVersion)
+		if err != nil {
+			return err
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if options.MaxVersion != "" {
+		version, err := ParseVersion(options.MaxVersion)
+		if err != nil {
+			return err
+		}
+		tlsConfig.MaxVersion = version
+	}
+
+	if tlsConfig.MaxVersion != 0 && tlsConfig.MaxVersion < tlsConfig.MinVersion {
+		return fmt.Errorf("TLS max version %s is lower than min version %s", options.MaxVersion, VersionName(tlsConfig.MinVersion))
+	}
+
+	if len(options.CipherSuites) > 0 {
+		suites, err := ParseCipherSuites(options.CipherSuites)
+		if err != nil {
+			return err
+		}
+		tlsConfig.CipherSuites = suites
+	}
+
+	if len(options.CurvePreferences) > 0 {
+		curveIDs, err := ParseCurves(options.CurvePreferences)
+		if err != nil {
+			return err
+		}
+		tlsConfig.CurvePreferences = curveIDs
+	}
+
+	if len(options.ALPNProtocols) > 0 {
+		tlsConfig.NextProtos = append([]string(nil), options.ALPNProtocols...)
+	}
+
+	return nil
+}
+
+// ParseVersion converts a TLS version name such as "1.2", "TLS1.2" or "tls12" into its protocol identifier.
+//
+// Parameters:
+// - name: The version name.
+//
+// Returns:
+// - uint16: The TLS protocol version.
+// - error: An error if the version is unknown.
+func ParseVersion(name string) (uint16, error) {
+	normalized := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "tls")
+	normalized = strings.TrimPrefix(normalized, "v")
+	if len(normalized) == 2 && !strings.Contains(normalized, ".") {
+		normalized = normalized[:1] + "." + normalized[1:]
+	}
+	version, ok := tlsVersions[normalized]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q", name)
+	}
+	return version, nil
+}
+
+// VersionName returns the configuration name of a TLS protocol version.
+//
+// Parameters:
+// - version: The TLS protocol version.
+//
+// Returns:
+// - string: The version name (e.g. "1.2"), or its hexadecimal value if unknown.
+func VersionName(version uint16) string {
+	for name, v := range tlsVersions {
+		if v == version {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// ParseCipherSuites converts cipher suite names into their identifiers.
+// Only suites considered secure by the Go standard library are accepted.
+//
+// Parameters:
+// - names: The IANA names of the cipher suites.
+//
+// Returns:
+// - []uint16: The cipher suite identifiers in the given order.
+// - error: An error if a suite is unknown or insecure.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	available := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		available[suite.Name] = suite.ID
+	}
+	insecure := make(map[string]bool)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if insecure[name] {
+			return nil, fmt.Errorf("cipher suite %s is insecure and not allowed", name)
+		}
+		id, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ParseCurves converts curve names such as "X25519" or "P-256" into curve identifiers.
+//
+// Parameters:
+// - names: The curve names.
+//
+// Returns:
+// - []tls.CurveID: The curve identifiers in the given order.
+// - error: An error if a curve is unknown.
+func ParseCurves(names []string) ([]tls.CurveID, error) {
+	ids := make([]tls.CurveID, 0, len(names))
+	for _, name := range names {
+		normalized := strings.ToLower(strings.TrimSpace(name))
+		normalized = strings.TrimPrefix(normalized, "curve")
+		normalized = strings.ReplaceAll(normalized, "-", "")
+		id, ok := curves[normalized]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// NewServerConfig builds the tls.Config used to terminate client TLS connections.
+//
+// Parameters:
+// - serverTLS: The server TLS configuration.
+//
+// Returns:
+// - *tls.Config: The server TLS configuration.
+// - error: An error if the certificate cannot be loaded or an option is invalid.
+func NewServerConfig(serverTLS config.ServerTLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(serverTLS.CertFile, serverTLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server key pair: %v", err)
+	}
+
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if err := ApplyOptions(tlsConfig, serverTLS.TLSOptions); err != nil {
+		return nil, err
+	}
+	return tlsConfig, nil
+}
+
+// ApplyUpstreamVerification configures SNI override, certificate verification and pinning
+// for connections to an upstream server.
+//
+// Parameters:
+// - tlsConfig: The tls.Config to update.
+// - upstream: The upstream TLS configuration.
+//
+// Returns:
+// - error: An error if a pin cannot be decoded.
+func ApplyUpstreamVerification(tlsConfig *tls.Config, upstream config.UpstreamTLSConfig) error {
+	tlsConfig.ServerName = upstream.ServerName
+	tlsConfig.InsecureSkipVerify = upstream.InsecureSkipVerify
+
+	certPins, err := decodePins(upstream.PinnedCertificates)
+	if err != nil {
+		return fmt.Errorf("invalid pinned certificate: %v", err)
+	}
+	keyPins, err := decodePins(upstream.PinnedPublicKeys)
+	if err != nil {
+		return fmt.Errorf("invalid pinned public key: %v", err)
+	}
+	if len(certPins) == 0 && len(keyPins) == 0 {
+		return nil
+	}
+
+	// VerifyConnection runs after the standard chain verification (if any), so pinning
+	// also applies when InsecureSkipVerify is enabled.
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		for _, cert := range pinCandidates(state) {
+			certSum := sha256.Sum256(cert.Raw)
+			keySum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if containsPin(certPins, certSum[:]) || containsPin(keyPins, keySum[:]) {
+				return nil
+			}
+		}
+		return errors.New("upstream certificate does not match any configured pin")
+	}
+	return nil
+}
+
+// pinCandidates returns the certificates a pin may match. The certificates sent by the peer are not
+// trusted as such, since a server can append any certificate to its own: when the chain was verified,
+// the pins match the certificates of the verified chains; otherwise, only the leaf.
+//
+// Parameters:
+// - state: The state of the connection.
+//
+// Returns:
+// - []*x509.Certificate: The certificates checked against the pins.
+func pinCandidates(state tls.ConnectionState) []*x509.Certificate {
+	if len(state.VerifiedChains) > 0 {
+		var certs []*x509.Certificate
+		for _, chain := range state.VerifiedChains {
+			certs = append(certs, chain...)
+		}
+		return certs
+	}
+	if len(state.PeerCertificates) > 0 {
+		return state.PeerCertificates[:1]
+	}
+	return nil
+}
+
+// decodePins decodes SHA-256 fingerprints given in hex (optionally colon separated) or base64
+// (optionally prefixed with "sha256/").
+func decodePins(pins []string) ([][]byte, error) {
+	decoded := make([][]byte, 0, len(pins))
+	for _, pin := range pins {
+		value := strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+		hexValue := strings.ReplaceAll(value, ":", "")
+
+		var sum []byte
+		var err error
+		if len(hexValue) == sha256.Size*2 {
+			sum, err = hex.DecodeString(hexValue)
+		} else {
+			sum, err = base64.StdEncoding.DecodeString(value)
+		}
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("%q is not a SHA-256 fingerprint", pin)
+		}
+		decoded = append(decoded, sum)
+	}
+	return decoded, nil
+}
+
+// containsPin reports whether the given digest matches one of the pins.
+func containsPin(pins [][]byte, sum []byte) bool {
+	for _, pin := range pins {
+		if bytes.Equal(pin, sum) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package tlsutil
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"dito/config"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseVersion verifies the accepted spellings of TLS versions.
+func TestParseVersion(t *testing.T) {
+	for name, expected := range map[string]uint16{
+		"1.2":     tls.VersionTLS12,
+		"TLS1.3":  tls.VersionTLS13,
+		"tls12":   tls.VersionTLS12,
+		"TLSv1.1": tls.VersionTLS11,
+	} {
+		version, err := ParseVersion(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, expected, version, name)
+	}
+
+	_, err := ParseVersion("2.0")
+	assert.Error(t, err)
+}
+
+// TestApplyOptionsDefaults verifies that TLS 1.2 is enforced when no options are configured.
+func TestApplyOptionsDefaults(t *testing.T) {
+	tlsConfig := &tls.Config{}
+	assert.NoError(t, ApplyOptions(tlsConfig, config.TLSOptions{}))
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	assert.Zero(t, tlsConfig.MaxVersion)
+	assert.Nil(t, tlsConfig.CipherSuites)
+	assert.Nil(t, tlsConfig.NextProtos)
+}
+
+// TestApplyOptions verifies that every option is translated into the tls.Config.
+func TestApplyOptions(t *testing.T) {
+	tlsConfig := &tls.Config{}
+	err := ApplyOptions(tlsConfig, config.TLSOptions{
+		MinVersion:       "1.2",
+		MaxVersion:       "1.3",
+		CipherSuites:     []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		CurvePreferences: []string{"X25519", "P-256"},
+		ALPNProtocols:    []string{"http/1.1"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MaxVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, tlsConfig.CipherSuites)
+	assert.Equal(t, []tls.CurveID{tls.X25519, tls.CurveP256}, tlsConfig.CurvePreferences)
+	assert.Equal(t, []string{"http/1.1"}, tlsConfig.NextProtos)
+}
+
+// TestApplyOptionsInvalid verifies that invalid or insecure options are rejected.
+func TestApplyOptionsInvalid(t *testing.T) {
+	assert.Error(t, ApplyOptions(&tls.Config{}, config.TLSOptions{MinVersion: "1.3", MaxVersion: "1.2"}))
+	assert.Error(t, ApplyOptions(&tls.Config{}, config.TLSOptions{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}))
+	assert.Error(t, ApplyOptions(&tls.Config{}, config.TLSOptions{CipherSuites: []string{"NOT_A_SUITE"}}))
+	assert.Error(t, ApplyOptions(&tls.Config{}, config.TLSOptions{CurvePreferences: []string{"P999"}}))
+}
+
+// TestNewServerConfig verifies that the server certificate is loaded together with the options.
+func TestNewServerConfig(t *testing.T) {
+	tlsConfig, err := NewServerConfig(config.ServerTLSConfig{
+		Enabled:    true,
+		CertFile:   "testdata/test_cert.pem",
+		KeyFile:    "testdata/test_key.pem",
+		TLSOptions: config.TLSOptions{MinVersion: "1.3"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, tlsConfig.Certificates, 1)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+
+	_, err = NewServerConfig(config.ServerTLSConfig{CertFile: "missing.pem", KeyFile: "missing.pem"})
+	assert.Error(t, err)
+}
+
+// TestApplyUpstreamVerificationPinning verifies that connections succeed only when a pin matches.
+func TestApplyUpstreamVerificationPinning(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	leaf := server.Certificate()
+	certSum := sha256.Sum256(leaf.Raw)
+	keySum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+
+	get := func(upstream config.UpstreamTLSConfig) error {
+		tlsConfig := &tls.Config{}
+		if err := ApplyUpstreamVerification(tlsConfig, upstream); err != nil {
+			return err
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	assert.NoError(t, get(config.UpstreamTLSConfig{
+		InsecureSkipVerify: true,
+		PinnedCertificates: []string{hex.EncodeToString(certSum[:])},
+	}))
+	assert.NoError(t, get(config.UpstreamTLSConfig{
+		InsecureSkipVerify: true,
+		PinnedPublicKeys:   []string{"sha256/" + base64.StdEncoding.EncodeToString(keySum[:])},
+	}))
+
+	otherSum := sha256.Sum256([]byte("another certificate"))
+	assert.Error(t, get(config.UpstreamTLSConfig{
+		InsecureSkipVerify: true,
+		PinnedCertificates: []string{hex.EncodeToString(otherSum[:])},
+	}))
+
+	// Without InsecureSkipVerify the self-signed test certificate is still rejected.
+	assert.Error(t, get(config.UpstreamTLSConfig{PinnedCertificates: []string{hex.EncodeToString(certSum[:])}}))
+}
+
+// TestApplyUpstreamVerificationAppendedPin verifies that a server cannot pass the pinning by sending the pinned
+// certificate after a leaf that is not pinned.
+func TestApplyUpstreamVerificationAppendedPin(t *testing.T) {
+	pinned := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	pinnedCert := pinned.Certificate()
+	pinned.Close()
+
+	leaf, err := tls.LoadX509KeyPair("testdata/test_cert.pem", "testdata/test_key.pem")
+	if !assert.NoError(t, err) {
+		return
+	}
+	leaf.Certificate = append(leaf.Certificate, pinnedCert.Raw)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{leaf}}
+	server.StartTLS()
+	defer server.Close()
+
+	certSum := sha256.Sum256(pinnedCert.Raw)
+	keySum := sha256.Sum256(pinnedCert.RawSubjectPublicKeyInfo)
+	for _, upstream := range []config.UpstreamTLSConfig{
+		{InsecureSkipVerify: true, PinnedCertificates: []string{hex.EncodeToString(certSum[:])}},
+		{InsecureSkipVerify: true, PinnedPublicKeys: []string{hex.EncodeToString(keySum[:])}},
+	} {
+		tlsConfig := &tls.Config{}
+		assert.NoError(t, ApplyUpstreamVerification(tlsConfig, upstream))
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), tlsConfig)
+		if err == nil {
+			conn.Close()
+		}
+		assert.ErrorContains(t, err, "does not match any configured pin")
+	}
+}
+
+// TestApplyUpstreamVerificationInvalidPin verifies that malformed pins are reported.
+func TestApplyUpstreamVerificationInvalidPin(t *testing.T) {
+	err := ApplyUpstreamVerification(&tls.Config{}, config.UpstreamTLSConfig{PinnedPublicKeys: []string{"not-a-pin"}})
+	assert.Error(t, err)
+
+	tlsConfig := &tls.Config{}
+	assert.NoError(t, ApplyUpstreamVerification(tlsConfig, config.UpstreamTLSConfig{ServerName: "backend.internal"}))
+	assert.Equal(t, "backend.internal", tlsConfig.ServerName)
+	assert.Nil(t, tlsConfig.VerifyConnection)
+}
@@ -0,0 +1,38 @@
+package transform
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// The built-in transforms, available to every location.
+func init() {
+	Register("prepend", textTransform(func(text string, body io.Reader) io.Reader {
+		return io.MultiReader(strings.NewReader(text), body)
+	}))
+	Register("append", textTransform(func(text string, body io.Reader) io.Reader {
+		return io.MultiReader(body, strings.NewReader(text))
+	}))
+}
+
+// textTransform returns the factory of a transform adding the text option to the body, e.g. a banner or the
+// callback wrapping of a JSONP response.
+//
+// Parameters:
+// - add: The function adding the text to the body.
+//
+// Returns:
+// - Factory: The factory, which requires the text option.
+func textTransform(add func(text string, body io.Reader) io.Reader) Factory {
+	return func(options map[string]string) (BodyTransform, error) {
+		text, ok := options["text"]
+		if !ok || text == "" {
+			return nil, fmt.Errorf("missing text option")
+		}
+		return Func(func(_ *http.Response, body io.Reader) (io.Reader, error) {
+			return add(text, body), nil
+		}), nil
+	}
+}
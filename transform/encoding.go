@@ -0,0 +1,204 @@
+package transform
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encodedChunkSize is the size of the reads from the transformed stream before it is compressed again.
+const encodedChunkSize = 32 << 10
+
+// encoder compresses a stream, and can flush what it holds so that streamed responses are not delayed.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// codec decompresses and compresses a content coding.
+type codec struct {
+	decode func(r io.Reader) (io.ReadCloser, error)
+	encode func(w io.Writer) (encoder, error)
+}
+
+// codecs are the content codings decompressed for the transforms. Other codings are left untouched along
+// with their response.
+var codecs = map[string]codec{
+	"br": {
+		decode: func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(brotli.NewReader(r)), nil },
+		encode: func(w io.Writer) (encoder, error) { return brotli.NewWriter(w), nil },
+	},
+	"gzip": {
+		decode: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+		encode: func(w io.Writer) (encoder, error) { return gzip.NewWriter(w), nil },
+	},
+	// The deflate coding of HTTP is the zlib format.
+	"deflate": {
+		decode: func(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) },
+		encode: func(w io.Writer) (encoder, error) { return zlib.NewWriter(w), nil },
+	},
+	"zstd": {
+		decode: func(r io.Reader) (io.ReadCloser, error) {
+			decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+			if err != nil {
+				return nil, err
+			}
+			return decoder.IOReadCloser(), nil
+		},
+		encode: func(w io.Writer) (encoder, error) {
+			return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		},
+	},
+}
+
+// responseCodec returns the codec of the Content-Encoding of a response.
+//
+// Parameters:
+// - header: The response headers.
+//
+// Returns:
+// - codec: The codec of the coding, if any.
+// - bool: True if the body is unencoded or its coding has a codec; false for unknown or stacked codings.
+// - bool: True if the body is encoded.
+func responseCodec(header http.Header) (codec, bool, bool) {
+	encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" {
+		return codec{}, true, false
+	}
+	c, ok := codecs[encoding]
+	return c, ok, true
+}
+
+// DecodeBody decompresses a complete body with the codec of its Content-Encoding, up to a maximum size.
+//
+// Parameters:
+// - body: The body.
+// - header: The headers of the body.
+// - maxSize: The largest decompressed body returned; larger bodies are truncated.
+//
+// Returns:
+// - []byte: The decompressed body, or the body itself if it is not encoded.
+// - bool: True if the decompressed body was truncated at the maximum size.
+// - error: An error if the coding is unknown or stacked, or the body is not a valid stream of its coding.
+func DecodeBody(body []byte, header http.Header, maxSize int) ([]byte, bool, error) {
+	c, ok, encoded := responseCodec(header)
+	if !ok {
+		return nil, false, fmt.Errorf("unsupported content encoding %q", header.Get("Content-Encoding"))
+	}
+	if !encoded {
+		return body, false, nil
+	}
+	decoder, err := c.decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	defer decoder.Close()
+	decoded, err := io.ReadAll(io.LimitReader(decoder, int64(maxSize)+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(decoded) > maxSize {
+		return decoded[:maxSize], true, nil
+	}
+	return decoded, false, nil
+}
+
+// lazyDecoder decompresses a body from its first read, so that the header of the compressed stream is not
+// read from the upstream while the response headers are still being processed.
+type lazyDecoder struct {
+	src     io.Reader
+	decode  func(r io.Reader) (io.ReadCloser, error)
+	decoder io.ReadCloser
+}
+
+// Read returns the decompressed body.
+func (ld *lazyDecoder) Read(p []byte) (int, error) {
+	if ld.decoder == nil {
+		decoder, err := ld.decode(ld.src)
+		if err != nil {
+			return 0, err
+		}
+		ld.decoder = decoder
+	}
+	return ld.decoder.Read(p)
+}
+
+// Close releases the decompressor.
+func (ld *lazyDecoder) Close() error {
+	if ld.decoder == nil {
+		return nil
+	}
+	return ld.decoder.Close()
+}
+
+// reencode compresses a transformed stream again with the coding of the upstream response. The compressed
+// stream is flushed after each chunk read, so that streamed responses reach the client as they are produced.
+//
+// Parameters:
+// - body: The transformed stream.
+// - decoder: The decompressor of the upstream body, released once the stream ends.
+// - encode: The compressor of the coding.
+//
+// Returns:
+// - io.ReadCloser: The compressed stream; closing it stops the compression.
+func reencode(body io.Reader, decoder io.Closer, encode func(w io.Writer) (encoder, error)) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer decoder.Close()
+		enc, err := encode(pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		chunk := make([]byte, encodedChunkSize)
+		for {
+			n, err := body.Read(chunk)
+			if n > 0 {
+				if _, werr := enc.Write(chunk[:n]); werr != nil {
+					pw.CloseWithError(werr)
+					return
+				}
+				if werr := enc.Flush(); werr != nil {
+					pw.CloseWithError(werr)
+					return
+				}
+			}
+			if err == io.EOF {
+				pw.CloseWithError(enc.Close())
+				return
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return pr
+}
+
+// DecodableAcceptEncoding restricts an Accept-Encoding header to the codings Apply can decompress, so that
+// the bodies of an upstream asked for them can still be inspected while they travel compressed.
+//
+// Parameters:
+// - acceptEncoding: The Accept-Encoding header of the client.
+//
+// Returns:
+// - string: The accepted codings with a codec, with their quality values; empty if none is left.
+func DecodableAcceptEncoding(acceptEncoding string) string {
+	var kept []string
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		coding, _, _ := strings.Cut(item, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if _, ok := codecs[coding]; ok || coding == "identity" {
+			kept = append(kept, strings.TrimSpace(item))
+		}
+	}
+	return strings.Join(kept, ", ")
+}
@@ -0,0 +1,187 @@
+package transform
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// BodyTransform processes a response body as a stream.
+//
+// Wrap receives the upstream response and a reader positioned at the start of its body,
+// and returns a reader producing the transformed body. Implementations must read from
+// body incrementally (never buffering it whole), so that backpressure from the client
+// propagates to the upstream and multi-GB bodies flow through in constant memory.
+// Wrap may also adjust the response headers. A transform is built once per configuration load and
+// shared by the concurrent requests of its location, so Wrap must be safe for concurrent use.
+type BodyTransform interface {
+	Wrap(resp *http.Response, body io.Reader) (io.Reader, error)
+}
+
+// Func adapts an ordinary function to the BodyTransform interface.
+type Func func(resp *http.Response, body io.Reader) (io.Reader, error)
+
+// Wrap calls f(resp, body).
+func (f Func) Wrap(resp *http.Response, body io.Reader) (io.Reader, error) {
+	return f(resp, body)
+}
+
+// Config references a registered response body transform and its options.
+type Config struct {
+	Name    string            `yaml:"name"`    // Name of the registered transform.
+	Options map[string]string `yaml:"options"` // Options passed to the transform factory.
+}
+
+// Factory creates a BodyTransform from the options configured on a location.
+type Factory func(options map[string]string) (BodyTransform, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a transform available under the given name, so that locations can
+// reference it in their response_transforms list. Registering a name twice replaces
+// the previous factory.
+//
+// Parameters:
+// - name: The name used in the configuration.
+// - factory: The factory creating the transform.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+// Registered returns the sorted names of all registered transforms.
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Build instantiates the configured transforms in order.
+//
+// Parameters:
+// - transforms: The transform configurations of a location.
+//
+// Returns:
+// - []BodyTransform: The transforms, in the order they must be applied.
+// - error: An error if a transform is unknown or its options are invalid.
+func Build(transforms []Config) ([]BodyTransform, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	built := make([]BodyTransform, 0, len(transforms))
+	for _, transformConfig := range transforms {
+		factory, ok := registry[transformConfig.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown response transform %q", transformConfig.Name)
+		}
+		bodyTransform, err := factory(transformConfig.Options)
+		if err != nil {
+			return nil, fmt.Errorf("response transform %q: %v", transformConfig.Name, err)
+		}
+		built = append(built, bodyTransform)
+	}
+	return built, nil
+}
+
+// Apply chains the given transforms onto the response body. The first transform reads
+// the upstream body, each following one reads the output of the previous one.
+// Since the resulting length is unknown, Content-Length is removed and the body is sent chunked.
+// Bodies compressed with br, gzip, deflate, or zstd are decompressed for the transforms and compressed
+// again with the same coding. Responses without a body, partial (206) responses, whose bytes cannot be
+// decompressed or rewritten out of their context, and responses with another Content-Encoding, such as compress,
+// are left untouched. Transformed responses no longer advertise ranges, as the offsets of the upstream do
+// not address the transformed body.
+//
+// Parameters:
+// - resp: The upstream response.
+// - transforms: The transforms to apply.
+//
+// Returns:
+// - error: An error if a transform refuses the response.
+func Apply(resp *http.Response, transforms []BodyTransform) error {
+	if len(transforms) == 0 || !hasBody(resp) || resp.StatusCode == http.StatusPartialContent {
+		return nil
+	}
+	c, supported, encoded := responseCodec(resp.Header)
+	if !supported {
+		return nil
+	}
+
+	var body io.Reader = resp.Body
+	var decoder *lazyDecoder
+	if encoded {
+		decoder = &lazyDecoder{src: resp.Body, decode: c.decode}
+		body = decoder
+	}
+	for _, bodyTransform := range transforms {
+		wrapped, err := bodyTransform.Wrap(resp, body)
+		if err != nil {
+			return err
+		}
+		body = wrapped
+	}
+
+	if encoded {
+		encodedBody := reencode(body, decoder, c.encode)
+		resp.Body = &readCloser{Reader: encodedBody, closer: closers{encodedBody, resp.Body}}
+	} else {
+		resp.Body = &readCloser{Reader: body, closer: resp.Body}
+	}
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	resp.Header.Del("Accept-Ranges")
+	return nil
+}
+
+// hasBody reports whether the response can carry a body.
+func hasBody(resp *http.Response) bool {
+	if resp.Body == nil || resp.Body == http.NoBody {
+		return false
+	}
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return false
+	}
+	switch {
+	case resp.StatusCode >= 100 && resp.StatusCode < 200,
+		resp.StatusCode == http.StatusNoContent,
+		resp.StatusCode == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// readCloser reads from the transformed stream and closes the original upstream body.
+type readCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+// Close closes the upstream body.
+func (rc *readCloser) Close() error {
+	return rc.closer.Close()
+}
+
+// closers closes several streams, returning the first error.
+type closers []io.Closer
+
+// Close closes every stream.
+func (cs closers) Close() error {
+	var first error
+	for _, c := range cs {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
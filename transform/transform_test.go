@@ -0,0 +1,170 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// upperCase is a line-oriented transform used in tests.
+func upperCase(resp *http.Response, body io.Reader) (io.Reader, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		scanner := bufio.NewScanner(body)
+		for scanner.Scan() {
+			if _, err := pw.Write([]byte(strings.ToUpper(scanner.Text()) + "\n")); err != nil {
+				return
+			}
+		}
+		pw.CloseWithError(scanner.Err())
+	}()
+	return pr, nil
+}
+
+// newResponse creates a 200 response with the given body.
+func newResponse(body io.ReadCloser) *http.Response {
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: body, ContentLength: 10}
+	resp.Header.Set("Content-Length", "10")
+	return resp
+}
+
+// TestBuildAndApply verifies that registered transforms are chained in order.
+func TestBuildAndApply(t *testing.T) {
+	Register("test-upper", func(options map[string]string) (BodyTransform, error) {
+		return Func(upperCase), nil
+	})
+	Register("test-suffix", func(options map[string]string) (BodyTransform, error) {
+		return Func(func(resp *http.Response, body io.Reader) (io.Reader, error) {
+			return io.MultiReader(body, strings.NewReader(options["suffix"])), nil
+		}), nil
+	})
+	assert.Contains(t, Registered(), "test-upper")
+
+	transforms, err := Build([]Config{
+		{Name: "test-upper"},
+		{Name: "test-suffix", Options: map[string]string{"suffix": "done"}},
+	})
+	assert.NoError(t, err)
+
+	resp := newResponse(io.NopCloser(strings.NewReader("hello\nworld\n")))
+	resp.Header.Set("Accept-Ranges", "bytes")
+	assert.NoError(t, Apply(resp, transforms))
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Empty(t, resp.Header.Get("Content-Length"))
+	assert.Empty(t, resp.Header.Get("Accept-Ranges"))
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "HELLO\nWORLD\ndone", string(body))
+
+	_, err = Build([]Config{{Name: "missing"}})
+	assert.Error(t, err)
+}
+
+// TestBuiltinTransforms verifies that the prepend and append transforms add their text around the body, and that
+// they require it.
+func TestBuiltinTransforms(t *testing.T) {
+	transforms, err := Build([]Config{
+		{Name: "prepend", Options: map[string]string{"text": "callback("}},
+		{Name: "append", Options: map[string]string{"text": ");"}},
+	})
+	assert.NoError(t, err)
+
+	resp := newResponse(io.NopCloser(strings.NewReader(`{"a":1}`)))
+	assert.NoError(t, Apply(resp, transforms))
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `callback({"a":1});`, string(body))
+
+	_, err = Build([]Config{{Name: "append"}})
+	assert.ErrorContains(t, err, "missing text option")
+}
+
+// TestApplyStreams verifies that transformed data is available before the upstream body is complete.
+func TestApplyStreams(t *testing.T) {
+	upstreamReader, upstreamWriter := io.Pipe()
+	resp := newResponse(upstreamReader)
+	assert.NoError(t, Apply(resp, []BodyTransform{Func(upperCase)}))
+
+	go upstreamWriter.Write([]byte("first\n"))
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "FIRST\n", line)
+
+	go func() {
+		upstreamWriter.Write([]byte("second\n"))
+		upstreamWriter.Close()
+	}()
+	rest, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "SECOND\n", string(rest))
+	assert.NoError(t, resp.Body.Close())
+}
+
+// TestApplySkipsEncodedAndEmptyBodies verifies that responses with an unsupported coding, bodiless responses,
+// and partial responses are not transformed.
+func TestApplySkipsEncodedAndEmptyBodies(t *testing.T) {
+	encoded := newResponse(io.NopCloser(bytes.NewReader([]byte("compressed"))))
+	encoded.Header.Set("Content-Encoding", "compress")
+	assert.NoError(t, Apply(encoded, []BodyTransform{Func(upperCase)}))
+	assert.Equal(t, int64(10), encoded.ContentLength)
+
+	notModified := newResponse(http.NoBody)
+	notModified.StatusCode = http.StatusNotModified
+	assert.NoError(t, Apply(notModified, []BodyTransform{Func(upperCase)}))
+	assert.Equal(t, http.NoBody, notModified.Body)
+
+	partial := newResponse(io.NopCloser(strings.NewReader("partial")))
+	partial.StatusCode = http.StatusPartialContent
+	partial.Header.Set("Content-Range", "bytes 10-16/100")
+	assert.NoError(t, Apply(partial, []BodyTransform{Func(upperCase)}))
+	body, _ := io.ReadAll(partial.Body)
+	assert.Equal(t, "partial", string(body))
+}
+
+// TestApplyEncodedBodies verifies that br, gzip, deflate, and zstd bodies are decompressed for the transforms and
+// compressed again with the same coding.
+func TestApplyEncodedBodies(t *testing.T) {
+	for _, encoding := range []string{"br", "gzip", "deflate", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			c := codecs[encoding]
+			var compressed bytes.Buffer
+			enc, err := c.encode(&compressed)
+			if !assert.NoError(t, err) {
+				return
+			}
+			enc.Write([]byte("first\nsecond\n"))
+			assert.NoError(t, enc.Close())
+
+			resp := newResponse(io.NopCloser(&compressed))
+			resp.Header.Set("Content-Encoding", encoding)
+			assert.NoError(t, Apply(resp, []BodyTransform{Func(upperCase)}))
+			assert.Equal(t, int64(-1), resp.ContentLength)
+			assert.Equal(t, encoding, resp.Header.Get("Content-Encoding"))
+
+			decoder, err := c.decode(resp.Body)
+			if !assert.NoError(t, err) {
+				return
+			}
+			body, err := io.ReadAll(decoder)
+			assert.NoError(t, err)
+			assert.Equal(t, "FIRST\nSECOND\n", string(body))
+			assert.NoError(t, decoder.Close())
+			assert.NoError(t, resp.Body.Close())
+		})
+	}
+}
+
+// TestDecodableAcceptEncoding verifies that the Accept-Encoding forwarded to the upstream keeps only the
+// codings with a codec, with their quality values.
+func TestDecodableAcceptEncoding(t *testing.T) {
+	assert.Equal(t, "br;q=1.0, gzip;q=0.8, identity", DecodableAcceptEncoding("br;q=1.0, gzip;q=0.8, compress, identity"))
+	assert.Equal(t, "zstd, deflate", DecodableAcceptEncoding("zstd, deflate"))
+	assert.Empty(t, DecodableAcceptEncoding("compress, *"))
+}
@@ -0,0 +1,23 @@
+package transport
+
+import (
+	"dito/config"
+	"net/http"
+)
+
+// injectCredentials adds the upstream credentials of the location to a request, replacing those sent by the
+// client.
+//
+// Parameters:
+// - req: The request, whose headers are updated.
+func (t *Caronte) injectCredentials(req *http.Request) {
+	credentials := t.Location.Credentials
+	switch credentials.Type {
+	case config.CredentialsBearer:
+		req.Header.Set("Authorization", "Bearer "+credentials.Token)
+	case config.CredentialsBasic:
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+	case config.CredentialsHeader:
+		req.Header.Set(credentials.Header, credentials.Value)
+	}
+}
@@ -0,0 +1,105 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"dito/config"
+	"dito/signing"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// sign adds the signature of the location to a request sent to its upstream, once its headers are final.
+//
+// Parameters:
+// - req: The request, whose headers are updated.
+//
+// Returns:
+// - error: An error if the body cannot be read or exceeds the maximum size.
+func (t *Caronte) sign(req *http.Request) error {
+	settings := t.Location.Signing
+	switch settings.Type {
+	case config.SigningAWSV4:
+		payloadHash := signing.UnsignedPayload
+		if !settings.AWS.UnsignedPayload {
+			body, err := signedBody(req, settings.MaxBodySize)
+			if err != nil {
+				return err
+			}
+			payloadHash = signing.PayloadHash(body)
+		}
+		signing.SignAWSV4(req, settings.AWS, payloadHash, time.Now())
+	case config.SigningHMAC:
+		body, err := signedBody(req, settings.MaxBodySize)
+		if err != nil {
+			return err
+		}
+		var fields []string
+		if settings.HMAC.TimestampHeader != "" {
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			req.Header.Set(settings.HMAC.TimestampHeader, timestamp)
+			fields = append(fields, timestamp)
+		}
+		if settings.HMAC.NonceHeader != "" {
+			nonce := make([]byte, 16)
+			_, _ = rand.Read(nonce)
+			req.Header.Set(settings.HMAC.NonceHeader, hex.EncodeToString(nonce))
+			fields = append(fields, hex.EncodeToString(nonce))
+		}
+		signature := signing.HMAC(settings.HMAC.Algorithm, settings.HMAC.Secret, body, fields...)
+		req.Header.Set(settings.HMAC.Header, settings.HMAC.Prefix+signing.Encode(signature, settings.HMAC.Encoding))
+	}
+	return nil
+}
+
+// signedBody returns the body of a request to sign it. Spooled bodies are read from their copy; other bodies
+// are read into memory and replaced by a replayable copy.
+//
+// Parameters:
+// - req: The request.
+// - maxSize: The largest body read, in bytes.
+//
+// Returns:
+// - []byte: The body (nil without a body).
+// - error: An error if the body cannot be read or exceeds the maximum size.
+func signedBody(req *http.Request, maxSize int64) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.ContentLength > maxSize {
+		return nil, fmt.Errorf("request body of %d bytes exceeds the maximum size of the signature", req.ContentLength)
+	}
+	if req.GetBody != nil {
+		spooled, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer spooled.Close()
+		return readSignedBody(spooled, maxSize)
+	}
+
+	body, err := readSignedBody(req.Body, maxSize)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	return body, nil
+}
+
+// readSignedBody reads a body up to a maximum size.
+func readSignedBody(r io.Reader, maxSize int64) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the request body to sign it: %v", err)
+	}
+	if int64(len(body)) > maxSize {
+		return nil, fmt.Errorf("request body exceeds the maximum size of the signature (%d bytes)", maxSize)
+	}
+	return body, nil
+}
@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Phases are the durations of the phases of an upstream request, measured from the start of the round trip.
+type Phases struct {
+	Addr    string        // Addr is the remote address of the upstream connection.
+	Reused  bool          // Reused reports whether the connection came from the idle pool.
+	DNS     time.Duration // DNS is the duration of the DNS lookup (0 when cached or reused).
+	Connect time.Duration // Connect is the duration of the TCP connection (0 when reused).
+	TLS     time.Duration // TLS is the duration of the TLS handshake (0 when reused or plain HTTP).
+	Header  time.Duration // Header is the time until the first byte of the response.
+	Total   time.Duration // Total is the time until the response body was closed.
+}
+
+// Timing measures the phases of an upstream request. Attach it to the request context with WithTiming
+// before the request goes through Caronte; the phases are available once the response body is closed.
+type Timing struct {
+	mu           sync.Mutex
+	phases       Phases
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+}
+
+// timingKey is the request context key of the timing.
+type timingKey struct{}
+
+// WithTiming returns a context carrying a timing for the upstream request.
+//
+// Parameters:
+// - ctx: The request context.
+// - timing: The timing to fill in.
+//
+// Returns:
+// - context.Context: The context carrying the timing.
+func WithTiming(ctx context.Context, timing *Timing) context.Context {
+	return context.WithValue(ctx, timingKey{}, timing)
+}
+
+// Phases returns the phases measured so far.
+func (t *Timing) Phases() Phases {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.phases
+}
+
+// trace instruments a request and returns it, starting the measure.
+func (t *Timing) trace(req *http.Request) *http.Request {
+	t.mu.Lock()
+	t.start = time.Now()
+	t.mu.Unlock()
+
+	// The callbacks may run in the dialing goroutines, concurrently with the request.
+	measure := func(f func(now time.Time)) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		f(time.Now())
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { measure(func(now time.Time) { t.dnsStart = now }) },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			measure(func(now time.Time) { t.phases.DNS = now.Sub(t.dnsStart) })
+		},
+		ConnectStart: func(string, string) { measure(func(now time.Time) { t.connectStart = now }) },
+		ConnectDone: func(string, string, error) {
+			measure(func(now time.Time) { t.phases.Connect = now.Sub(t.connectStart) })
+		},
+		TLSHandshakeStart: func() { measure(func(now time.Time) { t.tlsStart = now }) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			measure(func(now time.Time) { t.phases.TLS = now.Sub(t.tlsStart) })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			measure(func(time.Time) {
+				t.phases.Addr = info.Conn.RemoteAddr().String()
+				t.phases.Reused = info.Reused
+			})
+		},
+		GotFirstResponseByte: func() {
+			measure(func(now time.Time) { t.phases.Header = now.Sub(t.start) })
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// done ends the measure.
+func (t *Timing) done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.phases.Total = time.Since(t.start)
+}
@@ -1,17 +1,25 @@
 package transport
 
 import (
+	"context"
 	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"dito/config"
+	"dito/dnscache"
+	"dito/tlsutil"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -24,14 +32,47 @@ const (
 type Caronte struct {
 	Location       *config.LocationConfig
 	TransportCache *TransportCache
+	OnRequest      func(req *http.Request) // OnRequest, if set, is called with each request once its headers are final, before it is sent.
 }
 
 // TransportCache is a thread-safe cache for storing and retrieving custom HTTP transports.
 type TransportCache struct {
 	transports       sync.Map // Changed from map to sync.Map
 	genericTransport *http.Transport
+	resolver         atomic.Pointer[dnscache.Resolver] // resolver caches the upstream DNS lookups of new transports.
 }
 
+// cacheEntry is a cached transport along with the state of the files it was built from
+// and the counters used to estimate the usage of its connection pool.
+type cacheEntry struct {
+	transport      *http.Transport
+	config         config.HTTPTransportConfig
+	filesTime      time.Time    // filesTime is the latest modification time of the certificate files.
+	openConns      atomic.Int64 // openConns is the number of upstream connections currently open.
+	activeRequests atomic.Int64 // activeRequests is the number of requests whose response is still being read.
+	dials          atomic.Int64 // dials is the total number of connections opened by the transport.
+}
+
+// TransportStats reports the state of a cached transport and an estimate of its connection pool usage.
+// Idle connections are estimated as the open connections not serving a request, which is exact
+// for HTTP/1.x and an upper bound for multiplexed HTTP/2 connections.
+type TransportStats struct {
+	Key                 string `json:"key"`                     // Key is a short identifier of the transport configuration.
+	OpenConnections     int64  `json:"open_connections"`        // OpenConnections is the number of open upstream connections.
+	ActiveRequests      int64  `json:"active_requests"`         // ActiveRequests is the number of in-flight requests.
+	IdleConnections     int64  `json:"idle_connections"`        // IdleConnections is the estimated number of idle connections.
+	TotalDials          int64  `json:"total_dials"`             // TotalDials is the number of connections opened since the transport was created.
+	MaxIdleConnsPerHost int    `json:"max_idle_conns_per_host"` // MaxIdleConnsPerHost is the configured idle pool size per host.
+	MaxConnsPerHost     int    `json:"max_conns_per_host"`      // MaxConnsPerHost is the configured connection cap per host.
+}
+
+// defaultExpectContinueTimeout is the time waited for the upstream to accept a request announced with
+// Expect: 100-continue before its body is sent anyway, when none is configured.
+const defaultExpectContinueTimeout = time.Second
+
+// statsKeyLength is the number of hex characters of the configuration hash used to identify transports.
+const statsKeyLength = 12
+
 // NewTransportCache creates a new instance of TransportCache with a generic transport configuration.
 //
 // Parameters:
@@ -40,7 +81,7 @@ type TransportCache struct {
 // Returns:
 // - *TransportCache: A pointer to the newly created TransportCache.
 func NewTransportCache(transportConfig config.HTTPTransportConfig) *TransportCache {
-	genericTransport, err := createTransportFromConfig(transportConfig)
+	genericTransport, err := createTransportFromConfig(transportConfig, nil)
 	if err != nil {
 		log.Fatalf("Failed to create generic transport: %v", err)
 	}
@@ -61,6 +102,15 @@ func NewTransportCache(transportConfig config.HTTPTransportConfig) *TransportCac
 // - *http.Transport: The custom HTTP transport.
 // - error: An error if the custom transport could not be created.
 func (c *TransportCache) GetTransport(location *config.LocationConfig, genericTransportConfig config.HTTPTransportConfig) (*http.Transport, error) {
+	entry, err := c.getEntry(location, genericTransportConfig)
+	if err != nil {
+		return nil, err
+	}
+	return entry.transport, nil
+}
+
+// getEntry retrieves the cache entry for the given location, creating the transport if needed.
+func (c *TransportCache) getEntry(location *config.LocationConfig, genericTransportConfig config.HTTPTransportConfig) (*cacheEntry, error) {
 	//log.Printf("Getting transport for location: %s\n", location.Path)
 	var transportConfig config.HTTPTransportConfig
 	if location.Transport != nil {
@@ -74,22 +124,71 @@ func (c *TransportCache) GetTransport(location *config.LocationConfig, genericTr
 	// Attempt to load the transport from the map
 	if value, ok := c.transports.Load(key); ok {
 		// Type assertion
-		transport, ok := value.(*http.Transport)
+		entry, ok := value.(*cacheEntry)
 		if !ok {
 			return nil, fmt.Errorf("invalid transport type")
 		}
-		return transport, nil
+		return entry, nil
 	}
 
 	// Create the transport without a global lock
-	customTransport, err := createTransportFromConfig(transportConfig)
+	filesTime := certificateFilesTime(transportConfig)
+	customTransport, err := createTransportFromConfig(transportConfig, c.resolver.Load())
 	if err != nil {
 		return nil, err
 	}
+	entry := &cacheEntry{transport: customTransport, config: transportConfig, filesTime: filesTime}
+	customTransport.DialContext = entry.countingDial(customTransport.DialContext)
 
 	// Atomically load or store the transport
-	actual, _ := c.transports.LoadOrStore(key, customTransport)
-	return actual.(*http.Transport), nil
+	actual, loaded := c.transports.LoadOrStore(key, entry)
+	if loaded {
+		customTransport.CloseIdleConnections()
+	}
+	return actual.(*cacheEntry), nil
+}
+
+// SetResolver sets the DNS cache used by the transports created from now on to resolve upstream hosts.
+//
+// Parameters:
+// - resolver: The DNS cache, or nil to use the system resolver directly.
+func (c *TransportCache) SetResolver(resolver *dnscache.Resolver) {
+	c.resolver.Store(resolver)
+}
+
+// Len returns the number of cached transports.
+func (c *TransportCache) Len() int {
+	count := 0
+	c.transports.Range(func(key, value interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// Stats returns the statistics of every cached transport, sorted by key.
+//
+// Returns:
+// - []TransportStats: The statistics of the cached transports.
+func (c *TransportCache) Stats() []TransportStats {
+	stats := make([]TransportStats, 0)
+	c.transports.Range(func(key, value interface{}) bool {
+		entry := value.(*cacheEntry)
+		open := entry.openConns.Load()
+		active := entry.activeRequests.Load()
+		stats = append(stats, TransportStats{
+			Key:                 key.(string)[:statsKeyLength],
+			OpenConnections:     open,
+			ActiveRequests:      active,
+			IdleConnections:     max(open-active, 0),
+			TotalDials:          entry.dials.Load(),
+			MaxIdleConnsPerHost: entry.config.MaxIdleConnsPerHost,
+			MaxConnsPerHost:     entry.config.MaxConnsPerHost,
+		})
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Key < stats[j].Key })
+	return stats
 }
 
 // InvalidateTransport removes the transport associated with the given configuration from the cache.
@@ -106,17 +205,117 @@ func (c *TransportCache) Clear() {
 	})
 }
 
+// Retain keeps the transports built from one of the given configurations and removes all the
+// others, closing their idle connections. Transports whose certificate, key, or CA files changed
+// on disk since they were built are removed too, so that rotated certificates are picked up.
+// This lets a configuration reload keep the keep-alive pools of unchanged upstreams.
+//
+// Parameters:
+// - transportConfigs: The transport configurations still in use.
+//
+// Returns:
+// - int: The number of transports removed.
+func (c *TransportCache) Retain(transportConfigs []config.HTTPTransportConfig) int {
+	inUse := make(map[string]config.HTTPTransportConfig, len(transportConfigs))
+	for _, transportConfig := range transportConfigs {
+		inUse[generateTransportKey(transportConfig)] = transportConfig
+	}
+
+	removed := 0
+	c.transports.Range(func(key, value interface{}) bool {
+		entry := value.(*cacheEntry)
+		transportConfig, ok := inUse[key.(string)]
+		if ok && certificateFilesTime(transportConfig).Equal(entry.filesTime) {
+			return true
+		}
+		c.transports.Delete(key)
+		entry.transport.CloseIdleConnections()
+		removed++
+		return true
+	})
+	return removed
+}
+
 // RoundTrip executes a single HTTP transaction, manipulating headers and handling TLS certificates.
 func (t *Caronte) RoundTrip(req *http.Request) (*http.Response, error) {
 	// Use the custom or generic transport based on location configuration
-	transport, err := t.TransportCache.GetTransport(t.Location, config.GetCurrentProxyConfig().Transport.HTTP)
+	entry, err := t.TransportCache.getEntry(t.Location, config.GetCurrentProxyConfig().Transport.HTTP)
 	if err != nil {
 		return nil, err
 	}
 
 	t.AddHeaders(req)
+	t.injectCredentials(req)
+	if err := t.sign(req); err != nil {
+		return nil, err
+	}
+	if t.OnRequest != nil {
+		t.OnRequest(req)
+	}
+
+	timing, _ := req.Context().Value(timingKey{}).(*Timing)
+	if timing != nil {
+		req = timing.trace(req)
+	}
+
+	entry.activeRequests.Add(1)
+	resp, err := entry.transport.RoundTrip(req)
+	if err != nil || resp.StatusCode == http.StatusSwitchingProtocols {
+		// Upgraded connections leave the pool: keep the body untouched so it can still be hijacked.
+		entry.activeRequests.Add(-1)
+		if timing != nil {
+			timing.done()
+		}
+		return resp, err
+	}
+	resp.Body = &trackedBody{ReadCloser: resp.Body, done: func() {
+		entry.activeRequests.Add(-1)
+		if timing != nil {
+			timing.done()
+		}
+	}}
+	return resp, nil
+}
+
+// countingDial wraps a dial function so that the connections it opens are counted.
+func (e *cacheEntry) countingDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		e.dials.Add(1)
+		e.openConns.Add(1)
+		return &countedConn{Conn: conn, done: func() { e.openConns.Add(-1) }}, nil
+	}
+}
+
+// countedConn is a net.Conn that reports its closing exactly once.
+type countedConn struct {
+	net.Conn
+	once sync.Once
+	done func()
+}
 
-	return transport.RoundTrip(req)
+// Close closes the connection and reports it.
+func (c *countedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.done)
+	return err
+}
+
+// trackedBody is a response body that reports the end of the request exactly once when closed.
+type trackedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+// Close closes the body and reports the end of the request.
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
 }
 
 // AddHeaders manipulates the request headers according to the LocationConfig.
@@ -138,11 +337,17 @@ func (t *Caronte) AddHeaders(req *http.Request) {
 	}
 
 	if !contains(t.Location.ExcludedHeaders, XForwardedFor) {
-		clientIP := req.RemoteAddr
-		if prior, ok := req.Header[XForwardedFor]; ok {
-			req.Header.Set(XForwardedFor, prior[0]+", "+clientIP)
-		} else {
+		// Only the address is forwarded: the port of RemoteAddr, and the brackets of an IPv6 address, are not
+		// part of X-Forwarded-For entries.
+		clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			clientIP = req.RemoteAddr
+		}
+		if prior := req.Header.Values(XForwardedFor); len(prior) == 0 {
 			req.Header.Set(XForwardedFor, clientIP)
+		} else if entries := strings.Split(prior[len(prior)-1], ","); strings.TrimSpace(entries[len(entries)-1]) != clientIP {
+			// The reverse proxy has usually appended the client already.
+			req.Header.Set(XForwardedFor, strings.Join(prior, ", ")+", "+clientIP)
 		}
 	}
 
@@ -159,12 +364,19 @@ func (t *Caronte) AddHeaders(req *http.Request) {
 //
 // Parameters:
 // - config: The HTTP transport configuration.
+// - resolver: The DNS cache used to resolve upstream hosts (nil for the system resolver).
 //
 // Returns:
 // - *http.Transport: A pointer to the created HTTP transport.
 // - error: An error if the transport could not be created.
-func createTransportFromConfig(config config.HTTPTransportConfig) (*http.Transport, error) {
+func createTransportFromConfig(config config.HTTPTransportConfig, resolver *dnscache.Resolver) (*http.Transport, error) {
 	tlsConfig := &tls.Config{}
+	if err := tlsutil.ApplyOptions(tlsConfig, config.TLS.TLSOptions); err != nil {
+		return nil, fmt.Errorf("invalid upstream TLS options: %v", err)
+	}
+	if err := tlsutil.ApplyUpstreamVerification(tlsConfig, config.TLS); err != nil {
+		return nil, err
+	}
 
 	if config.CertFile != "" && config.KeyFile != "" {
 		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
@@ -184,6 +396,22 @@ func createTransportFromConfig(config config.HTTPTransportConfig) (*http.Transpo
 		tlsConfig.RootCAs = caCertPool
 	}
 
+	dialer := &net.Dialer{
+		Timeout:   config.DialTimeout,
+		KeepAlive: config.KeepAlive,
+	}
+	dialContext := dialer.DialContext
+	if resolver != nil {
+		dialContext = resolver.DialContext(dialer)
+	}
+
+	// Without a timeout, the transport would send the bodies of Expect: 100-continue requests at once,
+	// before the upstream could refuse them; a negative timeout still does so.
+	expectContinueTimeout := config.ExpectContinueTimeout
+	if expectContinueTimeout == 0 {
+		expectContinueTimeout = defaultExpectContinueTimeout
+	}
+
 	return &http.Transport{
 		IdleConnTimeout:       config.IdleConnTimeout,
 		MaxIdleConns:          config.MaxIdleConns,
@@ -191,17 +419,29 @@ func createTransportFromConfig(config config.HTTPTransportConfig) (*http.Transpo
 		MaxConnsPerHost:       config.MaxConnsPerHost,
 		TLSHandshakeTimeout:   config.TLSHandshakeTimeout,
 		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
-		ExpectContinueTimeout: config.ExpectContinueTimeout,
+		ExpectContinueTimeout: expectContinueTimeout,
 		DisableCompression:    config.DisableCompression,
 		ForceAttemptHTTP2:     config.ForceHTTP2,
 		TLSClientConfig:       tlsConfig,
-		DialContext: (&net.Dialer{
-			Timeout:   config.DialTimeout,
-			KeepAlive: config.KeepAlive,
-		}).DialContext,
+		DialContext:           dialContext,
 	}, nil
 }
 
+// certificateFilesTime returns the latest modification time of the certificate, key, and CA files
+// referenced by the configuration (the zero time if there are none).
+func certificateFilesTime(transportConfig config.HTTPTransportConfig) time.Time {
+	var latest time.Time
+	for _, file := range []string{transportConfig.CertFile, transportConfig.KeyFile, transportConfig.CaFile} {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
 // contains checks if a header is in the list of excluded headers.
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
@@ -2,9 +2,18 @@ package transport_test
 
 import (
 	"dito/config"
+	"dito/signing"
 	"dito/transport"
+	"encoding/hex"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func setupTestConfig() {
@@ -93,3 +102,220 @@ func TestClearTransports(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotEqual(t, customTransport, clearedTransport)
 }
+
+func TestRetainTransports(t *testing.T) {
+	setupTestConfig()
+
+	kept := &config.LocationConfig{Path: "/kept", Transport: &config.TransportConfig{HTTP: config.HTTPTransportConfig{MaxIdleConns: 10}}}
+	dropped := &config.LocationConfig{Path: "/dropped", Transport: &config.TransportConfig{HTTP: config.HTTPTransportConfig{MaxIdleConns: 20}}}
+
+	cache := transport.NewTransportCache(config.GetCurrentProxyConfig().Transport.HTTP)
+	keptTransport, err := cache.GetTransport(kept, config.GetCurrentProxyConfig().Transport.HTTP)
+	assert.NoError(t, err)
+	droppedTransport, err := cache.GetTransport(dropped, config.GetCurrentProxyConfig().Transport.HTTP)
+	assert.NoError(t, err)
+
+	removed := cache.Retain([]config.HTTPTransportConfig{kept.Transport.HTTP})
+	assert.Equal(t, 1, removed)
+
+	transportAfter, err := cache.GetTransport(kept, config.GetCurrentProxyConfig().Transport.HTTP)
+	assert.NoError(t, err)
+	assert.Same(t, keptTransport, transportAfter)
+
+	transportAfter, err = cache.GetTransport(dropped, config.GetCurrentProxyConfig().Transport.HTTP)
+	assert.NoError(t, err)
+	assert.NotSame(t, droppedTransport, transportAfter)
+}
+
+func TestRetainTransportsReloadsChangedCertificates(t *testing.T) {
+	setupTestConfig()
+
+	caData, err := os.ReadFile("testdata/test_ca.pem")
+	assert.NoError(t, err)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, caData, 0o600))
+
+	location := &config.LocationConfig{Path: "/tls", Transport: &config.TransportConfig{HTTP: config.HTTPTransportConfig{CaFile: caFile}}}
+	cache := transport.NewTransportCache(config.GetCurrentProxyConfig().Transport.HTTP)
+	original, err := cache.GetTransport(location, config.GetCurrentProxyConfig().Transport.HTTP)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 0, cache.Retain([]config.HTTPTransportConfig{location.Transport.HTTP}))
+
+	// Simulate a certificate rotation at the same path.
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(caFile, later, later))
+	assert.Equal(t, 1, cache.Retain([]config.HTTPTransportConfig{location.Transport.HTTP}))
+
+	reloaded, err := cache.GetTransport(location, config.GetCurrentProxyConfig().Transport.HTTP)
+	assert.NoError(t, err)
+	assert.NotSame(t, original, reloaded)
+}
+
+func TestTransportStats(t *testing.T) {
+	setupTestConfig()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	location := &config.LocationConfig{Path: "/stats", Transport: &config.TransportConfig{HTTP: config.HTTPTransportConfig{MaxIdleConnsPerHost: 5}}}
+	cache := transport.NewTransportCache(config.GetCurrentProxyConfig().Transport.HTTP)
+	caronte := &transport.Caronte{Location: location, TransportCache: cache}
+
+	req := httptest.NewRequest("GET", upstream.URL, nil)
+	req.RequestURI = ""
+	resp, err := caronte.RoundTrip(req)
+	assert.NoError(t, err)
+
+	stats := cache.Stats()
+	assert.Equal(t, 1, cache.Len())
+	assert.Len(t, stats, 1)
+	assert.Equal(t, int64(1), stats[0].OpenConnections)
+	assert.Equal(t, int64(1), stats[0].ActiveRequests)
+	assert.Equal(t, int64(0), stats[0].IdleConnections)
+	assert.Equal(t, 5, stats[0].MaxIdleConnsPerHost)
+
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	stats = cache.Stats()
+	assert.Equal(t, int64(0), stats[0].ActiveRequests)
+	assert.Equal(t, int64(1), stats[0].IdleConnections)
+	assert.Equal(t, int64(1), stats[0].TotalDials)
+}
+
+// TestTiming verifies that the phases of upstream requests are measured.
+func TestTiming(t *testing.T) {
+	setupTestConfig()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	location := &config.LocationConfig{Path: "/timing", Transport: &config.TransportConfig{HTTP: config.HTTPTransportConfig{MaxIdleConnsPerHost: 2}}}
+	cache := transport.NewTransportCache(config.GetCurrentProxyConfig().Transport.HTTP)
+	caronte := &transport.Caronte{Location: location, TransportCache: cache}
+
+	roundTrip := func() transport.Phases {
+		timing := &transport.Timing{}
+		req := httptest.NewRequest("GET", upstream.URL, nil)
+		req.RequestURI = ""
+		req = req.WithContext(transport.WithTiming(req.Context(), timing))
+		resp, err := caronte.RoundTrip(req)
+		if !assert.NoError(t, err) {
+			return transport.Phases{}
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return timing.Phases()
+	}
+
+	phases := roundTrip()
+	assert.Equal(t, upstream.Listener.Addr().String(), phases.Addr)
+	assert.False(t, phases.Reused)
+	assert.Greater(t, phases.Connect, time.Duration(0))
+	assert.GreaterOrEqual(t, phases.Header, 20*time.Millisecond)
+	assert.GreaterOrEqual(t, phases.Total, phases.Header)
+
+	phases = roundTrip()
+	assert.True(t, phases.Reused)
+	assert.Equal(t, time.Duration(0), phases.Connect)
+}
+
+// TestAddHeadersForwardedFor verifies that X-Forwarded-For receives the client address without its port, IPv6
+// included, and only once when the reverse proxy has already appended it.
+func TestAddHeadersForwardedFor(t *testing.T) {
+	caronte := &transport.Caronte{Location: &config.LocationConfig{}}
+	forwarded := func(remoteAddr string, prior ...string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		for _, value := range prior {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		caronte.AddHeaders(req)
+		return req.Header.Get("X-Forwarded-For")
+	}
+
+	assert.Equal(t, "192.0.2.1", forwarded("192.0.2.1:4321"))
+	assert.Equal(t, "2001:db8::1", forwarded("[2001:db8::1]:4321"))
+	assert.Equal(t, "198.51.100.2, 2001:db8::1", forwarded("[2001:db8::1]:4321", "198.51.100.2"))
+	assert.Equal(t, "198.51.100.2, 2001:db8::1", forwarded("[2001:db8::1]:4321", "198.51.100.2, 2001:db8::1"))
+	assert.Equal(t, "11.2.3.4, 1.2.3.4", forwarded("1.2.3.4:4321", "11.2.3.4"))
+}
+
+// TestRoundTripCredentials verifies that the upstream credentials of a location replace those sent by the client.
+func TestRoundTripCredentials(t *testing.T) {
+	setupTestConfig()
+
+	var authorization, apiKey string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		apiKey = r.Header.Get("X-API-Key")
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		credentials   config.UpstreamCredentials
+		authorization string
+		apiKey        string
+	}{
+		{config.UpstreamCredentials{Type: config.CredentialsBearer, Token: "backend-token"}, "Bearer backend-token", "client-key"},
+		{config.UpstreamCredentials{Type: config.CredentialsBasic, Username: "dito", Password: "s3cret"}, "Basic ZGl0bzpzM2NyZXQ=", "client-key"},
+		{config.UpstreamCredentials{Type: config.CredentialsHeader, Header: "X-API-Key", Value: "backend-key"}, "Bearer client-token", "backend-key"},
+	}
+	for _, tt := range tests {
+		location := &config.LocationConfig{Path: "/credentials", Credentials: tt.credentials}
+		caronte := &transport.Caronte{Location: location, TransportCache: transport.NewTransportCache(config.GetCurrentProxyConfig().Transport.HTTP)}
+
+		req := httptest.NewRequest("GET", upstream.URL, nil)
+		req.RequestURI = ""
+		req.Header.Set("Authorization", "Bearer client-token")
+		req.Header.Set("X-API-Key", "client-key")
+		resp, err := caronte.RoundTrip(req)
+		if assert.NoError(t, err, tt.credentials.Type) {
+			resp.Body.Close()
+			assert.Equal(t, tt.authorization, authorization, tt.credentials.Type)
+			assert.Equal(t, tt.apiKey, apiKey, tt.credentials.Type)
+		}
+	}
+}
+
+// TestRoundTripSigning verifies that the upstream receives the HMAC signature of the timestamp and the body, and
+// the body itself, and that bodies above the maximum size are not sent.
+func TestRoundTripSigning(t *testing.T) {
+	setupTestConfig()
+
+	var signature, timestamp, body string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("X-Signature")
+		timestamp = r.Header.Get("X-Timestamp")
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer upstream.Close()
+
+	location := &config.LocationConfig{Path: "/signed", Signing: config.UpstreamSigning{
+		Type:        config.SigningHMAC,
+		MaxBodySize: 16,
+		HMAC:        config.HMACSigning{Secret: "key", Algorithm: config.HMACAlgorithmSHA256, Encoding: config.HMACEncodingHex, Header: "X-Signature", Prefix: "sha256=", TimestampHeader: "X-Timestamp"},
+	}}
+	caronte := &transport.Caronte{Location: location, TransportCache: transport.NewTransportCache(config.GetCurrentProxyConfig().Transport.HTTP)}
+
+	req := httptest.NewRequest("POST", upstream.URL, strings.NewReader(`{"a":1}`))
+	req.RequestURI = ""
+	resp, err := caronte.RoundTrip(req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, `{"a":1}`, body)
+		assert.Equal(t, "sha256="+hex.EncodeToString(signing.HMAC("sha256", "key", []byte(`{"a":1}`), timestamp)), signature)
+	}
+
+	req = httptest.NewRequest("POST", upstream.URL, strings.NewReader(strings.Repeat("a", 32)))
+	req.RequestURI = ""
+	_, err = caronte.RoundTrip(req)
+	assert.ErrorContains(t, err, "exceeds the maximum size")
+}
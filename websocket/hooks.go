@@ -0,0 +1,108 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+// Direction is the direction a proxied message travels in.
+type Direction int
+
+const (
+	ClientToUpstream Direction = iota // The message was sent by the client.
+	UpstreamToClient                  // The message was sent by the upstream.
+)
+
+// String returns the name of the direction.
+func (d Direction) String() string {
+	if d == ClientToUpstream {
+		return "client_to_upstream"
+	}
+	return "upstream_to_client"
+}
+
+// Message is a data message (text or binary) flowing through a proxied session.
+type Message struct {
+	Direction Direction // Direction is the direction of the message.
+	Type      int       // Type is websocket.TextMessage or websocket.BinaryMessage.
+	Data      []byte    // Data is the payload; hooks may replace it to modify the message.
+}
+
+// Action is what happens to a message after a hook has inspected it.
+type Action int
+
+const (
+	Forward Action = iota // Forward passes the message on, to the next hook and then to its destination.
+	Drop                  // Drop discards the message silently.
+	Close                 // Close ends the session, sending the close code and reason to both sides.
+)
+
+// Verdict is the decision of a hook about a message.
+type Verdict struct {
+	Action      Action
+	CloseCode   int    // CloseCode is sent when closing; defaults to 1008 (policy violation).
+	CloseReason string // CloseReason is sent when closing.
+}
+
+// MessageHook inspects a message of a session. It is called from the goroutine copying its direction,
+// so a hook shared by both directions must be safe for concurrent use.
+type MessageHook func(message *Message) Verdict
+
+// messageHooksKey is the request context key of the message hooks.
+type messageHooksKey struct{}
+
+// WithMessageHook returns a request carrying a message hook, for the session the request is upgraded to.
+// Middlewares call it before the upgrade; hooks run in the order they were added.
+//
+// Parameters:
+//   - r: The HTTP request.
+//   - hook: The message hook.
+//
+// Returns:
+//   - *http.Request: The request carrying the hook.
+func WithMessageHook(r *http.Request, hook MessageHook) *http.Request {
+	hooks := messageHooks(r.Context())
+	hooks = append(hooks[:len(hooks):len(hooks)], hook)
+	return r.WithContext(context.WithValue(r.Context(), messageHooksKey{}, hooks))
+}
+
+// messageHooks returns the message hooks carried by a context.
+func messageHooks(ctx context.Context) []MessageHook {
+	hooks, _ := ctx.Value(messageHooksKey{}).([]MessageHook)
+	return hooks
+}
+
+// applyHooks runs the hooks on a message until one of them does not forward it.
+func applyHooks(hooks []MessageHook, message *Message) Verdict {
+	for _, hook := range hooks {
+		if verdict := hook(message); verdict.Action != Forward {
+			if verdict.Action == Close && verdict.CloseCode == 0 {
+				verdict.CloseCode = websocket.ClosePolicyViolation
+			}
+			return verdict
+		}
+	}
+	return Verdict{Action: Forward}
+}
+
+// MessageRateLimit returns a hook closing the session when the client sends messages faster than the limit.
+// Each call creates a new limiter, so the hook must be created per session.
+//
+// Parameters:
+//   - messagesPerSecond: The sustained rate of client messages allowed.
+//   - burst: The number of messages allowed at once.
+//
+// Returns:
+//   - MessageHook: The hook enforcing the limit.
+func MessageRateLimit(messagesPerSecond float64, burst int) MessageHook {
+	limiter := rate.NewLimiter(rate.Limit(messagesPerSecond), max(burst, 1))
+	return func(message *Message) Verdict {
+		if message.Direction != ClientToUpstream || limiter.Allow() {
+			return Verdict{Action: Forward}
+		}
+		return Verdict{Action: Close, CloseCode: websocket.ClosePolicyViolation, CloseReason: "message rate exceeded"}
+	}
+}
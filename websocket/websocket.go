@@ -1,38 +1,78 @@
 package websocket
 
 import (
+	"context"
+	"dito/config"
 	"dito/logging"
+	"dito/metrics"
+	"errors"
 	"github.com/gorilla/websocket"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrClosedByHook reports that a message hook closed the session.
+var ErrClosedByHook = errors.New("websocket session closed by a message hook")
+
+// closeGracePeriod is the time allowed to send a close frame, and for the other direction of a session to
+// complete the closing handshake once one direction has ended.
+const closeGracePeriod = time.Second
+
+// defaultWriteTimeout is the maximum time to forward a message when the location does not set one.
+const defaultWriteTimeout = 10 * time.Second
+
+// session is a proxied WebSocket session.
+type session struct {
+	client *websocket.Conn
+	server *websocket.Conn
+}
+
+// sessions tracks the active sessions, since hijacked connections are not covered by the HTTP server shutdown.
+var sessions = struct {
+	sync.Mutex
+	active map[*session]struct{}
+	done   chan struct{} // done is closed when the last active session ends.
+}{active: make(map[*session]struct{})}
+
 // HandleWebSocketProxy handles the proxying of WebSocket connections between a client and a target server.
 // It upgrades the HTTP connection to a WebSocket connection and forwards messages between the client and server.
 //
 // Parameters:
 //   - w: The HTTP response writer.
 //   - r: The HTTP request.
-//   - targetURL: The URL of the target WebSocket server.
+//   - location: The location configuration: its target URL (http and https URLs are translated to ws and wss),
+//     its WebSocket settings, and its path, which labels the session metrics.
+//   - dialer: The dialer connecting to the target server, or nil for the default dialer.
 //   - logger: The logger instance.
-func HandleWebSocketProxy(w http.ResponseWriter, r *http.Request, targetURL string, logger *slog.Logger) {
-	url, err := url.Parse(targetURL)
+func HandleWebSocketProxy(w http.ResponseWriter, r *http.Request, location config.LocationConfig, dialer *websocket.Dialer, logger *slog.Logger) {
+	url, err := upstreamURL(location.TargetURL)
 	if err != nil {
 		logger.Error("Invalid WebSocket target URL", slog.Any("details", err))
 		http.Error(w, "Invalid WebSocket target URL", http.StatusInternalServerError)
 		return
 	}
 
+	if !OriginAllowed(r, location.WebSocket.AllowedOrigins) {
+		logger.Warn("WebSocket origin not allowed", "origin", r.Header.Get("Origin"), "path", r.URL.Path)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
 	upgrader := websocket.Upgrader{
+		// The origin has been checked above.
 		CheckOrigin: func(r *http.Request) bool { return true },
 	}
 
 	clientConn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		// The upgrader has already answered the client with an error status.
 		logger.Error("Failed to upgrade to WebSocket", slog.Any("details", err))
-		http.Error(w, "Failed to upgrade to WebSocket", http.StatusInternalServerError)
 		return
 	}
 	defer func() {
@@ -41,7 +81,10 @@ func HandleWebSocketProxy(w http.ResponseWriter, r *http.Request, targetURL stri
 		}
 	}()
 
-	serverConn, _, err := websocket.DefaultDialer.Dial(url.String(), nil)
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	serverConn, _, err := dialer.DialContext(r.Context(), url.String(), nil)
 	if err != nil {
 		logger.Error("Failed to connect to target WebSocket server", slog.Any("details", err))
 		clientConn.WriteMessage(websocket.TextMessage, []byte("Error: Unable to connect to WebSocket server"))
@@ -53,36 +96,212 @@ func HandleWebSocketProxy(w http.ResponseWriter, r *http.Request, targetURL stri
 		}
 	}()
 
+	// Close frames are forwarded to the other peer instead of being answered by the proxy, so that the
+	// peers complete the closing handshake between themselves with their own codes and reasons.
+	ignoreClose := func(code int, text string) error { return nil }
+	clientConn.SetCloseHandler(ignoreClose)
+	serverConn.SetCloseHandler(ignoreClose)
+
+	s := &session{client: clientConn, server: serverConn}
+	track(s)
+	defer untrack(s)
+	metrics.UpdateWebSocketConnections(location.Label(), true)
+	defer metrics.UpdateWebSocketConnections(location.Label(), false)
+	start := time.Now()
+	defer func() { metrics.RecordWebSocketSession(location.Label(), time.Since(start).Seconds()) }()
+
+	hooks := messageHooks(r.Context())
+	if location.KeepaliveInterval > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		hooks = append(hooks[:len(hooks):len(hooks)], keepalive(clientConn, location.KeepaliveInterval, stop))
+	}
+	writeTimeout := location.WebSocket.WriteTimeout
+	if writeTimeout <= 0 {
+		writeTimeout = defaultWriteTimeout
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan error, 2)
+	for _, pipe := range []struct {
+		src, dest *websocket.Conn
+		direction Direction
+	}{{clientConn, serverConn, ClientToUpstream}, {serverConn, clientConn, UpstreamToClient}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := CopyWebSocketMessages(pipe.src, pipe.dest, location.Label(), pipe.direction, hooks, writeTimeout, logger)
+			logCopyError(logger, pipe.direction, err)
+			results <- err
+		}()
+	}
+
+	// A direction ending with a close frame leaves the other one the grace period to carry the reply of the
+	// peer; a failed direction ends the session at once. Closing the connections unblocks the pending reads.
+	if err := <-results; err == nil || errors.Is(err, ErrClosedByHook) {
+		timer := time.NewTimer(closeGracePeriod)
+		select {
+		case <-results:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+	clientConn.Close()
+	serverConn.Close()
+	wg.Wait()
+}
+
+// keepalive pings the client whenever the upstream has been silent for the interval, until stop is closed,
+// so that idle sessions are not dropped by NATs and proxies along the way.
+//
+// Parameters:
+//   - client: The client connection.
+//   - interval: The maximum silence before a ping is sent.
+//   - stop: Closed when the session ends.
+//
+// Returns:
+//   - MessageHook: The hook tracking the messages of the upstream; it forwards every message.
+func keepalive(client *websocket.Conn, interval time.Duration, stop <-chan struct{}) MessageHook {
+	var last atomic.Int64
+	last.Store(time.Now().UnixNano())
+
 	go func() {
-		if err := CopyWebSocketMessages(clientConn, serverConn, logger); err != nil {
-			logger.Error("Error while copying message from client to server", slog.Any("details", err))
+		timer := time.NewTimer(interval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-timer.C:
+			}
+			idle := time.Since(time.Unix(0, last.Load()))
+			if idle >= interval {
+				if err := client.WriteControl(websocket.PingMessage, nil, time.Now().Add(closeGracePeriod)); err != nil {
+					return
+				}
+				last.Store(time.Now().UnixNano())
+				idle = 0
+			}
+			timer.Reset(interval - idle)
 		}
-		clientConn.Close()
-		serverConn.Close()
 	}()
 
-	if err := CopyWebSocketMessages(serverConn, clientConn, logger); err != nil {
-		logger.Error("Error while copying message from server to client", slog.Any("details", err))
-		clientConn.Close()
-		serverConn.Close()
+	return func(message *Message) Verdict {
+		if message.Direction == UpstreamToClient {
+			last.Store(time.Now().UnixNano())
+		}
+		return Verdict{Action: Forward}
+	}
+}
+
+// logCopyError logs the error ending a copy direction; closing handshakes and sessions closed by a hook are
+// not errors.
+func logCopyError(logger *slog.Logger, direction Direction, err error) {
+	switch {
+	case err == nil:
+		logger.Debug("WebSocket close frame forwarded", "direction", direction.String())
+	case errors.Is(err, ErrClosedByHook):
+		logger.Info("WebSocket session closed by a message hook")
+	case errors.Is(err, net.ErrClosed):
+		// The session was ended by the other direction.
+	default:
+		logger.Error("Error while copying WebSocket messages", "direction", direction.String(), slog.Any("details", err))
+	}
+}
+
+// track registers an active session.
+func track(s *session) {
+	sessions.Lock()
+	defer sessions.Unlock()
+	if len(sessions.active) == 0 {
+		sessions.done = make(chan struct{})
+	}
+	sessions.active[s] = struct{}{}
+}
+
+// untrack removes a session once it has ended.
+func untrack(s *session) {
+	sessions.Lock()
+	defer sessions.Unlock()
+	delete(sessions.active, s)
+	if len(sessions.active) == 0 && sessions.done != nil {
+		close(sessions.done)
+		sessions.done = nil
+	}
+}
+
+// ActiveSessions returns the number of WebSocket sessions being proxied.
+func ActiveSessions() int {
+	sessions.Lock()
+	defer sessions.Unlock()
+	return len(sessions.active)
+}
+
+// Shutdown waits for the active WebSocket sessions to end until the context is done, then closes the
+// remaining ones, sending the clients a going-away close frame so that they can reconnect elsewhere.
+//
+// Parameters:
+//   - ctx: The context bounding the wait. An already expired context closes the sessions at once.
+//
+// Returns:
+//   - int: The number of sessions closed.
+func Shutdown(ctx context.Context) int {
+	sessions.Lock()
+	done := sessions.done
+	sessions.Unlock()
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}
+
+	sessions.Lock()
+	defer sessions.Unlock()
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for s := range sessions.active {
+		s.client.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(closeGracePeriod))
+		s.client.Close()
+		s.server.Close()
 	}
+	return len(sessions.active)
 }
 
 // CopyWebSocketMessages copies messages from the source WebSocket connection to the destination WebSocket connection.
-// It logs the details of the messages and any errors that occur during the process.
+// Every message goes through the message hooks, which may modify or drop it, or close the session. A close frame
+// received from the source is forwarded to the destination with its code and reason, ending the copy.
+// It logs the details of the messages and any errors that occur during the process, and records the
+// forwarded messages and the close codes in the metrics of the location.
 //
 // Parameters:
 //   - src: The source WebSocket connection.
 //   - dest: The destination WebSocket connection.
+//   - location: The location path labeling the metrics.
+//   - direction: The direction of the messages.
+//   - hooks: The message hooks of the session.
+//   - writeTimeout: The maximum time to write a message to the destination.
 //   - logger: The logger instance.
 //
 // Returns:
-//   - error: An error if the message copying fails.
-func CopyWebSocketMessages(src, dest *websocket.Conn, logger *slog.Logger) error {
+//   - error: Nil once a close frame has been forwarded, ErrClosedByHook if a hook closed the session, or the
+//     error that ended the copy.
+func CopyWebSocketMessages(src, dest *websocket.Conn, location string, direction Direction, hooks []MessageHook, writeTimeout time.Duration, logger *slog.Logger) error {
 	for {
 		startTime := time.Now()
 		messageType, message, err := src.ReadMessage()
 		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				metrics.RecordWebSocketClose(location, direction.String(), closeErr.Code)
+			}
+			if closeErr != nil && closeErr.Code != websocket.CloseAbnormalClosure {
+				logging.LogWebSocketMessage(websocket.CloseMessage, []byte(closeErr.Text), nil, time.Since(startTime))
+				closeMessage := websocket.FormatCloseMessage(closeErr.Code, closeErr.Text)
+				if err := dest.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(closeGracePeriod)); err != nil && !errors.Is(err, websocket.ErrCloseSent) {
+					return err
+				}
+				return nil
+			}
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				logger.Error("Unexpected WebSocket closure", slog.Any("details", err))
 			}
@@ -91,12 +310,103 @@ func CopyWebSocketMessages(src, dest *websocket.Conn, logger *slog.Logger) error
 		}
 		logging.LogWebSocketMessage(messageType, message, nil, time.Since(startTime))
 
+		msg := &Message{Direction: direction, Type: messageType, Data: message}
+		switch verdict := applyHooks(hooks, msg); verdict.Action {
+		case Drop:
+			continue
+		case Close:
+			metrics.RecordWebSocketClose(location, direction.String(), verdict.CloseCode)
+			closeMessage := websocket.FormatCloseMessage(verdict.CloseCode, verdict.CloseReason)
+			deadline := time.Now().Add(closeGracePeriod)
+			src.WriteControl(websocket.CloseMessage, closeMessage, deadline)
+			dest.WriteControl(websocket.CloseMessage, closeMessage, deadline)
+			return ErrClosedByHook
+		}
+		messageType, message = msg.Type, msg.Data
+
+		// A destination that stops reading must not block the session forever.
+		dest.SetWriteDeadline(time.Now().Add(writeTimeout))
 		if err := dest.WriteMessage(messageType, message); err != nil {
 			logger.Error("Error writing message", slog.Any("details", err))
 			logging.LogWebSocketMessage(messageType, message, err, time.Since(startTime))
 			return err
 		}
+		metrics.RecordWebSocketMessage(location, direction.String(), len(message))
+	}
+}
+
+// NewDialer returns a dialer connecting to WebSocket servers the way the transport connects to HTTP
+// upstreams: with the same TLS settings (client certificates, CA, pins), dial function, and proxy.
+//
+// Parameters:
+//   - transport: The HTTP transport of the location, or nil for the default settings.
+//
+// Returns:
+//   - *websocket.Dialer: The dialer.
+func NewDialer(transport *http.Transport) *websocket.Dialer {
+	dialer := *websocket.DefaultDialer
+	if transport == nil {
+		return &dialer
+	}
+	dialer.NetDialContext = transport.DialContext
+	dialer.Proxy = transport.Proxy
+	if transport.TLSClientConfig != nil {
+		tlsConfig := transport.TLSClientConfig.Clone()
+		// The WebSocket handshake requires HTTP/1.1, while the transport may negotiate HTTP/2.
+		tlsConfig.NextProtos = []string{"http/1.1"}
+		dialer.TLSClientConfig = tlsConfig
+	}
+	return &dialer
+}
+
+// upstreamURL parses a target URL, translating the http and https schemes to ws and wss.
+func upstreamURL(targetURL string) (*url.URL, error) {
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	return u, nil
+}
+
+// OriginAllowed checks the Origin header of an upgrade request. Without allowed origins only same-origin
+// requests are accepted. Requests without an Origin header come from non-browser clients and are accepted.
+//
+// Parameters:
+//   - r: The HTTP request.
+//   - allowedOrigins: The allowed origins: "*", exact origins (https://app.example.com), or subdomain
+//     wildcards (https://*.example.com).
+//
+// Returns:
+//   - bool: True if the origin is allowed.
+func OriginAllowed(r *http.Request, allowedOrigins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if len(allowedOrigins) == 0 {
+		return strings.EqualFold(originURL.Host, r.Host)
+	}
+
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		scheme, host, ok := strings.Cut(allowed, "://*.")
+		if ok && strings.EqualFold(scheme, originURL.Scheme) && strings.HasSuffix(strings.ToLower(originURL.Host), "."+strings.ToLower(host)) {
+			return true
+		}
 	}
+	return false
 }
 
 // IsWebSocketRequest checks if the given HTTP request is a WebSocket upgrade request.
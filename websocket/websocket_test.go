@@ -0,0 +1,272 @@
+package websocket
+
+import (
+	"context"
+	"dito/config"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// startProxy starts an echo upstream and a proxy in front of it adding the given message hooks.
+func startProxy(t *testing.T, hooks ...MessageHook) string {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	upgrader := websocket.Upgrader{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, message)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, hook := range hooks {
+			r = WithMessageHook(r, hook)
+		}
+		HandleWebSocketProxy(w, r, config.LocationConfig{Path: "/ws", TargetURL: "ws" + strings.TrimPrefix(upstream.URL, "http")}, nil, logger)
+	}))
+	t.Cleanup(proxy.Close)
+	return "ws" + strings.TrimPrefix(proxy.URL, "http")
+}
+
+// TestShutdown verifies that sessions still open at shutdown are closed with a going-away close frame.
+func TestShutdown(t *testing.T) {
+	proxyURL := startProxy(t)
+
+	client, _, err := websocket.DefaultDialer.Dial(proxyURL, nil)
+	assert.NoError(t, err)
+	defer client.Close()
+	assert.NoError(t, client.WriteMessage(websocket.TextMessage, []byte("ping")))
+	_, message, err := client.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(message))
+	assert.Equal(t, 1, ActiveSessions())
+
+	// The session does not end by itself: Shutdown waits for the deadline, then closes it.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, 1, Shutdown(ctx))
+
+	_, _, err = client.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "unexpected error: %v", err)
+	assert.Eventually(t, func() bool { return ActiveSessions() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+// TestMessageHooks verifies that hooks can modify, drop, and close on proxied messages.
+func TestMessageHooks(t *testing.T) {
+	var seen []string
+	proxyURL := startProxy(t,
+		func(message *Message) Verdict {
+			seen = append(seen, message.Direction.String()+":"+string(message.Data))
+			return Verdict{Action: Forward}
+		},
+		func(message *Message) Verdict {
+			switch {
+			case message.Direction == UpstreamToClient:
+				message.Data = []byte(strings.ToUpper(string(message.Data)))
+			case string(message.Data) == "secret":
+				return Verdict{Action: Drop}
+			case string(message.Data) == "bye":
+				return Verdict{Action: Close, CloseCode: websocket.CloseNormalClosure, CloseReason: "see you"}
+			}
+			return Verdict{Action: Forward}
+		},
+	)
+
+	client, _, err := websocket.DefaultDialer.Dial(proxyURL, nil)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	for _, message := range []string{"secret", "hello"} {
+		assert.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(message)))
+	}
+	_, message, err := client.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "HELLO", string(message), "the dropped message must not reach the upstream")
+
+	assert.NoError(t, client.WriteMessage(websocket.TextMessage, []byte("bye")))
+	_, _, err = client.ReadMessage()
+	var closeErr *websocket.CloseError
+	if assert.ErrorAs(t, err, &closeErr) {
+		assert.Equal(t, websocket.CloseNormalClosure, closeErr.Code)
+		assert.Equal(t, "see you", closeErr.Text)
+	}
+	assert.Equal(t, []string{"client_to_upstream:secret", "client_to_upstream:hello", "upstream_to_client:hello", "client_to_upstream:bye"}, seen)
+}
+
+// TestMessageRateLimit verifies that clients sending messages too fast are disconnected.
+func TestMessageRateLimit(t *testing.T) {
+	hook := MessageRateLimit(1, 2)
+	client := &Message{Direction: ClientToUpstream}
+	assert.Equal(t, Forward, hook(client).Action)
+	assert.Equal(t, Forward, hook(client).Action)
+	assert.Equal(t, Forward, hook(&Message{Direction: UpstreamToClient}).Action)
+	verdict := hook(client)
+	assert.Equal(t, Close, verdict.Action)
+	assert.Equal(t, websocket.ClosePolicyViolation, verdict.CloseCode)
+}
+
+// TestOriginAllowed verifies the origin check of upgrade requests.
+func TestOriginAllowed(t *testing.T) {
+	request := func(origin string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://proxy.example.com/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+
+	// Without allowed origins only same-origin browsers and non-browser clients are accepted.
+	assert.True(t, OriginAllowed(request(""), nil))
+	assert.True(t, OriginAllowed(request("https://proxy.example.com"), nil))
+	assert.False(t, OriginAllowed(request("https://evil.example.org"), nil))
+
+	allowed := []string{"https://app.example.com", "https://*.partner.com"}
+	assert.True(t, OriginAllowed(request("https://app.example.com"), allowed))
+	assert.True(t, OriginAllowed(request("https://eu.partner.com"), allowed))
+	assert.False(t, OriginAllowed(request("http://eu.partner.com"), allowed))
+	assert.False(t, OriginAllowed(request("https://partner.com.evil.org"), allowed))
+	assert.False(t, OriginAllowed(request("https://proxy.example.com"), allowed))
+	assert.True(t, OriginAllowed(request("https://anything.org"), []string{"*"}))
+}
+
+// TestTLSUpstream verifies that https targets are dialed as wss with the TLS settings of the transport.
+func TestTLSUpstream(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	upgrader := websocket.Upgrader{}
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte("secure"))
+	}))
+	defer upstream.Close()
+
+	// The transport trusts the test certificate and would negotiate HTTP/2.
+	upstreamTransport := upstream.Client().Transport.(*http.Transport).Clone()
+	upstreamTransport.TLSClientConfig.NextProtos = []string{"h2", "http/1.1"}
+	dialer := NewDialer(upstreamTransport)
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocketProxy(w, r, config.LocationConfig{Path: "/wss", TargetURL: upstream.URL}, dialer, logger)
+	}))
+	defer proxy.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(proxy.URL, "http"), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+	_, message, err := client.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "secure", string(message))
+}
+
+// TestClosePropagation verifies that close frames travel between the peers with their codes and reasons.
+func TestClosePropagation(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	received := make(chan *websocket.CloseError, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetCloseHandler(func(code int, text string) error {
+			received <- &websocket.CloseError{Code: code, Text: text}
+			message := websocket.FormatCloseMessage(code, "upstream "+text)
+			return conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer upstream.Close()
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocketProxy(w, r, config.LocationConfig{Path: "/ws", TargetURL: upstream.URL}, nil, logger)
+	}))
+	defer proxy.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(proxy.URL, "http"), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+	assert.NoError(t, client.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4000, "bye"), time.Now().Add(time.Second)))
+
+	select {
+	case closeErr := <-received:
+		assert.Equal(t, 4000, closeErr.Code)
+		assert.Equal(t, "bye", closeErr.Text)
+	case <-time.After(time.Second):
+		t.Fatal("the upstream did not receive the close frame")
+	}
+	_, _, err = client.ReadMessage()
+	var closeErr *websocket.CloseError
+	if assert.ErrorAs(t, err, &closeErr) {
+		assert.Equal(t, 4000, closeErr.Code)
+		assert.Equal(t, "upstream bye", closeErr.Text)
+	}
+	assert.Eventually(t, func() bool { return ActiveSessions() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+// TestKeepalive verifies that the client is pinged while the upstream is silent.
+func TestKeepalive(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.ReadMessage()
+	}))
+	defer upstream.Close()
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		location := config.LocationConfig{Path: "/ws", TargetURL: upstream.URL, KeepaliveInterval: 20 * time.Millisecond}
+		HandleWebSocketProxy(w, r, location, nil, logger)
+	}))
+	defer proxy.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(proxy.URL, "http"), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+	pings := make(chan struct{}, 10)
+	client.SetPingHandler(func(string) error {
+		pings <- struct{}{}
+		return nil
+	})
+	go client.ReadMessage()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-pings:
+		case <-time.After(time.Second):
+			t.Fatal("the client was not pinged")
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package writer
+
+import (
+	"mime"
+	"strings"
+)
+
+// MediaType returns the media type of a Content-Type header value, lowercased and without parameters.
+//
+// Parameters:
+// - contentType: The Content-Type header value.
+//
+// Returns:
+// - string: The media type, e.g. application/soap+xml, or an empty string if the value is empty or malformed.
+func MediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// IsJSON reports whether a Content-Type denotes a JSON body: application/json or a +json type.
+func IsJSON(contentType string) bool {
+	mediaType := MediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// IsXML reports whether a Content-Type denotes an XML body: text/xml, application/xml, or a +xml type
+// such as application/soap+xml.
+func IsXML(contentType string) bool {
+	mediaType := MediaType(contentType)
+	return mediaType == "text/xml" || mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml")
+}
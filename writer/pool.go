@@ -0,0 +1,113 @@
+package writer
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// maxPooledBufferSize is the largest body buffer kept for reuse. Larger buffers are
+	// released to the garbage collector so that a single huge response does not pin memory.
+	maxPooledBufferSize = 1 << 20
+	// sizeHintWeight is the weight (out of 8) given to the previous size hint when averaging.
+	sizeHintWeight = 7
+)
+
+// Pool reuses ResponseWriters and their body buffers across requests.
+//
+// Each pool learns the typical body size of the responses it buffers and pre-sizes the
+// buffers it hands out accordingly, so that keeping one pool per location avoids both
+// repeated growth for large responses and oversized buffers for small ones.
+type Pool struct {
+	pool     sync.Pool
+	sizeHint atomic.Int64 // sizeHint is a moving average of the buffered body sizes.
+}
+
+// pools holds the pools registered with PoolFor, keyed by name.
+var pools sync.Map
+
+// NewPool creates an empty ResponseWriter pool.
+//
+// Returns:
+// - *Pool: The new pool.
+func NewPool() *Pool {
+	p := &Pool{}
+	p.pool.New = func() any { return &ResponseWriter{} }
+	return p
+}
+
+// PoolFor returns the shared pool registered under the given key (typically a location path),
+// creating it on first use.
+//
+// Parameters:
+// - key: The pool key.
+//
+// Returns:
+// - *Pool: The pool for the key.
+func PoolFor(key string) *Pool {
+	if p, ok := pools.Load(key); ok {
+		return p.(*Pool)
+	}
+	p, _ := pools.LoadOrStore(key, NewPool())
+	return p.(*Pool)
+}
+
+// Get returns a reset ResponseWriter wrapping w. Buffering writers get a body buffer
+// pre-sized to the sizes previously seen by the pool.
+//
+// Parameters:
+// - w: The underlying HTTP response writer.
+// - passthrough: Whether the writer should skip body buffering.
+//
+// Returns:
+// - *ResponseWriter: The writer, to be returned with Put once the response is complete.
+func (p *Pool) Get(w http.ResponseWriter, passthrough bool) *ResponseWriter {
+	rw := p.pool.Get().(*ResponseWriter)
+	rw.ResponseWriter = w
+	rw.Passthrough = passthrough
+	if !passthrough {
+		if hint := int(p.sizeHint.Load()); hint > rw.Body.Cap() {
+			rw.Body.Grow(hint)
+		}
+	}
+	return rw
+}
+
+// Put returns a writer to the pool. The writer and its body must not be used afterwards.
+//
+// Parameters:
+// - rw: The writer obtained from Get.
+func (p *Pool) Put(rw *ResponseWriter) {
+	if !rw.Passthrough {
+		p.updateSizeHint(rw.Body.Len())
+	}
+
+	rw.ResponseWriter = nil
+	rw.StatusCode = 0
+	rw.BytesWritten = 0
+	rw.Passthrough = false
+	rw.MaxBodySize = 0
+	rw.Truncated = false
+	rw.Spool = nil
+	rw.Body.Reset()
+	if rw.Body.Cap() > maxPooledBufferSize {
+		rw.Body = bytes.Buffer{}
+	}
+	p.pool.Put(rw)
+}
+
+// updateSizeHint folds a new body size into the moving average.
+func (p *Pool) updateSizeHint(size int) {
+	if size > maxPooledBufferSize {
+		size = maxPooledBufferSize
+	}
+	for {
+		old := p.sizeHint.Load()
+		next := (old*sizeHintWeight + int64(size)) / (sizeHintWeight + 1)
+		if p.sizeHint.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
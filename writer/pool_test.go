@@ -0,0 +1,79 @@
+package writer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestPoolReset tests that writers returned to the pool come back clean.
+func TestPoolReset(t *testing.T) {
+	pool := NewPool()
+
+	rw := pool.Get(httptest.NewRecorder(), false)
+	rw.MaxBodySize = 2
+	rw.WriteHeader(http.StatusCreated)
+	rw.Write([]byte("test body"))
+	pool.Put(rw)
+
+	inner := httptest.NewRecorder()
+	rw = pool.Get(inner, true)
+	if rw.ResponseWriter != inner {
+		t.Error("Expected the writer to wrap the new ResponseWriter")
+	}
+	if rw.StatusCode != 0 || rw.BytesWritten != 0 || rw.Truncated || rw.MaxBodySize != 0 || rw.Body.Len() != 0 {
+		t.Errorf("Expected a reset writer, got %+v", rw)
+	}
+	if !rw.Passthrough {
+		t.Error("Expected the writer to be in passthrough mode")
+	}
+}
+
+// TestPoolSizeHint tests that buffered writers are pre-sized from previous responses.
+func TestPoolSizeHint(t *testing.T) {
+	pool := NewPool()
+	body := make([]byte, 8192)
+
+	for i := 0; i < 32; i++ {
+		rw := pool.Get(httptest.NewRecorder(), false)
+		rw.Write(body)
+		pool.Put(rw)
+	}
+
+	if hint := pool.sizeHint.Load(); hint < 4096 || hint > int64(len(body)) {
+		t.Errorf("Expected size hint close to %d, got %d", len(body), hint)
+	}
+	rw := pool.Get(httptest.NewRecorder(), false)
+	if rw.Body.Cap() < int(pool.sizeHint.Load()) {
+		t.Errorf("Expected buffer capacity of at least %d, got %d", pool.sizeHint.Load(), rw.Body.Cap())
+	}
+}
+
+// TestPoolFor tests that the same key always returns the same pool.
+func TestPoolFor(t *testing.T) {
+	if PoolFor("/api") != PoolFor("/api") {
+		t.Error("Expected the same pool for the same key")
+	}
+	if PoolFor("/api") == PoolFor("/other") {
+		t.Error("Expected different pools for different keys")
+	}
+}
+
+// BenchmarkPoolBuffered measures buffered writers recycled through a pool.
+func BenchmarkPoolBuffered(b *testing.B) {
+	pool := NewPool()
+	inner := &discardResponseWriter{header: make(http.Header)}
+	chunk := make([]byte, 4096)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(chunk) * 8))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rw := pool.Get(inner, false)
+		rw.WriteHeader(http.StatusOK)
+		for j := 0; j < 8; j++ {
+			rw.Write(chunk)
+		}
+		pool.Put(rw)
+	}
+}
@@ -3,29 +3,43 @@ package writer
 import (
 	"bufio"
 	"bytes"
+	"dito/spool"
 	"net"
 	"net/http"
 )
 
-// ResponseWriter is an HTTP response writer that logs the status code and body.
+// ResponseWriter is an HTTP response writer that records the status code, the number of bytes
+// written and, unless Passthrough is set, a copy of the body.
+//
+// When Passthrough is true the writer takes a zero-buffering fast path: bytes go straight to the
+// underlying ResponseWriter and only the status code and byte count are tracked. Use it whenever
+// no feature (caching, verbose response logging, ...) needs to inspect the body.
 type ResponseWriter struct {
-	http.ResponseWriter              // Embeds the standard HTTP ResponseWriter.
-	StatusCode          int          // Stores the HTTP status code of the response.
-	Body                bytes.Buffer // Buffers the body of the response.
-	BytesWritten        int          // Tracks the number of bytes written to the response.
+	http.ResponseWriter               // Embeds the standard HTTP ResponseWriter.
+	StatusCode          int           // Stores the HTTP status code of the response.
+	Body                bytes.Buffer  // Buffers the body of the response (unless Passthrough is set).
+	BytesWritten        int           // Tracks the number of bytes written to the response.
+	Passthrough         bool          // Skips body buffering entirely (fast path).
+	MaxBodySize         int           // Limits the number of buffered body bytes (0 means unlimited).
+	Truncated           bool          // Reports whether the buffered body was cut at MaxBodySize or did not fit in Spool.
+	Spool               *spool.Buffer // Receives the body instead of Body, spilling to disk when it is large.
 }
 
 // WriteHeader logs the status code and writes it to the underlying ResponseWriter.
+// Informational (1xx) responses other than 101 Switching Protocols are forwarded without being
+// recorded, since they are followed by the final status code.
 //
 // Parameters:
 // - statusCode: The HTTP status code to be written.
 func (rw *ResponseWriter) WriteHeader(statusCode int) {
-	rw.StatusCode = statusCode
+	if rw.StatusCode == 0 && (statusCode >= 200 || statusCode == http.StatusSwitchingProtocols) {
+		rw.StatusCode = statusCode
+	}
 	rw.ResponseWriter.WriteHeader(statusCode)
 }
 
-// Write writes the data to the buffer and logs the number of bytes written.
-// It also writes the same data to the actual ResponseWriter so that it is sent to the client.
+// Write writes the data to the actual ResponseWriter so that it is sent to the client, and
+// unless the writer is in passthrough mode, keeps a copy of it in the body buffer.
 //
 // Parameters:
 // - b: The byte slice to write to the response.
@@ -39,21 +53,54 @@ func (rw *ResponseWriter) Write(b []byte) (int, error) {
 		rw.StatusCode = http.StatusOK
 	}
 
-	// Write to the buffer and track the bytes being written.
-	n, err := rw.Body.Write(b)
-	rw.BytesWritten += n // Update the total bytes written
+	n, err := rw.ResponseWriter.Write(b)
+	rw.BytesWritten += n
 
-	if err != nil {
-		return n, err
+	if !rw.Passthrough {
+		rw.capture(b[:n])
 	}
 
-	// Write the same data to the actual ResponseWriter so that it is sent to the client.
-	n, err = rw.ResponseWriter.Write(b)
-	rw.BytesWritten += n // Update the total bytes written again if necessary
-
 	return n, err
 }
 
+// capture appends the written bytes to the body buffer, honoring MaxBodySize.
+// When a Spool is set the bytes go to it instead, and the capture stops as soon as it refuses data.
+func (rw *ResponseWriter) capture(b []byte) {
+	if rw.Spool != nil {
+		if !rw.Truncated {
+			if _, err := rw.Spool.Write(b); err != nil {
+				rw.Truncated = true
+			}
+		}
+		return
+	}
+	if rw.MaxBodySize > 0 {
+		remaining := rw.MaxBodySize - rw.Body.Len()
+		if remaining <= 0 {
+			rw.Truncated = rw.Truncated || len(b) > 0
+			return
+		}
+		if len(b) > remaining {
+			b = b[:remaining]
+			rw.Truncated = true
+		}
+	}
+	rw.Body.Write(b)
+}
+
+// Flush sends any buffered data to the client, if the underlying ResponseWriter supports it.
+func (rw *ResponseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, so that http.ResponseController can reach
+// optional interfaces (deadlines, full duplex, ...) that the wrapper does not implement.
+func (rw *ResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // Hijack allows the caller to take over the connection from the HTTP server.
 // This function is typically used for implementing WebSockets or other protocols
 // that require raw network access.
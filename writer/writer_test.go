@@ -1,6 +1,8 @@
 package writer
 
 import (
+	"dito/spool"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -28,8 +30,8 @@ func TestResponseWriter(t *testing.T) {
 		t.Fatal("Failed to write to ResponseWriter:", err)
 	}
 
-	if rw.BytesWritten != len(testBody)*2 {
-		t.Errorf("Expected bytes written %d, got %d", len(testBody)*2, rw.BytesWritten)
+	if rw.BytesWritten != len(testBody) {
+		t.Errorf("Expected bytes written %d, got %d", len(testBody), rw.BytesWritten)
 	}
 
 	if rw.Body.String() != testBody {
@@ -45,3 +47,161 @@ func TestResponseWriter(t *testing.T) {
 		t.Errorf("Expected inner status code %d, got %d", statusCode, inner.Code)
 	}
 }
+
+// TestResponseWriterPassthrough tests that passthrough mode forwards the body without buffering it.
+func TestResponseWriterPassthrough(t *testing.T) {
+	inner := httptest.NewRecorder()
+	rw := &ResponseWriter{ResponseWriter: inner, Passthrough: true}
+
+	testBody := "test body"
+	if _, err := rw.Write([]byte(testBody)); err != nil {
+		t.Fatal("Failed to write to ResponseWriter:", err)
+	}
+
+	if rw.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, rw.StatusCode)
+	}
+	if rw.BytesWritten != len(testBody) {
+		t.Errorf("Expected bytes written %d, got %d", len(testBody), rw.BytesWritten)
+	}
+	if rw.Body.Len() != 0 {
+		t.Errorf("Expected empty buffer, got '%s'", rw.Body.String())
+	}
+	if inner.Body.String() != testBody {
+		t.Errorf("Expected inner body '%s', got '%s'", testBody, inner.Body.String())
+	}
+}
+
+// TestResponseWriterMaxBodySize tests that the buffered body is truncated at MaxBodySize.
+func TestResponseWriterMaxBodySize(t *testing.T) {
+	inner := httptest.NewRecorder()
+	rw := &ResponseWriter{ResponseWriter: inner, MaxBodySize: 6}
+
+	rw.Write([]byte("test "))
+	rw.Write([]byte("body"))
+
+	if rw.Body.String() != "test b" {
+		t.Errorf("Expected buffered body 'test b', got '%s'", rw.Body.String())
+	}
+	if !rw.Truncated {
+		t.Error("Expected Truncated to be true")
+	}
+	if rw.BytesWritten != 9 {
+		t.Errorf("Expected bytes written 9, got %d", rw.BytesWritten)
+	}
+	if inner.Body.String() != "test body" {
+		t.Errorf("Expected inner body 'test body', got '%s'", inner.Body.String())
+	}
+}
+
+// TestResponseWriterInformationalStatus tests that 1xx responses do not mask the final status code.
+func TestResponseWriterInformationalStatus(t *testing.T) {
+	rw := &ResponseWriter{ResponseWriter: httptest.NewRecorder(), Passthrough: true}
+
+	rw.WriteHeader(http.StatusContinue)
+	rw.WriteHeader(http.StatusCreated)
+
+	if rw.StatusCode != http.StatusCreated {
+		t.Errorf("Expected status code %d, got %d", http.StatusCreated, rw.StatusCode)
+	}
+}
+
+// TestResponseWriterUnwrap tests that http.ResponseController can reach the underlying writer.
+func TestResponseWriterUnwrap(t *testing.T) {
+	inner := httptest.NewRecorder()
+	rw := &ResponseWriter{ResponseWriter: inner}
+
+	if err := http.NewResponseController(rw).Flush(); err != nil {
+		t.Fatal("Expected flush to succeed:", err)
+	}
+	if !inner.Flushed {
+		t.Error("Expected the inner writer to be flushed")
+	}
+}
+
+// discardResponseWriter is a minimal http.ResponseWriter that drops everything, so that
+// benchmarks only measure the overhead of the wrapper.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header         { return d.header }
+func (d *discardResponseWriter) Write(b []byte) (int, error) { return io.Discard.Write(b) }
+func (d *discardResponseWriter) WriteHeader(int)             {}
+
+// benchmarkResponseWriter writes a 32 KiB response in 4 KiB chunks through a fresh writer per request.
+func benchmarkResponseWriter(b *testing.B, newWriter func(http.ResponseWriter) http.ResponseWriter) {
+	inner := &discardResponseWriter{header: make(http.Header)}
+	chunk := make([]byte, 4096)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(chunk) * 8))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := newWriter(inner)
+		w.WriteHeader(http.StatusOK)
+		for j := 0; j < 8; j++ {
+			w.Write(chunk)
+		}
+	}
+}
+
+// BenchmarkResponseWriterBuffered measures the writer when the body is buffered.
+func BenchmarkResponseWriterBuffered(b *testing.B) {
+	benchmarkResponseWriter(b, func(w http.ResponseWriter) http.ResponseWriter {
+		return &ResponseWriter{ResponseWriter: w}
+	})
+}
+
+// BenchmarkResponseWriterPassthrough measures the zero-buffering fast path.
+func BenchmarkResponseWriterPassthrough(b *testing.B) {
+	benchmarkResponseWriter(b, func(w http.ResponseWriter) http.ResponseWriter {
+		return &ResponseWriter{ResponseWriter: w, Passthrough: true}
+	})
+}
+
+// TestResponseWriterSpool tests that the body is captured in the spool buffer when one is set.
+func TestResponseWriterSpool(t *testing.T) {
+	inner := httptest.NewRecorder()
+	body := spool.NewBuffer(4, 0, t.TempDir())
+	defer body.Close()
+	rw := &ResponseWriter{ResponseWriter: inner, Spool: body}
+
+	rw.Write([]byte("test "))
+	rw.Write([]byte("body"))
+
+	if rw.Body.Len() != 0 {
+		t.Errorf("Expected empty in-memory buffer, got '%s'", rw.Body.String())
+	}
+	if rw.Truncated || !body.OnDisk() {
+		t.Errorf("Expected the body to spill to disk without truncation")
+	}
+	data, _ := io.ReadAll(body.NewReader())
+	if string(data) != "test body" {
+		t.Errorf("Expected spooled body 'test body', got '%s'", data)
+	}
+}
+
+// TestContentTypes tests the classification of the JSON and XML content types, SOAP included.
+func TestContentTypes(t *testing.T) {
+	if mediaType := MediaType(`Application/SOAP+XML; charset=utf-8; action="urn:GetQuote"`); mediaType != "application/soap+xml" {
+		t.Errorf("Expected media type 'application/soap+xml', got '%s'", mediaType)
+	}
+	if mediaType := MediaType("not a media type;;"); mediaType != "" {
+		t.Errorf("Expected no media type for a malformed value, got '%s'", mediaType)
+	}
+
+	for _, contentType := range []string{"text/xml; charset=utf-8", "application/xml", "application/soap+xml", "application/atom+xml"} {
+		if !IsXML(contentType) || IsJSON(contentType) {
+			t.Errorf("Expected '%s' to be XML only", contentType)
+		}
+	}
+	for _, contentType := range []string{"application/json", "application/problem+json; charset=utf-8"} {
+		if !IsJSON(contentType) || IsXML(contentType) {
+			t.Errorf("Expected '%s' to be JSON only", contentType)
+		}
+	}
+	if IsXML("text/html") || IsJSON("") {
+		t.Error("Expected HTML and empty content types to be neither JSON nor XML")
+	}
+}